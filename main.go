@@ -15,13 +15,18 @@
 package main
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
 	"runtime"
 	"strings"
 	"time"
@@ -29,6 +34,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/eniac/zgrab.v0/zlib"
 	"gopkg.in/eniac/zgrab.v0/ztools/processing"
+	"gopkg.in/eniac/zgrab.v0/ztools/snmp"
 	"gopkg.in/eniac/zgrab.v0/ztools/x509"
 	"gopkg.in/eniac/zgrab.v0/ztools/zlog"
 	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
@@ -37,19 +43,63 @@ import (
 // Command-line flags
 var (
 	outputFileName, inputFileName string
+	errorFileName                 string
+	csvFileName, csvFields        string
 	logFileName, metadataFileName string
 	messageFileName               string
 	interfaceName                 string
+	sourceIPs                     string
+	ipv4Only, ipv6Only            bool
+	proxyURL                      string
 	ehlo                          string
 	portFlag                      uint
 	inputFile, metadataFile       *os.File
 	timeout                       uint
 	tlsVersion                    string
+	tlsMinVersion                 string
 	rootCAFileName                string
+	clientCertFileName            string
+	udpProbeName                  string
+	snmpVersion, snmpCommunities  string
+	clientKeyFileName             string
+	ctLogListFileName             string
 	prometheusAddress             string
 	clientHelloFileName           string
+	tlsProfileName                string
+	cipherSuiteName               string
+	nextProtos                    string
+	bannerProbeUntil              string
+	progressInterval              time.Duration
+	connectRate, prefixRate       float64
+	expandTargets                 bool
+	excludeFileNames              string
+	excludeLogFileName            string
+	targetOptions                 zlib.TargetOptions
 )
 
+// headerFlags collects repeated "Name: Value" arguments
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	pairs := make([]string, 0, len(h))
+	for key, value := range h {
+		pairs = append(pairs, key+": "+value)
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (h headerFlags) Set(header string) error {
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return errors.New("header must be of the form Name: Value")
+	}
+	if strings.ContainsAny(header, "\r\n") {
+		return errors.New("header must not contain CR or LF")
+	}
+	h[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	return nil
+}
+
 // Module configurations
 var (
 	config       zlib.Config
@@ -64,45 +114,117 @@ var (
 func init() {
 
 	flag.StringVar(&outputFileName, "output-file", "-", "Output filename, use - for stdout")
+	flag.StringVar(&errorFileName, "error-file", "", "Write failed grabs to this file instead of the output file, use - for stderr")
+	flag.StringVar(&csvFileName, "csv-file", "", "Also write a CSV row per grab to this file, use - for stdout")
+	flag.StringVar(&csvFields, "csv-fields", strings.Join(zlib.DefaultCSVFields, ","), "Comma-separated paths into the JSON output to write as CSV columns, each optionally followed by |first_line or |port")
+	flag.IntVar(&outputConfig.Options.BufferSize, "output-buffer", processing.DefaultOutputBufferSize, "Bytes of output to buffer before writing")
+	flag.DurationVar(&outputConfig.Options.FlushInterval, "flush-interval", processing.DefaultOutputFlushInterval, "Write buffered output at least this often, negative to only write when the buffer fills")
+	flag.BoolVar(&outputConfig.Options.Gzip, "gzip", false, "Compress the output and error files with gzip")
 	flag.StringVar(&inputFileName, "input-file", "-", "Input filename, use - for stdin")
 	flag.StringVar(&metadataFileName, "metadata-file", "-", "File to record banner-grab metadata, use - for stdout")
 	flag.StringVar(&logFileName, "log-file", "-", "File to log to, use - for stderr")
 	flag.StringVar(&prometheusAddress, "prometheus", "", "Address to use for Prometheus server (e.g. localhost:8080). If empty, Prometheus is disabled.")
 	flag.BoolVar(&config.LookupDomain, "lookup-domain", false, "Input contains only domain names")
-	flag.StringVar(&interfaceName, "interface", "", "Network interface to send on")
+	flag.StringVar(&excludeFileNames, "exclude-file", "", "Comma-separated files of CIDR blocks never to contact")
+	flag.StringVar(&excludeLogFileName, "exclude-log", "", "Log excluded addresses and the rule matching each to this file (requires --exclude-file)")
+	flag.BoolVar(&expandTargets, "expand-targets", false, "Input lines may hold CIDR blocks, first-last address ranges and domains, each optionally with :port")
+	flag.BoolVar(&targetOptions.SkipNetworkBroadcast, "skip-network-broadcast", false, "Leave out the network and broadcast addresses of IPv4 blocks (requires --expand-targets)")
+	flag.IntVar(&targetOptions.MinIPv6Prefix, "min-ipv6-prefix", zlib.DefaultMinIPv6Prefix, "Reject IPv6 blocks larger than this prefix (requires --expand-targets)")
+	flag.BoolVar(&config.Resolve, "resolve", false, "Resolve the input domains and grab their addresses (requires --lookup-domain)")
+	flag.StringVar(&config.Resolver, "resolver", "", "DNS resolver for --resolve, as ip or ip:port (default: first nameserver in /etc/resolv.conf)")
+	flag.StringVar(&config.ResolvePolicy, "resolve-policy", zlib.ResolvePolicyFirst, "Which resolved addresses to grab: first, random or all")
+	flag.StringVar(&interfaceName, "interface", "", "Network interface to send on (Linux only)")
+	flag.BoolVar(&ipv4Only, "ipv4", false, "Only connect over IPv4")
+	flag.BoolVar(&ipv6Only, "ipv6", false, "Only connect over IPv6")
+	flag.StringVar(&proxyURL, "proxy", "", "Tunnel connections through a proxy, socks5://[user:pass@]host:port or http://[user:pass@]host:port")
+	flag.StringVar(&sourceIPs, "source-ip", "", "Comma-separated list of local IPs to connect from, used in turn")
 	flag.UintVar(&portFlag, "port", 80, "Port to grab on")
 	flag.UintVar(&timeout, "timeout", 10, "Set connection timeout in seconds")
 	flag.BoolVar(&config.TLS, "tls", false, "Grab over TLS")
-	flag.StringVar(&tlsVersion, "tls-version", "", "Max TLS version to use (implies --tls)")
+	flag.StringVar(&tlsVersion, "tls-version", "", "Max TLS version to use (implies --tls, default TLSv1.2). TLSv1.3 key shares are NIST curves only, with no X25519 or ChaCha20, so many servers answer with a HelloRetryRequest or fail")
+	flag.StringVar(&tlsMinVersion, "tls-min-version", "", "Min TLS version to use (implies --tls, default SSLv3)")
 	flag.UintVar(&config.Senders, "senders", 1000, "Number of send coroutines to use")
+	flag.Float64Var(&connectRate, "rate", 0, "Max new connections per second across all senders, 0 for no limit")
+	flag.Float64Var(&prefixRate, "prefix-rate", 0, "Max new connections per second to any one /24 (/48 for IPv6), 0 for no limit")
+	flag.DurationVar(&progressInterval, "progress-interval", 0, "Log progress to stderr this often, e.g. 10s, 0 to disable")
+	flag.UintVar(&config.RetryAttempts, "attempts", 1, "Number of attempts at each host when connecting times out or the connection is reset")
+	flag.DurationVar(&config.RetryDelay, "retry-delay", time.Second, "Wait this long before the second attempt")
+	flag.Float64Var(&config.RetryBackoff, "retry-backoff", 2, "Multiply the delay by this after every further attempt")
+	flag.BoolVar(&config.RetryRefused, "retry-refused", false, "Also retry refused connections")
+	flag.DurationVar(&config.RetryBudget, "retry-budget", 0, "Start no further attempt later than this after the first, e.g. 30s (default: no limit)")
 	flag.UintVar(&config.ConnectionsPerHost, "connections-per-host", 1, "Number of times to connect to each host (results in more output)")
 	flag.BoolVar(&config.Banners, "banners", false, "Read banner upon connection creation")
 	flag.StringVar(&messageFileName, "data", "", "Send a message and read response (%s will be replaced with destination IP)")
+	flag.BoolVar(&config.BannerProbe, "banner-probe", false, "Send the --data message, if any, and read the response until --banner-probe-until matches")
+	flag.StringVar(&bannerProbeUntil, "banner-probe-until", "", "Regular expression marking the end of the --banner-probe response (default: read once)")
+	flag.IntVar(&config.BannerProbeMaxBytes, "banner-probe-max-bytes", 1024, "Maximum number of response bytes read by --banner-probe")
+	flag.DurationVar(&config.BannerProbeTimeout, "banner-probe-timeout", 0, "Stop reading the --banner-probe response after this long, e.g. 2s (default: --timeout)")
 	flag.StringVar(&config.HTTP.Endpoint, "http", "", "Send an HTTP request to an endpoint")
 	flag.StringVar(&config.HTTP.Method, "http-method", "GET", "Set HTTP request method type")
+	flag.BoolVar(&config.HTTP.Elasticsearch, "elasticsearch", false, "Send GET / and decode the Elasticsearch cluster name, version and tagline (usually port 9200)")
+	flag.BoolVar(&config.HTTP.CouchDB, "couchdb", false, "Send GET / and decode the CouchDB welcome and version (usually port 5984)")
 	flag.StringVar(&config.HTTP.UserAgent, "http-user-agent", "Mozilla/5.0 zgrab/0.x", "Set a custom HTTP user agent")
 	flag.StringVar(&config.HTTP.ProxyDomain, "http-proxy-domain", "", "Send a CONNECT <domain> first")
 	flag.IntVar(&config.HTTP.MaxSize, "http-max-size", 256, "Max kilobytes to read in response to an HTTP request")
 	flag.IntVar(&config.HTTP.MaxRedirects, "http-max-redirects", 0, "Max number of redirects to follow")
+	flag.BoolVar(&config.HTTP.RedirectSameHost, "http-redirect-same-host", false, "Only follow redirects to the host of the original request")
+	config.HTTP.Headers = make(map[string]string)
+	flag.Var(headerFlags(config.HTTP.Headers), "http-header", "Add a header to HTTP requests as Name: Value, may be repeated; not sent on redirects to another host")
 	flag.BoolVar(&config.TLSExtendedRandom, "tls-extended-random", false, "send extended random extension")
 	flag.BoolVar(&config.SignedCertificateTimestampExt, "signed-certificate-timestamp", true, "request SCTs during TLS handshake")
 
-	flag.StringVar(&config.EHLODomain, "ehlo", "", "Send an EHLO with the specified domain (implies --smtp)")
+	flag.StringVar(&config.EHLODomain, "ehlo", "", "Send an EHLO with the specified domain, falling back to HELO if EHLO is refused (implies --smtp)")
 	flag.BoolVar(&config.SMTPHelp, "smtp-help", false, "Send a SMTP help (implies --smtp)")
+	flag.StringVar(&config.SMTPVrfy, "smtp-vrfy", "", "Send VRFY for the specified user (implies --smtp)")
+	flag.StringVar(&config.SMTPExpn, "smtp-expn", "", "Send EXPN for the specified mailing list (implies --smtp)")
+	flag.BoolVar(&config.SMTPAuth, "smtp-auth", false, "Record AUTH mechanisms offered before and after STARTTLS, without authenticating (implies --smtp)")
 	flag.BoolVar(&config.StartTLS, "starttls", false, "Send STARTTLS before negotiating")
-	flag.BoolVar(&config.SMTP, "smtp", false, "Conform to SMTP when reading responses and sending STARTTLS")
-	flag.BoolVar(&config.IMAP, "imap", false, "Conform to IMAP rules when sending STARTTLS")
-	flag.BoolVar(&config.POP3, "pop3", false, "Conform to POP3 rules when sending STARTTLS")
+	flag.DurationVar(&config.QuitTimeout, "quit-timeout", 0, "Wait up to this long for the reply to SMTP/POP3/FTP QUIT or IMAP LOGOUT before closing, e.g. 500ms")
+	flag.DurationVar(&config.DrainTimeout, "drain-timeout", 0, "Half-close the connection and record what the server sends for up to this long before closing, e.g. 2s, for servers that only flush on FIN")
+	flag.DurationVar(&config.TotalTimeout, "total-timeout", 0, "Give up on a connection after this long across all operations, 0 for no limit")
+	flag.BoolVar(&config.SMTP, "smtp", false, "Conform to SMTP when reading responses and sending STARTTLS; with --tls the banner and EHLO are read inside the tunnel, e.g. on port 465")
+	flag.BoolVar(&config.IMAP, "imap", false, "Conform to IMAP rules when sending STARTTLS; with --tls the banner and capabilities are read inside the tunnel, e.g. on port 993")
+	flag.BoolVar(&config.POP3, "pop3", false, "Conform to POP3 rules when sending STARTTLS; with --tls the banner and capabilities are read inside the tunnel, e.g. on port 995")
+	flag.BoolVar(&config.MailCapabilities, "mail-capabilities", false, "Send IMAP CAPABILITY or POP3 CAPA, and again after STARTTLS or only inside the tunnel with --tls (requires --imap or --pop3)")
 	flag.BoolVar(&config.Modbus, "modbus", false, "Send some modbus data")
+	flag.BoolVar(&config.VNC, "vnc", false, "Read the VNC protocol version and security types")
+	flag.BoolVar(&config.VNCTLS, "vnc-tls", false, "Perform a TLS handshake after --vnc when the server offers VeNCrypt or TLS")
+	flag.BoolVar(&config.RDP, "rdp", false, "Negotiate RDP security, then perform a TLS handshake if the server selects TLS or CredSSP")
+	flag.BoolVar(&config.SMB, "smb", false, "Negotiate SMB dialects and record the selected dialect and signing requirements")
+	flag.BoolVar(&config.NNTP, "nntp", false, "Read the NNTP greeting and send CAPABILITIES and LIST OVERVIEW.FMT")
+	flag.BoolVar(&config.IRC, "irc", false, "Register with an IRC server under a throwaway nick and record the welcome numerics and ISUPPORT tokens")
+	flag.BoolVar(&config.MongoDB, "mongodb", false, "Send MongoDB isMaster and buildInfo commands and record the replies")
+	flag.BoolVar(&config.Memcached, "memcached", false, "Send a memcached stats command and record the statistics")
+	flag.BoolVar(&config.Redis, "redis", false, "Send a Redis INFO command and record the server's version, OS and role")
 	flag.BoolVar(&config.BACNet, "bacnet", false, "Send some BACNet data")
+	flag.StringVar(&udpProbeName, "udp-probe", "", "Send a UDP request and record the replies, one of: "+strings.Join(zlib.UDPProbeNames(), ", "))
+	flag.IntVar(&config.UDPMaxResponses, "udp-max-responses", 1, "Record up to this many reply datagrams to --udp-probe before the timeout")
+	flag.BoolVar(&config.NTP, "ntp", false, "Send an NTP client query over UDP and record the reply")
+	flag.BoolVar(&config.NTPReadVar, "ntp-readvar", false, "Also read the NTP system variables with a mode 6 READVAR query")
+	flag.BoolVar(&config.DNSProbe, "dns-probe", false, "Send a recursive DNS query over UDP, retrying over TCP when truncated, and record whether the server recursed")
+	flag.StringVar(&config.DNSProbeName, "dns-probe-name", "example.com", "Name whose A records --dns-probe asks for")
+	flag.BoolVar(&config.DNSProbeVersion, "dns-probe-version", false, "Also ask the --dns-probe target for its version.bind TXT record")
+	flag.BoolVar(&config.SNMP, "snmp", false, "Send SNMP GetRequests for sysDescr, sysObjectID and sysName over UDP")
+	flag.StringVar(&snmpVersion, "snmp-version", "2c", "SNMP version for --snmp, 1 or 2c")
+	flag.StringVar(&snmpCommunities, "snmp-communities", "public", "Comma separated community strings --snmp tries in order")
+	flag.BoolVar(&config.SIP, "sip", false, "Send a SIP OPTIONS request over UDP and record the response")
+	flag.BoolVar(&config.SIPTCP, "sip-tcp", false, "Send the --sip request over TCP instead of UDP")
+	flag.BoolVar(&config.SSDP, "ssdp", false, "Send a unicast SSDP M-SEARCH over UDP and record the replies")
+	flag.BoolVar(&config.SSDPFetch, "ssdp-fetch", false, "Fetch the UPnP device description from the LOCATION in the --ssdp replies")
+	flag.BoolVar(&config.SSDPFetchAnyHost, "ssdp-fetch-any-host", false, "Let --ssdp-fetch follow a LOCATION on a public address other than the target")
+	flag.BoolVar(&config.DTLS, "dtls", false, "Perform a DTLS handshake over UDP, recording the server's first flight")
+	flag.DurationVar(&config.DTLSRetransmitTimeout, "dtls-retransmit-timeout", ztls.DefaultDTLSRetransmitTimeout, "Wait this long for a DTLS reply before retransmitting, doubled on each retransmission")
+	flag.IntVar(&config.DTLSMaxRetransmits, "dtls-max-retransmits", ztls.DefaultDTLSMaxRetransmits, "Give up on a DTLS handshake after retransmitting a flight this many times")
 	flag.BoolVar(&config.Fox, "fox", false, "Send some Niagara Fox Tunneling data")
 	flag.BoolVar(&config.S7, "s7", false, "Send some Siemens S7 data")
 	flag.BoolVar(&config.NoSNI, "no-sni", false, "Do not send domain name in TLS handshake regardless of whether known")
+	flag.BoolVar(&config.NoOCSPStapling, "no-ocsp-stapling", false, "Do not offer the OCSP status_request extension in the TLS handshake")
 
 	flag.StringVar(&clientHelloFileName, "raw-client-hello", "", "Provide a raw ClientHello to be sent; only the SNI will be rewritten")
+	flag.StringVar(&tlsProfileName, "tls-profile", "", "Shape the ClientHello like chrome, firefox, safari or default, recording the profile and the hello's JA3 (implies --tls, default max version TLSv1.3)")
 
-	flag.BoolVar(&config.ExportsOnly, "export-ciphers", false, "Send only export ciphers")
-	flag.BoolVar(&config.ExportsDHOnly, "export-dhe-ciphers", false, "Send only export DHE ciphers")
+	flag.BoolVar(&config.ExportsOnly, "export-ciphers", false, "Send only export ciphers and record whether one was negotiated (FREAK)")
+	flag.BoolVar(&config.ExportsDHOnly, "export-dhe-ciphers", false, "Send only export DHE ciphers and record whether one was negotiated, with the DH group (Logjam)")
 	flag.BoolVar(&config.DHEOnly, "dhe-ciphers", false, "Send only DHE ciphers (not ECDHE)")
 	flag.BoolVar(&config.ECDHEOnly, "ecdhe-ciphers", false, "Send only ECDHE ciphers (not DHE)")
 
@@ -114,14 +236,37 @@ func init() {
 	flag.BoolVar(&config.SafariOnly, "safari-ciphers", false, "Send Safari Ordered Cipher Suites")
 	flag.BoolVar(&config.SafariNoDHE, "safari-no-dhe-ciphers", false, "Send Safari ciphers minus DHE suites")
 
+	flag.StringVar(&nextProtos, "alpn", "", "Comma-separated list of protocols to offer with ALPN and NPN, e.g. h2,http/1.1")
+	flag.StringVar(&cipherSuiteName, "cipher-suite", "", "Offer a named list of cipher suites: rsa, rc4, dhe, ecdhe, export, rsa-export, dhe-export, chrome, chrome-nodhe, firefox, firefox-nodhe, safari, safari-nodhe")
+
+	flag.BoolVar(&config.SSLv2, "sslv2", false, "Send an SSLv2 CLIENT-HELLO and record the SERVER-HELLO; with --tls, on a second connection")
 	flag.BoolVar(&config.Heartbleed, "heartbleed", false, "Check if server is vulnerable to Heartbleed (implies --tls)")
+	flag.BoolVar(&config.HeartbleedSafe, "heartbleed-safe", false, "Only send a well-formed heartbeat to check the extension is enabled, without probing for Heartbleed")
+	flag.IntVar(&config.HeartbleedSampleSize, "heartbleed-sample-size", 1024, "Max bytes of leaked memory to record from a Heartbleed probe")
+	flag.IntVar(&config.HeartbleedProbes, "heartbleed-probes", 1, "Number of Heartbleed probes to send on the same session")
+	flag.IntVar(&config.HeartbleedPayloadLength, "heartbleed-payload-length", 0x4000, "Payload length each Heartbleed probe claims, at most 65535")
+	flag.DurationVar(&config.HeartbleedDelay, "heartbleed-delay", 0, "Pause between Heartbleed probes")
 
 	flag.BoolVar(&config.GatherSessionTicket, "tls-session-ticket", false, "Send support for TLS Session Tickets and output ticket if presented")
+	flag.BoolVar(&config.TLSRenegotiation, "tls-renegotiation", false, "Attempt a client-initiated renegotiation after the handshake; may stall some servers until the timeout")
+	flag.BoolVar(&config.TLSResumption, "tls-resumption", false, "Check whether a second connection can resume the TLS session")
+	flag.BoolVar(&config.TLSCurves, "tls-curves", false, "Enumerate the ECDHE curves the server supports, one new connection per curve")
+	flag.IntVar(&config.TLSCurvesMaxConnections, "tls-curves-max-connections", 17, "Maximum number of extra connections opened by --tls-curves")
 	flag.BoolVar(&config.ExtendedMasterSecret, "tls-extended-master-secret", false, "Offer RFC 7627 Extended Master Secret extension")
+	flag.BoolVar(&config.CaptureHandshakeBytes, "tls-capture-handshake", false, "Add the raw bytes of the first TLS flight in each direction, up to 64KB, to JSON output")
+	flag.BoolVar(&config.TLSCertificatesOnly, "tls-certificates-only", false, "Close the TLS handshake with a close_notify once the server's certificates have been received, recording it as abandoned rather than failed")
+	flag.BoolVar(&config.TLSFalseStart, "tls-false-start", false, "Send the first --data or --http request before the server's Finished when an ECDHE AEAD suite is negotiated (RFC 7918), recording whether the server aborted")
 	flag.BoolVar(&config.TLSVerbose, "tls-verbose", false, "Add extra TLS information to JSON output (client hello, client KEX, key material, etc)")
 
 	flag.StringVar(&rootCAFileName, "ca-file", "", "List of trusted root certificate authorities in PEM format")
+	flag.StringVar(&clientCertFileName, "client-cert", "", "Client certificate in PEM format, presented to servers that request one")
+	flag.StringVar(&clientKeyFileName, "client-key", "", "Private key for --client-cert in PEM format")
+	flag.StringVar(&ctLogListFileName, "ct-log-list", "", "CT log list in log_list.json format to check SCT signatures against; no list is bundled, so without one every SCT is recorded as unverified")
 	flag.IntVar(&config.GOMAXPROCS, "gomaxprocs", 3, "Set GOMAXPROCS (default 3)")
+	flag.BoolVar(&config.Trace, "trace", false, "Log protocol-level trace messages (bytes sent and received, handshake progress) to the log file")
+	flag.IntVar(&config.MaxReadBytes, "max-read-bytes", 1024*1024, "Max total bytes to read from a single connection, 0 for no limit")
+	flag.StringVar(&config.OperationResponses, "operation-responses", "", "Record bytes received on each operation, encoded as base64 or as utf8 where they are printable text")
+	flag.BoolVar(&config.HashWritePayloads, "hash-write-payloads", false, "Record the length and SHA-256 of payloads sent with --data or --banner-probe on their operation, instead of the payload itself; Heartbleed and HTTP logs are unchanged")
 	flag.BoolVar(&config.FTP, "ftp", false, "Read FTP banners")
 	flag.BoolVar(&config.FTPAuthTLS, "ftp-authtls", false, "Collect FTPS certificates in addition to FTP banners")
 	flag.BoolVar(&config.DNP3, "dnp3", false, "Read DNP3 banners")
@@ -133,10 +278,28 @@ func init() {
 	flag.BoolVar(&config.SSH.NegativeOne, "ssh-negative-one", false, "Set SSH DH kex value to -1 in the selected group")
 	flag.BoolVar(&config.Telnet, "telnet", false, "Read telnet banners")
 	flag.IntVar(&config.TelnetMaxSize, "telnet-max-size", 65536, "Max bytes to read for telnet banner")
+	flag.BoolVar(&config.XMPP, "xmpp", false, "Open an XMPP stream and negotiate STARTTLS")
+	flag.StringVar(&config.XMPPDomain, "xmpp-domain", "", "Domain to send in the XMPP stream header (defaults to the target domain)")
+	flag.BoolVar(&config.LDAP, "ldap", false, "Send an LDAP StartTLS extended request and negotiate TLS")
+	flag.BoolVar(&config.LDAPRootDSE, "ldap-rootdse", false, "Perform an anonymous LDAP search of the rootDSE, after StartTLS if --ldap is given")
+	flag.BoolVar(&config.Kerberos, "kerberos", false, "Send a Kerberos AS-REQ for a nonexistent principal over UDP and record the KDC's error")
+	flag.BoolVar(&config.KerberosTCP, "kerberos-tcp", false, "Send the --kerberos request over TCP instead of UDP")
+	flag.StringVar(&config.KerberosRealm, "kerberos-realm", "", "Realm --kerberos asks for, by default the upper cased domain of the target")
+	flag.BoolVar(&config.Postgres, "postgres", false, "Send a Postgres SSLRequest and negotiate TLS if accepted")
+	flag.BoolVar(&config.PostgresStartup, "postgres-startup", false, "Send a Postgres StartupMessage for a throwaway user and record the authentication request or error")
+	flag.BoolVar(&config.Cassandra, "cassandra", false, "Send a Cassandra OPTIONS request and record the supported CQL versions and compression")
+	flag.BoolVar(&config.AMQP, "amqp", false, "Send an AMQP 0-9-1 protocol header and record the broker's properties and SASL mechanisms")
+	flag.BoolVar(&config.MQTT, "mqtt", false, "Send an anonymous MQTT CONNECT and record whether the broker accepts it (with --tls for port 8883)")
+	flag.BoolVar(&config.MySQL, "mysql", false, "Read and parse the MySQL initial handshake packet")
+	flag.BoolVar(&config.MySQLTLS, "mysql-tls", false, "Upgrade MySQL connections to TLS when the server supports it")
 	flag.StringVar(&config.TLSInvalidDHKeyExchange, "tls-invalid-kex", "", "Send an invalid key exchange value. Options are {0,1,pm1,g3,g5,g7}.")
 
 	// Flags for XSSH scanner
 	flag.BoolVar(&config.XSSH.XSSH, "xssh", false, "Use the x/crypto SSH scanner")
+	flag.BoolVar(&config.XSSH.HostKeys, "xssh-host-keys", false, "Collect the host key for every host key algorithm the server advertises, one new connection per algorithm")
+	flag.IntVar(&config.XSSH.HostKeyMaxConnections, "xssh-host-keys-max-connections", 8, "Maximum number of extra connections opened by --xssh-host-keys")
+	flag.BoolVar(&config.XSSH.AuthProbe, "xssh-auth-probe", false, "After the key exchange, send one \"none\" userauth request and record whether access was granted or which methods may continue")
+	flag.StringVar(&config.XSSH.AuthProbeUser, "xssh-auth-probe-user", "root", "Username sent by --xssh-auth-probe")
 
 	flag.Parse()
 
@@ -150,6 +313,14 @@ func init() {
 		zlog.Fatalf("--connections-per-host must be in the range [0,50]")
 	}
 
+	// Validate retries
+	if config.RetryAttempts < 1 {
+		zlog.Fatal("--attempts must be at least 1")
+	}
+	if config.RetryBackoff < 1 {
+		zlog.Fatal("--retry-backoff must be at least 1")
+	}
+
 	// Validate SSH related flags
 	if config.SSH.SSH {
 		if _, ok := config.SSH.GetClientImplementation(); !ok {
@@ -170,10 +341,97 @@ func init() {
 		}
 	}
 
+	if excludeLogFileName != "" && excludeFileNames == "" {
+		zlog.Fatal("--exclude-log requires usage of --exclude-file")
+	}
+	if excludeFileNames != "" {
+		config.Exclude = new(zlib.ExcludeList)
+		for _, name := range strings.Split(excludeFileNames, ",") {
+			if err := config.Exclude.LoadFile(strings.TrimSpace(name)); err != nil {
+				zlog.Fatalf("Could not load exclude file: %s", err.Error())
+			}
+		}
+		targetOptions.Exclude = config.Exclude
+	}
+	if excludeLogFileName != "" {
+		excludeLog, err := os.Create(excludeLogFileName)
+		if err != nil {
+			zlog.Fatal(err)
+		}
+		config.Exclude.Log = excludeLog
+	}
+	if expandTargets && config.LookupDomain {
+		zlog.Fatal("--expand-targets and --lookup-domain are mutually exclusive")
+	}
+	if targetOptions.SkipNetworkBroadcast && !expandTargets {
+		zlog.Fatal("--skip-network-broadcast requires usage of --expand-targets")
+	}
+	if targetOptions.MinIPv6Prefix < 1 || targetOptions.MinIPv6Prefix > 128 {
+		zlog.Fatalf("Invalid --min-ipv6-prefix %d", targetOptions.MinIPv6Prefix)
+	}
+	if connectRate < 0 || prefixRate < 0 {
+		zlog.Fatal("--rate and --prefix-rate must not be negative")
+	}
+	if connectRate > 0 || prefixRate > 0 {
+		config.ConnectionLimiter = zlib.NewConnectionLimiter(connectRate, prefixRate)
+	}
+	if config.TotalTimeout < 0 {
+		zlog.Fatalf("Invalid --total-timeout %s", config.TotalTimeout)
+	}
+	if config.MaxReadBytes < 0 {
+		zlog.Fatalf("Invalid --max-read-bytes %d", config.MaxReadBytes)
+	}
+	if outputConfig.Options.BufferSize <= 0 {
+		zlog.Fatalf("Invalid --output-buffer %d", outputConfig.Options.BufferSize)
+	}
+	if errorFileName != "" && errorFileName != "-" && errorFileName == outputFileName {
+		zlog.Fatal("--error-file must differ from --output-file")
+	}
+	if csvFileName != "" && (csvFileName == outputFileName || csvFileName == errorFileName) {
+		zlog.Fatal("--csv-file must differ from --output-file and --error-file")
+	}
+	outputConfig.CSVFields = strings.Split(csvFields, ",")
+	for _, field := range outputConfig.CSVFields {
+		if _, err := processing.ParseCSVField(field); err != nil {
+			zlog.Fatal(err)
+		}
+	}
+
+	// Validate operation response encoding
+	switch config.OperationResponses {
+	case "", zlib.ResponseEncodingBase64, zlib.ResponseEncodingUTF8:
+	default:
+		zlog.Fatalf("Invalid --operation-responses %s. Valid options are: base64, utf8.", config.OperationResponses)
+	}
+
 	// Validate HTTP
 	if config.HTTP.Method != "GET" && config.HTTP.Method != "HEAD" {
 		zlog.Fatalf("Bad HTTP Method: %s. Valid options are: GET, HEAD.", config.HTTP.Method)
 	}
+	if config.HTTP.Elasticsearch || config.HTTP.CouchDB {
+		if config.HTTP.Elasticsearch && config.HTTP.CouchDB {
+			zlog.Fatal("--elasticsearch and --couchdb are mutually exclusive")
+		}
+		if config.HTTP.Endpoint != "" || config.HTTP.Method != "GET" {
+			zlog.Fatal("--elasticsearch and --couchdb send their own GET /, and are mutually exclusive with --http and --http-method")
+		}
+		config.HTTP.Endpoint = "/"
+	}
+
+	// Validate banner probe
+	if bannerProbeUntil != "" {
+		if !config.BannerProbe {
+			zlog.Fatal("--banner-probe-until requires usage of --banner-probe")
+		}
+		until, err := regexp.Compile(bannerProbeUntil)
+		if err != nil {
+			zlog.Fatalf("Invalid --banner-probe-until: %s", err.Error())
+		}
+		config.BannerProbeUntil = until
+	}
+	if config.BannerProbeMaxBytes <= 0 {
+		zlog.Fatal("--banner-probe-max-bytes must be positive")
+	}
 
 	// Validate FTP
 	if config.FTP && config.Banners {
@@ -188,42 +446,145 @@ func init() {
 		zlog.Fatal("--telnet and --banners are mutually exclusive")
 	}
 
+	// Validate XMPP
+	if config.XMPP && config.Banners {
+		zlog.Fatal("--xmpp and --banners are mutually exclusive")
+	}
+
+	// Validate LDAP
+	if config.LDAP && config.Banners {
+		zlog.Fatal("--ldap and --banners are mutually exclusive")
+	}
+	if config.LDAPRootDSE && (config.Banners || config.StartTLS) {
+		zlog.Fatal("--ldap-rootdse and --banners or --starttls are mutually exclusive")
+	}
+
+	// Validate Postgres
+	if config.Postgres && config.Banners {
+		zlog.Fatal("--postgres and --banners are mutually exclusive")
+	}
+	if config.PostgresStartup && (config.Banners || config.TLS || config.StartTLS || config.Postgres) {
+		zlog.Fatal("--postgres-startup and --banners, --tls, --starttls or --postgres are mutually exclusive")
+	}
+
+	// Validate Cassandra
+	if config.Cassandra && (config.Banners || config.TLS || config.StartTLS) {
+		zlog.Fatal("--cassandra and --banners, --tls or --starttls are mutually exclusive")
+	}
+
+	// Validate AMQP and MQTT, either of which may run over --tls
+	if config.AMQP && (config.Banners || config.StartTLS) {
+		zlog.Fatal("--amqp and --banners or --starttls are mutually exclusive")
+	}
+	if config.MQTT && (config.Banners || config.StartTLS || config.AMQP) {
+		zlog.Fatal("--mqtt and --banners, --starttls or --amqp are mutually exclusive")
+	}
+
+	// Validate MySQL
+	if config.MySQL && config.Banners {
+		zlog.Fatal("--mysql and --banners are mutually exclusive")
+	}
+	if config.MySQLTLS && !config.MySQL {
+		zlog.Fatal("--mysql-tls requires usage of --mysql")
+	}
+
+	// Validate VNC
+	if config.VNC && (config.Banners || config.TLS || config.StartTLS) {
+		zlog.Fatal("--vnc and --banners, --tls or --starttls are mutually exclusive")
+	}
+	if config.VNCTLS && !config.VNC {
+		zlog.Fatal("--vnc-tls requires usage of --vnc")
+	}
+
+	// Validate RDP
+	if config.RDP && (config.Banners || config.TLS || config.StartTLS || config.VNC) {
+		zlog.Fatal("--rdp and --banners, --tls, --starttls or --vnc are mutually exclusive")
+	}
+
+	// Validate SMB
+	if config.SMB && (config.Banners || config.TLS || config.StartTLS) {
+		zlog.Fatal("--smb and --banners, --tls or --starttls are mutually exclusive")
+	}
+
+	// Validate NNTP and IRC, either of which may run over --tls
+	if config.NNTP && (config.Banners || config.StartTLS) {
+		zlog.Fatal("--nntp and --banners or --starttls are mutually exclusive")
+	}
+	if config.IRC && (config.Banners || config.StartTLS || config.NNTP) {
+		zlog.Fatal("--irc and --banners, --starttls or --nntp are mutually exclusive")
+	}
+
 	// Validate TLS Versions
-	tv := strings.ToUpper(tlsVersion)
-	if tv != "" {
+	if tlsVersion != "" || tlsMinVersion != "" || tlsProfileName != "" {
 		config.TLS = true
 	}
 
 	if config.TLS || config.HTTP.MaxRedirects > 0 {
-
-		switch tv {
-		case "SSLV3", "SSLV30", "SSLV3.0":
-			config.TLSVersion = ztls.VersionSSL30
-			tlsVersion = "SSLv3"
-		case "TLSV1", "TLSV10", "TLSV1.0":
-			config.TLSVersion = ztls.VersionTLS10
-			tlsVersion = "TLSv1.0"
-		case "TLSV11", "TLSV1.1":
-			config.TLSVersion = ztls.VersionTLS11
-			tlsVersion = "TLSv1.1"
-		case "", "TLSV12", "TLSV1.2":
-			config.TLSVersion = ztls.VersionTLS12
+		var ok bool
+		if tlsVersion == "" && tlsProfileName != "" {
+			tlsVersion = "TLSv1.3"
+		} else if tlsVersion == "" {
 			tlsVersion = "TLSv1.2"
-		default:
+		}
+		if config.TLSVersion, tlsVersion, ok = parseTLSVersion(tlsVersion); !ok {
 			zlog.Fatal("Invalid SSL/TLS versions")
 		}
+		if tlsMinVersion == "" {
+			tlsMinVersion = "SSLv3"
+		}
+		if config.TLSMinVersion, tlsMinVersion, ok = parseTLSVersion(tlsMinVersion); !ok {
+			zlog.Fatal("Invalid minimum SSL/TLS version")
+		}
+		if config.TLSMinVersion > config.TLSVersion {
+			zlog.Fatalf("--tls-min-version %s is greater than --tls-version %s", tlsMinVersion, tlsVersion)
+		}
+	}
+
+	// Validate named cipher suites
+	if cipherSuiteName != "" {
+		suites, ok := ztls.CipherSuitePresets[strings.ToLower(cipherSuiteName)]
+		if !ok {
+			zlog.Fatalf("Unknown cipher suite list %s", cipherSuiteName)
+		}
+		config.CipherSuites = suites
+	}
+
+	// ALPN protocol names are 1 to 255 bytes
+	if nextProtos != "" {
+		for _, proto := range strings.Split(nextProtos, ",") {
+			if len(proto) == 0 || len(proto) > 255 {
+				zlog.Fatalf("Invalid --alpn protocol %q", proto)
+			}
+			config.NextProtos = append(config.NextProtos, proto)
+		}
 	}
 
 	// STARTTLS cannot be used with TLS
 	if config.StartTLS && config.TLS {
 		zlog.Fatal("Cannot both initiate a TLS and STARTTLS connection")
 	}
+	if config.XMPP && config.TLS {
+		zlog.Fatal("Cannot both initiate a TLS connection and XMPP STARTTLS")
+	}
+	if config.LDAP && config.TLS {
+		zlog.Fatal("Cannot both initiate a TLS connection and LDAP StartTLS")
+	}
+	if config.Postgres && config.TLS {
+		zlog.Fatal("Cannot both initiate a TLS connection and a Postgres SSLRequest")
+	}
+	if config.MySQL && config.TLS {
+		zlog.Fatal("Cannot both initiate a TLS connection and a MySQL handshake")
+	}
 
 	if config.EHLODomain != "" {
 		config.EHLO = true
 	}
 
-	if config.SMTPHelp || config.EHLO {
+	if config.SMTPAuth && (config.StartTLS || config.TLS) {
+		zlog.Fatal("--smtp-auth performs its own STARTTLS and cannot be combined with --starttls or --tls")
+	}
+
+	if config.SMTPHelp || config.SMTPAuth || config.SMTPVrfy != "" || config.SMTPExpn != "" || config.EHLO {
 		config.SMTP = true
 	}
 
@@ -248,6 +609,10 @@ func init() {
 		zlog.Fatal("Cannot send an EHLO when conforming to IMAP or POP3")
 	}
 
+	if config.MailCapabilities && !(config.IMAP || config.POP3) {
+		zlog.Fatal("--mail-capabilities requires usage of --imap or --pop3")
+	}
+
 	if config.SMTP {
 		mailType = "SMTP"
 	} else if config.POP3 {
@@ -256,6 +621,183 @@ func init() {
 		mailType = "IMAP"
 	}
 
+	if config.HeartbleedSafe && !config.Heartbleed {
+		zlog.Fatal("--heartbleed-safe requires usage of --heartbleed")
+	}
+	if config.HeartbleedSampleSize < 0 {
+		zlog.Fatalf("Invalid --heartbleed-sample-size %d", config.HeartbleedSampleSize)
+	}
+	if (config.HeartbleedProbes != 1 || config.HeartbleedDelay != 0) && !config.Heartbleed {
+		zlog.Fatal("--heartbleed-probes and --heartbleed-delay require usage of --heartbleed")
+	}
+	if config.HeartbleedSafe && (config.HeartbleedProbes != 1 || config.HeartbleedDelay != 0) {
+		zlog.Fatal("--heartbleed-safe and --heartbleed-probes are mutually exclusive")
+	}
+	if config.HeartbleedDelay < 0 {
+		zlog.Fatalf("Invalid --heartbleed-delay %s", config.HeartbleedDelay)
+	}
+	if config.HeartbleedProbes < 1 {
+		zlog.Fatalf("Invalid --heartbleed-probes %d", config.HeartbleedProbes)
+	}
+	if config.HeartbleedPayloadLength < 1 || config.HeartbleedPayloadLength > ztls.MaxHeartbeatPayloadLength {
+		zlog.Fatalf("Invalid --heartbleed-payload-length %d", config.HeartbleedPayloadLength)
+	}
+
+	// The second connection goes straight to the TLS handshake
+	if config.TLSResumption && !config.TLS {
+		zlog.Fatal("--tls-resumption requires usage of --tls")
+	}
+
+	// Nothing after the handshake can run on an abandoned handshake
+	if config.TLSCertificatesOnly {
+		if !config.TLS {
+			zlog.Fatal("--tls-certificates-only requires usage of --tls")
+		}
+		if config.Banners || messageFileName != "" || config.Heartbleed || config.TLSResumption || config.TLSRenegotiation || config.TLSCurves || config.HTTP.Endpoint != "" {
+			zlog.Fatal("--tls-certificates-only and --banners, --data, --heartbleed, --tls-resumption, --tls-renegotiation, --tls-curves or --http are mutually exclusive")
+		}
+	}
+
+	if config.SSLv2 && config.StartTLS {
+		zlog.Fatal("Cannot use --sslv2 with --starttls")
+	}
+
+	if config.DTLS && (config.TLS || config.StartTLS || config.Banners) {
+		zlog.Fatal("--dtls and --tls, --starttls or --banners are mutually exclusive")
+	}
+	if udpProbeName != "" {
+		if config.UDPProbe = zlib.UDPProbes[udpProbeName]; config.UDPProbe == nil {
+			zlog.Fatalf("Unknown --udp-probe %s", udpProbeName)
+		}
+		if config.TLS || config.StartTLS || config.Banners || config.DTLS || config.BACNet {
+			zlog.Fatal("--udp-probe and --tls, --starttls, --banners, --dtls or --bacnet are mutually exclusive")
+		}
+	}
+	if config.NTP && (config.TLS || config.StartTLS || config.Banners || config.DTLS || config.BACNet || config.UDPProbe != nil) {
+		zlog.Fatal("--ntp and --tls, --starttls, --banners, --dtls, --bacnet or --udp-probe are mutually exclusive")
+	}
+	if config.NTPReadVar && !config.NTP {
+		zlog.Fatal("--ntp-readvar requires usage of --ntp")
+	}
+	if config.DNSProbe && (config.TLS || config.StartTLS || config.Banners || config.DTLS || config.BACNet || config.UDPProbe != nil || config.NTP) {
+		zlog.Fatal("--dns-probe and --tls, --starttls, --banners, --dtls, --bacnet, --udp-probe or --ntp are mutually exclusive")
+	}
+	if config.DNSProbeVersion && !config.DNSProbe {
+		zlog.Fatal("--dns-probe-version requires usage of --dns-probe")
+	}
+	if config.SNMP {
+		if config.TLS || config.StartTLS || config.Banners || config.DTLS || config.BACNet || config.UDPProbe != nil || config.NTP || config.DNSProbe {
+			zlog.Fatal("--snmp and --tls, --starttls, --banners, --dtls, --bacnet, --udp-probe, --ntp or --dns-probe are mutually exclusive")
+		}
+		switch snmpVersion {
+		case "1":
+			config.SNMPVersion = snmp.Version1
+		case "2c":
+			config.SNMPVersion = snmp.Version2c
+		default:
+			zlog.Fatalf("Invalid --snmp-version %s", snmpVersion)
+		}
+		for _, community := range strings.Split(snmpCommunities, ",") {
+			if community == "" {
+				zlog.Fatalf("Invalid --snmp-communities %q", snmpCommunities)
+			}
+			config.SNMPCommunities = append(config.SNMPCommunities, community)
+		}
+	}
+	if config.SIP && (config.TLS || config.StartTLS || config.Banners || config.DTLS || config.BACNet || config.UDPProbe != nil || config.NTP || config.DNSProbe || config.SNMP) {
+		zlog.Fatal("--sip and --tls, --starttls, --banners, --dtls, --bacnet, --udp-probe, --ntp, --dns-probe or --snmp are mutually exclusive")
+	}
+	if config.SIPTCP && !config.SIP {
+		zlog.Fatal("--sip-tcp requires usage of --sip")
+	}
+	if config.SSDP && (config.TLS || config.StartTLS || config.Banners || config.DTLS || config.BACNet || config.UDPProbe != nil || config.NTP || config.DNSProbe || config.SNMP || config.SIP) {
+		zlog.Fatal("--ssdp and --tls, --starttls, --banners, --dtls, --bacnet, --udp-probe, --ntp, --dns-probe, --snmp or --sip are mutually exclusive")
+	}
+	if config.Kerberos && (config.TLS || config.StartTLS || config.Banners || config.DTLS || config.BACNet || config.UDPProbe != nil || config.NTP || config.DNSProbe || config.SNMP || config.SIP || config.SSDP) {
+		zlog.Fatal("--kerberos and --tls, --starttls, --banners, --dtls, --bacnet, --udp-probe, --ntp, --dns-probe, --snmp, --sip or --ssdp are mutually exclusive")
+	}
+	if config.KerberosTCP && !config.Kerberos {
+		zlog.Fatal("--kerberos-tcp requires usage of --kerberos")
+	}
+	if config.KerberosRealm != "" && !config.Kerberos {
+		zlog.Fatal("--kerberos-realm requires usage of --kerberos")
+	}
+	if config.SSDPFetch && !config.SSDP {
+		zlog.Fatal("--ssdp-fetch requires usage of --ssdp")
+	}
+	if config.SSDPFetchAnyHost && !config.SSDPFetch {
+		zlog.Fatal("--ssdp-fetch-any-host requires usage of --ssdp-fetch")
+	}
+	if config.UDPMaxResponses < 1 {
+		zlog.Fatalf("Invalid --udp-max-responses %d", config.UDPMaxResponses)
+	}
+	if config.DTLSRetransmitTimeout <= 0 {
+		zlog.Fatalf("Invalid --dtls-retransmit-timeout %s", config.DTLSRetransmitTimeout)
+	}
+	if config.DTLSMaxRetransmits < 0 {
+		zlog.Fatalf("Invalid --dtls-max-retransmits %d", config.DTLSMaxRetransmits)
+	}
+
+	// Like resumption, each connection goes straight to the TLS handshake
+	if config.TLSCurves && !config.TLS {
+		zlog.Fatal("--tls-curves requires usage of --tls")
+	}
+	if config.TLSCurvesMaxConnections <= 0 {
+		zlog.Fatalf("Invalid --tls-curves-max-connections %d", config.TLSCurvesMaxConnections)
+	}
+
+	if config.XSSH.HostKeys && !config.XSSH.XSSH {
+		zlog.Fatal("--xssh-host-keys requires usage of --xssh")
+	}
+	if config.XSSH.AuthProbe && !config.XSSH.XSSH {
+		zlog.Fatal("--xssh-auth-probe requires usage of --xssh")
+	}
+	if config.XSSH.HostKeyMaxConnections <= 0 {
+		zlog.Fatalf("Invalid --xssh-host-keys-max-connections %d", config.XSSH.HostKeyMaxConnections)
+	}
+
+	if config.TLSRenegotiation && !(config.StartTLS || config.TLS) {
+		zlog.Fatal("Must specify one of --tls or --starttls for --tls-renegotiation")
+	}
+
+	if config.TLSFalseStart && !(config.StartTLS || config.TLS) {
+		zlog.Fatal("Must specify one of --tls or --starttls for --tls-false-start")
+	}
+	// Without a request the handshake log would end before the server's
+	// Finished is read
+	if config.TLSFalseStart && messageFileName == "" && config.HTTP.Endpoint == "" {
+		zlog.Fatal("Must specify one of --data or --http for --tls-false-start")
+	}
+	// Resumption offers a cached session, which is never false started, and
+	// heartbeats and renegotiation need the server's Finished read first
+	if config.TLSFalseStart && (config.TLSResumption || config.Heartbleed || config.TLSRenegotiation) {
+		zlog.Fatal("--tls-false-start and --tls-resumption, --heartbleed or --tls-renegotiation are mutually exclusive")
+	}
+
+	if tlsProfileName != "" {
+		if clientHelloFileName != "" {
+			zlog.Fatal("--tls-profile and --raw-client-hello are mutually exclusive")
+		}
+		var ok bool
+		if config.TLSClientHelloProfile, ok = ztls.ClientHelloProfileByName(tlsProfileName); !ok {
+			zlog.Fatalf("Unknown --tls-profile %s", tlsProfileName)
+		}
+		// A profile with an extension list sends only those extensions, so
+		// flags asking for others would be silently ignored
+		if config.TLSClientHelloProfile.Extensions != nil {
+			if config.Heartbleed {
+				zlog.Fatalf("--tls-profile %s sends no heartbeat extension and cannot be used with --heartbleed", tlsProfileName)
+			}
+			sctSet := false
+			flag.Visit(func(f *flag.Flag) {
+				sctSet = sctSet || f.Name == "signed-certificate-timestamp"
+			})
+			if config.TLSExtendedRandom || config.ExtendedMasterSecret || sctSet {
+				zlog.Fatalf("--tls-profile %s decides its own extensions and cannot be used with --tls-extended-random, --tls-extended-master-secret or --signed-certificate-timestamp", tlsProfileName)
+			}
+		}
+	}
+
 	// Heartbleed requires STARTTLS or TLS
 	if config.Heartbleed && !(config.StartTLS || config.TLS) {
 		zlog.Fatal("Must specify one of --tls or --starttls for --heartbleed")
@@ -277,6 +819,86 @@ func init() {
 
 	// Check the network interface
 	var err error
+	if interfaceName != "" {
+		if _, err = net.InterfaceByName(interfaceName); err != nil {
+			zlog.Fatalf("Invalid --interface %s: %s", interfaceName, err.Error())
+		}
+		config.Interface = interfaceName
+	}
+
+	// Validate address family
+	if ipv4Only && ipv6Only {
+		zlog.Fatal("--ipv4 and --ipv6 are mutually exclusive")
+	}
+	if ipv4Only {
+		config.AddressFamily = "4"
+	} else if ipv6Only {
+		config.AddressFamily = "6"
+	}
+
+	// Validate DNS resolution
+	if config.Resolve {
+		if !config.LookupDomain {
+			zlog.Fatal("--resolve requires usage of --lookup-domain")
+		}
+		switch config.ResolvePolicy {
+		case zlib.ResolvePolicyFirst, zlib.ResolvePolicyRandom, zlib.ResolvePolicyAll:
+		default:
+			zlog.Fatalf("Invalid --resolve-policy %s", config.ResolvePolicy)
+		}
+		if config.Resolver == "" {
+			config.Resolver = zlib.SystemResolver()
+		} else if _, _, err := net.SplitHostPort(config.Resolver); err != nil {
+			config.Resolver = net.JoinHostPort(config.Resolver, "53")
+		}
+	} else if config.Resolver != "" {
+		zlog.Fatal("--resolver requires usage of --resolve")
+	}
+
+	// Validate the proxy
+	if proxyURL != "" {
+		if config.BACNet {
+			zlog.Fatal("--proxy and --bacnet are mutually exclusive")
+		}
+		if config.DTLS {
+			zlog.Fatal("--proxy and --dtls are mutually exclusive")
+		}
+		if config.UDPProbe != nil {
+			zlog.Fatal("--proxy and --udp-probe are mutually exclusive")
+		}
+		if config.NTP {
+			zlog.Fatal("--proxy and --ntp are mutually exclusive")
+		}
+		if config.DNSProbe {
+			zlog.Fatal("--proxy and --dns-probe are mutually exclusive")
+		}
+		if config.SNMP {
+			zlog.Fatal("--proxy and --snmp are mutually exclusive")
+		}
+		if config.SIP && !config.SIPTCP {
+			zlog.Fatal("--proxy requires usage of --sip-tcp with --sip")
+		}
+		if config.SSDP {
+			zlog.Fatal("--proxy and --ssdp are mutually exclusive")
+		}
+		if config.Kerberos && !config.KerberosTCP {
+			zlog.Fatal("--proxy requires usage of --kerberos-tcp with --kerberos")
+		}
+		if config.Proxy, err = zlib.ParseProxyURL(proxyURL); err != nil {
+			zlog.Fatalf("Invalid --proxy: %s", err.Error())
+		}
+	}
+
+	// Validate source IPs
+	if sourceIPs != "" {
+		for _, s := range strings.Split(sourceIPs, ",") {
+			ip := net.ParseIP(strings.TrimSpace(s))
+			if ip == nil {
+				zlog.Fatalf("Invalid --source-ip %s", s)
+			}
+			config.SourceIPs = append(config.SourceIPs, ip)
+		}
+	}
 
 	// Look at CA file
 	if rootCAFileName != "" {
@@ -295,6 +917,29 @@ func init() {
 		}
 	}
 
+	// Load the client certificate
+	if (clientCertFileName == "") != (clientKeyFileName == "") {
+		zlog.Fatal("--client-cert and --client-key must be used together")
+	}
+	if clientCertFileName != "" {
+		cert, err := ztls.LoadX509KeyPair(clientCertFileName, clientKeyFileName)
+		if err != nil {
+			zlog.Fatalf("Could not load client certificate: %s", err.Error())
+		}
+		config.ClientCertificate = &cert
+	}
+
+	// Look at CT log list
+	if ctLogListFileName != "" {
+		logBytes, readErr := ioutil.ReadFile(ctLogListFileName)
+		if readErr != nil {
+			zlog.Fatal(readErr)
+		}
+		if config.CTLogs, err = ztls.ParseCTLogList(logBytes); err != nil {
+			zlog.Fatalf("Could not read CT log list: %s", err.Error())
+		}
+	}
+
 	// Open input and output files
 	switch inputFileName {
 	case "-":
@@ -314,6 +959,26 @@ func init() {
 		}
 	}
 
+	switch csvFileName {
+	case "":
+	case "-":
+		outputConfig.CSVFile = os.Stdout
+	default:
+		if outputConfig.CSVFile, err = os.Create(csvFileName); err != nil {
+			zlog.Fatal(err)
+		}
+	}
+
+	switch errorFileName {
+	case "":
+	case "-":
+		outputConfig.ErrorFile = os.Stderr
+	default:
+		if outputConfig.ErrorFile, err = os.Create(errorFileName); err != nil {
+			zlog.Fatal(err)
+		}
+	}
+
 	// Open message file, if applicable
 	if messageFileName != "" {
 		if messageFile, err := os.Open(messageFileName); err != nil {
@@ -361,6 +1026,45 @@ func init() {
 	}
 }
 
+// parseTLSVersion maps a user-supplied version name to its ztls constant and
+// canonical name
+func parseTLSVersion(name string) (uint16, string, bool) {
+	switch strings.ToUpper(name) {
+	case "SSLV3", "SSLV30", "SSLV3.0":
+		return ztls.VersionSSL30, "SSLv3", true
+	case "TLSV1", "TLSV10", "TLSV1.0":
+		return ztls.VersionTLS10, "TLSv1.0", true
+	case "TLSV11", "TLSV1.1":
+		return ztls.VersionTLS11, "TLSv1.1", true
+	case "TLSV12", "TLSV1.2":
+		return ztls.VersionTLS12, "TLSv1.2", true
+	case "TLSV13", "TLSV1.3":
+		return ztls.VersionTLS13, "TLSv1.3", true
+	}
+	return 0, name, false
+}
+
+// countTargets counts the lines of a regular input file and rewinds it, or
+// returns zero when the input cannot be reread
+func countTargets(f *os.File) uint {
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	buf := make([]byte, 64*1024)
+	var lines uint
+	for {
+		n, err := f.Read(buf)
+		lines += uint(bytes.Count(buf[:n], []byte("\n")))
+		if err != nil {
+			break
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		zlog.Fatal(err)
+	}
+	return lines
+}
+
 func main() {
 	runtime.GOMAXPROCS(config.GOMAXPROCS)
 	if prometheusAddress != "" {
@@ -372,11 +1076,47 @@ func main() {
 		}()
 	}
 
-	decoder := zlib.NewGrabTargetDecoder(inputFile, config.LookupDomain)
-	marshaler := zlib.NewGrabMarshaler()
+	var total uint
+	if progressInterval > 0 && !config.Resolve && !expandTargets {
+		total = countTargets(inputFile) * config.ConnectionsPerHost
+	}
+	var decoder processing.Decoder
+	if expandTargets {
+		decoder = zlib.NewTargetReader(inputFile, targetOptions)
+	} else {
+		decoder = zlib.NewGrabTargetDecoder(inputFile, config.LookupDomain)
+	}
+	output, err := zlib.NewOutputWriter(&outputConfig)
+	if err != nil {
+		zlog.Fatal(err)
+	}
 	worker := zlib.NewGrabWorker(&config)
+
+	// Stop reading targets on the first interrupt and let the grabs in
+	// flight finish. A second interrupt kills the process.
+	stop := make(chan struct{})
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		if _, ok := <-interrupts; ok {
+			signal.Stop(interrupts)
+			zlog.Info("Interrupted, finishing grabs in flight")
+			close(stop)
+		}
+	}()
+
 	start := time.Now()
-	processing.Process(decoder, outputConfig.OutputFile, worker, marshaler, config.Senders)
+	stopProgress := func() {}
+	if progressInterval > 0 {
+		stopProgress = processing.ReportProgress(os.Stderr, worker, total, progressInterval)
+	}
+	processing.ProcessUntil(decoder, output, worker, config.Senders, stop)
+	signal.Stop(interrupts)
+	close(interrupts)
+	stopProgress()
+	if err := output.Close(); err != nil {
+		zlog.Fatalf("Unable to write output: %s", err.Error())
+	}
 	end := time.Now()
 	s := Summary{
 		Port:       config.Port,
@@ -393,6 +1133,14 @@ func main() {
 		SNISupport: !config.NoSNI,
 		Flags:      os.Args,
 	}
+	select {
+	case <-stop:
+		s.Interrupted = true
+	default:
+	}
+	if config.Exclude != nil {
+		s.Excluded = config.Exclude.Excluded()
+	}
 	enc := json.NewEncoder(metadataFile)
 	if err := enc.Encode(&s); err != nil {
 		config.ErrorLog.Errorf("Unable to write summary: %s", err.Error())
@@ -34,23 +34,31 @@ type Summary struct {
 	CAFile     string
 	SNISupport bool
 	Flags      []string
+
+	// Set when an interrupt stopped the scan before the input ran out
+	Interrupted bool
+
+	// Addresses left out or refused because of the exclude list
+	Excluded uint
 }
 
 type encodedSummary struct {
-	Port       uint16        `json:"port"`
-	Success    uint          `json:"success_count"`
-	Failure    uint          `json:"failure_count"`
-	Total      uint          `json:"total"`
-	StartTime  string        `json:"start_time"`
-	EndTime    string        `json:"end_time"`
-	Duration   time.Duration `json:"duration"`
-	Senders    uint          `json:"senders"`
-	Timeout    uint          `json:"timeout"`
-	TLSVersion *string       `json:"tls_version"`
-	MailType   *string       `json:"mail_type"`
-	CAFile     *string       `json:"ca_file_name"`
-	SNISupport bool          `json:"sni_support"`
-	Flags      []string      `json:"flags"`
+	Port        uint16        `json:"port"`
+	Success     uint          `json:"success_count"`
+	Failure     uint          `json:"failure_count"`
+	Total       uint          `json:"total"`
+	StartTime   string        `json:"start_time"`
+	EndTime     string        `json:"end_time"`
+	Duration    time.Duration `json:"duration"`
+	Senders     uint          `json:"senders"`
+	Timeout     uint          `json:"timeout"`
+	TLSVersion  *string       `json:"tls_version"`
+	MailType    *string       `json:"mail_type"`
+	CAFile      *string       `json:"ca_file_name"`
+	SNISupport  bool          `json:"sni_support"`
+	Flags       []string      `json:"flags"`
+	Interrupted bool          `json:"interrupted,omitempty"`
+	Excluded    uint          `json:"excluded_count,omitempty"`
 }
 
 func (s *Summary) MarshalJSON() ([]byte, error) {
@@ -66,6 +74,8 @@ func (s *Summary) MarshalJSON() ([]byte, error) {
 	e.Timeout = uint(s.Timeout / time.Second)
 	e.SNISupport = s.SNISupport
 	e.Flags = s.Flags
+	e.Interrupted = s.Interrupted
+	e.Excluded = s.Excluded
 	if s.TLSVersion != "" {
 		e.TLSVersion = &s.TLSVersion
 	}
@@ -97,6 +107,8 @@ func (s *Summary) UnmarshalJSON(b []byte) error {
 	s.Duration = s.EndTime.Sub(s.StartTime)
 	s.Senders = e.Senders
 	s.Timeout = time.Duration(e.Timeout) * time.Second
+	s.Interrupted = e.Interrupted
+	s.Excluded = e.Excluded
 	if e.TLSVersion != nil {
 		s.TLSVersion = *e.TLSVersion
 	}
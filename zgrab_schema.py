@@ -172,6 +172,7 @@ zgrab_lint = SubRecord({})
 zgrab_certificate = SubRecord({
     "raw":Binary(),
     "parsed":zgrab_parsed_certificate,
+    "parse_error":String(),
     "validation":SubRecord({
         "nss":zgrab_certificate_trust,
         "apple":zgrab_certificate_trust,
@@ -189,10 +190,51 @@ zgrab_server_certificate_valid = SubRecord({
     "error":String()
 })
 
+zgrab_curve_id = SubRecord({
+    "name":String(),
+    "id":Integer(),
+})
+
+zgrab_tls_key_share = SubRecord({
+    "group":zgrab_curve_id,
+    "key":Binary(),
+})
+
+# JA3, JA3S and HASSH fingerprints
+zgrab_fingerprint = SubRecord({
+    "raw":String(),
+    "md5":String(),
+})
+
 zgrab_tls = SubRecord({
     "client_hello":SubRecord({
         "random":Binary(),
         "extended_random":Binary(),
+        "server_name":String(),
+        "cipher_suites":ListOf(SubRecord({
+            "hex":String(),
+            "name":String(),
+            "value":Integer(),
+        })),
+        "alpn_protocols":ListOf(String()),
+        "heartbeat":Boolean(),
+        "heartbeat_mode":String(),
+        "supported_versions":ListOf(SubRecord({
+            "name":String(),
+            "value":Integer()
+        })),
+        "key_shares":ListOf(zgrab_tls_key_share),
+        "profile":String(),
+        "ja3":zgrab_fingerprint,
+    }),
+    "hello_retry_request":SubRecord({
+        "cipher_suite":SubRecord({
+            "hex":String(),
+            "name":String(),
+            "value":Integer(),
+        }),
+        "selected_group":zgrab_curve_id,
+        "cookie":Binary(),
     }),
     "server_hello":SubRecord({
         "version":SubRecord({
@@ -222,12 +264,61 @@ zgrab_tls = SubRecord({
                  }),
                 "raw":Binary()
             })),
+        "alpn_protocol":String(),
+        "next_protocol_negotiation":Boolean(),
+        "next_protocols":ListOf(String()),
+        "heartbeat_mode":String(),
+        "supported_version":SubRecord({
+            "name":String(),
+            "value":Integer()
+        }),
+        "key_share":zgrab_tls_key_share,
+        "extensions":ListOf(Integer()),
+    }),
+    "encrypted_extensions":SubRecord({
+        "alpn_protocol":String(),
+        "server_name_ack":Boolean(),
+        "supported_groups":ListOf(zgrab_curve_id),
+        "extensions":ListOf(Unsigned16BitInteger()),
+    }),
+    "signed_certificate_timestamps":ListOf(SubRecord({
+        "source":String(),
+        "version":Integer(),
+        "log_id":IndexedBinary(),
+        "log_description":String(),
+        "timestamp":Signed64BitInteger(),
+        # valid, invalid, unverified (no --ct-log-list), unknown_log or error
+        "signature_status":String(),
+        "error":String(),
+        "error_class":String(),
+    })),
+    "raw_client_flight":Binary(),
+    "raw_server_flight":Binary(),
+    "raw_flight_truncated":Boolean(),
+    "ocsp_staple":SubRecord({
+        "raw":Binary(),
+        "parse_status":String(),
+        "parse_error":String(),
+        "response_status":String(),
+        "cert_status":String(),
+        "produced_at":DateTime(),
+        "this_update":DateTime(),
+        "next_update":DateTime(),
+        "revoked_at":DateTime(),
+        "responder_name":String(),
+        "responder_key_hash":Binary(),
     }),
     "server_certificates":SubRecord({
         "certificate":zgrab_certificate,
         "chain":ListOf(zgrab_certificate),
         "validation":SubRecord({
+            "browser_trusted":Boolean(),
+            "browser_error":String(),
             "matches_domain":Boolean(),
+            "hostname_skipped":Boolean(),
+            "error_type":String(),
+            "anchor_subject_dn":String(),
+            "anchor_fingerprint_sha256":Binary(),
             "stores":SubRecord({
                 "nss":zgrab_server_certificate_valid,
                 "microsoft":zgrab_server_certificate_valid,
@@ -306,6 +397,12 @@ zgrab_tls = SubRecord({
             "length":Integer()
         }),
     }),
+    "certificate_request":SubRecord({
+        "certificate_types":ListOf(String()),
+        "certificate_authorities":ListOf(String()),
+        "client_certificate_sent":Boolean(),
+        "client_certificate_accepted":Boolean(),
+    }),
     "client_finished":SubRecord({
         "verify_data":Binary()
     }),
@@ -353,14 +450,134 @@ zgrab_tls = SubRecord({
             "encrypted_pre_master_secret":Binary()
         }),
     }),
+    "negotiated_protocol":String(),
+    "error_category":String(),
+    "alert":SubRecord({
+        "level":Integer(),
+        "description":Integer(),
+        "name":String(),
+    }),
+    "abandoned":Boolean(),
+    "false_start":Boolean(),
+    "false_start_aborted":Boolean(),
+    "ja3s":zgrab_fingerprint,
+})
+
+zgrab_operation = SubRecord({
+    "type":String(),
+    "start":DateTime(),
+    "end":DateTime(),
+    "response":String(),
+    "encoding":String(),
+    "truncated":Boolean(),
+    "in_tls":Boolean(),
+    "seq":Integer(),
+    "length":Integer(),
+    "sha256":String(),
+})
+
+zgrab_connect = SubRecord({
+    "success":Boolean(),
+    "network":String(),
+    "address_family":String(),
+    "local_addr":String(),
+    "remote_addr":String(),
+    "resolved_addrs":ListOf(String()),
+    "device":String(),
+    "duration_ms":Float(),
+    "error":String(),
+    "error_class":String(),
 })
 
 zgrab_base = Record({
-    "ip":IPv4Address(required=True),
+    "ip":IPAddress(required=True),
     "timestamp":DateTime(required=True),
     "domain":String(),
-    "data":SubRecord({}),
+    "data":SubRecord({
+        "operations":ListOf(zgrab_operation),
+        "dns":SubRecord({
+            "domain":String(),
+            "resolver":String(),
+            "policy":String(),
+            "rcode":String(),
+            "queries":ListOf(SubRecord({
+                "type":String(),
+                "rcode":String(),
+                "rtt_ms":Float(),
+                "answers":ListOf(String()),
+                "truncated":Boolean(),
+                "error":String(),
+                "error_class":String(),
+            })),
+            "addrs":ListOf(String()),
+            "chosen":ListOf(String()),
+        }),
+        "connect":zgrab_connect,
+        "summary":SubRecord({
+            "success":Boolean(),
+            "local_addr":String(),
+            "remote_addr":String(),
+            "connect_ms":Float(),
+            "duration_ms":Float(),
+            "bytes_read":Integer(),
+            "bytes_written":Integer(),
+        }),
+        "retries":SubRecord({
+            "attempts":ListOf(SubRecord({
+                "index":Integer(),
+                "timestamp":DateTime(),
+                "connect":zgrab_connect,
+                "error":String(),
+                "error_class":String(),
+                "error_component":String(),
+            })),
+            "final":Integer(),
+        }),
+        "budget_exceeded":SubRecord({
+            "step":String(),
+            "budget_ms":Float(),
+            "elapsed_ms":Float(),
+        }),
+        "connection_closed":SubRecord({
+            "reason":String(),
+            "error":String(),
+            "error_class":String(),
+        }),
+        "proxy":SubRecord({
+            "type":String(),
+            "address":String(),
+            "target":String(),
+            "method":String(),
+            "reply_code":Integer(),
+            "reply":String(),
+            "latency_ms":Float(),
+            "error":String(),
+            "error_class":String(),
+        }),
+        "quit":SubRecord({
+            "command":String(),
+            "response":String(),
+            "error":String(),
+            "error_class":String(),
+        }),
+        "drain":SubRecord({
+            "half_closed":Boolean(),
+            "half_close_unavailable":Boolean(),
+            "response":String(),
+            "server_closed":Boolean(),
+            "error":String(),
+            "error_class":String(),
+        }),
+        "banner_probe":SubRecord({
+            "sent":String(),
+            "response":String(),
+            "stop_reason":String(),
+            "error":String(),
+            "error_class":String(),
+        }),
+    }),
     "error":String(),
+    "error_class":String(),
     "error_component":String()
 })
 
@@ -391,41 +608,657 @@ zgrab_telnet = Record({
 
 zschema.registry.register_schema("zgrab-telnet", zgrab_telnet)
 
+zgrab_xmpp = Record({
+    "data":SubRecord({
+        "xmpp":SubRecord({
+            "stream_features":String(),
+            "starttls":Boolean(),
+            "starttls_resp":String(),
+        }),
+        "tls":zgrab_tls,
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-xmpp", zgrab_xmpp)
+
+zgrab_ldap = Record({
+    "data":SubRecord({
+        "ldap":SubRecord({
+            "starttls_request":Binary(),
+            "starttls_response":Binary(),
+            "result_code":Integer(),
+            "matched_dn":String(),
+            "diagnostic_message":String(),
+            "root_dse":SubRecord({
+                "result_code":Integer(),
+                "diagnostic_message":String(),
+                "naming_contexts":ListOf(String()),
+                "default_naming_context":String(),
+                "supported_ldap_version":ListOf(String()),
+                "supported_sasl_mechanisms":ListOf(String()),
+                "vendor_name":String(),
+                "vendor_version":String(),
+                "attributes":SubRecord({
+                    "supportedExtension":ListOf(String()),
+                    "supportedControl":ListOf(String()),
+                }),
+            }),
+        }),
+        "tls":zgrab_tls,
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-ldap", zgrab_ldap)
+zschema.registry.register_schema("zgrab-ldaps", zgrab_ldap)
+
+zgrab_kerberos = Record({
+    "data":SubRecord({
+        "kerberos":SubRecord({
+            "transport":String(),
+            "realm":String(),
+            "principal":String(),
+            "raw":Binary(),
+            "kdc":Boolean(),
+            "message_type":String(),
+            "error_code":Integer(),
+            "error_name":String(),
+            "server_realm":String(),
+            "server_name":ListOf(String()),
+            "server_time":DateTime(),
+            "error_text":String(),
+        }),
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-kerberos", zgrab_kerberos)
+
+zgrab_postgres = Record({
+    "data":SubRecord({
+        "postgres":SubRecord({
+            "ssl_response":Binary(),
+            "supports_ssl":Boolean(),
+            "other_message":Binary(),
+            "startup":SubRecord({
+                "protocol_version":String(),
+                "rejected_versions":ListOf(String()),
+                "authentication_type":Integer(),
+                "authentication_method":String(),
+                "sasl_mechanisms":ListOf(String()),
+                "negotiated_minor_version":Integer(),
+                "unrecognized_options":ListOf(String()),
+                "parameters":SubRecord({
+                    "server_version":String(),
+                    "server_encoding":String(),
+                    "client_encoding":String(),
+                    "DateStyle":String(),
+                    "TimeZone":String(),
+                    "integer_datetimes":String(),
+                    "standard_conforming_strings":String(),
+                }),
+                "server_version":String(),
+                "error":SubRecord({
+                    "severity":String(),
+                    "code":String(),
+                    "message":String(),
+                    "detail":String(),
+                    "hint":String(),
+                    "file":String(),
+                    "line":String(),
+                    "routine":String(),
+                }),
+            }),
+        }),
+        "tls":zgrab_tls,
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-postgres", zgrab_postgres)
+
+zgrab_cassandra = Record({
+    "data":SubRecord({
+        "cassandra":SubRecord({
+            "protocol_version":Integer(),
+            "rejected_versions":ListOf(Integer()),
+            "cql_versions":ListOf(String()),
+            "compression":ListOf(String()),
+            "protocol_versions":ListOf(String()),
+            "supported":SubRecord({
+                "CQL_VERSION":ListOf(String()),
+                "COMPRESSION":ListOf(String()),
+                "PROTOCOL_VERSIONS":ListOf(String()),
+            }),
+            "error_code":Integer(),
+            "error_message":String(),
+        }),
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-cassandra", zgrab_cassandra)
+
+zgrab_amqp = Record({
+    "data":SubRecord({
+        "amqp":SubRecord({
+            "raw_frame":Binary(),
+            "server_protocol_header":Binary(),
+            "version_major":Integer(),
+            "version_minor":Integer(),
+            "product":String(),
+            "version":String(),
+            "platform":String(),
+            "cluster_name":String(),
+            "copyright":String(),
+            "information":String(),
+            "capabilities":ListOf(String()),
+            "mechanisms":ListOf(String()),
+            "locales":ListOf(String()),
+        }),
+        "tls":zgrab_tls,
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-amqp", zgrab_amqp)
+zschema.registry.register_schema("zgrab-amqps", zgrab_amqp)
+
+zgrab_mqtt = Record({
+    "data":SubRecord({
+        "mqtt":SubRecord({
+            "client_id":String(),
+            "raw_connack":Binary(),
+            "session_present":Boolean(),
+            "return_code":Integer(),
+            "return":String(),
+            "outcome":String(),
+        }),
+        "tls":zgrab_tls,
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-mqtt", zgrab_mqtt)
+zschema.registry.register_schema("zgrab-mqtts", zgrab_mqtt)
+
+zgrab_mysql = Record({
+    "data":SubRecord({
+        "mysql":SubRecord({
+            "protocol_version":Integer(),
+            "server_version":String(),
+            "connection_id":Integer(),
+            "capability_flags":Integer(),
+            "character_set":Integer(),
+            "status_flags":Integer(),
+            "auth_plugin_name":String(),
+            "supports_tls":Boolean(),
+            "error_code":Integer(),
+            "error_message":String(),
+        }),
+        "tls":zgrab_tls,
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-mysql", zgrab_mysql)
+
+zgrab_vnc_security_type = SubRecord({
+    "id":Integer(),
+    "name":String(),
+})
+
+zgrab_vnc = Record({
+    "data":SubRecord({
+        "vnc":SubRecord({
+            "protocol_version":String(),
+            "server_major":Integer(),
+            "server_minor":Integer(),
+            "client_version":String(),
+            "security_types":ListOf(zgrab_vnc_security_type),
+            "connection_failed":Boolean(),
+            "failure_reason":String(),
+            "vencrypt_version":String(),
+            "vencrypt_subtypes":ListOf(zgrab_vnc_security_type),
+            "tls_security_type":zgrab_vnc_security_type,
+        }),
+        "tls":zgrab_tls,
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-vnc", zgrab_vnc)
+
+zgrab_rdp = Record({
+    "data":SubRecord({
+        "rdp":SubRecord({
+            "requested_protocols":ListOf(String()),
+            "standard_security":Boolean(),
+            "selected_protocol":String(),
+            "flags":ListOf(String()),
+            "failure_code":Integer(),
+            "failure":String(),
+            "nla_supported":Boolean(),
+        }),
+        "tls":zgrab_tls,
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-rdp", zgrab_rdp)
+
+zgrab_smb = Record({
+    "data":SubRecord({
+        "smb":SubRecord({
+            "protocol":String(),
+            "smb1_only":Boolean(),
+            "dialect":String(),
+            "dialect_revision":Integer(),
+            "security_mode":Integer(),
+            "signing_enabled":Boolean(),
+            "signing_required":Boolean(),
+            "server_guid":String(),
+            "capabilities":Integer(),
+            "capability_names":ListOf(String()),
+            "system_time":DateTime(),
+            "server_start_time":DateTime(),
+            "time_zone":Integer(),
+        }),
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-smb", zgrab_smb)
+
+zgrab_nntp = Record({
+    "data":SubRecord({
+        "nntp":SubRecord({
+            "greeting":String(),
+            "posting_allowed":Boolean(),
+            "capabilities":String(),
+            "capability_list":ListOf(String()),
+            "implementation":String(),
+            "overview_format":String(),
+            "overview_fields":ListOf(String()),
+        }),
+        "tls":zgrab_tls,
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-nntp", zgrab_nntp)
+zschema.registry.register_schema("zgrab-nntps", zgrab_nntp)
+
+# Only the common ISUPPORT tokens are typed
+zgrab_irc = Record({
+    "data":SubRecord({
+        "irc":SubRecord({
+            "nick":String(),
+            "welcome":String(),
+            "your_host":String(),
+            "created":String(),
+            "server_name":String(),
+            "version":String(),
+            "user_modes":String(),
+            "channel_modes":String(),
+            "network":String(),
+            "isupport":SubRecord({
+                "NETWORK":String(),
+                "CASEMAPPING":String(),
+                "CHANTYPES":String(),
+                "CHANMODES":String(),
+                "PREFIX":String(),
+                "NICKLEN":String(),
+                "CHANNELLEN":String(),
+                "TOPICLEN":String(),
+            }),
+            "error":String(),
+            "error_class":String(),
+            "raw":String(),
+        }),
+        "tls":zgrab_tls,
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-irc", zgrab_irc)
+zschema.registry.register_schema("zgrab-ircs", zgrab_irc)
+
+zgrab_snmp = Record({
+    "data":SubRecord({
+        "snmp":SubRecord({
+            "version":String(),
+            "attempts":ListOf(SubRecord({
+                "community":String(),
+                "outcome":String(),
+                "error_index":Integer(),
+            })),
+            "community":String(),
+            "sys_descr":String(),
+            "sys_object_id":String(),
+            "sys_name":String(),
+        }),
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-snmp", zgrab_snmp)
+
+zgrab_sip = Record({
+    "data":SubRecord({
+        "sip":SubRecord({
+            "transport":String(),
+            "response":SubRecord({
+                "status_code":Integer(),
+                "reason_phrase":String(),
+                "server":String(),
+                "user_agent":String(),
+                "allow":ListOf(String()),
+                "supported":ListOf(String()),
+            }),
+            "provisional":Boolean(),
+            "retransmits":Integer(),
+        }),
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-sip", zgrab_sip)
+
+zgrab_mongodb = Record({
+    "data":SubRecord({
+        "mongodb":SubRecord({
+            "opcode":String(),
+            "legacy_rejected":Boolean(),
+            # The common fields of the isMaster reply
+            "is_master":SubRecord({
+                "ismaster":Boolean(),
+                "secondary":Boolean(),
+                "setName":String(),
+                "hosts":ListOf(String()),
+                "maxBsonObjectSize":Integer(),
+                "maxMessageSizeBytes":Integer(),
+                "maxWriteBatchSize":Integer(),
+                "localTime":DateTime(),
+                "minWireVersion":Integer(),
+                "maxWireVersion":Integer(),
+                "readOnly":Boolean(),
+                "ok":Float(),
+            }),
+            "raw":Binary(),
+            "raw_truncated":Boolean(),
+            "version":String(),
+            "max_wire_version":Integer(),
+            "auth_required":Boolean(),
+            "set_name":String(),
+            "hosts":ListOf(String()),
+        }),
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-mongodb", zgrab_mongodb)
+
+zgrab_resumption = SubRecord({
+    "ticket_issued":Boolean(),
+    "session_id_issued":Boolean(),
+    "resumed":Boolean(),
+    "method":String(),
+    "new_ticket":Boolean(),
+    "error":String(),
+    "error_class":String(),
+})
+
+zgrab_renegotiation = SubRecord({
+    "secure_renegotiation":Boolean(),
+    "result":String(),
+    "alert":String(),
+    "error":String(),
+    "error_class":String(),
+})
+
+zgrab_export_ciphers = SubRecord({
+    "export_rsa_supported":Boolean(),
+    "export_dhe_supported":Boolean(),
+    "dh_prime_bits":Integer(),
+    "dh_prime":Binary(),
+})
+
+zgrab_curves = SubRecord({
+    "supported":ListOf(zgrab_curve_id),
+    "rejected":ListOf(zgrab_curve_id),
+    "preferred":zgrab_curve_id,
+    "untested":ListOf(zgrab_curve_id),
+    "connections":Integer(),
+})
+
+zgrab_sslv2 = SubRecord({
+    "supported":Boolean(),
+    "version":Integer(),
+    "session_id_hit":Boolean(),
+    "certificate_type":Integer(),
+    "certificate":zgrab_certificate,
+    "cipher_specs":ListOf(SubRecord({
+        "hex":String(),
+        "name":String(),
+        "value":Integer(),
+    })),
+    "connection_id":Binary(),
+    "error":String(),
+    "error_class":String(),
+})
+
 zgrab_tls_banner = Record({
     "data":SubRecord({
         "tls":zgrab_tls,
+        "resumption":zgrab_resumption,
+        "renegotiation":zgrab_renegotiation,
+        "export_ciphers":zgrab_export_ciphers,
+        "curves":zgrab_curves,
+        "sslv2":zgrab_sslv2,
     })
 }, extends=zgrab_banner)
-zschema.registry.register_schema("zgrab-imaps", zgrab_tls_banner)
-zschema.registry.register_schema("zgrab-pop3s", zgrab_tls_banner)
 
 zgrab_starttls = Record({
     "data":SubRecord({
         "starttls":String(),
     })
 }, extends=zgrab_tls_banner)
-zschema.registry.register_schema("zgrab-imap", zgrab_starttls)
-zschema.registry.register_schema("zgrab-pop3", zgrab_starttls)
+
+zgrab_imap_capabilities = SubRecord({
+    "capabilities":ListOf(String()),
+    "auth_mechanisms":ListOf(String()),
+    "starttls":Boolean(),
+    "login_disabled":Boolean(),
+    "refused":Boolean(),
+})
+
+zgrab_imap = Record({
+    "data":SubRecord({
+        "imap_capability":SubRecord({
+            "plaintext":zgrab_imap_capabilities,
+            "tls":zgrab_imap_capabilities,
+        }),
+    })
+}, extends=zgrab_starttls)
+zschema.registry.register_schema("zgrab-imap", zgrab_imap)
+zschema.registry.register_schema("zgrab-imaps", zgrab_imap)
+
+zgrab_pop3_capabilities = SubRecord({
+    "capabilities":ListOf(String()),
+    "sasl_mechanisms":ListOf(String()),
+    "stls":Boolean(),
+    "user":Boolean(),
+    "implementation":String(),
+    "refused":Boolean(),
+})
+
+zgrab_pop3 = Record({
+    "data":SubRecord({
+        "pop3_capa":SubRecord({
+            "plaintext":zgrab_pop3_capabilities,
+            "tls":zgrab_pop3_capabilities,
+        }),
+    })
+}, extends=zgrab_starttls)
+zschema.registry.register_schema("zgrab-pop3", zgrab_pop3)
+zschema.registry.register_schema("zgrab-pop3s", zgrab_pop3)
+
+zgrab_smtp_probe = SubRecord({
+    "argument":String(),
+    "code":Integer(),
+    "response":String(),
+    "result":String(),
+})
 
 zgrab_smtp = Record({
     "data":SubRecord({
         "ehlo":String(),
+        "ehlo_extensions":ListOf(SubRecord({
+            "keyword":String(),
+            "parameters":ListOf(String()),
+        })),
+        "helo":String(),
+        "smtp_greeting":String(),
+        "smtp_vrfy":zgrab_smtp_probe,
+        "smtp_expn":zgrab_smtp_probe,
+        "smtp_auth":SubRecord({
+            "plaintext_mechanisms":ListOf(String()),
+            "starttls_offered":Boolean(),
+            "tls_ehlo":String(),
+            "tls_mechanisms":ListOf(String()),
+            "added_after_starttls":ListOf(String()),
+            "removed_after_starttls":ListOf(String()),
+            "plain_over_plaintext":Boolean(),
+        }),
     })
 }, extends=zgrab_starttls)
 zschema.registry.register_schema("zgrab-smtp", zgrab_smtp)
+zschema.registry.register_schema("zgrab-smtps", zgrab_smtp)
 
 
 zgrab_https = Record({
     "data":SubRecord({
-        "tls":zgrab_tls
+        "tls":zgrab_tls,
+        "resumption":zgrab_resumption,
+        "renegotiation":zgrab_renegotiation,
+        "export_ciphers":zgrab_export_ciphers,
+        "curves":zgrab_curves,
+        "sslv2":zgrab_sslv2,
     })
 }, extends=zgrab_base)
 
 zschema.registry.register_schema("zgrab-https", zgrab_https)
 
+zgrab_dtls = Record({
+    "data":SubRecord({
+        "tls":zgrab_tls,
+        "dtls":SubRecord({
+            "hello_verify_request":Boolean(),
+            "cookie_length":Integer(),
+            "retransmits":Integer(),
+        }),
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-dtls", zgrab_dtls)
+
+zgrab_udp = Record({
+    "data":SubRecord({
+        "udp":SubRecord({
+            "probe":String(),
+            "outcome":String(),
+            "responses":ListOf(SubRecord({
+                "data":Binary(),
+                "parse_error":String(),
+                # Fields of every probe's parsed reply, dns-version and ntp
+                "parsed":SubRecord({
+                    "rcode":String(),
+                    "txt":ListOf(String()),
+                    "leap_indicator":Integer(),
+                    "version":Integer(),
+                    "mode":Integer(),
+                    "stratum":Integer(),
+                    "poll":Integer(),
+                    "precision":Integer(),
+                    "root_delay":Float(),
+                    "root_dispersion":Float(),
+                    "reference_id":String(),
+                    "reference_time":DateTime(),
+                    "receive_time":DateTime(),
+                    "transmit_time":DateTime(),
+                    "kiss_code":String(),
+                    "rate_limited":Boolean(),
+                }),
+            })),
+        }),
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-udp", zgrab_udp)
+
+zgrab_ntp = Record({
+    "data":SubRecord({
+        "ntp":SubRecord({
+            "response":SubRecord({
+                "leap_indicator":Integer(),
+                "version":Integer(),
+                "mode":Integer(),
+                "stratum":Integer(),
+                "poll":Integer(),
+                "precision":Integer(),
+                "root_delay":Float(),
+                "root_dispersion":Float(),
+                "reference_id":String(),
+                "reference_time":DateTime(),
+                "receive_time":DateTime(),
+                "transmit_time":DateTime(),
+                "kiss_code":String(),
+                "rate_limited":Boolean(),
+            }),
+            "readvar":SubRecord({
+                "leap_indicator":Integer(),
+                "clock_source":Integer(),
+                "event_count":Integer(),
+                "event_code":Integer(),
+                "variables":String(),
+            }),
+            "readvar_error":String(),
+            "partial":Boolean(),
+        }),
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-ntp", zgrab_ntp)
+
+zgrab_dns_probe = Record({
+    "data":SubRecord({
+        "dns_probe":SubRecord({
+            "name":String(),
+            "rcode":String(),
+            "authoritative":Boolean(),
+            "recursion_available":Boolean(),
+            "recursed":Boolean(),
+            "answers":ListOf(SubRecord({
+                "name":String(),
+                "type":String(),
+                "class":Integer(),
+                "ttl":Integer(),
+                "data":String(),
+            })),
+            "edns_payload_size":Integer(),
+            "truncated":Boolean(),
+            "tcp_fallback":Boolean(),
+            "version":SubRecord({
+                "rcode":String(),
+                "txt":ListOf(String()),
+            }),
+            "version_error":String(),
+        }),
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-dns-probe", zgrab_dns_probe)
+
 zgrab_heartbleed = SubRecord({
     "heartbeat_enabled":Boolean(),
-    "heartbleed_vulnerable":Boolean()
+    "heartbeat_responded":Boolean(),
+    "heartbleed_vulnerable":Boolean(),
+    "leaked_bytes":Integer(),
+    "leaked_sample":Binary(),
+    "probes":ListOf(SubRecord({
+        "claimed_length":Integer(),
+        "leaked_bytes":Integer(),
+        "leaked_sample":Binary(),
+        "error":String(),
+        "error_class":String(),
+    })),
+    "probes_completed":Integer(),
 })
 
 zgrab_https_heartbleed = Record({
@@ -522,6 +1355,7 @@ zgrab_http_response = SubRecord({
     "status_code":Integer(),
     "body":HTML(),
     "body_sha256": Binary(),
+    "body_truncated": Boolean(),
     "headers":zgrab_http_headers,
     "content_length":Integer(),
     "request":zgrab_http_request
@@ -531,13 +1365,78 @@ zgrab_http = Record({
     "data":SubRecord({
       "http":SubRecord({
         "response":zgrab_http_response,
-        "redirect_response_chain":ListOf(zgrab_http_response)
+        "redirect_response_chain":ListOf(zgrab_http_response),
+        "redirect_stop_reason":String(),
       })
     })
 }, extends=zgrab_base)
 
 zschema.registry.register_schema("zgrab-http", zgrab_http)
 
+zgrab_elasticsearch = Record({
+    "data":SubRecord({
+        "elasticsearch":SubRecord({
+            "outcome":String(),
+            "status_code":Integer(),
+            "www_authenticate":String(),
+            "name":String(),
+            "cluster_name":String(),
+            "cluster_uuid":String(),
+            "version":String(),
+            "build_flavor":String(),
+            "lucene_version":String(),
+            "tagline":String(),
+            "body":String(),
+        }),
+    })
+}, extends=zgrab_http)
+
+zschema.registry.register_schema("zgrab-elasticsearch", zgrab_elasticsearch)
+
+zgrab_couchdb = Record({
+    "data":SubRecord({
+        "couchdb":SubRecord({
+            "outcome":String(),
+            "status_code":Integer(),
+            "www_authenticate":String(),
+            "couchdb":String(),
+            "version":String(),
+            "git_sha":String(),
+            "uuid":String(),
+            "features":ListOf(String()),
+            "vendor":String(),
+            "body":String(),
+        }),
+    })
+}, extends=zgrab_http)
+
+zschema.registry.register_schema("zgrab-couchdb", zgrab_couchdb)
+
+zgrab_ssdp = Record({
+    "data":SubRecord({
+        "ssdp":SubRecord({
+            "responses":ListOf(SubRecord({
+                "location":String(),
+                "server":String(),
+                "st":String(),
+                "usn":String(),
+                "private_location":Boolean(),
+                "off_host_location":Boolean(),
+            })),
+            "description_url":String(),
+            "device":SubRecord({
+                "device_type":String(),
+                "friendly_name":String(),
+                "manufacturer":String(),
+                "model_name":String(),
+                "model_number":String(),
+            }),
+        }),
+    })
+}, extends=zgrab_http)
+
+zschema.registry.register_schema("zgrab-ssdp", zgrab_ssdp)
+
 zgrab_http_proxy = Record({
     "data":SubRecord({
       "http":SubRecord({
@@ -570,6 +1469,10 @@ zgrab_bacnet = Record({
             "model_name": AnalyzedString(es_include_raw=True),
             "description": AnalyzedString(es_include_raw=True),
             "location": AnalyzedString(es_include_raw=True),
+            "responses": ListOf(SubRecord({
+                "property_id": Integer(),
+                "raw": Binary(),
+            })),
         }),
     }),
 }, extends=zgrab_base)
@@ -629,12 +1532,64 @@ zgrab_modbus = Record({
                 "exception_function":Integer(),
                 "exception_type":Integer(),
             }),
+            "support":String(),
+            "vendor_name":String(),
+            "product_code":String(),
+            "revision":String(),
+            "holding_registers":ListOf(Integer()),
+            "exchanges":ListOf(SubRecord({
+                "transaction_id":Integer(),
+                "unit_id":Integer(),
+                "function_code":Integer(),
+                "exception":String(),
+                "error":String(),
+                "error_class":String(),
+            })),
         }),
     }),
 }, extends=zgrab_base)
 
 zschema.registry.register_schema("zgrab-modbus", zgrab_modbus)
 
+zgrab_memcached = Record({
+    "data":SubRecord({
+        "memcached":SubRecord({
+            "outcome":String(),
+            # Statistics vary between versions, keep the common ones
+            "stats":SubRecord({
+                "pid":String(),
+                "uptime":String(),
+                "version":String(),
+                "libevent":String(),
+                "pointer_size":String(),
+                "curr_connections":String(),
+                "total_connections":String(),
+                "curr_items":String(),
+            }),
+            "error":String(),
+            "error_class":String(),
+        }),
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-memcached", zgrab_memcached)
+
+zgrab_redis = Record({
+    "data":SubRecord({
+        "redis":SubRecord({
+            "outcome":String(),
+            "version":String(),
+            "os":String(),
+            "role":String(),
+            "connected_clients":Integer(),
+            "error":String(),
+            "error_class":String(),
+        }),
+    })
+}, extends=zgrab_base)
+
+zschema.registry.register_schema("zgrab-redis", zgrab_redis)
+
 zgrab_dnp3 = Record({
     "data":SubRecord({
         "dnp3":SubRecord({
@@ -664,6 +1619,11 @@ zgrab_s7 = Record({
             "module_id":String(),
             "hardware":String(),
             "firmware":String(),
+            "cotp_status":String(),
+            "raw_cotp":Binary(),
+            "raw_setup":Binary(),
+            "raw_module_id":Binary(),
+            "raw_component_id":Binary(),
         }),
     }),
 }, extends=zgrab_base)
@@ -743,31 +1703,121 @@ ed25519_public_key = SubRecord({
     "public_bytes":Binary(),
 })
 
+zgrab_xssh_endpoint_id = SubRecord({
+    "raw":AnalyzedString(),
+    "version":String(),
+    "software":AnalyzedString(),
+    "comment":AnalyzedString(),
+})
+
+zgrab_xssh_kex_init = SubRecord({
+    "cookie": Binary(),
+    "kex_algorithms":ListOf(String()),
+    "host_key_algorithms":ListOf(String()),
+    "client_to_server_ciphers":ListOf(String()),
+    "server_to_client_ciphers":ListOf(String()),
+    "client_to_server_macs":ListOf(String()),
+    "server_to_client_macs":ListOf(String()),
+    "client_to_server_compression":ListOf(String()),
+    "server_to_client_compression":ListOf(String()),
+    "client_to_server_languages":ListOf(String()),
+    "server_to_client_languages":ListOf(String()),
+    "first_kex_follows":Boolean(),
+    "reserved":Short(),
+})
+
+zgrab_xssh_host_key = SubRecord({
+    "raw":Binary(),
+    "algorithm":String(),
+    "fingerprint_sha256":String(),
+    "rsa_public_key":rsa_public_key,
+    "dsa_public_key":dsa_public_key,
+    "ecdsa_public_key":ecdsa_public_key,
+    "ed25519_public_key":ed25519_public_key,
+    "certkey_public_key":SubRecord({
+        "nonce":Binary(),
+        "key":SubRecord({
+            "raw":Binary(),
+            "fingerprint_sha256":String(),
+            "algorithm":String(),
+            "rsa_public_key":rsa_public_key,
+            "dsa_public_key":dsa_public_key,
+            "ecdsa_public_key":ecdsa_public_key,
+            "ed25519_public_key":ed25519_public_key,
+        }),
+        "serial":String(),
+        "cert_type":SubRecord({
+            "id":Integer(),
+            "name":String(),
+        }),
+        "key_id":String(),
+        "valid_principals":ListOf(String()),
+        "validity":SubRecord({
+            "valid_after":DateTime(doc="Timestamp of when certificate is first valid. Timezone is UTC."),
+            "valid_before":DateTime(doc="Timestamp of when certificate expires. Timezone is UTC."),
+            "length":Integer(),
+        }),
+        "reserved":Binary(),
+        "signature_key":SubRecord({
+            "raw":Binary(),
+            "fingerprint_sha256":String(),
+            "algorithm":String(),
+            "rsa_public_key":rsa_public_key,
+            "dsa_public_key":dsa_public_key,
+            "ecdsa_public_key":ecdsa_public_key,
+            "ed25519_public_key":ed25519_public_key,
+        }),
+        "signature":SubRecord({
+            "algorithm":String(),
+            "value":Binary(),
+        }),
+        "parse_error":String(),
+        "extensions":SubRecord({
+            "known":SubRecord({
+                "permit-X11-forwarding":String(),
+                "permit-agent-forwarding":String(),
+                "permit-port-forwarding":String(),
+                "permit-pty":String(),
+                "permit-user-rc":String(),
+            }),
+            "unknown":ListOf(String()),
+        }),
+        "critical_options":SubRecord({
+            "known":SubRecord({
+                "force-command":String(),
+                "source-address":String(),
+            }),
+            "unknown":ListOf(String()),
+        })
+    }),
+})
+
 zgrab_xssh = Record({
     "data":SubRecord({
         "xssh":SubRecord({
-            "server_id":SubRecord({
-                "raw":AnalyzedString(),
-                "version":String(),
-                "software":AnalyzedString(),
-                "comment":AnalyzedString(),
-            }),
-            "server_key_exchange":SubRecord({
-                "cookie": Binary(),
-                "kex_algorithms":ListOf(String()),
-                "host_key_algorithms":ListOf(String()),
-                "client_to_server_ciphers":ListOf(String()),
-                "server_to_client_ciphers":ListOf(String()),
-                "client_to_server_macs":ListOf(String()),
-                "server_to_client_macs":ListOf(String()),
-                "client_to_server_compression":ListOf(String()),
-                "server_to_client_compression":ListOf(String()),
-                "client_to_server_languages":ListOf(String()),
-                "server_to_client_languages":ListOf(String()),
-                "first_kex_follows":Boolean(),
-                "reserved":Short(),
-            }),
+            "server_id":zgrab_xssh_endpoint_id,
+            "client_id":zgrab_xssh_endpoint_id,
+            "server_key_exchange":zgrab_xssh_kex_init,
+            "hassh_server":zgrab_fingerprint,
+            "client_key_exchange":zgrab_xssh_kex_init,
             "userauth":ListOf(String()),
+            "userauth_probe":SubRecord({
+                "user":String(),
+                "accepted":Boolean(),
+                "methods":ListOf(String()),
+            }),
+            "weaknesses":SubRecord({
+                "weak_algorithms":ListOf(SubRecord({
+                    "kind":String(),
+                    "name":String(),
+                    "reason":String(),
+                })),
+                "weak_kex":Boolean(),
+                "weak_host_key":Boolean(),
+                "weak_cipher":Boolean(),
+                "weak_mac":Boolean(),
+                "ssh_v1":Boolean(),
+            }),
             "algorithm_selection":SubRecord({
                 "dh_kex_algorithm":String(),
                 "host_key_algorithm":String(),
@@ -791,73 +1841,19 @@ zgrab_xssh = Record({
                     "generator":Binary(),
                 }),
                 "server_signature":Binary(),
-                "server_host_key":SubRecord({
-                    "raw":Binary(),
-                    "algorithm":String(),
-                    "fingerprint_sha256":String(),
-                    "rsa_public_key":rsa_public_key,
-                    "dsa_public_key":dsa_public_key,
-                    "ecdsa_public_key":ecdsa_public_key,
-                    "ed25519_public_key":ed25519_public_key,
-                    "certkey_public_key":SubRecord({
-                        "nonce":Binary(),
-                        "key":SubRecord({
-                            "raw":Binary(),
-                            "fingerprint_sha256":String(),
-                            "algorithm":String(),
-                            "rsa_public_key":rsa_public_key,
-                            "dsa_public_key":dsa_public_key,
-                            "ecdsa_public_key":ecdsa_public_key,
-                            "ed25519_public_key":ed25519_public_key,
-                        }),
-                        "serial":String(),
-                        "cert_type":SubRecord({
-                            "id":Integer(),
-                            "name":String(),
-                        }),
-                        "key_id":String(),
-                        "valid_principals":ListOf(String()),
-                        "validity":SubRecord({
-                            "valid_after":DateTime(doc="Timestamp of when certificate is first valid. Timezone is UTC."),
-                            "valid_before":DateTime(doc="Timestamp of when certificate expires. Timezone is UTC."),
-                            "length":Integer(),
-                        }),
-                        "reserved":Binary(),
-                        "signature_key":SubRecord({
-                            "raw":Binary(),
-                            "fingerprint_sha256":String(),
-                            "algorithm":String(),
-                            "rsa_public_key":rsa_public_key,
-                            "dsa_public_key":dsa_public_key,
-                            "ecdsa_public_key":ecdsa_public_key,
-                            "ed25519_public_key":ed25519_public_key,
-                        }),
-                        "signature":SubRecord({
-                            "algorithm":String(),
-                            "value":Binary(),
-                        }),
-                        "parse_error":String(),
-                        "extensions":SubRecord({
-                            "known":SubRecord({
-                                "permit-X11-forwarding":String(),
-                                "permit-agent-forwarding":String(),
-                                "permit-port-forwarding":String(),
-                                "permit-pty":String(),
-                                "permit-user-rc":String(),
-                            }),
-                            "unknown":ListOf(String()),
-                        }),
-                        "critical_options":SubRecord({
-                            "known":SubRecord({
-                                "force-command":String(),
-                                "source-address":String(),
-                            }),
-                            "unknown":ListOf(String()),
-                        })
-                    }),
-                }),
+                "server_host_key":zgrab_xssh_host_key,
             }),
         }),
+        "xssh_host_keys":SubRecord({
+            "host_keys":ListOf(SubRecord({
+                "algorithm":String(),
+                "host_key":zgrab_xssh_host_key,
+                "error":String(),
+                "error_class":String(),
+            })),
+            "untested":ListOf(String()),
+            "connections":Integer(),
+        }),
     }),
 }, extends=zgrab_base)
 
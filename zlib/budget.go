@@ -0,0 +1,145 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// budgetError is returned by operations on a Conn once its total timeout
+// has passed. It is a timeout, so callers treating deadlines specially see
+// it as one.
+type budgetError struct{}
+
+func (budgetError) Error() string   { return "total timeout exceeded" }
+func (budgetError) Timeout() bool   { return true }
+func (budgetError) Temporary() bool { return false }
+
+// ErrTotalTimeout is returned by reads and writes on a Conn once the budget
+// set with SetTotalTimeout is spent
+var ErrTotalTimeout net.Error = budgetError{}
+
+// A BudgetExceededState records the step that was in progress when the
+// connection's total timeout ran out
+type BudgetExceededState struct {
+	Step      string  `json:"step"`
+	BudgetMs  float64 `json:"budget_ms"`
+	ElapsedMs float64 `json:"elapsed_ms"`
+}
+
+// budgetConn fails I/O once its deadline has passed and keeps any deadline
+// set on it from extending past that point
+type budgetConn struct {
+	net.Conn
+	start    time.Time
+	deadline time.Time
+	exceeded int32 // set atomically, I/O may run beside the close path
+}
+
+func (bc *budgetConn) Read(b []byte) (int, error) {
+	if bc.spent() {
+		return 0, ErrTotalTimeout
+	}
+	n, err := bc.Conn.Read(b)
+	return n, bc.convert(err)
+}
+
+func (bc *budgetConn) Write(b []byte) (int, error) {
+	if bc.spent() {
+		return 0, ErrTotalTimeout
+	}
+	n, err := bc.Conn.Write(b)
+	return n, bc.convert(err)
+}
+
+func (bc *budgetConn) SetDeadline(t time.Time) error {
+	return bc.Conn.SetDeadline(bc.clamp(t))
+}
+
+func (bc *budgetConn) SetReadDeadline(t time.Time) error {
+	return bc.Conn.SetReadDeadline(bc.clamp(t))
+}
+
+func (bc *budgetConn) SetWriteDeadline(t time.Time) error {
+	return bc.Conn.SetWriteDeadline(bc.clamp(t))
+}
+
+func (bc *budgetConn) spent() bool {
+	if bc.wasExceeded() {
+		return true
+	}
+	if time.Now().Before(bc.deadline) {
+		return false
+	}
+	atomic.StoreInt32(&bc.exceeded, 1)
+	return true
+}
+
+// wasExceeded reports whether I/O has been failed for running out of budget
+func (bc *budgetConn) wasExceeded() bool {
+	return atomic.LoadInt32(&bc.exceeded) != 0
+}
+
+// convert reports a deadline hit at the end of the budget as ErrTotalTimeout
+func (bc *budgetConn) convert(err error) error {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && bc.spent() {
+		return ErrTotalTimeout
+	}
+	return err
+}
+
+func (bc *budgetConn) clamp(t time.Time) time.Time {
+	if t.IsZero() || t.After(bc.deadline) {
+		return bc.deadline
+	}
+	return t
+}
+
+// SetTotalTimeout bounds the time spent on the connection across all
+// operations, starting now. Per-operation deadlines still apply but are cut
+// short at the end of the budget, and once it is spent every further read
+// or write fails with ErrTotalTimeout. It must be called before the TLS
+// handshake.
+func (c *Conn) SetTotalTimeout(d time.Duration) {
+	if d <= 0 || c.conn == nil {
+		return
+	}
+	now := time.Now()
+	c.budget = &budgetConn{Conn: c.conn, start: now, deadline: now.Add(d)}
+	c.conn = c.budget
+	c.conn.SetReadDeadline(c.readDeadline)
+	c.conn.SetWriteDeadline(c.writeDeadline)
+}
+
+// recordBudgetExceeded notes the step during which the total timeout ran
+// out, the first time it is called after that
+func (c *Conn) recordBudgetExceeded(step string) {
+	if c.budget == nil || !c.budget.wasExceeded() || c.grabData.BudgetExceeded != nil {
+		return
+	}
+	now := time.Now()
+	c.grabData.BudgetExceeded = &BudgetExceededState{
+		Step:      step,
+		BudgetMs:  float64(c.budget.deadline.Sub(c.budget.start)) / float64(time.Millisecond),
+		ElapsedMs: float64(now.Sub(c.budget.start)) / float64(time.Millisecond),
+	}
+	c.appendOperation(&Operation{
+		Type:  OperationBudgetExceeded,
+		Start: now,
+		End:   now,
+	})
+}
@@ -0,0 +1,134 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// silentServer accepts connections and never writes to them
+func silentServer(t *testing.T) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				<-done
+				c.Close()
+			}()
+		}
+	}()
+	return l.Addr().String(), func() {
+		close(done)
+		l.Close()
+	}
+}
+
+func TestTotalTimeoutCutsBannerRead(t *testing.T) {
+	addr, stop := silentServer(t)
+	defer stop()
+	config, target := retryConfig(addr)
+	config.RetryAttempts = 1
+	config.Timeout = 5 * time.Second
+	config.TotalTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	grab := GrabBanner(config, target)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Banner read not cut short by the total timeout: took %s", elapsed)
+	}
+	if grab.Error != ErrTotalTimeout || grab.ErrorComponent != "banner" {
+		t.Errorf("Wrong error: %v (component %q)", grab.Error, grab.ErrorComponent)
+	}
+	state := grab.Data.BudgetExceeded
+	if state == nil || state.Step != OperationBanner || state.BudgetMs != 100 || state.ElapsedMs < 100 {
+		t.Fatalf("Wrong budget state: %+v", state)
+	}
+	ops := grab.Data.Operations
+	if op := ops[len(ops)-2]; op.Type != OperationBudgetExceeded {
+		t.Errorf("Wrong operation before close %s", op.Type)
+	}
+	if closed := grab.Data.Closed; closed == nil || closed.Reason != CloseReasonTimeout {
+		t.Errorf("Wrong close state: %+v", closed)
+	}
+}
+
+func TestTotalTimeoutSkipsLaterSteps(t *testing.T) {
+	addr, stop := silentServer(t)
+	defer stop()
+	d := Dialer{Deadline: time.Now().Add(3 * time.Second)}
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Hour))
+	conn.SetTotalTimeout(20 * time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := conn.Write([]byte("EHLO example.com\r\n")); err != ErrTotalTimeout {
+		t.Errorf("Write after the budget was spent returned %v", err)
+	}
+	if _, err := conn.BasicBanner(); err != ErrTotalTimeout {
+		t.Errorf("Read after the budget was spent returned %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Steps after the budget was spent were not skipped: took %s", elapsed)
+	}
+	if state := conn.grabData.BudgetExceeded; state == nil || state.Step != OperationWrite {
+		t.Errorf("Wrong budget state: %+v", state)
+	}
+	exceeded := 0
+	for _, op := range conn.grabData.Operations {
+		if op.Type == OperationBudgetExceeded {
+			exceeded++
+		}
+	}
+	if exceeded != 1 {
+		t.Errorf("Budget exhaustion recorded %d times", exceeded)
+	}
+}
+
+func TestBudgetSpentDuringIO(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	bc := &budgetConn{Conn: client, start: time.Now(), deadline: time.Now().Add(20 * time.Millisecond)}
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+	done := make(chan error)
+	go func() {
+		var err error
+		for err == nil {
+			_, err = bc.Read(make([]byte, 1))
+		}
+		done <- err
+	}()
+	for !bc.wasExceeded() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := <-done; err != ErrTotalTimeout {
+		t.Errorf("Wrong error: %v", err)
+	}
+}
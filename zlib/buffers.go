@@ -0,0 +1,91 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"regexp"
+	"sync"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/util"
+)
+
+// Scratch buffers for the command/response read paths. A response is read
+// into a pooled buffer and only the bytes received are copied out, so
+// nothing recorded in the grab or in an operation aliases pooled memory.
+var readBuffers = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
+// Buffers grown past this by a long response are dropped rather than kept
+// in the pool
+const maxPooledReadBuffer = 64 * 1024
+
+// getReadBuffer returns a pooled buffer of length size. Its contents are
+// left over from earlier reads; only bytes read into it are meaningful.
+func getReadBuffer(size int) *[]byte {
+	p := readBuffers.Get().(*[]byte)
+	if cap(*p) < size {
+		*p = make([]byte, size)
+	}
+	*p = (*p)[:size]
+	return p
+}
+
+// putReadBuffer returns p to the pool, keeping used instead when a read
+// grew it into a larger buffer
+func putReadBuffer(p *[]byte, used []byte) {
+	if cap(used) > cap(*p) {
+		*p = used[:cap(used)]
+	}
+	if cap(*p) > maxPooledReadBuffer {
+		return
+	}
+	readBuffers.Put(p)
+}
+
+// readSMTPBanner, readPOP3Banner and readIMAPBanner read a greeting that
+// was not read with a buffer from the caller, e.g. ahead of STARTTLS
+func (c *Conn) readSMTPBanner() error {
+	p := getReadBuffer(1024)
+	defer putReadBuffer(p, *p)
+	_, err := c.SMTPBanner(*p)
+	return err
+}
+
+func (c *Conn) readPOP3Banner() error {
+	p := getReadBuffer(1024)
+	defer putReadBuffer(p, *p)
+	_, err := c.POP3Banner(*p)
+	return err
+}
+
+func (c *Conn) readIMAPBanner() error {
+	p := getReadBuffer(1024)
+	defer putReadBuffer(p, *p)
+	_, err := c.IMAPBanner(*p)
+	return err
+}
+
+// readPooled reads until expr matches like util.ReadUntilRegexGrowing,
+// starting from a pooled buffer of size bytes, and returns a copy of the
+// response
+func (c *Conn) readPooled(size int, expr *regexp.Regexp, maxLength int) ([]byte, error) {
+	p := getReadBuffer(size)
+	res, err := util.ReadUntilRegexGrowing(c.getUnderlyingConn(), *p, expr, maxLength)
+	out := make([]byte, len(res))
+	copy(out, res)
+	putReadBuffer(p, res)
+	return out, err
+}
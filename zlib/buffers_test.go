@@ -0,0 +1,149 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedConn answers each Read with the next reply in its script, so
+// read-ahead never joins two replies, and discards writes
+type scriptedConn struct {
+	net.Conn
+	replies [][]byte
+	pending []byte
+}
+
+func (s *scriptedConn) Read(b []byte) (int, error) {
+	if len(s.pending) == 0 {
+		if len(s.replies) == 0 {
+			return 0, io.EOF
+		}
+		s.pending, s.replies = s.replies[0], s.replies[1:]
+	}
+	n := copy(b, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *scriptedConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// smtpScript is a banner, a multi-line EHLO reply long enough to grow a
+// 512 byte buffer, and a HELP reply
+func smtpScript() [][]byte {
+	ehlo := "250-mail.example.com Hello\r\n"
+	for i := 0; i < 24; i++ {
+		ehlo += "250-X-EXTENSION-" + strings.Repeat("A", i) + "\r\n"
+	}
+	ehlo += "250 SMTPUTF8\r\n"
+	return [][]byte{
+		[]byte("220 mail.example.com ESMTP ready\r\n"),
+		[]byte(ehlo),
+		[]byte("214 See RFC 5321\r\n"),
+	}
+}
+
+func TestPooledReadsMatchScript(t *testing.T) {
+	script := smtpScript()
+	for round := 0; round < 3; round++ {
+		c := &Conn{conn: &scriptedConn{replies: smtpScript()}}
+		if err := c.readSMTPBanner(); err != nil {
+			t.Fatalf("Banner: %s", err.Error())
+		}
+		if err := c.EHLO("example.com"); err != nil {
+			t.Fatalf("EHLO: %s", err.Error())
+		}
+		if err := c.SMTPHelp(); err != nil {
+			t.Fatalf("HELP: %s", err.Error())
+		}
+		if c.grabData.Banner != string(script[0]) || c.grabData.EHLO != string(script[1]) || c.grabData.SMTPHelp.Response != string(script[2]) {
+			t.Errorf("Round %d recorded %q, %q, %q", round, c.grabData.Banner, c.grabData.EHLO, c.grabData.SMTPHelp.Response)
+		}
+	}
+}
+
+func TestPooledReadIsCopied(t *testing.T) {
+	c := &Conn{conn: &scriptedConn{replies: [][]byte{[]byte("250 OK\r\n")}}}
+	res, err := c.readPooledSmtpResponse(512)
+	if err != nil {
+		t.Fatalf("readPooledSmtpResponse: %s", err.Error())
+	}
+	// Whatever buffer comes out of the pool next may be the one just used
+	p := getReadBuffer(512)
+	for i := range *p {
+		(*p)[i] = 'x'
+	}
+	putReadBuffer(p, *p)
+	if !bytes.Equal(res, []byte("250 OK\r\n")) || cap(res) != len(res) {
+		t.Errorf("Response aliases the pooled buffer: %q", res)
+	}
+}
+
+func TestPutReadBufferKeepsGrownBuffer(t *testing.T) {
+	p := getReadBuffer(512)
+	grown := make([]byte, 1000, 2048)
+	putReadBuffer(p, grown)
+	if cap(*p) != 2048 || len(*p) != 2048 {
+		t.Errorf("Grown buffer not kept: len %d, cap %d", len(*p), cap(*p))
+	}
+	if p = getReadBuffer(512); len(*p) != 512 {
+		t.Errorf("Wrong pooled buffer length %d", len(*p))
+	}
+}
+
+// BenchmarkSMTPExchange reads the replies of smtpScript the way the SMTP
+// commands do, with fresh buffers as before pooling and with pooled ones.
+// The Conn is reset rather than allocated so the buffers dominate.
+func BenchmarkSMTPExchange(b *testing.B) {
+	script := smtpScript()
+	commands := []string{"EHLO example.com\r\n", "HELP\r\n"}
+	b.Run("fresh", func(b *testing.B) {
+		c, sc := new(Conn), new(scriptedConn)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			*sc = scriptedConn{replies: script}
+			*c = Conn{conn: sc}
+			c.SMTPBanner(make([]byte, 1024))
+			for _, cmd := range commands {
+				start := time.Now()
+				c.getUnderlyingConn().Write([]byte(cmd))
+				res, _ := c.readSmtpResponse(make([]byte, 512))
+				c.recordResponse(OperationEHLO, start, res)
+			}
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		c, sc := new(Conn), new(scriptedConn)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			*sc = scriptedConn{replies: script}
+			*c = Conn{conn: sc}
+			c.readSMTPBanner()
+			for _, cmd := range commands {
+				start := time.Now()
+				c.getUnderlyingConn().Write([]byte(cmd))
+				res, _ := c.readPooledSmtpResponse(512)
+				c.recordResponse(OperationEHLO, start, res)
+			}
+		}
+	})
+}
@@ -0,0 +1,111 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+)
+
+// How a connection ended, see ConnectionClosedState
+const (
+	CloseReasonPeerFIN = "peer_fin"
+	CloseReasonPeerRST = "peer_rst"
+	CloseReasonTimeout = "timeout"
+	CloseReasonLocal   = "local_close"
+)
+
+// A ConnectionClosedState records how a connection ended: the peer closing
+// it cleanly or resetting it, an I/O timeout, or the scanner closing it
+// first. Error is the socket error the classification is based on, and
+// ErrorClass its class as returned by zerrors.Classify.
+type ConnectionClosedState struct {
+	Reason     string `json:"reason"`
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+}
+
+// classifyClose maps the last error seen on the socket onto a close reason.
+// A nil error or one not caused by the peer means the connection was closed
+// locally.
+func classifyClose(err error) string {
+	if err == nil {
+		return CloseReasonLocal
+	}
+	if err == io.EOF {
+		return CloseReasonPeerFIN
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return CloseReasonTimeout
+	}
+	switch socketErrno(err) {
+	case syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE:
+		return CloseReasonPeerRST
+	case syscall.ETIMEDOUT:
+		return CloseReasonTimeout
+	}
+	return CloseReasonLocal
+}
+
+// socketErrno unwraps the errno from the *net.OpError and *os.SyscallError
+// the net package returns, or 0 when err carries none
+func socketErrno(err error) syscall.Errno {
+	for {
+		switch e := err.(type) {
+		case syscall.Errno:
+			return e
+		case *net.OpError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		default:
+			return 0
+		}
+	}
+}
+
+// recordClose appends the connection_closed state the first time it is
+// called on a connected Conn
+func (c *Conn) recordClose() {
+	if c.conn == nil || c.grabData.Closed != nil {
+		return
+	}
+	state := &ConnectionClosedState{}
+	var err error
+	if c.counter != nil {
+		err = c.counter.lastErr()
+	}
+	state.Reason = classifyClose(err)
+	if c.budget != nil && c.budget.wasExceeded() {
+		// The budget fails I/O without it ever reaching the socket
+		state.Reason = CloseReasonTimeout
+	}
+	if err != nil {
+		state.Error = err.Error()
+		state.ErrorClass = zerrors.Classify(err)
+	}
+	c.grabData.Closed = state
+	now := time.Now()
+	c.appendOperation(&Operation{
+		Type:  OperationConnectionClosed,
+		Start: now,
+		End:   now,
+	})
+}
@@ -0,0 +1,156 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyClose(t *testing.T) {
+	tests := []struct {
+		err    error
+		reason string
+	}{
+		{nil, CloseReasonLocal},
+		{io.EOF, CloseReasonPeerFIN},
+		{timeoutError{}, CloseReasonTimeout},
+		{&net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, CloseReasonTimeout},
+		{&net.OpError{Op: "read", Net: "tcp", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}}, CloseReasonPeerRST},
+		{&net.OpError{Op: "write", Net: "tcp", Err: &os.SyscallError{Syscall: "write", Err: syscall.EPIPE}}, CloseReasonPeerRST},
+		{&net.OpError{Op: "read", Net: "tcp", Err: net.ErrClosed}, CloseReasonLocal},
+	}
+	for _, test := range tests {
+		if reason := classifyClose(test.err); reason != test.reason {
+			t.Errorf("%v: expected %s, got %s", test.err, test.reason, reason)
+		}
+	}
+}
+
+// closingServer accepts a single connection and hands it to serve
+func closingServer(t *testing.T, serve func(*net.TCPConn)) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		serve(c.(*net.TCPConn))
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func dialAndClose(t *testing.T, addr string, read bool) *ConnectionClosedState {
+	d := Dialer{Deadline: time.Now().Add(3 * time.Second)}
+	c, err := d.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %s", err.Error())
+	}
+	if read {
+		c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		b := make([]byte, 64)
+		for {
+			if _, err := c.Read(b); err != nil {
+				break
+			}
+		}
+	}
+	c.Close()
+	c.Close()
+	ops := c.grabData.Operations
+	if len(ops) == 0 || ops[len(ops)-1].Type != OperationConnectionClosed {
+		t.Errorf("No trailing %s operation", OperationConnectionClosed)
+	}
+	n := 0
+	for _, op := range ops {
+		if op.Type == OperationConnectionClosed {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("Connection close recorded %d times", n)
+	}
+	return c.grabData.Closed
+}
+
+func TestConnectionClosedReasons(t *testing.T) {
+	addr, stop := closingServer(t, func(c *net.TCPConn) {
+		c.Write([]byte("bye\r\n"))
+		c.Close()
+	})
+	if s := dialAndClose(t, addr, true); s == nil || s.Reason != CloseReasonPeerFIN {
+		t.Errorf("Expected peer FIN, got %+v", s)
+	}
+	stop()
+
+	addr, stop = closingServer(t, func(c *net.TCPConn) {
+		// Give the dial time to complete before the reset
+		time.Sleep(50 * time.Millisecond)
+		c.SetLinger(0)
+		c.Close()
+	})
+	if s := dialAndClose(t, addr, true); s == nil || s.Reason != CloseReasonPeerRST || s.Error == "" || s.ErrorClass != "connection_reset" {
+		t.Errorf("Expected peer RST, got %+v", s)
+	}
+	stop()
+
+	addr, stop = silentServer(t)
+	if s := dialAndClose(t, addr, true); s == nil || s.Reason != CloseReasonTimeout {
+		t.Errorf("Expected timeout, got %+v", s)
+	}
+	if s := dialAndClose(t, addr, false); s == nil || s.Reason != CloseReasonLocal || s.Error != "" {
+		t.Errorf("Expected local close, got %+v", s)
+	}
+	stop()
+}
+
+// Reads and writes on the counted socket may run in different goroutines,
+// as they do during a false start or a heartbeat probe
+func TestCountingConnConcurrentIO(t *testing.T) {
+	client, server := net.Pipe()
+	cc := &countingConn{Conn: client}
+	go func() {
+		buf := make([]byte, 4)
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+			server.Write(buf)
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 4)
+		for i := 0; i < 100; i++ {
+			cc.Read(buf)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		cc.Write([]byte("ping"))
+	}
+	<-done
+	server.Close()
+	if _, err := cc.Read(make([]byte, 1)); err == nil || cc.lastErr() != err {
+		t.Errorf("Last error %v, read returned %v", cc.lastErr(), err)
+	}
+}
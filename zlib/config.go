@@ -15,24 +15,35 @@
 package zlib
 
 import (
+	"context"
 	"encoding/csv"
 	"errors"
 	"io"
+	"net"
+	"net/url"
+	"regexp"
 	"strings"
 	"time"
 
 	"gopkg.in/eniac/zgrab.v0/ztools/ssh"
 	"gopkg.in/eniac/zgrab.v0/ztools/x509"
 	"gopkg.in/eniac/zgrab.v0/ztools/zlog"
+	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
 )
 
 type HTTPConfig struct {
-	Method       string
-	Endpoint     string
-	UserAgent    string
-	ProxyDomain  string
-	MaxSize      int
-	MaxRedirects int
+	Method           string
+	Endpoint         string
+	UserAgent        string
+	ProxyDomain      string
+	MaxSize          int
+	MaxRedirects     int
+	RedirectSameHost bool
+	Headers          map[string]string
+
+	// Decode the JSON served at GET / by Elasticsearch or CouchDB
+	Elasticsearch bool
+	CouchDB       bool
 }
 
 type SSHScanConfig struct {
@@ -46,7 +57,11 @@ type SSHScanConfig struct {
 }
 
 type XSSHScanConfig struct {
-	XSSH bool
+	XSSH                  bool
+	HostKeys              bool
+	HostKeyMaxConnections int
+	AuthProbe             bool
+	AuthProbeUser         string
 }
 
 func (sc *SSHScanConfig) GetClientImplementation() (*ssh.ClientImplementation, bool) {
@@ -102,15 +117,37 @@ type Config struct {
 	Timeout            time.Duration
 	Senders            uint
 	ConnectionsPerHost uint
+	SourceIPs          []net.IP
+	Interface          string
+	AddressFamily      string // "4" or "6" to force IPv4 or IPv6, empty for either
+	Proxy              *url.URL
+
+	// Retries, see grabWithRetries
+	RetryAttempts uint
+	RetryDelay    time.Duration
+	RetryBackoff  float64
+	RetryRefused  bool
+	RetryBudget   time.Duration
 
 	// DNS
-	LookupDomain bool
+	LookupDomain  bool
+	Resolve       bool
+	Resolver      string
+	ResolvePolicy string
 
 	// TLS
 	TLS                           bool
 	TLSVersion                    uint16
+	TLSMinVersion                 uint16
 	Heartbleed                    bool
+	HeartbleedSafe                bool
+	HeartbleedSampleSize          int
+	HeartbleedProbes              int
+	HeartbleedPayloadLength       int
+	HeartbleedDelay               time.Duration
 	RootCAPool                    *x509.CertPool
+	ClientCertificate             *ztls.Certificate
+	CTLogs                        *ztls.CTLogList
 	DHEOnly                       bool
 	ECDHEOnly                     bool
 	ExportsOnly                   bool
@@ -121,14 +158,26 @@ type Config struct {
 	ChromeNoDHE                   bool
 	SafariOnly                    bool
 	SafariNoDHE                   bool
+	CipherSuites                  []uint16
 	NoSNI                         bool
+	NextProtos                    []string
+	NoOCSPStapling                bool
 	TLSExtendedRandom             bool
 	GatherSessionTicket           bool
+	TLSResumption                 bool
+	TLSRenegotiation              bool
+	TLSCurves                     bool
+	TLSCurvesMaxConnections       int
+	SSLv2                         bool
 	ExtendedMasterSecret          bool
 	TLSVerbose                    bool
+	CaptureHandshakeBytes         bool
+	TLSCertificatesOnly           bool
+	TLSFalseStart                 bool
 	SignedCertificateTimestampExt bool
 	ExternalClientHello           []byte
-	TLSInvalidDHKeyExchange string
+	TLSClientHelloProfile         *ztls.ClientHelloProfile
+	TLSInvalidDHKeyExchange       string
 
 	// SSH
 	SSH SSHScanConfig
@@ -139,15 +188,37 @@ type Config struct {
 	Data     []byte
 	Raw      bool
 
+	// Banner probe, replaces the plain read after sending Data
+	BannerProbe         bool
+	BannerProbeUntil    *regexp.Regexp
+	BannerProbeMaxBytes int
+	BannerProbeTimeout  time.Duration
+
 	// Mail
 	SMTP       bool
 	IMAP       bool
 	POP3       bool
 	SMTPHelp   bool
+	SMTPAuth   bool
+	SMTPVrfy   string
+	SMTPExpn   string
 	EHLODomain string
 	EHLO       bool
 	StartTLS   bool
 
+	// IMAP CAPABILITY or POP3 CAPA, repeated after STARTTLS
+	MailCapabilities bool
+
+	// How long to wait for the reply to the goodbye sent before closing
+	QuitTimeout time.Duration
+
+	// How long to read after a half-close before closing, zero to close
+	// without one
+	DrainTimeout time.Duration
+
+	// Overall limit on the time spent on each connection, zero for none
+	TotalTimeout time.Duration
+
 	// FTP
 	FTP        bool
 	FTPAuthTLS bool
@@ -156,12 +227,96 @@ type Config struct {
 	Telnet        bool
 	TelnetMaxSize int
 
+	// XMPP
+	XMPP       bool
+	XMPPDomain string
+
+	// LDAP StartTLS and an anonymous rootDSE search, either or both
+	LDAP        bool
+	LDAPRootDSE bool
+
+	// Kerberos AS-REQ, over UDP unless KerberosTCP is set
+	Kerberos      bool
+	KerberosTCP   bool
+	KerberosRealm string
+
+	// Postgres SSLRequest, or a StartupMessage probing the authentication
+	// method and version
+	Postgres        bool
+	PostgresStartup bool
+
+	// Cassandra native protocol OPTIONS
+	Cassandra bool
+
+	// AMQP Connection.Start and MQTT CONNACK, either optionally over TLS
+	AMQP bool
+	MQTT bool
+
+	// MySQL
+	MySQL    bool
+	MySQLTLS bool
+
 	// Modbus
 	Modbus bool
 
+	// memcached stats and Redis INFO
+	Memcached bool
+	Redis     bool
+
+	// MongoDB isMaster and buildInfo
+	MongoDB bool
+
+	// VNC security types, optionally followed by VeNCrypt and TLS
+	VNC    bool
+	VNCTLS bool
+
+	// RDP negotiation, followed by TLS when the server selects it
+	RDP bool
+
+	// SMB dialect and signing negotiation
+	SMB bool
+
+	// NNTP greeting, capabilities and overview format
+	NNTP bool
+
+	// IRC registration numerics and ISUPPORT tokens
+	IRC bool
+
 	// BACNet
 	BACNet bool
 
+	// Request/response probe over UDP, see UDPProbes
+	UDPProbe        *UDPProbe
+	UDPMaxResponses int
+
+	// NTP client query, optionally followed by a READVAR control query
+	NTP        bool
+	NTPReadVar bool
+
+	// Recursive DNS query telling open resolvers, with version.bind
+	DNSProbe        bool
+	DNSProbeName    string
+	DNSProbeVersion bool
+
+	// SNMP system group GetRequest, one community after another
+	SNMP            bool
+	SNMPVersion     int
+	SNMPCommunities []string
+
+	// SIP OPTIONS request, over UDP unless SIPTCP is set
+	SIP    bool
+	SIPTCP bool
+
+	// Unicast SSDP M-SEARCH, optionally fetching the device description
+	SSDP             bool
+	SSDPFetch        bool
+	SSDPFetchAnyHost bool
+
+	// DTLS over UDP in place of TLS
+	DTLS                  bool
+	DTLSRetransmitTimeout time.Duration
+	DTLSMaxRetransmits    int
+
 	// Niagara Fox
 	Fox bool
 
@@ -177,6 +332,27 @@ type Config struct {
 	// Error handling
 	ErrorLog *zlog.Logger
 
+	// Log protocol-level trace messages to ErrorLog
+	Trace bool
+
+	// Record response bytes on operations: "", "base64" or "utf8"
+	OperationResponses string
+
+	// Record the length and SHA-256 of sent payloads instead of the bytes
+	HashWritePayloads bool
+
+	// Cap on total bytes read per connection, zero for no limit
+	MaxReadBytes int
+
+	// Networks never to contact, nil to allow all
+	Exclude *ExcludeList
+
+	// Caps the rate of new connections, nil for no cap
+	ConnectionLimiter *ConnectionLimiter
+
+	// Canceling Context aborts in-flight grabs, nil to never cancel
+	Context context.Context
+
 	// Go Runtime Config
 	GOMAXPROCS int
 
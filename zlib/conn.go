@@ -16,6 +16,7 @@ package zlib
 
 import (
 	"bufio"
+	"context"
 	"crypto/sha256"
 	"errors"
 	"fmt"
@@ -26,24 +27,48 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"gopkg.in/eniac/zgrab.v0/ztools/amqp"
+	"gopkg.in/eniac/zgrab.v0/ztools/cassandra"
 	"gopkg.in/eniac/zgrab.v0/ztools/ftp"
+	"gopkg.in/eniac/zgrab.v0/ztools/ldap"
+	"gopkg.in/eniac/zgrab.v0/ztools/mongodb"
+	"gopkg.in/eniac/zgrab.v0/ztools/mqtt"
+	"gopkg.in/eniac/zgrab.v0/ztools/mysql"
+	"gopkg.in/eniac/zgrab.v0/ztools/postgres"
+	"gopkg.in/eniac/zgrab.v0/ztools/rdp"
 	"gopkg.in/eniac/zgrab.v0/ztools/scada/bacnet"
+	"gopkg.in/eniac/zgrab.v0/ztools/smb"
 	"gopkg.in/eniac/zgrab.v0/ztools/ssh"
+	"gopkg.in/eniac/zgrab.v0/ztools/sslv2"
+	"gopkg.in/eniac/zgrab.v0/ztools/telnet"
 	"gopkg.in/eniac/zgrab.v0/ztools/util"
+	"gopkg.in/eniac/zgrab.v0/ztools/vnc"
 	"gopkg.in/eniac/zgrab.v0/ztools/x509"
+	"gopkg.in/eniac/zgrab.v0/ztools/xmpp"
+	"gopkg.in/eniac/zgrab.v0/ztools/xssh"
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+	"gopkg.in/eniac/zgrab.v0/ztools/zlog"
 	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
 )
 
 var smtpEndRegex = regexp.MustCompile(`(?:^\d\d\d\s.*\r\n$)|(?:^\d\d\d-[\s\S]*\r\n\d\d\d\s.*\r\n$)`)
 var pop3EndRegex = regexp.MustCompile(`(?:\r\n\.\r\n$)|(?:\r\n$)`)
 var imapStatusEndRegex = regexp.MustCompile(`\r\n$`)
+var imapTaggedEndRegex = regexp.MustCompile(`(?:^|\r\n)a\d\d\d [^\r\n]*\r\n$`)
+
+// pop3MultilineEndRegex matches a -ERR line or a multi-line response ended
+// by a "." line
+var pop3MultilineEndRegex = regexp.MustCompile(`(?:^-[^\r\n]*\r\n$)|(?:\r\n\.\r\n$)`)
+
+// Multi-line SMTP responses are read into a growing buffer capped at this size
+const smtpMaxResponseSize = 64 * 1024
 
 const (
 	SMTP_COMMAND = "STARTTLS\r\n"
 	POP3_COMMAND = "STLS\r\n"
-	IMAP_COMMAND = "a001 STARTTLS\r\n"
 )
 
 // Implements the net.Conn interface
@@ -53,9 +78,21 @@ type Conn struct {
 	tlsConn *ztls.Conn
 	isTls   bool
 
+	// DTLS, see DTLSHandshake
+	dtlsConn              *ztls.DTLSConn
+	dtlsRetransmitTimeout time.Duration
+	dtlsMaxRetransmits    int
+	dtlsRetransmitSet     bool
+
 	grabData GrabData
 
-	// Max TLS version
+	// Guards grabData.Operations, see Operations and DrainOperations
+	opsMu   sync.Mutex
+	nextSeq int
+	firstOp time.Time
+
+	// TLS version bounds, zero means the ztls default
+	minTlsVersion uint16
 	maxTlsVersion uint16
 
 	// Cache the deadlines so we can reapply after TLS handshake
@@ -63,27 +100,86 @@ type Conn struct {
 	writeDeadline time.Time
 
 	caPool *x509.CertPool
+	ctLogs *ztls.CTLogList
+
+	// Presented when the server sends a CertificateRequest, nil for none
+	clientCert *ztls.Certificate
 
 	CipherSuites                  []uint16
 	ForceSuites                   bool
 	noSNI                         bool
+	nextProtos                    []string
+	noOCSPStapling                bool
 	ExternalClientHello           []byte
+	clientHelloProfile            *ztls.ClientHelloProfile
 	extendedRandom                bool
 	gatherSessionTicket           bool
 	offerExtendedMasterSecret     bool
+	captureHandshakeBytes         bool
+	tlsCertificatesOnly           bool
+	tlsFalseStart                 bool
 	tlsVerbose                    bool
 	SignedCertificateTimestampExt bool
 
 	// send an invalid client key exchange value
 	tlsInvalidDHKeyExchange string
 
+	// Export cipher family being probed, see SetExportProbe
+	exportProbe string
+
 	domain string
 
+	// Set once the POP3 or IMAP greeting has been consumed
+	pop3GreetingRead bool
+	imapGreetingRead bool
+
+	// Number of the last IMAP command tag sent, see nextIMAPTag
+	imapTag int
+
 	// SSH
 	sshScan *SSHScanConfig
 
 	// Errored component
 	erroredComponent string
+
+	// Protocol-level trace output, silent when nil
+	debugLog *zlog.Logger
+
+	// How response bytes are recorded on operations, empty to omit them
+	responseEncoding string
+
+	// Record the length and SHA-256 of sent payloads rather than the bytes,
+	// see SetRecordWritePayloads
+	hashWritePayloads bool
+
+	// Bytes read and written on the socket, see Summary
+	counter *countingConn
+
+	// Cap on the bytes read from the remote host, nil for no limit
+	readLimit         *readLimitConn
+	readLimitRecorded bool
+
+	// Cancellation, see WithContext
+	ctx            context.Context
+	stopWatch      chan struct{}
+	stopOnce       sync.Once
+	cancelRecorded bool
+
+	// Overall time limit, see SetTotalTimeout
+	budget *budgetConn
+
+	// Polite close, see Quit and SetDrainTimeout
+	goodbye      *Goodbye
+	quitTimeout  time.Duration
+	drainTimeout time.Duration
+
+	// Session resumption, see ResumptionCheck
+	sessionCache ztls.ClientSessionCache
+	redial       func() (*Conn, error)
+
+	// TCP connection to the target for a truncated DNS probe reply, see
+	// DNSProbe
+	redialTCP func() (*Conn, error)
 }
 
 func (c *Conn) getUnderlyingConn() net.Conn {
@@ -97,6 +193,34 @@ func (c *Conn) SetExternalClientHello(clientHello []byte) {
 	c.ExternalClientHello = clientHello
 }
 
+// SetClientHelloProfile shapes the ClientHello like the profile's client
+func (c *Conn) SetClientHelloProfile(profile *ztls.ClientHelloProfile) {
+	c.clientHelloProfile = profile
+}
+
+// SetResponseEncoding makes read operations carry the bytes received, encoded
+// as ResponseEncodingBase64 or, where the bytes are printable text,
+// ResponseEncodingUTF8. An empty encoding omits them.
+func (c *Conn) SetResponseEncoding(encoding string) {
+	c.responseEncoding = encoding
+}
+
+// SetRecordWritePayloads controls whether the bytes sent by Write and
+// BannerProbe are kept in the grab, which is the default. When record is
+// false only their length and SHA-256 are recorded, on the operation that
+// sent them. Heartbleed logs and HTTP requests are recorded as before: they
+// are not sent through Write.
+func (c *Conn) SetRecordWritePayloads(record bool) {
+	c.hashWritePayloads = !record
+}
+
+// SetTLSVersionBounds restricts the versions offered in TLSHandshake. A zero
+// bound leaves the ztls default in place.
+func (c *Conn) SetTLSVersionBounds(min, max uint16) {
+	c.minTlsVersion = min
+	c.maxTlsVersion = max
+}
+
 func (c *Conn) SetExtendedRandom() {
 	c.extendedRandom = true
 }
@@ -105,6 +229,17 @@ func (c *Conn) SetCAPool(pool *x509.CertPool) {
 	c.caPool = pool
 }
 
+// SetClientCertificate sets the certificate presented to servers that
+// request one. Without it an empty certificate list is sent.
+func (c *Conn) SetClientCertificate(cert *ztls.Certificate) {
+	c.clientCert = cert
+}
+
+// SetCTLogs sets the CT logs the server's SCT signatures are checked against
+func (c *Conn) SetCTLogs(logs *ztls.CTLogList) {
+	c.ctLogs = logs
+}
+
 func (c *Conn) SetDomain(domain string) {
 	c.domain = domain
 }
@@ -113,10 +248,41 @@ func (c *Conn) SetNoSNI() {
 	c.noSNI = true
 }
 
+// SetNextProtos sets the protocols offered with ALPN and NPN in the next TLS
+// handshake, most preferred first
+func (c *Conn) SetNextProtos(protos []string) {
+	c.nextProtos = protos
+}
+
+// SetNoOCSPStapling stops the status_request extension being offered
+func (c *Conn) SetNoOCSPStapling() {
+	c.noOCSPStapling = true
+}
+
 func (c *Conn) SetGatherSessionTicket() {
 	c.gatherSessionTicket = true
 }
 
+// SetCaptureHandshakeBytes keeps the raw bytes of the first flight in each
+// direction of the next TLS handshake
+func (c *Conn) SetCaptureHandshakeBytes() {
+	c.captureHandshakeBytes = true
+}
+
+// SetTLSCertificatesOnly abandons the next TLS handshake once the server's
+// certificates have been recorded. TLSHandshake then succeeds without the
+// connection switching to TLS.
+func (c *Conn) SetTLSCertificatesOnly() {
+	c.tlsCertificatesOnly = true
+}
+
+// SetTLSFalseStart lets the next TLS handshake return after the client
+// Finished when an ECDHE AEAD suite is negotiated, so the first Write goes
+// out before the server's Finished has been read
+func (c *Conn) SetTLSFalseStart() {
+	c.tlsFalseStart = true
+}
+
 func (c *Conn) SetOfferExtendedMasterSecret() {
 	c.offerExtendedMasterSecret = true
 }
@@ -129,6 +295,24 @@ func (c *Conn) SetTLSVerbose() {
 	c.tlsVerbose = true
 }
 
+// SetDebugLogger routes protocol-level trace messages (bytes sent and
+// received, response matches, handshake milestones) to logger. Passing nil
+// disables tracing, which is the default.
+func (c *Conn) SetDebugLogger(logger *zlog.Logger) {
+	c.debugLog = logger
+}
+
+func (c *Conn) tracef(format string, v ...interface{}) {
+	if c.debugLog == nil {
+		return
+	}
+	remote := "<unconnected>"
+	if c.conn != nil {
+		remote = c.conn.RemoteAddr().String()
+	}
+	c.debugLog.Tracef("%s: %s", remote, fmt.Sprintf(format, v...))
+}
+
 // Layer in the regular conn methods
 func (c *Conn) LocalAddr() net.Addr {
 	return c.getUnderlyingConn().LocalAddr()
@@ -156,25 +340,40 @@ func (c *Conn) SetWriteDeadline(t time.Time) error {
 
 // Delegate here, but record all the things
 func (c *Conn) Write(b []byte) (int, error) {
+	start := time.Now()
 	n, err := c.getUnderlyingConn().Write(b)
-	c.grabData.Write = string(b[0:n])
+	c.tracef("sent %d bytes: %q", n, b[0:n])
+	op := c.newOperation(OperationWrite, start)
+	c.grabData.Write = c.sentPayload(op, b[0:n])
+	c.finishOperation(op)
 	return n, err
 }
 
 func (c *Conn) BasicBanner() (string, error) {
-	b := make([]byte, 1024)
+	start := time.Now()
+	p := getReadBuffer(1024)
+	defer putReadBuffer(p, *p)
+	b := *p
 	n, err := c.getUnderlyingConn().Read(b)
+	c.tracef("received %d bytes: %q", n, b[0:n])
 	c.grabData.Banner = string(b[0:n])
+	c.recordResponse(OperationBanner, start, b[0:n])
 	return c.grabData.Banner, err
 }
 
 func (c *Conn) Read(b []byte) (int, error) {
+	start := time.Now()
 	n, err := c.getUnderlyingConn().Read(b)
+	c.tracef("received %d bytes: %q", n, b[0:n])
+	// Converting to a string copies the bytes, so callers are free to reuse b
 	c.grabData.Read = string(b[0:n])
+	c.recordResponse(OperationRead, start, b[0:n])
 	return n, err
 }
 
 func (c *Conn) Close() error {
+	c.recordClose()
+	c.stopContextWatch()
 	return c.getUnderlyingConn().Close()
 }
 
@@ -189,6 +388,12 @@ func (c *Conn) makeHTTPRequest(endpoint string, httpMethod string, userAgent str
 	} else {
 		host, _, _ = net.SplitHostPort(c.RemoteAddr().String())
 	}
+	if len(userAgent) <= 0 {
+		userAgent = "Mozilla/5.0 zgrab/0.x"
+	}
+	if err = checkArguments("HTTP host", host, "HTTP method", httpMethod, "HTTP endpoint", endpoint, "HTTP user agent", userAgent); err != nil {
+		return nil, nil, err
+	}
 	url.Host = host
 	req.Host = host
 	req.Method = httpMethod
@@ -201,10 +406,6 @@ func (c *Conn) makeHTTPRequest(endpoint string, httpMethod string, userAgent str
 	url.Path = endpoint
 	req.URL = url
 
-	if len(userAgent) <= 0 {
-		userAgent = "Mozilla/5.0 zgrab/0.x"
-	}
-
 	req.Header.Set("User-Agent", userAgent)
 	encReq = new(HTTPRequest)
 	encReq.Endpoint = endpoint
@@ -271,6 +472,9 @@ func (c *Conn) doProxy(config *HTTPConfig) error {
 	if c.grabData.HTTP == nil {
 		c.grabData.HTTP = new(HTTP)
 	}
+	if err := checkArgument("proxy domain", config.ProxyDomain); err != nil {
+		return err
+	}
 	c.grabData.HTTP.ProxyRequest = encReq
 	req.Method = "CONNECT"
 	req.URL.Path = config.ProxyDomain
@@ -289,23 +493,87 @@ func (c *Conn) doProxy(config *HTTPConfig) error {
 
 // Extra method - Do a TLS Handshake and record progress
 func (c *Conn) TLSHandshake() error {
-	if c.isTls {
+	if c.tlsConn != nil {
 		return fmt.Errorf(
 			"Attempted repeat handshake with remote host %s",
 			c.RemoteAddr().String())
 	}
+	defer c.recordOperation(OperationTLSHandshake, time.Now())
+	tlsConfig := c.tlsClientConfig()
+
+	c.tlsConn = ztls.Client(c.conn, tlsConfig)
+	c.tlsConn.SetReadDeadline(c.readDeadline)
+	c.tlsConn.SetWriteDeadline(c.writeDeadline)
+	c.tracef("starting TLS handshake (max version %#04x, server name %q)", tlsConfig.MaxVersion, tlsConfig.ServerName)
+	err := c.tlsConn.Handshake()
+	if tlsConfig.ForceSuites && err == ztls.ErrUnimplementedCipher {
+		err = nil
+	}
+	// Only route through the TLS connection once it is usable, so a failed
+	// handshake leaves reads, writes and deadlines on the plain connection
+	if err == ztls.ErrCertificatesOnly {
+		err = nil
+		c.tracef("TLS handshake abandoned after the server certificates")
+	} else if err != nil {
+		c.tracef("TLS handshake failed: %s", err.Error())
+	} else {
+		c.isTls = true
+		c.tracef("TLS handshake complete")
+	}
+	hl := c.tlsConn.GetHandshakeLog()
+	for _, sct := range hl.SCTs {
+		sct.ErrorClass = zerrors.Classify(sct.Err())
+	}
+
+	if !c.tlsVerbose {
+		hl.KeyMaterial = nil
+		if hl.ClientHello != nil {
+			// Keep what we offered, drop the per-connection randomness
+			hl.ClientHello = &ztls.ClientHello{
+				ServerName:    hl.ClientHello.ServerName,
+				CipherSuites:  hl.ClientHello.CipherSuites,
+				ALPNProtocols: hl.ClientHello.ALPNProtocols,
+				Heartbeat:     hl.ClientHello.Heartbeat,
+				HeartbeatMode: hl.ClientHello.HeartbeatMode,
+				Profile:       hl.ClientHello.Profile,
+				JA3:           hl.ClientHello.JA3,
+			}
+		}
+		hl.ClientFinished = nil
+		hl.ClientKeyExchange = nil
+	}
+
+	c.grabData.TLSHandshake = hl
+	if c.exportProbe != "" {
+		c.grabData.ExportCiphers = exportProbeResult(c.exportProbe, tlsConfig.CipherSuites, hl)
+	}
+	return err
+}
+
+// tlsClientConfig builds the ztls configuration for a handshake from the
+// options set on the connection
+func (c *Conn) tlsClientConfig() *ztls.Config {
 	tlsConfig := new(ztls.Config)
 	tlsConfig.InsecureSkipVerify = true
 	tlsConfig.MinVersion = ztls.VersionSSL30
+	if c.minTlsVersion != 0 {
+		tlsConfig.MinVersion = c.minTlsVersion
+	}
 	tlsConfig.MaxVersion = c.maxTlsVersion
 	tlsConfig.RootCAs = c.caPool
+	tlsConfig.CTLogs = c.ctLogs
+	if c.clientCert != nil {
+		tlsConfig.Certificates = []ztls.Certificate{*c.clientCert}
+	}
 	tlsConfig.HeartbeatEnabled = true
 	tlsConfig.ClientDSAEnabled = true
 	tlsConfig.ForceSuites = c.ForceSuites
 	tlsConfig.CipherSuites = c.CipherSuites
 	tlsConfig.InvalidDHKeyExchange = c.tlsInvalidDHKeyExchange
-	if !c.noSNI && c.domain != "" {
-		tlsConfig.ServerName = c.domain
+	tlsConfig.NextProtos = c.nextProtos
+	tlsConfig.NoOCSPStapling = c.noOCSPStapling
+	if !c.noSNI {
+		tlsConfig.ServerName = serverNameIndication(c.domain)
 	}
 	if c.extendedRandom {
 		tlsConfig.ExtendedRandom = true
@@ -316,32 +584,48 @@ func (c *Conn) TLSHandshake() error {
 	if c.gatherSessionTicket {
 		tlsConfig.ForceSessionTicketExt = true
 	}
+	if c.captureHandshakeBytes {
+		tlsConfig.CaptureHandshakeBytes = true
+	}
+	if c.tlsCertificatesOnly {
+		tlsConfig.CertificatesOnly = true
+	}
+	if c.tlsFalseStart {
+		tlsConfig.FalseStart = true
+	}
 	if c.offerExtendedMasterSecret {
 		tlsConfig.ExtendedMasterSecret = true
 	}
 	if c.ExternalClientHello != nil {
 		tlsConfig.ExternalClientHello = c.ExternalClientHello
 	}
+	tlsConfig.ClientHelloProfile = c.clientHelloProfile
+	tlsConfig.ClientSessionCache = c.sessionCache
+	return tlsConfig
+}
 
-	c.tlsConn = ztls.Client(c.conn, tlsConfig)
-	c.tlsConn.SetReadDeadline(c.readDeadline)
-	c.tlsConn.SetWriteDeadline(c.writeDeadline)
-	c.isTls = true
-	err := c.tlsConn.Handshake()
-	if tlsConfig.ForceSuites && err == ztls.ErrUnimplementedCipher {
-		err = nil
+// serverNameIndication returns the name to send in the SNI extension for
+// host, which may include a port. IP literals are never sent, per RFC 6066.
+func serverNameIndication(host string) string {
+	if containsPort(host) {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
 	}
-	hl := c.tlsConn.GetHandshakeLog()
-
-	if !c.tlsVerbose {
-		hl.KeyMaterial = nil
-		hl.ClientHello = nil
-		hl.ClientFinished = nil
-		hl.ClientKeyExchange = nil
+	if isIPLiteral(host) {
+		return ""
 	}
+	return host
+}
 
-	c.grabData.TLSHandshake = hl
-	return err
+// isIPLiteral reports whether host is an IP address, optionally bracketed
+// and, for IPv6, carrying a zone such as fe80::1%eth0
+func isIPLiteral(host string) bool {
+	host = strings.Trim(host, "[]")
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+	return net.ParseIP(host) != nil
 }
 
 func (c *Conn) sendStartTLSCommand(command string) error {
@@ -351,26 +635,31 @@ func (c *Conn) sendStartTLSCommand(command string) error {
 			"Attempt STARTTLS after TLS handshake with remote host %s",
 			c.RemoteAddr().String())
 	}
+	if err := checkArgument("STARTTLS command", strings.TrimSuffix(command, "\r\n")); err != nil {
+		return err
+	}
 	// Send the STARTTLS message
 	starttls := []byte(command)
 	_, err := c.conn.Write(starttls)
+	c.tracef("sent STARTTLS command %q", command)
 	return err
 }
 
 // Do a STARTTLS handshake
 func (c *Conn) SMTPStartTLSHandshake() error {
 
+	start := time.Now()
 	// Send the command
 	if err := c.sendStartTLSCommand(SMTP_COMMAND); err != nil {
 		return err
 	}
 	// Read the response on a successful send
-	buf := make([]byte, 256)
-	n, err := c.readSmtpResponse(buf)
-	c.grabData.StartTLS = string(buf[0:n])
+	res, err := c.readPooledSmtpResponse(256)
+	c.grabData.StartTLS = string(res)
+	c.recordResponse(OperationStartTLS, start, res)
 
 	// Actually check return code
-	if n < 5 {
+	if len(res) < 5 {
 		err = errors.New("Server did not indicate support for STARTTLS")
 	}
 	if err == nil {
@@ -390,16 +679,35 @@ func (c *Conn) SMTPStartTLSHandshake() error {
 	return c.TLSHandshake()
 }
 
+// POP3StartTLSHandshake reads the greeting if it has not been read yet,
+// sends STLS and only performs the TLS handshake on a +OK reply. A -ERR reply
+// is returned as a *StartTLSRefusedError.
 func (c *Conn) POP3StartTLSHandshake() error {
+	if !c.pop3GreetingRead {
+		if err := c.readPOP3Banner(); err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(c.grabData.Banner, "+OK") {
+		return errors.New("Server did not send a POP3 +OK greeting")
+	}
+
+	start := time.Now()
 	if err := c.sendStartTLSCommand(POP3_COMMAND); err != nil {
 		return err
 	}
 
-	buf := make([]byte, 512)
-	n, err := c.readPop3Response(buf)
-	c.grabData.StartTLS = string(buf[0:n])
+	buf := getReadBuffer(512)
+	n, err := c.readPop3Response(*buf)
+	c.grabData.StartTLS = string((*buf)[0:n])
+	c.recordResponse(OperationStartTLS, start, (*buf)[0:n])
+	putReadBuffer(buf, *buf)
 	if err == nil {
-		if !strings.HasPrefix(c.grabData.StartTLS, "+") {
+		switch {
+		case strings.HasPrefix(c.grabData.StartTLS, "+OK"):
+		case strings.HasPrefix(c.grabData.StartTLS, "-ERR"):
+			err = &StartTLSRefusedError{Protocol: "POP3", Response: c.grabData.StartTLS}
+		default:
 			err = errors.New("Server did not indicate support for STARTTLS")
 		}
 	}
@@ -410,16 +718,38 @@ func (c *Conn) POP3StartTLSHandshake() error {
 	return c.TLSHandshake()
 }
 
+// IMAPStartTLSHandshake reads the greeting if it has not been read yet,
+// sends STARTTLS and skips any untagged lines before the tagged reply. A
+// tagged NO or BAD is returned as a *StartTLSRefusedError.
 func (c *Conn) IMAPStartTLSHandshake() error {
-	if err := c.sendStartTLSCommand(IMAP_COMMAND); err != nil {
+	if !c.imapGreetingRead {
+		if err := c.readIMAPBanner(); err != nil {
+			return err
+		}
+	}
+	if !imapGreetingAccepted(c.grabData.Banner) {
+		return errIMAPGreeting
+	}
+
+	start := time.Now()
+	tag := c.nextIMAPTag()
+	if err := c.sendStartTLSCommand(tag + " STARTTLS\r\n"); err != nil {
 		return err
 	}
 
-	buf := make([]byte, 512)
-	n, err := c.readImapStatusResponse(buf)
-	c.grabData.StartTLS = string(buf[0:n])
+	buf := getReadBuffer(1024)
+	n, err := c.readImapTaggedResponse(*buf)
+	c.grabData.StartTLS = string((*buf)[0:n])
+	c.recordResponse(OperationStartTLS, start, (*buf)[0:n])
+	putReadBuffer(buf, *buf)
 	if err == nil {
-		if !strings.HasPrefix(c.grabData.StartTLS, "a001 OK") {
+		lines := strings.Split(strings.TrimSuffix(c.grabData.StartTLS, "\r\n"), "\r\n")
+		status := lines[len(lines)-1]
+		switch {
+		case strings.HasPrefix(status, tag+" OK"):
+		case strings.HasPrefix(status, tag+" NO"), strings.HasPrefix(status, tag+" BAD"):
+			err = &StartTLSRefusedError{Protocol: "IMAP", Response: status}
+		default:
 			err = errors.New("Server did not indicate support for STARTTLS")
 		}
 	}
@@ -430,78 +760,288 @@ func (c *Conn) IMAPStartTLSHandshake() error {
 	return c.TLSHandshake()
 }
 
-func (c *Conn) readSmtpResponse(res []byte) (int, error) {
-	return util.ReadUntilRegex(c.getUnderlyingConn(), res, smtpEndRegex)
+// IMAPCapability reads the greeting if it has not been read yet and sends
+// CAPABILITY, recording the list under plaintext or, once TLS is up, tls. It
+// can be called again after IMAPStartTLSHandshake.
+func (c *Conn) IMAPCapability() error {
+	if !c.imapGreetingRead {
+		if err := c.readIMAPBanner(); err != nil {
+			return err
+		}
+	}
+	if !imapGreetingAccepted(c.grabData.Banner) {
+		return errIMAPGreeting
+	}
+
+	start := time.Now()
+	tag := c.nextIMAPTag()
+	if _, err := c.getUnderlyingConn().Write([]byte(tag + " CAPABILITY\r\n")); err != nil {
+		c.recordOperation(OperationIMAPCapability, start)
+		return err
+	}
+	res, err := c.readPooled(1024, imapTaggedEndRegex, smtpMaxResponseSize)
+	c.traceResponse("IMAP", res, err)
+	c.recordResponse(OperationIMAPCapability, start, res)
+	if err != nil {
+		return err
+	}
+	if c.grabData.IMAPCapability == nil {
+		c.grabData.IMAPCapability = new(IMAPCapabilityLog)
+	}
+	if c.isTls {
+		c.grabData.IMAPCapability.TLS = parseIMAPCapabilities(string(res), tag)
+	} else {
+		c.grabData.IMAPCapability.Plaintext = parseIMAPCapabilities(string(res), tag)
+	}
+	return nil
+}
+
+// POP3Capa reads the greeting if it has not been read yet and sends CAPA,
+// recording the list under plaintext or, once TLS is up, tls. It can be
+// called again after POP3StartTLSHandshake.
+func (c *Conn) POP3Capa() error {
+	if !c.pop3GreetingRead {
+		if err := c.readPOP3Banner(); err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(c.grabData.Banner, "+OK") {
+		return errors.New("Server did not send a POP3 +OK greeting")
+	}
+
+	start := time.Now()
+	if _, err := c.getUnderlyingConn().Write([]byte("CAPA\r\n")); err != nil {
+		c.recordOperation(OperationPOP3Capa, start)
+		return err
+	}
+	res, err := c.readPooled(1024, pop3MultilineEndRegex, smtpMaxResponseSize)
+	c.traceResponse("POP3", res, err)
+	c.recordResponse(OperationPOP3Capa, start, res)
+	if err != nil {
+		return err
+	}
+	if c.grabData.POP3Capa == nil {
+		c.grabData.POP3Capa = new(POP3CapabilityLog)
+	}
+	if c.isTls {
+		c.grabData.POP3Capa.TLS = parsePOP3Capabilities(string(res))
+	} else {
+		c.grabData.POP3Capa.Plaintext = parsePOP3Capabilities(string(res))
+	}
+	return nil
+}
+
+// readSmtpResponse reads a complete, possibly multi-line, SMTP response. res
+// is used as the initial buffer and is grown as needed; the accumulated
+// response is returned.
+func (c *Conn) readSmtpResponse(res []byte) ([]byte, error) {
+	res, err := util.ReadUntilRegexGrowing(c.getUnderlyingConn(), res, smtpEndRegex, smtpMaxResponseSize)
+	c.traceResponse("SMTP", res, err)
+	return res, err
 }
 
-func (c *Conn) SMTPBanner(b []byte) (int, error) {
-	n, err := c.readSmtpResponse(b)
-	c.grabData.Banner = string(b[0:n])
-	return n, err
+// readPooledSmtpResponse reads an SMTP response like readSmtpResponse,
+// starting from a pooled buffer of size bytes, and returns a copy of it
+func (c *Conn) readPooledSmtpResponse(size int) ([]byte, error) {
+	res, err := c.readPooled(size, smtpEndRegex, smtpMaxResponseSize)
+	c.traceResponse("SMTP", res, err)
+	return res, err
 }
 
+func (c *Conn) traceResponse(protocol string, res []byte, err error) {
+	if err != nil {
+		c.tracef("reading %s response failed after %d bytes: %s", protocol, len(res), err.Error())
+	} else {
+		c.tracef("matched %s response (%d bytes): %q", protocol, len(res), res)
+	}
+}
+
+// SMTPBanner reads the SMTP greeting, using b as the initial read buffer.
+func (c *Conn) SMTPBanner(b []byte) (string, error) {
+	start := time.Now()
+	res, err := c.readSmtpResponse(b)
+	c.grabData.Banner = string(res)
+	c.recordResponse(OperationBanner, start, res)
+	return c.grabData.Banner, err
+}
+
+// EHLO greets the server with EHLO and, if it answers 500, 502 or 554 as
+// servers predating ESMTP do, falls back to HELO. The greeting that was
+// accepted is recorded as smtp_greeting.
 func (c *Conn) EHLO(domain string) error {
-	cmd := []byte("EHLO " + domain + "\r\n")
+	res, err := c.sendEHLO(domain)
+	c.grabData.EHLO = res
+	if err != nil {
+		return err
+	}
+	switch smtpReplyCode(res) {
+	case 500, 502, 554:
+	default:
+		c.grabData.EHLOExtensions = parseEHLOResponse(res)
+		if smtpReplyCode(res)/100 == 2 {
+			c.grabData.SMTPGreeting = SMTPGreetingEHLO
+		}
+		return nil
+	}
+	res, err = c.sendSMTPGreeting("HELO", domain, OperationHELO)
+	c.grabData.HELO = res
+	if err == nil && smtpReplyCode(res)/100 == 2 {
+		c.grabData.SMTPGreeting = SMTPGreetingHELO
+	}
+	return err
+}
+
+func (c *Conn) sendEHLO(domain string) (string, error) {
+	return c.sendSMTPGreeting("EHLO", domain, OperationEHLO)
+}
+
+func (c *Conn) sendSMTPGreeting(command, domain, opType string) (string, error) {
+	if err := checkSMTPArgument(command, domain); err != nil {
+		return "", err
+	}
+	start := time.Now()
+	cmd := []byte(command + " " + domain + "\r\n")
 	if _, err := c.getUnderlyingConn().Write(cmd); err != nil {
+		c.recordOperation(opType, start)
+		return "", err
+	}
+
+	res, err := c.readPooledSmtpResponse(512)
+	c.recordResponse(opType, start, res)
+	return string(res), err
+}
+
+// SMTPAuthProbe records the AUTH mechanisms advertised on plaintext, issues
+// STARTTLS if it is offered and repeats EHLO to record the mechanisms
+// advertised over TLS. The plaintext EHLO is reused if one was already sent.
+// No credentials are sent.
+func (c *Conn) SMTPAuthProbe(domain string) error {
+	auth := new(SMTPAuthLog)
+	c.grabData.SMTPAuth = auth
+	if c.grabData.EHLO == "" {
+		if err := c.EHLO(domain); err != nil {
+			return err
+		}
+	}
+	auth.PlaintextMechanisms = authMechanisms(c.grabData.EHLOExtensions)
+	for _, m := range auth.PlaintextMechanisms {
+		if m == "PLAIN" {
+			auth.PlainOverPlaintext = true
+		}
+	}
+
+	auth.StartTLSOffered = hasExtension(c.grabData.EHLOExtensions, "STARTTLS")
+	if !auth.StartTLSOffered {
+		return nil
+	}
+	if err := c.SMTPStartTLSHandshake(); err != nil {
 		return err
 	}
 
-	buf := make([]byte, 512)
-	n, err := c.readSmtpResponse(buf)
-	c.grabData.EHLO = string(buf[0:n])
-	return err
+	res, err := c.sendEHLO(domain)
+	auth.TLSEHLO = res
+	if err != nil {
+		return err
+	}
+	auth.TLSMechanisms = authMechanisms(parseEHLOResponse(res))
+	auth.AddedAfterStartTLS = mechanismDiff(auth.PlaintextMechanisms, auth.TLSMechanisms)
+	auth.RemovedAfterStartTLS = mechanismDiff(auth.TLSMechanisms, auth.PlaintextMechanisms)
+	return nil
 }
 
 func (c *Conn) SMTPHelp() error {
+	start := time.Now()
 	cmd := []byte("HELP\r\n")
 	h := new(SMTPHelpEvent)
 	if _, err := c.getUnderlyingConn().Write(cmd); err != nil {
 		c.grabData.SMTPHelp = h
+		c.recordOperation(OperationSMTPHelp, start)
 		return err
 	}
-	buf := make([]byte, 512)
-	n, err := c.readSmtpResponse(buf)
-	h.Response = string(buf[0:n])
+	res, err := c.readPooledSmtpResponse(512)
+	h.Response = string(res)
 	c.grabData.SMTPHelp = h
+	c.recordResponse(OperationSMTPHelp, start, res)
 	return err
 }
 
-func (c *Conn) SMTPQuit() error {
-	cmd := []byte("QUIT\r\n")
-	_, err := c.getUnderlyingConn().Write(cmd)
+// SMTPVrfy sends VRFY for user and records the reply
+func (c *Conn) SMTPVrfy(user string) error {
+	e, err := c.sendSMTPProbe("VRFY", user, OperationSMTPVrfy)
+	c.grabData.SMTPVrfy = e
 	return err
 }
 
+// SMTPExpn sends EXPN for list and records the reply
+func (c *Conn) SMTPExpn(list string) error {
+	e, err := c.sendSMTPProbe("EXPN", list, OperationSMTPExpn)
+	c.grabData.SMTPExpn = e
+	return err
+}
+
+func (c *Conn) sendSMTPProbe(command, argument, opType string) (*SMTPProbeEvent, error) {
+	e := &SMTPProbeEvent{Argument: argument}
+	if err := checkSMTPArgument(command, argument); err != nil {
+		return e, err
+	}
+	start := time.Now()
+	cmd := []byte(command + " " + argument + "\r\n")
+	if _, err := c.getUnderlyingConn().Write(cmd); err != nil {
+		c.recordOperation(opType, start)
+		return e, err
+	}
+	res, err := c.readPooledSmtpResponse(512)
+	e.Response = string(res)
+	c.recordResponse(opType, start, res)
+	if err != nil {
+		return e, err
+	}
+	if len(res) < 3 {
+		return e, fmt.Errorf("Invalid %s response", command)
+	}
+	if e.Code, err = strconv.Atoi(string(res[0:3])); err != nil {
+		return e, fmt.Errorf("Invalid %s reply code %q", command, res[0:3])
+	}
+	e.Result = classifySMTPProbe(e.Code)
+	return e, nil
+}
+
 func (c *Conn) readPop3Response(res []byte) (int, error) {
-	return util.ReadUntilRegex(c.getUnderlyingConn(), res, pop3EndRegex)
+	n, err := util.ReadUntilRegex(c.getUnderlyingConn(), res, pop3EndRegex)
+	c.traceResponse("POP3", res[0:n], err)
+	return n, err
 }
 
 func (c *Conn) POP3Banner(b []byte) (int, error) {
+	start := time.Now()
 	n, err := c.readPop3Response(b)
 	c.grabData.Banner = string(b[0:n])
+	c.pop3GreetingRead = true
+	c.recordResponse(OperationBanner, start, b[0:n])
 	return n, err
 }
 
-func (c *Conn) POP3Quit() error {
-	cmd := []byte("QUIT\r\n")
-	_, err := c.getUnderlyingConn().Write(cmd)
-	return err
-}
-
 func (c *Conn) readImapStatusResponse(res []byte) (int, error) {
-	return util.ReadUntilRegex(c.getUnderlyingConn(), res, imapStatusEndRegex)
+	n, err := util.ReadUntilRegex(c.getUnderlyingConn(), res, imapStatusEndRegex)
+	c.traceResponse("IMAP", res[0:n], err)
+	return n, err
 }
 
 func (c *Conn) IMAPBanner(b []byte) (int, error) {
+	start := time.Now()
 	n, err := c.readImapStatusResponse(b)
 	c.grabData.Banner = string(b[0:n])
+	c.imapGreetingRead = true
+	c.recordResponse(OperationBanner, start, b[0:n])
 	return n, err
 }
 
-func (c *Conn) IMAPQuit() error {
-	cmd := []byte("a001 CLOSE\r\n")
-	_, err := c.getUnderlyingConn().Write(cmd)
-	return err
+// readImapTaggedResponse reads untagged responses up to and including a
+// tagged status line
+func (c *Conn) readImapTaggedResponse(res []byte) (int, error) {
+	n, err := util.ReadUntilRegex(c.getUnderlyingConn(), res, imapTaggedEndRegex)
+	c.traceResponse("IMAP", res[0:n], err)
+	return n, err
 }
 
 func (c *Conn) CheckHeartbleed(b []byte) (int, error) {
@@ -510,6 +1050,7 @@ func (c *Conn) CheckHeartbleed(b []byte) (int, error) {
 			"Must perform TLS handshake before sending Heartbleed probe to %s",
 			c.RemoteAddr().String())
 	}
+	defer c.recordOperation(OperationHeartbleed, time.Now())
 	n, err := c.tlsConn.CheckHeartbleed(b)
 	hb := c.tlsConn.GetHeartbleedLog()
 	if err == ztls.HeartbleedError {
@@ -519,6 +1060,66 @@ func (c *Conn) CheckHeartbleed(b []byte) (int, error) {
 	return n, err
 }
 
+// CheckHeartbleedProbes sends up to k Heartbleed probes on the session, each
+// claiming payloadLength bytes, with delay between them, and records what
+// each leaked up to len(b) bytes. It returns how many probes the server
+// answered. A probe that fails, e.g. because the server closed the
+// connection, is recorded with its error and ends the probes without an
+// error being returned.
+func (c *Conn) CheckHeartbleedProbes(k, payloadLength int, delay time.Duration, b []byte) (int, error) {
+	if !c.isTls {
+		return 0, fmt.Errorf(
+			"Must perform TLS handshake before sending Heartbleed probes to %s",
+			c.RemoteAddr().String())
+	}
+	defer c.recordOperation(OperationHeartbleed, time.Now())
+	n, err := c.tlsConn.CheckHeartbleedProbes(k, payloadLength, delay, b)
+	if err == ztls.HeartbleedError {
+		err = nil
+	}
+	c.grabData.Heartbleed = c.tlsConn.GetHeartbleedLog()
+	if c.grabData.Heartbleed != nil {
+		for _, probe := range c.grabData.Heartbleed.Probes {
+			probe.ErrorClass = zerrors.Classify(probe.Err())
+		}
+	}
+	return n, err
+}
+
+// CheckHeartbeat sends a well-formed heartbeat to confirm the extension is
+// enabled without probing for Heartbleed
+func (c *Conn) CheckHeartbeat() error {
+	if !c.isTls {
+		return fmt.Errorf(
+			"Must perform TLS handshake before sending heartbeat to %s",
+			c.RemoteAddr().String())
+	}
+	defer c.recordOperation(OperationHeartbeat, time.Now())
+	err := c.tlsConn.CheckHeartbeat()
+	if err == ztls.HeartbleedError {
+		err = nil
+	}
+	c.grabData.Heartbleed = c.tlsConn.GetHeartbleedLog()
+	return err
+}
+
+// RenegotiationCheck attempts a client-initiated renegotiation. It leaves the
+// TLS connection mid-handshake, so it must be the last use of the connection.
+func (c *Conn) RenegotiationCheck() error {
+	if !c.isTls {
+		return fmt.Errorf(
+			"Must perform TLS handshake before renegotiating with %s",
+			c.RemoteAddr().String())
+	}
+	defer c.recordOperation(OperationRenegotiation, time.Now())
+	r, err := c.tlsConn.CheckRenegotiation()
+	if r != nil {
+		r.ErrorClass = zerrors.Classify(r.Err())
+	}
+	c.grabData.Renegotiation = r
+	return err
+}
+
 func (c *Conn) BACNetVendorQuery() error {
 	c.grabData.BACNet = new(bacnet.Log)
 	if err := c.grabData.BACNet.QueryDeviceID(c.getUnderlyingConn()); err != nil {
@@ -584,8 +1185,30 @@ func (c *Conn) SendModbusEcho() (int, error) {
 	return w, err
 }
 
+// FTPBanner reads the, possibly multi-line, greeting into the FTP log and
+// reports whether it carried a 2xx code
+func (c *Conn) FTPBanner() (bool, error) {
+	defer c.recordOperation(OperationBanner, time.Now())
+	c.grabData.FTP = new(ftp.FTPLog)
+	return ftp.GetFTPBanner(c.grabData.FTP, c.getUnderlyingConn())
+}
+
+// TelnetBanner refuses the server's option negotiation and reads up to
+// maxReadSize bytes of banner, with IAC sequences stripped, into the telnet
+// log
+func (c *Conn) TelnetBanner(maxReadSize int) error {
+	start := time.Now()
+	c.grabData.Telnet = new(telnet.TelnetLog)
+	err := telnet.GetTelnetBanner(c.grabData.Telnet, c.getUnderlyingConn(), maxReadSize)
+	c.tracef("telnet banner (%d bytes): %q", len(c.grabData.Telnet.Banner), c.grabData.Telnet.Banner)
+	c.recordOperation(OperationBanner, start)
+	return err
+}
+
 func (c *Conn) GetFTPSCertificates() error {
+	start := time.Now()
 	ftpsReady, err := ftp.SetupFTPS(c.grabData.FTP, c.getUnderlyingConn())
+	c.recordOperation(OperationFTPAuth, start)
 
 	if err != nil {
 		return err
@@ -598,6 +1221,279 @@ func (c *Conn) GetFTPSCertificates() error {
 	}
 }
 
+// XMPPStartTLSHandshake opens an XMPP stream to domain, negotiates STARTTLS
+// if it is advertised in the stream features and performs the TLS handshake
+// after a proceed. A failure response is returned as a *StartTLSRefusedError.
+func (c *Conn) XMPPStartTLSHandshake(domain string) error {
+	if err := checkArgument("XMPP domain", domain); err != nil {
+		return err
+	}
+	c.grabData.XMPP = new(xmpp.XMPPLog)
+
+	start := time.Now()
+	err := xmpp.GetXMPPFeatures(c.grabData.XMPP, c.getUnderlyingConn(), domain)
+	c.recordOperation(OperationBanner, start)
+	if err != nil {
+		return err
+	}
+	if !c.grabData.XMPP.StartTLS {
+		return errors.New("Server did not offer STARTTLS")
+	}
+
+	start = time.Now()
+	proceed, err := xmpp.SetupStartTLS(c.grabData.XMPP, c.getUnderlyingConn())
+	c.recordOperation(OperationStartTLS, start)
+	if err != nil {
+		return err
+	}
+	if !proceed {
+		return &StartTLSRefusedError{Protocol: "XMPP", Response: c.grabData.XMPP.StartTLSResp}
+	}
+	return c.TLSHandshake()
+}
+
+// LDAPStartTLSHandshake sends the StartTLS extended operation and performs
+// the TLS handshake on resultCode success. A non-zero resultCode is returned
+// as a *StartTLSRefusedError, an undecodable reply as a
+// *ldap.MalformedResponseError.
+func (c *Conn) LDAPStartTLSHandshake() error {
+	c.grabData.LDAP = new(ldap.LDAPLog)
+
+	start := time.Now()
+	success, err := ldap.SetupStartTLS(c.grabData.LDAP, c.getUnderlyingConn())
+	c.recordOperation(OperationStartTLS, start)
+	if err != nil {
+		return err
+	}
+	if !success {
+		return &StartTLSRefusedError{
+			Protocol: "LDAP",
+			Response: fmt.Sprintf("resultCode %d %s", *c.grabData.LDAP.ResultCode, c.grabData.LDAP.DiagnosticMessage),
+		}
+	}
+	return c.TLSHandshake()
+}
+
+// LDAPRootDSE performs an anonymous search of the rootDSE, inside the TLS
+// session when LDAPStartTLSHandshake or --tls ran first
+func (c *Conn) LDAPRootDSE() error {
+	messageID := 1
+	if c.grabData.LDAP == nil {
+		c.grabData.LDAP = new(ldap.LDAPLog)
+	} else if c.grabData.LDAP.StartTLSRequest != nil {
+		messageID = 2
+	}
+
+	defer c.recordOperation(OperationLDAPRootDSE, time.Now())
+	return ldap.SearchRootDSE(c.grabData.LDAP, c.getUnderlyingConn(), messageID)
+}
+
+// PostgresStartTLSHandshake sends an SSLRequest and performs the TLS
+// handshake if the server accepts it. A server without SSL support is
+// recorded in the log and is not an error.
+func (c *Conn) PostgresStartTLSHandshake() error {
+	c.grabData.Postgres = new(postgres.PostgresLog)
+
+	start := time.Now()
+	ok, err := postgres.SetupSSL(c.grabData.Postgres, c.getUnderlyingConn())
+	c.recordOperation(OperationStartTLS, start)
+	if err != nil || !ok {
+		return err
+	}
+	return c.TLSHandshake()
+}
+
+// retryConn returns a new connection for another attempt after the server
+// refused the one on prev, which is closed unless it is c
+func (c *Conn) retryConn(prev *Conn) (*Conn, error) {
+	if prev != c {
+		prev.Close()
+	}
+	if c.redial == nil {
+		return nil, errors.New("No redialer set for the retry")
+	}
+	return c.redial()
+}
+
+// PostgresStartup sends a StartupMessage for a throwaway user and records
+// the authentication request or error that comes back. A refused protocol
+// version is retried with the next of postgres.StartupVersions on a new
+// connection.
+func (c *Conn) PostgresStartup() error {
+	log := new(postgres.StartupLog)
+	c.grabData.Postgres = &postgres.PostgresLog{Startup: log}
+
+	conn := c
+	defer func() {
+		if conn != nil && conn != c {
+			conn.Close()
+		}
+	}()
+	for i, version := range postgres.StartupVersions {
+		if i > 0 {
+			var err error
+			if conn, err = c.retryConn(conn); err != nil {
+				return err
+			}
+		}
+		start := time.Now()
+		err := postgres.Startup(log, conn.getUnderlyingConn(), version)
+		c.recordOperation(OperationPostgresStartup, start)
+		if _, refused := err.(*postgres.VersionError); !refused || i == len(postgres.StartupVersions)-1 {
+			return err
+		}
+	}
+	return nil
+}
+
+// CassandraOptions sends a native protocol OPTIONS request and records the
+// SUPPORTED reply, retrying with lower protocol versions on new connections
+// as the server refuses them
+func (c *Conn) CassandraOptions() error {
+	log := new(cassandra.Log)
+	c.grabData.Cassandra = log
+
+	conn := c
+	defer func() {
+		if conn != nil && conn != c {
+			conn.Close()
+		}
+	}()
+	for version, retries := cassandra.MaxVersion, 0; ; version, retries = version-1, retries+1 {
+		if retries > 0 {
+			var err error
+			if conn, err = c.retryConn(conn); err != nil {
+				return err
+			}
+		}
+		start := time.Now()
+		err := cassandra.Options(log, conn.getUnderlyingConn(), version)
+		c.recordOperation(OperationCassandraOptions, start)
+		if _, refused := err.(*cassandra.VersionError); !refused || retries == cassandra.MaxRetries || version == cassandra.MinVersion {
+			return err
+		}
+	}
+}
+
+// AMQPStart sends the AMQP 0-9-1 protocol header and records the broker's
+// Connection.Start
+func (c *Conn) AMQPStart() error {
+	c.grabData.AMQP = new(amqp.AMQPLog)
+
+	defer c.recordOperation(OperationAMQPStart, time.Now())
+	return amqp.GetConnectionStart(c.grabData.AMQP, c.getUnderlyingConn())
+}
+
+// MQTTConnect sends an anonymous MQTT CONNECT and records the CONNACK. Over
+// --tls this runs inside the TLS session.
+func (c *Conn) MQTTConnect() error {
+	c.grabData.MQTT = new(mqtt.MQTTLog)
+
+	defer c.recordOperation(OperationMQTTConnect, time.Now())
+	return mqtt.Connect(c.grabData.MQTT, c.getUnderlyingConn())
+}
+
+// MongoDBInfo identifies a MongoDB server from its replies to isMaster and
+// buildInfo
+func (c *Conn) MongoDBInfo() error {
+	c.grabData.MongoDB = new(mongodb.MongoDBLog)
+
+	defer c.recordOperation(OperationMongoDB, time.Now())
+	return mongodb.GetMongoDBInfo(c.grabData.MongoDB, c.getUnderlyingConn())
+}
+
+// VNCHandshake reads the RFB version and security types and, if upgradeTLS
+// is set and the server offers VeNCrypt or TLS, performs the TLS handshake
+// so the certificate is recorded. The client never authenticates.
+func (c *Conn) VNCHandshake(upgradeTLS bool) error {
+	c.grabData.VNC = new(vnc.VNCLog)
+
+	start := time.Now()
+	err := vnc.GetVNCBanner(c.grabData.VNC, c.getUnderlyingConn())
+	c.recordOperation(OperationBanner, start)
+	if err != nil || !upgradeTLS {
+		return err
+	}
+
+	start = time.Now()
+	ok, err := vnc.SetupTLS(c.grabData.VNC, c.getUnderlyingConn())
+	c.recordOperation(OperationStartTLS, start)
+	if err != nil || !ok {
+		return err
+	}
+	return c.TLSHandshake()
+}
+
+// RDPHandshake sends an RDP connection request offering TLS and CredSSP and,
+// if the server selects either, performs the TLS handshake that carries its
+// certificate
+func (c *Conn) RDPHandshake() error {
+	c.grabData.RDP = new(rdp.RDPLog)
+
+	start := time.Now()
+	ok, err := rdp.Negotiate(c.grabData.RDP, c.getUnderlyingConn())
+	c.recordOperation(OperationStartTLS, start)
+	if err != nil || !ok {
+		return err
+	}
+	return c.TLSHandshake()
+}
+
+// SMBNegotiate offers SMB1 and SMB2 dialects and records which the server
+// picks and whether it requires signing
+func (c *Conn) SMBNegotiate() error {
+	c.grabData.SMB = new(smb.SMBLog)
+
+	start := time.Now()
+	err := smb.Negotiate(c.grabData.SMB, c.getUnderlyingConn())
+	c.recordOperation(OperationSMBNegotiate, start)
+	return err
+}
+
+// MySQLHandshake reads the initial handshake packet and, if upgradeTLS is
+// set and the server advertises CLIENT_SSL, sends an SSLRequest and performs
+// the TLS handshake
+func (c *Conn) MySQLHandshake(upgradeTLS bool) error {
+	c.grabData.MySQL = new(mysql.MySQLLog)
+
+	start := time.Now()
+	err := mysql.GetMySQLBanner(c.grabData.MySQL, c.getUnderlyingConn())
+	c.recordOperation(OperationBanner, start)
+	if err != nil || !upgradeTLS || !c.grabData.MySQL.SupportsTLS {
+		return err
+	}
+
+	start = time.Now()
+	err = mysql.SendSSLRequest(c.grabData.MySQL, c.getUnderlyingConn())
+	c.recordOperation(OperationStartTLS, start)
+	if err != nil {
+		return err
+	}
+	return c.TLSHandshake()
+}
+
+// SSLv2Probe sends an SSLv2 CLIENT-HELLO and records the SERVER-HELLO. Once
+// the connection has been used for TLS, the probe runs on a new connection
+// from the redialer instead.
+func (c *Conn) SSLv2Probe() error {
+	conn := c
+	if c.tlsConn != nil {
+		if c.redial == nil {
+			return errors.New("No redialer set for the SSLv2 probe")
+		}
+		second, err := c.redial()
+		if err != nil {
+			return err
+		}
+		defer second.Close()
+		conn = second
+	}
+
+	defer c.recordOperation(OperationSSLv2, time.Now())
+	c.grabData.SSLv2 = new(sslv2.SSLv2Log)
+	return sslv2.Probe(c.grabData.SSLv2, conn.getUnderlyingConn())
+}
+
 func (c *Conn) SSHHandshake() error {
 	config := c.sshScan.MakeConfig()
 	client := ssh.Client(c.conn, config)
@@ -606,3 +1502,30 @@ func (c *Conn) SSHHandshake() error {
 	c.grabData.SSH = handshakeLog
 	return err
 }
+
+// XSSHHandshake exchanges identification strings and KEXINIT with the server
+// using the x/crypto based scanner, recording the server's algorithm lists
+// and host key. The connection is never authenticated.
+func (c *Conn) XSSHHandshake() error {
+	defer c.recordOperation(OperationXSSHHandshake, time.Now())
+	return c.xsshHandshake(xssh.MakeXSSHConfig())
+}
+
+// XSSHAuthMethodsProbe performs the XSSHHandshake, then sends a single
+// userauth request with method "none" for user and records whether the
+// server granted access or which methods may continue. No password or key
+// is ever sent.
+func (c *Conn) XSSHAuthMethodsProbe(user string) error {
+	defer c.recordOperation(OperationXSSHUserAuth, time.Now())
+	config := xssh.MakeXSSHConfig()
+	config.User = user
+	config.ProbeNoneAuth = true
+	return c.xsshHandshake(config)
+}
+
+func (c *Conn) xsshHandshake(config *xssh.ClientConfig) error {
+	config.ConnLog = new(xssh.HandshakeLog)
+	c.grabData.XSSH = config.ConnLog
+	_, _, _, err := xssh.NewClientConn(c.getUnderlyingConn(), c.RemoteAddr().String(), config)
+	return err
+}
@@ -0,0 +1,2033 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/http"
+	"gopkg.in/eniac/zgrab.v0/ztools/http/httptest"
+	"gopkg.in/eniac/zgrab.v0/ztools/keys"
+	"gopkg.in/eniac/zgrab.v0/ztools/ldap"
+	"gopkg.in/eniac/zgrab.v0/ztools/telnet"
+	"gopkg.in/eniac/zgrab.v0/ztools/xssh"
+	"gopkg.in/eniac/zgrab.v0/ztools/xssh/testdata"
+	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
+)
+
+// pipeConn returns a Conn wrapping one end of an in-memory pipe, and the
+// other end for the test to act as the server.
+func pipeConn() (*Conn, net.Conn) {
+	client, server := net.Pipe()
+	return &Conn{conn: client}, server
+}
+
+func TestReadReusedBufferIsCopied(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	banner := "* OK IMAP4rev1 Service Ready\r\n"
+	capability := "* CAPABILITY IMAP4rev1 STARTTLS\r\n"
+	go func() {
+		server.Write([]byte(banner))
+		server.Write([]byte(capability))
+	}()
+
+	buf := make([]byte, 512)
+	if _, err := c.IMAPBanner(buf); err != nil {
+		t.Fatalf("IMAPBanner: %s", err.Error())
+	}
+	if _, err := c.Read(buf); err != nil {
+		t.Fatalf("Read: %s", err.Error())
+	}
+	if c.grabData.Banner != banner {
+		t.Errorf("Banner overwritten - expected: %q, got: %q", banner, c.grabData.Banner)
+	}
+	if c.grabData.Read != capability {
+		t.Errorf("Wrong read - expected: %q, got: %q", capability, c.grabData.Read)
+	}
+}
+
+func TestWritePayloadsHashed(t *testing.T) {
+	payload := bytes.Repeat([]byte{0x18, 0x03, 0x02}, 1000)
+	sum := sha256.Sum256(payload)
+
+	c := &Conn{conn: new(writeRecorder)}
+	c.Write(payload)
+	if op := c.Operations()[0]; c.grabData.Write != string(payload) || op.PayloadLength != 0 || op.PayloadSHA256 != "" {
+		t.Errorf("Payload not recorded by default: %d bytes, operation %+v", len(c.grabData.Write), op)
+	}
+
+	c = &Conn{conn: new(writeRecorder)}
+	c.SetRecordWritePayloads(false)
+	c.Write(payload)
+	c.BannerProbe(payload, nil, 0, 0)
+	if c.grabData.Write != "" || c.grabData.BannerProbe.Sent != "" {
+		t.Errorf("Payload recorded with payload recording off")
+	}
+	ops := c.Operations()
+	if len(ops) != 2 {
+		t.Fatalf("Expected write and banner probe operations, got %d", len(ops))
+	}
+	for _, op := range ops {
+		if op.PayloadLength != len(payload) || op.PayloadSHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("Wrong %s digest: %d bytes, %s", op.Type, op.PayloadLength, op.PayloadSHA256)
+		}
+	}
+	out, _ := json.Marshal(ops[0])
+	if !strings.Contains(string(out), `"length":3000`) || !strings.Contains(string(out), `"sha256":"`+hex.EncodeToString(sum[:])+`"`) {
+		t.Errorf("Digest missing from JSON: %s", out)
+	}
+}
+
+func TestSMTPBannerLargerThanBuffer(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	banner := ""
+	for i := 0; i < 40; i++ {
+		banner += "220-mx.example.com ESMTP multi-line greeting, line padding\r\n"
+	}
+	banner += "220 mx.example.com ready\r\n"
+	go server.Write([]byte(banner))
+
+	got, err := c.SMTPBanner(make([]byte, 64))
+	if err != nil {
+		t.Fatalf("SMTPBanner: %s", err.Error())
+	}
+	if got != banner {
+		t.Errorf("Banner truncated - expected %d bytes, got %d", len(banner), len(got))
+	}
+	if c.grabData.Banner != banner {
+		t.Errorf("Recorded banner truncated - expected %d bytes, got %d", len(banner), len(c.grabData.Banner))
+	}
+}
+
+func TestSMTPBannerRespectsReadLimit(t *testing.T) {
+	c, server := pipeConn()
+	c.SetMaxReadBytes(4096)
+	defer c.Close()
+	defer server.Close()
+
+	// A greeting that never terminates
+	go func() {
+		line := []byte("220-" + strings.Repeat("x", 60) + "\r\n")
+		for {
+			if _, err := server.Write(line); err != nil {
+				return
+			}
+		}
+	}()
+
+	banner, err := c.SMTPBanner(make([]byte, 256))
+	if err != ErrReadLimitExceeded {
+		t.Fatalf("Expected ErrReadLimitExceeded, got: %v", err)
+	}
+	if len(banner) != 4096 {
+		t.Errorf("Wrong banner length - expected: 4096, got: %d", len(banner))
+	}
+	ops := c.grabData.Operations
+	if len(ops) != 1 || !ops[0].Truncated {
+		t.Errorf("Operation not flagged as truncated")
+	}
+}
+
+func TestContextCancelAbortsRead(t *testing.T) {
+	c, server := pipeConn()
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.WithContext(ctx)
+	defer c.Close()
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := c.SMTPBanner(make([]byte, 256))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Read not aborted promptly, took %s", elapsed)
+	}
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got: %v", err)
+	}
+	ops := c.grabData.Operations
+	if len(ops) != 2 || ops[1].Type != OperationCanceled {
+		t.Errorf("Canceled operation not recorded: %+v", ops)
+	}
+}
+
+// The HTTP transport closes the socket it was dialed, never the Conn
+func TestTransportConnStopsContextWatch(t *testing.T) {
+	c, server := pipeConn()
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.WithContext(ctx)
+
+	c.transportConn().Close()
+	select {
+	case <-c.stopWatch:
+	default:
+		t.Errorf("Context watch still running after the transport closed its socket")
+	}
+}
+
+func TestSMTPHelpMultiline(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	help := "214-Commands supported:\r\n"
+	for i := 0; i < 20; i++ {
+		help += "214-  HELO EHLO MAIL RCPT DATA RSET NOOP QUIT HELP VRFY EXPN\r\n"
+	}
+	help += "214 End of HELP info\r\n"
+	go func() {
+		cmd := make([]byte, 64)
+		server.Read(cmd)
+		server.Write([]byte(help))
+	}()
+
+	if err := c.SMTPHelp(); err != nil {
+		t.Fatalf("SMTPHelp: %s", err.Error())
+	}
+	if c.grabData.SMTPHelp.Response != help {
+		t.Errorf("Help truncated - expected %d bytes, got %d", len(help), len(c.grabData.SMTPHelp.Response))
+	}
+}
+
+func TestEHLOExtensions(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		cmd := make([]byte, 64)
+		server.Read(cmd)
+		// Large responses arrive in several segments
+		server.Write([]byte("250-mail.example.com Hello\r\n250-SIZE 10485760\r\n250-AU"))
+		server.Write([]byte("TH PLAIN LOGIN\r\n250-8BITMIME\r\n250-PIPELINING\r\n250 STARTTLS\r\n"))
+	}()
+
+	if err := c.EHLO("scanner.example.com"); err != nil {
+		t.Fatalf("EHLO: %s", err.Error())
+	}
+	want := []SMTPExtension{
+		{Keyword: "SIZE", Parameters: []string{"10485760"}},
+		{Keyword: "AUTH", Parameters: []string{"PLAIN", "LOGIN"}},
+		{Keyword: "8BITMIME"},
+		{Keyword: "PIPELINING"},
+		{Keyword: "STARTTLS"},
+	}
+	got := c.grabData.EHLOExtensions
+	if len(got) != len(want) {
+		t.Fatalf("Wrong number of extensions - expected: %d, got: %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].Keyword != want[i].Keyword || strings.Join(got[i].Parameters, " ") != strings.Join(want[i].Parameters, " ") {
+			t.Errorf("Wrong extension %d - expected: %+v, got: %+v", i, want[i], *got[i])
+		}
+	}
+}
+
+func TestEHLOFallsBackToHELO(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	commands := make(chan string, 2)
+	go func() {
+		r := bufio.NewReader(server)
+		line, _ := r.ReadString('\n')
+		commands <- line
+		server.Write([]byte("502 Command not implemented\r\n"))
+		line, _ = r.ReadString('\n')
+		commands <- line
+		server.Write([]byte("250 mail.example.com\r\n"))
+	}()
+
+	if err := c.EHLO("scanner.example.com"); err != nil {
+		t.Fatalf("EHLO: %s", err.Error())
+	}
+	if ehlo, helo := <-commands, <-commands; ehlo != "EHLO scanner.example.com\r\n" || helo != "HELO scanner.example.com\r\n" {
+		t.Errorf("Wrong commands %q, %q", ehlo, helo)
+	}
+	if c.grabData.SMTPGreeting != SMTPGreetingHELO || c.grabData.HELO != "250 mail.example.com\r\n" || len(c.grabData.EHLOExtensions) != 0 {
+		t.Errorf("Wrong greeting %q: %q", c.grabData.SMTPGreeting, c.grabData.HELO)
+	}
+	if ops := c.Operations(); len(ops) != 2 || ops[0].Type != OperationEHLO || ops[1].Type != OperationHELO {
+		t.Errorf("EHLO and HELO not recorded separately: %+v", ops)
+	}
+}
+
+func TestSMTPArgumentInjectionRejected(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	if err := c.EHLO("example.com\r\nMAIL FROM:<a@example.com>"); err == nil {
+		t.Errorf("EHLO domain with CRLF accepted")
+	}
+	if err := c.SMTPVrfy("root\nRSET"); err == nil {
+		t.Errorf("VRFY argument with LF accepted")
+	}
+	if ops := c.Operations(); len(ops) != 0 {
+		t.Errorf("Commands sent for rejected arguments: %+v", ops)
+	}
+}
+
+func TestSMTPAuthProbePlainOverPlaintext(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		cmd := make([]byte, 64)
+		server.Read(cmd)
+		server.Write([]byte("250-mail.example.com Hello\r\n250-AUTH=LOGIN\r\n250 AUTH LOGIN PLAIN\r\n"))
+	}()
+
+	if err := c.SMTPAuthProbe("scanner.example.com"); err != nil {
+		t.Fatalf("SMTPAuthProbe: %s", err.Error())
+	}
+	auth := c.grabData.SMTPAuth
+	if got := strings.Join(auth.PlaintextMechanisms, " "); got != "LOGIN PLAIN" {
+		t.Errorf("Wrong plaintext mechanisms - expected: %s, got: %s", "LOGIN PLAIN", got)
+	}
+	if !auth.PlainOverPlaintext {
+		t.Errorf("AUTH PLAIN over plaintext was not flagged")
+	}
+	if auth.StartTLSOffered || auth.TLSEHLO != "" || c.isTls {
+		t.Errorf("STARTTLS attempted although it was not advertised")
+	}
+}
+
+func TestSMTPVrfyAndExpnClassified(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		cmd := make([]byte, 64)
+		server.Read(cmd)
+		server.Write([]byte("252 2.1.5 Cannot VRFY user, but will accept message\r\n"))
+		server.Read(cmd)
+		server.Write([]byte("250-Alice <alice@example.com>\r\n"))
+		server.Write([]byte("250 Bob <bob@example.com>\r\n"))
+	}()
+
+	if err := c.SMTPVrfy("postmaster"); err != nil {
+		t.Fatalf("SMTPVrfy: %s", err.Error())
+	}
+	if v := c.grabData.SMTPVrfy; v.Code != 252 || v.Result != SMTPProbeDisabled {
+		t.Errorf("Wrong VRFY result - expected: 252 %s, got: %d %s", SMTPProbeDisabled, v.Code, v.Result)
+	}
+	if err := c.SMTPExpn("staff"); err != nil {
+		t.Fatalf("SMTPExpn: %s", err.Error())
+	}
+	e := c.grabData.SMTPExpn
+	if e.Code != 250 || e.Result != SMTPProbeAllowed {
+		t.Errorf("Wrong EXPN result - expected: 250 %s, got: %d %s", SMTPProbeAllowed, e.Code, e.Result)
+	}
+	if !strings.HasSuffix(e.Response, "250 Bob <bob@example.com>\r\n") {
+		t.Errorf("Multi-line EXPN response not read completely: %q", e.Response)
+	}
+}
+
+func TestQuitWaitsForReplyAndCloses(t *testing.T) {
+	c, server := pipeConn()
+	defer server.Close()
+
+	closed := make(chan error, 1)
+	go func() {
+		cmd := make([]byte, 64)
+		server.Read(cmd)
+		server.Write([]byte("221 2.0.0 Bye\r\n"))
+		_, err := server.Read(cmd)
+		closed <- err
+	}()
+
+	c.SetQuitTimeout(time.Second)
+	if err := c.Quit(); err != nil {
+		t.Fatalf("Quit: %s", err.Error())
+	}
+	q := c.grabData.Quit
+	if q.Command != "QUIT" || q.Response != "221 2.0.0 Bye\r\n" || q.Error != "" {
+		t.Errorf("Wrong quit event: %+v", *q)
+	}
+	if err := <-closed; err != io.EOF {
+		t.Errorf("Connection not closed after Quit - expected: %v, got: %v", io.EOF, err)
+	}
+}
+
+func TestQuitWriteErrorIsRecorded(t *testing.T) {
+	c, server := pipeConn()
+	server.Close()
+
+	c.SetGoodbye(IMAPGoodbye)
+	if err := c.Quit(); err != nil {
+		t.Errorf("Quit failed on write error: %s", err.Error())
+	}
+	q := c.grabData.Quit
+	if q.Command != "a001 LOGOUT" || q.Error == "" || q.ErrorClass == "" {
+		t.Errorf("Write error not recorded: %+v", *q)
+	}
+}
+
+func TestBannerProbeMatchesAcrossReads(t *testing.T) {
+	c, server := pipeConn()
+	defer server.Close()
+
+	go func() {
+		cmd := make([]byte, 64)
+		server.Read(cmd)
+		server.Write([]byte("+OK POP3 "))
+		server.Write([]byte("ready\r\nextra"))
+	}()
+
+	until := regexp.MustCompile(`^\+OK.*\r\n`)
+	if err := c.BannerProbe([]byte("CAPA\r\n"), until, 1024, time.Second); err != nil {
+		t.Fatalf("BannerProbe: %s", err.Error())
+	}
+	p := c.grabData.BannerProbe
+	if p.Sent != "CAPA\r\n" || p.StopReason != BannerProbeMatched || p.Response != "+OK POP3 ready\r\nextra" {
+		t.Errorf("Wrong banner probe: %+v", *p)
+	}
+	if !c.readDeadline.IsZero() {
+		t.Errorf("Probe timeout left behind as the read deadline: %s", c.readDeadline)
+	}
+}
+
+func TestBannerProbeStopsAtLimits(t *testing.T) {
+	c, server := pipeConn()
+	defer server.Close()
+	go server.Write([]byte("Escape character is '^]'."))
+
+	// Nothing matches, so the probe runs until the timeout
+	if err := c.BannerProbe(nil, regexp.MustCompile("login: "), 1024, 50*time.Millisecond); err != nil {
+		t.Fatalf("BannerProbe timed out with a partial response: %s", err.Error())
+	}
+	if p := c.grabData.BannerProbe; p.StopReason != BannerProbeTimeout || p.Response != "Escape character is '^]'." {
+		t.Errorf("Wrong banner probe: %+v", *p)
+	}
+
+	go server.Write([]byte("0123456789"))
+	if err := c.BannerProbe(nil, regexp.MustCompile("never"), 4, time.Second); err != nil {
+		t.Fatalf("BannerProbe: %s", err.Error())
+	}
+	if p := c.grabData.BannerProbe; p.StopReason != BannerProbeMaxBytes || p.Response != "0123" {
+		t.Errorf("Wrong banner probe: %+v", *p)
+	}
+
+	// The rest of the write is still pending; a nil pattern reads once
+	if err := c.BannerProbe(nil, nil, 1024, time.Second); err != nil {
+		t.Fatalf("BannerProbe: %s", err.Error())
+	}
+	if p := c.grabData.BannerProbe; p.StopReason != BannerProbeReadOnce || p.Response != "456789" {
+		t.Errorf("Wrong banner probe: %+v", *p)
+	}
+
+	server.Close()
+	if err := c.BannerProbe(nil, nil, 1024, time.Second); err != io.EOF {
+		t.Errorf("Expected EOF on an empty response, got: %v", err)
+	}
+	if p := c.grabData.BannerProbe; p.StopReason != BannerProbeClosed {
+		t.Errorf("Wrong stop reason - expected: %s, got: %s", BannerProbeClosed, p.StopReason)
+	}
+}
+
+func TestTelnetBannerStripsNegotiation(t *testing.T) {
+	c, server := pipeConn()
+	defer server.Close()
+
+	replies := make(chan []byte, 1)
+	go func() {
+		// DO terminal type, WILL echo, then a subnegotiation split across
+		// writes followed by the banner with an escaped 0xff
+		server.Write([]byte{telnet.IAC, telnet.DO, 24, telnet.IAC, telnet.WILL, 1})
+		reply := make([]byte, 64)
+		n, _ := server.Read(reply)
+		replies <- reply[0:n]
+		server.Write([]byte{telnet.IAC, telnet.SB, 24, 1, telnet.IAC})
+		server.Write(append([]byte{telnet.SE, 'h', 'i', telnet.IAC, telnet.IAC}, "\r\nlogin: "...))
+	}()
+
+	if err := c.TelnetBanner(65536); err != nil {
+		t.Fatalf("TelnetBanner: %s", err.Error())
+	}
+	want := []byte{telnet.IAC, telnet.WONT, 24, telnet.IAC, telnet.DONT, 1}
+	if reply := <-replies; !bytes.Equal(reply, want) {
+		t.Errorf("Wrong negotiation reply - expected: %v, got: %v", want, reply)
+	}
+	log := c.grabData.Telnet
+	if log.Banner != "hi\xff\r\nlogin: " {
+		t.Errorf("IAC sequences not stripped from banner: %q", log.Banner)
+	}
+	if len(log.Do) != 1 || log.Do[0] != 24 || len(log.Will) != 1 || log.Will[0] != 1 {
+		t.Errorf("Wrong negotiated options: do %v, will %v", log.Do, log.Will)
+	}
+}
+
+func TestTelnetBannerNegotiationIsCapped(t *testing.T) {
+	c, server := pipeConn()
+	defer server.Close()
+
+	go func() {
+		reply := make([]byte, 64)
+		for i := 0; i < telnet.MAX_NEGOTIATION_ROUNDS; i++ {
+			server.Write([]byte{telnet.IAC, telnet.DO, byte(i)})
+			server.Read(reply)
+		}
+	}()
+
+	if err := c.TelnetBanner(65536); err == nil {
+		t.Errorf("Endless negotiation not cut off")
+	}
+	if n := len(c.grabData.Telnet.Do); n != telnet.MAX_NEGOTIATION_ROUNDS {
+		t.Errorf("Wrong number of recorded options - expected: %d, got: %d", telnet.MAX_NEGOTIATION_ROUNDS, n)
+	}
+}
+
+func TestPOP3StartTLSRefused(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		server.Write([]byte("+OK POP3 ready\r\n"))
+		cmd := make([]byte, 64)
+		server.Read(cmd)
+		server.Write([]byte("-ERR TLS not available\r\n"))
+	}()
+
+	err := c.POP3StartTLSHandshake()
+	refused, ok := err.(*StartTLSRefusedError)
+	if !ok {
+		t.Fatalf("Expected a StartTLSRefusedError, got: %v", err)
+	}
+	if refused.Response != "-ERR TLS not available\r\n" {
+		t.Errorf("Wrong STARTTLS response recorded: %q", refused.Response)
+	}
+	if c.grabData.Banner != "+OK POP3 ready\r\n" {
+		t.Errorf("Greeting not recorded: %q", c.grabData.Banner)
+	}
+	if c.isTls {
+		t.Errorf("TLS handshake attempted after -ERR")
+	}
+}
+
+func TestIMAPStartTLSRefusedAfterUntagged(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		server.Write([]byte("* OK IMAP4rev1 ready\r\n"))
+		cmd := make([]byte, 64)
+		server.Read(cmd)
+		server.Write([]byte("* BYE going away soon\r\n"))
+		server.Write([]byte("a001 NO STARTTLS disabled\r\n"))
+	}()
+
+	err := c.IMAPStartTLSHandshake()
+	refused, ok := err.(*StartTLSRefusedError)
+	if !ok {
+		t.Fatalf("Expected a StartTLSRefusedError, got: %v", err)
+	}
+	if refused.Response != "a001 NO STARTTLS disabled" {
+		t.Errorf("Wrong tagged status recorded: %q", refused.Response)
+	}
+	if c.grabData.StartTLS != "* BYE going away soon\r\na001 NO STARTTLS disabled\r\n" {
+		t.Errorf("Untagged lines not recorded: %q", c.grabData.StartTLS)
+	}
+}
+
+func TestFTPAuthFallsBackToSSL(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		server.Write([]byte("220-Welcome\r\n220 Ready\r\n"))
+		cmd := make([]byte, 64)
+		server.Read(cmd)
+		server.Write([]byte("502 Command not implemented\r\n"))
+		server.Read(cmd)
+		server.Write([]byte("534 Policy requires SSL\r\n"))
+	}()
+
+	is200Banner, err := c.FTPBanner()
+	if err != nil {
+		t.Fatalf("FTPBanner: %s", err.Error())
+	}
+	if !is200Banner || c.grabData.FTP.Banner != "220-Welcome\r\n220 Ready\r\n" {
+		t.Errorf("Multi-line greeting not read: %q", c.grabData.FTP.Banner)
+	}
+	if err := c.GetFTPSCertificates(); err != nil {
+		t.Fatalf("GetFTPSCertificates: %s", err.Error())
+	}
+	if c.grabData.FTP.AuthSSLResp != "534 Policy requires SSL\r\n" {
+		t.Errorf("AUTH SSL fallback not recorded: %q", c.grabData.FTP.AuthSSLResp)
+	}
+	if c.isTls {
+		t.Errorf("TLS handshake attempted without a 234 reply")
+	}
+}
+
+func TestXMPPFeaturesAcrossReads(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	features := []string{
+		"<?xml version='1.0'?><stream:stream from='example.com' id='1' version='1.0' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams'>",
+		"<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls>",
+		"<mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism></mechanisms></stream:features>",
+	}
+	go func() {
+		header := make([]byte, 512)
+		server.Read(header)
+		for _, f := range features {
+			server.Write([]byte(f))
+		}
+		cmd := make([]byte, 128)
+		server.Read(cmd)
+		server.Write([]byte("<failure xmlns='urn:ietf:params:xml:ns:xmpp-tls'/></stream:stream>"))
+	}()
+
+	err := c.XMPPStartTLSHandshake("example.com")
+	if _, ok := err.(*StartTLSRefusedError); !ok {
+		t.Fatalf("Expected a StartTLSRefusedError, got: %v", err)
+	}
+	if !c.grabData.XMPP.StartTLS {
+		t.Errorf("STARTTLS feature not detected")
+	}
+	if c.grabData.XMPP.StreamFeatures != strings.Join(features, "") {
+		t.Errorf("Stream features truncated: %q", c.grabData.XMPP.StreamFeatures)
+	}
+}
+
+func TestLDAPStartTLSResultCode(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	// ExtendedResponse with resultCode 53 (unwillingToPerform)
+	resp := []byte{0x30, 0x0f, 0x02, 0x01, 0x01, 0x78, 0x0a, 0x0a, 0x01, 0x35, 0x04, 0x00, 0x04, 0x03, 'n', 'o', 'p'}
+	go func() {
+		req := make([]byte, 64)
+		server.Read(req)
+		server.Write(resp)
+	}()
+
+	err := c.LDAPStartTLSHandshake()
+	if _, ok := err.(*StartTLSRefusedError); !ok {
+		t.Fatalf("Expected a StartTLSRefusedError, got: %v", err)
+	}
+	if code := c.grabData.LDAP.ResultCode; code == nil || *code != 53 {
+		t.Errorf("Wrong resultCode recorded: %v", code)
+	}
+	if c.grabData.LDAP.DiagnosticMessage != "nop" {
+		t.Errorf("Wrong diagnosticMessage recorded: %q", c.grabData.LDAP.DiagnosticMessage)
+	}
+}
+
+func TestLDAPStartTLSMalformedResponse(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		req := make([]byte, 64)
+		server.Read(req)
+		server.Write([]byte{0x30, 0x05, 0x02, 0x01, 0x01, 0x61, 0x00})
+	}()
+
+	err := c.LDAPStartTLSHandshake()
+	if _, ok := err.(*ldap.MalformedResponseError); !ok {
+		t.Fatalf("Expected a MalformedResponseError, got: %v", err)
+	}
+	if c.grabData.LDAP.ResultCode != nil {
+		t.Errorf("resultCode recorded for a malformed response")
+	}
+}
+
+func TestPostgresSSLNotSupported(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		req := make([]byte, 8)
+		server.Read(req)
+		server.Write([]byte{'N'})
+	}()
+
+	if err := c.PostgresStartTLSHandshake(); err != nil {
+		t.Fatalf("PostgresStartTLSHandshake: %s", err.Error())
+	}
+	if c.grabData.Postgres.SupportsSSL || string(c.grabData.Postgres.SSLResponse) != "N" {
+		t.Errorf("Wrong SSLRequest result recorded: %+v", c.grabData.Postgres)
+	}
+	if c.isTls {
+		t.Errorf("TLS handshake attempted after N")
+	}
+}
+
+func TestMySQLHandshakePacket(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	payload := []byte{0x0a}
+	payload = append(payload, "5.7.33-log\x00"...)
+	payload = append(payload, 0x2a, 0x00, 0x00, 0x00)
+	payload = append(payload, "abcdefgh\x00"...)
+	payload = append(payload, 0xff, 0xff, 0x21, 0x02, 0x00, 0xff, 0x81, 0x15)
+	payload = append(payload, make([]byte, 10)...)
+	payload = append(payload, "ijklmnopqrst\x00"...)
+	payload = append(payload, "mysql_native_password\x00"...)
+	packet := append([]byte{byte(len(payload)), 0x00, 0x00, 0x00}, payload...)
+	go func() {
+		server.Write(packet)
+	}()
+
+	if err := c.MySQLHandshake(false); err != nil {
+		t.Fatalf("MySQLHandshake: %s", err.Error())
+	}
+	log := c.grabData.MySQL
+	if log.ServerVersion != "5.7.33-log" || log.ConnectionID != 42 || log.CharacterSet != 0x21 {
+		t.Errorf("Wrong handshake fields parsed: %+v", log)
+	}
+	if !log.SupportsTLS {
+		t.Errorf("CLIENT_SSL capability not detected")
+	}
+	if log.AuthPluginName != "mysql_native_password" {
+		t.Errorf("Wrong auth plugin parsed: %q", log.AuthPluginName)
+	}
+}
+
+// deadlineConn records the deadlines applied to it
+type deadlineConn struct {
+	net.Conn
+	read, write time.Time
+}
+
+func (d *deadlineConn) SetDeadline(t time.Time) error {
+	d.read, d.write = t, t
+	return d.Conn.SetDeadline(t)
+}
+
+func (d *deadlineConn) SetReadDeadline(t time.Time) error {
+	d.read = t
+	return d.Conn.SetReadDeadline(t)
+}
+
+func (d *deadlineConn) SetWriteDeadline(t time.Time) error {
+	d.write = t
+	return d.Conn.SetWriteDeadline(t)
+}
+
+func TestDeadlinesAfterFailedTLSHandshake(t *testing.T) {
+	c, server := pipeConn()
+	raw := &deadlineConn{Conn: c.conn}
+	c.conn = raw
+	defer c.Close()
+	defer server.Close()
+
+	before := time.Now().Add(time.Minute)
+	c.SetDeadline(before)
+	if !raw.read.Equal(before) || !raw.write.Equal(before) {
+		t.Errorf("Deadline before handshake not applied")
+	}
+
+	go io.Copy(ioutil.Discard, server)
+	go server.Write([]byte("HTTP/1.0 400 Bad Request\r\n\r\n"))
+	if err := c.TLSHandshake(); err == nil {
+		t.Fatalf("Expected TLS handshake to fail")
+	}
+	if c.isTls || c.getUnderlyingConn() != c.conn {
+		t.Errorf("Failed handshake switched to the TLS connection")
+	}
+
+	after := time.Now().Add(2 * time.Minute)
+	c.SetReadDeadline(after)
+	if !raw.read.Equal(after) || !raw.write.Equal(before) {
+		t.Errorf("Deadline after failed handshake not applied to the plain connection")
+	}
+	if err := c.TLSHandshake(); err == nil {
+		t.Errorf("Repeat handshake after failure was attempted")
+	}
+}
+
+func TestDeadlinesAcrossTLSHandshake(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	c := dialTLSTestServer(t, s)
+	raw := &deadlineConn{Conn: c.conn}
+	c.conn = raw
+	defer c.Close()
+
+	before := time.Now().Add(time.Minute)
+	c.SetWriteDeadline(before)
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	if !c.isTls || c.getUnderlyingConn() != c.tlsConn {
+		t.Fatalf("Successful handshake did not switch to the TLS connection")
+	}
+	if !raw.write.Equal(before) {
+		t.Errorf("Deadline set before handshake not reapplied")
+	}
+
+	after := time.Now().Add(2 * time.Minute)
+	c.SetDeadline(after)
+	if !raw.read.Equal(after) || !raw.write.Equal(after) {
+		t.Errorf("Deadline after handshake not applied")
+	}
+}
+
+func dialTLSTestServer(t *testing.T, s *httptest.Server) *Conn {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("Invalid URL %s", s.URL)
+	}
+	d := Dialer{Deadline: time.Now().Add(3 * time.Second)}
+	c, err := d.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatalf("Could not connect to %s: %s", u.Host, err.Error())
+	}
+	return c
+}
+
+func TestTLSVersionBounds(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	c := dialTLSTestServer(t, s)
+	c.SetTLSVersionBounds(ztls.VersionSSL30, ztls.VersionSSL30)
+	if err := c.TLSHandshake(); err == nil {
+		t.Errorf("Expected SSLv3-only handshake to fail")
+	}
+	if c.grabData.TLSHandshake == nil {
+		t.Errorf("Failed handshake was not recorded")
+	}
+	c.Close()
+
+	c = dialTLSTestServer(t, s)
+	c.SetTLSVersionBounds(ztls.VersionTLS10, ztls.VersionTLS12)
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	if v := c.grabData.TLSHandshake.ServerHello.Version; v != ztls.VersionTLS12 {
+		t.Errorf("Wrong negotiated version - expected: %d, got: %d", ztls.VersionTLS12, v)
+	}
+	c.Close()
+}
+
+func TestServerNameIndication(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{"", ""},
+		{"example.com", "example.com"},
+		{"example.com:443", "example.com"},
+		{"192.0.2.1", ""},
+		{"192.0.2.1:443", ""},
+		{"2001:db8::1", ""},
+		{"[2001:db8::1]:443", ""},
+		{"[2001:db8::1]", ""},
+		{"fe80::1%eth0", ""},
+		{"[fe80::1%eth0]:443", ""},
+	}
+	for _, test := range tests {
+		if got := serverNameIndication(test.host); got != test.expected {
+			t.Errorf("serverNameIndication(%q) - expected: %q, got: %q", test.host, test.expected, got)
+		}
+	}
+}
+
+func TestTLSHandshakeRecordsSNI(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	c := dialTLSTestServer(t, s)
+	c.SetDomain("example.com")
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	if name := c.grabData.TLSHandshake.ClientHello.ServerName; name != "example.com" {
+		t.Errorf("Wrong SNI recorded - expected: %s, got: %s", "example.com", name)
+	}
+	c.Close()
+}
+
+func TestTLSHandshakeRecordsOfferedCipherSuites(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	c := dialTLSTestServer(t, s)
+	c.CipherSuites = ztls.CipherSuitePresets["chrome"]
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	offered := c.grabData.TLSHandshake.ClientHello.CipherSuites
+	if len(offered) == 0 {
+		t.Fatalf("No offered cipher suites recorded")
+	}
+	for _, suite := range offered {
+		found := false
+		for _, id := range ztls.ChromeCiphers {
+			if uint16(suite) == id {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Offered unexpected cipher suite %s", suite.String())
+		}
+	}
+	c.Close()
+}
+
+func TestResumptionCheckWithTicket(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	c := dialTLSTestServer(t, s)
+	defer c.Close()
+	c.SetResumptionCheck()
+	c.SetRedialer(func() (*Conn, error) {
+		return dialTLSTestServer(t, s), nil
+	})
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	if err := c.ResumptionCheck(); err != nil {
+		t.Fatalf("ResumptionCheck: %s", err.Error())
+	}
+	r := c.grabData.Resumption
+	if !r.TicketIssued || !r.Resumed || r.Method != ResumptionMethodTicket {
+		t.Errorf("Session not resumed with the ticket: %+v", *r)
+	}
+}
+
+func TestRenegotiationCheckRejected(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	c := dialTLSTestServer(t, s)
+	defer c.Close()
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	if err := c.RenegotiationCheck(); err != nil {
+		t.Fatalf("RenegotiationCheck: %s", err.Error())
+	}
+	r := c.grabData.Renegotiation
+	if !r.SecureRenegotiation {
+		t.Errorf("RFC 5746 extension in the initial handshake not recorded")
+	}
+	// Depending on the server, a refusal is a warning or a fatal alert
+	if (r.Result != ztls.RenegotiationRefused && r.Result != ztls.RenegotiationFatalAlert) || r.Alert == "" {
+		t.Errorf("Renegotiation not rejected: %+v", *r)
+	}
+}
+
+func TestExportProbeRefused(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	c := dialTLSTestServer(t, s)
+	defer c.Close()
+	c.SetExportProbe(ExportProbeRSA)
+	if err := c.TLSHandshake(); err == nil {
+		t.Fatalf("Handshake with only export suites succeeded")
+	}
+	e := c.grabData.ExportCiphers
+	if e == nil || e.ExportRSASupported == nil || *e.ExportRSASupported || e.ExportDHESupported != nil {
+		t.Errorf("Refusal not recorded: %+v", e)
+	}
+}
+
+func TestExportProbeRecordsDHPrime(t *testing.T) {
+	prime := new(big.Int).Lsh(big.NewInt(1), 511)
+	prime.Add(prime, big.NewInt(1))
+	hl := &ztls.ServerHandshake{
+		ServerHello:       &ztls.ServerHello{CipherSuite: ztls.CipherSuite(ztls.DHEExportCiphers[0])},
+		ServerKeyExchange: &ztls.ServerKeyExchange{DHParams: &keys.DHParams{Prime: prime}},
+	}
+	e := exportProbeResult(ExportProbeDHE, ztls.DHEExportCiphers, hl)
+	if e.ExportDHESupported == nil || !*e.ExportDHESupported {
+		t.Fatalf("Negotiated DHE_EXPORT suite not recorded: %+v", e)
+	}
+	if e.DHPrimeBits != 512 || !bytes.Equal(e.DHPrime, prime.Bytes()) {
+		t.Errorf("Wrong DH group - expected 512 bits, got %d", e.DHPrimeBits)
+	}
+}
+
+func TestTLSCertificatesOnly(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	c := dialTLSTestServer(t, s)
+	defer c.Close()
+	c.SetTLSCertificatesOnly()
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	hl := c.grabData.TLSHandshake
+	if hl == nil || hl.ServerCertificates == nil || !hl.Abandoned {
+		t.Fatalf("Abandoned handshake not recorded: %+v", hl)
+	}
+	if c.isTls {
+		t.Errorf("Connection switched to TLS after an abandoned handshake")
+	}
+	ops := c.Operations()
+	if last := ops[len(ops)-1]; last.Type != OperationTLSHandshake || last.InTLS {
+		t.Errorf("Wrong handshake operation: %+v", last)
+	}
+}
+
+func TestTLSFalseStart(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	c := dialTLSTestServer(t, s)
+	defer c.Close()
+	c.SetTLSFalseStart()
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	hl := c.grabData.TLSHandshake
+	if !hl.FalseStart || hl.ServerFinished != nil {
+		t.Fatalf("Handshake not false started: %+v", hl)
+	}
+	if _, err := c.Write([]byte("GET / HTTP/1.0\r\n\r\n")); err != nil {
+		t.Fatalf("Write: %s", err.Error())
+	}
+	if _, err := c.Read(make([]byte, 1024)); err != nil && err != io.EOF {
+		t.Fatalf("Read: %s", err.Error())
+	}
+	if !strings.HasPrefix(c.grabData.Read, "HTTP/1.0 200") || hl.ServerFinished == nil || hl.FalseStartAborted {
+		t.Errorf("Early request not answered: %q, aborted %t", c.grabData.Read, hl.FalseStartAborted)
+	}
+}
+
+func TestCurveEnumeration(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	c := dialTLSTestServer(t, s)
+	defer c.Close()
+	c.SetRedialer(func() (*Conn, error) {
+		return dialTLSTestServer(t, s), nil
+	})
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	curves := []keys.TLSCurveID{keys.Secp256r1, keys.X25519, keys.Secp160r1}
+	if err := c.CurveEnumeration(curves, len(curves)+1); err != nil {
+		t.Fatalf("CurveEnumeration: %s", err.Error())
+	}
+	e := c.grabData.Curves
+	if len(e.Supported) != 2 || e.Supported[0] != keys.Secp256r1 || e.Supported[1] != keys.X25519 {
+		t.Errorf("Wrong supported curves: %v", e.Supported)
+	}
+	if len(e.Rejected) != 1 || e.Rejected[0] != keys.Secp160r1 {
+		t.Errorf("Wrong rejected curves: %v", e.Rejected)
+	}
+	if e.Preferred == nil || e.Connections != 4 {
+		t.Errorf("No preference handshake: %+v", *e)
+	}
+}
+
+func TestCurveEnumerationTLS13(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	dial := func() *Conn {
+		c := dialTLSTestServer(t, s)
+		c.SetTLSVersionBounds(0, ztls.VersionTLS13)
+		return c
+	}
+	c := dial()
+	defer c.Close()
+	c.SetRedialer(func() (*Conn, error) {
+		return dial(), nil
+	})
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	curves := []keys.TLSCurveID{keys.Secp256r1, keys.Secp384r1}
+	if err := c.CurveEnumeration(curves, len(curves)+1); err != nil {
+		t.Fatalf("CurveEnumeration: %s", err.Error())
+	}
+	if e := c.grabData.Curves; len(e.Supported) != 2 {
+		t.Errorf("Curves not found over a TLS 1.3 capable connection: %+v", *e)
+	}
+}
+
+func TestCurveEnumerationConnectionLimit(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	c := dialTLSTestServer(t, s)
+	defer c.Close()
+	c.SetRedialer(func() (*Conn, error) {
+		return dialTLSTestServer(t, s), nil
+	})
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	curves := []keys.TLSCurveID{keys.Secp256r1, keys.Secp384r1, keys.Secp521r1}
+	if err := c.CurveEnumeration(curves, 2); err != nil {
+		t.Fatalf("CurveEnumeration: %s", err.Error())
+	}
+	e := c.grabData.Curves
+	if e.Connections != 2 || len(e.Untested) != 1 || e.Untested[0] != keys.Secp521r1 || e.Preferred != nil {
+		t.Errorf("Connection limit not respected: %+v", *e)
+	}
+}
+
+func TestTLSHandshakeALPN(t *testing.T) {
+	tests := []struct {
+		serverProtos []string
+		negotiated   string
+	}{
+		{[]string{"h2", "http/1.1"}, "h2"},
+		// The server ignores ALPN entirely
+		{[]string{}, ""},
+	}
+	for _, test := range tests {
+		s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		s.TLS = &tls.Config{NextProtos: test.serverProtos}
+		s.StartTLS()
+
+		c := dialTLSTestServer(t, s)
+		c.SetNextProtos([]string{"h2", "http/1.1"})
+		if err := c.TLSHandshake(); err != nil {
+			t.Errorf("TLSHandshake with server protocols %v: %s", test.serverProtos, err.Error())
+		} else if hl := c.grabData.TLSHandshake; hl.NegotiatedProtocol != test.negotiated || hl.ServerHello.ALPNProtocol != test.negotiated {
+			t.Errorf("Wrong negotiated protocol - expected %q, got %q", test.negotiated, hl.NegotiatedProtocol)
+		} else if len(hl.ClientHello.ALPNProtocols) != 2 {
+			t.Errorf("Offered protocols not recorded: %v", hl.ClientHello.ALPNProtocols)
+		}
+		c.Close()
+		s.Close()
+	}
+}
+
+func testSigner(t *testing.T, keyType string) xssh.Signer {
+	signer, err := xssh.ParsePrivateKey(testdata.PEMBytes[keyType])
+	if err != nil {
+		t.Fatalf("ParsePrivateKey: %s", err.Error())
+	}
+	return signer
+}
+
+func xsshServerConfig(signers ...xssh.Signer) *xssh.ServerConfig {
+	serverConfig := &xssh.ServerConfig{NoClientAuth: true, ServerVersion: "SSH-2.0-OpenSSH_7.4"}
+	serverConfig.KeyExchanges = []string{"ecdh-sha2-nistp256"}
+	for _, signer := range signers {
+		serverConfig.AddHostKey(signer)
+	}
+	return serverConfig
+}
+
+// xsshTestServer serves SSH handshakes on a local TCP listener and returns a
+// dialer for it. Both ends send their identification first, so a
+// synchronous pipe would deadlock.
+func xsshTestServer(t *testing.T, serverConfig *xssh.ServerConfig) (func() (*Conn, error), io.Closer) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err.Error())
+	}
+	go func() {
+		for {
+			server, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer server.Close()
+				xssh.NewServerConn(server, serverConfig)
+			}()
+		}
+	}()
+
+	dial := func() (*Conn, error) {
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		c := &Conn{conn: client}
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		return c, nil
+	}
+	return dial, l
+}
+
+func TestXSSHHandshakeRecordsAlgorithmsAndHostKey(t *testing.T) {
+	signer := testSigner(t, "rsa")
+	dial, l := xsshTestServer(t, xsshServerConfig(signer))
+	defer l.Close()
+
+	c, err := dial()
+	if err != nil {
+		t.Fatalf("Dial: %s", err.Error())
+	}
+	defer c.Close()
+	if err := c.XSSHHandshake(); err != nil {
+		t.Fatalf("XSSHHandshake: %s", err.Error())
+	}
+	log := c.grabData.XSSH
+	if log.ServerID == nil || log.ServerID.SoftwareVersion != "OpenSSH_7.4" {
+		t.Errorf("Server identification not recorded: %+v", log.ServerID)
+	}
+	if log.ServerKex == nil || log.AlgorithmSelection == nil {
+		t.Fatalf("Server KEXINIT not recorded")
+	}
+	if log.UserAuth != nil {
+		t.Errorf("User authentication attempted: %v", log.UserAuth)
+	}
+
+	// The host key is logged as part of the key exchange
+	out, err := json.Marshal(log)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err.Error())
+	}
+	fingerprint := sha256.Sum256(signer.PublicKey().Marshal())
+	if !bytes.Contains(out, []byte(`"algorithm":"ssh-rsa"`)) || !bytes.Contains(out, []byte(hex.EncodeToString(fingerprint[:]))) {
+		t.Errorf("Host key not recorded: %s", out)
+	}
+}
+
+func TestXSSHHostKeyEnumeration(t *testing.T) {
+	rsaSigner, ecdsaSigner := testSigner(t, "rsa"), testSigner(t, "ecdsa")
+	dial, l := xsshTestServer(t, xsshServerConfig(rsaSigner, ecdsaSigner))
+	defer l.Close()
+
+	c, err := dial()
+	if err != nil {
+		t.Fatalf("Dial: %s", err.Error())
+	}
+	defer c.Close()
+	c.SetRedialer(dial)
+	if err := c.XSSHHandshake(); err != nil {
+		t.Fatalf("XSSHHandshake: %s", err.Error())
+	}
+	// Pretend the server also advertised a key type it can't sign with
+	kex := c.grabData.XSSH.ServerKex
+	kex.ServerHostKeyAlgos = append(kex.ServerHostKeyAlgos, "ssh-ed25519")
+	algorithms := kex.ServerHostKeyAlgos
+	if len(algorithms) != 3 {
+		t.Fatalf("Wrong advertised host key algorithms: %v", algorithms)
+	}
+
+	if err := c.XSSHHostKeyEnumeration(8); err != nil {
+		t.Fatalf("XSSHHostKeyEnumeration: %s", err.Error())
+	}
+	out := c.grabData.XSSHHostKeys
+	if out.Connections != 3 || len(out.HostKeys) != 3 || out.Untested != nil {
+		t.Fatalf("Wrong enumeration: %+v", *out)
+	}
+	want := map[string]xssh.PublicKey{
+		rsaSigner.PublicKey().Type():   rsaSigner.PublicKey(),
+		ecdsaSigner.PublicKey().Type(): ecdsaSigner.PublicKey(),
+	}
+	for _, r := range out.HostKeys {
+		key, ok := want[r.Algorithm]
+		if !ok {
+			if r.HostKey != nil || r.Error == "" {
+				t.Errorf("Failed exchange for %s not recorded: %+v", r.Algorithm, *r)
+			}
+			continue
+		}
+		fingerprint := sha256.Sum256(key.Marshal())
+		if r.Error != "" || r.HostKey == nil || r.HostKey.Fingerprint != hex.EncodeToString(fingerprint[:]) {
+			t.Errorf("Wrong host key for %s: %+v", r.Algorithm, *r)
+		}
+	}
+
+	if err := c.XSSHHostKeyEnumeration(1); err != nil {
+		t.Fatalf("XSSHHostKeyEnumeration: %s", err.Error())
+	}
+	if out := c.grabData.XSSHHostKeys; out.Connections != 1 || len(out.Untested) != 2 {
+		t.Errorf("Connection limit not respected: %+v", *out)
+	}
+}
+
+func TestXSSHAuthMethodsProbe(t *testing.T) {
+	serverConfig := xsshServerConfig(testSigner(t, "rsa"))
+	serverConfig.NoClientAuth = false
+	serverConfig.PasswordCallback = func(conn xssh.ConnMetadata, password []byte) (*xssh.Permissions, error) {
+		t.Errorf("Password sent by the probe")
+		return nil, errors.New("denied")
+	}
+	dial, l := xsshTestServer(t, serverConfig)
+	defer l.Close()
+
+	c, err := dial()
+	if err != nil {
+		t.Fatalf("Dial: %s", err.Error())
+	}
+	defer c.Close()
+	if err := c.XSSHAuthMethodsProbe("admin"); err != nil {
+		t.Fatalf("XSSHAuthMethodsProbe: %s", err.Error())
+	}
+	probe := c.grabData.XSSH.UserAuthProbe
+	if probe == nil || probe.User != "admin" || probe.Accepted || len(probe.Methods) != 1 || probe.Methods[0] != "password" {
+		t.Errorf("Wrong userauth probe: %+v", probe)
+	}
+
+	// A server with no authentication at all accepts "none"
+	dial, l = xsshTestServer(t, xsshServerConfig(testSigner(t, "rsa")))
+	defer l.Close()
+	if c, err = dial(); err != nil {
+		t.Fatalf("Dial: %s", err.Error())
+	}
+	defer c.Close()
+	if err := c.XSSHAuthMethodsProbe("admin"); err != nil {
+		t.Fatalf("XSSHAuthMethodsProbe: %s", err.Error())
+	}
+	if probe := c.grabData.XSSH.UserAuthProbe; probe == nil || !probe.Accepted {
+		t.Errorf("Accepted none authentication not recorded: %+v", probe)
+	}
+}
+
+// selfSignedCertificate returns an ECDSA certificate and key for name and
+// the PEM encoded certificate
+func selfSignedCertificate(t *testing.T, name string) (ztls.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	cert, err := ztls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, certPEM
+}
+
+func TestCertificateRequestRecorded(t *testing.T) {
+	_, caPEM := selfSignedCertificate(t, "Scan Test CA")
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	s.TLS = &tls.Config{ClientAuth: tls.RequestClientCert, ClientCAs: pool}
+	s.StartTLS()
+	defer s.Close()
+
+	// Without a client certificate an empty one is sent and the request is
+	// still recorded
+	c := dialTLSTestServer(t, s)
+	defer c.Close()
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	cr := c.grabData.TLSHandshake.CertificateRequest
+	if cr == nil {
+		t.Fatalf("CertificateRequest not recorded")
+	}
+	if len(cr.CertificateAuthorities) != 1 || !strings.Contains(cr.CertificateAuthorities[0], "Scan Test CA") {
+		t.Errorf("Wrong acceptable CAs: %v", cr.CertificateAuthorities)
+	}
+	if len(cr.CertificateTypes) == 0 {
+		t.Errorf("Certificate types not recorded")
+	}
+	if cr.ClientCertificateSent || cr.ClientCertificateAccepted {
+		t.Errorf("Client certificate reported without one configured: %+v", cr)
+	}
+}
+
+func TestClientCertificatePresented(t *testing.T) {
+	cert, _ := selfSignedCertificate(t, "zgrab client")
+	peer := make(chan int, 1)
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer <- len(r.TLS.PeerCertificates)
+	}))
+	s.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	s.StartTLS()
+	defer s.Close()
+
+	c := dialTLSTestServer(t, s)
+	defer c.Close()
+	c.SetClientCertificate(&cert)
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	cr := c.grabData.TLSHandshake.CertificateRequest
+	if cr == nil || !cr.ClientCertificateSent || !cr.ClientCertificateAccepted {
+		t.Fatalf("Client certificate not recorded as sent and accepted: %+v", cr)
+	}
+	if _, err := c.Write([]byte("GET / HTTP/1.0\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(c)
+	select {
+	case n := <-peer:
+		if n != 1 {
+			t.Errorf("Server saw %d client certificates", n)
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("Request not served")
+	}
+
+	// The server rejects the handshake when no certificate is sent
+	c = dialTLSTestServer(t, s)
+	defer c.Close()
+	c.TLSHandshake()
+	if cr := c.grabData.TLSHandshake.CertificateRequest; cr == nil || cr.ClientCertificateAccepted {
+		t.Errorf("Wrong state for rejected handshake: %+v", cr)
+	}
+}
+
+func TestDTLSHandshakeRecordsRetransmits(t *testing.T) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	d := Dialer{Deadline: time.Now().Add(3 * time.Second)}
+	c, err := d.Dial("udp", l.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDTLSRetransmission(10*time.Millisecond, 1)
+	if err := c.DTLSHandshake(); err != ztls.ErrDTLSTimeout {
+		t.Fatalf("Expected ErrDTLSTimeout, got %v", err)
+	}
+	if c.grabData.DTLS == nil || c.grabData.DTLS.Retransmits != 1 {
+		t.Errorf("Wrong DTLS log: %+v", c.grabData.DTLS)
+	}
+	if hl := c.grabData.TLSHandshake; hl == nil || hl.ClientHello == nil || len(hl.ClientHello.CipherSuites) == 0 {
+		t.Errorf("ClientHello not recorded: %+v", hl)
+	}
+	ops := c.grabData.Operations
+	if len(ops) == 0 || ops[len(ops)-1].Type != OperationDTLSHandshake {
+		t.Errorf("No %s operation recorded", OperationDTLSHandshake)
+	}
+	if err := c.DTLSHandshake(); err == nil {
+		t.Errorf("Repeat handshake allowed")
+	}
+}
+
+// serveOnce reads one command on server and answers with the replies, each
+// written separately
+func serveOnce(server net.Conn, replies ...string) {
+	cmd := make([]byte, 64)
+	server.Read(cmd)
+	for _, r := range replies {
+		server.Write([]byte(r))
+	}
+}
+
+func TestMemcachedStats(t *testing.T) {
+	c, server := pipeConn()
+	c.SetResponseEncoding(ResponseEncodingUTF8)
+	defer c.Close()
+	defer server.Close()
+	go serveOnce(server, "STAT pid 1234\r\nSTAT version 1.6.21\r\n", "STAT libevent 2.1.12-stable\r\nEND\r\n")
+
+	if err := c.MemcachedStats(); err != nil {
+		t.Fatalf("MemcachedStats: %s", err.Error())
+	}
+	log := c.grabData.Memcached
+	if log.Outcome != KVOutcomeSuccess || len(log.Stats) != 3 || log.Stats["version"] != "1.6.21" || log.Stats["libevent"] != "2.1.12-stable" {
+		t.Errorf("Wrong log: %+v", log)
+	}
+	op := c.grabData.Operations[0]
+	if op.Type != OperationMemcachedStats || !strings.HasSuffix(string(op.Response), "END\r\n") {
+		t.Errorf("Reply not recorded on the operation: %+v", op)
+	}
+}
+
+func TestMemcachedStatsAuthRequired(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+	go serveOnce(server, "CLIENT_ERROR unauthenticated\r\n")
+
+	if err := c.MemcachedStats(); err != nil {
+		t.Fatalf("MemcachedStats: %s", err.Error())
+	}
+	if log := c.grabData.Memcached; log.Outcome != KVOutcomeAuthRequired || log.Stats != nil {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
+
+func TestRedisInfo(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+	info := "# Server\r\nredis_version:7.2.4\r\nos:Linux 6.1.0 x86_64\r\n\r\n# Clients\r\nconnected_clients:3\r\n\r\n# Replication\r\nrole:master\r\n"
+	reply := "$" + strconv.Itoa(len(info)) + "\r\n" + info + "\r\n"
+	go serveOnce(server, reply[:20], reply[20:])
+
+	if err := c.RedisInfo(); err != nil {
+		t.Fatalf("RedisInfo: %s", err.Error())
+	}
+	log := c.grabData.Redis
+	if log.Outcome != KVOutcomeSuccess || log.Version != "7.2.4" || log.OS != "Linux 6.1.0 x86_64" || log.Role != "master" || log.ConnectedClients != 3 {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
+
+func TestRedisInfoAuthRequired(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+	go serveOnce(server, "-NOAUTH Authentication required.\r\n")
+
+	if err := c.RedisInfo(); err != nil {
+		t.Fatalf("RedisInfo: %s", err.Error())
+	}
+	if log := c.grabData.Redis; log.Outcome != KVOutcomeAuthRequired || log.Error != "NOAUTH Authentication required." {
+		t.Errorf("Wrong log: %+v", log)
+	}
+
+	c, server = pipeConn()
+	defer c.Close()
+	defer server.Close()
+	go serveOnce(server, "-DENIED Redis is running in protected mode\r\n")
+	if err := c.RedisInfo(); err == nil || c.grabData.Redis.Outcome != "" {
+		t.Errorf("Protected mode not reported as an error: %v, %+v", err, c.grabData.Redis)
+	}
+}
+
+// modbusServer answers each request with the PDU reply returns for its
+// unit and function, echoing the transaction ID
+func modbusServer(server net.Conn, reply func(unitID, function byte) []byte) {
+	for {
+		req := make([]byte, 256)
+		n, err := server.Read(req)
+		if err != nil || n < 8 {
+			return
+		}
+		pdu := reply(req[6], req[7])
+		res := []byte{req[0], req[1], 0, 0, 0, byte(len(pdu) + 1), req[6]}
+		server.Write(append(res, pdu...))
+	}
+}
+
+func TestModbusIdentifyThroughGateway(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+	go modbusServer(server, func(unitID, function byte) []byte {
+		if unitID == 0 {
+			return []byte{function | 0x80, ExceptionGatewayPathUnavailable}
+		}
+		objects := []byte{0x2B, 0x0E, 0x01, 0x01, 0x00, 0x00, 3}
+		for oid, v := range []string{"Schneider Electric", "BMX P34 2020", "v2.70"} {
+			objects = append(append(objects, byte(oid), byte(len(v))), v...)
+		}
+		return objects
+	})
+
+	if err := c.ModbusIdentify(); err != nil {
+		t.Fatalf("ModbusIdentify: %s", err.Error())
+	}
+	m := c.grabData.Modbus
+	if m.Support != ModbusSupportIdentification || m.VendorName != "Schneider Electric" || m.ProductCode != "BMX P34 2020" || m.Revision != "v2.70" {
+		t.Errorf("Wrong identification: %+v", m)
+	}
+	if len(m.Exchanges) != 2 || m.Exchanges[0].Exception != "gateway_path_unavailable" || m.Exchanges[1].UnitID != 255 || m.UnitID != 255 {
+		t.Errorf("Wrong exchanges: %+v", m.Exchanges)
+	}
+}
+
+func TestModbusIdentifyFallsBackToRegisters(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+	go modbusServer(server, func(unitID, function byte) []byte {
+		if function == byte(FunctionCodeMEI) {
+			return []byte{function | 0x80, ExceptionIllegalFunction}
+		}
+		return []byte{function, 2, 0x12, 0x34}
+	})
+
+	if err := c.ModbusIdentify(); err != nil {
+		t.Fatalf("ModbusIdentify: %s", err.Error())
+	}
+	m := c.grabData.Modbus
+	if m.Support != ModbusSupportRegisters || len(m.HoldingRegisters) != 1 || m.HoldingRegisters[0] != 0x1234 {
+		t.Errorf("Wrong log: %+v", m)
+	}
+	if len(m.Exchanges) != 2 || m.Exchanges[0].Exception != "illegal_function" || m.Exchanges[1].Function != FunctionCodeReadHoldingRegisters {
+		t.Errorf("Wrong exchanges: %+v", m.Exchanges)
+	}
+}
+
+func TestModbusIdentifyTransactionMismatch(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+	go func() {
+		server.Read(make([]byte, 256))
+		server.Write([]byte{0x00, 0x01, 0, 0, 0, 3, 0, 0x83, 0x01})
+	}()
+
+	if err := c.ModbusIdentify(); err == nil || c.grabData.Modbus.Support != "" {
+		t.Errorf("Mismatched transaction accepted: %v, %+v", err, c.grabData.Modbus)
+	}
+}
+
+func TestIMAPCapabilityAroundStartTLS(t *testing.T) {
+	cert, _ := selfSignedCertificate(t, "imap.example.com")
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		r := bufio.NewReader(server)
+		server.Write([]byte("* OK IMAP4rev1 ready\r\n"))
+		r.ReadString('\n')
+		server.Write([]byte("* CAPABILITY IMAP4rev1 STARTTLS\r\n* CAPABILITY LOGINDISABLED\r\n"))
+		server.Write([]byte("a001 OK CAPABILITY completed\r\n"))
+		r.ReadString('\n')
+		server.Write([]byte("a002 OK Begin TLS negotiation now\r\n"))
+		tlsServer := ztls.Server(server, &ztls.Config{Certificates: []ztls.Certificate{cert}})
+		r = bufio.NewReader(tlsServer)
+		r.ReadString('\n')
+		tlsServer.Write([]byte("* CAPABILITY IMAP4rev1 AUTH=PLAIN auth=LOGIN\r\na003 OK done\r\n"))
+	}()
+
+	if err := c.IMAPCapability(); err != nil {
+		t.Fatalf("IMAPCapability: %s", err.Error())
+	}
+	if err := c.IMAPStartTLSHandshake(); err != nil {
+		t.Fatalf("IMAPStartTLSHandshake: %s", err.Error())
+	}
+	if err := c.IMAPCapability(); err != nil {
+		t.Fatalf("IMAPCapability over TLS: %s", err.Error())
+	}
+	plain, tls := c.grabData.IMAPCapability.Plaintext, c.grabData.IMAPCapability.TLS
+	if plain == nil || !plain.StartTLS || !plain.LoginDisabled || len(plain.Capabilities) != 3 || len(plain.AuthMechanisms) != 0 {
+		t.Errorf("Wrong plaintext capabilities: %+v", plain)
+	}
+	if tls == nil || tls.StartTLS || tls.LoginDisabled || strings.Join(tls.AuthMechanisms, " ") != "PLAIN LOGIN" {
+		t.Errorf("Wrong TLS capabilities: %+v", tls)
+	}
+	for _, op := range c.grabData.Operations {
+		if want := op.Type == OperationIMAPCapability && c.grabData.Operations[len(c.grabData.Operations)-1] == op; op.InTLS != want {
+			t.Errorf("Wrong in_tls on %s: %v", op.Type, op.InTLS)
+		}
+	}
+}
+
+func TestIMAPPreauthWithNumberedTags(t *testing.T) {
+	c, server := pipeConn()
+	defer server.Close()
+
+	commands := make(chan string, 2)
+	go func() {
+		r := bufio.NewReader(server)
+		server.Write([]byte("* PREAUTH IMAP4rev1 logged in\r\n"))
+		line, _ := r.ReadString('\n')
+		commands <- line
+		server.Write([]byte("* CAPABILITY IMAP4rev1\r\na001 OK done\r\n"))
+		line, _ = r.ReadString('\n')
+		commands <- line
+		server.Write([]byte("* BYE\r\na002 OK LOGOUT completed\r\n"))
+	}()
+
+	if err := c.IMAPCapability(); err != nil {
+		t.Fatalf("IMAPCapability after PREAUTH: %s", err.Error())
+	}
+	if caps := c.grabData.IMAPCapability.Plaintext; caps == nil || caps.Refused || len(caps.Capabilities) != 1 {
+		t.Errorf("Wrong capabilities: %+v", caps)
+	}
+	c.SetGoodbye(IMAPGoodbye)
+	c.SetQuitTimeout(time.Second)
+	c.Quit()
+	if first, second := <-commands, <-commands; first != "a001 CAPABILITY\r\n" || second != "a002 LOGOUT\r\n" {
+		t.Errorf("Wrong commands: %q, %q", first, second)
+	}
+	if q := c.grabData.Quit; q.Command != "a002 LOGOUT" || q.Response != "* BYE\r\na002 OK LOGOUT completed\r\n" {
+		t.Errorf("Wrong quit log: %+v", *q)
+	}
+}
+
+func TestSMTPOverImplicitTLS(t *testing.T) {
+	cert, _ := selfSignedCertificate(t, "mail.example.com")
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		tlsServer := ztls.Server(server, &ztls.Config{Certificates: []ztls.Certificate{cert}})
+		// The multi-line greeting spans records and exceeds the read buffer
+		tlsServer.Write([]byte("220-mail.example.com ESMTP\r\n"))
+		tlsServer.Write([]byte("220 ready\r\n"))
+		bufio.NewReader(tlsServer).ReadString('\n')
+		tlsServer.Write([]byte("250-mail.example.com\r\n250-SIZE 10485760\r\n250 AUTH PLAIN LOGIN\r\n"))
+	}()
+
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	if banner, err := c.SMTPBanner(make([]byte, 8)); err != nil || banner != "220-mail.example.com ESMTP\r\n220 ready\r\n" {
+		t.Fatalf("Wrong banner %q: %v", banner, err)
+	}
+	if err := c.EHLO("scanner.example.com"); err != nil {
+		t.Fatalf("EHLO: %s", err.Error())
+	}
+	if mechanisms := authMechanisms(c.grabData.EHLOExtensions); strings.Join(mechanisms, " ") != "PLAIN LOGIN" {
+		t.Errorf("Wrong EHLO extensions: %+v", c.grabData.EHLOExtensions)
+	}
+	var states []string
+	for _, op := range c.grabData.Operations {
+		states = append(states, op.Type+":"+strconv.FormatBool(op.InTLS))
+	}
+	if got := strings.Join(states, " "); got != "tls_handshake:false banner:true ehlo:true" {
+		t.Errorf("Wrong operations: %s", got)
+	}
+}
+
+func TestPOP3CapaOverImplicitTLS(t *testing.T) {
+	cert, _ := selfSignedCertificate(t, "pop.example.com")
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		tlsServer := ztls.Server(server, &ztls.Config{Certificates: []ztls.Certificate{cert}})
+		tlsServer.Write([]byte("+OK POP3 ready\r\n"))
+		bufio.NewReader(tlsServer).ReadString('\n')
+		tlsServer.Write([]byte("+OK\r\nUSER\r\nSASL PLAIN\r\n.\r\n"))
+	}()
+
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	if err := c.POP3Capa(); err != nil {
+		t.Fatalf("POP3Capa: %s", err.Error())
+	}
+	if c.grabData.Banner != "+OK POP3 ready\r\n" {
+		t.Errorf("Wrong banner %q", c.grabData.Banner)
+	}
+	if caps := c.grabData.POP3Capa; caps.Plaintext != nil || caps.TLS == nil || !caps.TLS.User {
+		t.Errorf("Wrong capabilities: %+v", caps)
+	}
+}
+
+func TestPOP3CapaMultiline(t *testing.T) {
+	c, server := pipeConn()
+	c.SetResponseEncoding(ResponseEncodingUTF8)
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		server.Write([]byte("+OK Dovecot ready.\r\n"))
+		cmd := make([]byte, 64)
+		server.Read(cmd)
+		// The terminating line arrives in a separate segment
+		server.Write([]byte("+OK\r\nCAPA\r\nTOP\r\nUSER\r\nSASL PLAIN LOGIN\r\n"))
+		server.Write([]byte("STLS\r\nIMPLEMENTATION Dovecot 2.3\r\n.\r\n"))
+	}()
+
+	if err := c.POP3Capa(); err != nil {
+		t.Fatalf("POP3Capa: %s", err.Error())
+	}
+	caps := c.grabData.POP3Capa.Plaintext
+	if caps == nil || !caps.STLS || !caps.User || caps.Implementation != "Dovecot 2.3" || len(caps.Capabilities) != 6 {
+		t.Fatalf("Wrong capabilities: %+v", caps)
+	}
+	if strings.Join(caps.SASLMechanisms, " ") != "PLAIN LOGIN" {
+		t.Errorf("Wrong SASL mechanisms: %v", caps.SASLMechanisms)
+	}
+	if op := c.grabData.Operations[1]; op.Type != OperationPOP3Capa || !strings.HasSuffix(string(op.Response), "\r\n.\r\n") {
+		t.Errorf("Full response not recorded: %+v", op)
+	}
+}
+
+func TestPOP3CapaRefused(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+	go serveOnce(server, "-ERR unknown command\r\n")
+	c.pop3GreetingRead = true
+	c.grabData.Banner = "+OK ready\r\n"
+
+	if err := c.POP3Capa(); err != nil {
+		t.Fatalf("POP3Capa: %s", err.Error())
+	}
+	if caps := c.grabData.POP3Capa.Plaintext; !caps.Refused || len(caps.Capabilities) != 0 {
+		t.Errorf("Wrong capabilities: %+v", caps)
+	}
+}
+
+func TestNNTPBanner(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		server.Write([]byte("201 news.example.com InterNetNews NNRP server ready (no posting)\r\n"))
+		serveOnce(server, "101 Capability list:\r\nVERSION 2\r\n", "READER\r\nIMPLEMENTATION INN 2.6.4\r\n.\r\n")
+		serveOnce(server, "215 Order of fields in overview database.\r\nSubject:\r\nFrom:\r\n..dot:full\r\n.\r\n")
+	}()
+
+	if err := c.NNTPBanner(); err != nil {
+		t.Fatalf("NNTPBanner: %s", err.Error())
+	}
+	log := c.grabData.NNTP
+	if log.PostingAllowed || log.Implementation != "INN 2.6.4" || len(log.CapabilityList) != 3 {
+		t.Errorf("Wrong capabilities: %+v", log)
+	}
+	if strings.Join(log.OverviewFields, " ") != "Subject: From: .dot:full" {
+		t.Errorf("Wrong overview fields: %q", log.OverviewFields)
+	}
+	if len(c.grabData.Operations) != 3 || c.grabData.Operations[2].Type != OperationNNTPOverviewFmt {
+		t.Errorf("Wrong operations: %+v", c.grabData.Operations)
+	}
+}
+
+func TestNNTPBannerCommandsRefused(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		server.Write([]byte("200 Leafnode NNTP daemon, version 1.11, posting ok\r\n"))
+		serveOnce(server, "500 What?\r\n")
+		serveOnce(server, "503 program error, function not performed\r\n")
+	}()
+
+	if err := c.NNTPBanner(); err != nil {
+		t.Fatalf("NNTPBanner: %s", err.Error())
+	}
+	if log := c.grabData.NNTP; !log.PostingAllowed || log.Capabilities != "" || log.OverviewFields != nil {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
+
+func TestNNTPBannerNotNNTP(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+	go server.Write([]byte("400 service temporarily unavailable\r\n"))
+
+	if err := c.NNTPBanner(); err == nil {
+		t.Errorf("Non-greeting accepted: %+v", c.grabData.NNTP)
+	}
+}
+
+func TestIRCBanner(t *testing.T) {
+	c, server := pipeConn()
+	c.SetResponseEncoding(ResponseEncodingUTF8)
+	defer c.Close()
+	defer server.Close()
+
+	done := make(chan []string)
+	go func() {
+		r := bufio.NewReader(server)
+		var sent []string
+		read := func() {
+			line, _ := r.ReadString('\n')
+			sent = append(sent, strings.TrimSpace(line))
+		}
+		read()
+		read()
+		server.Write([]byte(":irc.example.net NOTICE * :*** Looking up your hostname\r\nPING :12345\r\n"))
+		read()
+		server.Write([]byte(":irc.example.net 433 * zgrab :Nickname is already in use\r\n"))
+		read()
+		server.Write([]byte(":irc.example.net 001 zgrab :Welcome to the ExampleNet IRC Network zgrab\r\n" +
+			":irc.example.net 002 zgrab :Your host is irc.example.net, running version InspIRCd-3\r\n" +
+			":irc.example.net 003 zgrab :This server was created 12:00:00 Jan 01 2024\r\n" +
+			":irc.example.net 004 zgrab irc.example.net InspIRCd-3 iosw biklmnopstv :bklov\r\n"))
+		server.Write([]byte(":irc.example.net 005 zgrab CASEMAPPING=rfc1459 CHANTYPES=# EXCEPTS NETWORK=ExampleNet :are supported by this server\r\n" +
+			":irc.example.net 005 zgrab NICKLEN=30 -EXCEPTS :are supported by this server\r\n" +
+			":irc.example.net 376 zgrab :End of message of the day.\r\n"))
+		read()
+		done <- sent
+	}()
+
+	if err := c.IRCBanner(); err != nil {
+		t.Fatalf("IRCBanner: %s", err.Error())
+	}
+	sent := <-done
+	if !strings.HasPrefix(sent[0], "NICK zgrab") || sent[2] != "PONG :12345" || !strings.HasPrefix(sent[4], "QUIT") {
+		t.Errorf("Wrong commands sent: %q", sent)
+	}
+	log := c.grabData.IRC
+	if sent[3] != "NICK "+log.Nick {
+		t.Errorf("Nick not retried: sent %q, recorded %s", sent[3], log.Nick)
+	}
+	if log.ServerName != "irc.example.net" || log.Version != "InspIRCd-3" || log.Network != "ExampleNet" || log.ChanModes != "biklmnopstv" {
+		t.Errorf("Wrong registration numerics: %+v", log)
+	}
+	if _, ok := log.ISupport["EXCEPTS"]; ok || len(log.ISupport) != 4 || log.ISupport["NICKLEN"] != "30" {
+		t.Errorf("Wrong ISUPPORT tokens: %v", log.ISupport)
+	}
+	if op := c.grabData.Operations[0]; op.Type != OperationIRCRegister || !strings.HasSuffix(string(op.Response), "message of the day.\r\n") {
+		t.Errorf("Exchange not recorded: %+v", op)
+	}
+}
+
+func TestIRCBannerError(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		r := bufio.NewReader(server)
+		r.ReadString('\n')
+		r.ReadString('\n')
+		server.Write([]byte("ERROR :Closing link: (zgrab@192.0.2.1) [K-lined]\r\n"))
+		r.ReadString('\n')
+	}()
+
+	if err := c.IRCBanner(); err == nil {
+		t.Fatal("ERROR accepted")
+	}
+	if log := c.grabData.IRC; log.Error != "Closing link: (zgrab@192.0.2.1) [K-lined]" {
+		t.Errorf("Wrong error recorded: %q", log.Error)
+	}
+}
+
+func TestPostgresStartupRetriesOnNewConnection(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+	refusal := "SFATAL\x00C0A000\x00Munsupported frontend protocol 3.2: server supports 1.0 to 3.0\x00\x00"
+	go serveOnce(server, "E\x00\x00\x00"+string([]byte{byte(4 + len(refusal))})+refusal)
+
+	var second net.Conn
+	c.SetRedialer(func() (*Conn, error) {
+		retry, server := pipeConn()
+		second = server
+		go serveOnce(server, "R\x00\x00\x00\x0c\x00\x00\x00\x05salt")
+		return retry, nil
+	})
+	if err := c.PostgresStartup(); err != nil {
+		t.Fatalf("PostgresStartup: %s", err.Error())
+	}
+	defer second.Close()
+	log := c.grabData.Postgres.Startup
+	if log.ProtocolVersion != "3.0" || log.AuthenticationMethod != "md5_password" || len(log.RejectedVersions) != 1 {
+		t.Errorf("Wrong startup log: %+v", log)
+	}
+	if len(c.grabData.Operations) != 2 || c.grabData.Operations[1].Type != OperationPostgresStartup {
+		t.Errorf("Wrong operations: %+v", c.grabData.Operations)
+	}
+}
+
+func TestSMTPHeartbleedStopsAtStartTLS(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		r := bufio.NewReader(server)
+		server.Write([]byte("220 mail.example.com ESMTP\r\n"))
+		r.ReadString('\n')
+		server.Write([]byte("250-mail.example.com\r\n250 STARTTLS\r\n"))
+		r.ReadString('\n')
+		server.Write([]byte("454 TLS not available\r\n"))
+	}()
+
+	err := c.SMTPHeartbleed("scanner.example.com", make([]byte, 64))
+	if fe, ok := err.(*HeartbleedFlowError); !ok || fe.Stage != HeartbleedStageStartTLS {
+		t.Fatalf("Wrong error: %v", err)
+	}
+	var types []string
+	for _, op := range c.Operations() {
+		types = append(types, op.Type)
+	}
+	if got := strings.Join(types, " "); got != "banner ehlo starttls" || c.erroredComponent != "starttls" {
+		t.Errorf("Wrong operations %q or errored component %q", got, c.erroredComponent)
+	}
+	if c.grabData.Heartbleed != nil {
+		t.Errorf("Heartbleed probe sent after a failed STARTTLS")
+	}
+}
+
+func TestIMAPHeartbleedOverStartTLS(t *testing.T) {
+	cert, _ := selfSignedCertificate(t, "imap.example.com")
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		server.Write([]byte("* OK IMAP4rev1 ready\r\n"))
+		bufio.NewReader(server).ReadString('\n')
+		server.Write([]byte("a001 OK Begin TLS negotiation now\r\n"))
+		tlsServer := ztls.Server(server, &ztls.Config{Certificates: []ztls.Certificate{cert}})
+		tlsServer.Handshake()
+	}()
+
+	if err := c.IMAPHeartbleed(make([]byte, 64)); err != nil {
+		t.Fatalf("IMAPHeartbleed: %s", err.Error())
+	}
+	ops := c.Operations()
+	if last := ops[len(ops)-1]; last.Type != OperationHeartbleed || !last.InTLS {
+		t.Errorf("Wrong last operation: %+v", last)
+	}
+	if hb := c.grabData.Heartbleed; hb == nil || hb.Vulnerable {
+		t.Errorf("Wrong Heartbleed log: %+v", hb)
+	}
+}
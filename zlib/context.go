@@ -0,0 +1,111 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// contextConn reports the context's error in place of the error caused by
+// the connection being closed on cancellation
+type contextConn struct {
+	net.Conn
+	ctx context.Context
+}
+
+func (cc *contextConn) Read(b []byte) (int, error) {
+	n, err := cc.Conn.Read(b)
+	if err != nil && cc.ctx.Err() != nil {
+		err = cc.ctx.Err()
+	}
+	return n, err
+}
+
+func (cc *contextConn) Write(b []byte) (int, error) {
+	n, err := cc.Conn.Write(b)
+	if err != nil && cc.ctx.Err() != nil {
+		err = cc.ctx.Err()
+	}
+	return n, err
+}
+
+// WithContext aborts blocking operations on the connection when ctx is
+// canceled by closing the underlying connection. Operations then return
+// ctx.Err() and a canceled operation is recorded. It must be called before
+// the TLS handshake.
+func (c *Conn) WithContext(ctx context.Context) {
+	if ctx == nil || ctx.Done() == nil || c.conn == nil {
+		return
+	}
+	c.ctx = ctx
+	c.conn = &contextConn{Conn: c.conn, ctx: ctx}
+	c.stopWatch = make(chan struct{})
+
+	raw, stop := c.conn, c.stopWatch
+	go func() {
+		select {
+		case <-ctx.Done():
+			raw.Close()
+		case <-stop:
+		}
+	}()
+}
+
+// stopContextWatch ends the goroutine started by WithContext
+func (c *Conn) stopContextWatch() {
+	if c.stopWatch != nil {
+		c.stopOnce.Do(func() { close(c.stopWatch) })
+	}
+}
+
+// watchedConn ends the context watch of the Conn it was taken from when it
+// is closed
+type watchedConn struct {
+	net.Conn
+	stop func()
+}
+
+func (wc *watchedConn) Close() error {
+	wc.stop()
+	return wc.Conn.Close()
+}
+
+// transportConn returns the socket for an owner, such as the HTTP transport,
+// that closes it directly instead of calling Close, so the context watch
+// still ends with the connection
+func (c *Conn) transportConn() net.Conn {
+	conn := c.getUnderlyingConn()
+	if c.stopWatch == nil {
+		return conn
+	}
+	return &watchedConn{Conn: conn, stop: c.stopContextWatch}
+}
+
+// recordCancellation appends a canceled operation the first time it is
+// called after the context is canceled
+func (c *Conn) recordCancellation() {
+	if c.ctx == nil || c.ctx.Err() == nil || c.cancelRecorded {
+		return
+	}
+	c.cancelRecorded = true
+	now := time.Now()
+	c.appendOperation(&Operation{
+		Type:  OperationCanceled,
+		Start: now,
+		End:   now,
+	})
+}
@@ -0,0 +1,146 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/keys"
+	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
+)
+
+// DefaultEnumerationCurves are the named curves CurveEnumeration offers when
+// none are given: the common ones first, then deprecated and weak curves
+var DefaultEnumerationCurves = []keys.TLSCurveID{
+	keys.Secp256r1,
+	keys.Secp384r1,
+	keys.Secp521r1,
+	keys.X25519,
+	keys.X448,
+	keys.BrainpoolP256r1,
+	keys.BrainpoolP384r1,
+	keys.BrainpoolP512r1,
+	keys.Secp256k1,
+	keys.Secp224r1,
+	keys.Secp192r1,
+	keys.Secp160k1,
+	keys.Secp160r1,
+	keys.Secp160r2,
+	keys.Sect163k1,
+	keys.Sect571r1,
+}
+
+// A CurveEnumerationLog records which named curves a server will negotiate
+// for ECDHE. Every curve is offered alone on its own connection.
+type CurveEnumerationLog struct {
+	Supported []keys.TLSCurveID `json:"supported,omitempty"`
+	Rejected  []keys.TLSCurveID `json:"rejected,omitempty"`
+
+	// The curve chosen when all supported curves are offered at once
+	Preferred *keys.TLSCurveID `json:"preferred,omitempty"`
+
+	// Curves left untested because the connection limit was reached
+	Untested    []keys.TLSCurveID `json:"untested,omitempty"`
+	Connections int               `json:"connections"`
+}
+
+// CurveEnumeration opens up to maxConnections new connections with the
+// redialer and performs one ECDHE handshake on each, offering a single
+// curve. If more than one curve is supported and a connection is left, a
+// final handshake offers them all to find the server's preference. Only a
+// failure to open a connection is returned as an error.
+func (c *Conn) CurveEnumeration(curves []keys.TLSCurveID, maxConnections int) error {
+	if !c.isTls {
+		return fmt.Errorf(
+			"Must perform TLS handshake before enumerating curves with %s",
+			c.RemoteAddr().String())
+	}
+	if c.redial == nil {
+		return errors.New("No redialer set for curve enumeration")
+	}
+	if len(curves) == 0 {
+		curves = DefaultEnumerationCurves
+	}
+	defer c.recordOperation(OperationCurveEnumeration, time.Now())
+
+	out := new(CurveEnumerationLog)
+	c.grabData.Curves = out
+	for i, curve := range curves {
+		if out.Connections >= maxConnections {
+			out.Untested = curves[i:]
+			return nil
+		}
+		chosen, err := c.curveHandshake([]keys.TLSCurveID{curve})
+		out.Connections++
+		if err != nil {
+			return err
+		}
+		if chosen != nil && *chosen == curve {
+			out.Supported = append(out.Supported, curve)
+		} else {
+			out.Rejected = append(out.Rejected, curve)
+		}
+	}
+
+	if len(out.Supported) > 1 && out.Connections < maxConnections {
+		chosen, err := c.curveHandshake(out.Supported)
+		out.Connections++
+		if err != nil {
+			return err
+		}
+		out.Preferred = chosen
+	}
+	return nil
+}
+
+// curveHandshake performs an ECDHE handshake on a new connection offering
+// only curves, and returns the curve named in the ServerKeyExchange. The
+// handshake fails for curves ztls cannot compute with, but the server's
+// choice has been logged by then. The scan is held to TLS 1.2, since a TLS
+// 1.3 server names its group in a key share instead, and a ClientHello
+// profile is dropped so that only curves is offered.
+func (c *Conn) curveHandshake(curves []keys.TLSCurveID) (*keys.TLSCurveID, error) {
+	conn, err := c.redial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	tlsConfig := c.tlsClientConfig()
+	tlsConfig.CipherSuites = ztls.ECDHECiphers
+	tlsConfig.ClientSessionCache = nil
+	tlsConfig.ClientHelloProfile = nil
+	if tlsConfig.MaxVersion == 0 || tlsConfig.MaxVersion > ztls.VersionTLS12 {
+		tlsConfig.MaxVersion = ztls.VersionTLS12
+	}
+	tlsConfig.CurvePreferences = make([]ztls.CurveID, len(curves))
+	for i, curve := range curves {
+		tlsConfig.CurvePreferences[i] = ztls.CurveID(curve)
+	}
+
+	tlsConn := ztls.Client(conn.conn, tlsConfig)
+	tlsConn.SetReadDeadline(conn.readDeadline)
+	tlsConn.SetWriteDeadline(conn.writeDeadline)
+	tlsConn.Handshake()
+
+	skx := tlsConn.GetHandshakeLog().ServerKeyExchange
+	if skx == nil || skx.ECDHParams == nil {
+		return nil, nil
+	}
+	chosen := skx.ECDHParams.TLSCurveID
+	return &chosen, nil
+}
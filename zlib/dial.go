@@ -15,16 +15,70 @@
 package zlib
 
 import (
+	"context"
+	"errors"
 	"net"
+	"net/url"
+	"strings"
+	"syscall"
 	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+)
+
+// A ConnectState records the outcome of dialing the remote host
+type ConnectState struct {
+	Success       bool     `json:"success"`
+	Network       string   `json:"network"`
+	AddressFamily string   `json:"address_family,omitempty"`
+	LocalAddr     string   `json:"local_addr,omitempty"`
+	RemoteAddr    string   `json:"remote_addr"`
+	ResolvedAddrs []string `json:"resolved_addrs,omitempty"`
+	Device        string   `json:"device,omitempty"`
+	DurationMs    float64  `json:"duration_ms"`
+	Error         string   `json:"error,omitempty"`
+	ErrorClass    string   `json:"error_class,omitempty"`
+}
+
+// Address families recorded in a ConnectState
+const (
+	AddressFamilyIPv4 = "ipv4"
+	AddressFamilyIPv6 = "ipv6"
 )
 
+// classifyConnectError tells a refused connection from a timeout and from
+// an unreachable host or network, in the classes of zerrors.Classify.
+// Anything else a dial fails with is zerrors.Unknown.
+func classifyConnectError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return zerrors.ConnectionRefused
+	case errors.Is(err, syscall.ETIMEDOUT), errors.As(err, &netErr) && netErr.Timeout():
+		return zerrors.ConnectionTimeout
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return zerrors.HostUnreachable
+	case errors.Is(err, syscall.ENETUNREACH):
+		return zerrors.NetworkUnreachable
+	}
+	return zerrors.Unknown
+}
+
 type Dialer struct {
 	Deadline  time.Time
 	Timeout   time.Duration
 	LocalAddr net.Addr
 	DualStack bool
 	KeepAlive time.Duration
+
+	// Network interface to bind the socket to, Linux only
+	Device string
+
+	// SOCKS5 or HTTP CONNECT proxy to tunnel through, see ParseProxyURL
+	Proxy *url.URL
+
+	// Addresses that must not be dialed, checked after resolving
+	Exclude *ExcludeList
 }
 
 func (d *Dialer) Dial(network, address string) (*Conn, error) {
@@ -35,7 +89,166 @@ func (d *Dialer) Dial(network, address string) (*Conn, error) {
 		LocalAddr: d.LocalAddr,
 		KeepAlive: d.KeepAlive,
 	}
+	if d.Device != "" {
+		netDialer.Control = bindToDevice(d.Device)
+	}
 	var err error
-	c.conn, err = netDialer.Dial(network, address)
+	start := time.Now()
+	deadline := d.Deadline
+	if deadline.IsZero() && d.Timeout != 0 {
+		deadline = start.Add(d.Timeout)
+	}
+	dialAddress := address
+	if d.Proxy != nil {
+		dialAddress = d.Proxy.Host
+	}
+	state := &ConnectState{
+		Network:    network,
+		RemoteAddr: dialAddress,
+		Device:     d.Device,
+	}
+	if d.Exclude != nil && d.Proxy != nil {
+		// The proxy resolves names itself, so only literals can be checked
+		if host, _, splitErr := net.SplitHostPort(address); splitErr == nil && isIPLiteral(host) {
+			if err = d.Exclude.Check(hostIP(host)); err != nil {
+				c.recordConnect(state, start, err)
+				return c, err
+			}
+		}
+	}
+	c.conn, err = dialResolved(&netDialer, network, dialAddress, state, d.Exclude)
+	c.recordConnect(state, start, err)
+	if err == nil {
+		c.counter = &countingConn{Conn: c.conn}
+		c.conn = c.counter
+	}
+	if err == nil && d.Proxy != nil {
+		err = c.proxyConnect(d.Proxy, address, deadline)
+	}
 	return c, err
 }
+
+// dialResolved dials address, resolving a host name itself so the state
+// can record every address it resolved to and the one attempted. The
+// addresses are tried in turn, sharing the dialer's timeout. Addresses on
+// the exclude list, which may be nil, are never dialed.
+func dialResolved(d *net.Dialer, network, address string, state *ConnectState, exclude *ExcludeList) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return d.Dial(network, address)
+	}
+	if isIPLiteral(host) {
+		if exclude != nil {
+			if err := exclude.Check(hostIP(host)); err != nil {
+				return nil, err
+			}
+		}
+		return d.Dial(network, address)
+	}
+	if d.Deadline.IsZero() && d.Timeout != 0 {
+		d.Deadline = time.Now().Add(d.Timeout)
+		d.Timeout = 0
+	}
+	ctx := context.Background()
+	if !d.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, d.Deadline)
+		defer cancel()
+	}
+	ipNetwork := "ip"
+	if strings.HasSuffix(network, "4") || strings.HasSuffix(network, "6") {
+		ipNetwork += network[len(network)-1:]
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, ipNetwork, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		state.ResolvedAddrs = append(state.ResolvedAddrs, ip.String())
+	}
+	var conn net.Conn
+	for _, ip := range ips {
+		state.RemoteAddr = net.JoinHostPort(ip.String(), port)
+		if exclude != nil {
+			if err = exclude.Check(ip); err != nil {
+				continue
+			}
+		}
+		if conn, err = d.Dial(network, state.RemoteAddr); err == nil {
+			break
+		}
+	}
+	return conn, err
+}
+
+// hostIP parses an IP literal host, dropping any brackets and zone. It
+// returns nil for a host name.
+func hostIP(host string) net.IP {
+	host = strings.Trim(host, "[]")
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+	return net.ParseIP(host)
+}
+
+// recordConnect records the connection attempt as the first operation
+func (c *Conn) recordConnect(state *ConnectState, start time.Time, err error) {
+	state.Success = err == nil
+	state.DurationMs = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil {
+		state.Error = err.Error()
+		state.ErrorClass = classifyConnectError(err)
+	} else {
+		state.LocalAddr = c.conn.LocalAddr().String()
+		state.RemoteAddr = c.conn.RemoteAddr().String()
+	}
+	state.AddressFamily = addressFamily(state.RemoteAddr)
+	c.grabData.Connect = state
+	c.recordOperation(OperationConnect, start)
+}
+
+// addressFamily returns the family of the IP in address, or the empty string
+// if it does not hold one
+func addressFamily(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return AddressFamilyIPv4
+	}
+	return AddressFamilyIPv6
+}
+
+// Dial connects to remote from the local address, which may omit the port
+// or be empty to let the system pick one, giving up after timeout
+func Dial(network, local, remote string, timeout time.Duration) (*Conn, error) {
+	d := Dialer{Timeout: timeout}
+	if local != "" {
+		addr, err := resolveLocalAddr(network, local)
+		if err != nil {
+			return &Conn{}, err
+		}
+		d.LocalAddr = addr
+	}
+	return d.Dial(network, remote)
+}
+
+// resolveLocalAddr resolves a local IP or IP:port for the given network
+func resolveLocalAddr(network, local string) (net.Addr, error) {
+	if _, _, err := net.SplitHostPort(local); err != nil {
+		local = net.JoinHostPort(local, "0")
+	}
+	switch network {
+	case "udp", "udp4", "udp6":
+		return net.ResolveUDPAddr(network, local)
+	}
+	return net.ResolveTCPAddr(network, local)
+}
@@ -0,0 +1,163 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+)
+
+func TestDialRecordsConnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c, err := Dial("tcp", "127.0.0.1", l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("Dial: %s", err)
+	}
+	defer c.Close()
+
+	state := c.grabData.Connect
+	if state == nil || !state.Success {
+		t.Fatalf("Wrong connect state: %+v", state)
+	}
+	if !strings.HasPrefix(state.LocalAddr, "127.0.0.1:") || state.RemoteAddr != l.Addr().String() {
+		t.Errorf("Wrong addresses - local: %s, remote: %s", state.LocalAddr, state.RemoteAddr)
+	}
+	if len(c.grabData.Operations) != 1 || c.grabData.Operations[0].Type != OperationConnect {
+		t.Errorf("Connect not recorded as the first operation: %v", c.grabData.Operations)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	c, err := Dial("tcp", "", addr, time.Second)
+	if err == nil {
+		c.Close()
+		t.Fatal("Dial to a closed port succeeded")
+	}
+	state := c.grabData.Connect
+	if state == nil || state.Success || state.ErrorClass != zerrors.ConnectionRefused {
+		t.Errorf("Wrong connect state: %+v", state)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyConnectError(t *testing.T) {
+	opError := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: err}
+	}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{opError(os.NewSyscallError("connect", syscall.ECONNREFUSED)), zerrors.ConnectionRefused},
+		{opError(os.NewSyscallError("connect", syscall.ETIMEDOUT)), zerrors.ConnectionTimeout},
+		{opError(timeoutError{}), zerrors.ConnectionTimeout},
+		{opError(os.NewSyscallError("connect", syscall.EHOSTUNREACH)), zerrors.HostUnreachable},
+		{opError(os.NewSyscallError("connect", syscall.ENETUNREACH)), zerrors.NetworkUnreachable},
+		{opError(os.NewSyscallError("socket", syscall.EMFILE)), zerrors.Unknown},
+	}
+	for _, test := range tests {
+		if got := classifyConnectError(test.err); got != test.want {
+			t.Errorf("classifyConnectError(%s) = %s, want %s", test.err, got, test.want)
+		}
+	}
+}
+
+func TestDialHostNameRecordsResolvedAddrs(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	c, err := Dial("tcp4", "", net.JoinHostPort("localhost", port), time.Second)
+	if err != nil {
+		t.Fatalf("Dial: %s", err)
+	}
+	defer c.Close()
+
+	state := c.grabData.Connect
+	if state.AddressFamily != AddressFamilyIPv4 || state.RemoteAddr != l.Addr().String() {
+		t.Errorf("Wrong address - family: %s, remote: %s", state.AddressFamily, state.RemoteAddr)
+	}
+	found := false
+	for _, addr := range state.ResolvedAddrs {
+		if net.ParseIP(addr).To4() == nil {
+			t.Errorf("tcp4 dial resolved IPv6 address %s", addr)
+		}
+		found = found || addr == "127.0.0.1"
+	}
+	if !found {
+		t.Errorf("127.0.0.1 not among resolved addresses %v", state.ResolvedAddrs)
+	}
+}
+
+func TestDialIPv6(t *testing.T) {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %s", err)
+	}
+	defer l.Close()
+
+	c, err := Dial("tcp6", "::1", l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("Dial: %s", err)
+	}
+	defer c.Close()
+	if state := c.grabData.Connect; state.AddressFamily != AddressFamilyIPv6 || !strings.HasPrefix(state.LocalAddr, "[::1]:") {
+		t.Errorf("Wrong connect state: %+v", state)
+	}
+}
+
+func TestAddressFamily(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"192.0.2.1:80", AddressFamilyIPv4},
+		{"[2001:db8::1]:80", AddressFamilyIPv6},
+		{"[fe80::1%eth0]:22", AddressFamilyIPv6},
+		{"::ffff:192.0.2.1", AddressFamilyIPv4},
+		{"example.com:80", ""},
+	}
+	for _, test := range tests {
+		if got := addressFamily(test.address); got != test.want {
+			t.Errorf("addressFamily(%s) = %q, want %q", test.address, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/dns"
+)
+
+// The EDNS0 payload size a DNS probe advertises, the size recommended by
+// DNS flag day 2020
+const dnsProbePayloadSize = 1232
+
+// A DNSProbeLog records a recursive query sent to the target. Recursed is
+// set when the server answered from the cache or by recursion rather than
+// authoritatively, meaning it is an open resolver.
+type DNSProbeLog struct {
+	Name               string    `json:"name"`
+	Rcode              string    `json:"rcode,omitempty"`
+	Authoritative      bool      `json:"authoritative"`
+	RecursionAvailable bool      `json:"recursion_available"`
+	Recursed           bool      `json:"recursed"`
+	Answers            []*dns.RR `json:"answers,omitempty"`
+	EDNSPayloadSize    int       `json:"edns_payload_size,omitempty"`
+	// Set when the UDP reply was truncated and the query was repeated
+	// over TCP
+	Truncated    bool        `json:"truncated,omitempty"`
+	TCPFallback  bool        `json:"tcp_fallback,omitempty"`
+	Version      *DNSVersion `json:"version,omitempty"`
+	VersionError string      `json:"version_error,omitempty"`
+}
+
+// SetTCPRedialer sets how DNSProbe connects over TCP after a truncated
+// reply
+func (c *Conn) SetTCPRedialer(redial func() (*Conn, error)) {
+	c.redialTCP = redial
+}
+
+func dnsQueryID() uint16 {
+	var id [2]byte
+	rand.Read(id[:])
+	return binary.BigEndian.Uint16(id[:])
+}
+
+// DNSProbe sends a recursive query for the A records of name on a UDP
+// connection, repeating it over TCP when the reply is truncated. With
+// version the version.bind TXT record is asked for as well, a failure of
+// which is recorded but does not fail the probe.
+func (c *Conn) DNSProbe(name string, version bool) error {
+	log := &DNSProbeLog{Name: name}
+	c.grabData.DNSProbe = log
+	id := dnsQueryID()
+	query, err := dns.ProbeQuery(id, name, dnsProbePayloadSize)
+	if err != nil {
+		return err
+	}
+	res, err := c.dnsExchange(query, dns.ParseProbeResponse)
+	if err != nil {
+		return err
+	}
+	if res.Truncated {
+		log.Truncated = true
+		if res, err = c.dnsOverTCP(query, dns.ParseProbeResponse); err != nil {
+			return err
+		}
+		log.TCPFallback = true
+	}
+	log.Rcode = dns.RcodeName(res.Rcode)
+	log.Authoritative = res.Authoritative
+	log.RecursionAvailable = res.RecursionAvailable
+	log.Recursed = res.RecursionAvailable && !res.Authoritative &&
+		(res.Rcode == dns.RcodeNameError || (res.Rcode == dns.RcodeSuccess && len(res.Answers) > 0))
+	log.Answers = res.Answers
+	log.EDNSPayloadSize = res.EDNSPayloadSize
+
+	if version {
+		res, err := c.dnsExchange(dns.VersionQuery(dnsQueryID()), dns.ParseVersionResponse)
+		if err != nil {
+			log.VersionError = err.Error()
+		} else {
+			log.Version = &DNSVersion{Rcode: dns.RcodeName(res.Rcode), TXT: res.TXT}
+		}
+	}
+	return nil
+}
+
+// dnsExchange writes query as one datagram and parses the first reply
+// carrying its ID, skipping strays, each datagram recorded as a read
+func (c *Conn) dnsExchange(query []byte, parse func([]byte) (*dns.Response, error)) (*dns.Response, error) {
+	if _, err := c.Write(query); err != nil {
+		return nil, err
+	}
+	var res *dns.Response
+	err := c.readDatagrams(func(b []byte) (bool, error) {
+		if len(b) < 2 || b[0] != query[0] || b[1] != query[1] {
+			return false, nil
+		}
+		var err error
+		res, err = parse(b)
+		return true, err
+	})
+	return res, err
+}
+
+// dnsOverTCP repeats query over a TCP connection to the target, RFC 1035
+// section 4.2.2. The connection is swapped in for the UDP one while it is
+// used, so its reads and writes are recorded with the rest.
+func (c *Conn) dnsOverTCP(query []byte, parse func([]byte) (*dns.Response, error)) (*dns.Response, error) {
+	if c.redialTCP == nil {
+		return nil, errors.New("No TCP redialer set for the DNS probe")
+	}
+	second, err := c.redialTCP()
+	if err != nil {
+		return nil, err
+	}
+	defer second.Close()
+	udp := c.conn
+	c.conn = second.conn
+	defer func() { c.conn = udp }()
+
+	msg := make([]byte, 2, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	if _, err := c.Write(append(msg, query...)); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(c, msg); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, binary.BigEndian.Uint16(msg))
+	if _, err := io.ReadFull(c, reply); err != nil {
+		return nil, err
+	}
+	if len(reply) < 2 || reply[0] != query[0] || reply[1] != query[1] {
+		return nil, errors.New("dns: TCP reply to a different query")
+	}
+	return parse(reply)
+}
@@ -0,0 +1,119 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// dnsReply answers query with the given flags and one A record pointing
+// back at the question, followed by an OPT record advertising 4096 bytes
+func dnsReply(query []byte, flags uint16) []byte {
+	end := 12
+	for query[end] != 0 {
+		end += 1 + int(query[end])
+	}
+	end += 5
+	r := append([]byte(nil), query[:end]...)
+	binary.BigEndian.PutUint16(r[2:], flags|0x8100)
+	binary.BigEndian.PutUint16(r[6:], 1)
+	binary.BigEndian.PutUint16(r[10:], 1)
+	r = append(r, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0x0e, 0x10, 0, 4, 192, 0, 2, 7)
+	return append(r, 0, 0, 41, 0x10, 0, 0, 0, 0, 0, 0, 0)
+}
+
+func TestDNSProbeRecursed(t *testing.T) {
+	addr, stop := udpServer(t, func(q []byte) [][]byte {
+		if binary.BigEndian.Uint16(q[len(q)-2:]) == 3 {
+			// version.bind in class CHAOS, refused
+			r := append([]byte(nil), q...)
+			binary.BigEndian.PutUint16(r[2:], 0x8005)
+			return [][]byte{r}
+		}
+		return [][]byte{dnsReply(q, 0x0080)}
+	})
+	defer stop()
+
+	c := dialUDP(t, addr, time.Second)
+	defer c.Close()
+	if err := c.DNSProbe("example.com", true); err != nil {
+		t.Fatalf("DNSProbe: %s", err.Error())
+	}
+	log := c.grabData.DNSProbe
+	if !log.Recursed || log.Authoritative || log.Rcode != "NOERROR" || log.EDNSPayloadSize != 4096 || log.TCPFallback {
+		t.Errorf("Wrong log: %+v", log)
+	}
+	if len(log.Answers) != 1 || log.Answers[0].Name != "example.com." || log.Answers[0].Type != "A" ||
+		log.Answers[0].TTL != 3600 || log.Answers[0].Data != "192.0.2.7" {
+		t.Errorf("Wrong answers: %+v", log.Answers)
+	}
+	if log.Version == nil || log.Version.Rcode != "REFUSED" {
+		t.Errorf("Wrong version: %+v (%s)", log.Version, log.VersionError)
+	}
+}
+
+func TestDNSProbeTCPFallback(t *testing.T) {
+	addr, stop := udpServer(t, func(q []byte) [][]byte {
+		r := append([]byte(nil), q...)
+		binary.BigEndian.PutUint16(r[2:], 0x8380)
+		return [][]byte{r}
+	})
+	defer stop()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 512)
+		n, _ := conn.Read(buf)
+		r := dnsReply(buf[2:n], 0x0400)
+		conn.Write(append([]byte{byte(len(r) >> 8), byte(len(r))}, r...))
+	}()
+
+	c := dialUDP(t, addr, time.Second)
+	defer c.Close()
+	c.SetTCPRedialer(func() (*Conn, error) {
+		d := Dialer{Deadline: time.Now().Add(time.Second)}
+		return d.Dial("tcp", addr)
+	})
+	if err := c.DNSProbe("example.com", false); err != nil {
+		t.Fatalf("DNSProbe: %s", err.Error())
+	}
+	log := c.grabData.DNSProbe
+	if !log.Truncated || !log.TCPFallback || !log.Authoritative || log.Recursed || len(log.Answers) != 1 {
+		t.Errorf("Wrong log: %+v", log)
+	}
+	var reads, writes int
+	for _, op := range c.grabData.Operations {
+		switch op.Type {
+		case OperationRead:
+			reads++
+		case OperationWrite:
+			writes++
+		}
+	}
+	if writes != 2 || reads < 3 {
+		t.Errorf("TCP exchange not recorded: %d writes, %d reads", writes, reads)
+	}
+}
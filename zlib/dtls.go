@@ -0,0 +1,70 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
+)
+
+// SetDTLSRetransmission sets how long DTLSHandshake waits for the server's
+// answer to a flight before sending it again, doubled on each retry, and
+// how many times it retries. Without it the ztls defaults apply.
+func (c *Conn) SetDTLSRetransmission(timeout time.Duration, maxRetransmits int) {
+	c.dtlsRetransmitTimeout = timeout
+	c.dtlsMaxRetransmits = maxRetransmits
+	c.dtlsRetransmitSet = true
+}
+
+// DTLSHandshake runs a DTLS handshake on a UDP connection with the same
+// client settings as TLSHandshake. The ServerHandshake log is recorded
+// under tls like a TLS handshake's; the handshake ends after the server's
+// first flight, so the connection cannot carry application data.
+func (c *Conn) DTLSHandshake() error {
+	if c.tlsConn != nil || c.dtlsConn != nil {
+		return fmt.Errorf(
+			"Attempted repeat handshake with remote host %s",
+			c.RemoteAddr().String())
+	}
+	defer c.recordOperation(OperationDTLSHandshake, time.Now())
+	tlsConfig := c.tlsClientConfig()
+
+	c.dtlsConn = ztls.DTLSClient(c.conn, tlsConfig)
+	if c.dtlsRetransmitSet {
+		c.dtlsConn.RetransmitTimeout = c.dtlsRetransmitTimeout
+		c.dtlsConn.MaxRetransmits = c.dtlsMaxRetransmits
+	}
+	c.dtlsConn.SetDeadline(c.readDeadline)
+	c.tracef("starting DTLS handshake (max version %#04x, server name %q)", tlsConfig.MaxVersion, tlsConfig.ServerName)
+	err := c.dtlsConn.Handshake()
+	if err != nil {
+		c.tracef("DTLS handshake failed: %s", err.Error())
+	} else {
+		c.tracef("DTLS handshake complete")
+	}
+	hl := c.dtlsConn.GetHandshakeLog()
+	if hl != nil && !c.tlsVerbose && hl.ClientHello != nil {
+		hl.ClientHello = &ztls.ClientHello{
+			ServerName:    hl.ClientHello.ServerName,
+			CipherSuites:  hl.ClientHello.CipherSuites,
+			ALPNProtocols: hl.ClientHello.ALPNProtocols,
+		}
+	}
+	c.grabData.TLSHandshake = hl
+	c.grabData.DTLS = c.dtlsConn.GetDTLSLog()
+	return err
+}
@@ -0,0 +1,173 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// An ExcludeList holds the IPv4 and IPv6 networks that must never be
+// contacted, e.g. those that opted out of scanning. Networks are kept in a
+// binary trie per family, so a lookup walks at most one node per bit of
+// the address. It is safe for concurrent use once loaded.
+type ExcludeList struct {
+	v4, v6 excludeNode
+
+	excluded uint64
+
+	// Excluded addresses are logged here with the matching rule when set
+	Log   io.Writer
+	logMu sync.Mutex
+}
+
+type excludeNode struct {
+	children [2]*excludeNode
+	// Set on the node ending an excluded network, e.g. "optout.txt:12 10.0.0.0/8"
+	rule string
+}
+
+// An ExcludedError is returned when dialing an excluded address
+type ExcludedError struct {
+	Addr string
+	Rule string
+}
+
+func (e *ExcludedError) Error() string {
+	return fmt.Sprintf("%s is excluded by %s", e.Addr, e.Rule)
+}
+
+// LoadFile adds the networks listed in the file at path
+func (l *ExcludeList) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return l.Load(f, path)
+}
+
+// Load adds the networks listed in r, one CIDR block or address per line.
+// Anything after a # is a comment. A malformed line fails the whole load,
+// naming source and the line number, since skipping it could let packets
+// reach a network that asked not to receive any.
+func (l *ExcludeList) Load(r io.Reader, source string) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		block, err := parseExcludeEntry(text)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", source, line, err.Error())
+		}
+		l.add(block, fmt.Sprintf("%s:%d %s", source, line, block.String()))
+	}
+	return scanner.Err()
+}
+
+func parseExcludeEntry(text string) (*net.IPNet, error) {
+	if strings.Contains(text, "/") {
+		_, block, err := net.ParseCIDR(text)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CIDR block %s", text)
+		}
+		return block, nil
+	}
+	ip := net.ParseIP(text)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid address %s", text)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func (l *ExcludeList) add(block *net.IPNet, rule string) {
+	ones, bits := block.Mask.Size()
+	node := &l.v6
+	ip := block.IP.To16()
+	if bits == 32 {
+		node, ip = &l.v4, block.IP.To4()
+	}
+	for i := 0; i < ones; i++ {
+		if node.rule != "" {
+			// A wider network already covers this one
+			return
+		}
+		bit := ip[i/8] >> uint(7-i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = new(excludeNode)
+		}
+		node = node.children[bit]
+	}
+	node.rule = rule
+	// Narrower networks inside this one are now redundant
+	node.children = [2]*excludeNode{}
+}
+
+// Lookup returns the rule excluding ip, or the empty string if it may be
+// contacted
+func (l *ExcludeList) Lookup(ip net.IP) string {
+	node, bits := &l.v6, 128
+	if ip4 := ip.To4(); ip4 != nil {
+		node, bits, ip = &l.v4, 32, ip4
+	} else if ip = ip.To16(); ip == nil {
+		return ""
+	}
+	for i := 0; node != nil; i++ {
+		if node.rule != "" {
+			return node.rule
+		}
+		if i == bits {
+			break
+		}
+		node = node.children[ip[i/8]>>uint(7-i%8)&1]
+	}
+	return ""
+}
+
+// Check returns an *ExcludedError if ip is excluded, counting and logging
+// it
+func (l *ExcludeList) Check(ip net.IP) error {
+	rule := l.Lookup(ip)
+	if rule == "" {
+		return nil
+	}
+	atomic.AddUint64(&l.excluded, 1)
+	if l.Log != nil {
+		l.logMu.Lock()
+		fmt.Fprintf(l.Log, "%s %s\n", ip, rule)
+		l.logMu.Unlock()
+	}
+	return &ExcludedError{Addr: ip.String(), Rule: rule}
+}
+
+// Excluded returns the number of addresses Check has refused
+func (l *ExcludeList) Excluded() uint {
+	return uint(atomic.LoadUint64(&l.excluded))
+}
@@ -0,0 +1,131 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadExcludeList(t *testing.T, list string) *ExcludeList {
+	l := new(ExcludeList)
+	if err := l.Load(strings.NewReader(list), "optout.txt"); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestExcludeListLookup(t *testing.T) {
+	l := loadExcludeList(t, `# networks that opted out
+10.0.0.0/8
+192.0.2.0/25   # partial
+192.0.2.200
+10.1.0.0/16
+2001:db8::/32
+`)
+	tests := []struct {
+		ip   string
+		rule string
+	}{
+		{"10.200.1.1", "optout.txt:2 10.0.0.0/8"},
+		{"10.1.2.3", "optout.txt:2 10.0.0.0/8"},
+		{"192.0.2.127", "optout.txt:3 192.0.2.0/25"},
+		{"192.0.2.128", ""},
+		{"192.0.2.200", "optout.txt:4 192.0.2.200/32"},
+		{"192.0.2.201", ""},
+		{"11.0.0.1", ""},
+		{"::ffff:10.0.0.1", "optout.txt:2 10.0.0.0/8"},
+		{"2001:db8:ffff::1", "optout.txt:6 2001:db8::/32"},
+		{"2001:db9::1", ""},
+	}
+	for _, test := range tests {
+		if rule := l.Lookup(net.ParseIP(test.ip)); rule != test.rule {
+			t.Errorf("%s: got rule %q, expected %q", test.ip, rule, test.rule)
+		}
+	}
+
+	everything := loadExcludeList(t, "0.0.0.0/0\n")
+	if everything.Lookup(net.ParseIP("203.0.113.9")) == "" || everything.Lookup(net.ParseIP("2001:db8::1")) != "" {
+		t.Errorf("/0 excludes the wrong family")
+	}
+}
+
+func TestExcludeListMalformedLine(t *testing.T) {
+	err := new(ExcludeList).Load(strings.NewReader("10.0.0.0/8\n\n10.0.0.0/33\n"), "optout.txt")
+	if err == nil || !strings.HasPrefix(err.Error(), "optout.txt:3: ") {
+		t.Errorf("Wrong error %v", err)
+	}
+}
+
+func TestExcludeListLoads100kEntries(t *testing.T) {
+	var list bytes.Buffer
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&list, "%d.%d.%d.0/24\n", 1+i/65536, i/256%256, i%256)
+	}
+	start := time.Now()
+	l := loadExcludeList(t, list.String())
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Loading 100k entries took %s", elapsed)
+	}
+	if l.Lookup(net.ParseIP("2.134.159.77")) == "" || l.Lookup(net.ParseIP("2.134.160.1")) != "" {
+		t.Errorf("Wrong lookups after a large load")
+	}
+}
+
+func TestExcludeListCheckCountsAndLogs(t *testing.T) {
+	l := loadExcludeList(t, "192.0.2.0/24\n")
+	var log bytes.Buffer
+	l.Log = &log
+	if err := l.Check(net.ParseIP("198.51.100.1")); err != nil {
+		t.Errorf("Allowed address refused: %v", err)
+	}
+	err := l.Check(net.ParseIP("192.0.2.7"))
+	if excluded, ok := err.(*ExcludedError); !ok || excluded.Rule != "optout.txt:1 192.0.2.0/24" {
+		t.Errorf("Wrong error %v", err)
+	}
+	if l.Excluded() != 1 || log.String() != "192.0.2.7 optout.txt:1 192.0.2.0/24\n" {
+		t.Errorf("Wrong count %d or log %q", l.Excluded(), log.String())
+	}
+
+	targets, _ := readTargets(t, "192.0.2.254/31\n192.0.3.0\n", TargetOptions{Exclude: l})
+	if strings.Join(targets, " ") != "192.0.3.0:0" || l.Excluded() != 3 {
+		t.Errorf("Wrong targets %v with %d excluded", targets, l.Excluded())
+	}
+}
+
+func TestDialRefusesExcludedAddresses(t *testing.T) {
+	addr, stop := bannerServer(t, "220 ready\r\n")
+	defer stop()
+	config, target := retryConfig(addr)
+	config.Exclude = loadExcludeList(t, "127.0.0.0/8\n::1\n")
+
+	grab := GrabBanner(config, target)
+	if _, ok := grab.Error.(*ExcludedError); !ok || grab.ErrorComponent != "excluded" {
+		t.Errorf("Excluded address dialed: %v (component %q)", grab.Error, grab.ErrorComponent)
+	}
+
+	// A name resolving into excluded space is refused as well
+	_, port, _ := net.SplitHostPort(addr)
+	d := Dialer{Timeout: time.Second, Exclude: config.Exclude}
+	if _, err := d.Dial("tcp", net.JoinHostPort("localhost", port)); err == nil {
+		t.Errorf("Excluded address dialed through a name")
+	} else if _, ok := err.(*ExcludedError); !ok {
+		t.Errorf("Wrong error %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
+)
+
+// Export cipher probes, see SetExportProbe
+const (
+	ExportProbeRSA = "rsa"
+	ExportProbeDHE = "dhe"
+)
+
+// An ExportCipherLog records whether a server negotiated an export cipher
+// suite when offered nothing else (FREAK for RSA_EXPORT, Logjam for
+// DHE_EXPORT). Only the field for the probed family is set.
+type ExportCipherLog struct {
+	ExportRSASupported *bool `json:"export_rsa_supported,omitempty"`
+	ExportDHESupported *bool `json:"export_dhe_supported,omitempty"`
+
+	// The server's group when a DHE suite was negotiated
+	DHPrimeBits int    `json:"dh_prime_bits,omitempty"`
+	DHPrime     []byte `json:"dh_prime,omitempty"`
+}
+
+// SetExportProbe offers only the RSA_EXPORT or DHE_EXPORT suites in the next
+// TLS handshake and records whether the server negotiated one of them
+func (c *Conn) SetExportProbe(probe string) {
+	switch probe {
+	case ExportProbeRSA:
+		c.CipherSuites = ztls.RSA512ExportCiphers
+	case ExportProbeDHE:
+		c.CipherSuites = ztls.DHEExportCiphers
+	default:
+		return
+	}
+	c.exportProbe = probe
+}
+
+// exportProbeResult summarizes the handshake log of an export probe. A
+// server that fails the handshake before choosing a suite did not negotiate
+// one.
+func exportProbeResult(probe string, offered []uint16, hl *ztls.ServerHandshake) *ExportCipherLog {
+	negotiated := false
+	if hl != nil && hl.ServerHello != nil {
+		for _, suite := range offered {
+			if uint16(hl.ServerHello.CipherSuite) == suite {
+				negotiated = true
+				break
+			}
+		}
+	}
+
+	out := new(ExportCipherLog)
+	if probe == ExportProbeRSA {
+		out.ExportRSASupported = &negotiated
+	} else {
+		out.ExportDHESupported = &negotiated
+	}
+	if negotiated && hl.ServerKeyExchange != nil && hl.ServerKeyExchange.DHParams != nil {
+		if p := hl.ServerKeyExchange.DHParams.Prime; p != nil {
+			out.DHPrimeBits = p.BitLen()
+			out.DHPrime = p.Bytes()
+		}
+	}
+	return out
+}
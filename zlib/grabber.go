@@ -26,16 +26,14 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"gopkg.in/eniac/zgrab.v0/ztools/ftp"
 	"gopkg.in/eniac/zgrab.v0/ztools/http"
 	"gopkg.in/eniac/zgrab.v0/ztools/processing"
 	"gopkg.in/eniac/zgrab.v0/ztools/scada/dnp3"
 	"gopkg.in/eniac/zgrab.v0/ztools/scada/fox"
 	"gopkg.in/eniac/zgrab.v0/ztools/scada/siemens"
-	"gopkg.in/eniac/zgrab.v0/ztools/telnet"
-	"gopkg.in/eniac/zgrab.v0/ztools/xssh"
 	"gopkg.in/eniac/zgrab.v0/ztools/zlog"
 	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
 )
@@ -43,6 +41,79 @@ import (
 type GrabTarget struct {
 	Addr   net.IP
 	Domain string
+
+	// IPv6 zone of a link-local Addr, e.g. eth0
+	Zone string
+	// Port given with the address, zero for the configured port
+	Port uint16
+}
+
+// hostPort returns the address to dial for the target, or its domain if it
+// has no address, bracketing IPv6 literals
+func (t *GrabTarget) hostPort(defaultPort uint16) string {
+	host := t.Addr.String()
+	if t.Addr == nil {
+		host = t.Domain
+	}
+	if t.Zone != "" {
+		host += "%" + t.Zone
+	}
+	port := defaultPort
+	if t.Port != 0 {
+		port = t.Port
+	}
+	return net.JoinHostPort(host, strconv.FormatUint(uint64(port), 10))
+}
+
+// parseTargetAddress splits an input address into its IP, IPv6 zone and
+// port. It accepts ip, ip:port, [ip] and [ip]:port, where an IPv6 ip may
+// carry a zone like fe80::1%eth0. The port is zero when none is given.
+func parseTargetAddress(s string) (ip net.IP, zone string, port uint16, err error) {
+	host := s
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return nil, "", 0, fmt.Errorf("Invalid address %s: missing ]", s)
+		}
+		host = s[1:end]
+		if rest := s[end+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return nil, "", 0, fmt.Errorf("Invalid address %s", s)
+			}
+			if port, err = parseTargetPort(rest[1:]); err != nil {
+				return nil, "", 0, err
+			}
+		}
+	} else if strings.Count(s, ":") == 1 {
+		// Unbracketed IPv6 literals have several colons, so a single
+		// colon separates an IPv4 address from its port
+		i := strings.Index(s, ":")
+		host = s[:i]
+		if port, err = parseTargetPort(s[i+1:]); err != nil {
+			return nil, "", 0, err
+		}
+	}
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host, zone = host[:i], host[i+1:]
+		if zone == "" {
+			return nil, "", 0, fmt.Errorf("Invalid address %s: empty zone", s)
+		}
+	}
+	if ip = net.ParseIP(host); ip == nil {
+		return nil, "", 0, fmt.Errorf("Invalid IP address %s", s)
+	}
+	if zone != "" && ip.To4() != nil {
+		return nil, "", 0, fmt.Errorf("Invalid address %s: zone on an IPv4 address", s)
+	}
+	return ip, zone, port, nil
+}
+
+func parseTargetPort(s string) (uint16, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("Invalid port %s", s)
+	}
+	return uint16(port), nil
 }
 
 type grabTargetDecoder struct {
@@ -58,9 +129,9 @@ func (gtd *grabTargetDecoder) DecodeNext() (interface{}, error) {
 		return nil, errors.New("Invalid grab target (no fields)")
 	}
 	var target GrabTarget
-	target.Addr = net.ParseIP(record[0])
-	if target.Addr == nil {
-		return nil, fmt.Errorf("Invalid IP address %s", record[0])
+	target.Addr, target.Zone, target.Port, err = parseTargetAddress(record[0])
+	if err != nil {
+		return nil, err
 	}
 	// Check for a domain
 	if len(record) >= 2 {
@@ -107,38 +178,85 @@ func NewGrabTargetDecoder(reader io.Reader, domainOnly bool) processing.Decoder
 
 func makeDialer(c *Config) func(string) (*Conn, error) {
 	proto := "tcp"
-	if c.BACNet {
+	if c.BACNet || c.DTLS || c.UDPProbe != nil || c.NTP || c.DNSProbe || c.SNMP || (c.SIP && !c.SIPTCP) || c.SSDP || (c.Kerberos && !c.KerberosTCP) {
 		proto = "udp"
 	}
+	return makeProtoDialer(c, proto)
+}
+
+// makeProtoDialer returns a dialer for proto, tcp or udp, whatever the
+// configured protocols would pick
+func makeProtoDialer(c *Config, proto string) func(string) (*Conn, error) {
+	proto += c.AddressFamily
 	timeout := c.Timeout
 	return func(addr string) (*Conn, error) {
+		if err := c.waitDial(addr); err != nil {
+			return &Conn{}, err
+		}
 		deadline := time.Now().Add(timeout)
 		d := Dialer{
-			Deadline: deadline,
+			Deadline:  deadline,
+			LocalAddr: c.nextLocalAddr(proto),
+			Device:    c.Interface,
+			Proxy:     c.Proxy,
+			Exclude:   c.Exclude,
 		}
 		conn, err := d.Dial(proto, addr)
-		conn.maxTlsVersion = c.TLSVersion
+		conn.SetTLSVersionBounds(c.TLSMinVersion, c.TLSVersion)
 		if err == nil {
 			conn.SetDeadline(deadline)
+			conn.SetMaxReadBytes(c.MaxReadBytes)
+			conn.WithContext(c.Context)
+			conn.SetTotalTimeout(c.TotalTimeout)
+			conn.SetQuitTimeout(c.QuitTimeout)
+			conn.SetDrainTimeout(c.DrainTimeout)
 		}
 		return conn, err
 	}
 }
 
+// sourceIPIndex picks the next of the configured source IPs
+var sourceIPIndex uint64
+
+// nextLocalAddr cycles through the configured source IPs, so connections
+// are spread evenly across them. It returns nil when there are none.
+func (c *Config) nextLocalAddr(proto string) net.Addr {
+	if len(c.SourceIPs) == 0 {
+		return nil
+	}
+	i := atomic.AddUint64(&sourceIPIndex, 1) - 1
+	ip := c.SourceIPs[i%uint64(len(c.SourceIPs))]
+	if strings.HasPrefix(proto, "udp") {
+		return &net.UDPAddr{IP: ip}
+	}
+	return &net.TCPAddr{IP: ip}
+}
+
 func makeNetDialer(c *Config) func(string, string) (net.Conn, error) {
-	proto := "tcp"
+	proto := "tcp" + c.AddressFamily
 	timeout := c.Timeout
 	return func(net, addr string) (net.Conn, error) {
+		if err := c.waitDial(addr); err != nil {
+			return nil, err
+		}
 		deadline := time.Now().Add(timeout)
 		d := Dialer{
-			Deadline: deadline,
+			Deadline:  deadline,
+			LocalAddr: c.nextLocalAddr(proto),
+			Device:    c.Interface,
+			Proxy:     c.Proxy,
+			Exclude:   c.Exclude,
 		}
 		conn, err := d.Dial(proto, addr)
-		conn.maxTlsVersion = c.TLSVersion
-		if err == nil {
-			conn.SetDeadline(deadline)
+		conn.SetTLSVersionBounds(c.TLSMinVersion, c.TLSVersion)
+		if err != nil {
+			return conn.getUnderlyingConn(), err
 		}
-		return conn.getUnderlyingConn(), err
+		conn.SetDeadline(deadline)
+		conn.SetMaxReadBytes(c.MaxReadBytes)
+		conn.WithContext(c.Context)
+		conn.SetTotalTimeout(c.TotalTimeout)
+		return conn.transportConn(), nil
 	}
 }
 
@@ -146,8 +264,15 @@ func makeTLSConfig(config *Config, urlHost string) *ztls.Config {
 	tlsConfig := new(ztls.Config)
 	tlsConfig.InsecureSkipVerify = true
 	tlsConfig.MinVersion = ztls.VersionSSL30
+	if config.TLSMinVersion != 0 {
+		tlsConfig.MinVersion = config.TLSMinVersion
+	}
 	tlsConfig.MaxVersion = config.TLSVersion
 	tlsConfig.RootCAs = config.RootCAPool
+	tlsConfig.CTLogs = config.CTLogs
+	if config.ClientCertificate != nil {
+		tlsConfig.Certificates = []ztls.Certificate{*config.ClientCertificate}
+	}
 	tlsConfig.HeartbeatEnabled = true
 	tlsConfig.ClientDSAEnabled = true
 	if config.DHEOnly {
@@ -183,6 +308,9 @@ func makeTLSConfig(config *Config, urlHost string) *ztls.Config {
 		tlsConfig.CipherSuites = ztls.SafariNoDHECiphers
 		tlsConfig.ForceSuites = true
 	}
+	if config.CipherSuites != nil {
+		tlsConfig.CipherSuites = config.CipherSuites
+	}
 	if config.TLSExtendedRandom {
 		tlsConfig.ExtendedRandom = true
 	}
@@ -192,12 +320,16 @@ func makeTLSConfig(config *Config, urlHost string) *ztls.Config {
 	if config.GatherSessionTicket {
 		tlsConfig.ForceSessionTicketExt = true
 	}
-	if !config.NoSNI && urlHost != "" {
-		tlsConfig.ServerName = urlHost
+	if config.TLSFalseStart {
+		tlsConfig.FalseStart = true
+	}
+	if !config.NoSNI {
+		tlsConfig.ServerName = serverNameIndication(urlHost)
 	}
 	if config.ExternalClientHello != nil {
 		tlsConfig.ExternalClientHello = config.ExternalClientHello
 	}
+	tlsConfig.ClientHelloProfile = config.TLSClientHelloProfile
 
 	return tlsConfig
 }
@@ -212,8 +344,50 @@ func containsPort(host string) bool {
 	return strings.LastIndex(host, ":") > strings.LastIndex(host, "]")
 }
 
+// readHTTPBody reads at most maxSize kilobytes of the body into BodyText and
+// flags longer bodies as truncated rather than failing
+func readHTTPBody(res *http.Response, maxSize int) {
+	b := new(bytes.Buffer)
+	maxReadLen := int64(maxSize) * 1024
+	readLen := maxReadLen + 1
+	if res.ContentLength >= 0 && res.ContentLength < maxReadLen {
+		readLen = res.ContentLength
+	}
+	io.CopyN(b, res.Body, readLen)
+	if int64(b.Len()) > maxReadLen {
+		b.Truncate(int(maxReadLen))
+		res.BodyTruncated = true
+	}
+	res.BodyText = b.String()
+	if len(res.BodyText) > 0 {
+		m := sha256.New()
+		m.Write(b.Bytes())
+		res.BodySHA256 = m.Sum(nil)
+	}
+}
+
+// hostWithoutPort strips an optional port from a URL host
+func hostWithoutPort(host string) string {
+	if !containsPort(host) {
+		return host
+	}
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
+	}
+	return hostname
+}
+
 func makeHTTPGrabber(config *Config, grabData *GrabData) func(string, string, string) error {
 	g := func(urlHost, endpoint, httpHost string) (err error) {
+		if err := checkArguments("HTTP host", httpHost, "HTTP endpoint", endpoint, "HTTP user agent", config.HTTP.UserAgent); err != nil {
+			return err
+		}
+		for key, value := range config.HTTP.Headers {
+			if err := checkArguments("HTTP header name", key, "HTTP header "+key, value); err != nil {
+				return err
+			}
+		}
 
 		var tlsConfig *ztls.Config
 		if config.TLS {
@@ -231,24 +405,24 @@ func makeHTTPGrabber(config *Config, grabData *GrabData) func(string, string, st
 
 		client := http.MakeNewClient()
 		client.UserAgent = config.HTTP.UserAgent
+		client.Headers = make(http.Header, len(config.HTTP.Headers))
+		for key, value := range config.HTTP.Headers {
+			client.Headers.Set(key, value)
+		}
 		client.CheckRedirect = func(req *http.Request, res *http.Response, via []*http.Request) error {
 			grabData.HTTP.RedirectResponseChain = append(grabData.HTTP.RedirectResponseChain, res)
-			b := new(bytes.Buffer)
-			maxReadLen := int64(config.HTTP.MaxSize) * 1024
-			readLen := maxReadLen
-			if res.ContentLength >= 0 && res.ContentLength < maxReadLen {
-				readLen = res.ContentLength
-			}
-			io.CopyN(b, res.Body, readLen)
-			res.BodyText = b.String()
-			if len(res.BodyText) > 0 {
-				m := sha256.New()
-				m.Write(b.Bytes())
-				res.BodySHA256 = m.Sum(nil)
-			}
+			readHTTPBody(res, config.HTTP.MaxSize)
 
 			if len(via) > config.HTTP.MaxRedirects {
-				return errors.New(fmt.Sprintf("stopped after %d redirects", config.HTTP.MaxRedirects))
+				return &redirectStop{fmt.Sprintf("stopped after %d redirects", config.HTTP.MaxRedirects)}
+			}
+			for _, prev := range via {
+				if prev.URL.String() == req.URL.String() {
+					return &redirectStop{"redirect loop to " + req.URL.String()}
+				}
+			}
+			if config.HTTP.RedirectSameHost && !strings.EqualFold(hostWithoutPort(req.URL.Host), hostWithoutPort(via[0].URL.Host)) {
+				return &redirectStop{"redirect to off-scope host " + req.URL.Host}
 			}
 
 			if req.URL.Scheme == "https" && transport.TLSClientConfig == nil {
@@ -301,24 +475,19 @@ func makeHTTPGrabber(config *Config, grabData *GrabData) func(string, string, st
 		}
 		grabData.HTTP.Response = resp
 
+		// The body of the last redirect was already read in CheckRedirect
+		if urlErr, ok := err.(*url.Error); ok {
+			if stop, ok := urlErr.Err.(*redirectStop); ok {
+				grabData.HTTP.RedirectStopReason = stop.reason
+				return nil
+			}
+		}
 		if err != nil {
 			config.ErrorLog.Errorf("Could not connect to remote host %s: %s", fullURL, err.Error())
 			return err
 		}
 
-		b := new(bytes.Buffer)
-		maxReadLen := int64(config.HTTP.MaxSize) * 1024
-		readLen := maxReadLen
-		if resp.ContentLength >= 0 && resp.ContentLength < maxReadLen {
-			readLen = resp.ContentLength
-		}
-		io.CopyN(b, resp.Body, readLen)
-		grabData.HTTP.Response.BodyText = b.String()
-		if len(grabData.HTTP.Response.BodyText) > 0 {
-			m := sha256.New()
-			m.Write(b.Bytes())
-			grabData.HTTP.Response.BodySHA256 = m.Sum(nil)
-		}
+		readHTTPBody(resp, config.HTTP.MaxSize)
 
 		return nil
 	}
@@ -326,12 +495,50 @@ func makeHTTPGrabber(config *Config, grabData *GrabData) func(string, string, st
 	return g
 }
 
+// mailCapabilities sends IMAP CAPABILITY or POP3 CAPA, whichever protocol
+// the grab conforms to
+func mailCapabilities(config *Config, c *Conn) error {
+	var err error
+	if config.IMAP {
+		err = c.IMAPCapability()
+	} else {
+		err = c.POP3Capa()
+	}
+	if err != nil {
+		c.erroredComponent = "capabilities"
+	}
+	return err
+}
+
+// expandData substitutes the remote IP for %s and the target domain for %d
+// in the --data message. A domain with control characters is refused rather
+// than substituted.
+func expandData(c *Conn, data []byte) ([]byte, error) {
+	host, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+	msg := bytes.Replace(data, []byte("%s"), []byte(host), -1)
+	if bytes.Contains(msg, []byte("%d")) {
+		if err := checkArgument("domain", c.domain); err != nil {
+			return nil, err
+		}
+	}
+	return bytes.Replace(msg, []byte("%d"), []byte(c.domain), -1), nil
+}
+
 func makeGrabber(config *Config) func(*Conn) error {
 	// Do all the hard work here
 	g := func(c *Conn) error {
-		banner := make([]byte, 1024)
-		response := make([]byte, 65536)
+		bannerBuf, responseBuf := getReadBuffer(1024), getReadBuffer(65536)
+		defer putReadBuffer(bannerBuf, *bannerBuf)
+		defer putReadBuffer(responseBuf, *responseBuf)
+		banner, response := *bannerBuf, *responseBuf
 		c.SetCAPool(config.RootCAPool)
+		c.SetCTLogs(config.CTLogs)
+		c.SetClientCertificate(config.ClientCertificate)
+		if config.Trace {
+			c.SetDebugLogger(config.ErrorLog)
+		}
+		c.SetResponseEncoding(config.OperationResponses)
+		c.SetRecordWritePayloads(!config.HashWritePayloads)
 		if config.DHEOnly {
 			c.CipherSuites = ztls.DHECiphers
 		}
@@ -339,10 +546,10 @@ func makeGrabber(config *Config) func(*Conn) error {
 			c.CipherSuites = ztls.ECDHECiphers
 		}
 		if config.ExportsOnly {
-			c.CipherSuites = ztls.RSA512ExportCiphers
+			c.SetExportProbe(ExportProbeRSA)
 		}
 		if config.ExportsDHOnly {
-			c.CipherSuites = ztls.DHEExportCiphers
+			c.SetExportProbe(ExportProbeDHE)
 		}
 		if config.ChromeOnly {
 			c.CipherSuites = ztls.ChromeCiphers
@@ -364,24 +571,48 @@ func makeGrabber(config *Config) func(*Conn) error {
 			c.CipherSuites = ztls.SafariNoDHECiphers
 			c.ForceSuites = true
 		}
+		if config.CipherSuites != nil {
+			c.CipherSuites = config.CipherSuites
+		}
 		if config.NoSNI {
 			c.SetNoSNI()
 		}
+		if config.NoOCSPStapling {
+			c.SetNoOCSPStapling()
+		}
+		if config.NextProtos != nil {
+			c.SetNextProtos(config.NextProtos)
+		}
 		if config.TLSExtendedRandom {
 			c.SetExtendedRandom()
 		}
 		if config.GatherSessionTicket {
 			c.SetGatherSessionTicket()
 		}
+		if config.TLSResumption {
+			c.SetResumptionCheck()
+		}
 		if config.SignedCertificateTimestampExt {
 			c.SetSignedCertificateTimestampExt()
 		}
 		if config.ExtendedMasterSecret {
 			c.SetOfferExtendedMasterSecret()
 		}
+		if config.CaptureHandshakeBytes {
+			c.SetCaptureHandshakeBytes()
+		}
+		if config.TLSCertificatesOnly {
+			c.SetTLSCertificatesOnly()
+		}
+		if config.TLSFalseStart {
+			c.SetTLSFalseStart()
+		}
 		if config.ExternalClientHello != nil {
 			c.SetExternalClientHello(config.ExternalClientHello)
 		}
+		if config.TLSClientHelloProfile != nil {
+			c.SetClientHelloProfile(config.TLSClientHelloProfile)
+		}
 		if config.TLSVerbose {
 			c.SetTLSVerbose()
 		}
@@ -396,6 +627,23 @@ func makeGrabber(config *Config) func(*Conn) error {
 				c.erroredComponent = "tls"
 				return err
 			}
+			// The handshake was abandoned, there is no session to go on with
+			if config.TLSCertificatesOnly {
+				return nil
+			}
+		}
+		if config.DTLS {
+			c.SetDTLSRetransmission(config.DTLSRetransmitTimeout, config.DTLSMaxRetransmits)
+			if err := c.DTLSHandshake(); err != nil {
+				c.erroredComponent = "dtls"
+				return err
+			}
+		}
+		if config.SSLv2 {
+			if err := c.SSLv2Probe(); err != nil {
+				c.erroredComponent = "sslv2"
+				return err
+			}
 		}
 		if config.Banners {
 			if config.SMTP {
@@ -422,9 +670,7 @@ func makeGrabber(config *Config) func(*Conn) error {
 		}
 
 		if config.FTP {
-			c.grabData.FTP = new(ftp.FTPLog)
-
-			is200Banner, err := ftp.GetFTPBanner(c.grabData.FTP, c.getUnderlyingConn())
+			is200Banner, err := c.FTPBanner()
 			if err != nil {
 				c.erroredComponent = "ftp"
 				return err
@@ -438,6 +684,118 @@ func makeGrabber(config *Config) func(*Conn) error {
 			}
 		}
 
+		if config.XMPP {
+			domain := config.XMPPDomain
+			if domain == "" {
+				domain = c.domain
+			}
+			if domain == "" {
+				domain, _, _ = net.SplitHostPort(c.RemoteAddr().String())
+			}
+			if err := c.XMPPStartTLSHandshake(domain); err != nil {
+				c.erroredComponent = "xmpp"
+				return err
+			}
+		}
+
+		if config.LDAP {
+			if err := c.LDAPStartTLSHandshake(); err != nil {
+				c.erroredComponent = "ldap"
+				return err
+			}
+		}
+
+		if config.LDAPRootDSE {
+			if err := c.LDAPRootDSE(); err != nil {
+				c.erroredComponent = "ldap_rootdse"
+				return err
+			}
+		}
+
+		if config.Postgres {
+			if err := c.PostgresStartTLSHandshake(); err != nil {
+				c.erroredComponent = "postgres"
+				return err
+			}
+		}
+
+		if config.PostgresStartup {
+			if err := c.PostgresStartup(); err != nil {
+				c.erroredComponent = "postgres_startup"
+				return err
+			}
+		}
+
+		if config.Cassandra {
+			if err := c.CassandraOptions(); err != nil {
+				c.erroredComponent = "cassandra"
+				return err
+			}
+		}
+
+		if config.AMQP {
+			if err := c.AMQPStart(); err != nil {
+				c.erroredComponent = "amqp"
+				return err
+			}
+		}
+
+		if config.MQTT {
+			if err := c.MQTTConnect(); err != nil {
+				c.erroredComponent = "mqtt"
+				return err
+			}
+		}
+
+		if config.MySQL {
+			if err := c.MySQLHandshake(config.MySQLTLS); err != nil {
+				c.erroredComponent = "mysql"
+				return err
+			}
+		}
+
+		if config.VNC {
+			if err := c.VNCHandshake(config.VNCTLS); err != nil {
+				c.erroredComponent = "vnc"
+				return err
+			}
+		}
+
+		if config.RDP {
+			if err := c.RDPHandshake(); err != nil {
+				c.erroredComponent = "rdp"
+				return err
+			}
+		}
+
+		if config.SMB {
+			if err := c.SMBNegotiate(); err != nil {
+				c.erroredComponent = "smb"
+				return err
+			}
+		}
+
+		if config.NNTP {
+			if err := c.NNTPBanner(); err != nil {
+				c.erroredComponent = "nntp"
+				return err
+			}
+		}
+
+		if config.IRC {
+			if err := c.IRCBanner(); err != nil {
+				c.erroredComponent = "irc"
+				return err
+			}
+		}
+
+		if config.MongoDB {
+			if err := c.MongoDBInfo(); err != nil {
+				c.erroredComponent = "mongodb"
+				return err
+			}
+		}
+
 		if config.Fox {
 			c.grabData.Fox = new(fox.FoxLog)
 
@@ -448,9 +806,7 @@ func makeGrabber(config *Config) func(*Conn) error {
 		}
 
 		if config.Telnet {
-			c.grabData.Telnet = new(telnet.TelnetLog)
-
-			if err := telnet.GetTelnetBanner(c.grabData.Telnet, c.getUnderlyingConn(), config.TelnetMaxSize); err != nil {
+			if err := c.TelnetBanner(config.TelnetMaxSize); err != nil {
 				c.erroredComponent = "telnet"
 				return err
 			}
@@ -476,11 +832,44 @@ func makeGrabber(config *Config) func(*Conn) error {
 				return err
 			}
 		}
+		if config.XSSH.XSSH {
+			var err error
+			if config.XSSH.AuthProbe {
+				err = c.XSSHAuthMethodsProbe(config.XSSH.AuthProbeUser)
+			} else {
+				err = c.XSSHHandshake()
+			}
+			if err != nil {
+				c.erroredComponent = "xssh"
+				return err
+			}
+			if config.XSSH.HostKeys {
+				if err := c.XSSHHostKeyEnumeration(config.XSSH.HostKeyMaxConnections); err != nil {
+					c.erroredComponent = "xssh_host_keys"
+					return err
+				}
+			}
+		}
 
-		if config.SendData {
-			host, _, _ := net.SplitHostPort(c.RemoteAddr().String())
-			msg := bytes.Replace(config.Data, []byte("%s"), []byte(host), -1)
-			msg = bytes.Replace(msg, []byte("%d"), []byte(c.domain), -1)
+		if config.BannerProbe {
+			var msg []byte
+			if config.SendData {
+				var err error
+				if msg, err = expandData(c, config.Data); err != nil {
+					c.erroredComponent = "write"
+					return err
+				}
+			}
+			if err := c.BannerProbe(msg, config.BannerProbeUntil, config.BannerProbeMaxBytes, config.BannerProbeTimeout); err != nil {
+				c.erroredComponent = "banner_probe"
+				return err
+			}
+		} else if config.SendData {
+			msg, err := expandData(c, config.Data)
+			if err != nil {
+				c.erroredComponent = "write"
+				return err
+			}
 			if _, err := c.Write(msg); err != nil {
 				c.erroredComponent = "write"
 				return err
@@ -503,6 +892,29 @@ func makeGrabber(config *Config) func(*Conn) error {
 				return err
 			}
 		}
+		if config.SMTPVrfy != "" {
+			if err := c.SMTPVrfy(config.SMTPVrfy); err != nil {
+				c.erroredComponent = "smtp_vrfy"
+				return err
+			}
+		}
+		if config.SMTPExpn != "" {
+			if err := c.SMTPExpn(config.SMTPExpn); err != nil {
+				c.erroredComponent = "smtp_expn"
+				return err
+			}
+		}
+		if config.SMTPAuth {
+			if err := c.SMTPAuthProbe(config.EHLODomain); err != nil {
+				c.erroredComponent = "smtp_auth"
+				return err
+			}
+		}
+		if config.MailCapabilities {
+			if err := mailCapabilities(config, c); err != nil {
+				return err
+			}
+		}
 		if config.StartTLS {
 			if config.IMAP {
 				if err := c.IMAPStartTLSHandshake(); err != nil {
@@ -520,28 +932,85 @@ func makeGrabber(config *Config) func(*Conn) error {
 					return err
 				}
 			}
+			if config.MailCapabilities {
+				if err := mailCapabilities(config, c); err != nil {
+					return err
+				}
+			}
 		}
 
-		if config.SMTP {
-			if err := c.SMTPQuit(); err != nil {
-				c.erroredComponent = "quit"
+		if config.Modbus {
+			if err := c.ModbusIdentify(); err != nil {
+				c.erroredComponent = "modbus"
 				return err
 			}
-		} else if config.POP3 {
-			if err := c.POP3Quit(); err != nil {
-				c.erroredComponent = "quit"
+		}
+
+		if config.Memcached {
+			if err := c.MemcachedStats(); err != nil {
+				c.erroredComponent = "memcached"
 				return err
 			}
-		} else if config.IMAP {
-			if err := c.IMAPQuit(); err != nil {
-				c.erroredComponent = "quit"
+		}
+
+		if config.Redis {
+			if err := c.RedisInfo(); err != nil {
+				c.erroredComponent = "redis"
 				return err
 			}
 		}
 
-		if config.Modbus {
-			if _, err := c.SendModbusEcho(); err != nil {
-				c.erroredComponent = "modbus"
+		if config.UDPProbe != nil {
+			if err := c.UDPProbe(config.UDPProbe, config.UDPMaxResponses); err != nil {
+				c.erroredComponent = "udp"
+				return err
+			}
+		}
+
+		if config.NTP {
+			if err := c.NTPQuery(config.NTPReadVar); err != nil {
+				c.erroredComponent = "ntp"
+				return err
+			}
+		}
+
+		if config.SNMP {
+			if err := c.SNMPQuery(config.SNMPVersion, config.SNMPCommunities); err != nil {
+				c.erroredComponent = "snmp"
+				return err
+			}
+		}
+
+		if config.SIP {
+			if err := c.SIPOptions(config.SIPTCP); err != nil {
+				c.erroredComponent = "sip"
+				return err
+			}
+		}
+
+		if config.Kerberos {
+			if err := c.KerberosASRequest(config.KerberosRealm, config.KerberosTCP); err != nil {
+				c.erroredComponent = "kerberos"
+				return err
+			}
+		}
+
+		if config.SSDP {
+			if err := c.SSDPSearch(config.UDPMaxResponses); err != nil {
+				c.erroredComponent = "ssdp"
+				return err
+			}
+			if config.SSDPFetch {
+				if err := fetchSSDPDescription(config, c, config.SSDPFetchAnyHost); err != nil {
+					c.erroredComponent = "ssdp_description"
+					return err
+				}
+			}
+		}
+
+		if config.DNSProbe {
+			if err := c.DNSProbe(config.DNSProbeName, config.DNSProbeVersion); err != nil {
+				c.erroredComponent = "dns_probe"
 				return err
 			}
 		}
@@ -553,13 +1022,40 @@ func makeGrabber(config *Config) func(*Conn) error {
 			}
 		}
 
-		if config.Heartbleed {
-			buf := make([]byte, 256)
-			if _, err := c.CheckHeartbleed(buf); err != nil {
+		if config.Heartbleed && config.HeartbleedSafe {
+			if err := c.CheckHeartbeat(); err != nil {
+				c.erroredComponent = "heartbleed"
+				return err
+			}
+		} else if config.Heartbleed {
+			buf := make([]byte, config.HeartbleedSampleSize)
+			if _, err := c.CheckHeartbleedProbes(config.HeartbleedProbes, config.HeartbleedPayloadLength, config.HeartbleedDelay, buf); err != nil {
 				c.erroredComponent = "heartbleed"
 				return err
 			}
 		}
+
+		if config.TLSResumption {
+			if err := c.ResumptionCheck(); err != nil {
+				c.erroredComponent = "resumption"
+				return err
+			}
+		}
+
+		if config.TLSCurves {
+			if err := c.CurveEnumeration(DefaultEnumerationCurves, config.TLSCurvesMaxConnections); err != nil {
+				c.erroredComponent = "curves"
+				return err
+			}
+		}
+
+		// Leaves the connection mid-handshake, so it goes last
+		if config.TLSRenegotiation {
+			if err := c.RenegotiationCheck(); err != nil {
+				c.erroredComponent = "renegotiation"
+				return err
+			}
+		}
 		return nil
 	}
 	// Wrap the whole thing in a logger
@@ -570,89 +1066,54 @@ func makeGrabber(config *Config) func(*Conn) error {
 				c.RemoteAddr().String(), err.Error())
 		}
 
-		c.Close()
-		return err
-	}
-}
-
-func makeXSSHGrabber(gblConfig *Config, grabData GrabData) func(string) error {
-	return func(netAddr string) error {
-
-		xsshConfig := xssh.MakeXSSHConfig()
-		xsshConfig.Timeout = gblConfig.Timeout
-		xsshConfig.ConnLog = grabData.XSSH
-		_, err := xssh.Dial("tcp", netAddr, xsshConfig)
-		if err != nil {
+		switch {
+		case config.SMTP:
+			c.SetGoodbye(SMTPGoodbye)
+		case config.POP3:
+			c.SetGoodbye(POP3Goodbye)
+		case config.IMAP:
+			c.SetGoodbye(IMAPGoodbye)
+		case config.FTP:
+			c.SetGoodbye(FTPGoodbye)
+		default:
+			c.drainOrClose()
 			return err
 		}
-
-		return nil
+		c.Quit()
+		return err
 	}
 }
 
+// GrabBanner grabs the target, retrying as configured
 func GrabBanner(config *Config, target *GrabTarget) *Grab {
-	if config.XSSH.XSSH {
-		t := time.Now()
-
-		grabData := GrabData{XSSH: new(xssh.HandshakeLog)}
-		xsshGrabber := makeXSSHGrabber(config, grabData)
-
-		port := strconv.FormatUint(uint64(config.Port), 10)
-		rhost := net.JoinHostPort(target.Addr.String(), port)
-
-		err := xsshGrabber(rhost)
+	return grabWithRetries(config, func() *Grab {
+		return grabOnce(config, target)
+	})
+}
 
-		return &Grab{
-			IP:    target.Addr,
-			Time:  t,
-			Data:  grabData,
-			Error: err,
-		}
-	} else if len(config.HTTP.Endpoint) == 0 {
-		dial := makeDialer(config)
-		grabber := makeGrabber(config)
-		port := strconv.FormatUint(uint64(config.Port), 10)
-		addr := target.Addr.String()
-		rhost := net.JoinHostPort(addr, port)
-		t := time.Now()
-		conn, dialErr := dial(rhost)
-		if target.Domain != "" {
-			conn.SetDomain(target.Domain)
-		}
-		if dialErr != nil {
-			// Could not connect to host
-			config.ErrorLog.Errorf("Could not connect to %s remote host %s: %s",
-				target.Domain, addr, dialErr.Error())
-			return &Grab{
-				IP:             target.Addr,
-				Domain:         target.Domain,
-				Time:           t,
-				Error:          dialErr,
-				ErrorComponent: "connect",
-			}
-		}
-		err := grabber(conn)
-		return &Grab{
-			IP:             target.Addr,
-			Domain:         target.Domain,
-			Time:           t,
-			Data:           conn.grabData,
-			Error:          err,
-			ErrorComponent: conn.erroredComponent,
-		}
+func grabOnce(config *Config, target *GrabTarget) *Grab {
+	if len(config.HTTP.Endpoint) == 0 {
+		return grabConn(config, target, makeGrabber(config))
 	} else {
 		grabData := GrabData{HTTP: new(HTTP)}
 		httpGrabber := makeHTTPGrabber(config, &grabData)
-		port := strconv.FormatUint(uint64(config.Port), 10)
 		t := time.Now()
 		var rhost string
-		if config.LookupDomain {
+		if config.LookupDomain && target.Addr == nil {
 			rhost = target.Domain
 		} else {
-			rhost = net.JoinHostPort(target.Addr.String(), port)
+			rhost = target.hostPort(config.Port)
 		}
 
 		err := httpGrabber(rhost, config.HTTP.Endpoint, target.Domain)
+		if res := grabData.HTTP.Response; err == nil && res != nil {
+			if config.HTTP.Elasticsearch {
+				grabData.Elasticsearch = parseElasticsearch(res)
+			}
+			if config.HTTP.CouchDB {
+				grabData.CouchDB = parseCouchDB(res)
+			}
+		}
 
 		return &Grab{
 			IP:     target.Addr,
@@ -663,3 +1124,55 @@ func GrabBanner(config *Config, target *GrabTarget) *Grab {
 		}
 	}
 }
+
+// grabConn dials the target and runs grabber on the connection
+func grabConn(config *Config, target *GrabTarget, grabber func(*Conn) error) *Grab {
+	dial := makeDialer(config)
+	addr := target.Addr.String()
+	rhost := target.hostPort(config.Port)
+	t := time.Now()
+	conn, dialErr := dial(rhost)
+	if target.Domain != "" {
+		conn.SetDomain(target.Domain)
+	}
+	if dialErr != nil {
+		// Could not connect to host
+		config.ErrorLog.Errorf("Could not connect to %s remote host %s: %s",
+			target.Domain, addr, dialErr.Error())
+		component := "connect"
+		switch dialErr.(type) {
+		case *ProxyError:
+			component = "proxy"
+		case *ExcludedError:
+			component = "excluded"
+		}
+		conn.grabData.Summary = conn.Summary()
+		return &Grab{
+			IP:             target.Addr,
+			Domain:         target.Domain,
+			Time:           t,
+			Data:           conn.grabData,
+			Error:          dialErr,
+			ErrorComponent: component,
+		}
+	}
+	conn.SetRedialer(func() (*Conn, error) {
+		return dial(rhost)
+	})
+	if config.DNSProbe {
+		dialTCP := makeProtoDialer(config, "tcp")
+		conn.SetTCPRedialer(func() (*Conn, error) {
+			return dialTCP(rhost)
+		})
+	}
+	err := grabber(conn)
+	conn.grabData.Summary = conn.Summary()
+	return &Grab{
+		IP:             target.Addr,
+		Domain:         target.Domain,
+		Time:           t,
+		Data:           conn.grabData,
+		Error:          err,
+		ErrorComponent: conn.erroredComponent,
+	}
+}
@@ -85,6 +85,44 @@ func TestHTTP(t *testing.T) {
 	}
 }
 
+func TestHTTPBodyTruncatedWithCustomHeader(t *testing.T) {
+	body := strings.Repeat("a", 2048)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Headers.Get("X-Scan") != "zgrab" {
+			t.Errorf("Wrong custom header - expected: %s, got: %s", "zgrab", r.Headers.Get("X-Scan"))
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	addr, port := getAddrAndPortForServer(ts)
+	config := &zlib.Config{
+		Port:               port,
+		Timeout:            time.Duration(3) * time.Second,
+		TLSVersion:         ztls.VersionTLS12,
+		Senders:            1,
+		ConnectionsPerHost: 1,
+		HTTP: zlib.HTTPConfig{
+			Endpoint:  "/",
+			Method:    "GET",
+			UserAgent: "test UA",
+			MaxSize:   1,
+			Headers:   map[string]string{"X-Scan": "zgrab"},
+		},
+		ErrorLog:   zlog.New(os.Stderr, "banner-grab"),
+		GOMAXPROCS: 1,
+	}
+
+	grab := zlib.GrabBanner(config, &zlib.GrabTarget{Addr: addr})
+	httpResponse := grab.Data.HTTP.Response
+	if httpResponse == nil {
+		t.Fatalf("No HTTP response: %v", grab.Error)
+	}
+	if len(httpResponse.BodyText) != 1024 || !httpResponse.BodyTruncated {
+		t.Errorf("Body not truncated - expected 1024 bytes, got %d (truncated: %t)", len(httpResponse.BodyText), httpResponse.BodyTruncated)
+	}
+}
+
 func getAddrAndPortForServer(s *httptest.Server) (net.IP, uint16) {
 	var addr net.IP
 	var port uint16
@@ -109,8 +147,9 @@ func TestHTTPToHTTPSRedirect(t *testing.T) {
 	var tlsServerHostString string
 
 	tlsServer := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
-		if r.TLS.ServerName != tlsServerHostString {
-			t.Errorf("Wrong SNI - expected: %s, got: %s", tlsServerHostString, r.TLS.ServerName)
+		// The redirect target is an IP literal, which must not be sent as SNI
+		if r.TLS.ServerName != "" {
+			t.Errorf("Wrong SNI - expected none, got: %s", r.TLS.ServerName)
 		}
 
 		fmt.Fprintf(w, TEST_SERVER_BODY)
@@ -176,4 +215,177 @@ func TestHTTPToHTTPSRedirect(t *testing.T) {
 	}
 }
 
+func TestHTTPRedirectLoopStopsCleanly(t *testing.T) {
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		target := "/a"
+		if r.URL.Path == "/a" {
+			target = "/"
+		}
+		Redirect(w, r, target, StatusFound)
+	}))
+	defer ts.Close()
+
+	addr, port := getAddrAndPortForServer(ts)
+	config := &zlib.Config{
+		Port:               port,
+		Timeout:            time.Duration(3) * time.Second,
+		TLSVersion:         ztls.VersionTLS12,
+		Senders:            1,
+		ConnectionsPerHost: 1,
+		HTTP: zlib.HTTPConfig{
+			Endpoint:     "/",
+			Method:       "GET",
+			UserAgent:    "test UA",
+			MaxSize:      256,
+			MaxRedirects: 5,
+		},
+		ErrorLog:   zlog.New(os.Stderr, "banner-grab"),
+		GOMAXPROCS: 1,
+	}
+
+	grab := zlib.GrabBanner(config, &zlib.GrabTarget{Addr: addr})
+	if grab.Error != nil {
+		t.Fatalf("Redirect loop reported as an error: %s", grab.Error.Error())
+	}
+	httpData := grab.Data.HTTP
+	if !strings.HasPrefix(httpData.RedirectStopReason, "redirect loop") {
+		t.Errorf("Wrong redirect stop reason: %q", httpData.RedirectStopReason)
+	}
+	if len(httpData.RedirectResponseChain) != 2 {
+		t.Errorf("Incorrect number of redirects: Expected: 2, got: %d", len(httpData.RedirectResponseChain))
+	}
+}
+
+func TestGrabTargetDecoderAddresses(t *testing.T) {
+	tests := []struct {
+		input string
+		addr  string
+		zone  string
+		port  uint16
+	}{
+		{"192.0.2.1", "192.0.2.1", "", 0},
+		{"192.0.2.1:8443", "192.0.2.1", "", 8443},
+		{"2001:db8::1", "2001:db8::1", "", 0},
+		{"[2001:db8::1]", "2001:db8::1", "", 0},
+		{"[2001:db8::1]:8443", "2001:db8::1", "", 8443},
+		{"fe80::1%eth0", "fe80::1", "eth0", 0},
+		{"[fe80::1%eth0]:22", "fe80::1", "eth0", 22},
+	}
+	for _, test := range tests {
+		decoder := zlib.NewGrabTargetDecoder(strings.NewReader(test.input+",example.com\n"), false)
+		next, err := decoder.DecodeNext()
+		if err != nil {
+			t.Errorf("%s: %s", test.input, err.Error())
+			continue
+		}
+		target := next.(zlib.GrabTarget)
+		if !target.Addr.Equal(net.ParseIP(test.addr)) || target.Zone != test.zone || target.Port != test.port {
+			t.Errorf("%s: got address %s, zone %q, port %d", test.input, target.Addr, target.Zone, target.Port)
+		}
+		if target.Domain != "example.com" {
+			t.Errorf("%s: wrong domain %q", test.input, target.Domain)
+		}
+	}
+
+	for _, input := range []string{"[2001:db8::1", "[2001:db8::1]443", "192.0.2.1:0", "192.0.2.1%eth0", "fe80::1%", "example.com"} {
+		decoder := zlib.NewGrabTargetDecoder(strings.NewReader(input+"\n"), false)
+		if _, err := decoder.DecodeNext(); err == nil {
+			t.Errorf("%s: invalid address accepted", input)
+		}
+	}
+}
+
+func jsonProbeConfig(port uint16) *zlib.Config {
+	return &zlib.Config{
+		Port:               port,
+		Timeout:            time.Duration(3) * time.Second,
+		TLSVersion:         ztls.VersionTLS12,
+		Senders:            1,
+		ConnectionsPerHost: 1,
+		HTTP: zlib.HTTPConfig{
+			Endpoint:  "/",
+			Method:    "GET",
+			UserAgent: "test UA",
+			MaxSize:   256,
+		},
+		ErrorLog:   zlog.New(os.Stderr, "banner-grab"),
+		GOMAXPROCS: 1,
+	}
+}
+
+func TestElasticsearch(t *testing.T) {
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"name":"node-1","cluster_name":"logs","cluster_uuid":"u4Rk","version":{"number":"7.17.9","build_flavor":"default","lucene_version":"8.11.1"},"tagline":"You Know, for Search"}`)
+	}))
+	defer ts.Close()
+
+	addr, port := getAddrAndPortForServer(ts)
+	config := jsonProbeConfig(port)
+	config.HTTP.Elasticsearch = true
+	grab := zlib.GrabBanner(config, &zlib.GrabTarget{Addr: addr})
+	es := grab.Data.Elasticsearch
+	if es == nil {
+		t.Fatalf("No Elasticsearch log: %v", grab.Error)
+	}
+	if es.Outcome != zlib.HTTPJSONOK || es.ClusterName != "logs" || es.Version != "7.17.9" || es.Tagline != "You Know, for Search" || es.Body != "" {
+		t.Errorf("Wrong Elasticsearch log: %+v", es)
+	}
+}
+
+func TestElasticsearchAuthRequired(t *testing.T) {
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="security" charset="UTF-8"`)
+		w.WriteHeader(StatusUnauthorized)
+		fmt.Fprint(w, `{"error":{"type":"security_exception"},"status":401}`)
+	}))
+	defer ts.Close()
+
+	addr, port := getAddrAndPortForServer(ts)
+	config := jsonProbeConfig(port)
+	config.HTTP.Elasticsearch = true
+	grab := zlib.GrabBanner(config, &zlib.GrabTarget{Addr: addr})
+	if grab.Error != nil {
+		t.Fatalf("401 reported as an error: %s", grab.Error.Error())
+	}
+	es := grab.Data.Elasticsearch
+	if es.Outcome != zlib.HTTPJSONAuthRequired || es.StatusCode != 401 || !strings.HasPrefix(es.Authenticate, "Basic") || es.Body != "" {
+		t.Errorf("Wrong Elasticsearch log: %+v", es)
+	}
+}
+
+func TestCouchDB(t *testing.T) {
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprint(w, `{"couchdb":"Welcome","version":"3.3.2","git_sha":"11a234070","uuid":"9f3e","features":["access-ready","scheduler"],"vendor":{"name":"The Apache Software Foundation"}}`)
+	}))
+	defer ts.Close()
+
+	addr, port := getAddrAndPortForServer(ts)
+	config := jsonProbeConfig(port)
+	config.HTTP.CouchDB = true
+	grab := zlib.GrabBanner(config, &zlib.GrabTarget{Addr: addr})
+	db := grab.Data.CouchDB
+	if db == nil {
+		t.Fatalf("No CouchDB log: %v", grab.Error)
+	}
+	if db.Outcome != zlib.HTTPJSONOK || db.Welcome != "Welcome" || db.Version != "3.3.2" || len(db.Features) != 2 || db.Vendor != "The Apache Software Foundation" {
+		t.Errorf("Wrong CouchDB log: %+v", db)
+	}
+}
+
+func TestCouchDBUnexpectedBody(t *testing.T) {
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprint(w, "<html>It works!</html>")
+	}))
+	defer ts.Close()
+
+	addr, port := getAddrAndPortForServer(ts)
+	config := jsonProbeConfig(port)
+	config.HTTP.CouchDB = true
+	grab := zlib.GrabBanner(config, &zlib.GrabTarget{Addr: addr})
+	if db := grab.Data.CouchDB; db.Outcome != zlib.HTTPJSONUnexpected || db.Body != "<html>It works!</html>" {
+		t.Errorf("Raw body not kept: %+v", db)
+	}
+}
+
 // TODO: add tests for more complex HTTP behavior/options
@@ -0,0 +1,142 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/util"
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+)
+
+// DrainAndClose reads at most this many bytes after the half-close
+const drainMaxResponseSize = 64 * 1024
+
+// ErrHalfCloseUnavailable is returned by CloseWrite when the connection
+// cannot be half-closed, e.g. once TLS is up or over UDP
+var ErrHalfCloseUnavailable = errors.New("half-close unavailable on this connection")
+
+// A DrainLog records the half-close sent by DrainAndClose and whatever the
+// server sent after it
+type DrainLog struct {
+	HalfClosed           bool   `json:"half_closed"`
+	HalfCloseUnavailable bool   `json:"half_close_unavailable,omitempty"`
+	Response             string `json:"response,omitempty"`
+	ServerClosed         bool   `json:"server_closed,omitempty"`
+	Error                string `json:"error,omitempty"`
+	ErrorClass           string `json:"error_class,omitempty"`
+}
+
+// A closeWriter is a connection that can shut down its writing side alone,
+// e.g. a *net.TCPConn
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// baseConn returns conn with the wrappers installed by Dial and the
+// connection options removed
+func baseConn(conn net.Conn) net.Conn {
+	for {
+		switch w := conn.(type) {
+		case *countingConn:
+			conn = w.Conn
+		case *readLimitConn:
+			conn = w.Conn
+		case *budgetConn:
+			conn = w.Conn
+		case *contextConn:
+			conn = w.Conn
+		default:
+			return conn
+		}
+	}
+}
+
+// CloseWrite sends a FIN while leaving the connection open for reading.
+// Through TLS, which would need its own close_notify first, or on a
+// connection that is not TCP it returns ErrHalfCloseUnavailable.
+func (c *Conn) CloseWrite() error {
+	if c.isTls {
+		return ErrHalfCloseUnavailable
+	}
+	cw, ok := baseConn(c.conn).(closeWriter)
+	if !ok {
+		return ErrHalfCloseUnavailable
+	}
+	defer c.recordOperation(OperationCloseWrite, time.Now())
+	err := cw.CloseWrite()
+	c.tracef("sent half-close")
+	return err
+}
+
+// DrainAndClose half-closes the connection, reads what the server sends
+// until it closes its side, timeout elapses or the read cap is reached,
+// then closes the connection. Some servers only flush their last response
+// once they see the FIN. When the half-close is unavailable the connection
+// is closed straight away. Failures are recorded rather than returned; the
+// only error returned is from Close.
+func (c *Conn) DrainAndClose(timeout time.Duration) error {
+	d := new(DrainLog)
+	c.grabData.Drain = d
+	if err := c.CloseWrite(); err == ErrHalfCloseUnavailable {
+		d.HalfCloseUnavailable = true
+		return c.Close()
+	} else if err != nil {
+		d.Error = err.Error()
+		d.ErrorClass = zerrors.Classify(err)
+		return c.Close()
+	}
+	d.HalfClosed = true
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	if c.readDeadline.IsZero() || deadline.Before(c.readDeadline) {
+		c.SetReadDeadline(deadline)
+	}
+	res, err := c.readDrain()
+	c.traceResponse("drain", res, err)
+	d.Response = string(res)
+	if err == io.EOF {
+		d.ServerClosed = true
+	} else if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		d.Error = err.Error()
+		d.ErrorClass = zerrors.Classify(err)
+	}
+	c.recordResponse(OperationDrain, start, res)
+	return c.Close()
+}
+
+// readDrain reads until an error, which is util.ErrBufferFull once
+// drainMaxResponseSize bytes have been read
+func (c *Conn) readDrain() ([]byte, error) {
+	p := getReadBuffer(1024)
+	defer putReadBuffer(p, *p)
+	var res []byte
+	for len(res) < drainMaxResponseSize {
+		buf := *p
+		if want := drainMaxResponseSize - len(res); want < len(buf) {
+			buf = buf[0:want]
+		}
+		n, err := c.getUnderlyingConn().Read(buf)
+		res = append(res, buf[0:n]...)
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, util.ErrBufferFull
+}
@@ -0,0 +1,108 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func dialDrainServer(t *testing.T, serve func(*net.TCPConn)) (*Conn, func()) {
+	addr, stop := closingServer(t, serve)
+	d := Dialer{Deadline: time.Now().Add(3 * time.Second)}
+	c, err := d.Dial("tcp", addr)
+	if err != nil {
+		stop()
+		t.Fatalf("Dial: %s", err.Error())
+	}
+	return c, stop
+}
+
+func TestDrainAndCloseReadsAfterFIN(t *testing.T) {
+	c, stop := dialDrainServer(t, func(conn *net.TCPConn) {
+		// Only answer once the client is done writing
+		ioutil.ReadAll(conn)
+		conn.Write([]byte("220 late banner\r\n"))
+		conn.Close()
+	})
+	defer stop()
+
+	if err := c.DrainAndClose(2 * time.Second); err != nil {
+		t.Fatalf("DrainAndClose: %s", err.Error())
+	}
+	d := c.grabData.Drain
+	if d == nil || !d.HalfClosed || !d.ServerClosed || d.Response != "220 late banner\r\n" || d.Error != "" {
+		t.Fatalf("Wrong drain log: %+v", d)
+	}
+	var types []string
+	for _, op := range c.Operations() {
+		types = append(types, op.Type)
+	}
+	if len(types) < 3 || types[len(types)-3] != OperationCloseWrite || types[len(types)-2] != OperationDrain {
+		t.Errorf("Wrong operations: %v", types)
+	}
+}
+
+func TestDrainAndCloseTimesOut(t *testing.T) {
+	done := make(chan struct{})
+	c, stop := dialDrainServer(t, func(conn *net.TCPConn) {
+		conn.Write([]byte("partial"))
+		<-done
+		conn.Close()
+	})
+	defer stop()
+	defer close(done)
+
+	c.DrainAndClose(100 * time.Millisecond)
+	if d := c.grabData.Drain; d == nil || !d.HalfClosed || d.ServerClosed || d.Response != "partial" || d.Error != "" {
+		t.Errorf("Wrong drain log: %+v", d)
+	}
+}
+
+func TestDrainAndCloseWithoutHalfClose(t *testing.T) {
+	c, server := pipeConn()
+	defer server.Close()
+
+	if err := c.CloseWrite(); err != ErrHalfCloseUnavailable {
+		t.Errorf("Expected ErrHalfCloseUnavailable, got %v", err)
+	}
+	c.DrainAndClose(time.Second)
+	if d := c.grabData.Drain; d == nil || d.HalfClosed || !d.HalfCloseUnavailable {
+		t.Errorf("Wrong drain log: %+v", d)
+	}
+	if _, err := server.Write([]byte("x")); err == nil {
+		t.Errorf("Connection not closed")
+	}
+}
+
+func TestQuitDrainsWithDrainTimeout(t *testing.T) {
+	c, stop := dialDrainServer(t, func(conn *net.TCPConn) {
+		ioutil.ReadAll(conn)
+		conn.Write([]byte("221 Bye\r\n"))
+		conn.Close()
+	})
+	defer stop()
+
+	c.SetDrainTimeout(2 * time.Second)
+	c.Quit()
+	if q := c.grabData.Quit; q == nil || q.Command != "QUIT" || q.Error != "" {
+		t.Errorf("Wrong quit log: %+v", q)
+	}
+	if d := c.grabData.Drain; d == nil || !d.HalfClosed || !d.ServerClosed || d.Response != "221 Bye\r\n" {
+		t.Errorf("Wrong drain log: %+v", d)
+	}
+}
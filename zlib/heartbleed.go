@@ -0,0 +1,89 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import "fmt"
+
+// Stages of a STARTTLS Heartbleed flow, in the order they run
+const (
+	HeartbleedStageBanner     = "banner"
+	HeartbleedStageEHLO       = "ehlo"
+	HeartbleedStageStartTLS   = "starttls"
+	HeartbleedStageHeartbleed = "heartbleed"
+)
+
+// A HeartbleedFlowError is returned by the STARTTLS Heartbleed flows when a
+// stage fails. Every operation up to and including the failed one has been
+// recorded.
+type HeartbleedFlowError struct {
+	Stage string
+	Err   error
+}
+
+func (e *HeartbleedFlowError) Error() string {
+	return fmt.Sprintf("Heartbleed flow failed at %s: %s", e.Stage, e.Err.Error())
+}
+
+// heartbleedStage is one step of a flow
+type heartbleedStage struct {
+	name string
+	run  func() error
+}
+
+// runHeartbleedFlow runs stages in order followed by a Heartbleed probe into
+// b, stopping at the first that fails. The failed stage is also recorded as
+// the errored component.
+func (c *Conn) runHeartbleedFlow(b []byte, stages ...heartbleedStage) error {
+	stages = append(stages, heartbleedStage{HeartbleedStageHeartbleed, func() error {
+		_, err := c.CheckHeartbleed(b)
+		return err
+	}})
+	for _, stage := range stages {
+		if err := stage.run(); err != nil {
+			c.erroredComponent = stage.name
+			return &HeartbleedFlowError{Stage: stage.name, Err: err}
+		}
+	}
+	return nil
+}
+
+// SMTPHeartbleed reads the SMTP banner, sends EHLO for domain, upgrades the
+// connection with STARTTLS and sends a Heartbleed probe, reading any leaked
+// bytes into b. A failure is returned as a *HeartbleedFlowError.
+func (c *Conn) SMTPHeartbleed(domain string, b []byte) error {
+	return c.runHeartbleedFlow(b,
+		heartbleedStage{HeartbleedStageBanner, c.readSMTPBanner},
+		heartbleedStage{HeartbleedStageEHLO, func() error {
+			return c.EHLO(domain)
+		}},
+		heartbleedStage{HeartbleedStageStartTLS, c.SMTPStartTLSHandshake},
+	)
+}
+
+// IMAPHeartbleed is SMTPHeartbleed for IMAP, which has no EHLO stage
+func (c *Conn) IMAPHeartbleed(b []byte) error {
+	return c.runHeartbleedFlow(b,
+		heartbleedStage{HeartbleedStageBanner, c.readIMAPBanner},
+		heartbleedStage{HeartbleedStageStartTLS, c.IMAPStartTLSHandshake},
+	)
+}
+
+// POP3Heartbleed is SMTPHeartbleed for POP3, which has no EHLO stage
+func (c *Conn) POP3Heartbleed(b []byte) error {
+	return c.runHeartbleedFlow(b,
+		heartbleedStage{HeartbleedStageBanner, c.readPOP3Banner},
+		heartbleedStage{HeartbleedStageStartTLS, c.POP3StartTLSHandshake},
+	)
+}
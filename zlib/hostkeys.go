@@ -0,0 +1,103 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/xssh"
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+)
+
+// A HostKeyEnumerationLog records the host keys a server presents when
+// each of its advertised host key algorithms is forced in turn
+type HostKeyEnumerationLog struct {
+	HostKeys []*HostKeyResult `json:"host_keys,omitempty"`
+
+	// Algorithms left untested because the connection limit was reached
+	Untested    []string `json:"untested,omitempty"`
+	Connections int      `json:"connections"`
+}
+
+// A HostKeyResult is the outcome of a handshake offering a single host key
+// algorithm: the key the server signed the exchange with, or why the
+// exchange failed
+type HostKeyResult struct {
+	Algorithm  string                     `json:"algorithm"`
+	HostKey    *xssh.ServerHostKeyJsonLog `json:"host_key,omitempty"`
+	Error      string                     `json:"error,omitempty"`
+	ErrorClass string                     `json:"error_class,omitempty"`
+}
+
+// XSSHHostKeyEnumeration opens up to maxConnections new connections with the
+// redialer and performs one key exchange on each, offering only one of the
+// host key algorithms the server advertised in the XSSHHandshake. A failed
+// exchange is recorded against its algorithm; only a failure to open a
+// connection is returned as an error.
+func (c *Conn) XSSHHostKeyEnumeration(maxConnections int) error {
+	if c.grabData.XSSH == nil || c.grabData.XSSH.ServerKex == nil {
+		return fmt.Errorf(
+			"Must perform SSH handshake before enumerating host keys with %s",
+			c.RemoteAddr().String())
+	}
+	if c.redial == nil {
+		return errors.New("No redialer set for host key enumeration")
+	}
+	defer c.recordOperation(OperationXSSHHostKeys, time.Now())
+
+	algorithms := c.grabData.XSSH.ServerKex.ServerHostKeyAlgos
+	out := new(HostKeyEnumerationLog)
+	c.grabData.XSSHHostKeys = out
+	for i, algorithm := range algorithms {
+		if out.Connections >= maxConnections {
+			out.Untested = algorithms[i:]
+			return nil
+		}
+		result, err := c.hostKeyHandshake(algorithm)
+		out.Connections++
+		if err != nil {
+			return err
+		}
+		out.HostKeys = append(out.HostKeys, result)
+	}
+	return nil
+}
+
+// hostKeyHandshake performs a key exchange on a new connection offering only
+// algorithm, and returns the host key whose signature over the exchange
+// verified
+func (c *Conn) hostKeyHandshake(algorithm string) (*HostKeyResult, error) {
+	conn, err := c.redial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result := &HostKeyResult{Algorithm: algorithm}
+	config := xssh.MakeXSSHConfig()
+	config.HostKeyAlgorithms = []string{algorithm}
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key xssh.PublicKey) error {
+		result.HostKey = xssh.LogServerHostKey(key.Marshal())
+		return nil
+	}
+	if _, _, _, err := xssh.NewClientConn(conn.getUnderlyingConn(), conn.RemoteAddr().String(), config); err != nil {
+		result.Error = err.Error()
+		result.ErrorClass = zerrors.Classify(err)
+	}
+	return result, nil
+}
@@ -82,6 +82,17 @@ type HTTP struct {
 	ProxyResponse         *HTTPResponse    `json:"connect_response,omitempty"`
 	Response              *http.Response   `json:"response,omitempty"`
 	RedirectResponseChain []*http.Response `json:"redirect_response_chain,omitempty"`
+	RedirectStopReason    string           `json:"redirect_stop_reason,omitempty"`
+}
+
+// A redirectStop is returned from CheckRedirect to end a redirect chain
+// without failing the grab
+type redirectStop struct {
+	reason string
+}
+
+func (r *redirectStop) Error() string {
+	return r.reason
 }
 
 type HTTPRequestResponse struct {
@@ -0,0 +1,141 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"encoding/json"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/http"
+)
+
+// Outcomes of a JSON endpoint probe. A server refusing anonymous access is
+// recorded rather than failed, as are bodies that are not the expected JSON.
+const (
+	HTTPJSONOK           = "ok"
+	HTTPJSONAuthRequired = "auth_required"
+	HTTPJSONUnexpected   = "unexpected_response"
+)
+
+// An ElasticsearchLog holds the fields of the banner Elasticsearch serves at
+// GET /. Body is only kept when the response could not be decoded.
+type ElasticsearchLog struct {
+	Outcome       string `json:"outcome"`
+	StatusCode    int    `json:"status_code,omitempty"`
+	Authenticate  string `json:"www_authenticate,omitempty"`
+	Name          string `json:"name,omitempty"`
+	ClusterName   string `json:"cluster_name,omitempty"`
+	ClusterUUID   string `json:"cluster_uuid,omitempty"`
+	Version       string `json:"version,omitempty"`
+	BuildFlavor   string `json:"build_flavor,omitempty"`
+	LuceneVersion string `json:"lucene_version,omitempty"`
+	Tagline       string `json:"tagline,omitempty"`
+	Body          string `json:"body,omitempty"`
+}
+
+// A CouchDBLog holds the fields of CouchDB's welcome document at GET /
+type CouchDBLog struct {
+	Outcome      string   `json:"outcome"`
+	StatusCode   int      `json:"status_code,omitempty"`
+	Authenticate string   `json:"www_authenticate,omitempty"`
+	Welcome      string   `json:"couchdb,omitempty"`
+	Version      string   `json:"version,omitempty"`
+	GitSHA       string   `json:"git_sha,omitempty"`
+	UUID         string   `json:"uuid,omitempty"`
+	Features     []string `json:"features,omitempty"`
+	Vendor       string   `json:"vendor,omitempty"`
+	Body         string   `json:"body,omitempty"`
+}
+
+// httpJSONOutcome classifies the status of res. The body is only decoded
+// when it returns HTTPJSONOK.
+func httpJSONOutcome(res *http.Response) string {
+	switch {
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return HTTPJSONAuthRequired
+	case res.StatusCode != http.StatusOK || res.BodyTruncated:
+		return HTTPJSONUnexpected
+	}
+	return HTTPJSONOK
+}
+
+func parseElasticsearch(res *http.Response) *ElasticsearchLog {
+	log := &ElasticsearchLog{
+		Outcome:      httpJSONOutcome(res),
+		StatusCode:   res.StatusCode,
+		Authenticate: res.Headers.Get("WWW-Authenticate"),
+	}
+	if log.Outcome == HTTPJSONOK {
+		var banner struct {
+			Name        string `json:"name"`
+			ClusterName string `json:"cluster_name"`
+			ClusterUUID string `json:"cluster_uuid"`
+			Version     struct {
+				Number        string `json:"number"`
+				BuildFlavor   string `json:"build_flavor"`
+				LuceneVersion string `json:"lucene_version"`
+			} `json:"version"`
+			Tagline string `json:"tagline"`
+		}
+		if err := json.Unmarshal([]byte(res.BodyText), &banner); err != nil || banner.Version.Number == "" {
+			log.Outcome = HTTPJSONUnexpected
+		} else {
+			log.Name = banner.Name
+			log.ClusterName = banner.ClusterName
+			log.ClusterUUID = banner.ClusterUUID
+			log.Version = banner.Version.Number
+			log.BuildFlavor = banner.Version.BuildFlavor
+			log.LuceneVersion = banner.Version.LuceneVersion
+			log.Tagline = banner.Tagline
+		}
+	}
+	if log.Outcome == HTTPJSONUnexpected {
+		log.Body = res.BodyText
+	}
+	return log
+}
+
+func parseCouchDB(res *http.Response) *CouchDBLog {
+	log := &CouchDBLog{
+		Outcome:      httpJSONOutcome(res),
+		StatusCode:   res.StatusCode,
+		Authenticate: res.Headers.Get("WWW-Authenticate"),
+	}
+	if log.Outcome == HTTPJSONOK {
+		var welcome struct {
+			CouchDB  string   `json:"couchdb"`
+			Version  string   `json:"version"`
+			GitSHA   string   `json:"git_sha"`
+			UUID     string   `json:"uuid"`
+			Features []string `json:"features"`
+			Vendor   struct {
+				Name string `json:"name"`
+			} `json:"vendor"`
+		}
+		if err := json.Unmarshal([]byte(res.BodyText), &welcome); err != nil || welcome.CouchDB == "" {
+			log.Outcome = HTTPJSONUnexpected
+		} else {
+			log.Welcome = welcome.CouchDB
+			log.Version = welcome.Version
+			log.GitSHA = welcome.GitSHA
+			log.UUID = welcome.UUID
+			log.Features = welcome.Features
+			log.Vendor = welcome.Vendor.Name
+		}
+	}
+	if log.Outcome == HTTPJSONUnexpected {
+		log.Body = res.BodyText
+	}
+	return log
+}
@@ -0,0 +1,212 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/util"
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+)
+
+// An IRCLog records the registration numerics 001 to 004 and the 005
+// ISUPPORT tokens, with the whole exchange kept in Raw. Tokens without a
+// value map to "".
+type IRCLog struct {
+	Nick       string            `json:"nick"`
+	Welcome    string            `json:"welcome,omitempty"`
+	YourHost   string            `json:"your_host,omitempty"`
+	Created    string            `json:"created,omitempty"`
+	ServerName string            `json:"server_name,omitempty"`
+	Version    string            `json:"version,omitempty"`
+	UserModes  string            `json:"user_modes,omitempty"`
+	ChanModes  string            `json:"channel_modes,omitempty"`
+	Network    string            `json:"network,omitempty"`
+	ISupport   map[string]string `json:"isupport,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	ErrorClass string            `json:"error_class,omitempty"`
+	Raw        string            `json:"raw,omitempty"`
+}
+
+// An ircMessage is a parsed line, the trailing parameter being the last
+// entry of Params
+type ircMessage struct {
+	Command string
+	Params  []string
+}
+
+func parseIRCMessage(line string) *ircMessage {
+	if strings.HasPrefix(line, ":") {
+		space := strings.IndexByte(line, ' ')
+		if space < 0 {
+			return nil
+		}
+		line = line[space+1:]
+	}
+	m := new(ircMessage)
+	for line != "" {
+		if strings.HasPrefix(line, ":") && m.Command != "" {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		var field string
+		if space := strings.IndexByte(line, ' '); space >= 0 {
+			field, line = line[:space], strings.TrimLeft(line[space+1:], " ")
+		} else {
+			field, line = line, ""
+		}
+		if m.Command == "" {
+			m.Command = strings.ToUpper(field)
+		} else {
+			m.Params = append(m.Params, field)
+		}
+	}
+	if m.Command == "" {
+		return nil
+	}
+	return m
+}
+
+// trailing returns the last parameter, the human readable text of most
+// numerics
+func (m *ircMessage) trailing() string {
+	if len(m.Params) == 0 {
+		return ""
+	}
+	return m.Params[len(m.Params)-1]
+}
+
+var errIRCNotRegistered = errors.New("IRC server closed the connection before registration completed")
+
+// IRCBanner registers with a throwaway nick, answering PINGs and retrying
+// once with another nick if it is taken, and reads until the end of the
+// MOTD. At most kvMaxResponseSize bytes are read. QUIT is sent once the
+// numerics are in.
+func (c *Conn) IRCBanner() error {
+	log := &IRCLog{Nick: "zgrab" + sipToken(3), ISupport: make(map[string]string)}
+	c.grabData.IRC = log
+	start := time.Now()
+	register := "NICK " + log.Nick + "\r\nUSER zgrab 0 * :zgrab\r\n"
+	if _, err := c.getUnderlyingConn().Write([]byte(register)); err != nil {
+		c.recordOperation(OperationIRCRegister, start)
+		return err
+	}
+
+	var raw, pending []byte
+	buf := make([]byte, 4096)
+	retried := false
+	err := func() error {
+		for {
+			n, err := c.getUnderlyingConn().Read(buf)
+			raw = append(raw, buf[:n]...)
+			pending = append(pending, buf[:n]...)
+			for {
+				end := bytes.IndexByte(pending, '\n')
+				if end < 0 {
+					break
+				}
+				line := strings.TrimRight(string(pending[:end]), "\r")
+				pending = pending[end+1:]
+				m := parseIRCMessage(line)
+				if m == nil {
+					continue
+				}
+				switch m.Command {
+				case "PING":
+					if _, err := c.getUnderlyingConn().Write([]byte("PONG :" + m.trailing() + "\r\n")); err != nil {
+						return err
+					}
+				case "433":
+					if retried {
+						return errors.New("IRC nick in use: " + m.trailing())
+					}
+					retried = true
+					log.Nick = "zgrab" + sipToken(4)
+					if _, err := c.getUnderlyingConn().Write([]byte("NICK " + log.Nick + "\r\n")); err != nil {
+						return err
+					}
+				case "ERROR":
+					log.Error = m.trailing()
+					log.ErrorClass = zerrors.ProtocolError
+					return errors.New("IRC server error: " + log.Error)
+				case "376", "422":
+					return nil
+				default:
+					log.addNumeric(m)
+				}
+			}
+			if err != nil {
+				if log.Welcome != "" {
+					return nil
+				}
+				return err
+			}
+			if len(raw) >= kvMaxResponseSize {
+				return util.ErrBufferFull
+			}
+		}
+	}()
+	log.Raw = string(raw)
+	c.traceResponse("IRC", raw, err)
+	c.recordResponse(OperationIRCRegister, start, raw)
+	if err == nil && log.Welcome == "" {
+		err = errIRCNotRegistered
+	}
+	if log.Network == "" {
+		log.Network = log.ISupport["NETWORK"]
+	}
+
+	quit := time.Now()
+	c.getUnderlyingConn().Write([]byte("QUIT :zgrab\r\n"))
+	c.recordOperation(OperationQuit, quit)
+	return err
+}
+
+// addNumeric records the registration numerics; the first parameter of
+// each is our nick
+func (log *IRCLog) addNumeric(m *ircMessage) {
+	switch m.Command {
+	case "001":
+		log.Welcome = m.trailing()
+	case "002":
+		log.YourHost = m.trailing()
+	case "003":
+		log.Created = m.trailing()
+	case "004":
+		// <nick> <servername> <version> <user modes> <channel modes> ...
+		fields := append(m.Params, "", "", "", "")[1:5]
+		log.ServerName, log.Version, log.UserModes, log.ChanModes = fields[0], fields[1], fields[2], fields[3]
+	case "005":
+		// <nick> TOKEN[=value] ... :are supported by this server
+		if len(m.Params) < 3 {
+			return
+		}
+		for _, token := range m.Params[1 : len(m.Params)-1] {
+			kv := strings.SplitN(token, "=", 2)
+			if strings.HasPrefix(kv[0], "-") {
+				delete(log.ISupport, kv[0][1:])
+				continue
+			}
+			if len(kv) == 2 {
+				log.ISupport[kv[0]] = kv[1]
+			} else {
+				log.ISupport[kv[0]] = ""
+			}
+		}
+	}
+}
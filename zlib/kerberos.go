@@ -0,0 +1,88 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/kerberos"
+)
+
+// kerberosDefaultRealm is asked for when neither --kerberos-realm nor a
+// domain is known; a KDC answers a foreign realm with an error all the same
+const kerberosDefaultRealm = "ZGRAB.INVALID"
+
+// Longest reply accepted over TCP; a KRB-ERROR is a few hundred bytes
+const kerberosMaxReplySize = 64 * 1024
+
+var errKerberosReplyTooLong = errors.New("Kerberos reply too long")
+
+// KerberosASRequest sends an AS-REQ for a random principal and records the
+// KDC's reply. Over UDP, datagrams that are not a Kerberos reply are skipped;
+// over TCP each message carries a four byte length.
+func (c *Conn) KerberosASRequest(realm string, tcp bool) error {
+	log := &kerberos.KerberosLog{Transport: "UDP", Realm: realm, Principal: kerberos.RandomPrincipal()}
+	if tcp {
+		log.Transport = "TCP"
+	}
+	if log.Realm == "" {
+		log.Realm = strings.ToUpper(c.domain)
+	}
+	if log.Realm == "" {
+		log.Realm = kerberosDefaultRealm
+	}
+	c.grabData.Kerberos = log
+
+	req, err := kerberos.ASRequest(log.Realm, log.Principal)
+	if err != nil {
+		return err
+	}
+	defer c.recordOperation(OperationKerberosASReq, time.Now())
+	if !tcp {
+		if _, err := c.Write(req); err != nil {
+			return err
+		}
+		return c.readDatagrams(func(b []byte) (bool, error) {
+			if err := kerberos.ParseReply(log, b); err != nil {
+				return false, nil
+			}
+			log.Raw = append([]byte(nil), b...)
+			return true, nil
+		})
+	}
+
+	framed := make([]byte, 4, 4+len(req))
+	binary.BigEndian.PutUint32(framed, uint32(len(req)))
+	if _, err := c.Write(append(framed, req...)); err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length > kerberosMaxReplySize {
+		return errKerberosReplyTooLong
+	}
+	log.Raw = make([]byte, length)
+	if _, err := io.ReadFull(c, log.Raw); err != nil {
+		return err
+	}
+	return kerberos.ParseReply(log, log.Raw)
+}
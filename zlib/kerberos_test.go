@@ -0,0 +1,71 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"testing"
+	"time"
+)
+
+// A KRB-ERROR with KDC_ERR_C_PRINCIPAL_UNKNOWN for realm EXAMPLE.COM
+var krbErrorPrincipalUnknown, _ = hex.DecodeString("7e6f306da003020105a10302011ea411180f32303234303330313132303030305aa504020204d2a603020106a90d1b0b4558414d504c452e434f4daa20301ea003020102a11730151b066b72627467741b0b4558414d504c452e434f4dab121b10434c49454e545f4e4f545f464f554e44")
+
+func TestKerberosASRequestUDP(t *testing.T) {
+	addr, stop := udpServer(t, func(request []byte) [][]byte {
+		if request[0] != 0x6a {
+			return nil
+		}
+		return [][]byte{[]byte("noise"), krbErrorPrincipalUnknown}
+	})
+	defer stop()
+	c := dialUDP(t, addr, 2*time.Second)
+	defer c.Close()
+	c.SetDomain("example.com")
+
+	if err := c.KerberosASRequest("", false); err != nil {
+		t.Fatalf("KerberosASRequest: %s", err.Error())
+	}
+	log := c.grabData.Kerberos
+	if log.Realm != "EXAMPLE.COM" || !log.KDC || log.ErrorName != "KDC_ERR_C_PRINCIPAL_UNKNOWN" || log.ServerRealm != "EXAMPLE.COM" {
+		t.Errorf("Wrong log: %+v", log)
+	}
+	if len(log.Raw) != len(krbErrorPrincipalUnknown) {
+		t.Errorf("Noise recorded as the reply: %x", log.Raw)
+	}
+}
+
+func TestKerberosASRequestTCP(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+	go func() {
+		header := make([]byte, 4)
+		io.ReadFull(server, header)
+		io.ReadFull(server, make([]byte, binary.BigEndian.Uint32(header)))
+		reply := make([]byte, 4)
+		binary.BigEndian.PutUint32(reply, uint32(len(krbErrorPrincipalUnknown)))
+		server.Write(append(reply, krbErrorPrincipalUnknown...))
+	}()
+
+	if err := c.KerberosASRequest("CORP.EXAMPLE.COM", true); err != nil {
+		t.Fatalf("KerberosASRequest: %s", err.Error())
+	}
+	if log := c.grabData.Kerberos; log.Transport != "TCP" || log.Realm != "CORP.EXAMPLE.COM" || log.ErrorCode != 6 {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
@@ -0,0 +1,196 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/util"
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+)
+
+// Outcomes of a memcached or Redis probe. A server demanding authentication
+// is a result in its own right rather than an error.
+const (
+	KVOutcomeSuccess      = "success"
+	KVOutcomeAuthRequired = "auth_required"
+)
+
+// Largest stats or INFO reply read
+const kvMaxResponseSize = 64 * 1024
+
+// A MemcachedLog records the reply to the text protocol stats command
+type MemcachedLog struct {
+	Outcome    string            `json:"outcome,omitempty"`
+	Stats      map[string]string `json:"stats,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	ErrorClass string            `json:"error_class,omitempty"`
+}
+
+// A stats reply ends with END, or with one of the error lines
+var memcachedEndRegex = regexp.MustCompile(`(?:^|\r\n)(?:END|ERROR|CLIENT_ERROR[^\r\n]*|SERVER_ERROR[^\r\n]*)\r\n$`)
+
+// MemcachedStats sends stats and parses the STAT lines of the reply. Since
+// stats takes no argument, a CLIENT_ERROR means the server wants the client
+// to authenticate first.
+func (c *Conn) MemcachedStats() error {
+	log := new(MemcachedLog)
+	c.grabData.Memcached = log
+	start := time.Now()
+	if _, err := c.getUnderlyingConn().Write([]byte("stats\r\n")); err != nil {
+		c.recordOperation(OperationMemcachedStats, start)
+		return err
+	}
+	res, err := c.readKVResponse(make([]byte, 1024), func(b []byte) bool {
+		return memcachedEndRegex.Match(b)
+	})
+	c.traceResponse("memcached", res, err)
+	c.recordResponse(OperationMemcachedStats, start, res)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(res), "\r\n"), "\r\n")
+	last := lines[len(lines)-1]
+	switch {
+	case strings.HasPrefix(last, "CLIENT_ERROR"):
+		log.Outcome = KVOutcomeAuthRequired
+		log.Error = last
+		log.ErrorClass = zerrors.ProtocolError
+		return nil
+	case last != "END":
+		log.Error = last
+		log.ErrorClass = zerrors.ProtocolError
+		return errors.New("memcached stats failed: " + last)
+	}
+	log.Outcome = KVOutcomeSuccess
+	log.Stats = make(map[string]string)
+	for _, line := range lines[:len(lines)-1] {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) == 3 && fields[0] == "STAT" {
+			log.Stats[fields[1]] = fields[2]
+		}
+	}
+	return nil
+}
+
+// A RedisLog records the fields of the reply to INFO that identify a server
+type RedisLog struct {
+	Outcome          string `json:"outcome,omitempty"`
+	Version          string `json:"version,omitempty"`
+	OS               string `json:"os,omitempty"`
+	Role             string `json:"role,omitempty"`
+	ConnectedClients int    `json:"connected_clients,omitempty"`
+	Error            string `json:"error,omitempty"`
+	ErrorClass       string `json:"error_class,omitempty"`
+}
+
+// redisReplyComplete reports whether b holds a whole RESP error, simple
+// string or bulk string reply
+func redisReplyComplete(b []byte) bool {
+	end := strings.Index(string(b), "\r\n")
+	if end < 0 {
+		return false
+	}
+	if b[0] != '$' {
+		return true
+	}
+	n, err := strconv.Atoi(string(b[1:end]))
+	return err != nil || n < 0 || len(b) >= end+2+n+2
+}
+
+// RedisInfo sends INFO as an inline command and parses the bulk string
+// reply. A -NOAUTH error means the server requires AUTH.
+func (c *Conn) RedisInfo() error {
+	log := new(RedisLog)
+	c.grabData.Redis = log
+	start := time.Now()
+	if _, err := c.getUnderlyingConn().Write([]byte("INFO\r\n")); err != nil {
+		c.recordOperation(OperationRedisInfo, start)
+		return err
+	}
+	res, err := c.readKVResponse(make([]byte, 4096), redisReplyComplete)
+	c.traceResponse("Redis", res, err)
+	c.recordResponse(OperationRedisInfo, start, res)
+	if err != nil {
+		return err
+	}
+	reply := string(res)
+	end := strings.Index(reply, "\r\n")
+	switch reply[0] {
+	case '-':
+		log.Error = reply[1:end]
+		log.ErrorClass = zerrors.ProtocolError
+		if strings.HasPrefix(log.Error, "NOAUTH") {
+			log.Outcome = KVOutcomeAuthRequired
+			return nil
+		}
+		return errors.New("Redis INFO failed: " + log.Error)
+	case '$':
+		n, err := strconv.Atoi(reply[1:end])
+		if err != nil || n < 0 {
+			return errors.New("Invalid Redis bulk string length: " + reply[1:end])
+		}
+		reply = reply[end+2 : end+2+n]
+	default:
+		return errors.New("Unexpected Redis reply to INFO: " + reply[:end])
+	}
+	log.Outcome = KVOutcomeSuccess
+	for _, line := range strings.Split(reply, "\r\n") {
+		i := strings.IndexByte(line, ':')
+		if i < 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		value := line[i+1:]
+		switch line[:i] {
+		case "redis_version":
+			log.Version = value
+		case "os":
+			log.OS = value
+		case "role":
+			log.Role = value
+		case "connected_clients":
+			log.ConnectedClients, _ = strconv.Atoi(value)
+		}
+	}
+	return nil
+}
+
+// readKVResponse reads into res, growing it up to kvMaxResponseSize, until
+// complete matches the accumulated data
+func (c *Conn) readKVResponse(res []byte, complete func([]byte) bool) ([]byte, error) {
+	length := 0
+	for {
+		if length == len(res) {
+			if length >= kvMaxResponseSize {
+				return res[0:length], util.ErrBufferFull
+			}
+			grown := make([]byte, 2*len(res))
+			copy(grown, res[0:length])
+			res = grown
+		}
+		n, err := c.getUnderlyingConn().Read(res[length:])
+		length += n
+		if err != nil {
+			return res[0:length], err
+		}
+		if complete(res[0:length]) {
+			return res[0:length], nil
+		}
+	}
+}
@@ -14,7 +14,287 @@
 
 package zlib
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // An SMTPHelpEvent represents sending a "HELP" message over SMTP
 type SMTPHelpEvent struct {
 	Response string
 }
+
+// Classifications of the reply to a VRFY or EXPN probe
+const (
+	SMTPProbeAllowed   = "allowed"
+	SMTPProbeDisabled  = "disabled"
+	SMTPProbeRejected  = "rejected"
+	SMTPProbeTemporary = "temporary_failure"
+)
+
+// An SMTPProbeEvent represents sending a VRFY or EXPN command over SMTP
+type SMTPProbeEvent struct {
+	Argument string `json:"argument"`
+	Code     int    `json:"code,omitempty"`
+	Response string `json:"response,omitempty"`
+	Result   string `json:"result,omitempty"`
+}
+
+// classifySMTPProbe maps a VRFY/EXPN reply code onto a classification. A 252
+// means the server will not disclose whether the address exists.
+func classifySMTPProbe(code int) string {
+	switch {
+	case code == 252:
+		return SMTPProbeDisabled
+	case code >= 200 && code < 300:
+		return SMTPProbeAllowed
+	case code >= 400 && code < 500:
+		return SMTPProbeTemporary
+	case code >= 500 && code < 600:
+		return SMTPProbeRejected
+	}
+	return ""
+}
+
+// The greeting an SMTP server accepted, see EHLO
+const (
+	SMTPGreetingEHLO = "ehlo"
+	SMTPGreetingHELO = "helo"
+)
+
+// smtpReplyCode returns the three-digit code starting a reply, or 0 if it
+// does not start with one
+func smtpReplyCode(response string) int {
+	if len(response) < 3 {
+		return 0
+	}
+	code, err := strconv.Atoi(response[0:3])
+	if err != nil {
+		return 0
+	}
+	return code
+}
+
+// checkSMTPArgument rejects an argument that is empty or contains control
+// characters, see checkArgument
+func checkSMTPArgument(command, argument string) error {
+	field := command + " argument"
+	if argument == "" {
+		return &InvalidArgumentError{Field: field}
+	}
+	return checkArgument(field, argument)
+}
+
+// An SMTPExtension is a service extension advertised in an EHLO response,
+// e.g. SIZE with parameter 10485760 or AUTH with the mechanisms offered
+type SMTPExtension struct {
+	Keyword    string   `json:"keyword"`
+	Parameters []string `json:"parameters,omitempty"`
+}
+
+// parseEHLOResponse returns the extensions listed in a 250 EHLO response.
+// The first line is the server's greeting and carries no extension.
+func parseEHLOResponse(response string) []*SMTPExtension {
+	lines := strings.Split(strings.TrimSuffix(response, "\r\n"), "\r\n")
+	var extensions []*SMTPExtension
+	for i, line := range lines {
+		if i == 0 || len(line) < 4 || !strings.HasPrefix(line, "250") {
+			continue
+		}
+		fields := strings.Fields(line[4:])
+		if len(fields) == 0 {
+			continue
+		}
+		ext := &SMTPExtension{Keyword: strings.ToUpper(fields[0])}
+		if len(fields) > 1 {
+			ext.Parameters = fields[1:]
+		}
+		extensions = append(extensions, ext)
+	}
+	return extensions
+}
+
+// An SMTPAuthLog records the AUTH mechanisms advertised in EHLO before and
+// after STARTTLS. No AUTH command is ever sent.
+type SMTPAuthLog struct {
+	PlaintextMechanisms  []string `json:"plaintext_mechanisms,omitempty"`
+	StartTLSOffered      bool     `json:"starttls_offered"`
+	TLSEHLO              string   `json:"tls_ehlo,omitempty"`
+	TLSMechanisms        []string `json:"tls_mechanisms,omitempty"`
+	AddedAfterStartTLS   []string `json:"added_after_starttls,omitempty"`
+	RemovedAfterStartTLS []string `json:"removed_after_starttls,omitempty"`
+	PlainOverPlaintext   bool     `json:"plain_over_plaintext"`
+}
+
+// authMechanisms returns the mechanisms listed in the AUTH extension,
+// including the obsolete AUTH=<mechanism> form some servers still send.
+func authMechanisms(extensions []*SMTPExtension) []string {
+	var mechanisms []string
+	seen := make(map[string]bool)
+	add := func(m string) {
+		m = strings.ToUpper(m)
+		if m != "" && !seen[m] {
+			seen[m] = true
+			mechanisms = append(mechanisms, m)
+		}
+	}
+	for _, ext := range extensions {
+		switch {
+		case ext.Keyword == "AUTH":
+		case strings.HasPrefix(ext.Keyword, "AUTH="):
+			add(ext.Keyword[len("AUTH="):])
+		default:
+			continue
+		}
+		for _, p := range ext.Parameters {
+			add(p)
+		}
+	}
+	return mechanisms
+}
+
+// hasExtension reports whether keyword was advertised
+func hasExtension(extensions []*SMTPExtension, keyword string) bool {
+	for _, ext := range extensions {
+		if ext.Keyword == keyword {
+			return true
+		}
+	}
+	return false
+}
+
+// mechanismDiff returns the entries of b that are not in a
+func mechanismDiff(a, b []string) []string {
+	in := make(map[string]bool, len(a))
+	for _, m := range a {
+		in[m] = true
+	}
+	var diff []string
+	for _, m := range b {
+		if !in[m] {
+			diff = append(diff, m)
+		}
+	}
+	return diff
+}
+
+// A StartTLSRefusedError is returned when the server answers a STARTTLS
+// command with an explicit rejection, e.g. a POP3 -ERR
+type StartTLSRefusedError struct {
+	Protocol string
+	Response string
+}
+
+func (e *StartTLSRefusedError) Error() string {
+	return fmt.Sprintf("%s server refused STARTTLS: %s", e.Protocol, strings.TrimSpace(e.Response))
+}
+
+// IMAPCapabilities is the list a CAPABILITY command returned. Refused is
+// set when the server answered with a tagged NO or BAD.
+type IMAPCapabilities struct {
+	Capabilities   []string `json:"capabilities,omitempty"`
+	AuthMechanisms []string `json:"auth_mechanisms,omitempty"`
+	StartTLS       bool     `json:"starttls"`
+	LoginDisabled  bool     `json:"login_disabled"`
+	Refused        bool     `json:"refused,omitempty"`
+}
+
+// An IMAPCapabilityLog records the capabilities before and after TLS. With
+// implicit TLS only the TLS list is present.
+type IMAPCapabilityLog struct {
+	Plaintext *IMAPCapabilities `json:"plaintext,omitempty"`
+	TLS       *IMAPCapabilities `json:"tls,omitempty"`
+}
+
+// errIMAPGreeting is returned when the greeting is neither * OK nor, for a
+// session that starts authenticated, * PREAUTH
+var errIMAPGreeting = errors.New("Server did not send an IMAP * OK or * PREAUTH greeting")
+
+func imapGreetingAccepted(banner string) bool {
+	return strings.HasPrefix(banner, "* OK") || strings.HasPrefix(banner, "* PREAUTH")
+}
+
+// nextIMAPTag returns the tag for the next IMAP command: a001, a002, ...
+func (c *Conn) nextIMAPTag() string {
+	c.imapTag++
+	return fmt.Sprintf("a%03d", c.imapTag)
+}
+
+// parseIMAPCapabilities reads the untagged CAPABILITY lines of a response
+// ending in the status line tagged tag
+func parseIMAPCapabilities(response, tag string) *IMAPCapabilities {
+	caps := new(IMAPCapabilities)
+	lines := strings.Split(strings.TrimSuffix(response, "\r\n"), "\r\n")
+	status := strings.ToUpper(lines[len(lines)-1])
+	caps.Refused = !strings.HasPrefix(status, strings.ToUpper(tag)+" OK")
+	for _, line := range lines[:len(lines)-1] {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "*" || strings.ToUpper(fields[1]) != "CAPABILITY" {
+			continue
+		}
+		for _, capability := range fields[2:] {
+			caps.Capabilities = append(caps.Capabilities, capability)
+			upper := strings.ToUpper(capability)
+			switch {
+			case strings.HasPrefix(upper, "AUTH="):
+				caps.AuthMechanisms = append(caps.AuthMechanisms, upper[len("AUTH="):])
+			case upper == "STARTTLS":
+				caps.StartTLS = true
+			case upper == "LOGINDISABLED":
+				caps.LoginDisabled = true
+			}
+		}
+	}
+	return caps
+}
+
+// POP3Capabilities is the list a CAPA command returned, RFC 2449. Refused is
+// set on a -ERR, which servers predating CAPA send.
+type POP3Capabilities struct {
+	Capabilities   []string `json:"capabilities,omitempty"`
+	SASLMechanisms []string `json:"sasl_mechanisms,omitempty"`
+	STLS           bool     `json:"stls"`
+	User           bool     `json:"user"`
+	Implementation string   `json:"implementation,omitempty"`
+	Refused        bool     `json:"refused,omitempty"`
+}
+
+// A POP3CapabilityLog records the capabilities before and after TLS
+type POP3CapabilityLog struct {
+	Plaintext *POP3Capabilities `json:"plaintext,omitempty"`
+	TLS       *POP3Capabilities `json:"tls,omitempty"`
+}
+
+// parsePOP3Capabilities reads a +OK multi-line CAPA response up to the
+// terminating "." line
+func parsePOP3Capabilities(response string) *POP3Capabilities {
+	caps := new(POP3Capabilities)
+	lines := strings.Split(strings.TrimSuffix(response, "\r\n"), "\r\n")
+	if !strings.HasPrefix(lines[0], "+OK") {
+		caps.Refused = true
+		return caps
+	}
+	for _, line := range lines[1:] {
+		if line == "." {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		caps.Capabilities = append(caps.Capabilities, line)
+		switch strings.ToUpper(fields[0]) {
+		case "SASL":
+			caps.SASLMechanisms = append(caps.SASLMechanisms, fields[1:]...)
+		case "STLS":
+			caps.STLS = true
+		case "USER":
+			caps.User = true
+		case "IMPLEMENTATION":
+			caps.Implementation = strings.TrimSpace(line[len(fields[0]):])
+		}
+	}
+	return caps
+}
@@ -18,8 +18,13 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
 )
 
 type MEIResponse struct {
@@ -96,6 +101,15 @@ type ModbusEvent struct {
 	Response         []byte             `json:"raw_response,omitempty"`
 	MEIResponse      *MEIResponse       `json:"mei_response,omitempty"`
 	ExceptionReponse *ExceptionResponse `json:"exception_response,omitempty"`
+
+	// Set by ModbusIdentify, which fills the fields above from the last
+	// response
+	Support          string            `json:"support,omitempty"`
+	VendorName       string            `json:"vendor_name,omitempty"`
+	ProductCode      string            `json:"product_code,omitempty"`
+	Revision         string            `json:"revision,omitempty"`
+	HoldingRegisters []uint16          `json:"holding_registers,omitempty"`
+	Exchanges        []*ModbusExchange `json:"exchanges,omitempty"`
 }
 
 func (m *ModbusEvent) IsException() bool {
@@ -188,14 +202,20 @@ type ModbusRequest struct {
 }
 
 func (r *ModbusRequest) MarshalBinary() (data []byte, err error) {
-	data = make([]byte, 7+1+len(r.Data))
-	copy(data[0:4], ModbusHeaderBytes)
+	return r.marshalMBAP(binary.BigEndian.Uint16(ModbusHeaderBytes), 0), nil
+}
+
+// marshalMBAP encodes the request behind an MBAP header carrying
+// transactionID and unitID
+func (r *ModbusRequest) marshalMBAP(transactionID uint16, unitID byte) []byte {
+	data := make([]byte, 7+1+len(r.Data))
+	binary.BigEndian.PutUint16(data[0:2], transactionID)
 	msglen := len(r.Data) + 2 // unit ID and function
 	binary.BigEndian.PutUint16(data[4:6], uint16(msglen))
-	data[6] = 0
+	data[6] = unitID
 	data[7] = byte(r.Function)
 	copy(data[8:], r.Data)
-	return
+	return data
 }
 
 type ModbusResponse struct {
@@ -312,3 +332,191 @@ var ModbusFunctionEncapsulatedInterface = FunctionCode(0x2B)
 const (
 	FunctionCodeMEI = FunctionCode(0x2B)
 )
+
+const FunctionCodeReadHoldingRegisters = FunctionCode(0x03)
+
+// Exception codes, Modbus Application Protocol v1.1b3 section 7
+const (
+	ExceptionIllegalFunction        = 0x01
+	ExceptionIllegalDataAddress     = 0x02
+	ExceptionIllegalDataValue       = 0x03
+	ExceptionServerDeviceFailure    = 0x04
+	ExceptionAcknowledge            = 0x05
+	ExceptionServerDeviceBusy       = 0x06
+	ExceptionMemoryParityError      = 0x08
+	ExceptionGatewayPathUnavailable = 0x0A
+	ExceptionGatewayTargetFailed    = 0x0B
+)
+
+var modbusExceptionNames = map[byte]string{
+	ExceptionIllegalFunction:        "illegal_function",
+	ExceptionIllegalDataAddress:     "illegal_data_address",
+	ExceptionIllegalDataValue:       "illegal_data_value",
+	ExceptionServerDeviceFailure:    "server_device_failure",
+	ExceptionAcknowledge:            "acknowledge",
+	ExceptionServerDeviceBusy:       "server_device_busy",
+	ExceptionMemoryParityError:      "memory_parity_error",
+	ExceptionGatewayPathUnavailable: "gateway_path_unavailable",
+	ExceptionGatewayTargetFailed:    "gateway_target_failed_to_respond",
+}
+
+// ModbusExceptionName returns the name of an exception code
+func ModbusExceptionName(code byte) string {
+	if name, ok := modbusExceptionNames[code]; ok {
+		return name
+	}
+	return "exception_" + strconv.Itoa(int(code))
+}
+
+// How far ModbusIdentify got with a device
+const (
+	// Read Device Identification returned objects
+	ModbusSupportIdentification = "device_identification"
+	// Holding register 0 could be read
+	ModbusSupportRegisters = "holding_registers"
+	// The device answered in Modbus, but only with exceptions
+	ModbusSupportExceptions = "exceptions_only"
+)
+
+// A ModbusExchange is one request sent by ModbusIdentify and the outcome
+type ModbusExchange struct {
+	TransactionID uint16       `json:"transaction_id"`
+	UnitID        int          `json:"unit_id"`
+	Function      FunctionCode `json:"function_code"`
+	Exception     string       `json:"exception,omitempty"`
+	Error         string       `json:"error,omitempty"`
+	ErrorClass    string       `json:"error_class,omitempty"`
+}
+
+// Largest PDU, Modbus Application Protocol v1.1b3 section 4.1
+const modbusMaxPDU = 253
+
+// modbusTransaction sends req to unitID and reads the response with the
+// same transaction ID, recording the exchange in event
+func (c *Conn) modbusTransaction(event *ModbusEvent, transactionID uint16, unitID byte, req *ModbusRequest) (*ModbusExchange, *ModbusResponse, error) {
+	ex := &ModbusExchange{TransactionID: transactionID, UnitID: int(unitID), Function: req.Function}
+	event.Exchanges = append(event.Exchanges, ex)
+	start := time.Now()
+	res, raw, err := c.modbusRoundTrip(transactionID, req.marshalMBAP(transactionID, unitID))
+	c.recordResponse(OperationModbus, start, raw)
+	if err != nil {
+		ex.Error = err.Error()
+		ex.ErrorClass = zerrors.Classify(err)
+		return ex, nil, err
+	}
+	ex.Function = res.Function
+	if res.Function.IsException() && len(res.Data) > 0 {
+		ex.Exception = ModbusExceptionName(res.Data[0])
+	}
+	return ex, res, nil
+}
+
+func (c *Conn) modbusRoundTrip(transactionID uint16, request []byte) (*ModbusResponse, []byte, error) {
+	if _, err := c.getUnderlyingConn().Write(request); err != nil {
+		return nil, nil, err
+	}
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(c.getUnderlyingConn(), header); err != nil {
+		return nil, header, err
+	}
+	if binary.BigEndian.Uint16(header[2:4]) != 0 {
+		return nil, header, errors.New("modbus: not a modbus response")
+	}
+	if binary.BigEndian.Uint16(header[0:2]) != transactionID {
+		return nil, header, fmt.Errorf("modbus: response to transaction %d, expected %d", binary.BigEndian.Uint16(header[0:2]), transactionID)
+	}
+	// The length counts the unit ID, which is part of the header
+	msglen := int(binary.BigEndian.Uint16(header[4:6]))
+	if msglen < 2 || msglen-1 > modbusMaxPDU {
+		return nil, header, fmt.Errorf("modbus: invalid length %d", msglen)
+	}
+	pdu := make([]byte, msglen-1)
+	n, err := io.ReadFull(c.getUnderlyingConn(), pdu)
+	raw := append(header, pdu[:n]...)
+	if err != nil {
+		return nil, raw, err
+	}
+	return &ModbusResponse{
+		Length:   msglen,
+		UnitID:   int(header[6]),
+		Function: FunctionCode(pdu[0]),
+		Data:     pdu[1:],
+	}, raw, nil
+}
+
+// ModbusIdentify asks for the basic device identification objects, first of
+// unit 0 and, if a gateway cannot route that, of unit 255, the gateway
+// itself. A device without identification support is asked for holding
+// register 0 instead, so at most three requests are sent.
+func (c *Conn) ModbusIdentify() error {
+	event := new(ModbusEvent)
+	c.grabData.Modbus = event
+	identify := &ModbusRequest{
+		Function: FunctionCodeMEI,
+		Data:     []byte{0x0E, 0x01, 0x00},
+	}
+	var transactionID uint16 = 0x1337
+	var unitID byte
+	for _, unitID = range []byte{0, 255} {
+		ex, res, err := c.modbusTransaction(event, transactionID, unitID, identify)
+		transactionID++
+		if err != nil {
+			return err
+		}
+		event.setResponse(res)
+		if ex.Exception != ModbusExceptionName(ExceptionGatewayPathUnavailable) &&
+			ex.Exception != ModbusExceptionName(ExceptionGatewayTargetFailed) {
+			break
+		}
+	}
+	if event.MEIResponse != nil && len(event.MEIResponse.Objects) > 0 {
+		event.Support = ModbusSupportIdentification
+		for _, obj := range event.MEIResponse.Objects {
+			switch obj.OID {
+			case OIDVendor:
+				event.VendorName = obj.Value
+			case OIDProductCode:
+				event.ProductCode = obj.Value
+			case OIDRevision:
+				event.Revision = obj.Value
+			}
+		}
+		return nil
+	}
+
+	// The device has answered in Modbus by now, so a failure to read the
+	// register still leaves a result
+	event.Support = ModbusSupportExceptions
+	registers := &ModbusRequest{
+		Function: FunctionCodeReadHoldingRegisters,
+		Data:     []byte{0x00, 0x00, 0x00, 0x01},
+	}
+	_, res, err := c.modbusTransaction(event, transactionID, unitID, registers)
+	if err != nil {
+		return nil
+	}
+	event.setResponse(res)
+	if !res.Function.IsException() && len(res.Data) > 0 {
+		count := int(res.Data[0])
+		data := res.Data[1:]
+		if count > len(data) {
+			count = len(data)
+		}
+		for i := 0; i+1 < count; i += 2 {
+			event.HoldingRegisters = append(event.HoldingRegisters, binary.BigEndian.Uint16(data[i:]))
+		}
+		event.Support = ModbusSupportRegisters
+	}
+	return nil
+}
+
+// setResponse fills the fields describing a single response from res
+func (m *ModbusEvent) setResponse(res *ModbusResponse) {
+	m.Length = res.Length
+	m.UnitID = res.UnitID
+	m.Function = res.Function
+	m.Response = res.Data
+	m.MEIResponse = nil
+	m.ExceptionReponse = nil
+	m.ParseSelf()
+}
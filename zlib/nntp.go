@@ -0,0 +1,137 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An NNTPLog records the greeting and the replies to CAPABILITIES and LIST
+// OVERVIEW.FMT, raw and split into lines. A server may refuse either
+// command, which leaves the list empty rather than failing the grab.
+type NNTPLog struct {
+	Greeting       string   `json:"greeting"`
+	PostingAllowed bool     `json:"posting_allowed"`
+	Capabilities   string   `json:"capabilities,omitempty"`
+	CapabilityList []string `json:"capability_list,omitempty"`
+	Implementation string   `json:"implementation,omitempty"`
+	OverviewFormat string   `json:"overview_format,omitempty"`
+	OverviewFields []string `json:"overview_fields,omitempty"`
+}
+
+// Status codes of the multi-line replies to the commands sent, RFC 3977
+// sections 5.2 and 8.4
+const (
+	nntpCapabilityList = 101
+	nntpListFollows    = 215
+)
+
+// nntpCode returns the status code starting line, or 0
+func nntpCode(line string) int {
+	if len(line) < 3 {
+		return 0
+	}
+	code, _ := strconv.Atoi(line[:3])
+	return code
+}
+
+// nntpResponseComplete reports whether b holds a whole reply: a single
+// status line, or for the multi-line codes the data up to the "." line
+func nntpResponseComplete(b []byte) bool {
+	end := bytes.Index(b, []byte("\r\n"))
+	if end < 0 {
+		return false
+	}
+	if code := nntpCode(string(b[:end])); code != nntpCapabilityList && code != nntpListFollows {
+		return true
+	}
+	return bytes.HasSuffix(b, []byte("\r\n.\r\n"))
+}
+
+// nntpLines returns the status code and the dot-unstuffed data lines of a
+// multi-line reply
+func nntpLines(res []byte) (int, []string) {
+	lines := strings.Split(strings.TrimSuffix(string(res), "\r\n"), "\r\n")
+	code := nntpCode(lines[0])
+	var data []string
+	for _, line := range lines[1:] {
+		if line == "." {
+			break
+		}
+		data = append(data, strings.TrimPrefix(line, "."))
+	}
+	return code, data
+}
+
+// nntpCommand sends a command and reads its whole reply, recorded as opType
+func (c *Conn) nntpCommand(command, opType string) ([]byte, error) {
+	start := time.Now()
+	if _, err := c.getUnderlyingConn().Write([]byte(command + "\r\n")); err != nil {
+		c.recordOperation(opType, start)
+		return nil, err
+	}
+	res, err := c.readKVResponse(make([]byte, 1024), nntpResponseComplete)
+	c.traceResponse("NNTP", res, err)
+	c.recordResponse(opType, start, res)
+	return res, err
+}
+
+// NNTPBanner reads the greeting, 200 when posting is allowed and 201 when
+// not, then asks for the capabilities and the overview format
+func (c *Conn) NNTPBanner() error {
+	log := new(NNTPLog)
+	c.grabData.NNTP = log
+	start := time.Now()
+	res, err := c.readKVResponse(make([]byte, 1024), nntpResponseComplete)
+	c.traceResponse("NNTP", res, err)
+	c.recordResponse(OperationBanner, start, res)
+	log.Greeting = string(res)
+	if err != nil {
+		return err
+	}
+	switch {
+	case strings.HasPrefix(log.Greeting, "200"):
+		log.PostingAllowed = true
+	case strings.HasPrefix(log.Greeting, "201"):
+	default:
+		return errors.New("Server did not send an NNTP 200 or 201 greeting")
+	}
+
+	if res, err = c.nntpCommand("CAPABILITIES", OperationNNTPCapabilities); err != nil {
+		return err
+	}
+	if code, lines := nntpLines(res); code == nntpCapabilityList {
+		log.Capabilities = string(res)
+		log.CapabilityList = lines
+		for _, line := range lines {
+			if strings.HasPrefix(strings.ToUpper(line), "IMPLEMENTATION ") {
+				log.Implementation = strings.TrimSpace(line[len("IMPLEMENTATION "):])
+			}
+		}
+	}
+
+	if res, err = c.nntpCommand("LIST OVERVIEW.FMT", OperationNNTPOverviewFmt); err != nil {
+		return err
+	}
+	if code, lines := nntpLines(res); code == nntpListFollows {
+		log.OverviewFormat = string(res)
+		log.OverviewFields = lines
+	}
+	return nil
+}
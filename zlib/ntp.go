@@ -0,0 +1,85 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/ntp"
+)
+
+// An NTPLog records the reply to a client query and, with --ntp-readvar,
+// the system variables read with a mode 6 control query. A server that
+// answers the client query but not the control query gives a partial
+// result rather than an error.
+type NTPLog struct {
+	Response     *ntp.Response        `json:"response,omitempty"`
+	ReadVar      *ntp.ControlResponse `json:"readvar,omitempty"`
+	ReadVarError string               `json:"readvar_error,omitempty"`
+	Partial      bool                 `json:"partial,omitempty"`
+}
+
+// NTPQuery sends a client query on a UDP connection and records the
+// server's reply, ignoring datagrams that do not answer it. A rate limiting
+// Kiss-o'-Death reply is recorded and ends the grab. With readVar the
+// system variables are then requested.
+func (c *Conn) NTPQuery(readVar bool) error {
+	log := new(NTPLog)
+	c.grabData.NTP = log
+	request := ntp.ClientRequest(time.Now())
+	if _, err := c.Write(request); err != nil {
+		return err
+	}
+	err := c.readDatagrams(func(b []byte) (bool, error) {
+		res, err := ntp.ParseResponse(request, b)
+		if err != nil {
+			return false, nil
+		}
+		log.Response = res
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	if !readVar || log.Response.RateLimited {
+		return nil
+	}
+	if err := c.ntpReadVar(log); err != nil {
+		log.ReadVarError = err.Error()
+		log.Partial = true
+	}
+	return nil
+}
+
+func (c *Conn) ntpReadVar(log *NTPLog) error {
+	var seq [2]byte
+	rand.Read(seq[:])
+	r := &ntp.ControlReader{Sequence: binary.BigEndian.Uint16(seq[:])}
+	if _, err := c.Write(ntp.ReadVarRequest(r.Sequence)); err != nil {
+		return err
+	}
+	if err := c.readDatagrams(r.Add); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return errors.New("server did not answer the mode 6 control query")
+		}
+		return err
+	}
+	log.ReadVar = r.Response()
+	return nil
+}
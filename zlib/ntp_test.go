@@ -0,0 +1,116 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/ntp"
+)
+
+// ntpServer answers client queries from refid at the given stratum and,
+// with vars set, READVAR queries with the variables split in two fragments
+// sent last first
+func ntpServer(t *testing.T, stratum byte, refid, vars string) (string, func()) {
+	return udpServer(t, func(q []byte) [][]byte {
+		switch q[0] & 0x7 {
+		case ntp.ModeClient:
+			r := make([]byte, 48)
+			r[0] = 4<<3 | ntp.ModeServer
+			r[1] = stratum
+			copy(r[12:], refid)
+			copy(r[24:32], q[40:48])
+			copy(r[40:48], q[40:48])
+			return [][]byte{r}
+		case ntp.ModeControl:
+			if vars == "" {
+				return nil
+			}
+			half := len(vars) / 2
+			fragment := func(offset int, data string, more bool) []byte {
+				r := make([]byte, 12, 12+len(data)+3)
+				copy(r, q[:4])
+				r[1] |= 0x80
+				if more {
+					r[1] |= 0x20
+				}
+				binary.BigEndian.PutUint16(r[4:], 0x0615)
+				binary.BigEndian.PutUint16(r[8:], uint16(offset))
+				binary.BigEndian.PutUint16(r[10:], uint16(len(data)))
+				r = append(r, data...)
+				for len(r)%4 != 0 {
+					r = append(r, 0)
+				}
+				return r
+			}
+			return [][]byte{fragment(half, vars[half:], false), fragment(0, vars[:half], true)}
+		}
+		return nil
+	})
+}
+
+func TestNTPQueryReadVar(t *testing.T) {
+	vars := `version="ntpd 4.2.8p15", processor="x86_64", stratum=2`
+	addr, stop := ntpServer(t, 2, "\xc0\x00\x02\x01", vars)
+	defer stop()
+
+	c := dialUDP(t, addr, time.Second)
+	defer c.Close()
+	if err := c.NTPQuery(true); err != nil {
+		t.Fatalf("NTPQuery: %s", err.Error())
+	}
+	log := c.grabData.NTP
+	if log.Response == nil || log.Response.Stratum != 2 || log.Response.ReferenceID != "192.0.2.1" {
+		t.Fatalf("Wrong client response: %+v", log.Response)
+	}
+	if log.Partial || log.ReadVar == nil || log.ReadVar.Variables != vars {
+		t.Fatalf("Wrong readvar: %+v (%q)", log.ReadVar, log.ReadVarError)
+	}
+	if log.ReadVar.LeapIndicator != 0 || log.ReadVar.ClockSource != 6 || log.ReadVar.EventCount != 1 || log.ReadVar.EventCode != 5 {
+		t.Errorf("Wrong system status: %+v", log.ReadVar)
+	}
+}
+
+func TestNTPQueryPartial(t *testing.T) {
+	addr, stop := ntpServer(t, 1, "GPS", "")
+	defer stop()
+
+	c := dialUDP(t, addr, 200*time.Millisecond)
+	defer c.Close()
+	if err := c.NTPQuery(true); err != nil {
+		t.Fatalf("NTPQuery: %s", err.Error())
+	}
+	log := c.grabData.NTP
+	if log.Response == nil || log.ReadVar != nil || !log.Partial || log.ReadVarError == "" {
+		t.Errorf("Expected a partial result: %+v", log)
+	}
+}
+
+func TestNTPQueryRateLimited(t *testing.T) {
+	addr, stop := ntpServer(t, 0, ntp.KissRate, "unused=1")
+	defer stop()
+
+	c := dialUDP(t, addr, time.Second)
+	defer c.Close()
+	if err := c.NTPQuery(true); err != nil {
+		t.Fatalf("NTPQuery: %s", err.Error())
+	}
+	log := c.grabData.NTP
+	if !log.Response.RateLimited || log.Response.KissCode != ntp.KissRate || log.ReadVar != nil {
+		t.Errorf("Kiss-o'-Death not recorded: %+v", log)
+	}
+}
@@ -0,0 +1,309 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Operation types as they appear in the output. Downstream parsers match on
+// these values, so existing ones must not be renamed.
+const (
+	OperationWrite            = "write"
+	OperationRead             = "read"
+	OperationBanner           = "banner"
+	OperationTLSHandshake     = "tls_handshake"
+	OperationStartTLS         = "starttls"
+	OperationEHLO             = "ehlo"
+	OperationHELO             = "helo"
+	OperationSMTPHelp         = "smtp_help"
+	OperationSMTPVrfy         = "smtp_vrfy"
+	OperationSMTPExpn         = "smtp_expn"
+	OperationHeartbleed       = "heartbleed"
+	OperationHeartbeat        = "heartbeat"
+	OperationFTPAuth          = "ftp_auth"
+	OperationQuit             = "quit"
+	OperationCanceled         = "canceled"
+	OperationResumption       = "tls_resumption"
+	OperationRenegotiation    = "tls_renegotiation"
+	OperationCurveEnumeration = "tls_curves"
+	OperationSSLv2            = "sslv2"
+	OperationBannerProbe      = "banner_probe"
+	OperationXSSHHandshake    = "xssh_handshake"
+	OperationXSSHHostKeys     = "xssh_host_keys"
+	OperationXSSHUserAuth     = "xssh_userauth"
+	OperationConnect          = "connect"
+	OperationProxy            = "proxy"
+	OperationBudgetExceeded   = "budget_exceeded"
+	OperationConnectionClosed = "connection_closed"
+	OperationDTLSHandshake    = "dtls_handshake"
+	OperationMemcachedStats   = "memcached_stats"
+	OperationRedisInfo        = "redis_info"
+	OperationMongoDB          = "mongodb"
+	OperationModbus           = "modbus"
+	OperationSMBNegotiate     = "smb_negotiate"
+	OperationIMAPCapability   = "imap_capability"
+	OperationPOP3Capa         = "pop3_capa"
+	OperationNNTPCapabilities = "nntp_capabilities"
+	OperationNNTPOverviewFmt  = "nntp_overview_fmt"
+	OperationIRCRegister      = "irc_register"
+	OperationPostgresStartup  = "postgres_startup"
+	OperationCassandraOptions = "cassandra_options"
+	OperationAMQPStart        = "amqp_start"
+	OperationMQTTConnect      = "mqtt_connect"
+	OperationLDAPRootDSE      = "ldap_rootdse"
+	OperationKerberosASReq    = "kerberos_as_req"
+	OperationCloseWrite       = "close_write"
+	OperationDrain            = "drain"
+)
+
+// Operations recorded by probes outside this package carry a type starting
+// with this prefix, e.g. "x-acme_hello", so they never collide with the
+// built-in types above
+const ExternalOperationPrefix = "x-"
+
+// ExternalOperationType returns the type for an external operation name
+func ExternalOperationType(name string) string {
+	return ExternalOperationPrefix + name
+}
+
+// Encodings for the response bytes recorded on an operation
+const (
+	ResponseEncodingBase64 = "base64"
+	ResponseEncodingUTF8   = "utf8"
+)
+
+// An Operation records when a single step of the conversation with the
+// remote host started and finished, and optionally the bytes received.
+type Operation struct {
+	Type     string
+	Start    time.Time
+	End      time.Time
+	Response []byte
+	Encoding string
+
+	// Set on the operation during which the connection's read limit was hit
+	Truncated bool
+
+	// Set on operations carried over an established TLS session, e.g. the
+	// EHLO after STARTTLS or the banner read on an implicit TLS port
+	InTLS bool
+
+	// Position of the operation in the connection's log, counting from 0
+	Seq int
+
+	// Set on operations that sent a payload when write payloads are not
+	// recorded, see SetRecordWritePayloads. The digest is in hex.
+	PayloadLength int
+	PayloadSHA256 string
+}
+
+type encodedOperation struct {
+	Type      string  `json:"type"`
+	Start     string  `json:"start"`
+	End       string  `json:"end"`
+	Response  *string `json:"response,omitempty"`
+	Encoding  string  `json:"encoding,omitempty"`
+	Truncated bool    `json:"truncated,omitempty"`
+	InTLS     bool    `json:"in_tls"`
+	Seq       int     `json:"seq"`
+	Length    int     `json:"length,omitempty"`
+	SHA256    string  `json:"sha256,omitempty"`
+}
+
+// MarshalJSON encodes the timestamps in UTC so the output does not depend on
+// the scanning host's time zone, and the response in its recorded encoding
+func (op *Operation) MarshalJSON() ([]byte, error) {
+	enc := &encodedOperation{
+		Type:      op.Type,
+		Start:     op.Start.UTC().Format(time.RFC3339Nano),
+		End:       op.End.UTC().Format(time.RFC3339Nano),
+		Truncated: op.Truncated,
+		InTLS:     op.InTLS,
+		Seq:       op.Seq,
+		Length:    op.PayloadLength,
+		SHA256:    op.PayloadSHA256,
+	}
+	if op.Encoding != "" {
+		var response string
+		if op.Encoding == ResponseEncodingUTF8 {
+			response = string(op.Response)
+		} else {
+			response = base64.StdEncoding.EncodeToString(op.Response)
+		}
+		enc.Response = &response
+		enc.Encoding = op.Encoding
+	}
+	return json.Marshal(enc)
+}
+
+// isPrintableText reports whether b is valid UTF-8 without control
+// characters other than tab, CR and LF
+func isPrintableText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if r != '\t' && r != '\r' && r != '\n' && !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Duration returns the wall-clock time spent in the operation
+func (op *Operation) Duration() time.Duration {
+	return op.End.Sub(op.Start)
+}
+
+// recordOperation appends an operation of the given type that started at
+// start and finished now. It is intended to be deferred, e.g.
+//
+//	defer c.recordOperation(OperationRead, time.Now())
+func (c *Conn) recordOperation(opType string, start time.Time) {
+	c.finishOperation(c.newOperation(opType, start))
+}
+
+// recordResponse records an operation like recordOperation, attaching a copy
+// of the bytes received when a response encoding is set
+func (c *Conn) recordResponse(opType string, start time.Time, response []byte) {
+	op := c.newOperation(opType, start)
+	c.encodeResponse(op, response)
+	c.finishOperation(op)
+}
+
+// encodeResponse attaches a copy of response to op in the connection's
+// response encoding, or clears it when none is set
+func (c *Conn) encodeResponse(op *Operation, response []byte) {
+	op.Response, op.Encoding = nil, ""
+	if c.responseEncoding == "" {
+		return
+	}
+	op.Response = append([]byte{}, response...)
+	op.Encoding = ResponseEncodingBase64
+	if c.responseEncoding == ResponseEncodingUTF8 && isPrintableText(response) {
+		op.Encoding = ResponseEncodingUTF8
+	}
+}
+
+// sentPayload returns payload as a string for the grab or, when write
+// payloads are not recorded, attaches its length and SHA-256 to op and
+// returns ""
+func (c *Conn) sentPayload(op *Operation, payload []byte) string {
+	if !c.hashWritePayloads {
+		return string(payload)
+	}
+	sum := sha256.Sum256(payload)
+	op.PayloadLength, op.PayloadSHA256 = len(payload), hex.EncodeToString(sum[:])
+	return ""
+}
+
+// A ConnectionOperation is a step of a probe implemented outside this
+// package. *Operation implements it, so a probe can pass its own Operation
+// or a type carrying one.
+type ConnectionOperation interface {
+	StateLog() *Operation
+}
+
+// StateLog returns op itself
+func (op *Operation) StateLog() *Operation {
+	return op
+}
+
+// RecordOperation appends the operation of an external probe to the grab,
+// numbered and marked in_tls like the built-in ones. Its type must start
+// with ExternalOperationPrefix. A zero End is set to now and a zero Start to
+// End. Response bytes are recorded in the connection's response encoding,
+// overriding any Encoding set on the operation.
+func (c *Conn) RecordOperation(op ConnectionOperation) error {
+	o := op.StateLog()
+	if o == nil {
+		return fmt.Errorf("Operation to record is nil")
+	}
+	if !strings.HasPrefix(o.Type, ExternalOperationPrefix) || len(o.Type) == len(ExternalOperationPrefix) {
+		return fmt.Errorf("External operation type %q does not start with %q", o.Type, ExternalOperationPrefix)
+	}
+	if o.End.IsZero() {
+		o.End = time.Now()
+	}
+	if o.Start.IsZero() {
+		o.Start = o.End
+	}
+	if o.Response != nil {
+		c.encodeResponse(o, o.Response)
+	}
+	c.finishOperation(o)
+	return nil
+}
+
+func (c *Conn) newOperation(opType string, start time.Time) *Operation {
+	return &Operation{
+		Type:      opType,
+		Start:     start,
+		End:       time.Now(),
+		Truncated: c.readLimitExceeded(),
+	}
+}
+
+// finishOperation appends op followed by any cancellation or budget state
+// it triggered
+func (c *Conn) finishOperation(op *Operation) {
+	c.appendOperation(op)
+	c.recordCancellation()
+	c.recordBudgetExceeded(op.Type)
+}
+
+// appendOperation numbers op and marks whether the connection was in TLS
+// before adding it to the log. The TLS handshake itself runs before the
+// session is established and is never marked.
+func (c *Conn) appendOperation(op *Operation) {
+	c.opsMu.Lock()
+	defer c.opsMu.Unlock()
+	if c.nextSeq == 0 {
+		c.firstOp = op.Start
+	}
+	op.Seq = c.nextSeq
+	c.nextSeq++
+	op.InTLS = c.isTls && op.Type != OperationTLSHandshake
+	c.grabData.Operations = append(c.grabData.Operations, op)
+}
+
+// Operations returns a snapshot of the operations recorded so far. It is
+// safe to call while the grab is still running on another goroutine.
+func (c *Conn) Operations() []*Operation {
+	c.opsMu.Lock()
+	defer c.opsMu.Unlock()
+	return append([]*Operation(nil), c.grabData.Operations...)
+}
+
+// DrainOperations returns the operations recorded so far, in order, and
+// clears them from the grab, e.g. to emit partial results for a slow host.
+// Sequence numbers keep increasing across drains, so the operations recorded
+// afterwards continue where the drained ones stopped.
+func (c *Conn) DrainOperations() []*Operation {
+	c.opsMu.Lock()
+	defer c.opsMu.Unlock()
+	ops := c.grabData.Operations
+	c.grabData.Operations = nil
+	return ops
+}
@@ -0,0 +1,325 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite golden files in testdata")
+
+var allOperationTypes = []string{
+	OperationWrite,
+	OperationRead,
+	OperationBanner,
+	OperationTLSHandshake,
+	OperationStartTLS,
+	OperationEHLO,
+	OperationHELO,
+	OperationSMTPHelp,
+	OperationSMTPVrfy,
+	OperationSMTPExpn,
+	OperationHeartbleed,
+	OperationHeartbeat,
+	OperationFTPAuth,
+	OperationQuit,
+	OperationCanceled,
+	OperationResumption,
+	OperationRenegotiation,
+	OperationCurveEnumeration,
+	OperationSSLv2,
+	OperationBannerProbe,
+	OperationXSSHHandshake,
+	OperationXSSHHostKeys,
+	OperationXSSHUserAuth,
+	OperationConnect,
+	OperationProxy,
+	OperationBudgetExceeded,
+	OperationConnectionClosed,
+	OperationDTLSHandshake,
+	OperationMemcachedStats,
+	OperationRedisInfo,
+	OperationMongoDB,
+	OperationModbus,
+	OperationSMBNegotiate,
+	OperationIMAPCapability,
+	OperationPOP3Capa,
+	OperationNNTPCapabilities,
+	OperationNNTPOverviewFmt,
+	OperationIRCRegister,
+	OperationPostgresStartup,
+	OperationCassandraOptions,
+	OperationAMQPStart,
+	OperationMQTTConnect,
+	OperationLDAPRootDSE,
+	OperationKerberosASReq,
+	OperationCloseWrite,
+	OperationDrain,
+}
+
+func TestOperationsGolden(t *testing.T) {
+	start := time.Date(2015, 6, 1, 12, 0, 0, 0, time.FixedZone("EDT", -4*3600))
+	grab := &Grab{
+		IP:   net.ParseIP("192.0.2.1"),
+		Time: start.UTC(),
+		Data: GrabData{Banner: "220 mail.example.com ESMTP\r\n"},
+	}
+	inTLS := false
+	for i, opType := range allOperationTypes {
+		opStart := start.Add(time.Duration(i) * time.Millisecond)
+		grab.Data.Operations = append(grab.Data.Operations, &Operation{
+			Type:  opType,
+			Start: opStart,
+			End:   opStart.Add(500 * time.Microsecond),
+			InTLS: inTLS,
+			Seq:   i,
+		})
+		inTLS = inTLS || opType == OperationTLSHandshake
+	}
+
+	got, err := json.MarshalIndent(grab, "", "  ")
+	if err != nil {
+		t.Fatalf("Marshal: %s", err.Error())
+	}
+	got = append(got, '\n')
+
+	golden := filepath.Join("testdata", "operations.golden.json")
+	if *updateGolden {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatalf("Writing %s: %s", golden, err.Error())
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Reading %s: %s", golden, err.Error())
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Output does not match %s:\n%s", golden, got)
+	}
+}
+
+func TestOperationResponseRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		response []byte
+		encoding string
+	}{
+		{"crlf", []byte("220 ready\r\n250-SIZE\r\n250 OK\r\n"), ResponseEncodingUTF8},
+		{"unicode", []byte("220 m\xc3\xa4il ready\r\n"), ResponseEncodingUTF8},
+		{"control", []byte("220 ready\x01\x1b[0m\r\n"), ResponseEncodingBase64},
+		{"invalid utf8", []byte("220 \xff\xfe ready\r\n"), ResponseEncodingBase64},
+	}
+	for _, test := range tests {
+		c, server := pipeConn()
+		c.SetResponseEncoding(ResponseEncodingUTF8)
+		go server.Write(test.response)
+		b := make([]byte, 256)
+		if _, err := c.Read(b); err != nil {
+			t.Fatalf("%s: Read: %s", test.name, err.Error())
+		}
+		c.Close()
+		server.Close()
+
+		out, err := json.Marshal(c.grabData.Operations[0])
+		if err != nil {
+			t.Fatalf("%s: Marshal: %s", test.name, err.Error())
+		}
+		var decoded encodedOperation
+		if err := json.Unmarshal(out, &decoded); err != nil {
+			t.Fatalf("%s: Unmarshal: %s", test.name, err.Error())
+		}
+		if decoded.Encoding != test.encoding || decoded.Response == nil {
+			t.Errorf("%s: Wrong encoding - expected: %s, got: %s", test.name, test.encoding, decoded.Encoding)
+			continue
+		}
+		got := []byte(*decoded.Response)
+		if decoded.Encoding == ResponseEncodingBase64 {
+			if got, err = base64.StdEncoding.DecodeString(*decoded.Response); err != nil {
+				t.Fatalf("%s: Decoding base64: %s", test.name, err.Error())
+			}
+		}
+		if !bytes.Equal(got, test.response) {
+			t.Errorf("%s: Response did not round trip - expected: %q, got: %q", test.name, test.response, got)
+		}
+	}
+}
+
+func TestOperationFieldNames(t *testing.T) {
+	op := &Operation{
+		Type:      OperationEHLO,
+		Response:  []byte("250 OK\r\n"),
+		Encoding:  ResponseEncodingUTF8,
+		Truncated: true,
+		InTLS:     true,
+		Seq:       3,
+	}
+	out, err := json.Marshal(op)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err.Error())
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(out, &fields); err != nil {
+		t.Fatalf("Unmarshal: %s", err.Error())
+	}
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, " "); got != "encoding end in_tls response seq start truncated type" {
+		t.Errorf("Wrong field names: %s", got)
+	}
+	if fields["in_tls"] != true || fields["seq"] != float64(3) {
+		t.Errorf("Wrong annotations: %s", out)
+	}
+}
+
+func TestOperationsNumberedAtAppend(t *testing.T) {
+	c, server := pipeConn()
+	defer server.Close()
+	go serveOnce(server, "220 ready\r\n")
+
+	if _, err := c.Write([]byte("HELO\r\n")); err != nil {
+		t.Fatalf("Write: %s", err.Error())
+	}
+	if _, err := c.Read(make([]byte, 64)); err != nil {
+		t.Fatalf("Read: %s", err.Error())
+	}
+	// Pretend a handshake happened without routing I/O through ztls
+	c.isTls = true
+	c.recordOperation(OperationEHLO, time.Now())
+	c.recordClose()
+	c.isTls = false
+	c.Close()
+
+	for i, op := range c.grabData.Operations {
+		if op.Seq != i || op.InTLS != (i >= 2) {
+			t.Errorf("Wrong annotations on %s: seq %d, in_tls %v", op.Type, op.Seq, op.InTLS)
+		}
+	}
+	if n := len(c.grabData.Operations); n != 4 || c.grabData.Operations[n-1].Type != OperationConnectionClosed {
+		t.Errorf("Wrong operations: %d", n)
+	}
+}
+
+func TestDrainOperationsKeepsNumbering(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	c.recordOperation(OperationWrite, time.Now())
+	c.recordOperation(OperationRead, time.Now())
+	drained := c.DrainOperations()
+	if len(drained) != 2 || drained[0].Type != OperationWrite || drained[1].Type != OperationRead {
+		t.Fatalf("Wrong drained operations: %+v", drained)
+	}
+	if ops := c.Operations(); len(ops) != 0 {
+		t.Fatalf("Operations left after drain: %+v", ops)
+	}
+
+	c.recordOperation(OperationEHLO, time.Now())
+	ops := c.Operations()
+	if len(ops) != 1 || ops[0].Seq != 2 {
+		t.Errorf("Numbering restarted after drain: %+v", ops)
+	}
+	if c.Summary().DurationMs < float64(time.Since(drained[0].Start))/float64(time.Millisecond)-1 {
+		t.Errorf("Summary duration no longer measured from the first operation")
+	}
+}
+
+func TestOperationsWhileRecording(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			c.recordOperation(OperationRead, time.Now())
+		}
+	}()
+	var seen []*Operation
+	for polling := true; polling; {
+		select {
+		case <-done:
+			polling = false
+		default:
+		}
+		for _, op := range c.Operations() {
+			_ = op.Type
+		}
+		seen = append(seen, c.DrainOperations()...)
+	}
+	seen = append(seen, c.DrainOperations()...)
+	if len(seen) != 1000 {
+		t.Fatalf("Drained %d operations, expected 1000", len(seen))
+	}
+	for i, op := range seen {
+		if op.Seq != i {
+			t.Fatalf("Operation %d drained out of order with seq %d", i, op.Seq)
+		}
+	}
+}
+
+// helloState is an external probe's state embedding the Operation it records
+type helloState struct {
+	Operation
+	Greeting string
+}
+
+func TestRecordExternalOperation(t *testing.T) {
+	c, server := pipeConn()
+	c.SetResponseEncoding(ResponseEncodingUTF8)
+	defer c.Close()
+	defer server.Close()
+
+	c.recordOperation(OperationWrite, time.Now())
+	for _, opType := range []string{OperationRead, "acme_hello", ExternalOperationPrefix} {
+		if err := c.RecordOperation(&Operation{Type: opType}); err == nil {
+			t.Errorf("Operation type %q accepted", opType)
+		}
+	}
+	state := &helloState{Operation: Operation{Type: ExternalOperationType("acme_hello"), Response: []byte("HELLO 1\r\n")}}
+	if err := c.RecordOperation(state); err != nil {
+		t.Fatalf("RecordOperation: %s", err.Error())
+	}
+
+	ops := c.Operations()
+	if len(ops) != 2 || ops[1] != &state.Operation || ops[1].Seq != 1 || ops[1].Start.IsZero() {
+		t.Fatalf("Wrong operations: %+v", ops)
+	}
+	out, err := json.Marshal(ops[1])
+	if err != nil {
+		t.Fatalf("Marshal: %s", err.Error())
+	}
+	var decoded encodedOperation
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatalf("Unmarshal: %s", err.Error())
+	}
+	if decoded.Type != "x-acme_hello" || decoded.Response == nil || *decoded.Response != "HELLO 1\r\n" || decoded.Encoding != ResponseEncodingUTF8 {
+		t.Errorf("Wrong encoding: %s", out)
+	}
+}
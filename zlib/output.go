@@ -18,11 +18,64 @@ import (
 	"encoding/json"
 	"log"
 	"os"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/processing"
 )
 
 type OutputConfig struct {
 	OutputFile *os.File
 	ErrorLog   *log.Logger
+
+	// Failed grabs are written here instead of OutputFile when set
+	ErrorFile *os.File
+
+	// Every grab is also written here as a CSV row of CSVFields when set
+	CSVFile   *os.File
+	CSVFields []string
+
+	// Buffering and compression of all the files
+	Options processing.OutputOptions
+}
+
+// DefaultCSVFields are the columns written to the CSV file unless others
+// are configured
+var DefaultCSVFields = []string{
+	"ip",
+	"data.summary.remote_addr|port",
+	"data.summary.success",
+	"data.tls.server_hello.version.name",
+	"data.tls.server_hello.cipher_suite.name",
+	"data.tls.server_certificates.certificate.parsed.fingerprint_sha256",
+	"data.banner|first_line",
+	"error_component",
+}
+
+// NewOutputWriter returns a writer of grabs as newline-delimited JSON to the
+// configured files, and as CSV if a CSV file is set. Closing it does not
+// close the files.
+func NewOutputWriter(config *OutputConfig) (processing.ResultWriter, error) {
+	var out processing.ResultWriter
+	out = processing.NewNDJSONWriter(config.OutputFile, NewGrabMarshaler(), config.Options)
+	if config.ErrorFile != nil {
+		out = &processing.SplitWriter{
+			Out:    out,
+			Errors: processing.NewNDJSONWriter(config.ErrorFile, NewGrabMarshaler(), config.Options),
+			Failed: GrabFailed,
+		}
+	}
+	if config.CSVFile == nil {
+		return out, nil
+	}
+	fields := config.CSVFields
+	if len(fields) == 0 {
+		fields = DefaultCSVFields
+	}
+	csvOut, err := processing.NewCSVWriter(config.CSVFile, NewGrabMarshaler(), fields, config.Options)
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	return processing.MultiWriter{out, csvOut}, nil
 }
 
 func WriteOutput(grabChan chan Grab, doneChan chan int, config *OutputConfig) {
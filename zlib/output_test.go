@@ -0,0 +1,58 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOutputWriterCSVAlongsideJSON(t *testing.T) {
+	addr, stop := bannerServer(t, "220 mail.example.com ESMTP\r\n250 ignored\r\n")
+	defer stop()
+	config, target := retryConfig(addr)
+	grab := GrabBanner(config, target)
+
+	jsonFile, _ := ioutil.TempFile("", "zgrab-json")
+	csvFile, _ := ioutil.TempFile("", "zgrab-csv")
+	defer os.Remove(jsonFile.Name())
+	defer os.Remove(csvFile.Name())
+	out, err := NewOutputWriter(&OutputConfig{OutputFile: jsonFile, CSVFile: csvFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Write(grab); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := ioutil.ReadFile(jsonFile.Name()); strings.Count(string(b), "\n") != 1 {
+		t.Errorf("Wrong JSON output %q", b)
+	}
+	b, _ := ioutil.ReadFile(csvFile.Name())
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 2 || lines[0] != strings.Join(DefaultCSVFields, ",") {
+		t.Fatalf("Wrong CSV output %q", b)
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	if expected := "127.0.0.1," + port + ",true,,,,220 mail.example.com ESMTP,"; lines[1] != expected {
+		t.Errorf("Wrong CSV row %q, expected %q", lines[1], expected)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"io"
+	"net"
+	"regexp"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+)
+
+// BannerProbe reads this many bytes when not given a positive limit
+const bannerProbeDefaultMaxBytes = 1024
+
+// Why BannerProbe stopped reading
+const (
+	BannerProbeMatched  = "matched"
+	BannerProbeReadOnce = "read_once"
+	BannerProbeMaxBytes = "max_bytes"
+	BannerProbeTimeout  = "timeout"
+	BannerProbeClosed   = "closed"
+	BannerProbeError    = "error"
+)
+
+// A BannerProbeLog records the payload sent by BannerProbe, everything read
+// back and why reading stopped
+type BannerProbeLog struct {
+	Sent       string `json:"sent,omitempty"`
+	Response   string `json:"response,omitempty"`
+	StopReason string `json:"stop_reason"`
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+}
+
+// BannerProbe writes send, unless it is empty, then reads until the
+// accumulated response matches until, maxBytes have been read or timeout
+// elapses. A nil until means a single read. A timeout of zero keeps the
+// connection's deadline; a shorter one replaces it for the probe only.
+// Running out of bytes or time is recorded as the stop reason and only
+// returned as an error when nothing was read at all.
+func (c *Conn) BannerProbe(send []byte, until *regexp.Regexp, maxBytes int, timeout time.Duration) error {
+	start := time.Now()
+	if maxBytes <= 0 {
+		maxBytes = bannerProbeDefaultMaxBytes
+	}
+	p := new(BannerProbeLog)
+	c.grabData.BannerProbe = p
+
+	var sent []byte
+	if len(send) > 0 {
+		n, err := c.getUnderlyingConn().Write(send)
+		c.tracef("sent %d bytes: %q", n, send[0:n])
+		sent = send[0:n]
+		if err != nil {
+			p.StopReason = BannerProbeError
+			p.Error = err.Error()
+			p.ErrorClass = zerrors.Classify(err)
+			op := c.newOperation(OperationBannerProbe, start)
+			p.Sent = c.sentPayload(op, sent)
+			c.finishOperation(op)
+			return err
+		}
+	}
+
+	if timeout > 0 {
+		previous := c.readDeadline
+		deadline := time.Now().Add(timeout)
+		if previous.IsZero() || deadline.Before(previous) {
+			c.SetReadDeadline(deadline)
+			defer c.SetReadDeadline(previous)
+		}
+	}
+
+	res, err := c.readBannerProbe(p, until, maxBytes)
+	c.traceResponse("probe", res, err)
+	p.Response = string(res)
+	op := c.newOperation(OperationBannerProbe, start)
+	c.encodeResponse(op, res)
+	if sent != nil {
+		p.Sent = c.sentPayload(op, sent)
+	}
+	c.finishOperation(op)
+	if err != nil && (len(res) == 0 || p.StopReason == BannerProbeError) {
+		return err
+	}
+	return nil
+}
+
+// readBannerProbe reads a probe response, setting p's stop reason
+func (c *Conn) readBannerProbe(p *BannerProbeLog, until *regexp.Regexp, maxBytes int) ([]byte, error) {
+	res := make([]byte, 0, 512)
+	buf := make([]byte, 1024)
+	for {
+		want := maxBytes - len(res)
+		if want > len(buf) {
+			want = len(buf)
+		}
+		n, err := c.getUnderlyingConn().Read(buf[0:want])
+		res = append(res, buf[0:n]...)
+
+		switch {
+		case until != nil && until.Match(res):
+			p.StopReason = BannerProbeMatched
+			return res, nil
+		case err != nil:
+			p.Error = err.Error()
+			p.ErrorClass = zerrors.Classify(err)
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				p.StopReason = BannerProbeTimeout
+			} else if err == io.EOF {
+				p.StopReason = BannerProbeClosed
+			} else {
+				p.StopReason = BannerProbeError
+			}
+			return res, err
+		case until == nil:
+			p.StopReason = BannerProbeReadOnce
+			return res, nil
+		case len(res) >= maxBytes:
+			p.StopReason = BannerProbeMaxBytes
+			return res, nil
+		}
+	}
+}
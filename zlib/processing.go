@@ -15,16 +15,19 @@
 package zlib
 
 import (
+	"bytes"
 	"encoding/json"
 	"gopkg.in/eniac/zgrab.v0/ztools/processing"
+	"sync/atomic"
 )
 
 // GrabWorker implements ztools.processing.Worker
 type GrabWorker struct {
-	success uint
-	failure uint
+	success uint64
+	failure uint64
 
 	statuses chan status
+	counted  chan struct{}
 
 	config *Config
 }
@@ -36,24 +39,27 @@ const (
 	status_failure status = iota
 )
 
+// The counts may be read while grabs are running, e.g. to report progress
 func (g *GrabWorker) Success() uint {
-	return g.success
+	return uint(atomic.LoadUint64(&g.success))
 }
 
 func (g *GrabWorker) Failure() uint {
-	return g.failure
+	return uint(atomic.LoadUint64(&g.failure))
 }
 
 func (g *GrabWorker) Total() uint {
-	return g.success + g.failure
+	return g.Success() + g.Failure()
 }
 
 func (g *GrabWorker) RunCount() uint {
 	return g.config.ConnectionsPerHost
 }
 
+// Done waits for the last statuses to be counted
 func (g *GrabWorker) Done() {
 	close(g.statuses)
+	<-g.counted
 }
 
 func (g *GrabWorker) MakeHandler(id uint) processing.Handler {
@@ -62,6 +68,13 @@ func (g *GrabWorker) MakeHandler(id uint) processing.Handler {
 		if !ok {
 			return nil
 		}
+		if g.config.Resolve {
+			grabs := GrabResolved(g.config, &target)
+			for _, grab := range grabs {
+				g.statuses <- grab.status()
+			}
+			return grabs
+		}
 		grab := GrabBanner(g.config, &target)
 		s := grab.status()
 		g.statuses <- s
@@ -72,14 +85,16 @@ func (g *GrabWorker) MakeHandler(id uint) processing.Handler {
 func NewGrabWorker(config *Config) processing.Worker {
 	w := new(GrabWorker)
 	w.statuses = make(chan status, config.Senders*4)
+	w.counted = make(chan struct{})
 	w.config = config
 	go func() {
+		defer close(w.counted)
 		for s := range w.statuses {
 			switch s {
 			case status_success:
-				w.success++
+				atomic.AddUint64(&w.success, 1)
 			case status_failure:
-				w.failure++
+				atomic.AddUint64(&w.failure, 1)
 			default:
 				continue
 			}
@@ -90,8 +105,40 @@ func NewGrabWorker(config *Config) processing.Worker {
 
 type grabMarshaler struct{}
 
+// Marshal encodes a grab, or each of several grabs of one target on its
+// own line
 func (gm *grabMarshaler) Marshal(v interface{}) ([]byte, error) {
-	return json.Marshal(v)
+	grabs, ok := v.([]*Grab)
+	if !ok {
+		return json.Marshal(v)
+	}
+	lines := make([][]byte, 0, len(grabs))
+	for _, grab := range grabs {
+		line, err := json.Marshal(grab)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+// GrabFailed reports whether a worker result is a failed grab. The grabs of
+// a resolved domain only count as failed when none succeeded, so they stay
+// together in one output.
+func GrabFailed(v interface{}) bool {
+	switch g := v.(type) {
+	case *Grab:
+		return g.status() == status_failure
+	case []*Grab:
+		for _, grab := range g {
+			if grab.status() == status_success {
+				return false
+			}
+		}
+		return len(g) > 0
+	}
+	return false
 }
 
 func NewGrabMarshaler() processing.Marshaler {
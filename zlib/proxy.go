@@ -0,0 +1,323 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Proxy types, taken from the scheme of the proxy URL
+const (
+	ProxySOCKS5 = "socks5"
+	ProxyHTTP   = "http"
+)
+
+// Classifications of a failed proxy handshake
+const (
+	ProxyAuthFailed     = "auth_failed"
+	ProxyConnectRefused = "connect_refused"
+	ProxyConnectFailed  = "connect_failed"
+	ProxyProtocolError  = "protocol_error"
+)
+
+// A ProxyState records the handshake with the proxy that tunnels the
+// connection to the remote host
+type ProxyState struct {
+	Type       string  `json:"type"`
+	Address    string  `json:"address"`
+	Target     string  `json:"target"`
+	Method     string  `json:"method,omitempty"`
+	ReplyCode  int     `json:"reply_code"`
+	Reply      string  `json:"reply,omitempty"`
+	LatencyMs  float64 `json:"latency_ms"`
+	Error      string  `json:"error,omitempty"`
+	ErrorClass string  `json:"error_class,omitempty"`
+}
+
+// A ProxyError is returned when the proxy does not open the tunnel. Class
+// tells rejected credentials from a target the proxy could not reach.
+type ProxyError struct {
+	Class  string
+	Reason string
+}
+
+func (e *ProxyError) Error() string {
+	return "proxy: " + e.Reason
+}
+
+// ParseProxyURL parses a proxy given as socks5://[user:pass@]host:port or
+// http://[user:pass@]host:port
+func ParseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != ProxySOCKS5 && u.Scheme != ProxyHTTP {
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Port() == "" {
+		return nil, fmt.Errorf("proxy %s has no port", s)
+	}
+	return u, nil
+}
+
+// SOCKS5 constants, see RFC 1928 and RFC 1929
+const (
+	socks5Version          = 5
+	socks5AuthNone         = 0
+	socks5AuthPassword     = 2
+	socks5NoAcceptable     = 0xff
+	socks5CommandConnect   = 1
+	socks5AddrIPv4         = 1
+	socks5AddrDomain       = 3
+	socks5AddrIPv6         = 4
+	socks5ReplyNotAllowed  = 2
+	socks5ReplyRefused     = 5
+	socks5PasswordVersion  = 1
+	socks5PasswordAccepted = 0
+)
+
+var socks5Replies = map[byte]string{
+	0: "succeeded",
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// proxyHandshake asks the proxy on conn to open a tunnel to target and
+// records the exchange in state
+func proxyHandshake(conn net.Conn, proxy *url.URL, target string, state *ProxyState) error {
+	start := time.Now()
+	var err error
+	if proxy.Scheme == ProxySOCKS5 {
+		err = socks5Connect(conn, proxy, target, state)
+	} else {
+		err = httpConnect(conn, proxy, target, state)
+	}
+	state.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil {
+		state.Error = err.Error()
+		state.ErrorClass = ProxyProtocolError
+		if proxyErr, ok := err.(*ProxyError); ok {
+			state.ErrorClass = proxyErr.Class
+		}
+	}
+	return err
+}
+
+func socks5Connect(conn net.Conn, proxy *url.URL, target string, state *ProxyState) error {
+	methods := []byte{socks5AuthNone}
+	if proxy.User != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	var choice [2]byte
+	if _, err := io.ReadFull(conn, choice[:]); err != nil {
+		return err
+	}
+	if choice[0] != socks5Version {
+		return &ProxyError{Class: ProxyProtocolError, Reason: fmt.Sprintf("unexpected SOCKS version %d", choice[0])}
+	}
+	switch choice[1] {
+	case socks5AuthNone:
+		state.Method = "none"
+	case socks5AuthPassword:
+		state.Method = "username_password"
+		if proxy.User == nil {
+			return &ProxyError{Class: ProxyAuthFailed, Reason: "proxy requires a username and password"}
+		}
+		if err := socks5Authenticate(conn, proxy.User); err != nil {
+			return err
+		}
+	case socks5NoAcceptable:
+		return &ProxyError{Class: ProxyAuthFailed, Reason: "no acceptable authentication method"}
+	default:
+		return &ProxyError{Class: ProxyProtocolError, Reason: fmt.Sprintf("unexpected authentication method %d", choice[1])}
+	}
+
+	host, portString, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return err
+	}
+	req := []byte{socks5Version, socks5CommandConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %s too long for SOCKS", host)
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5AddrIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5AddrIPv6)
+		req = append(req, ip...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// VER, REP, RSV and ATYP, then the bound address and port
+	var reply [4]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	state.ReplyCode = int(reply[1])
+	state.Reply = socks5Replies[reply[1]]
+	var addrLen int
+	switch reply[3] {
+	case socks5AddrIPv4:
+		addrLen = net.IPv4len
+	case socks5AddrIPv6:
+		addrLen = net.IPv6len
+	case socks5AddrDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return err
+		}
+		addrLen = int(l[0])
+	default:
+		return &ProxyError{Class: ProxyProtocolError, Reason: fmt.Sprintf("unexpected address type %d", reply[3])}
+	}
+	if _, err := io.ReadFull(conn, make([]byte, addrLen+2)); err != nil {
+		return err
+	}
+	switch reply[1] {
+	case 0:
+		return nil
+	case socks5ReplyRefused, socks5ReplyNotAllowed:
+		return &ProxyError{Class: ProxyConnectRefused, Reason: state.Reply}
+	}
+	reason := state.Reply
+	if reason == "" {
+		reason = fmt.Sprintf("reply code %d", reply[1])
+	}
+	return &ProxyError{Class: ProxyConnectFailed, Reason: reason}
+}
+
+// socks5Authenticate sends the username and password, RFC 1929
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return errors.New("SOCKS username or password too long")
+	}
+	req := []byte{socks5PasswordVersion, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var status [2]byte
+	if _, err := io.ReadFull(conn, status[:]); err != nil {
+		return err
+	}
+	if status[1] != socks5PasswordAccepted {
+		return &ProxyError{Class: ProxyAuthFailed, Reason: "username and password rejected"}
+	}
+	return nil
+}
+
+// Cap on the size of the proxy's reply to CONNECT
+const maxConnectResponse = 8 * 1024
+
+func httpConnect(conn net.Conn, proxy *url.URL, target string, state *ProxyState) error {
+	req := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n"
+	if proxy.User != nil {
+		state.Method = "basic"
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	req += "\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return err
+	}
+
+	// Read a byte at a time, so nothing the remote host sends through the
+	// tunnel is consumed with the headers
+	var res []byte
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(res, []byte("\r\n\r\n")) {
+		if len(res) >= maxConnectResponse {
+			return &ProxyError{Class: ProxyProtocolError, Reason: "CONNECT response too long"}
+		}
+		if _, err := conn.Read(b); err != nil {
+			return err
+		}
+		res = append(res, b[0])
+	}
+	statusLine := res[:bytes.Index(res, []byte("\r\n"))]
+	var version string
+	var code int
+	if _, err := fmt.Sscanf(string(statusLine), "%s %d", &version, &code); err != nil {
+		return &ProxyError{Class: ProxyProtocolError, Reason: fmt.Sprintf("malformed CONNECT response %q", statusLine)}
+	}
+	state.ReplyCode = code
+	state.Reply = string(statusLine)
+	switch {
+	case code >= 200 && code < 300:
+		return nil
+	case code == 407:
+		return &ProxyError{Class: ProxyAuthFailed, Reason: state.Reply}
+	}
+	return &ProxyError{Class: ProxyConnectRefused, Reason: state.Reply}
+}
+
+// proxyConnect opens the tunnel to target over the connection to the
+// proxy, giving up at deadline. Credentials are left out of the state.
+func (c *Conn) proxyConnect(proxy *url.URL, target string, deadline time.Time) error {
+	if err := checkArgument("proxy target", target); err != nil {
+		c.conn.Close()
+		return err
+	}
+	start := time.Now()
+	state := &ProxyState{
+		Type:    proxy.Scheme,
+		Address: proxy.Host,
+		Target:  target,
+	}
+	c.grabData.Proxy = state
+	c.conn.SetDeadline(deadline)
+	err := proxyHandshake(c.conn, proxy, target, state)
+	c.recordOperation(OperationProxy, start)
+	if err != nil {
+		c.conn.Close()
+		return err
+	}
+	return c.conn.SetDeadline(time.Time{})
+}
@@ -0,0 +1,120 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+)
+
+// Quit reads at most this many bytes of the goodbye reply
+const quitMaxResponseSize = 4096
+
+// A Goodbye is the command Quit sends to end a session politely, and the
+// pattern matching the end of the server's reply to it. A Tagged command is
+// sent after the connection's next IMAP tag.
+type Goodbye struct {
+	Command string
+	End     *regexp.Regexp
+	Tagged  bool
+}
+
+var (
+	SMTPGoodbye = &Goodbye{Command: "QUIT\r\n", End: smtpEndRegex}
+	POP3Goodbye = &Goodbye{Command: "QUIT\r\n", End: pop3EndRegex}
+	IMAPGoodbye = &Goodbye{Command: "LOGOUT\r\n", End: imapTaggedEndRegex, Tagged: true}
+	// FTP replies use the same code and continuation format as SMTP
+	FTPGoodbye = &Goodbye{Command: "QUIT\r\n", End: smtpEndRegex}
+)
+
+// A QuitEvent records the goodbye sent by Quit and the server's reply
+type QuitEvent struct {
+	Command    string `json:"command"`
+	Response   string `json:"response,omitempty"`
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+}
+
+// SetGoodbye registers the goodbye sent by Quit. SMTP QUIT is used when none
+// is registered.
+func (c *Conn) SetGoodbye(g *Goodbye) {
+	c.goodbye = g
+}
+
+// SetQuitTimeout sets how long Quit waits for the reply to its goodbye. Zero
+// means Quit closes the connection right after sending it.
+func (c *Conn) SetQuitTimeout(d time.Duration) {
+	c.quitTimeout = d
+}
+
+// SetDrainTimeout makes Quit, and the grabber's close, drain the connection
+// for up to d with DrainAndClose instead of closing it. Zero means the
+// connection is closed straight away.
+func (c *Conn) SetDrainTimeout(d time.Duration) {
+	c.drainTimeout = d
+}
+
+// drainOrClose closes the connection, draining it first if a drain timeout
+// is set
+func (c *Conn) drainOrClose() error {
+	if c.drainTimeout > 0 {
+		return c.DrainAndClose(c.drainTimeout)
+	}
+	return c.Close()
+}
+
+// Quit sends the registered goodbye, over TLS if it has been negotiated,
+// optionally waits for the reply and closes the connection, draining it
+// first if a drain timeout is set. Failures to send or read the goodbye are
+// recorded rather than returned; the only error returned is from Close.
+func (c *Conn) Quit() error {
+	g := c.goodbye
+	if g == nil {
+		g = SMTPGoodbye
+	}
+	command := g.Command
+	if g.Tagged {
+		command = c.nextIMAPTag() + " " + command
+	}
+	start := time.Now()
+	q := &QuitEvent{Command: strings.TrimSpace(command)}
+	c.grabData.Quit = q
+	if _, err := c.getUnderlyingConn().Write([]byte(command)); err != nil {
+		q.Error = err.Error()
+		q.ErrorClass = zerrors.Classify(err)
+		c.recordOperation(OperationQuit, start)
+		return c.drainOrClose()
+	}
+	if c.quitTimeout <= 0 {
+		c.recordOperation(OperationQuit, start)
+		return c.drainOrClose()
+	}
+
+	deadline := time.Now().Add(c.quitTimeout)
+	if c.readDeadline.IsZero() || deadline.Before(c.readDeadline) {
+		c.SetReadDeadline(deadline)
+	}
+	res, err := c.readPooled(256, g.End, quitMaxResponseSize)
+	q.Response = string(res)
+	if err != nil {
+		q.Error = err.Error()
+		q.ErrorClass = zerrors.Classify(err)
+	}
+	c.recordResponse(OperationQuit, start, res)
+	return c.drainOrClose()
+}
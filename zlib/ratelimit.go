@@ -0,0 +1,177 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// A TokenBucket admits events at a steady rate, allowing bursts of up to
+// its capacity. It is safe for concurrent use. Each Wait reserves the next
+// free slot and sleeps until it, rather than polling, so many waiters are
+// spaced accurately.
+type TokenBucket struct {
+	mu        sync.Mutex
+	interval  time.Duration
+	tolerance time.Duration
+	// Theoretical arrival time of the next event at the steady rate
+	tat time.Time
+}
+
+// NewTokenBucket returns a bucket admitting rate events per second with
+// room for a burst of burst events, at least one
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	return &TokenBucket{
+		interval:  interval,
+		tolerance: time.Duration(burst-1) * interval,
+	}
+}
+
+// reserve takes the next slot and returns how long to wait for it
+func (b *TokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tat.Before(now) {
+		b.tat = now
+	}
+	wait := b.tat.Sub(now) - b.tolerance
+	b.tat = b.tat.Add(b.interval)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Wait blocks until the bucket admits an event or ctx, which may be nil, is
+// done. A slot reserved by a canceled Wait is not given back.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	return sleepContext(ctx, b.reserve(time.Now()))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case <-timer.C:
+		return nil
+	case <-done:
+		return ctx.Err()
+	}
+}
+
+// Destination prefixes a ConnectionLimiter caps separately
+const (
+	RatePrefixIPv4 = 24
+	RatePrefixIPv6 = 48
+)
+
+// A ConnectionLimiter caps the rate of new connections overall and to each
+// destination /24 (/48 for IPv6), so no single network is hammered
+type ConnectionLimiter struct {
+	global *TokenBucket
+
+	prefixInterval time.Duration
+	mu             sync.Mutex
+	// Theoretical arrival time of the next connection to each prefix
+	prefixes  map[string]time.Time
+	pruneSize int
+}
+
+// NewConnectionLimiter admits rate new connections per second in total and
+// prefixRate per second to any one prefix. Either may be zero for no cap.
+func NewConnectionLimiter(rate, prefixRate float64) *ConnectionLimiter {
+	l := &ConnectionLimiter{pruneSize: 1024}
+	if rate > 0 {
+		l.global = NewTokenBucket(rate, 1)
+	}
+	if prefixRate > 0 {
+		l.prefixInterval = time.Duration(float64(time.Second) / prefixRate)
+		l.prefixes = make(map[string]time.Time)
+	}
+	return l
+}
+
+// Wait blocks until a new connection to ip may be opened, or ctx, which may
+// be nil, is done. A nil ip is only subject to the overall cap.
+func (l *ConnectionLimiter) Wait(ctx context.Context, ip net.IP) error {
+	if l.prefixes != nil && ip != nil {
+		if err := sleepContext(ctx, l.reservePrefix(ip, time.Now())); err != nil {
+			return err
+		}
+	}
+	if l.global != nil {
+		return l.global.Wait(ctx)
+	}
+	return nil
+}
+
+// waitDial waits for the limiter, if any, before a connection to addr
+func (c *Config) waitDial(addr string) error {
+	if c.ConnectionLimiter == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return c.ConnectionLimiter.Wait(c.Context, hostIP(host))
+}
+
+func (l *ConnectionLimiter) reservePrefix(ip net.IP, now time.Time) time.Duration {
+	var key string
+	if ip4 := ip.To4(); ip4 != nil {
+		key = ip4.Mask(net.CIDRMask(RatePrefixIPv4, 32)).String()
+	} else {
+		key = ip.Mask(net.CIDRMask(RatePrefixIPv6, 128)).String()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.prefixes) >= l.pruneSize {
+		l.prune(now)
+	}
+	tat, ok := l.prefixes[key]
+	if !ok || tat.Before(now) {
+		tat = now
+	}
+	l.prefixes[key] = tat.Add(l.prefixInterval)
+	return tat.Sub(now)
+}
+
+// prune forgets the prefixes that could take a connection right away, as a
+// fresh entry would behave the same, and lets the map grow if most are busy
+func (l *ConnectionLimiter) prune(now time.Time) {
+	for key, tat := range l.prefixes {
+		if !tat.After(now) {
+			delete(l.prefixes, key)
+		}
+	}
+	if len(l.prefixes) >= l.pruneSize/2 {
+		l.pruneSize *= 2
+	}
+}
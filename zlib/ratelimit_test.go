@@ -0,0 +1,122 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRate(t *testing.T) {
+	const rate, events, workers = 10000, 4000, 16
+	b := NewTokenBucket(rate, 1)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < events/workers; j++ {
+				b.Wait(nil)
+			}
+		}()
+	}
+	wg.Wait()
+	expected := time.Duration(events-1) * time.Second / rate
+	if elapsed := time.Since(start); elapsed < expected || elapsed > expected*110/100 {
+		t.Errorf("%d events at %d/s took %s, expected %s", events, rate, elapsed, expected)
+	}
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	b := NewTokenBucket(10, 5)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		if wait := b.reserve(now); wait != 0 {
+			t.Fatalf("Event %d of the burst waited %s", i, wait)
+		}
+	}
+	if wait := b.reserve(now); wait != 100*time.Millisecond {
+		t.Errorf("Event after the burst waited %s, expected 100ms", wait)
+	}
+}
+
+func TestTokenBucketWaitCanceled(t *testing.T) {
+	b := NewTokenBucket(1, 1)
+	b.Wait(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait returned %v, expected the context's error", err)
+	}
+}
+
+func TestConnectionLimiterPerPrefix(t *testing.T) {
+	l := NewConnectionLimiter(0, 10)
+	now := time.Now()
+	if wait := l.reservePrefix(net.ParseIP("192.0.2.1"), now); wait != 0 {
+		t.Errorf("First connection waited %s", wait)
+	}
+	if wait := l.reservePrefix(net.ParseIP("192.0.2.200"), now); wait != 100*time.Millisecond {
+		t.Errorf("Second connection to the /24 waited %s, expected 100ms", wait)
+	}
+	if wait := l.reservePrefix(net.ParseIP("192.0.3.1"), now); wait != 0 {
+		t.Errorf("Connection to another /24 waited %s", wait)
+	}
+	if wait := l.reservePrefix(net.ParseIP("2001:db8:1:2::1"), now); wait != 0 {
+		t.Errorf("First IPv6 connection waited %s", wait)
+	}
+	if wait := l.reservePrefix(net.ParseIP("2001:db8:1:3::1"), now); wait != 100*time.Millisecond {
+		t.Errorf("Second connection to the /48 waited %s, expected 100ms", wait)
+	}
+
+	// Idle prefixes are forgotten once the map fills up
+	later := now.Add(time.Second)
+	for i := 0; i < 2000; i++ {
+		l.reservePrefix(net.IPv4(10, byte(i>>8), byte(i), 1), later)
+	}
+	if len(l.prefixes) > 2000 || l.pruneSize > 4096 {
+		t.Errorf("%d prefixes tracked, prune size %d", len(l.prefixes), l.pruneSize)
+	}
+}
+
+func TestDialWaitsForLimiter(t *testing.T) {
+	addr, stop := bannerServer(t, "220 ready\r\n")
+	defer stop()
+	config, target := retryConfig(addr)
+	config.ConnectionLimiter = NewConnectionLimiter(20, 0)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if grab := GrabBanner(config, target); grab.Error != nil {
+			t.Fatal(grab.Error)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("3 connections at 20/s took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	config.Context = ctx
+	config.ConnectionLimiter = NewConnectionLimiter(0.1, 0)
+	config.ConnectionLimiter.Wait(nil, nil)
+	if grab := GrabBanner(config, target); grab.Error != context.Canceled || grab.ErrorComponent != "connect" {
+		t.Errorf("Canceled wait reported %v (component %q)", grab.Error, grab.ErrorComponent)
+	}
+}
@@ -0,0 +1,66 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"net"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+)
+
+// ErrReadLimitExceeded is returned by reads on a Conn once the bytes read
+// from the remote host reach the limit set with SetMaxReadBytes
+var ErrReadLimitExceeded = zerrors.New(zerrors.TooManyBytes, "read limit exceeded")
+
+// readLimitConn caps the total number of bytes read through it
+type readLimitConn struct {
+	net.Conn
+	remaining int
+	exceeded  bool
+}
+
+func (l *readLimitConn) Read(b []byte) (int, error) {
+	if l.remaining <= 0 {
+		l.exceeded = true
+		return 0, ErrReadLimitExceeded
+	}
+	if len(b) > l.remaining {
+		b = b[0:l.remaining]
+	}
+	n, err := l.Conn.Read(b)
+	l.remaining -= n
+	return n, err
+}
+
+// SetMaxReadBytes limits the bytes read from the remote host over the life
+// of the connection, including TLS records. It must be called before the TLS
+// handshake. Zero means no limit.
+func (c *Conn) SetMaxReadBytes(max int) {
+	if max <= 0 || c.conn == nil {
+		return
+	}
+	c.readLimit = &readLimitConn{Conn: c.conn, remaining: max}
+	c.conn = c.readLimit
+}
+
+// readLimitExceeded reports, once, that a read hit the limit since the last
+// recorded operation
+func (c *Conn) readLimitExceeded() bool {
+	if c.readLimit == nil || !c.readLimit.exceeded || c.readLimitRecorded {
+		return false
+	}
+	c.readLimitRecorded = true
+	return true
+}
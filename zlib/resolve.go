@@ -0,0 +1,196 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/dns"
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+)
+
+// Policies for choosing which resolved addresses to grab
+const (
+	ResolvePolicyFirst  = "first"
+	ResolvePolicyRandom = "random"
+	ResolvePolicyAll    = "all"
+)
+
+// A DNSQuery records one A or AAAA lookup
+type DNSQuery struct {
+	Type       string   `json:"type"`
+	Rcode      string   `json:"rcode,omitempty"`
+	RTTMs      float64  `json:"rtt_ms"`
+	Answers    []string `json:"answers,omitempty"`
+	Truncated  bool     `json:"truncated,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	ErrorClass string   `json:"error_class,omitempty"`
+}
+
+// A DNSState records how a domain target was resolved and which of its
+// addresses were grabbed
+type DNSState struct {
+	Domain   string      `json:"domain"`
+	Resolver string      `json:"resolver"`
+	Policy   string      `json:"policy"`
+	Rcode    string      `json:"rcode,omitempty"`
+	Queries  []*DNSQuery `json:"queries"`
+	Addrs    []string    `json:"addrs,omitempty"`
+	Chosen   []string    `json:"chosen,omitempty"`
+}
+
+// A DNSError is returned when a domain target has no address to grab,
+// either because the resolver answered with an error response code such as
+// NXDOMAIN or SERVFAIL, or because the answer held no records
+type DNSError struct {
+	Domain string
+	Rcode  string
+}
+
+func (e *DNSError) Error() string {
+	if e.Rcode == "" || e.Rcode == dns.RcodeName(dns.RcodeSuccess) {
+		return fmt.Sprintf("dns: no addresses found for %s", e.Domain)
+	}
+	return fmt.Sprintf("dns: %s looking up %s", e.Rcode, e.Domain)
+}
+
+// SystemResolver returns the first nameserver listed in /etc/resolv.conf,
+// or the local host if there is none
+func SystemResolver() string {
+	server := "127.0.0.1"
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				server = fields[1]
+				break
+			}
+		}
+	}
+	return net.JoinHostPort(server, "53")
+}
+
+// resolveTarget looks up the A and/or AAAA records of domain, as allowed by
+// the configured address family
+func resolveTarget(config *Config, domain string) (*DNSState, []net.IP) {
+	state := &DNSState{
+		Domain:   domain,
+		Resolver: config.Resolver,
+		Policy:   config.ResolvePolicy,
+	}
+	var qtypes []uint16
+	if config.AddressFamily != "6" {
+		qtypes = append(qtypes, dns.TypeA)
+	}
+	if config.AddressFamily != "4" {
+		qtypes = append(qtypes, dns.TypeAAAA)
+	}
+	var addrs []net.IP
+	for _, qtype := range qtypes {
+		query, res := lookup(config, domain, qtype)
+		state.Queries = append(state.Queries, query)
+		if res == nil {
+			continue
+		}
+		addrs = append(addrs, res.Addrs...)
+		if state.Rcode == "" || res.Rcode == dns.RcodeSuccess {
+			state.Rcode = query.Rcode
+		}
+	}
+	for _, ip := range addrs {
+		state.Addrs = append(state.Addrs, ip.String())
+	}
+	return state, addrs
+}
+
+// lookup sends a single query to the configured resolver
+func lookup(config *Config, domain string, qtype uint16) (*DNSQuery, *dns.Response) {
+	query := &DNSQuery{Type: dns.TypeName(qtype)}
+	start := time.Now()
+	res, err := func() (*dns.Response, error) {
+		conn, err := net.DialTimeout("udp", config.Resolver, config.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		conn.SetDeadline(start.Add(config.Timeout))
+		return dns.Query(conn, domain, qtype)
+	}()
+	query.RTTMs = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil {
+		query.Error = err.Error()
+		query.ErrorClass = zerrors.Classify(err)
+		return query, nil
+	}
+	query.Rcode = dns.RcodeName(res.Rcode)
+	query.Truncated = res.Truncated
+	for _, ip := range res.Addrs {
+		query.Answers = append(query.Answers, ip.String())
+	}
+	return query, res
+}
+
+// chooseAddrs applies the resolve policy to the resolved addresses
+func chooseAddrs(policy string, addrs []net.IP) []net.IP {
+	if len(addrs) == 0 {
+		return nil
+	}
+	switch policy {
+	case ResolvePolicyAll:
+		return addrs
+	case ResolvePolicyRandom:
+		return []net.IP{addrs[rand.Intn(len(addrs))]}
+	}
+	return addrs[:1]
+}
+
+// GrabResolved resolves the target's domain and grabs the addresses chosen
+// by the resolve policy, one grab each. The domain is kept on every grab,
+// so it is still sent as SNI and in the HTTP Host header. When nothing can
+// be grabbed, the single grab returned carries the DNSError.
+func GrabResolved(config *Config, target *GrabTarget) []*Grab {
+	t := time.Now()
+	state, addrs := resolveTarget(config, target.Domain)
+	chosen := chooseAddrs(config.ResolvePolicy, addrs)
+	if len(chosen) == 0 {
+		return []*Grab{{
+			Domain:         target.Domain,
+			Time:           t,
+			Data:           GrabData{DNS: state},
+			Error:          &DNSError{Domain: target.Domain, Rcode: state.Rcode},
+			ErrorComponent: "dns",
+		}}
+	}
+	for _, ip := range chosen {
+		state.Chosen = append(state.Chosen, ip.String())
+	}
+	grabs := make([]*Grab, 0, len(chosen))
+	for _, ip := range chosen {
+		resolved := *target
+		resolved.Addr = ip
+		grab := GrabBanner(config, &resolved)
+		grab.Data.DNS = state
+		grabs = append(grabs, grab)
+	}
+	return grabs
+}
@@ -0,0 +1,158 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/dns"
+	"gopkg.in/eniac/zgrab.v0/ztools/zlog"
+)
+
+type fakeDNSAnswer struct {
+	rcode int
+	a     []net.IP
+}
+
+// fakeDNSServer answers A queries from answers, keyed by name, and AAAA
+// queries with no records. Unknown names get NXDOMAIN.
+func fakeDNSServer(t *testing.T, answers map[string]fakeDNSAnswer) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := buf[:n]
+			// The question starts after the header and ends with QTYPE
+			// and QCLASS after the name's terminating zero
+			end := 12
+			var name string
+			for query[end] != 0 {
+				if name != "" {
+					name += "."
+				}
+				name += string(query[end+1 : end+1+int(query[end])])
+				end += 1 + int(query[end])
+			}
+			end += 5
+			qtype := binary.BigEndian.Uint16(query[end-4:])
+			answer, ok := answers[name]
+			if !ok {
+				answer = fakeDNSAnswer{rcode: dns.RcodeNameError}
+			}
+			var records []net.IP
+			if qtype == dns.TypeA {
+				records = answer.a
+			}
+			reply := append([]byte{}, query[:end]...)
+			binary.BigEndian.PutUint16(reply[2:], 0x8180|uint16(answer.rcode))
+			binary.BigEndian.PutUint16(reply[6:], uint16(len(records)))
+			for _, ip := range records {
+				reply = append(reply, 0xc0, 12, 0, 1, 0, 1, 0, 0, 1, 0, 0, 4)
+				reply = append(reply, ip.To4()...)
+			}
+			pc.WriteTo(reply, addr)
+		}
+	}()
+	return pc.LocalAddr().String(), func() { pc.Close() }
+}
+
+func TestResolveTargetOutcomes(t *testing.T) {
+	resolver, stop := fakeDNSServer(t, map[string]fakeDNSAnswer{
+		"example.com": {a: []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")}},
+		"broken.com":  {rcode: dns.RcodeServerFailure},
+	})
+	defer stop()
+	config := &Config{Timeout: time.Second, Resolver: resolver, ResolvePolicy: ResolvePolicyFirst}
+
+	state, addrs := resolveTarget(config, "example.com")
+	if state.Rcode != "NOERROR" || len(addrs) != 2 || len(state.Queries) != 2 {
+		t.Errorf("Wrong state for example.com: %+v", state)
+	}
+	if state.Resolver != resolver || state.Queries[0].Type != "A" || len(state.Queries[0].Answers) != 2 {
+		t.Errorf("Wrong A query for example.com: %+v", state.Queries[0])
+	}
+
+	for domain, rcode := range map[string]string{"missing.com": "NXDOMAIN", "broken.com": "SERVFAIL"} {
+		grabs := GrabResolved(config, &GrabTarget{Domain: domain})
+		if len(grabs) != 1 || grabs[0].ErrorComponent != "dns" {
+			t.Fatalf("%s: expected a single dns error, got %v", domain, grabs)
+		}
+		dnsErr, ok := grabs[0].Error.(*DNSError)
+		if !ok || dnsErr.Rcode != rcode || grabs[0].Data.DNS.Rcode != rcode {
+			t.Errorf("%s: wrong outcome %v, state %+v", domain, grabs[0].Error, grabs[0].Data.DNS)
+		}
+	}
+}
+
+func TestGrabResolvedPolicyAll(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("220 ready\r\n"))
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	portNum, _ := net.LookupPort("tcp", port)
+
+	resolver, stop := fakeDNSServer(t, map[string]fakeDNSAnswer{
+		"example.com": {a: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.1")}},
+	})
+	defer stop()
+	config := &Config{
+		Port:          uint16(portNum),
+		Timeout:       time.Second,
+		Banners:       true,
+		Resolve:       true,
+		Resolver:      resolver,
+		ResolvePolicy: ResolvePolicyAll,
+		ErrorLog:      zlog.New(os.Stderr, "banner-grab"),
+	}
+
+	grabs := GrabResolved(config, &GrabTarget{Domain: "example.com"})
+	if len(grabs) != 2 {
+		t.Fatalf("Expected a grab per address, got %d", len(grabs))
+	}
+	for _, grab := range grabs {
+		if grab.Error != nil {
+			t.Fatalf("Grab failed: %s", grab.Error)
+		}
+		if grab.Domain != "example.com" || !grab.IP.Equal(net.ParseIP("127.0.0.1")) || grab.Data.Banner != "220 ready\r\n" {
+			t.Errorf("Wrong grab: %s %s %q", grab.Domain, grab.IP, grab.Data.Banner)
+		}
+		if len(grab.Data.DNS.Chosen) != 2 {
+			t.Errorf("Wrong chosen addresses: %v", grab.Data.DNS.Chosen)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
+)
+
+// Ways a session can be resumed, as recorded in ResumptionLog.Method
+const (
+	ResumptionMethodTicket    = "session_ticket"
+	ResumptionMethodSessionID = "session_id"
+)
+
+// A ResumptionLog records an attempt to resume the TLS session of the first
+// handshake on a second connection to the same address
+type ResumptionLog struct {
+	// What the first handshake left to resume with
+	TicketIssued    bool `json:"ticket_issued"`
+	SessionIDIssued bool `json:"session_id_issued"`
+
+	Resumed bool   `json:"resumed"`
+	Method  string `json:"method,omitempty"`
+
+	// Whether the server sent a NewSessionTicket on the second connection
+	NewTicket  bool   `json:"new_ticket"`
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+}
+
+// SetRedialer sets how ResumptionCheck opens its second connection
+func (c *Conn) SetRedialer(redial func() (*Conn, error)) {
+	c.redial = redial
+}
+
+// SetResumptionCheck caches the TLS session of the next handshake so that
+// ResumptionCheck can offer it again. It must be called before the handshake.
+func (c *Conn) SetResumptionCheck() {
+	c.sessionCache = ztls.NewLRUClientSessionCache(1)
+}
+
+// ResumptionCheck opens a second connection and tries to resume the session
+// negotiated by the TLS handshake on c. A server that answers a session ID
+// with a full handshake is recorded as not resumed. Only a failure to open
+// the second connection is returned as an error.
+func (c *Conn) ResumptionCheck() error {
+	if !c.isTls {
+		return fmt.Errorf(
+			"Must perform TLS handshake before checking resumption with %s",
+			c.RemoteAddr().String())
+	}
+	if c.sessionCache == nil || c.redial == nil {
+		return errors.New("Resumption check was not set up before the TLS handshake")
+	}
+
+	r := new(ResumptionLog)
+	c.grabData.Resumption = r
+	if hl := c.grabData.TLSHandshake; hl != nil {
+		r.TicketIssued = hl.SessionTicket != nil
+		r.SessionIDIssued = hl.ServerHello != nil && len(hl.ServerHello.SessionID) > 0
+	}
+
+	defer c.recordOperation(OperationResumption, time.Now())
+	second, err := c.redial()
+	if err != nil {
+		r.Error = err.Error()
+		r.ErrorClass = zerrors.Classify(err)
+		return err
+	}
+	defer second.Close()
+
+	tlsConn := ztls.Client(second.conn, c.tlsClientConfig())
+	tlsConn.SetReadDeadline(second.readDeadline)
+	tlsConn.SetWriteDeadline(second.writeDeadline)
+	if err := tlsConn.Handshake(); err != nil {
+		r.Error = err.Error()
+		r.ErrorClass = zerrors.Classify(err)
+		return nil
+	}
+	second.tlsConn = tlsConn
+	second.isTls = true
+
+	state := tlsConn.ConnectionState()
+	r.Resumed = state.DidResume
+	if state.DidResume && state.ResumedWithTicket {
+		r.Method = ResumptionMethodTicket
+	} else if state.DidResume {
+		r.Method = ResumptionMethodSessionID
+	}
+	r.NewTicket = tlsConn.GetHandshakeLog().SessionTicket != nil
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"errors"
+	"syscall"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+)
+
+// A RetryAttempt records one of the attempts at grabbing a target
+type RetryAttempt struct {
+	Index          int           `json:"index"`
+	Time           string        `json:"timestamp"`
+	Connect        *ConnectState `json:"connect,omitempty"`
+	Error          string        `json:"error,omitempty"`
+	ErrorClass     string        `json:"error_class,omitempty"`
+	ErrorComponent string        `json:"error_component,omitempty"`
+}
+
+// A RetryLog lists every attempt made at a target. Final is the index of
+// the attempt whose results the grab holds, the successful one if any.
+type RetryLog struct {
+	Attempts []*RetryAttempt `json:"attempts"`
+	Final    int             `json:"final"`
+}
+
+// retryable reports whether a failed grab may succeed on a fresh
+// connection: a connect timeout, a refused connection when configured to,
+// or a reset by the remote host
+func retryable(config *Config, grab *Grab) bool {
+	if errors.Is(grab.Error, syscall.ECONNRESET) {
+		return true
+	}
+	if grab.ErrorComponent != "connect" {
+		return false
+	}
+	switch classifyConnectError(grab.Error) {
+	case zerrors.ConnectionTimeout:
+		return true
+	case zerrors.ConnectionRefused:
+		return config.RetryRefused
+	}
+	return false
+}
+
+// grabWithRetries grabs up to config.RetryAttempts times, re-dialing from
+// scratch each time, while failures are retryable. Later attempts wait
+// RetryDelay, growing by RetryBackoff, and are not started if they would
+// run past the RetryBudget measured from the first attempt.
+func grabWithRetries(config *Config, grabOnce func() *Grab) *Grab {
+	if config.RetryAttempts <= 1 {
+		return grabOnce()
+	}
+	start := time.Now()
+	delay := config.RetryDelay
+	retries := new(RetryLog)
+	var grab *Grab
+	for i := 0; ; i++ {
+		grab = grabOnce()
+		attempt := &RetryAttempt{
+			Index:          i,
+			Time:           grab.Time.Format(time.RFC3339),
+			Connect:        grab.Data.Connect,
+			ErrorComponent: grab.ErrorComponent,
+		}
+		if grab.Error != nil {
+			attempt.Error = grab.Error.Error()
+			attempt.ErrorClass = zerrors.Classify(grab.Error)
+		}
+		retries.Attempts = append(retries.Attempts, attempt)
+		retries.Final = i
+		if grab.Error == nil || uint(i+1) >= config.RetryAttempts || !retryable(config, grab) {
+			break
+		}
+		if config.RetryBudget > 0 && time.Since(start)+delay >= config.RetryBudget {
+			break
+		}
+		time.Sleep(delay)
+		delay = time.Duration(float64(delay) * config.RetryBackoff)
+	}
+	grab.Data.Retries = retries
+	return grab
+}
@@ -0,0 +1,109 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+	"gopkg.in/eniac/zgrab.v0/ztools/zlog"
+)
+
+func retryConfig(addr string) (*Config, *GrabTarget) {
+	host, port, _ := net.SplitHostPort(addr)
+	portNum, _ := net.LookupPort("tcp", port)
+	config := &Config{
+		Port:          uint16(portNum),
+		Timeout:       time.Second,
+		Banners:       true,
+		RetryAttempts: 3,
+		RetryDelay:    time.Millisecond,
+		RetryBackoff:  2,
+		ErrorLog:      zlog.New(os.Stderr, "banner-grab"),
+	}
+	return config, &GrabTarget{Addr: net.ParseIP(host)}
+}
+
+func TestRetryRefused(t *testing.T) {
+	addr, stop := bannerServer(t, "")
+	stop()
+	config, target := retryConfig(addr)
+
+	grab := GrabBanner(config, target)
+	if retries := grab.Data.Retries; retries == nil || len(retries.Attempts) != 1 {
+		t.Fatalf("Refused connection retried without --retry-refused: %+v", retries)
+	}
+
+	config.RetryRefused = true
+	grab = GrabBanner(config, target)
+	retries := grab.Data.Retries
+	if len(retries.Attempts) != 3 || retries.Final != 2 || grab.ErrorComponent != "connect" {
+		t.Fatalf("Wrong attempts: %+v", retries)
+	}
+	for i, attempt := range retries.Attempts {
+		if attempt.Index != i || attempt.Connect.ErrorClass != zerrors.ConnectionRefused {
+			t.Errorf("Wrong attempt %d: %+v", i, attempt)
+		}
+	}
+
+	config.RetryDelay = 100 * time.Millisecond
+	config.RetryBudget = 50 * time.Millisecond
+	grab = GrabBanner(config, target)
+	if n := len(grab.Data.Retries.Attempts); n != 1 {
+		t.Errorf("Retried past the budget: %d attempts", n)
+	}
+}
+
+func TestRetryAfterReset(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var accepted int32
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if atomic.AddInt32(&accepted, 1) == 1 {
+				// Reset the first connection while the banner is awaited
+				time.Sleep(50 * time.Millisecond)
+				c.(*net.TCPConn).SetLinger(0)
+			} else {
+				c.Write([]byte("220 ready\r\n"))
+			}
+			c.Close()
+		}
+	}()
+	config, target := retryConfig(l.Addr().String())
+
+	grab := GrabBanner(config, target)
+	if grab.Error != nil {
+		t.Fatalf("Grab failed: %s", grab.Error)
+	}
+	retries := grab.Data.Retries
+	if len(retries.Attempts) != 2 || retries.Final != 1 || retries.Attempts[0].ErrorComponent != "banner" {
+		t.Fatalf("Wrong attempts: %+v", retries)
+	}
+	if grab.Data.Banner != "220 ready\r\n" {
+		t.Errorf("Wrong banner: %q", grab.Data.Banner)
+	}
+}
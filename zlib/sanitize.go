@@ -0,0 +1,51 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import "fmt"
+
+// An InvalidArgumentError is returned before anything is sent when a value
+// to be interpolated into a protocol command, e.g. a target domain taken
+// from the input, is empty where one is required or contains control
+// characters that could end the command and start another
+type InvalidArgumentError struct {
+	Field string
+	Value string
+}
+
+func (e *InvalidArgumentError) Error() string {
+	return fmt.Sprintf("Invalid %s %q", e.Field, e.Value)
+}
+
+// checkArgument returns an *InvalidArgumentError if value contains a
+// control character, including CR and LF
+func checkArgument(field, value string) error {
+	for _, r := range value {
+		if r < ' ' || r == 0x7f {
+			return &InvalidArgumentError{Field: field, Value: value}
+		}
+	}
+	return nil
+}
+
+// checkArguments is checkArgument for each field and value pair
+func checkArguments(pairs ...string) error {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if err := checkArgument(pairs[i], pairs[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeRecorder fails every read and keeps whatever is written to it
+type writeRecorder struct {
+	net.Conn
+	written []byte
+}
+
+func (w *writeRecorder) Write(b []byte) (int, error) {
+	w.written = append(w.written, b...)
+	return len(b), nil
+}
+
+func (w *writeRecorder) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func TestInjectionRejectedBeforeWriting(t *testing.T) {
+	const bad = "example.com\r\nMAIL FROM:<relay@example.com>"
+	tests := []struct {
+		name string
+		run  func(c *Conn) error
+	}{
+		{"EHLO", func(c *Conn) error { return c.EHLO(bad) }},
+		{"VRFY", func(c *Conn) error { return c.SMTPVrfy(bad) }},
+		{"EXPN", func(c *Conn) error { return c.SMTPExpn(bad) }},
+		{"STARTTLS", func(c *Conn) error { return c.sendStartTLSCommand("STARTTLS\r\nRSET\r\n") }},
+		{"XMPP", func(c *Conn) error { return c.XMPPStartTLSHandshake(bad) }},
+		{"HTTP host", func(c *Conn) error {
+			c.SetDomain(bad)
+			_, _, err := c.makeHTTPRequest("/", "GET", "")
+			return err
+		}},
+		{"HTTP endpoint", func(c *Conn) error {
+			_, _, err := c.makeHTTPRequest("/\r\nX-Injected: 1", "GET", "")
+			return err
+		}},
+		{"HTTP user agent", func(c *Conn) error {
+			_, _, err := c.makeHTTPRequest("/", "GET", "zgrab\nX-Injected: 1")
+			return err
+		}},
+		{"proxy domain", func(c *Conn) error { return c.doProxy(&HTTPConfig{Method: "GET", ProxyDomain: bad}) }},
+		{"proxy target", func(c *Conn) error {
+			return c.proxyConnect(&url.URL{Scheme: "http", Host: "proxy.example.com:3128"}, bad+":25", time.Now().Add(time.Second))
+		}},
+		{"SIP target", func(c *Conn) error {
+			c.SetDomain(bad)
+			return c.SIPOptions(true)
+		}},
+		{"data", func(c *Conn) error {
+			c.SetDomain(bad)
+			_, err := expandData(c, []byte("HELO %d\r\n"))
+			return err
+		}},
+	}
+	for _, test := range tests {
+		client, server := net.Pipe()
+		w := &writeRecorder{Conn: client}
+		c := &Conn{conn: w}
+		err := test.run(c)
+		if _, ok := err.(*InvalidArgumentError); !ok {
+			t.Errorf("%s: Expected an InvalidArgumentError, got %v", test.name, err)
+		}
+		if len(w.written) != 0 {
+			t.Errorf("%s: Sent %q", test.name, w.written)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestHTTPGrabberRejectsInjectedHost(t *testing.T) {
+	config := &Config{Port: 80, Timeout: time.Second, HTTP: HTTPConfig{Method: "GET", Endpoint: "/"}}
+	grabData := GrabData{HTTP: new(HTTP)}
+	err := makeHTTPGrabber(config, &grabData)("192.0.2.1:80", "/", "example.com\r\nX-Injected: 1")
+	if _, ok := err.(*InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError, got %v", err)
+	}
+}
+
+func TestCheckArgumentAllowsPrintable(t *testing.T) {
+	for _, value := range []string{"", "mail.example.com", "[2001:db8::1]", "<postmaster@example.com>", "John Smith", "bücher.example"} {
+		if err := checkArgument("value", value); err != nil {
+			t.Errorf("%q rejected: %s", value, err.Error())
+		}
+	}
+	for _, value := range []string{"a\rb", "a\nb", "a\x00b", "a\tb", "a\x7fb"} {
+		if err := checkArgument("value", value); err == nil {
+			t.Errorf("%q accepted", value)
+		}
+	}
+}
+
+func TestXMPPDomainEscaped(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	w := &writeRecorder{Conn: client}
+	c := &Conn{conn: w}
+	defer c.Close()
+
+	c.XMPPStartTLSHandshake("example.com' xmlns='evil")
+	if got := string(w.written); !strings.Contains(got, "to='example.com&apos; xmlns=&apos;evil'") {
+		t.Errorf("Domain not escaped: %s", got)
+	}
+}
@@ -0,0 +1,50 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import "sync"
+
+// Run grabs the targets read from targets on the given number of
+// goroutines, running probe on each connection after dialing it as
+// configured. Timeout and TotalTimeout bound each target, and failed
+// grabs are retried as configured. The grabs are sent on the returned
+// channel, which is closed once targets is closed and the grabs in flight
+// are done, so to shut down gracefully stop sending and close targets.
+// Canceling config.Context aborts the grabs in flight instead.
+func Run(config *Config, targets <-chan GrabTarget, workers int, probe func(*Conn) error) <-chan *Grab {
+	results := make(chan *Grab, workers*4)
+	grabber := func(c *Conn) error {
+		defer c.Close()
+		return probe(c)
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for target := range targets {
+				target := target
+				results <- grabWithRetries(config, func() *Grab {
+					return grabConn(config, &target, grabber)
+				})
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
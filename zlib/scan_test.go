@@ -0,0 +1,52 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRunGrabsEveryTarget(t *testing.T) {
+	addr, stop := bannerServer(t, "220 ready\r\n")
+	defer stop()
+	config, target := retryConfig(addr)
+
+	targets := make(chan GrabTarget)
+	go func() {
+		for i := 0; i < 20; i++ {
+			targets <- *target
+		}
+		close(targets)
+	}()
+	probe := func(c *Conn) error {
+		_, err := c.BasicBanner()
+		return err
+	}
+
+	n := 0
+	for grab := range Run(config, targets, 4, probe) {
+		if grab.Error != nil || grab.Data.Banner != "220 ready\r\n" {
+			t.Errorf("Wrong grab: banner %q, error %v", grab.Data.Banner, grab.Error)
+		}
+		if !grab.IP.Equal(net.ParseIP("127.0.0.1")) || !grab.Data.Summary.Success {
+			t.Errorf("Wrong grab of %s: %+v", grab.IP, grab.Data.Summary)
+		}
+		n++
+	}
+	if n != 20 {
+		t.Errorf("Got %d grabs, expected 20", n)
+	}
+}
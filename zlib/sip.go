@@ -0,0 +1,148 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/sip"
+)
+
+// sipMaxRetransmits caps the T1 schedule at four sends, waiting 500ms, 1s,
+// 2s and 4s, well short of the 64*T1 a real client allows
+const sipMaxRetransmits = 3
+
+// sipMaxHeaderBytes bounds the response headers read over TCP
+const sipMaxHeaderBytes = 16384
+
+// A SIPLog records the final response to an OPTIONS request and whether
+// a provisional response came first
+type SIPLog struct {
+	Transport   string        `json:"transport"`
+	Response    *sip.Response `json:"response,omitempty"`
+	Provisional bool          `json:"provisional,omitempty"`
+	Retransmits int           `json:"retransmits,omitempty"`
+}
+
+var errSIPHeadersTooLong = errors.New("sip: response headers too long")
+
+func sipToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SIPOptions sends an OPTIONS request and records the final response
+// carrying our branch. Over UDP other datagrams are ignored and the request
+// is retransmitted on the T1 schedule, within the read deadline.
+func (c *Conn) SIPOptions(tcp bool) error {
+	log := &SIPLog{Transport: "UDP"}
+	if tcp {
+		log.Transport = "TCP"
+	}
+	c.grabData.SIP = log
+	target := c.domain
+	if target == "" {
+		target, _, _ = net.SplitHostPort(c.RemoteAddr().String())
+		if ip := net.ParseIP(target); ip != nil && ip.To4() == nil {
+			target = "[" + target + "]"
+		}
+	}
+	if err := checkArgument("SIP target", target); err != nil {
+		return err
+	}
+	opts := &sip.Options{
+		Transport: log.Transport,
+		Local:     c.LocalAddr(),
+		Target:    target,
+		Branch:    sip.BranchPrefix + sipToken(8),
+		Tag:       sipToken(4),
+		CallID:    sipToken(16),
+	}
+	accept := func(b []byte) (bool, error) {
+		res, err := sip.ParseResponse(b)
+		if err != nil || res.Branch != opts.Branch {
+			return false, nil
+		}
+		if res.StatusCode < 200 {
+			log.Provisional = true
+			return false, nil
+		}
+		log.Response = res
+		return true, nil
+	}
+	if tcp {
+		return c.sipOverTCP(opts.Request(), accept)
+	}
+	return c.sipOverUDP(opts.Request(), accept, log)
+}
+
+func (c *Conn) sipOverUDP(request []byte, accept func([]byte) (bool, error), log *SIPLog) error {
+	deadline := c.readDeadline
+	defer c.SetReadDeadline(deadline)
+	wait := sip.T1
+	for {
+		if _, err := c.Write(request); err != nil {
+			return err
+		}
+		until := time.Now().Add(wait)
+		if !deadline.IsZero() && deadline.Before(until) {
+			until = deadline
+		}
+		c.getUnderlyingConn().SetReadDeadline(until)
+		err := c.readDatagrams(accept)
+		ne, ok := err.(net.Error)
+		if !ok || !ne.Timeout() || log.Retransmits == sipMaxRetransmits || until.Equal(deadline) {
+			return err
+		}
+		log.Retransmits++
+		wait *= 2
+	}
+}
+
+// sipOverTCP sends request once and hands each complete header block read
+// from the stream to accept, skipping provisional responses
+func (c *Conn) sipOverTCP(request []byte, accept func([]byte) (bool, error)) error {
+	if _, err := c.Write(request); err != nil {
+		return err
+	}
+	var buf []byte
+	chunk := make([]byte, 4096)
+	for {
+		for {
+			end := bytes.Index(buf, []byte("\r\n\r\n"))
+			if end < 0 {
+				break
+			}
+			if done, err := accept(buf[:end+4]); done || err != nil {
+				return err
+			}
+			buf = buf[end+4:]
+		}
+		if len(buf) > sipMaxHeaderBytes {
+			return errSIPHeadersTooLong
+		}
+		n, err := c.Read(chunk)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, chunk[:n]...)
+	}
+}
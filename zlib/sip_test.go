@@ -0,0 +1,96 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var sipBranch = regexp.MustCompile(`branch=(z9hG4bK[0-9a-f]+)`)
+
+// sipResponse answers request with a response to its branch
+func sipResponse(request []byte, status, userAgent string) string {
+	branch := sipBranch.FindSubmatch(request)[1]
+	return "SIP/2.0 " + status + "\r\nVia: SIP/2.0/UDP 127.0.0.1;branch=" + string(branch) +
+		"\r\nUser-Agent: " + userAgent + "\r\nAllow: INVITE, OPTIONS\r\nContent-Length: 0\r\n\r\n"
+}
+
+func TestSIPOptionsRetransmits(t *testing.T) {
+	seen := 0
+	addr, stop := udpServer(t, func(q []byte) [][]byte {
+		// Drop the first request, then answer a stranger before us
+		if seen++; seen == 1 {
+			return nil
+		}
+		stranger := strings.Replace(sipResponse(q, "200 OK", "other"), "z9hG4bK", "z9hG4bKx", 1)
+		return [][]byte{[]byte(stranger), []byte(sipResponse(q, "100 Trying", "")), []byte(sipResponse(q, "200 OK", "Asterisk PBX 18.9.0"))}
+	})
+	defer stop()
+
+	c := dialUDP(t, addr, 3*time.Second)
+	defer c.Close()
+	if err := c.SIPOptions(false); err != nil {
+		t.Fatalf("SIPOptions: %s", err.Error())
+	}
+	log := c.grabData.SIP
+	if log.Transport != "UDP" || log.Retransmits != 1 || !log.Provisional {
+		t.Errorf("Wrong log: %+v", log)
+	}
+	if log.Response == nil || log.Response.StatusCode != 200 || log.Response.UserAgent != "Asterisk PBX 18.9.0" || len(log.Response.Allow) != 2 {
+		t.Errorf("Wrong response: %+v", log.Response)
+	}
+}
+
+func TestSIPOptionsStopsAtDeadline(t *testing.T) {
+	addr, stop := udpServer(t, func(q []byte) [][]byte { return nil })
+	defer stop()
+
+	// Sends at 0, 500ms and 1.5s, the wait after the last cut short
+	c := dialUDP(t, addr, 2*time.Second)
+	defer c.Close()
+	start := time.Now()
+	if err := c.SIPOptions(false); err == nil {
+		t.Fatalf("SIPOptions succeeded without a response")
+	}
+	if c.grabData.SIP.Retransmits != 2 || time.Since(start) > 2500*time.Millisecond {
+		t.Errorf("Wrong retransmissions %d after %s", c.grabData.SIP.Retransmits, time.Since(start))
+	}
+}
+
+func TestSIPOptionsTCP(t *testing.T) {
+	c, server := pipeConn()
+	defer c.Close()
+	defer server.Close()
+	go func() {
+		request := make([]byte, 1024)
+		n, _ := server.Read(request)
+		server.Write([]byte(sipResponse(request[:n], "100 Trying", "") + sipResponse(request[:n], "405 Method Not Allowed", "")[:20]))
+		server.Write([]byte(sipResponse(request[:n], "405 Method Not Allowed", "Cisco-SIPGateway/IOS-15.x")[20:]))
+	}()
+
+	if err := c.SIPOptions(true); err != nil {
+		t.Fatalf("SIPOptions: %s", err.Error())
+	}
+	log := c.grabData.SIP
+	if log.Transport != "TCP" || !log.Provisional || log.Response == nil || log.Response.StatusCode != 405 {
+		t.Fatalf("Wrong log: %+v", log)
+	}
+	if log.Response.UserAgent != "Cisco-SIPGateway/IOS-15.x" {
+		t.Errorf("Wrong User-Agent %q", log.Response.UserAgent)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/snmp"
+)
+
+// Outcomes of an SNMP community attempt besides the names of the error
+// statuses, e.g. noSuchName or authorizationError
+const (
+	SNMPOutcomeSuccess    = "success"
+	SNMPOutcomeNoResponse = "no_response"
+)
+
+// An SNMPAttempt records the reply to a GetRequest with one community.
+// Agents usually ignore requests with an unknown community, so most
+// failures are no_response.
+type SNMPAttempt struct {
+	Community  string `json:"community"`
+	Outcome    string `json:"outcome"`
+	ErrorIndex int    `json:"error_index,omitempty"`
+}
+
+// An SNMPLog records the community strings tried, in order, and the system
+// values read with the first one that worked
+type SNMPLog struct {
+	Version     string         `json:"version"`
+	Attempts    []*SNMPAttempt `json:"attempts"`
+	Community   string         `json:"community,omitempty"`
+	SysDescr    string         `json:"sys_descr,omitempty"`
+	SysObjectID string         `json:"sys_object_id,omitempty"`
+	SysName     string         `json:"sys_name,omitempty"`
+}
+
+var errSNMPNoCommunity = errors.New("snmp: no community string was accepted")
+
+var snmpVersionNames = map[int]string{
+	snmp.Version1:  "v1",
+	snmp.Version2c: "v2c",
+}
+
+// SNMPQuery sends a GetRequest for the system group with each community in
+// turn on a UDP connection, until one is answered without error. The time
+// left before the read deadline is shared among the remaining communities.
+func (c *Conn) SNMPQuery(version int, communities []string) error {
+	log := &SNMPLog{Version: snmpVersionNames[version]}
+	c.grabData.SNMP = log
+	deadline := c.readDeadline
+	defer c.SetReadDeadline(deadline)
+	for i, community := range communities {
+		if !deadline.IsZero() {
+			left := deadline.Sub(time.Now()) / time.Duration(len(communities)-i)
+			c.getUnderlyingConn().SetReadDeadline(time.Now().Add(left))
+		}
+		attempt := &SNMPAttempt{Community: community}
+		log.Attempts = append(log.Attempts, attempt)
+		res, err := c.snmpGet(version, community)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				attempt.Outcome = SNMPOutcomeNoResponse
+				continue
+			}
+			return err
+		}
+		if res.ErrorStatus != 0 {
+			attempt.Outcome = snmp.ErrorStatusName(res.ErrorStatus)
+			attempt.ErrorIndex = res.ErrorIndex
+			continue
+		}
+		attempt.Outcome = SNMPOutcomeSuccess
+		log.Community = community
+		log.SysDescr, _ = res.Value(snmp.OIDSysDescr)
+		log.SysObjectID, _ = res.Value(snmp.OIDSysObjectID)
+		log.SysName, _ = res.Value(snmp.OIDSysName)
+		return nil
+	}
+	return errSNMPNoCommunity
+}
+
+// snmpGet sends one GetRequest and waits for the response carrying its
+// request ID, ignoring any other datagram
+func (c *Conn) snmpGet(version int, community string) (*snmp.Response, error) {
+	var id [4]byte
+	rand.Read(id[:])
+	requestID := int32(binary.BigEndian.Uint32(id[:]) >> 1)
+	if _, err := c.Write(snmp.GetRequest(version, community, requestID)); err != nil {
+		return nil, err
+	}
+	var res *snmp.Response
+	err := c.readDatagrams(func(b []byte) (bool, error) {
+		r, err := snmp.ParseResponse(b)
+		if err != nil || r.RequestID != requestID {
+			return false, nil
+		}
+		res = r
+		return true, nil
+	})
+	return res, err
+}
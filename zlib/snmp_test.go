@@ -0,0 +1,75 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/snmp"
+)
+
+// snmpAgent echoes each GetRequest back as a GetResponse with the given
+// error status per community, ignoring communities it does not know
+func snmpAgent(t *testing.T, statuses map[string]byte) (string, func()) {
+	return udpServer(t, func(q []byte) [][]byte {
+		// sequence, version and community headers, all short form
+		community := string(q[7 : 7+int(q[6])])
+		status, ok := statuses[community]
+		if !ok {
+			return nil
+		}
+		r := append([]byte(nil), q...)
+		pdu := 7 + len(community)
+		r[pdu] = 0xa2
+		r[pdu+4+int(r[pdu+3])+2] = status
+		return [][]byte{r}
+	})
+}
+
+func TestSNMPQueryCommunities(t *testing.T) {
+	addr, stop := snmpAgent(t, map[string]byte{"restricted": 16, "private": 0})
+	defer stop()
+
+	c := dialUDP(t, addr, time.Second)
+	defer c.Close()
+	if err := c.SNMPQuery(snmp.Version2c, []string{"public", "restricted", "private", "unused"}); err != nil {
+		t.Fatalf("SNMPQuery: %s", err.Error())
+	}
+	log := c.grabData.SNMP
+	if log.Version != "v2c" || log.Community != "private" || len(log.Attempts) != 3 {
+		t.Fatalf("Wrong log: %+v", log)
+	}
+	outcomes := []string{SNMPOutcomeNoResponse, "authorizationError", SNMPOutcomeSuccess}
+	for i, attempt := range log.Attempts {
+		if attempt.Outcome != outcomes[i] {
+			t.Errorf("Wrong outcome for %s: %s", attempt.Community, attempt.Outcome)
+		}
+	}
+}
+
+func TestSNMPQueryNoCommunity(t *testing.T) {
+	addr, stop := snmpAgent(t, map[string]byte{"public": 2})
+	defer stop()
+
+	c := dialUDP(t, addr, 500*time.Millisecond)
+	defer c.Close()
+	if err := c.SNMPQuery(snmp.Version1, []string{"public"}); err != errSNMPNoCommunity {
+		t.Fatalf("Expected errSNMPNoCommunity, got %v", err)
+	}
+	if attempts := c.grabData.SNMP.Attempts; len(attempts) != 1 || attempts[0].Outcome != "noSuchName" {
+		t.Errorf("Wrong attempts: %+v", attempts)
+	}
+}
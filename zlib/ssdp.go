@@ -0,0 +1,207 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// An SSDPResponse is one reply to an M-SEARCH. A LOCATION on an RFC 1918
+// address other than the target's, or on a host name that resolves to one,
+// is flagged as private and never fetched, since it names a host behind
+// the target's NAT.
+type SSDPResponse struct {
+	Location        string `json:"location,omitempty"`
+	Server          string `json:"server,omitempty"`
+	ST              string `json:"st,omitempty"`
+	USN             string `json:"usn,omitempty"`
+	PrivateLocation bool   `json:"private_location,omitempty"`
+	OffHostLocation bool   `json:"off_host_location,omitempty"`
+}
+
+// A UPnPDevice is the root device of a UPnP device description
+type UPnPDevice struct {
+	DeviceType   string `xml:"deviceType" json:"device_type,omitempty"`
+	FriendlyName string `xml:"friendlyName" json:"friendly_name,omitempty"`
+	Manufacturer string `xml:"manufacturer" json:"manufacturer,omitempty"`
+	ModelName    string `xml:"modelName" json:"model_name,omitempty"`
+	ModelNumber  string `xml:"modelNumber" json:"model_number,omitempty"`
+}
+
+// An SSDPLog records the replies to a unicast M-SEARCH and, with
+// --ssdp-fetch, the device description read from the first usable
+// LOCATION. The HTTP exchange itself is in the http output.
+type SSDPLog struct {
+	Responses      []*SSDPResponse `json:"responses"`
+	DescriptionURL string          `json:"description_url,omitempty"`
+	Device         *UPnPDevice     `json:"device,omitempty"`
+}
+
+var errSSDPNoDescription = errors.New("ssdp: no device description in the response")
+
+// ssdpPrivateNets are the RFC 1918 ranges
+var ssdpPrivateNets = []*net.IPNet{
+	{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(172, 16, 0, 0), Mask: net.CIDRMask(12, 32)},
+	{IP: net.IPv4(192, 168, 0, 0), Mask: net.CIDRMask(16, 32)},
+}
+
+// ssdpLookupIP resolves a LOCATION host name
+var ssdpLookupIP = net.DefaultResolver.LookupIP
+
+func isRFC1918(ip net.IP) bool {
+	for _, n := range ssdpPrivateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// locationIPs returns the addresses a LOCATION host names: the host itself
+// for an IP literal, otherwise whatever it resolves to before the deadline
+func (c *Conn) locationIPs(host string) []net.IP {
+	if ip := hostIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+	ctx := context.Background()
+	if !c.readDeadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.readDeadline)
+		defer cancel()
+	}
+	ips, _ := ssdpLookupIP(ctx, "ip", host)
+	return ips
+}
+
+// parseSSDPResponse reads the headers of an HTTP over UDP reply, RFC 2616
+// style but without a body
+func parseSSDPResponse(b []byte) (*SSDPResponse, bool) {
+	lines := strings.Split(string(b), "\r\n")
+	if !strings.HasPrefix(lines[0], "HTTP/1.") {
+		return nil, false
+	}
+	res := new(SSDPResponse)
+	for _, line := range lines[1:] {
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		value := strings.TrimSpace(line[colon+1:])
+		switch strings.ToUpper(strings.TrimSpace(line[:colon])) {
+		case "LOCATION":
+			res.Location = value
+		case "SERVER":
+			res.Server = value
+		case "ST":
+			res.ST = value
+		case "USN":
+			res.USN = value
+		}
+	}
+	return res, true
+}
+
+// SSDPSearch sends an M-SEARCH for all devices to the target and records
+// up to maxResponses replies until the read deadline. It fails only when
+// nothing answers.
+func (c *Conn) SSDPSearch(maxResponses int) error {
+	log := &SSDPLog{Responses: []*SSDPResponse{}}
+	c.grabData.SSDP = log
+	remote := c.RemoteAddr().String()
+	request := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + remote + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"ST: ssdp:all\r\n\r\n"
+	if _, err := c.Write([]byte(request)); err != nil {
+		return err
+	}
+	target, _, _ := net.SplitHostPort(remote)
+	targetIP := net.ParseIP(target)
+	err := c.readDatagrams(func(b []byte) (bool, error) {
+		res, ok := parseSSDPResponse(b)
+		if !ok {
+			return false, nil
+		}
+		if u, err := url.Parse(res.Location); err == nil && res.Location != "" {
+			ip := hostIP(u.Hostname())
+			if ip == nil || !ip.Equal(targetIP) {
+				res.OffHostLocation = true
+				for _, ip := range c.locationIPs(u.Hostname()) {
+					res.PrivateLocation = res.PrivateLocation || isRFC1918(ip)
+				}
+			}
+		}
+		log.Responses = append(log.Responses, res)
+		return len(log.Responses) >= maxResponses, nil
+	})
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && len(log.Responses) > 0 {
+		return nil
+	}
+	return err
+}
+
+// ssdpDescriptionURL returns the first LOCATION that may be fetched: an
+// http URL on the target or, with anyHost, on any public address
+func (log *SSDPLog) ssdpDescriptionURL(anyHost bool) *url.URL {
+	for _, res := range log.Responses {
+		if res.Location == "" || res.PrivateLocation || (res.OffHostLocation && !anyHost) {
+			continue
+		}
+		if u, err := url.Parse(res.Location); err == nil && u.Scheme == "http" && u.Host != "" {
+			return u
+		}
+	}
+	return nil
+}
+
+// fetchSSDPDescription GETs the device description with the HTTP grabber,
+// so the request and response land in the http output as for --http, and
+// parses the root device out of it
+func fetchSSDPDescription(config *Config, c *Conn, anyHost bool) error {
+	log := c.grabData.SSDP
+	u := log.ssdpDescriptionURL(anyHost)
+	if u == nil {
+		return nil
+	}
+	log.DescriptionURL = u.String()
+	fetchConfig := *config
+	fetchConfig.TLS = false
+	fetchConfig.HTTP.Method = "GET"
+	fetchConfig.HTTP.MaxRedirects = 0
+	c.grabData.HTTP = new(HTTP)
+	if err := makeHTTPGrabber(&fetchConfig, &c.grabData)(u.Host, u.RequestURI(), ""); err != nil {
+		return err
+	}
+	if c.grabData.HTTP.Response == nil {
+		return errSSDPNoDescription
+	}
+	var description struct {
+		Device *UPnPDevice `xml:"device"`
+	}
+	if err := xml.Unmarshal([]byte(c.grabData.HTTP.Response.BodyText), &description); err != nil {
+		return err
+	}
+	if description.Device == nil {
+		return errSSDPNoDescription
+	}
+	log.Device = description.Device
+	return nil
+}
@@ -0,0 +1,147 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/http"
+	"gopkg.in/eniac/zgrab.v0/ztools/http/httptest"
+	"gopkg.in/eniac/zgrab.v0/ztools/zlog"
+)
+
+const testDeviceDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <friendlyName>Home Router</friendlyName>
+    <manufacturer>ACME</manufacturer>
+    <modelName>RT-1000</modelName>
+  </device>
+</root>`
+
+func ssdpReply(location, st string) []byte {
+	return []byte("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nLOCATION: " + location +
+		"\r\nSERVER: Linux/3.14 UPnP/1.0 miniupnpd/2.1\r\nST: " + st + "\r\nUSN: uuid:1234::" + st + "\r\n\r\n")
+}
+
+func TestSSDPSearchAndFetch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rootDesc.xml" {
+			t.Errorf("Wrong path %s", r.URL.Path)
+		}
+		fmt.Fprint(w, testDeviceDescription)
+	}))
+	defer ts.Close()
+
+	// The handler runs on the server's goroutine
+	requests := make(chan string, 1)
+	addr, stop := udpServer(t, func(q []byte) [][]byte {
+		select {
+		case requests <- string(q):
+		default:
+		}
+		return [][]byte{
+			[]byte("NOTIFY * HTTP/1.1\r\n\r\n"),
+			ssdpReply("http://192.168.1.5:5000/rootDesc.xml", "upnp:rootdevice"),
+			ssdpReply(ts.URL+"/rootDesc.xml", "urn:schemas-upnp-org:device:InternetGatewayDevice:1"),
+		}
+	})
+	defer stop()
+
+	c := dialUDP(t, addr, 500*time.Millisecond)
+	defer c.Close()
+	if err := c.SSDPSearch(2); err != nil {
+		t.Fatalf("SSDPSearch: %s", err.Error())
+	}
+	request := <-requests
+	if !strings.HasPrefix(request, "M-SEARCH * HTTP/1.1\r\nHOST: "+addr+"\r\n") || !strings.Contains(request, "ST: ssdp:all\r\n") {
+		t.Errorf("Wrong M-SEARCH:\n%s", request)
+	}
+	log := c.grabData.SSDP
+	if len(log.Responses) != 2 {
+		t.Fatalf("Wrong responses: %+v", log.Responses)
+	}
+	if !log.Responses[0].PrivateLocation || log.Responses[1].OffHostLocation || log.Responses[1].Server != "Linux/3.14 UPnP/1.0 miniupnpd/2.1" {
+		t.Errorf("Wrong location flags: %+v, %+v", log.Responses[0], log.Responses[1])
+	}
+
+	config := &Config{
+		Timeout:  time.Second,
+		HTTP:     HTTPConfig{Method: "HEAD", UserAgent: "test UA", MaxSize: 256},
+		ErrorLog: zlog.New(os.Stderr, "banner-grab"),
+	}
+	if err := fetchSSDPDescription(config, c, false); err != nil {
+		t.Fatalf("fetchSSDPDescription: %s", err.Error())
+	}
+	if log.DescriptionURL != ts.URL+"/rootDesc.xml" || c.grabData.HTTP.Response == nil {
+		t.Fatalf("Description not fetched from the target: %s", log.DescriptionURL)
+	}
+	if d := log.Device; d == nil || d.FriendlyName != "Home Router" || d.Manufacturer != "ACME" || d.ModelName != "RT-1000" {
+		t.Errorf("Wrong device: %+v", log.Device)
+	}
+}
+
+func TestSSDPDescriptionURL(t *testing.T) {
+	log := &SSDPLog{Responses: []*SSDPResponse{
+		{Location: "http://10.0.0.1/desc.xml", OffHostLocation: true, PrivateLocation: true},
+		{Location: "http://198.51.100.7/desc.xml", OffHostLocation: true},
+	}}
+	if u := log.ssdpDescriptionURL(false); u != nil {
+		t.Errorf("Followed an off-host location: %s", u)
+	}
+	if u := log.ssdpDescriptionURL(true); u == nil || u.Host != "198.51.100.7" {
+		t.Errorf("Wrong location with any host: %v", u)
+	}
+}
+
+func TestSSDPPrivateLocationHostName(t *testing.T) {
+	defer func(lookup func(context.Context, string, string) ([]net.IP, error)) { ssdpLookupIP = lookup }(ssdpLookupIP)
+	ssdpLookupIP = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		if host == "router.example" {
+			return []net.IP{net.IPv4(198, 51, 100, 7), net.IPv4(192, 168, 1, 1)}, nil
+		}
+		return []net.IP{net.IPv4(198, 51, 100, 8)}, nil
+	}
+	addr, stop := udpServer(t, func(q []byte) [][]byte {
+		return [][]byte{
+			ssdpReply("http://router.example:5000/rootDesc.xml", "upnp:rootdevice"),
+			ssdpReply("http://public.example/rootDesc.xml", "upnp:rootdevice"),
+		}
+	})
+	defer stop()
+
+	c := dialUDP(t, addr, 500*time.Millisecond)
+	defer c.Close()
+	if err := c.SSDPSearch(2); err != nil {
+		t.Fatalf("SSDPSearch: %s", err.Error())
+	}
+	log := c.grabData.SSDP
+	if len(log.Responses) != 2 {
+		t.Fatalf("Wrong responses: %+v", log.Responses)
+	}
+	if !log.Responses[0].PrivateLocation || log.Responses[1].PrivateLocation || !log.Responses[1].OffHostLocation {
+		t.Errorf("Wrong location flags: %+v, %+v", log.Responses[0], log.Responses[1])
+	}
+	if u := log.ssdpDescriptionURL(true); u == nil || u.Host != "public.example" {
+		t.Errorf("Wrong location with any host: %v", u)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// countingConn counts the bytes that cross the socket, so TLS record
+// overhead is included when a TLS client is layered on top. It also keeps
+// the error of the last read or write, cleared by a later one that succeeds,
+// from which recordClose tells how the connection ended. Reads and writes
+// may run concurrently, so the counters are updated atomically and the error
+// is kept under errMu.
+type countingConn struct {
+	net.Conn
+	read    int64 // accessed atomically
+	written int64 // accessed atomically
+
+	errMu sync.Mutex
+	err   error
+}
+
+func (cc *countingConn) Read(b []byte) (int, error) {
+	n, err := cc.Conn.Read(b)
+	atomic.AddInt64(&cc.read, int64(n))
+	cc.note(n, err)
+	return n, err
+}
+
+func (cc *countingConn) Write(b []byte) (int, error) {
+	n, err := cc.Conn.Write(b)
+	atomic.AddInt64(&cc.written, int64(n))
+	cc.note(n, err)
+	return n, err
+}
+
+func (cc *countingConn) note(n int, err error) {
+	cc.errMu.Lock()
+	defer cc.errMu.Unlock()
+	if err != nil {
+		cc.err = err
+	} else if n > 0 {
+		cc.err = nil
+	}
+}
+
+// lastErr returns the error of the last read or write
+func (cc *countingConn) lastErr() error {
+	cc.errMu.Lock()
+	defer cc.errMu.Unlock()
+	return cc.err
+}
+
+// A ConnectionSummary condenses a connection into the figures most readers
+// of the output want without walking the operations
+type ConnectionSummary struct {
+	Success      bool    `json:"success"`
+	LocalAddr    string  `json:"local_addr,omitempty"`
+	RemoteAddr   string  `json:"remote_addr,omitempty"`
+	ConnectMs    float64 `json:"connect_ms"`
+	DurationMs   float64 `json:"duration_ms"`
+	BytesRead    int64   `json:"bytes_read"`
+	BytesWritten int64   `json:"bytes_written"`
+}
+
+// Summary reports the addresses used, how long connecting and the whole
+// conversation took, and the bytes read and written on the socket. Success
+// is set when the connection was established and no step of the grab
+// failed.
+func (c *Conn) Summary() *ConnectionSummary {
+	s := new(ConnectionSummary)
+	if connect := c.grabData.Connect; connect != nil {
+		s.Success = connect.Success && c.erroredComponent == ""
+		s.LocalAddr = connect.LocalAddr
+		s.RemoteAddr = connect.RemoteAddr
+		s.ConnectMs = connect.DurationMs
+	}
+	c.opsMu.Lock()
+	if c.nextSeq > 0 {
+		// Measured from the first operation, even if it has been drained
+		s.DurationMs = float64(time.Since(c.firstOp)) / float64(time.Millisecond)
+	}
+	c.opsMu.Unlock()
+	if c.counter != nil {
+		s.BytesRead = atomic.LoadInt64(&c.counter.read)
+		s.BytesWritten = atomic.LoadInt64(&c.counter.written)
+	}
+	return s
+}
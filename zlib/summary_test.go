@@ -0,0 +1,90 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/http"
+	"gopkg.in/eniac/zgrab.v0/ztools/http/httptest"
+)
+
+func TestSummaryOfBannerGrab(t *testing.T) {
+	addr, stop := bannerServer(t, "220 ready\r\n")
+	defer stop()
+	config, target := retryConfig(addr)
+
+	grab := GrabBanner(config, target)
+	s := grab.Data.Summary
+	if s == nil || !s.Success {
+		t.Fatalf("Wrong summary: %+v (error %v)", s, grab.Error)
+	}
+	if s.RemoteAddr != addr || s.LocalAddr == "" {
+		t.Errorf("Wrong addresses: local %q, remote %q", s.LocalAddr, s.RemoteAddr)
+	}
+	if s.BytesRead != int64(len("220 ready\r\n")) || s.BytesWritten != 0 {
+		t.Errorf("Wrong byte counts: read %d, written %d", s.BytesRead, s.BytesWritten)
+	}
+	if s.DurationMs < s.ConnectMs {
+		t.Errorf("Duration %fms shorter than connect %fms", s.DurationMs, s.ConnectMs)
+	}
+
+	stop()
+	grab = GrabBanner(config, target)
+	if s := grab.Data.Summary; s == nil || s.Success || s.BytesRead != 0 {
+		t.Errorf("Wrong summary for refused connection: %+v", s)
+	}
+}
+
+func TestSummaryCountsTLSRecords(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	c := dialTLSTestServer(t, s)
+	defer c.Close()
+	if err := c.TLSHandshake(); err != nil {
+		t.Fatalf("TLSHandshake: %s", err.Error())
+	}
+	summary := c.Summary()
+	// The server's certificate alone is several hundred bytes
+	if summary.BytesRead < 500 || summary.BytesWritten == 0 {
+		t.Errorf("Handshake bytes not counted: read %d, written %d", summary.BytesRead, summary.BytesWritten)
+	}
+}
+
+// Summary may be taken while another goroutine is still using the socket
+func TestSummaryDuringIO(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(ioutil.Discard, server)
+	c := &Conn{counter: &countingConn{Conn: client}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.counter.Write([]byte("ping"))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		c.Summary()
+	}
+	<-done
+	if s := c.Summary(); s.BytesWritten != 400 {
+		t.Errorf("Wrong bytes written: %d", s.BytesWritten)
+	}
+}
@@ -0,0 +1,278 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// DefaultMinIPv6Prefix is the largest IPv6 block a TargetReader expands
+// unless configured otherwise, 65536 addresses
+const DefaultMinIPv6Prefix = 112
+
+// TargetOptions configures a TargetReader
+type TargetOptions struct {
+	// Leave out the first and last address of IPv4 blocks of /30 or larger
+	SkipNetworkBroadcast bool
+
+	// IPv6 blocks shorter than this prefix are rejected, as they could
+	// never be scanned. Zero means DefaultMinIPv6Prefix.
+	MinIPv6Prefix int
+
+	// Addresses on the list are left out, nil to keep all
+	Exclude *ExcludeList
+}
+
+// A TargetLineError reports a malformed line of the input. Reading resumes
+// with the next line.
+type TargetLineError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *TargetLineError) Error() string {
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Text, e.Err.Error())
+}
+
+// A TargetReader decodes targets one per line, expanding IPv4 and IPv6
+// CIDR blocks and first-last address ranges as they are read, so a large
+// block is never held in memory. Each line is an entry, optionally followed
+// by a comma and a domain:
+//
+//	192.0.2.1
+//	192.0.2.0/24
+//	192.0.2.1-192.0.2.50
+//	2001:db8::/120
+//	example.com
+//
+// An entry may end in :port to override the configured port for every
+// target it yields, with IPv6 entries bracketed as in [2001:db8::/120]:443.
+// Blank lines and lines starting with # are skipped.
+type TargetReader struct {
+	reader *bufio.Reader
+	opts   TargetOptions
+	line   int
+
+	// The entry being expanded, nil between lines
+	current *targetRange
+}
+
+// NewTargetReader returns a reader of the targets listed in r
+func NewTargetReader(r io.Reader, opts TargetOptions) *TargetReader {
+	if opts.MinIPv6Prefix == 0 {
+		opts.MinIPv6Prefix = DefaultMinIPv6Prefix
+	}
+	return &TargetReader{reader: bufio.NewReader(r), opts: opts}
+}
+
+// DecodeNext returns the next GrabTarget, a *TargetLineError for a
+// malformed line or io.EOF at the end of the input
+func (tr *TargetReader) DecodeNext() (interface{}, error) {
+	for {
+		if tr.current != nil {
+			target, ok := tr.current.next()
+			if !ok {
+				tr.current = nil
+				continue
+			}
+			if tr.opts.Exclude != nil && target.Addr != nil && tr.opts.Exclude.Check(target.Addr) != nil {
+				continue
+			}
+			return target, nil
+		}
+		line, err := tr.reader.ReadString('\n')
+		if line == "" && err != nil {
+			return nil, err
+		}
+		tr.line++
+		text := strings.TrimSpace(line)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if tr.current, err = parseTargetLine(text, &tr.opts); err != nil {
+			return nil, &TargetLineError{Line: tr.line, Text: text, Err: err}
+		}
+	}
+}
+
+// targetRange yields the addresses from cur to last inclusive, or a single
+// domain
+type targetRange struct {
+	cur, last net.IP
+	zone      string
+	domain    string
+	port      uint16
+	done      bool
+}
+
+func (r *targetRange) next() (GrabTarget, bool) {
+	if r.done {
+		return GrabTarget{}, false
+	}
+	target := GrabTarget{Domain: r.domain, Zone: r.zone, Port: r.port}
+	if r.cur == nil {
+		r.done = true
+		return target, true
+	}
+	target.Addr = append(net.IP(nil), r.cur...)
+	if bytes.Equal(r.cur, r.last) {
+		r.done = true
+	} else {
+		incrementIP(r.cur)
+	}
+	return target, true
+}
+
+// incrementIP adds one to ip in place
+func incrementIP(ip net.IP) {
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			return
+		}
+	}
+}
+
+// parseTargetLine parses an entry and its optional domain
+func parseTargetLine(text string, opts *TargetOptions) (*targetRange, error) {
+	entry, domain := text, ""
+	if i := strings.Index(text, ","); i >= 0 {
+		entry, domain = strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:])
+	}
+	host, port, err := splitTargetPort(entry)
+	if err != nil {
+		return nil, err
+	}
+	r := &targetRange{domain: domain, port: port}
+
+	switch {
+	case strings.Contains(host, "/"):
+		_, block, err := net.ParseCIDR(host)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CIDR block %s", host)
+		}
+		ones, bits := block.Mask.Size()
+		if bits == 128 && ones < opts.MinIPv6Prefix {
+			return nil, fmt.Errorf("IPv6 block larger than /%d", opts.MinIPv6Prefix)
+		}
+		r.cur = append(net.IP(nil), block.IP...)
+		r.last = make(net.IP, len(block.IP))
+		for i := range block.IP {
+			r.last[i] = block.IP[i] | ^block.Mask[i]
+		}
+		if opts.SkipNetworkBroadcast && bits == 32 && ones <= 30 {
+			incrementIP(r.cur)
+			decrementIP(r.last)
+		}
+	case isAddressRange(host):
+		i := strings.Index(host, "-")
+		first, last := net.ParseIP(host[:i]), net.ParseIP(host[i+1:])
+		if (first.To4() == nil) != (last.To4() == nil) {
+			return nil, fmt.Errorf("Range %s mixes IPv4 and IPv6", host)
+		}
+		if first.To4() != nil {
+			first, last = first.To4(), last.To4()
+		}
+		if bytes.Compare(first, last) > 0 {
+			return nil, fmt.Errorf("Range %s ends before it starts", host)
+		}
+		r.cur, r.last = first, last
+	case isIPLiteral(host):
+		ip, zone, _, err := parseTargetAddress(host)
+		if err != nil {
+			return nil, err
+		}
+		if ip.To4() != nil {
+			ip = ip.To4()
+		}
+		r.cur, r.last, r.zone = ip, ip, zone
+	default:
+		if !isHostName(host) {
+			return nil, fmt.Errorf("Invalid address or domain %s", host)
+		}
+		if domain != "" {
+			return nil, fmt.Errorf("Domain %s given a second domain", host)
+		}
+		r.domain = host
+	}
+	return r, nil
+}
+
+func decrementIP(ip net.IP) {
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]--
+		if ip[i] != 0xff {
+			return
+		}
+	}
+}
+
+// splitTargetPort splits an entry into its host and optional port. IPv6
+// entries need brackets to carry a port.
+func splitTargetPort(entry string) (string, uint16, error) {
+	if strings.HasPrefix(entry, "[") {
+		end := strings.Index(entry, "]")
+		if end < 0 {
+			return "", 0, fmt.Errorf("Missing ]")
+		}
+		host, rest := entry[1:end], entry[end+1:]
+		if rest == "" {
+			return host, 0, nil
+		}
+		if !strings.HasPrefix(rest, ":") {
+			return "", 0, fmt.Errorf("Unexpected %s after ]", rest)
+		}
+		port, err := parseTargetPort(rest[1:])
+		return host, port, err
+	}
+	if strings.Count(entry, ":") != 1 {
+		return entry, 0, nil
+	}
+	i := strings.Index(entry, ":")
+	port, err := parseTargetPort(entry[i+1:])
+	return entry[:i], port, err
+}
+
+// isAddressRange reports whether s is two IP addresses joined by a dash
+func isAddressRange(s string) bool {
+	i := strings.Index(s, "-")
+	return i > 0 && net.ParseIP(s[:i]) != nil && net.ParseIP(s[i+1:]) != nil
+}
+
+// isHostName reports whether s looks like a DNS name
+func isHostName(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
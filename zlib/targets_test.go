@@ -0,0 +1,139 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// readTargets decodes all of input, returning the targets as ip/domain:port
+// strings and the lines of the errors
+func readTargets(t *testing.T, input string, opts TargetOptions) ([]string, []int) {
+	tr := NewTargetReader(strings.NewReader(input), opts)
+	var targets []string
+	var errLines []int
+	for {
+		v, err := tr.DecodeNext()
+		if err == io.EOF {
+			return targets, errLines
+		}
+		if err != nil {
+			lineErr, ok := err.(*TargetLineError)
+			if !ok {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			errLines = append(errLines, lineErr.Line)
+			continue
+		}
+		target := v.(GrabTarget)
+		s := target.hostPort(0)
+		if target.Addr != nil && target.Domain != "" {
+			s += "," + target.Domain
+		}
+		targets = append(targets, s)
+	}
+}
+
+func TestTargetReaderEntries(t *testing.T) {
+	input := `# comment
+192.0.2.1
+
+192.0.2.0/30:8443
+  192.0.2.10-192.0.2.12 , example.com
+example.com:25
+[2001:db8::1]:22
+2001:db8::fe-2001:db8::101
+[fe80::1%eth0]
+`
+	targets, errLines := readTargets(t, input, TargetOptions{})
+	expected := []string{
+		"192.0.2.1:0",
+		"192.0.2.0:8443", "192.0.2.1:8443", "192.0.2.2:8443", "192.0.2.3:8443",
+		"192.0.2.10:0,example.com", "192.0.2.11:0,example.com", "192.0.2.12:0,example.com",
+		"example.com:25",
+		"[2001:db8::1]:22",
+		"[2001:db8::fe]:0", "[2001:db8::ff]:0", "[2001:db8::100]:0", "[2001:db8::101]:0",
+		"[fe80::1%eth0]:0",
+	}
+	if strings.Join(targets, " ") != strings.Join(expected, " ") || len(errLines) != 0 {
+		t.Errorf("Wrong targets %v (errors on lines %v)", targets, errLines)
+	}
+}
+
+func TestTargetReaderMalformedLines(t *testing.T) {
+	input := "192.0.2.1\n192.0.2.0/33\n192.0.2.5-192.0.2.1\n192.0.2.1-2001:db8::1\nbad host!\n[2001:db8::1\n192.0.2.2:0\n192.0.2.3\n"
+	targets, errLines := readTargets(t, input, TargetOptions{})
+	if strings.Join(targets, " ") != "192.0.2.1:0 192.0.2.3:0" {
+		t.Errorf("Wrong targets %v", targets)
+	}
+	if len(errLines) != 6 || errLines[0] != 2 || errLines[5] != 7 {
+		t.Errorf("Errors reported on lines %v, expected 2 through 7", errLines)
+	}
+}
+
+func TestTargetReaderSkipNetworkBroadcast(t *testing.T) {
+	targets, _ := readTargets(t, "192.0.2.0/29\n192.0.2.8/31\n192.0.2.16/32\n", TargetOptions{SkipNetworkBroadcast: true})
+	expected := "192.0.2.1:0 192.0.2.2:0 192.0.2.3:0 192.0.2.4:0 192.0.2.5:0 192.0.2.6:0 192.0.2.8:0 192.0.2.9:0 192.0.2.16:0"
+	if strings.Join(targets, " ") != expected {
+		t.Errorf("Wrong targets %v", targets)
+	}
+}
+
+func TestTargetReaderIPv6Limits(t *testing.T) {
+	targets, errLines := readTargets(t, "2001:db8::/64\n2001:db8::/111\n2001:db8::/127\n2001:db8::/128\n", TargetOptions{})
+	if strings.Join(targets, " ") != "[2001:db8::]:0 [2001:db8::1]:0 [2001:db8::]:0" {
+		t.Errorf("Wrong targets %v", targets)
+	}
+	if len(errLines) != 2 || errLines[0] != 1 || errLines[1] != 2 {
+		t.Errorf("Oversized blocks not rejected: errors on lines %v", errLines)
+	}
+
+	tr := NewTargetReader(strings.NewReader("[2001:db8::/112]:443\n"), TargetOptions{})
+	n := 0
+	var last GrabTarget
+	for {
+		v, err := tr.DecodeNext()
+		if err != nil {
+			break
+		}
+		last = v.(GrabTarget)
+		n++
+	}
+	if n != 65536 || !last.Addr.Equal(net.ParseIP("2001:db8::ffff")) || last.Port != 443 {
+		t.Errorf("Expanded /112 to %d targets ending in %s:%d", n, last.Addr, last.Port)
+	}
+
+	// The very top of the address space does not wrap around
+	targets, _ = readTargets(t, "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/127\n255.255.255.254/31\n", TargetOptions{MinIPv6Prefix: 64})
+	if len(targets) != 4 {
+		t.Errorf("Wrong targets at the top of the address space %v", targets)
+	}
+}
+
+func TestTargetReaderLazyExpansion(t *testing.T) {
+	tr := NewTargetReader(strings.NewReader("10.0.0.0/8\n"), TargetOptions{})
+	for i := 0; i < 1000; i++ {
+		if _, err := tr.DecodeNext(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, _ := tr.DecodeNext()
+	if addr := v.(GrabTarget).Addr; !addr.Equal(net.ParseIP("10.0.3.232")) {
+		t.Errorf("Wrong 1001st address %s", addr)
+	}
+}
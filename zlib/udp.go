@@ -0,0 +1,180 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+	"syscall"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/dns"
+	"gopkg.in/eniac/zgrab.v0/ztools/ntp"
+)
+
+// A UDPProbe is a request sent in a single datagram and the parser for the
+// datagrams that come back. Adding a probe takes an entry in UDPProbes.
+type UDPProbe struct {
+	Name string
+	// Payload returns the request, once per grab
+	Payload func() []byte
+	// Parse decodes a response to request for the output, or is nil to
+	// record the raw datagrams only
+	Parse func(request, response []byte) (interface{}, error)
+}
+
+// UDPProbes are the probes --udp-probe selects from, by name
+var UDPProbes = map[string]*UDPProbe{
+	"dns-version": {
+		Name:    "dns-version",
+		Payload: dnsVersionPayload,
+		Parse:   parseDNSVersion,
+	},
+	"ntp": {
+		Name: "ntp",
+		Payload: func() []byte {
+			return ntp.ClientRequest(time.Now())
+		},
+		Parse: func(request, response []byte) (interface{}, error) {
+			res, err := ntp.ParseResponse(request, response)
+			if res == nil {
+				return nil, err
+			}
+			return res, err
+		},
+	},
+}
+
+// UDPProbeNames returns the names of the registered probes in order
+func UDPProbeNames() []string {
+	names := make([]string, 0, len(UDPProbes))
+	for name := range UDPProbes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func dnsVersionPayload() []byte {
+	var id [2]byte
+	rand.Read(id[:])
+	return dns.VersionQuery(binary.BigEndian.Uint16(id[:]))
+}
+
+// A DNSVersion is the reply to a version.bind query, the version strings
+// being in TXT
+type DNSVersion struct {
+	Rcode string   `json:"rcode"`
+	TXT   []string `json:"txt,omitempty"`
+}
+
+func parseDNSVersion(request, response []byte) (interface{}, error) {
+	if len(response) < 2 || response[0] != request[0] || response[1] != request[1] {
+		return nil, errors.New("dns: reply to a different query")
+	}
+	res, err := dns.ParseVersionResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	return &DNSVersion{Rcode: dns.RcodeName(res.Rcode), TXT: res.TXT}, nil
+}
+
+// Outcomes of a UDP probe
+const (
+	UDPOutcomeResponse        = "response"
+	UDPOutcomeNoResponse      = "no_response"
+	UDPOutcomePortUnreachable = "port_unreachable"
+)
+
+// A UDPResponse is one datagram received in reply to a probe
+type UDPResponse struct {
+	Data       []byte      `json:"data"`
+	Parsed     interface{} `json:"parsed,omitempty"`
+	ParseError string      `json:"parse_error,omitempty"`
+}
+
+// A UDPProbeLog records a UDP probe and the datagrams it drew
+type UDPProbeLog struct {
+	Probe     string         `json:"probe"`
+	Outcome   string         `json:"outcome"`
+	Responses []*UDPResponse `json:"responses,omitempty"`
+}
+
+// isPortUnreachable reports whether err is the ECONNREFUSED a connected UDP
+// socket returns after an ICMP port unreachable. Not every platform reports
+// it, in which case the probe ends without a response.
+func isPortUnreachable(err error) bool {
+	return socketErrno(err) == syscall.ECONNREFUSED
+}
+
+// UDPProbe sends the probe's payload on a UDP connection and reads up to
+// maxResponses datagrams until the read deadline, each recorded as a read.
+// It fails when nothing comes back, with the outcome telling an ICMP port
+// unreachable from silence.
+func (c *Conn) UDPProbe(p *UDPProbe, maxResponses int) error {
+	log := &UDPProbeLog{Probe: p.Name}
+	c.grabData.UDP = log
+	request := p.Payload()
+	if _, err := c.Write(request); err != nil {
+		if isPortUnreachable(err) {
+			log.Outcome = UDPOutcomePortUnreachable
+		}
+		return err
+	}
+	buf := make([]byte, 65535)
+	for len(log.Responses) < maxResponses {
+		n, err := c.Read(buf)
+		if err != nil {
+			if len(log.Responses) > 0 {
+				break
+			}
+			if isPortUnreachable(err) {
+				log.Outcome = UDPOutcomePortUnreachable
+			} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log.Outcome = UDPOutcomeNoResponse
+			}
+			return err
+		}
+		res := &UDPResponse{Data: append([]byte(nil), buf[:n]...)}
+		if p.Parse != nil {
+			parsed, err := p.Parse(request, res.Data)
+			if err != nil {
+				res.ParseError = err.Error()
+			}
+			res.Parsed = parsed
+		}
+		log.Responses = append(log.Responses, res)
+	}
+	log.Outcome = UDPOutcomeResponse
+	return nil
+}
+
+// readDatagrams reads datagrams, each recorded as a read, handing them to
+// accept until it returns true or an error, or the read deadline passes
+func (c *Conn) readDatagrams(accept func([]byte) (bool, error)) error {
+	buf := make([]byte, 65535)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			return err
+		}
+		if done, err := accept(buf[:n]); done || err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zlib
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/ntp"
+)
+
+// udpServer answers each datagram with the replies reply returns
+func udpServer(t *testing.T, reply func(request []byte) [][]byte) (string, func()) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := l.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			for _, b := range reply(append([]byte(nil), buf[:n]...)) {
+				l.WriteToUDP(b, addr)
+			}
+		}
+	}()
+	return l.LocalAddr().String(), func() { l.Close() }
+}
+
+func dialUDP(t *testing.T, addr string, timeout time.Duration) *Conn {
+	deadline := time.Now().Add(timeout)
+	d := Dialer{Deadline: deadline}
+	c, err := d.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetDeadline(deadline)
+	return c
+}
+
+func TestUDPProbeDNSVersion(t *testing.T) {
+	addr, stop := udpServer(t, func(q []byte) [][]byte {
+		// Echo the question with one CHAOS TXT answer pointing back at it
+		r := append([]byte(nil), q...)
+		r[2] |= 0x80
+		binary.BigEndian.PutUint16(r[6:], 1)
+		txt := "9.18.1"
+		r = append(r, 0xc0, 12, 0, 16, 0, 3, 0, 0, 0, 0, 0, byte(1+len(txt)), byte(len(txt)))
+		r = append(r, txt...)
+		stray := append([]byte{q[0] ^ 0xff, q[1]}, r[2:]...)
+		return [][]byte{r, stray}
+	})
+	defer stop()
+
+	c := dialUDP(t, addr, 500*time.Millisecond)
+	defer c.Close()
+	if err := c.UDPProbe(UDPProbes["dns-version"], 2); err != nil {
+		t.Fatalf("UDPProbe: %s", err.Error())
+	}
+	log := c.grabData.UDP
+	if log.Outcome != UDPOutcomeResponse || len(log.Responses) != 2 {
+		t.Fatalf("Wrong log: %+v", log)
+	}
+	v, ok := log.Responses[0].Parsed.(*DNSVersion)
+	if !ok || v.Rcode != "NOERROR" || len(v.TXT) != 1 || v.TXT[0] != "9.18.1" {
+		t.Errorf("Wrong version: %+v (%s)", log.Responses[0].Parsed, log.Responses[0].ParseError)
+	}
+	if log.Responses[1].Parsed != nil || log.Responses[1].ParseError == "" {
+		t.Errorf("Reply to another query parsed: %+v", log.Responses[1])
+	}
+	reads := 0
+	for _, op := range c.grabData.Operations {
+		if op.Type == OperationRead {
+			reads++
+		}
+	}
+	if reads != 2 {
+		t.Errorf("Expected a read per datagram, got %d", reads)
+	}
+}
+
+func TestUDPProbeNTP(t *testing.T) {
+	addr, stop := udpServer(t, func(q []byte) [][]byte {
+		r := make([]byte, 48)
+		r[0] = 4<<3 | ntp.ModeServer
+		r[1] = 1
+		copy(r[12:], "GPS")
+		copy(r[24:32], q[40:48])
+		copy(r[40:48], q[40:48])
+		return [][]byte{r}
+	})
+	defer stop()
+
+	c := dialUDP(t, addr, time.Second)
+	defer c.Close()
+	if err := c.UDPProbe(UDPProbes["ntp"], 1); err != nil {
+		t.Fatalf("UDPProbe: %s", err.Error())
+	}
+	res, ok := c.grabData.UDP.Responses[0].Parsed.(*ntp.Response)
+	if !ok || res.Stratum != 1 || res.ReferenceID != "GPS" || res.Version != 4 {
+		t.Fatalf("Wrong NTP response: %+v", c.grabData.UDP.Responses[0])
+	}
+	if time.Since(res.TransmitTime) > time.Minute {
+		t.Errorf("Wrong transmit time %s", res.TransmitTime)
+	}
+}
+
+func TestUDPProbeOutcomes(t *testing.T) {
+	addr, stop := udpServer(t, func(q []byte) [][]byte { return nil })
+	c := dialUDP(t, addr, 100*time.Millisecond)
+	if err := c.UDPProbe(UDPProbes["ntp"], 1); err == nil || c.grabData.UDP.Outcome != UDPOutcomeNoResponse {
+		t.Errorf("Wrong outcome for silent server: %v, %+v", err, c.grabData.UDP)
+	}
+	c.Close()
+
+	// Nothing listens on the port once the server is gone
+	stop()
+	c = dialUDP(t, addr, time.Second)
+	defer c.Close()
+	if err := c.UDPProbe(UDPProbes["dns-version"], 1); err == nil || c.grabData.UDP.Outcome != UDPOutcomePortUnreachable {
+		t.Errorf("Wrong outcome for closed port: %v, %+v", err, c.grabData.UDP)
+	}
+}
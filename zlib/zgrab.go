@@ -19,14 +19,28 @@ import (
 	"net"
 	"time"
 
+	"gopkg.in/eniac/zgrab.v0/ztools/amqp"
+	"gopkg.in/eniac/zgrab.v0/ztools/cassandra"
 	"gopkg.in/eniac/zgrab.v0/ztools/ftp"
+	"gopkg.in/eniac/zgrab.v0/ztools/kerberos"
+	"gopkg.in/eniac/zgrab.v0/ztools/ldap"
+	"gopkg.in/eniac/zgrab.v0/ztools/mongodb"
+	"gopkg.in/eniac/zgrab.v0/ztools/mqtt"
+	"gopkg.in/eniac/zgrab.v0/ztools/mysql"
+	"gopkg.in/eniac/zgrab.v0/ztools/postgres"
+	"gopkg.in/eniac/zgrab.v0/ztools/rdp"
 	"gopkg.in/eniac/zgrab.v0/ztools/scada/bacnet"
 	"gopkg.in/eniac/zgrab.v0/ztools/scada/dnp3"
 	"gopkg.in/eniac/zgrab.v0/ztools/scada/fox"
 	"gopkg.in/eniac/zgrab.v0/ztools/scada/siemens"
+	"gopkg.in/eniac/zgrab.v0/ztools/smb"
 	"gopkg.in/eniac/zgrab.v0/ztools/ssh"
+	"gopkg.in/eniac/zgrab.v0/ztools/sslv2"
 	"gopkg.in/eniac/zgrab.v0/ztools/telnet"
+	"gopkg.in/eniac/zgrab.v0/ztools/vnc"
+	"gopkg.in/eniac/zgrab.v0/ztools/xmpp"
 	"gopkg.in/eniac/zgrab.v0/ztools/xssh"
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
 	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
 )
 
@@ -45,28 +59,79 @@ type encodedGrab struct {
 	Time           string    `json:"timestamp"`
 	Data           *GrabData `json:"data,omitempty"`
 	Error          *string   `json:"error,omitempty"`
+	ErrorClass     string    `json:"error_class,omitempty"`
 	ErrorComponent string    `json:"error_component,omitempty"`
 }
 
 type GrabData struct {
-	Banner       string                `json:"banner,omitempty"`
-	Read         string                `json:"read,omitempty"`
-	Write        string                `json:"write,omitempty"`
-	EHLO         string                `json:"ehlo,omitempty"`
-	SMTPHelp     *SMTPHelpEvent        `json:"smtp_help,omitempty"`
-	StartTLS     string                `json:"starttls,omitempty"`
-	TLSHandshake *ztls.ServerHandshake `json:"tls,omitempty"`
-	HTTP         *HTTP                 `json:"http,omitempty"`
-	Heartbleed   *ztls.Heartbleed      `json:"heartbleed,omitempty"`
-	Modbus       *ModbusEvent          `json:"modbus,omitempty"`
-	SSH          *ssh.HandshakeLog     `json:"ssh,omitempty"`
-	XSSH         *xssh.HandshakeLog    `json:"xssh,omitempty"`
-	FTP          *ftp.FTPLog           `json:"ftp,omitempty"`
-	BACNet       *bacnet.Log           `json:"bacnet,omitempty"`
-	Fox          *fox.FoxLog           `json:"fox,omitempty"`
-	DNP3         *dnp3.DNP3Log         `json:"dnp3,omitempty"`
-	S7           *siemens.S7Log        `json:"s7,omitempty"`
-	Telnet       *telnet.TelnetLog     `json:"telnet,omitempty"`
+	DNS            *DNSState              `json:"dns,omitempty"`
+	Connect        *ConnectState          `json:"connect,omitempty"`
+	Summary        *ConnectionSummary     `json:"summary,omitempty"`
+	Proxy          *ProxyState            `json:"proxy,omitempty"`
+	Retries        *RetryLog              `json:"retries,omitempty"`
+	BudgetExceeded *BudgetExceededState   `json:"budget_exceeded,omitempty"`
+	Banner         string                 `json:"banner,omitempty"`
+	Read           string                 `json:"read,omitempty"`
+	Write          string                 `json:"write,omitempty"`
+	BannerProbe    *BannerProbeLog        `json:"banner_probe,omitempty"`
+	EHLO           string                 `json:"ehlo,omitempty"`
+	EHLOExtensions []*SMTPExtension       `json:"ehlo_extensions,omitempty"`
+	HELO           string                 `json:"helo,omitempty"`
+	SMTPGreeting   string                 `json:"smtp_greeting,omitempty"`
+	SMTPHelp       *SMTPHelpEvent         `json:"smtp_help,omitempty"`
+	SMTPAuth       *SMTPAuthLog           `json:"smtp_auth,omitempty"`
+	IMAPCapability *IMAPCapabilityLog     `json:"imap_capability,omitempty"`
+	POP3Capa       *POP3CapabilityLog     `json:"pop3_capa,omitempty"`
+	NNTP           *NNTPLog               `json:"nntp,omitempty"`
+	IRC            *IRCLog                `json:"irc,omitempty"`
+	SMTPVrfy       *SMTPProbeEvent        `json:"smtp_vrfy,omitempty"`
+	SMTPExpn       *SMTPProbeEvent        `json:"smtp_expn,omitempty"`
+	StartTLS       string                 `json:"starttls,omitempty"`
+	Quit           *QuitEvent             `json:"quit,omitempty"`
+	Drain          *DrainLog              `json:"drain,omitempty"`
+	TLSHandshake   *ztls.ServerHandshake  `json:"tls,omitempty"`
+	DTLS           *ztls.DTLSLog          `json:"dtls,omitempty"`
+	SSLv2          *sslv2.SSLv2Log        `json:"sslv2,omitempty"`
+	ExportCiphers  *ExportCipherLog       `json:"export_ciphers,omitempty"`
+	HTTP           *HTTP                  `json:"http,omitempty"`
+	Elasticsearch  *ElasticsearchLog      `json:"elasticsearch,omitempty"`
+	CouchDB        *CouchDBLog            `json:"couchdb,omitempty"`
+	Heartbleed     *ztls.Heartbleed       `json:"heartbleed,omitempty"`
+	Resumption     *ResumptionLog         `json:"resumption,omitempty"`
+	Renegotiation  *ztls.Renegotiation    `json:"renegotiation,omitempty"`
+	Curves         *CurveEnumerationLog   `json:"curves,omitempty"`
+	Modbus         *ModbusEvent           `json:"modbus,omitempty"`
+	Memcached      *MemcachedLog          `json:"memcached,omitempty"`
+	Redis          *RedisLog              `json:"redis,omitempty"`
+	SSH            *ssh.HandshakeLog      `json:"ssh,omitempty"`
+	XSSH           *xssh.HandshakeLog     `json:"xssh,omitempty"`
+	XSSHHostKeys   *HostKeyEnumerationLog `json:"xssh_host_keys,omitempty"`
+	FTP            *ftp.FTPLog            `json:"ftp,omitempty"`
+	BACNet         *bacnet.Log            `json:"bacnet,omitempty"`
+	UDP            *UDPProbeLog           `json:"udp,omitempty"`
+	NTP            *NTPLog                `json:"ntp,omitempty"`
+	DNSProbe       *DNSProbeLog           `json:"dns_probe,omitempty"`
+	SNMP           *SNMPLog               `json:"snmp,omitempty"`
+	SIP            *SIPLog                `json:"sip,omitempty"`
+	SSDP           *SSDPLog               `json:"ssdp,omitempty"`
+	Fox            *fox.FoxLog            `json:"fox,omitempty"`
+	DNP3           *dnp3.DNP3Log          `json:"dnp3,omitempty"`
+	S7             *siemens.S7Log         `json:"s7,omitempty"`
+	Telnet         *telnet.TelnetLog      `json:"telnet,omitempty"`
+	XMPP           *xmpp.XMPPLog          `json:"xmpp,omitempty"`
+	LDAP           *ldap.LDAPLog          `json:"ldap,omitempty"`
+	Kerberos       *kerberos.KerberosLog  `json:"kerberos,omitempty"`
+	Postgres       *postgres.PostgresLog  `json:"postgres,omitempty"`
+	Cassandra      *cassandra.Log         `json:"cassandra,omitempty"`
+	AMQP           *amqp.AMQPLog          `json:"amqp,omitempty"`
+	MQTT           *mqtt.MQTTLog          `json:"mqtt,omitempty"`
+	MySQL          *mysql.MySQLLog        `json:"mysql,omitempty"`
+	MongoDB        *mongodb.MongoDBLog    `json:"mongodb,omitempty"`
+	VNC            *vnc.VNCLog            `json:"vnc,omitempty"`
+	RDP            *rdp.RDPLog            `json:"rdp,omitempty"`
+	SMB            *smb.SMBLog            `json:"smb,omitempty"`
+	Closed         *ConnectionClosedState `json:"connection_closed,omitempty"`
+	Operations     []*Operation           `json:"operations,omitempty"`
 }
 
 func (g *Grab) MarshalJSON() ([]byte, error) {
@@ -82,6 +147,7 @@ func (g *Grab) MarshalJSON() ([]byte, error) {
 		Time:           time,
 		Data:           &g.Data,
 		Error:          errString,
+		ErrorClass:     zerrors.Classify(g.Error),
 		ErrorComponent: g.ErrorComponent,
 	}
 	return json.Marshal(obj)
@@ -0,0 +1,222 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package amqp sends an AMQP 0-9-1 protocol header and decodes the
+// Connection.Start method the broker answers with
+package amqp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// protocolHeader announces AMQP 0-9-1
+var protocolHeader = []byte("AMQP\x00\x00\x09\x01")
+
+const (
+	frameMethod = 1
+	frameEnd    = 0xce
+
+	classConnection = 10
+	methodStart     = 10
+
+	// Connection.Start frames are a few hundred bytes
+	maxFrameSize = 64 * 1024
+)
+
+var (
+	errNotAMQP       = errors.New("Not an AMQP response")
+	errShortAMQP     = errors.New("Truncated AMQP Connection.Start")
+	errNotStartFrame = errors.New("AMQP broker did not send Connection.Start")
+)
+
+// A reader decodes the AMQP wire types from a method payload, recording
+// the first error
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.b) < n {
+		r.err = errShortAMQP
+		r.b = nil
+		return nil
+	}
+	x := r.b[:n]
+	r.b = r.b[n:]
+	return x
+}
+
+func (r *reader) uint8() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) shortString() string {
+	return string(r.next(int(r.uint8())))
+}
+
+func (r *reader) longString() string {
+	return string(r.next(int(r.uint32())))
+}
+
+// table decodes a field table. Only strings and booleans are kept since
+// the server properties carry nothing else of interest; nested tables are
+// decoded recursively.
+func (r *reader) table() map[string]interface{} {
+	t := make(map[string]interface{})
+	sub := &reader{b: r.next(int(r.uint32()))}
+	for r.err == nil && sub.err == nil && len(sub.b) > 0 {
+		name := sub.shortString()
+		if value := sub.value(); value != nil {
+			t[name] = value
+		}
+	}
+	if r.err == nil {
+		r.err = sub.err
+	}
+	return t
+}
+
+// value decodes one field value, as typed by RabbitMQ and the 0-9-1 errata
+func (r *reader) value() interface{} {
+	switch r.uint8() {
+	case 't':
+		return r.uint8() != 0
+	case 'b', 'B':
+		r.next(1)
+	case 's', 'u':
+		r.next(2)
+	case 'I', 'i', 'f':
+		r.next(4)
+	case 'l', 'L', 'd', 'T':
+		r.next(8)
+	case 'D':
+		r.next(5)
+	case 'S', 'x':
+		return r.longString()
+	case 'A':
+		r.next(int(r.uint32()))
+	case 'F':
+		return r.table()
+	case 'V':
+	default:
+		r.err = errNotAMQP
+	}
+	return nil
+}
+
+func stringProperty(properties map[string]interface{}, name string) string {
+	s, _ := properties[name].(string)
+	return s
+}
+
+// parseStart decodes the Connection.Start payload
+func parseStart(logStruct *AMQPLog, payload []byte) error {
+	r := &reader{b: payload}
+	if r.uint16() != classConnection || r.uint16() != methodStart {
+		if r.err != nil {
+			return r.err
+		}
+		return errNotStartFrame
+	}
+	logStruct.VersionMajor = int(r.uint8())
+	logStruct.VersionMinor = int(r.uint8())
+	properties := r.table()
+	mechanisms := r.longString()
+	locales := r.longString()
+	if r.err != nil {
+		return r.err
+	}
+
+	logStruct.Product = stringProperty(properties, "product")
+	logStruct.Version = stringProperty(properties, "version")
+	logStruct.Platform = stringProperty(properties, "platform")
+	logStruct.ClusterName = stringProperty(properties, "cluster_name")
+	logStruct.Copyright = stringProperty(properties, "copyright")
+	logStruct.Information = stringProperty(properties, "information")
+	if capabilities, ok := properties["capabilities"].(map[string]interface{}); ok {
+		for name, value := range capabilities {
+			if enabled, _ := value.(bool); enabled {
+				logStruct.Capabilities = append(logStruct.Capabilities, name)
+			}
+		}
+		sort.Strings(logStruct.Capabilities)
+	}
+	logStruct.Mechanisms = strings.Fields(mechanisms)
+	logStruct.Locales = strings.Fields(locales)
+	return nil
+}
+
+// GetConnectionStart sends the protocol header and records the broker's
+// Connection.Start. The connection is left for the caller to close; no
+// Start-Ok is ever sent.
+func GetConnectionStart(logStruct *AMQPLog, conn net.Conn) error {
+	if _, err := conn.Write(protocolHeader); err != nil {
+		return err
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if bytes.HasPrefix(header, []byte("AMQP")) {
+		// The broker counters with the protocol it supports
+		rest := make([]byte, 1)
+		io.ReadFull(conn, rest)
+		logStruct.ServerProtocolHeader = append(header, rest...)
+		return nil
+	}
+	if header[0] != frameMethod {
+		return errNotAMQP
+	}
+	size := binary.BigEndian.Uint32(header[3:])
+	if size > maxFrameSize {
+		return errNotAMQP
+	}
+	body := make([]byte, size+1)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return err
+	}
+	logStruct.RawFrame = append(header, body...)
+	if body[size] != frameEnd {
+		return errNotAMQP
+	}
+	return parseStart(logStruct, body[:size])
+}
@@ -0,0 +1,128 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package amqp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func longString(s string) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func table(entries ...[]byte) []byte {
+	body := bytes.Join(entries, nil)
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(len(body)))
+	return append(b, body...)
+}
+
+func entry(name string, typ byte, value []byte) []byte {
+	b := append([]byte{byte(len(name))}, name...)
+	b = append(b, typ)
+	return append(b, value...)
+}
+
+// startFrame is a RabbitMQ style Connection.Start
+func startFrame() []byte {
+	capabilities := table(
+		entry("publisher_confirms", 't', []byte{1}),
+		entry("consumer_priorities", 't', []byte{1}),
+		entry("basic.nack", 't', []byte{0}),
+	)
+	properties := table(
+		entry("capabilities", 'F', capabilities),
+		entry("cluster_name", 'S', longString("rabbit@mq1")),
+		entry("product", 'S', longString("RabbitMQ")),
+		entry("version", 'S', longString("3.12.4")),
+		entry("platform", 'S', longString("Erlang/OTP 26.0.2")),
+		entry("timestamp", 'T', make([]byte, 8)),
+	)
+	payload := []byte{0, classConnection, 0, methodStart, 0, 9}
+	payload = append(payload, properties...)
+	payload = append(payload, longString("AMQPLAIN PLAIN")...)
+	payload = append(payload, longString("en_US")...)
+
+	frame := []byte{frameMethod, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[3:], uint32(len(payload)))
+	frame = append(frame, payload...)
+	return append(frame, frameEnd)
+}
+
+func serve(conn net.Conn, reply []byte) <-chan []byte {
+	sent := make(chan []byte, 1)
+	go func() {
+		header := make([]byte, 8)
+		io.ReadFull(conn, header)
+		conn.Write(reply)
+		sent <- header
+	}()
+	return sent
+}
+
+func TestGetConnectionStart(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	frame := startFrame()
+	sent := serve(server, frame)
+
+	log := new(AMQPLog)
+	if err := GetConnectionStart(log, client); err != nil {
+		t.Fatalf("GetConnectionStart: %s", err.Error())
+	}
+	if header := <-sent; !bytes.Equal(header, protocolHeader) {
+		t.Errorf("Wrong protocol header: %q", header)
+	}
+	if log.Product != "RabbitMQ" || log.Version != "3.12.4" || log.ClusterName != "rabbit@mq1" || log.VersionMinor != 9 {
+		t.Errorf("Wrong server properties: %+v", log)
+	}
+	if strings.Join(log.Capabilities, " ") != "consumer_priorities publisher_confirms" {
+		t.Errorf("Wrong capabilities: %v", log.Capabilities)
+	}
+	if strings.Join(log.Mechanisms, " ") != "AMQPLAIN PLAIN" || !bytes.Equal(log.RawFrame, frame) {
+		t.Errorf("Wrong mechanisms or raw frame: %v", log.Mechanisms)
+	}
+}
+
+func TestGetConnectionStartOtherVersion(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	serve(server, []byte("AMQP\x00\x01\x00\x00"))
+
+	log := new(AMQPLog)
+	if err := GetConnectionStart(log, client); err != nil {
+		t.Fatalf("GetConnectionStart: %s", err.Error())
+	}
+	if string(log.ServerProtocolHeader) != "AMQP\x00\x01\x00\x00" || log.RawFrame != nil {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
+
+func TestParseStartTruncated(t *testing.T) {
+	frame := startFrame()
+	payload := frame[7 : len(frame)-1]
+	if err := parseStart(new(AMQPLog), payload[:len(payload)-10]); err != errShortAMQP {
+		t.Errorf("Expected errShortAMQP, got %v", err)
+	}
+}
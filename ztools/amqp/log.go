@@ -0,0 +1,34 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package amqp
+
+// An AMQPLog records the Connection.Start method a broker sends after the
+// protocol header. A broker not speaking 0-9-1 answers with the header of
+// the protocol it does speak instead, recorded in ServerProtocolHeader.
+type AMQPLog struct {
+	RawFrame             []byte   `json:"raw_frame,omitempty"`
+	ServerProtocolHeader []byte   `json:"server_protocol_header,omitempty"`
+	VersionMajor         int      `json:"version_major,omitempty"`
+	VersionMinor         int      `json:"version_minor,omitempty"`
+	Product              string   `json:"product,omitempty"`
+	Version              string   `json:"version,omitempty"`
+	Platform             string   `json:"platform,omitempty"`
+	ClusterName          string   `json:"cluster_name,omitempty"`
+	Copyright            string   `json:"copyright,omitempty"`
+	Information          string   `json:"information,omitempty"`
+	Capabilities         []string `json:"capabilities,omitempty"`
+	Mechanisms           []string `json:"mechanisms,omitempty"`
+	Locales              []string `json:"locales,omitempty"`
+}
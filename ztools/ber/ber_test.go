@@ -0,0 +1,40 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package ber
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeLength(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x81, 0x80}},
+		{255, []byte{0x81, 0xff}},
+		{256, []byte{0x82, 0x01, 0x00}},
+		{0x1234, []byte{0x82, 0x12, 0x34}},
+		{70000, []byte{0x83, 0x01, 0x11, 0x70}},
+	}
+	for _, test := range tests {
+		if got := EncodeLength(test.n); !bytes.Equal(got, test.want) {
+			t.Errorf("EncodeLength(%d) = %x, want %x", test.n, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package cassandra sends a native protocol OPTIONS request and decodes the
+// SUPPORTED reply listing the CQL versions and compression algorithms
+package cassandra
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Protocol versions tried, newest first. Version 5 is still flagged beta
+// by Cassandra 3, which refuses it like any unsupported version.
+const (
+	MaxVersion = 4
+	MinVersion = 2
+
+	// Lower versions tried after the server refuses one
+	MaxRetries = 2
+)
+
+// Opcodes, native protocol specification section 2.4
+const (
+	opError     = 0x00
+	opOptions   = 0x05
+	opSupported = 0x06
+)
+
+// errProtocol is the error code sent for an unsupported protocol version,
+// among other malformed requests
+const errProtocol = 0x000a
+
+// Frame body bytes read at most; a SUPPORTED reply is well under 1KB
+const maxBodyLength = 64 * 1024
+
+var errNotCassandra = errors.New("Not a Cassandra native protocol response")
+
+// A VersionError is returned when the server refuses the protocol version
+// of the request, so it may be retried with a lower one
+type VersionError struct {
+	Version int
+	Message string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("Cassandra protocol version %d refused: %s", e.Version, e.Message)
+}
+
+// headerLength returns the frame header length, the stream id being one
+// byte before version 3
+func headerLength(version int) int {
+	if version < 3 {
+		return 8
+	}
+	return 9
+}
+
+func optionsFrame(version int) []byte {
+	frame := make([]byte, headerLength(version))
+	frame[0] = byte(version)
+	// Flags, stream 0 and an empty body are all zero
+	frame[len(frame)-5] = opOptions
+	return frame
+}
+
+// readFrame reads one response frame and returns its opcode and body
+func readFrame(conn net.Conn) (byte, []byte, error) {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(conn, first); err != nil {
+		return 0, nil, err
+	}
+	version := int(first[0] &^ 0x80)
+	if first[0]&0x80 == 0 || version < 1 || version > 5 {
+		return 0, nil, errNotCassandra
+	}
+	header := make([]byte, headerLength(version)-1)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[len(header)-5]
+	length := binary.BigEndian.Uint32(header[len(header)-4:])
+	if length > maxBodyLength {
+		return 0, nil, errNotCassandra
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return 0, nil, err
+	}
+	return opcode, body, nil
+}
+
+// readString decodes a [string], a short length followed by the bytes
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errNotCassandra
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errNotCassandra
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+// parseMultimap decodes a [string multimap], each key mapping to a
+// [string list]
+func parseMultimap(b []byte) (map[string][]string, error) {
+	if len(b) < 2 {
+		return nil, errNotCassandra
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	m := make(map[string][]string, n)
+	for i := 0; i < n; i++ {
+		key, rest, err := readString(b)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) < 2 {
+			return nil, errNotCassandra
+		}
+		count := int(binary.BigEndian.Uint16(rest))
+		b = rest[2:]
+		values := make([]string, 0, count)
+		for j := 0; j < count; j++ {
+			var value string
+			if value, b, err = readString(b); err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		m[key] = values
+	}
+	return m, nil
+}
+
+// Options sends an OPTIONS request with the given protocol version and
+// records the SUPPORTED reply. A *VersionError means the version was
+// refused.
+func Options(logStruct *Log, conn net.Conn, version int) error {
+	if _, err := conn.Write(optionsFrame(version)); err != nil {
+		return err
+	}
+	opcode, body, err := readFrame(conn)
+	if err != nil {
+		return err
+	}
+
+	switch opcode {
+	case opSupported:
+		supported, err := parseMultimap(body)
+		if err != nil {
+			return err
+		}
+		logStruct.ProtocolVersion = version
+		logStruct.ErrorCode, logStruct.ErrorMessage = 0, ""
+		logStruct.Supported = supported
+		logStruct.CQLVersions = supported["CQL_VERSION"]
+		logStruct.Compression = supported["COMPRESSION"]
+		logStruct.ProtocolVersions = supported["PROTOCOL_VERSIONS"]
+		return nil
+	case opError:
+		if len(body) < 4 {
+			return errNotCassandra
+		}
+		code := binary.BigEndian.Uint32(body)
+		message, _, err := readString(body[4:])
+		if err != nil {
+			return err
+		}
+		logStruct.ErrorCode = code
+		logStruct.ErrorMessage = message
+		if code == errProtocol {
+			logStruct.RejectedVersions = append(logStruct.RejectedVersions, version)
+			return &VersionError{Version: version, Message: message}
+		}
+		return fmt.Errorf("Cassandra error 0x%04x: %s", code, message)
+	}
+	return errNotCassandra
+}
@@ -0,0 +1,135 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package cassandra
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func shortString(s string) []byte {
+	b := []byte{byte(len(s) >> 8), byte(len(s))}
+	return append(b, s...)
+}
+
+// frame encodes a version 4 response
+func frame(opcode byte, body []byte) []byte {
+	f := []byte{0x84, 0, 0, 0, opcode, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(f[5:], uint32(len(body)))
+	return append(f, body...)
+}
+
+func supported() []byte {
+	body := []byte{0, 2}
+	body = append(body, shortString("CQL_VERSION")...)
+	body = append(body, 0, 1)
+	body = append(body, shortString("3.4.5")...)
+	body = append(body, shortString("COMPRESSION")...)
+	body = append(body, 0, 2)
+	body = append(body, shortString("snappy")...)
+	body = append(body, shortString("lz4")...)
+	return frame(opSupported, body)
+}
+
+// serve reads a request header and answers with reply, returning the
+// request
+func serve(conn net.Conn, headerLen int, reply []byte) <-chan []byte {
+	sent := make(chan []byte, 1)
+	go func() {
+		request := make([]byte, headerLen)
+		io.ReadFull(conn, request)
+		conn.Write(reply)
+		sent <- request
+	}()
+	return sent
+}
+
+func TestOptions(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	sent := serve(server, 9, supported())
+
+	log := new(Log)
+	if err := Options(log, client, 4); err != nil {
+		t.Fatalf("Options: %s", err.Error())
+	}
+	if request := <-sent; !bytes.Equal(request, []byte{4, 0, 0, 0, opOptions, 0, 0, 0, 0}) {
+		t.Errorf("Wrong OPTIONS frame: %x", request)
+	}
+	if log.ProtocolVersion != 4 || len(log.CQLVersions) != 1 || log.CQLVersions[0] != "3.4.5" || len(log.Compression) != 2 {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
+
+func TestOptionsVersionRefused(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	message := "Invalid or unsupported protocol version (4); highest supported version is 3"
+	// The error comes back in a version 3 frame
+	reply := append([]byte{0x83, 0, 0, 0, opError, 0, 0, 0, byte(6 + len(message)), 0, 0, 0, errProtocol}, shortString(message)...)
+	serve(server, 9, reply)
+
+	log := new(Log)
+	err := Options(log, client, 4)
+	if verr, ok := err.(*VersionError); !ok || verr.Version != 4 || verr.Message != message {
+		t.Fatalf("Expected a VersionError, got %v", err)
+	}
+	if len(log.RejectedVersions) != 1 || log.ErrorCode != errProtocol {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
+
+func TestOptionsVersion2Header(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	reply := supported()
+	reply = append([]byte{0x82, 0, 0, opSupported}, reply[5:]...)
+	sent := serve(server, 8, reply)
+
+	log := new(Log)
+	if err := Options(log, client, 2); err != nil {
+		t.Fatalf("Options: %s", err.Error())
+	}
+	if request := <-sent; request[0] != 2 || request[3] != opOptions {
+		t.Errorf("Wrong version 2 OPTIONS frame: %x", request)
+	}
+	if log.ProtocolVersion != 2 || log.CQLVersions[0] != "3.4.5" {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
+
+func TestOptionsNotCassandra(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	serve(server, 9, []byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+
+	if err := Options(new(Log), client, 4); err != errNotCassandra {
+		t.Errorf("Expected errNotCassandra, got %v", err)
+	}
+}
+
+func TestParseMultimapTruncated(t *testing.T) {
+	body := supported()[9:]
+	if _, err := parseMultimap(body[:len(body)-2]); err == nil {
+		t.Error("Truncated multimap accepted")
+	}
+}
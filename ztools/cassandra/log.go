@@ -0,0 +1,30 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package cassandra
+
+// A Log records the SUPPORTED reply to an OPTIONS request. Each
+// protocol version the server refused is listed in RejectedVersions, with
+// the error code and message of the last refusal kept unless a later
+// version succeeded.
+type Log struct {
+	ProtocolVersion  int                 `json:"protocol_version,omitempty"`
+	RejectedVersions []int               `json:"rejected_versions,omitempty"`
+	CQLVersions      []string            `json:"cql_versions,omitempty"`
+	Compression      []string            `json:"compression,omitempty"`
+	ProtocolVersions []string            `json:"protocol_versions,omitempty"`
+	Supported        map[string][]string `json:"supported,omitempty"`
+	ErrorCode        uint32              `json:"error_code,omitempty"`
+	ErrorMessage     string              `json:"error_message,omitempty"`
+}
@@ -0,0 +1,375 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package dns implements just enough of a DNS stub resolver to look up the
+// A and AAAA records of a name while keeping the response code, the CHAOS
+// TXT query servers answer with their version, and the EDNS0 query used to
+// tell open resolvers.
+package dns
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Record types
+const (
+	TypeA     uint16 = 1
+	TypeNS    uint16 = 2
+	TypeCNAME uint16 = 5
+	TypePTR   uint16 = 12
+	TypeTXT   uint16 = 16
+	TypeAAAA  uint16 = 28
+	typeOPT   uint16 = 41
+)
+
+// Record classes
+const (
+	classINET  uint16 = 1
+	classCHAOS uint16 = 3
+)
+
+// Response codes, RFC 1035 section 4.1.1
+const (
+	RcodeSuccess        = 0
+	RcodeFormatError    = 1
+	RcodeServerFailure  = 2
+	RcodeNameError      = 3
+	RcodeNotImplemented = 4
+	RcodeRefused        = 5
+)
+
+var rcodeNames = map[int]string{
+	RcodeSuccess:        "NOERROR",
+	RcodeFormatError:    "FORMERR",
+	RcodeServerFailure:  "SERVFAIL",
+	RcodeNameError:      "NXDOMAIN",
+	RcodeNotImplemented: "NOTIMP",
+	RcodeRefused:        "REFUSED",
+}
+
+// RcodeName returns the mnemonic of a response code, e.g. NXDOMAIN
+func RcodeName(rcode int) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// TypeName returns the mnemonic of a record type
+func TypeName(qtype uint16) string {
+	switch qtype {
+	case TypeA:
+		return "A"
+	case TypeNS:
+		return "NS"
+	case TypeCNAME:
+		return "CNAME"
+	case TypePTR:
+		return "PTR"
+	case TypeTXT:
+		return "TXT"
+	case TypeAAAA:
+		return "AAAA"
+	}
+	return fmt.Sprintf("TYPE%d", qtype)
+}
+
+// An RR is a resource record of the answer section. Data is the address of
+// an A or AAAA record, the target of a CNAME, NS or PTR, the strings of a
+// TXT record and the hex encoded data of anything else.
+type RR struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class uint16 `json:"class"`
+	TTL   uint32 `json:"ttl"`
+	Data  string `json:"data"`
+}
+
+// A Response is the part of a reply Query understands
+type Response struct {
+	Rcode              int
+	Authoritative      bool
+	RecursionAvailable bool
+	// Addresses in the A or AAAA records of the answer section
+	Addrs []net.IP
+	// Strings in the TXT records of the answer section
+	TXT []string
+	// Every record of the answer section
+	Answers []*RR
+	// The UDP payload size of the server's EDNS0 OPT record, zero without
+	// one
+	EDNSPayloadSize int
+	// Set when the reply did not fit in a datagram. Addrs then holds
+	// whatever records were included.
+	Truncated bool
+}
+
+// Largest reply accepted over UDP without EDNS
+const maxUDPMessage = 512
+
+var errMalformed = errors.New("dns: malformed response")
+
+// Query sends a recursive query for the qtype records of name on conn,
+// typically a UDP socket connected to the resolver, and reads the reply.
+// Deadlines are left to the caller.
+func Query(conn net.Conn, name string, qtype uint16) (*Response, error) {
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+	query, err := buildQuery(id, name, qtype, classINET, true)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxUDPMessage)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Skip stray datagrams meant for an earlier query
+		if n >= 2 && binary.BigEndian.Uint16(buf) != id {
+			continue
+		}
+		return parseResponse(buf[:n], qtype, classINET)
+	}
+}
+
+// VersionQuery returns a query for the version.bind CHAOS TXT record, which
+// BIND and many other servers answer with their name and version
+func VersionQuery(id uint16) []byte {
+	query, _ := buildQuery(id, "version.bind", TypeTXT, classCHAOS, false)
+	return query
+}
+
+// ParseVersionResponse decodes the reply to VersionQuery. The version
+// strings are in TXT.
+func ParseVersionResponse(msg []byte) (*Response, error) {
+	return parseResponse(msg, TypeTXT, classCHAOS)
+}
+
+// ProbeQuery returns a recursive query for the A records of name with an
+// EDNS0 OPT record advertising payloadSize, as an open resolver census sends
+func ProbeQuery(id uint16, name string, payloadSize uint16) ([]byte, error) {
+	query, err := buildQuery(id, name, TypeA, classINET, true)
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(query[10:], 1)
+	return append(query, 0, byte(typeOPT>>8), byte(typeOPT), byte(payloadSize>>8), byte(payloadSize), 0, 0, 0, 0, 0, 0), nil
+}
+
+// ParseProbeResponse decodes the reply to ProbeQuery
+func ParseProbeResponse(msg []byte) (*Response, error) {
+	return parseResponse(msg, TypeA, classINET)
+}
+
+// buildQuery encodes a query with a single question, optionally asking for
+// recursion
+func buildQuery(id uint16, name string, qtype, qclass uint16, recursive bool) ([]byte, error) {
+	msg := make([]byte, 12, 12+len(name)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	if recursive {
+		binary.BigEndian.PutUint16(msg[2:], 0x0100)
+	}
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return nil, fmt.Errorf("dns: invalid name %q", name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("dns: invalid name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), byte(qclass>>8), byte(qclass))
+	return msg, nil
+}
+
+// parseResponse decodes the header of a reply and the qtype records of its
+// answer section
+func parseResponse(msg []byte, qtype, qclass uint16) (*Response, error) {
+	if len(msg) < 12 {
+		return nil, errMalformed
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&0x8000 == 0 {
+		return nil, errors.New("dns: reply is not a response")
+	}
+	res := &Response{
+		Rcode:              int(flags & 0x000f),
+		Authoritative:      flags&0x0400 != 0,
+		RecursionAvailable: flags&0x0080 != 0,
+		Truncated:          flags&0x0200 != 0,
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+	nscount := int(binary.BigEndian.Uint16(msg[8:]))
+	arcount := int(binary.BigEndian.Uint16(msg[10:]))
+	off := 12
+	var err error
+	for i := 0; i < qdcount; i++ {
+		if off, err = skipName(msg, off); err != nil {
+			return nil, err
+		}
+		off += 4
+	}
+	for i := 0; i < ancount+nscount+arcount; i++ {
+		start := off
+		if off, err = skipName(msg, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(msg) {
+			return nil, errMalformed
+		}
+		rrType := binary.BigEndian.Uint16(msg[off:])
+		rrClass := binary.BigEndian.Uint16(msg[off+2:])
+		ttl := binary.BigEndian.Uint32(msg[off+4:])
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			return nil, errMalformed
+		}
+		data := msg[off : off+length]
+		dataOff := off
+		off += length
+		if i >= ancount {
+			// The OPT pseudo-record carries the payload size in its class
+			if i >= ancount+nscount && rrType == typeOPT {
+				res.EDNSPayloadSize = int(rrClass)
+			}
+			continue
+		}
+		rr := &RR{Type: TypeName(rrType), Class: rrClass, TTL: ttl}
+		if rr.Name, err = readName(msg, start); err != nil {
+			return nil, err
+		}
+		if rr.Data, err = rrData(msg, rrType, dataOff, data); err != nil {
+			return nil, err
+		}
+		res.Answers = append(res.Answers, rr)
+		if rrType != qtype || rrClass != qclass {
+			// e.g. the CNAME records leading to the address
+			continue
+		}
+		switch {
+		case (qtype == TypeA && length == net.IPv4len) || (qtype == TypeAAAA && length == net.IPv6len):
+			res.Addrs = append(res.Addrs, net.IP(append([]byte{}, data...)))
+		case qtype == TypeTXT:
+			strs, err := parseTXT(data)
+			if err != nil {
+				return nil, err
+			}
+			res.TXT = append(res.TXT, strs...)
+		}
+	}
+	return res, nil
+}
+
+// rrData formats the data of a record for RR
+func rrData(msg []byte, rrType uint16, off int, data []byte) (string, error) {
+	switch {
+	case (rrType == TypeA && len(data) == net.IPv4len) || (rrType == TypeAAAA && len(data) == net.IPv6len):
+		return net.IP(data).String(), nil
+	case rrType == TypeCNAME || rrType == TypeNS || rrType == TypePTR:
+		return readName(msg, off)
+	case rrType == TypeTXT:
+		strs, err := parseTXT(data)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(strs, " "), nil
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// readName decodes the possibly compressed name at off
+func readName(msg []byte, off int) (string, error) {
+	var labels []string
+	// Each pointer must go backwards, which rules out loops
+	limit := len(msg)
+	for {
+		if off >= len(msg) {
+			return "", errMalformed
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			return strings.Join(labels, ".") + ".", nil
+		case length&0xc0 == 0xc0:
+			if off+2 > len(msg) {
+				return "", errMalformed
+			}
+			ptr := int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			if ptr >= limit {
+				return "", errMalformed
+			}
+			limit, off = ptr, ptr
+			continue
+		case length&0xc0 != 0 || off+1+length > len(msg):
+			return "", errMalformed
+		}
+		labels = append(labels, string(msg[off+1:off+1+length]))
+		off += 1 + length
+	}
+}
+
+// parseTXT splits TXT record data into its length-prefixed strings
+func parseTXT(data []byte) ([]string, error) {
+	var strs []string
+	for len(data) > 0 {
+		n := int(data[0])
+		if 1+n > len(data) {
+			return nil, errMalformed
+		}
+		strs = append(strs, string(data[1:1+n]))
+		data = data[1+n:]
+	}
+	return strs, nil
+}
+
+// skipName returns the offset just past the possibly compressed name at off
+func skipName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errMalformed
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			return off + 1, nil
+		case length&0xc0 == 0xc0:
+			// A pointer ends the name
+			if off+2 > len(msg) {
+				return 0, errMalformed
+			}
+			return off + 2, nil
+		case length&0xc0 != 0:
+			return 0, errMalformed
+		}
+		off += 1 + length
+	}
+}
@@ -0,0 +1,35 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package fingerprint holds the form the JA3, JA3S and HASSH handshake
+// fingerprints are logged in.
+package fingerprint
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+)
+
+// A Fingerprint is a fingerprint string and its MD5 digest in hex, the
+// compact key fingerprint records are clustered by
+type Fingerprint struct {
+	Raw string `json:"raw"`
+	MD5 string `json:"md5"`
+}
+
+// New digests a fingerprint string
+func New(raw string) *Fingerprint {
+	digest := md5.Sum([]byte(raw))
+	return &Fingerprint{Raw: raw, MD5: hex.EncodeToString(digest[:])}
+}
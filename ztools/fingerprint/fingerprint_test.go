@@ -0,0 +1,24 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package fingerprint
+
+import "testing"
+
+func TestNew(t *testing.T) {
+	fp := New("771,49199,35-65281-16")
+	if fp.Raw != "771,49199,35-65281-16" || fp.MD5 != "2b57f9dbfe8cbbe32fb9886c41c7bb20" {
+		t.Errorf("Wrong fingerprint: %+v", *fp)
+	}
+}
@@ -24,47 +24,59 @@ import (
 
 var ftpEndRegex = regexp.MustCompile(`^(?:.*\r?\n)*([0-9]{3})( [^\r\n]*)?\r?\n$`)
 
+// Multi-line replies are read into a growing buffer capped at this size
+const ftpMaxResponseSize = 64 * 1024
+
+// readResponse reads a complete, possibly multi-line, reply and returns it
+// along with the final reply code
+func readResponse(connection net.Conn) (string, string, error) {
+	buffer, err := util.ReadUntilRegexGrowing(connection, make([]byte, 1024), ftpEndRegex, ftpMaxResponseSize)
+	response := string(buffer)
+	if err != nil {
+		return response, "", err
+	}
+	return response, ftpEndRegex.FindStringSubmatch(response)[1], nil
+}
+
 func GetFTPBanner(logStruct *FTPLog, connection net.Conn) (bool, error) {
-	buffer := make([]byte, 1024)
-	respLen, err := util.ReadUntilRegex(connection, buffer, ftpEndRegex)
-	logStruct.Banner = string(buffer[0:respLen])
+	banner, retCode, err := readResponse(connection)
+	logStruct.Banner = banner
 
 	if err != nil {
 		return false, err
 	}
 
-	retCode := ftpEndRegex.FindStringSubmatch(logStruct.Banner)[1]
-
 	return strings.HasPrefix(retCode, "2"), nil
 }
 
+// SetupFTPS sends AUTH TLS and reports whether the server answered 234. If
+// the command is rejected as unrecognized (502) or unimplemented (504), AUTH
+// SSL is tried instead.
 func SetupFTPS(logStruct *FTPLog, connection net.Conn) (bool, error) {
-	buffer := make([]byte, 1024)
-
-	connection.Write([]byte("AUTH TLS\r\n"))
-	respLen, err := util.ReadUntilRegex(connection, buffer, ftpEndRegex)
+	if _, err := connection.Write([]byte("AUTH TLS\r\n")); err != nil {
+		return false, err
+	}
+	resp, retCode, err := readResponse(connection)
+	logStruct.AuthTLSResp = resp
 	if err != nil {
 		return false, err
 	}
 
-	logStruct.AuthTLSResp = string(buffer[0:respLen])
-	retCode := ftpEndRegex.FindStringSubmatch(logStruct.AuthTLSResp)[1]
-	if strings.HasPrefix(retCode, "2") {
+	if retCode == "234" {
 		return true, nil
-	} else {
-		connection.Write([]byte("AUTH SSL\r\n"))
-		respLen, err := util.ReadUntilRegex(connection, buffer, ftpEndRegex)
-		if err != nil {
-			return false, err
-		}
-
-		logStruct.AuthSSLResp = string(buffer[0:respLen])
-		retCode := ftpEndRegex.FindStringSubmatch(logStruct.AuthSSLResp)[1]
+	}
+	if retCode != "502" && retCode != "504" {
+		return false, nil
+	}
 
-		if strings.HasPrefix(retCode, "2") {
-			return true, nil
-		}
+	if _, err := connection.Write([]byte("AUTH SSL\r\n")); err != nil {
+		return false, err
+	}
+	resp, retCode, err = readResponse(connection)
+	logStruct.AuthSSLResp = resp
+	if err != nil {
+		return false, err
 	}
 
-	return false, nil
+	return retCode == "234", nil
 }
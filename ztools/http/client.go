@@ -48,6 +48,10 @@ type Client struct {
 
 	// HTTP User Agent header for an instantiated client
 	UserAgent string
+
+	// Additional headers sent with every request, including redirects to
+	// the same host but not redirects to another one
+	Headers Header
 }
 
 // DefaultClient is the default Client and is used by Get, Head, and Post.
@@ -158,7 +162,7 @@ func send(req *Request, t RoundTripper) (resp *Response, err error) {
 // automatically redirect.
 func shouldRedirect(statusCode int) bool {
 	switch statusCode {
-	case StatusMovedPermanently, StatusFound, StatusSeeOther, StatusTemporaryRedirect:
+	case StatusMovedPermanently, StatusFound, StatusSeeOther, StatusTemporaryRedirect, StatusPermanentRedirect:
 		return true
 	}
 	return false
@@ -171,6 +175,7 @@ func shouldRedirect(statusCode int) bool {
 //    302 (Found)
 //    303 (See Other)
 //    307 (Temporary Redirect)
+//    308 (Permanent Redirect)
 //
 // Caller should close r.Body when done reading from it.
 //
@@ -187,6 +192,7 @@ func Get(url string) (r *Response, err error) {
 //    302 (Found)
 //    303 (See Other)
 //    307 (Temporary Redirect)
+//    308 (Permanent Redirect)
 //
 // Caller should close r.Body when done reading from it.
 func (c *Client) Get(url string) (r *Response, err error) {
@@ -260,6 +266,11 @@ func (c *Client) doFollowingRedirects(ireq *Request) (r *Response, err error) {
 			req.Headers = make(Header)
 		}
 
+		if redirect == 0 || strings.EqualFold(requestHostname(req), requestHostname(ireq)) {
+			for key, values := range c.Headers {
+				req.Headers[key] = values
+			}
+		}
 		req.Headers.Set("User-Agent", c.UserAgent)
 
 		if r, err = send(req, c.Transport); err != nil {
@@ -297,6 +308,16 @@ func (c *Client) doFollowingRedirects(ireq *Request) (r *Response, err error) {
 	return
 }
 
+// requestHostname returns the host a request is addressed to, from its
+// Host override or URL, without the port
+func requestHostname(req *Request) string {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	return (&url.URL{Host: host}).Hostname()
+}
+
 func defaultCheckRedirect(req *Request, res *Response, via []*Request) error {
 	if len(via) >= 10 {
 		return errors.New("stopped after 10 redirects")
@@ -367,6 +388,7 @@ func (c *Client) PostForm(url string, data url.Values) (r *Response, err error)
 //    302 (Found)
 //    303 (See Other)
 //    307 (Temporary Redirect)
+//    308 (Permanent Redirect)
 //
 // Head is a wrapper around DefaultClient.Head
 func Head(url string) (r *Response, err error) {
@@ -381,6 +403,7 @@ func Head(url string) (r *Response, err error) {
 //    302 (Found)
 //    303 (See Other)
 //    307 (Temporary Redirect)
+//    308 (Permanent Redirect)
 func (c *Client) Head(url string) (r *Response, err error) {
 	return c.HeadWithHost(url, "")
 }
@@ -312,6 +312,37 @@ func TestRedirectCookiesJar(t *testing.T) {
 	matchReturnedCookies(t, expectedCookies, resp.Cookies())
 }
 
+func TestRedirectHeadersStayOnHost(t *testing.T) {
+	var offHost string
+	other := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		offHost = r.Headers.Get("X-Scan")
+	}))
+	defer other.Close()
+	_, port, _ := net.SplitHostPort(other.Listener.Addr().String())
+	var onHost []string
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		onHost = append(onHost, r.Headers.Get("X-Scan"))
+		if r.URL.Path == "/" {
+			Redirect(w, r, "/next", StatusFound)
+		} else {
+			Redirect(w, r, "http://localhost:"+port+"/", StatusFound)
+		}
+	}))
+	defer ts.Close()
+
+	c := MakeNewClient()
+	c.Headers = Header{"X-Scan": {"zgrab"}}
+	if _, err := c.Get(ts.URL); err != nil {
+		t.Fatalf("Get: %s", err.Error())
+	}
+	if len(onHost) != 2 || onHost[0] != "zgrab" || onHost[1] != "zgrab" {
+		t.Errorf("Custom header not sent on the same host: %q", onHost)
+	}
+	if offHost != "" {
+		t.Errorf("Custom header sent off host: %s", offHost)
+	}
+}
+
 func matchReturnedCookies(t *testing.T, expected, given []*Cookie) {
 	t.Logf("Received cookies: %v", given)
 	if len(given) != len(expected) {
@@ -100,6 +100,9 @@ type Response struct {
 	BodyText   string        `json:"body,omitempty"`
 	BodySHA256 []byte        `json:"body_sha256,omitempty"`
 
+	// BodyTruncated is set when BodyText holds only a prefix of the body
+	BodyTruncated bool `json:"body_truncated,omitempty"`
+
 	// ContentLength records the length of the associated content.  The
 	// value -1 indicates that the length is unknown.  Unless RequestMethod
 	// is "HEAD", values >= 0 indicate that the given number of bytes may
@@ -24,6 +24,7 @@ const (
 	StatusNotModified       = 304
 	StatusUseProxy          = 305
 	StatusTemporaryRedirect = 307
+	StatusPermanentRedirect = 308
 
 	StatusBadRequest                   = 400
 	StatusUnauthorized                 = 401
@@ -72,6 +73,7 @@ var statusText = map[int]string{
 	StatusNotModified:       "Not Modified",
 	StatusUseProxy:          "Use Proxy",
 	StatusTemporaryRedirect: "Temporary Redirect",
+	StatusPermanentRedirect: "Permanent Redirect",
 
 	StatusBadRequest:                   "Bad Request",
 	StatusUnauthorized:                 "Unauthorized",
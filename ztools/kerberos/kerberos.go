@@ -0,0 +1,228 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package kerberos encodes an AS-REQ, RFC 4120 section 5.4.1, and decodes
+// the KRB-ERROR a KDC answers with
+package kerberos
+
+import (
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Application tags of the messages sent and expected
+const (
+	tagASReq    = 10
+	tagASRep    = 11
+	tagKRBError = 30
+)
+
+// tagGeneralString is absent from older encoding/asn1 releases
+const tagGeneralString = 27
+
+// Principal name types, RFC 4120 section 6.2
+const (
+	nameTypePrincipal = 1
+	nameTypeSrvInst   = 2
+)
+
+// KDC options forwardable, renewable, canonicalize and renewable-ok, as
+// kinit sends them
+var kdcOptions = asn1.BitString{Bytes: []byte{0x40, 0x81, 0x00, 0x10}, BitLength: 32}
+
+// Encryption types offered: aes256-cts-hmac-sha1-96, aes128-cts-hmac-sha1-96
+// and rc4-hmac
+var encryptionTypes = []int{18, 17, 23}
+
+// The till time kinit uses for a ticket without an end
+var tillForever = time.Date(2037, 9, 13, 2, 48, 5, 0, time.UTC)
+
+var errorNames = map[int]string{
+	1:  "KDC_ERR_NAME_EXP",
+	2:  "KDC_ERR_SERVICE_EXP",
+	3:  "KDC_ERR_BAD_PVNO",
+	6:  "KDC_ERR_C_PRINCIPAL_UNKNOWN",
+	7:  "KDC_ERR_S_PRINCIPAL_UNKNOWN",
+	12: "KDC_ERR_POLICY",
+	14: "KDC_ERR_ETYPE_NOSUPP",
+	18: "KDC_ERR_CLIENT_REVOKED",
+	24: "KDC_ERR_PREAUTH_FAILED",
+	25: "KDC_ERR_PREAUTH_REQUIRED",
+	37: "KRB_AP_ERR_SKEW",
+	52: "KRB_ERR_RESPONSE_TOO_BIG",
+	60: "KRB_ERR_GENERIC",
+	68: "KDC_ERR_WRONG_REALM",
+}
+
+// ErrorName returns the RFC 4120 name of a KRB-ERROR code
+func ErrorName(code int) string {
+	if name, ok := errorNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown_%d", code)
+}
+
+var errNotKerberos = errors.New("Not a Kerberos reply")
+
+type principalName struct {
+	NameType   int             `asn1:"explicit,tag:0"`
+	NameString []asn1.RawValue `asn1:"explicit,tag:1"`
+}
+
+func generalString(s string) asn1.RawValue {
+	return asn1.RawValue{Tag: tagGeneralString, Bytes: []byte(s)}
+}
+
+// explicitString returns s as a GeneralString inside the context tag of a
+// KerberosString field, since encoding/asn1 applies no explicit tag when
+// marshalling a RawValue
+func explicitString(tag int, s string) asn1.RawValue {
+	inner, _ := asn1.Marshal(generalString(s))
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: inner}
+}
+
+// kerberosString returns the string in an explicitly tagged field, which
+// encoding/asn1 decodes into a RawValue still holding the inner TLV
+func kerberosString(v asn1.RawValue) string {
+	if v.Class != asn1.ClassContextSpecific {
+		return string(v.Bytes)
+	}
+	var inner asn1.RawValue
+	if _, err := asn1.Unmarshal(v.Bytes, &inner); err != nil {
+		return ""
+	}
+	return string(inner.Bytes)
+}
+
+func newPrincipalName(nameType int, components ...string) principalName {
+	p := principalName{NameType: nameType}
+	for _, c := range components {
+		p.NameString = append(p.NameString, generalString(c))
+	}
+	return p
+}
+
+func (p *principalName) components() []string {
+	var s []string
+	for _, c := range p.NameString {
+		s = append(s, string(c.Bytes))
+	}
+	return s
+}
+
+type kdcReqBody struct {
+	KDCOptions asn1.BitString `asn1:"explicit,tag:0"`
+	CName      principalName  `asn1:"explicit,tag:1"`
+	Realm      asn1.RawValue  // [2], see explicitString
+	SName      principalName  `asn1:"explicit,tag:3"`
+	Till       time.Time      `asn1:"generalized,explicit,tag:5"`
+	Nonce      int            `asn1:"explicit,tag:7"`
+	EType      []int          `asn1:"explicit,tag:8"`
+}
+
+type kdcReq struct {
+	PVNO    int        `asn1:"explicit,tag:1"`
+	MsgType int        `asn1:"explicit,tag:2"`
+	ReqBody kdcReqBody `asn1:"explicit,tag:4"`
+}
+
+type krbError struct {
+	PVNO      int           `asn1:"explicit,tag:0"`
+	MsgType   int           `asn1:"explicit,tag:1"`
+	CTime     asn1.RawValue `asn1:"optional,explicit,tag:2"`
+	CUsec     int           `asn1:"optional,explicit,tag:3"`
+	STime     time.Time     `asn1:"generalized,explicit,tag:4"`
+	SUsec     int           `asn1:"explicit,tag:5"`
+	ErrorCode int           `asn1:"explicit,tag:6"`
+	CRealm    asn1.RawValue `asn1:"optional,explicit,tag:7"`
+	CName     asn1.RawValue `asn1:"optional,explicit,tag:8"`
+	Realm     asn1.RawValue `asn1:"explicit,tag:9"`
+	SName     principalName `asn1:"explicit,tag:10"`
+	EText     asn1.RawValue `asn1:"optional,explicit,tag:11"`
+	EData     []byte        `asn1:"optional,explicit,tag:12"`
+}
+
+// RandomPrincipal returns a client name no KDC should know
+func RandomPrincipal() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "zgrab" + hex.EncodeToString(b)
+}
+
+// ASRequest encodes an AS-REQ without pre-authentication from principal in
+// realm for the realm's krbtgt
+func ASRequest(realm, principal string) ([]byte, error) {
+	nonce := make([]byte, 4)
+	rand.Read(nonce)
+	req := kdcReq{
+		PVNO:    5,
+		MsgType: tagASReq,
+		ReqBody: kdcReqBody{
+			KDCOptions: kdcOptions,
+			CName:      newPrincipalName(nameTypePrincipal, principal),
+			Realm:      explicitString(2, realm),
+			SName:      newPrincipalName(nameTypeSrvInst, "krbtgt", realm),
+			Till:       tillForever,
+			Nonce:      int(binary.BigEndian.Uint32(nonce) &^ 0x80000000),
+			EType:      encryptionTypes,
+		},
+	}
+	body, err := asn1.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: tagASReq, IsCompound: true, Bytes: body})
+}
+
+// ParseReply decodes the KDC's reply into logStruct. An AS-REP, which a KDC
+// would only send for a principal without pre-authentication, is recorded
+// by type alone.
+func ParseReply(logStruct *KerberosLog, b []byte) error {
+	var outer asn1.RawValue
+	if _, err := asn1.Unmarshal(b, &outer); err != nil || outer.Class != asn1.ClassApplication {
+		return errNotKerberos
+	}
+	switch outer.Tag {
+	case tagASRep:
+		logStruct.KDC = true
+		logStruct.MessageType = "as_rep"
+		return nil
+	case tagKRBError:
+	default:
+		return errNotKerberos
+	}
+
+	var e krbError
+	if _, err := asn1.Unmarshal(outer.Bytes, &e); err != nil {
+		return err
+	}
+	if e.PVNO != 5 || e.MsgType != tagKRBError {
+		return errNotKerberos
+	}
+	logStruct.KDC = true
+	logStruct.MessageType = "krb_error"
+	logStruct.ErrorCode = e.ErrorCode
+	logStruct.ErrorName = ErrorName(e.ErrorCode)
+	logStruct.ServerRealm = kerberosString(e.Realm)
+	logStruct.ServerName = e.SName.components()
+	stime := e.STime.UTC()
+	logStruct.ServerTime = &stime
+	logStruct.ErrorText = kerberosString(e.EText)
+	return nil
+}
@@ -0,0 +1,122 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package kerberos
+
+import (
+	"encoding/asn1"
+	"testing"
+	"time"
+)
+
+// The fields of an AS-REQ body up to the realm, as a KDC decodes them
+type kdcReqBodyView struct {
+	KDCOptions asn1.BitString `asn1:"explicit,tag:0"`
+	CName      principalName  `asn1:"explicit,tag:1"`
+	Realm      asn1.RawValue  `asn1:"explicit,tag:2"`
+	SName      principalName  `asn1:"explicit,tag:3"`
+}
+
+type kdcReqView struct {
+	PVNO    int           `asn1:"explicit,tag:1"`
+	MsgType int           `asn1:"explicit,tag:2"`
+	ReqBody asn1.RawValue `asn1:"explicit,tag:4"`
+}
+
+func TestASRequest(t *testing.T) {
+	b, err := ASRequest("EXAMPLE.COM", "zgrabtest")
+	if err != nil {
+		t.Fatalf("ASRequest: %s", err.Error())
+	}
+	if b[0] != 0x6a {
+		t.Errorf("Wrong application tag %#02x", b[0])
+	}
+	var outer asn1.RawValue
+	if _, err := asn1.Unmarshal(b, &outer); err != nil {
+		t.Fatal(err)
+	}
+	var req kdcReqView
+	if _, err := asn1.Unmarshal(outer.Bytes, &req); err != nil {
+		t.Fatalf("AS-REQ does not decode: %s", err.Error())
+	}
+	var body kdcReqBodyView
+	if _, err := asn1.Unmarshal(req.ReqBody.Bytes, &body); err != nil {
+		t.Fatalf("KDC-REQ-BODY does not decode: %s", err.Error())
+	}
+	if req.PVNO != 5 || req.MsgType != 10 || kerberosString(body.Realm) != "EXAMPLE.COM" || body.Realm.Bytes[0] != tagGeneralString {
+		t.Errorf("Wrong AS-REQ: %+v", req)
+	}
+	if sname := body.SName.components(); len(sname) != 2 || sname[0] != "krbtgt" || sname[1] != "EXAMPLE.COM" {
+		t.Errorf("Wrong sname %v", sname)
+	}
+	if cname := body.CName.components(); len(cname) != 1 || cname[0] != "zgrabtest" {
+		t.Errorf("Wrong cname %v", cname)
+	}
+}
+
+// krbErrorFields marshals like krbError, with the strings pre-wrapped
+type krbErrorFields struct {
+	PVNO      int       `asn1:"explicit,tag:0"`
+	MsgType   int       `asn1:"explicit,tag:1"`
+	STime     time.Time `asn1:"generalized,explicit,tag:4"`
+	SUsec     int       `asn1:"explicit,tag:5"`
+	ErrorCode int       `asn1:"explicit,tag:6"`
+	Realm     asn1.RawValue
+	SName     principalName `asn1:"explicit,tag:10"`
+	EText     asn1.RawValue
+}
+
+func krbErrorReply(t *testing.T, code int, realm string) []byte {
+	e := krbErrorFields{
+		PVNO:      5,
+		MsgType:   tagKRBError,
+		STime:     time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		SUsec:     1234,
+		ErrorCode: code,
+		Realm:     explicitString(9, realm),
+		SName:     newPrincipalName(nameTypeSrvInst, "krbtgt", realm),
+		EText:     explicitString(11, "CLIENT_NOT_FOUND"),
+	}
+	body, err := asn1.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: tagKRBError, IsCompound: true, Bytes: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseReplyKRBError(t *testing.T) {
+	log := new(KerberosLog)
+	if err := ParseReply(log, krbErrorReply(t, 6, "CORP.EXAMPLE.COM")); err != nil {
+		t.Fatalf("ParseReply: %s", err.Error())
+	}
+	if !log.KDC || log.ErrorCode != 6 || log.ErrorName != "KDC_ERR_C_PRINCIPAL_UNKNOWN" || log.ServerRealm != "CORP.EXAMPLE.COM" {
+		t.Errorf("Wrong log: %+v", log)
+	}
+	if log.ErrorText != "CLIENT_NOT_FOUND" || log.ServerTime == nil || log.ServerTime.Year() != 2024 || len(log.ServerName) != 2 {
+		t.Errorf("Wrong error details: %+v", log)
+	}
+}
+
+func TestParseReplyNotKerberos(t *testing.T) {
+	for _, b := range [][]byte{[]byte("SSH-2.0-OpenSSH\r\n"), {0x30, 0x03, 0x02, 0x01, 0x05}, {0x7e, 0x03, 0x30, 0x01}} {
+		log := new(KerberosLog)
+		if err := ParseReply(log, b); err == nil || log.KDC {
+			t.Errorf("%x accepted: %+v", b, log)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package kerberos
+
+import "time"
+
+// A KerberosLog records the KDC's reply to an AS-REQ for a principal that
+// should not exist. Any KRB-ERROR confirms a live KDC; its realm and error
+// code tell how the request failed.
+type KerberosLog struct {
+	Transport   string     `json:"transport"`
+	Realm       string     `json:"realm"`
+	Principal   string     `json:"principal"`
+	Raw         []byte     `json:"raw,omitempty"`
+	KDC         bool       `json:"kdc"`
+	MessageType string     `json:"message_type,omitempty"`
+	ErrorCode   int        `json:"error_code,omitempty"`
+	ErrorName   string     `json:"error_name,omitempty"`
+	ServerRealm string     `json:"server_realm,omitempty"`
+	ServerName  []string   `json:"server_name,omitempty"`
+	ServerTime  *time.Time `json:"server_time,omitempty"`
+	ErrorText   string     `json:"error_text,omitempty"`
+}
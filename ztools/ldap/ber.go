@@ -0,0 +1,80 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package ldap
+
+import (
+	"gopkg.in/eniac/zgrab.v0/ztools/ber"
+)
+
+// encodeElement encodes a TLV whose value is the concatenation of values
+func encodeElement(tag byte, values ...[]byte) []byte {
+	var value []byte
+	for _, v := range values {
+		value = append(value, v...)
+	}
+	b := append([]byte{tag}, ber.EncodeLength(len(value))...)
+	return append(b, value...)
+}
+
+// encodeInteger encodes a small non-negative INTEGER or ENUMERATED
+func encodeInteger(tag byte, n int) []byte {
+	var b []byte
+	for ; n > 0x7f; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return encodeElement(tag, append([]byte{byte(n)}, b...))
+}
+
+// makeMessage wraps a protocolOp in an LDAPMessage
+func makeMessage(messageID int, op []byte) []byte {
+	return encodeElement(tagSequence, encodeInteger(tagInteger, messageID), op)
+}
+
+// parseElement splits the next TLV from data, returning the tag, the value
+// and the remaining bytes
+func parseElement(data []byte) (byte, []byte, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, nil, &MalformedResponseError{"truncated element"}
+	}
+	tag, length, offset := data[0], int(data[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 3 || len(data) < 2+n {
+			return 0, nil, nil, &MalformedResponseError{"unsupported length encoding"}
+		}
+		length = 0
+		for _, b := range data[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		offset += n
+	}
+	if len(data) < offset+length {
+		return 0, nil, nil, &MalformedResponseError{"truncated element"}
+	}
+	return tag, data[offset : offset+length], data[offset+length:], nil
+}
+
+// parseInteger decodes a non-negative INTEGER or ENUMERATED of up to four
+// bytes
+func parseInteger(value []byte) (int, error) {
+	if len(value) == 0 || len(value) > 4 {
+		return 0, &MalformedResponseError{"bad integer length"}
+	}
+	n := 0
+	for _, b := range value {
+		n = n<<8 | int(b)
+	}
+	return n, nil
+}
@@ -0,0 +1,175 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package ldap
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+// StartTLSOID is the requestName of the StartTLS extended operation (RFC 4511)
+const StartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// BER tags used by the StartTLS and rootDSE exchanges
+const (
+	tagBoolean           = 0x01
+	tagInteger           = 0x02
+	tagOctetString       = 0x04
+	tagEnumerated        = 0x0a
+	tagSequence          = 0x30
+	tagSet               = 0x31
+	tagSearchRequest     = 0x63
+	tagSearchResultEntry = 0x64
+	tagSearchResultDone  = 0x65
+	tagExtendedRequest   = 0x77
+	tagExtendedResponse  = 0x78
+	tagRequestName       = 0x80
+	tagFilterPresent     = 0x87
+)
+
+// Responses longer than this are treated as malformed
+const maxResponseLength = 64 * 1024
+
+// A MalformedResponseError is returned when the ExtendedResponse cannot be
+// decoded
+type MalformedResponseError struct {
+	Reason string
+}
+
+func (e *MalformedResponseError) Error() string {
+	return "Malformed LDAP response: " + e.Reason
+}
+
+// makeStartTLSRequest encodes an LDAPMessage with messageID 1 carrying a
+// StartTLS ExtendedRequest
+func makeStartTLSRequest() []byte {
+	return makeMessage(1, encodeElement(tagExtendedRequest, encodeElement(tagRequestName, []byte(StartTLSOID))))
+}
+
+// readMessage reads a single BER encoded LDAPMessage
+func readMessage(connection net.Conn) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(connection, header); err != nil {
+		return header, err
+	}
+	if header[0] != tagSequence {
+		return header, &MalformedResponseError{fmt.Sprintf("expected SEQUENCE, got tag %#02x", header[0])}
+	}
+
+	msg := header
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 3 {
+			return msg, &MalformedResponseError{"unsupported length encoding"}
+		}
+		lengthBytes := make([]byte, n)
+		_, err := io.ReadFull(connection, lengthBytes)
+		msg = append(msg, lengthBytes...)
+		if err != nil {
+			return msg, err
+		}
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxResponseLength {
+		return msg, &MalformedResponseError{fmt.Sprintf("message length %d too large", length)}
+	}
+
+	body := make([]byte, length)
+	n, err := io.ReadFull(connection, body)
+	return append(msg, body[0:n]...), err
+}
+
+// parseExtendedResponse decodes the resultCode, matchedDN and
+// diagnosticMessage of an ExtendedResponse into logStruct
+func parseExtendedResponse(logStruct *LDAPLog, msg []byte) error {
+	tag, body, _, err := parseElement(msg)
+	if err != nil {
+		return err
+	}
+	if tag != tagSequence {
+		return &MalformedResponseError{"expected SEQUENCE"}
+	}
+	tag, _, body, err = parseElement(body)
+	if err != nil {
+		return err
+	}
+	if tag != tagInteger {
+		return &MalformedResponseError{"expected messageID"}
+	}
+	tag, op, _, err := parseElement(body)
+	if err != nil {
+		return err
+	}
+	if tag != tagExtendedResponse {
+		return &MalformedResponseError{fmt.Sprintf("expected ExtendedResponse, got tag %#02x", tag)}
+	}
+
+	tag, code, op, err := parseElement(op)
+	if err != nil {
+		return err
+	}
+	if tag != tagEnumerated || len(code) == 0 || len(code) > 4 {
+		return &MalformedResponseError{"expected resultCode"}
+	}
+	resultCode := 0
+	for _, b := range code {
+		resultCode = resultCode<<8 | int(b)
+	}
+	logStruct.ResultCode = &resultCode
+
+	tag, matchedDN, op, err := parseElement(op)
+	if err != nil {
+		return err
+	}
+	if tag != tagOctetString {
+		return &MalformedResponseError{"expected matchedDN"}
+	}
+	logStruct.MatchedDN = string(matchedDN)
+
+	tag, diagnostic, _, err := parseElement(op)
+	if err != nil {
+		return err
+	}
+	if tag != tagOctetString {
+		return &MalformedResponseError{"expected diagnosticMessage"}
+	}
+	logStruct.DiagnosticMessage = string(diagnostic)
+	return nil
+}
+
+// SetupStartTLS sends a StartTLS extended request and reports whether the
+// server answered with resultCode success
+func SetupStartTLS(logStruct *LDAPLog, connection net.Conn) (bool, error) {
+	logStruct.StartTLSRequest = makeStartTLSRequest()
+	if _, err := connection.Write(logStruct.StartTLSRequest); err != nil {
+		return false, err
+	}
+
+	resp, err := readMessage(connection)
+	logStruct.StartTLSResponse = resp
+	if err != nil {
+		return false, err
+	}
+
+	if err := parseExtendedResponse(logStruct, resp); err != nil {
+		return false, err
+	}
+	return *logStruct.ResultCode == 0, nil
+}
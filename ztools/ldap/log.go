@@ -0,0 +1,39 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package ldap
+
+type LDAPLog struct {
+	StartTLSRequest   []byte `json:"starttls_request,omitempty"`
+	StartTLSResponse  []byte `json:"starttls_response,omitempty"`
+	ResultCode        *int   `json:"result_code,omitempty"`
+	MatchedDN         string `json:"matched_dn,omitempty"`
+	DiagnosticMessage string `json:"diagnostic_message,omitempty"`
+
+	RootDSE *RootDSE `json:"root_dse,omitempty"`
+}
+
+// A RootDSE records an anonymous base search of the empty DN. Attributes
+// holds every attribute returned, including those without a field here.
+type RootDSE struct {
+	ResultCode              *int                `json:"result_code,omitempty"`
+	DiagnosticMessage       string              `json:"diagnostic_message,omitempty"`
+	NamingContexts          []string            `json:"naming_contexts,omitempty"`
+	DefaultNamingContext    string              `json:"default_naming_context,omitempty"`
+	SupportedLDAPVersion    []string            `json:"supported_ldap_version,omitempty"`
+	SupportedSASLMechanisms []string            `json:"supported_sasl_mechanisms,omitempty"`
+	VendorName              string              `json:"vendor_name,omitempty"`
+	VendorVersion           string              `json:"vendor_version,omitempty"`
+	Attributes              map[string][]string `json:"attributes,omitempty"`
+}
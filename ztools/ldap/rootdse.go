@@ -0,0 +1,222 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package ldap
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// RootDSEAttributes are requested from the rootDSE. Servers return only
+// the operational attributes asked for.
+var RootDSEAttributes = []string{
+	"namingContexts",
+	"defaultNamingContext",
+	"supportedLDAPVersion",
+	"supportedSASLMechanisms",
+	"supportedExtension",
+	"supportedControl",
+	"vendorName",
+	"vendorVersion",
+}
+
+// SearchResultEntry and SearchResultReference messages read at most before
+// the SearchResultDone
+const maxSearchMessages = 16
+
+// makeRootDSERequest encodes a base object search of "" for objectClass=*
+func makeRootDSERequest(messageID int) []byte {
+	var attributes [][]byte
+	for _, a := range RootDSEAttributes {
+		attributes = append(attributes, encodeElement(tagOctetString, []byte(a)))
+	}
+	search := encodeElement(tagSearchRequest,
+		encodeElement(tagOctetString),   // baseObject
+		encodeInteger(tagEnumerated, 0), // scope baseObject
+		encodeInteger(tagEnumerated, 0), // derefAliases neverDerefAliases
+		encodeInteger(tagInteger, 0),    // sizeLimit
+		encodeInteger(tagInteger, 0),    // timeLimit
+		encodeElement(tagBoolean, []byte{0}),
+		encodeElement(tagFilterPresent, []byte("objectClass")),
+		encodeElement(tagSequence, attributes...),
+	)
+	return makeMessage(messageID, search)
+}
+
+// parseProtocolOp returns the tag and value of the protocolOp of an
+// LDAPMessage
+func parseProtocolOp(msg []byte) (byte, []byte, error) {
+	tag, body, _, err := parseElement(msg)
+	if err != nil {
+		return 0, nil, err
+	}
+	if tag != tagSequence {
+		return 0, nil, &MalformedResponseError{"expected SEQUENCE"}
+	}
+	tag, _, body, err = parseElement(body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if tag != tagInteger {
+		return 0, nil, &MalformedResponseError{"expected messageID"}
+	}
+	tag, op, _, err := parseElement(body)
+	return tag, op, err
+}
+
+// parseSearchResultEntry decodes the attributes of an entry into a map from
+// attribute description to values
+func parseSearchResultEntry(op []byte) (map[string][]string, error) {
+	tag, _, rest, err := parseElement(op)
+	if err != nil {
+		return nil, err
+	}
+	if tag != tagOctetString {
+		return nil, &MalformedResponseError{"expected objectName"}
+	}
+	tag, list, _, err := parseElement(rest)
+	if err != nil {
+		return nil, err
+	}
+	if tag != tagSequence {
+		return nil, &MalformedResponseError{"expected attributes"}
+	}
+	attributes := make(map[string][]string)
+	for len(list) > 0 {
+		var attribute []byte
+		if tag, attribute, list, err = parseElement(list); err != nil {
+			return nil, err
+		}
+		if tag != tagSequence {
+			return nil, &MalformedResponseError{"expected PartialAttribute"}
+		}
+		tag, name, vals, err := parseElement(attribute)
+		if err != nil {
+			return nil, err
+		}
+		if tag != tagOctetString {
+			return nil, &MalformedResponseError{"expected attribute type"}
+		}
+		if tag, vals, _, err = parseElement(vals); err != nil {
+			return nil, err
+		}
+		if tag != tagSet {
+			return nil, &MalformedResponseError{"expected attribute values"}
+		}
+		var values []string
+		for len(vals) > 0 {
+			var value []byte
+			if tag, value, vals, err = parseElement(vals); err != nil {
+				return nil, err
+			}
+			values = append(values, string(value))
+		}
+		attributes[string(name)] = values
+	}
+	return attributes, nil
+}
+
+// parseLDAPResult decodes the resultCode and diagnosticMessage of a
+// SearchResultDone
+func parseLDAPResult(dse *RootDSE, op []byte) error {
+	tag, code, rest, err := parseElement(op)
+	if err != nil {
+		return err
+	}
+	if tag != tagEnumerated {
+		return &MalformedResponseError{"expected resultCode"}
+	}
+	resultCode, err := parseInteger(code)
+	if err != nil {
+		return err
+	}
+	dse.ResultCode = &resultCode
+	// matchedDN, then diagnosticMessage
+	if _, _, rest, err = parseElement(rest); err == nil {
+		if _, diagnostic, _, err := parseElement(rest); err == nil {
+			dse.DiagnosticMessage = string(diagnostic)
+		}
+	}
+	return nil
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// attribute looks name up case insensitively, as servers vary in how they
+// spell attribute descriptions
+func (dse *RootDSE) attribute(name string) []string {
+	for k, v := range dse.Attributes {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// fill copies the well known attributes into their fields
+func (dse *RootDSE) fill() {
+	dse.NamingContexts = dse.attribute("namingContexts")
+	dse.DefaultNamingContext = first(dse.attribute("defaultNamingContext"))
+	dse.SupportedLDAPVersion = dse.attribute("supportedLDAPVersion")
+	dse.SupportedSASLMechanisms = dse.attribute("supportedSASLMechanisms")
+	dse.VendorName = first(dse.attribute("vendorName"))
+	dse.VendorVersion = first(dse.attribute("vendorVersion"))
+}
+
+// SearchRootDSE performs an anonymous search of the rootDSE. messageID must
+// differ from any earlier request on the connection, e.g. 2 after StartTLS.
+func SearchRootDSE(logStruct *LDAPLog, connection net.Conn, messageID int) error {
+	dse := &RootDSE{Attributes: make(map[string][]string)}
+	logStruct.RootDSE = dse
+	if _, err := connection.Write(makeRootDSERequest(messageID)); err != nil {
+		return err
+	}
+
+	for i := 0; i < maxSearchMessages; i++ {
+		msg, err := readMessage(connection)
+		if err != nil {
+			return err
+		}
+		tag, op, err := parseProtocolOp(msg)
+		if err != nil {
+			return err
+		}
+		switch tag {
+		case tagSearchResultEntry:
+			attributes, err := parseSearchResultEntry(op)
+			if err != nil {
+				return err
+			}
+			for name, values := range attributes {
+				dse.Attributes[name] = append(dse.Attributes[name], values...)
+			}
+		case tagSearchResultDone:
+			if err := parseLDAPResult(dse, op); err != nil {
+				return err
+			}
+			dse.fill()
+			return nil
+		default:
+			// SearchResultReference and unsolicited notifications
+		}
+	}
+	return &MalformedResponseError{fmt.Sprintf("no SearchResultDone in %d messages", maxSearchMessages)}
+}
@@ -0,0 +1,107 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package ldap
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func attribute(name string, values ...string) []byte {
+	var vals [][]byte
+	for _, v := range values {
+		vals = append(vals, encodeElement(tagOctetString, []byte(v)))
+	}
+	return encodeElement(tagSequence, encodeElement(tagOctetString, []byte(name)), encodeElement(tagSet, vals...))
+}
+
+func searchResult(messageID int, resultCode int) []byte {
+	entry := encodeElement(tagSearchResultEntry,
+		encodeElement(tagOctetString),
+		encodeElement(tagSequence,
+			attribute("namingContexts", "dc=example,dc=com", "cn=config"),
+			attribute("supportedLDAPVersion", "3"),
+			attribute("supportedSASLMechanisms", "GSSAPI", "EXTERNAL"),
+			attribute("vendorname", "Example Directory"),
+		))
+	done := encodeElement(tagSearchResultDone,
+		encodeInteger(tagEnumerated, resultCode),
+		encodeElement(tagOctetString),
+		encodeElement(tagOctetString),
+	)
+	return append(makeMessage(messageID, entry), makeMessage(messageID, done)...)
+}
+
+func TestMakeStartTLSRequest(t *testing.T) {
+	expected := append([]byte{0x30, 0x1d, 0x02, 0x01, 0x01, 0x77, 0x18, 0x80, 0x16}, StartTLSOID...)
+	if b := makeStartTLSRequest(); !bytes.Equal(b, expected) {
+		t.Errorf("Wrong StartTLS request %x", b)
+	}
+}
+
+func TestSearchRootDSE(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	sent := make(chan []byte, 1)
+	go func() {
+		request, _ := readMessage(server)
+		server.Write(searchResult(2, 0))
+		sent <- request
+	}()
+
+	log := new(LDAPLog)
+	if err := SearchRootDSE(log, client, 2); err != nil {
+		t.Fatalf("SearchRootDSE: %s", err.Error())
+	}
+	request := <-sent
+	if tag, op, err := parseProtocolOp(request); err != nil || tag != tagSearchRequest || !bytes.Contains(op, []byte("supportedSASLMechanisms")) {
+		t.Errorf("Wrong search request %x", request)
+	}
+	dse := log.RootDSE
+	if dse.ResultCode == nil || *dse.ResultCode != 0 || len(dse.NamingContexts) != 2 || dse.VendorName != "Example Directory" {
+		t.Errorf("Wrong rootDSE: %+v", dse)
+	}
+	if strings.Join(dse.SupportedSASLMechanisms, " ") != "GSSAPI EXTERNAL" || len(dse.Attributes) != 4 {
+		t.Errorf("Wrong attributes: %v", dse.Attributes)
+	}
+}
+
+func TestSearchRootDSERefused(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		readMessage(server)
+		// operationsError, as Active Directory sends before a bind when
+		// anonymous access is off
+		done := encodeElement(tagSearchResultDone,
+			encodeInteger(tagEnumerated, 1),
+			encodeElement(tagOctetString),
+			encodeElement(tagOctetString, []byte("000004DC: LdapErr: DSID-0C090A5C")),
+		)
+		server.Write(makeMessage(1, done))
+	}()
+
+	log := new(LDAPLog)
+	if err := SearchRootDSE(log, client, 1); err != nil {
+		t.Fatalf("SearchRootDSE: %s", err.Error())
+	}
+	if dse := log.RootDSE; *dse.ResultCode != 1 || !strings.HasPrefix(dse.DiagnosticMessage, "000004DC") || len(dse.Attributes) != 0 {
+		t.Errorf("Wrong rootDSE: %+v", dse)
+	}
+}
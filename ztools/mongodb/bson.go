@@ -0,0 +1,227 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package mongodb
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// BSON element types decoded, http://bsonspec.org/spec.html
+const (
+	bsonDouble    = 0x01
+	bsonString    = 0x02
+	bsonDocument  = 0x03
+	bsonArray     = 0x04
+	bsonBinary    = 0x05
+	bsonObjectID  = 0x07
+	bsonBool      = 0x08
+	bsonDateTime  = 0x09
+	bsonNull      = 0x0a
+	bsonInt32     = 0x10
+	bsonTimestamp = 0x11
+	bsonInt64     = 0x12
+)
+
+var errMalformedBSON = errors.New("MongoDB reply holds malformed BSON")
+
+// A bsonElement is one key/value pair of a document being encoded. Commands
+// are sensitive to key order, so documents are encoded from a slice.
+type bsonElement struct {
+	key   string
+	value interface{}
+}
+
+// encodeDocument encodes a document whose values are int32, string or bool
+func encodeDocument(elements []bsonElement) []byte {
+	doc := make([]byte, 4, 64)
+	for _, e := range elements {
+		switch v := e.value.(type) {
+		case int32:
+			doc = append(doc, bsonInt32)
+			doc = appendCString(doc, e.key)
+			doc = appendInt32(doc, v)
+		case string:
+			doc = append(doc, bsonString)
+			doc = appendCString(doc, e.key)
+			doc = appendInt32(doc, int32(len(v)+1))
+			doc = appendCString(doc, v)
+		case bool:
+			doc = append(doc, bsonBool)
+			doc = appendCString(doc, e.key)
+			if v {
+				doc = append(doc, 1)
+			} else {
+				doc = append(doc, 0)
+			}
+		default:
+			panic(fmt.Sprintf("mongodb: cannot encode %T", v))
+		}
+	}
+	doc = append(doc, 0)
+	binary.LittleEndian.PutUint32(doc, uint32(len(doc)))
+	return doc
+}
+
+func appendCString(b []byte, s string) []byte {
+	return append(append(b, s...), 0)
+}
+
+func appendInt32(b []byte, v int32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// decodeDocument decodes the document at the start of b, returning it and
+// its length. Embedded documents become maps and arrays slices; object IDs
+// are hex encoded.
+func decodeDocument(b []byte) (map[string]interface{}, int, error) {
+	if len(b) < 5 {
+		return nil, 0, errMalformedBSON
+	}
+	length := int(binary.LittleEndian.Uint32(b))
+	if length < 5 || length > len(b) || b[length-1] != 0 {
+		return nil, 0, errMalformedBSON
+	}
+	doc := make(map[string]interface{})
+	body := b[4 : length-1]
+	for len(body) > 0 {
+		typ := body[0]
+		key, rest, err := cstring(body[1:])
+		if err != nil {
+			return nil, 0, err
+		}
+		value, n, err := decodeValue(typ, rest)
+		if err != nil {
+			return nil, 0, err
+		}
+		doc[key] = value
+		body = rest[n:]
+	}
+	return doc, length, nil
+}
+
+// decodeValue decodes a value of type typ at the start of b, returning it
+// and its length
+func decodeValue(typ byte, b []byte) (interface{}, int, error) {
+	need := func(n int) error {
+		if n > len(b) {
+			return errMalformedBSON
+		}
+		return nil
+	}
+	switch typ {
+	case bsonDouble:
+		if err := need(8); err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), 8, nil
+	case bsonString:
+		if err := need(4); err != nil {
+			return nil, 0, err
+		}
+		n := int(binary.LittleEndian.Uint32(b))
+		if n < 1 || need(4+n) != nil || b[4+n-1] != 0 {
+			return nil, 0, errMalformedBSON
+		}
+		return string(b[4 : 4+n-1]), 4 + n, nil
+	case bsonDocument:
+		return decodeDocument(b)
+	case bsonArray:
+		doc, n, err := decodeDocument(b)
+		if err != nil {
+			return nil, 0, err
+		}
+		// The keys are the indexes "0", "1", ... in order
+		array := make([]interface{}, len(doc))
+		for i := range array {
+			v, ok := doc[fmt.Sprint(i)]
+			if !ok {
+				return nil, 0, errMalformedBSON
+			}
+			array[i] = v
+		}
+		return array, n, nil
+	case bsonBinary:
+		if err := need(5); err != nil {
+			return nil, 0, err
+		}
+		n := int(binary.LittleEndian.Uint32(b))
+		if n < 0 || need(5+n) != nil {
+			return nil, 0, errMalformedBSON
+		}
+		return append([]byte(nil), b[5:5+n]...), 5 + n, nil
+	case bsonObjectID:
+		if err := need(12); err != nil {
+			return nil, 0, err
+		}
+		return hex.EncodeToString(b[:12]), 12, nil
+	case bsonBool:
+		if err := need(1); err != nil {
+			return nil, 0, err
+		}
+		return b[0] != 0, 1, nil
+	case bsonDateTime:
+		if err := need(8); err != nil {
+			return nil, 0, err
+		}
+		ms := int64(binary.LittleEndian.Uint64(b))
+		return time.Unix(ms/1000, ms%1000*int64(time.Millisecond)).UTC(), 8, nil
+	case bsonNull:
+		return nil, 0, nil
+	case bsonInt32:
+		if err := need(4); err != nil {
+			return nil, 0, err
+		}
+		return int32(binary.LittleEndian.Uint32(b)), 4, nil
+	case bsonTimestamp:
+		if err := need(8); err != nil {
+			return nil, 0, err
+		}
+		return binary.LittleEndian.Uint64(b), 8, nil
+	case bsonInt64:
+		if err := need(8); err != nil {
+			return nil, 0, err
+		}
+		return int64(binary.LittleEndian.Uint64(b)), 8, nil
+	}
+	return nil, 0, fmt.Errorf("MongoDB reply holds unsupported BSON type 0x%02x", typ)
+}
+
+// cstring splits a NUL terminated string off b
+func cstring(b []byte) (string, []byte, error) {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i]), b[i+1:], nil
+		}
+	}
+	return "", nil, errMalformedBSON
+}
+
+// toInt returns a numeric BSON value as an int, and whether it was numeric
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
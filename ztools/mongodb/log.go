@@ -0,0 +1,32 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package mongodb
+
+// A MongoDBLog records the replies to isMaster and buildInfo. IsMaster is
+// the whole decoded reply and Raw its first 16KB as received.
+type MongoDBLog struct {
+	// The opcode the commands were sent with, OP_QUERY or OP_MSG. Servers
+	// that reject OP_QUERY are asked again with OP_MSG.
+	Opcode         string                 `json:"opcode,omitempty"`
+	LegacyRejected bool                   `json:"legacy_rejected,omitempty"`
+	IsMaster       map[string]interface{} `json:"is_master,omitempty"`
+	Raw            []byte                 `json:"raw,omitempty"`
+	RawTruncated   bool                   `json:"raw_truncated,omitempty"`
+	Version        string                 `json:"version,omitempty"`
+	MaxWireVersion int                    `json:"max_wire_version,omitempty"`
+	AuthRequired   bool                   `json:"auth_required"`
+	SetName        string                 `json:"set_name,omitempty"`
+	Hosts          []string               `json:"hosts,omitempty"`
+}
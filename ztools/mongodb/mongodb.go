@@ -0,0 +1,193 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package mongodb speaks enough of the MongoDB wire protocol to identify a
+// server: it sends isMaster, buildInfo and listDatabases and decodes the
+// few BSON types in the replies.
+package mongodb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Opcodes, https://www.mongodb.com/docs/manual/reference/mongodb-wire-protocol/
+const (
+	opReply = 1
+	opQuery = 2004
+	opMsg   = 2013
+)
+
+var opcodeNames = map[int32]string{
+	opQuery: "OP_QUERY",
+	opMsg:   "OP_MSG",
+}
+
+const (
+	headerLen = 16
+	// Replies longer than this are treated as malformed
+	maxMessageLength = 1 << 20
+	// How much of the isMaster reply is kept in the log
+	maxRawLength = 16 * 1024
+	// The query failure flag of OP_REPLY
+	replyQueryFailure = 0x2
+	codeUnauthorized  = 13
+)
+
+var errShortReply = errors.New("MongoDB reply too short")
+
+// A client sends commands on one connection, numbering the requests
+type client struct {
+	conn      net.Conn
+	opcode    int32
+	requestID int32
+}
+
+// command sends cmd, a document naming the command first, to the admin
+// database and returns the reply document and the reply as received
+func (c *client) command(cmd []bsonElement) (map[string]interface{}, []byte, error) {
+	c.requestID++
+	var body []byte
+	switch c.opcode {
+	case opQuery:
+		// flags, full collection name, number to skip and to return
+		body = appendInt32(nil, 0)
+		body = appendCString(body, "admin.$cmd")
+		body = appendInt32(body, 0)
+		body = appendInt32(body, -1)
+		body = append(body, encodeDocument(cmd)...)
+	case opMsg:
+		// flag bits and a body section with the database in $db
+		body = appendInt32(nil, 0)
+		body = append(body, 0)
+		body = append(body, encodeDocument(append(cmd, bsonElement{"$db", "admin"}))...)
+	}
+	msg := appendInt32(nil, int32(headerLen+len(body)))
+	msg = appendInt32(msg, c.requestID)
+	msg = appendInt32(msg, 0)
+	msg = appendInt32(msg, c.opcode)
+	if _, err := c.conn.Write(append(msg, body...)); err != nil {
+		return nil, nil, err
+	}
+
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return nil, nil, err
+	}
+	length := int(binary.LittleEndian.Uint32(header))
+	if length < headerLen || length > maxMessageLength {
+		return nil, nil, fmt.Errorf("MongoDB reply length %d invalid", length)
+	}
+	reply := make([]byte, length)
+	copy(reply, header)
+	if _, err := io.ReadFull(c.conn, reply[headerLen:]); err != nil {
+		return nil, reply, err
+	}
+	body = reply[headerLen:]
+	switch int32(binary.LittleEndian.Uint32(header[12:])) {
+	case opReply:
+		// flags, cursor ID, starting from and number returned
+		if len(body) < 20 {
+			return nil, reply, errShortReply
+		}
+		doc, _, err := decodeDocument(body[20:])
+		if err == nil && binary.LittleEndian.Uint32(body)&replyQueryFailure != 0 {
+			doc["ok"] = int32(0)
+		}
+		return doc, reply, err
+	case opMsg:
+		if len(body) < 5 || body[4] != 0 {
+			return nil, reply, errors.New("MongoDB OP_MSG reply without a body section")
+		}
+		doc, _, err := decodeDocument(body[5:])
+		return doc, reply, err
+	}
+	return nil, reply, fmt.Errorf("MongoDB reply has unexpected opcode %d", binary.LittleEndian.Uint32(header[12:]))
+}
+
+// commandOK reports whether a reply document reports success
+func commandOK(doc map[string]interface{}) bool {
+	ok, _ := toInt(doc["ok"])
+	return ok == 1
+}
+
+// commandError returns the error of a failed command
+func commandError(name string, doc map[string]interface{}) error {
+	code, _ := toInt(doc["code"])
+	msg, _ := doc["errmsg"].(string)
+	if msg == "" {
+		msg, _ = doc["$err"].(string)
+	}
+	return fmt.Errorf("MongoDB %s failed with code %d: %s", name, code, msg)
+}
+
+// GetMongoDBInfo sends isMaster with OP_QUERY, repeating it with OP_MSG if
+// the server rejects the legacy opcode, then buildInfo for the version and
+// listDatabases to learn whether the server requires authentication. The
+// database names are not recorded.
+func GetMongoDBInfo(logStruct *MongoDBLog, connection net.Conn) error {
+	c := &client{conn: connection, opcode: opQuery}
+	isMaster := []bsonElement{{"isMaster", int32(1)}}
+	doc, raw, err := c.command(isMaster)
+	// isMaster needs no privileges, so a failure means MongoDB 5.1 or later
+	// refusing OP_QUERY
+	if err == nil && !commandOK(doc) {
+		logStruct.LegacyRejected = true
+		c.opcode = opMsg
+		doc, raw, err = c.command(isMaster)
+	}
+	logStruct.Opcode = opcodeNames[c.opcode]
+	logStruct.Raw = raw
+	if len(raw) > maxRawLength {
+		logStruct.Raw = raw[:maxRawLength]
+		logStruct.RawTruncated = true
+	}
+	if err != nil {
+		return err
+	}
+	logStruct.IsMaster = doc
+	if !commandOK(doc) {
+		return commandError("isMaster", doc)
+	}
+	logStruct.MaxWireVersion, _ = toInt(doc["maxWireVersion"])
+	logStruct.SetName, _ = doc["setName"].(string)
+	if hosts, ok := doc["hosts"].([]interface{}); ok {
+		for _, h := range hosts {
+			if s, ok := h.(string); ok {
+				logStruct.Hosts = append(logStruct.Hosts, s)
+			}
+		}
+	}
+
+	if doc, _, err = c.command([]bsonElement{{"buildInfo", int32(1)}}); err != nil {
+		return err
+	}
+	if commandOK(doc) {
+		logStruct.Version, _ = doc["version"].(string)
+	}
+
+	if doc, _, err = c.command([]bsonElement{{"listDatabases", int32(1)}, {"nameOnly", true}}); err != nil {
+		return err
+	}
+	if !commandOK(doc) {
+		code, _ := toInt(doc["code"])
+		msg, _ := doc["errmsg"].(string)
+		logStruct.AuthRequired = code == codeUnauthorized || strings.Contains(msg, "requires authentication")
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package mongodb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"testing"
+)
+
+// testDocument encodes a document like encodeDocument, adding the doubles
+// and string arrays servers use in their replies
+func testDocument(elements []bsonElement) []byte {
+	var plain []bsonElement
+	var extra []byte
+	for _, e := range elements {
+		switch v := e.value.(type) {
+		case float64:
+			extra = append(extra, bsonDouble)
+			extra = appendCString(extra, e.key)
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+			extra = append(extra, b[:]...)
+		case []string:
+			var items []bsonElement
+			for i, s := range v {
+				items = append(items, bsonElement{string('0' + byte(i)), s})
+			}
+			extra = append(extra, bsonArray)
+			extra = appendCString(extra, e.key)
+			extra = append(extra, encodeDocument(items)...)
+		default:
+			plain = append(plain, e)
+		}
+	}
+	doc := encodeDocument(plain)
+	doc = append(doc[:len(doc)-1], extra...)
+	doc = append(doc, 0)
+	binary.LittleEndian.PutUint32(doc, uint32(len(doc)))
+	return doc
+}
+
+// fakeServer answers OP_QUERY with the error of a server without legacy
+// opcode support and OP_MSG commands from replies, by command name
+func fakeServer(t *testing.T, conn net.Conn, replies map[string][]bsonElement) {
+	defer conn.Close()
+	for {
+		header := make([]byte, headerLen)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(header)-headerLen)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		var reply []byte
+		switch binary.LittleEndian.Uint32(header[12:]) {
+		case opQuery:
+			doc := testDocument([]bsonElement{
+				{"ok", float64(0)},
+				{"errmsg", "Unsupported OP_QUERY command: isMaster"},
+				{"code", int32(352)},
+			})
+			reply = append(make([]byte, 20), doc...)
+			binary.LittleEndian.PutUint32(reply[16:], 1)
+			reply = append(appendInt32(appendInt32(appendInt32(appendInt32(nil, int32(headerLen+len(reply))), 1), 0), opReply), reply...)
+		case opMsg:
+			cmd, _, err := decodeDocument(body[5:])
+			if err != nil {
+				t.Errorf("Bad OP_MSG: %s", err.Error())
+				return
+			}
+			if cmd["$db"] != "admin" {
+				t.Errorf("Command sent to %v", cmd["$db"])
+			}
+			var name string
+			for n := range replies {
+				if cmd[n] != nil {
+					name = n
+				}
+			}
+			reply = append([]byte{0, 0, 0, 0, 0}, testDocument(replies[name])...)
+			reply = append(appendInt32(appendInt32(appendInt32(appendInt32(nil, int32(headerLen+len(reply))), 1), 0), opMsg), reply...)
+		}
+		conn.Write(reply)
+	}
+}
+
+func TestGetMongoDBInfoRetriesWithOPMsg(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go fakeServer(t, server, map[string][]bsonElement{
+		"isMaster": {
+			{"ismaster", true},
+			{"setName", "rs0"},
+			{"maxWireVersion", int32(21)},
+			{"hosts", []string{"db0.example.com:27017", "db1.example.com:27017"}},
+			{"ok", float64(1)},
+		},
+		"buildInfo": {{"version", "7.0.2"}, {"ok", float64(1)}},
+		"listDatabases": {
+			{"ok", float64(0)},
+			{"errmsg", "command listDatabases requires authentication"},
+			{"code", int32(13)},
+		},
+	})
+
+	log := new(MongoDBLog)
+	if err := GetMongoDBInfo(log, client); err != nil {
+		t.Fatalf("GetMongoDBInfo: %s", err.Error())
+	}
+	if !log.LegacyRejected || log.Opcode != "OP_MSG" {
+		t.Errorf("No OP_MSG retry: %+v", log)
+	}
+	if log.Version != "7.0.2" || log.MaxWireVersion != 21 || log.SetName != "rs0" || len(log.Hosts) != 2 || !log.AuthRequired {
+		t.Errorf("Wrong log: %+v", log)
+	}
+	if log.IsMaster["ismaster"] != true || !bytes.Contains(log.Raw, []byte("rs0")) || log.RawTruncated {
+		t.Errorf("Reply not recorded: %+v, %q", log.IsMaster, log.Raw)
+	}
+}
+
+func TestDecodeDocumentMalformed(t *testing.T) {
+	doc := testDocument([]bsonElement{{"version", "7.0.2"}})
+	for _, b := range [][]byte{doc[:len(doc)-1], doc[:10], append(doc[:4:4], 0x13, 'x', 0, 0)} {
+		if _, _, err := decodeDocument(b); err == nil {
+			t.Errorf("Malformed document %x decoded", b)
+		}
+	}
+}
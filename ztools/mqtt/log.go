@@ -0,0 +1,25 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package mqtt
+
+// An MQTTLog records the CONNACK a broker sends for an anonymous CONNECT
+type MQTTLog struct {
+	ClientID       string `json:"client_id"`
+	RawConnAck     []byte `json:"raw_connack,omitempty"`
+	SessionPresent bool   `json:"session_present"`
+	ReturnCode     int    `json:"return_code"`
+	Return         string `json:"return,omitempty"`
+	Outcome        string `json:"outcome,omitempty"`
+}
@@ -0,0 +1,117 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package mqtt sends an MQTT 3.1.1 CONNECT without credentials and decodes
+// the broker's CONNACK
+package mqtt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Packet types, in the high nibble of the fixed header
+const (
+	packetConnect    = 0x10
+	packetConnAck    = 0x20
+	packetDisconnect = 0xe0
+)
+
+// Protocol level 4 is MQTT 3.1.1
+const protocolLevel = 4
+
+// CONNACK return codes, MQTT 3.1.1 section 3.2.2.3
+var returnCodes = []string{
+	"accepted",
+	"unacceptable_protocol_version",
+	"identifier_rejected",
+	"server_unavailable",
+	"bad_user_name_or_password",
+	"not_authorized",
+}
+
+// Outcomes of the CONNECT
+const (
+	OutcomeAccepted           = "accepted"
+	OutcomeAuthRequired       = "auth_required"
+	OutcomeIdentifierRejected = "identifier_rejected"
+	OutcomeRefused            = "refused"
+)
+
+var errNotMQTT = errors.New("Not an MQTT CONNACK")
+
+// clientID returns a random client identifier of at most 23 characters,
+// the longest every broker must accept
+func clientID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "zgrab" + hex.EncodeToString(b)
+}
+
+func connectPacket(id string) []byte {
+	variable := []byte{0, 4, 'M', 'Q', 'T', 'T', protocolLevel, 0x02, 0, 60}
+	payload := append([]byte{byte(len(id) >> 8), byte(len(id))}, id...)
+	// The remaining length always fits the one byte encoding here
+	packet := []byte{packetConnect, byte(len(variable) + len(payload))}
+	packet = append(packet, variable...)
+	return append(packet, payload...)
+}
+
+func outcome(code int) string {
+	switch code {
+	case 0:
+		return OutcomeAccepted
+	case 4, 5:
+		return OutcomeAuthRequired
+	case 2:
+		return OutcomeIdentifierRejected
+	}
+	return OutcomeRefused
+}
+
+// Connect sends a CONNECT with a clean session and a random client ID and
+// records the CONNACK, disconnecting again if the broker accepted it
+func Connect(logStruct *MQTTLog, conn net.Conn) error {
+	logStruct.ClientID = clientID()
+	if _, err := conn.Write(connectPacket(logStruct.ClientID)); err != nil {
+		return err
+	}
+
+	connAck := make([]byte, 4)
+	if _, err := io.ReadFull(conn, connAck); err != nil {
+		return err
+	}
+	logStruct.RawConnAck = connAck
+	if connAck[0] != packetConnAck || connAck[1] != 2 || connAck[2]&^1 != 0 {
+		return errNotMQTT
+	}
+	logStruct.SessionPresent = connAck[2] == 1
+	logStruct.ReturnCode = int(connAck[3])
+	if logStruct.ReturnCode < len(returnCodes) {
+		logStruct.Return = returnCodes[logStruct.ReturnCode]
+	} else {
+		logStruct.Return = fmt.Sprintf("unknown_%d", logStruct.ReturnCode)
+	}
+	logStruct.Outcome = outcome(logStruct.ReturnCode)
+
+	if logStruct.ReturnCode == 0 {
+		_, err := conn.Write([]byte{packetDisconnect, 0})
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package mqtt
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// serve reads a CONNECT, answers with connAck and then reads what follows
+// until the client closes
+func serve(conn net.Conn, connAck []byte) <-chan []byte {
+	sent := make(chan []byte, 1)
+	go func() {
+		header := make([]byte, 2)
+		io.ReadFull(conn, header)
+		rest := make([]byte, header[1])
+		io.ReadFull(conn, rest)
+		conn.Write(connAck)
+		after, _ := ioutil.ReadAll(conn)
+		sent <- append(append(header, rest...), after...)
+	}()
+	return sent
+}
+
+func TestConnectAccepted(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	sent := serve(server, []byte{packetConnAck, 2, 0, 0})
+
+	log := new(MQTTLog)
+	err := Connect(log, client)
+	client.Close()
+	if err != nil {
+		t.Fatalf("Connect: %s", err.Error())
+	}
+	packets := <-sent
+	if string(packets[4:8]) != "MQTT" || packets[8] != protocolLevel || string(packets[14:14+len(log.ClientID)]) != log.ClientID {
+		t.Errorf("Wrong CONNECT: %x", packets)
+	}
+	if len(log.ClientID) > 23 {
+		t.Errorf("Client ID %s too long", log.ClientID)
+	}
+	if tail := packets[len(packets)-2:]; tail[0] != packetDisconnect || tail[1] != 0 {
+		t.Errorf("No DISCONNECT sent: %x", packets)
+	}
+	if log.Outcome != OutcomeAccepted || log.Return != "accepted" {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
+
+func TestConnectNotAuthorized(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	sent := serve(server, []byte{packetConnAck, 2, 0, 5})
+
+	log := new(MQTTLog)
+	err := Connect(log, client)
+	client.Close()
+	if err != nil {
+		t.Fatalf("Connect: %s", err.Error())
+	}
+	if packets := <-sent; packets[len(packets)-2] == packetDisconnect {
+		t.Error("DISCONNECT sent after a refusal")
+	}
+	if log.Outcome != OutcomeAuthRequired || log.ReturnCode != 5 || log.Return != "not_authorized" {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
+
+func TestConnectNotMQTT(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	serve(server, []byte("HTTP/1.1 400"))
+
+	log := new(MQTTLog)
+	if err := Connect(log, client); err != errNotMQTT {
+		t.Errorf("Expected errNotMQTT, got %v", err)
+	}
+	if string(log.RawConnAck) != "HTTP" {
+		t.Errorf("Raw reply not recorded: %q", log.RawConnAck)
+	}
+}
@@ -0,0 +1,28 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package mysql
+
+type MySQLLog struct {
+	ProtocolVersion byte   `json:"protocol_version"`
+	ServerVersion   string `json:"server_version,omitempty"`
+	ConnectionID    uint32 `json:"connection_id"`
+	CapabilityFlags uint32 `json:"capability_flags"`
+	CharacterSet    byte   `json:"character_set"`
+	StatusFlags     uint16 `json:"status_flags"`
+	AuthPluginName  string `json:"auth_plugin_name,omitempty"`
+	SupportsTLS     bool   `json:"supports_tls"`
+	ErrorCode       uint16 `json:"error_code,omitempty"`
+	ErrorMessage    string `json:"error_message,omitempty"`
+}
@@ -0,0 +1,145 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Capability flags used during the handshake
+const (
+	CLIENT_PROTOCOL_41       = 0x00000200
+	CLIENT_SSL               = 0x00000800
+	CLIENT_SECURE_CONNECTION = 0x00008000
+	CLIENT_PLUGIN_AUTH       = 0x00080000
+)
+
+// Packets longer than this are treated as malformed
+const maxPacketLength = 64 * 1024
+
+var errShortPacket = errors.New("MySQL handshake packet too short")
+
+// readPacket reads one packet, returning its payload and sequence id
+func readPacket(connection net.Conn) ([]byte, byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(connection, header); err != nil {
+		return nil, 0, err
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if length > maxPacketLength {
+		return nil, 0, fmt.Errorf("MySQL packet length %d too large", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(connection, payload); err != nil {
+		return nil, 0, err
+	}
+	return payload, header[3], nil
+}
+
+// parseHandshake decodes an initial handshake packet (protocol 10) or an
+// error packet into logStruct
+func parseHandshake(logStruct *MySQLLog, payload []byte) error {
+	if len(payload) == 0 {
+		return errShortPacket
+	}
+	if payload[0] == 0xff {
+		if len(payload) < 3 {
+			return errShortPacket
+		}
+		logStruct.ErrorCode = binary.LittleEndian.Uint16(payload[1:3])
+		logStruct.ErrorMessage = string(payload[3:])
+		return fmt.Errorf("MySQL error %d: %s", logStruct.ErrorCode, logStruct.ErrorMessage)
+	}
+
+	logStruct.ProtocolVersion = payload[0]
+	rest := payload[1:]
+	end := bytes.IndexByte(rest, 0)
+	if end < 0 {
+		return errShortPacket
+	}
+	logStruct.ServerVersion = string(rest[0:end])
+	rest = rest[end+1:]
+
+	// thread id, auth-plugin-data-part-1, filler, lower capability flags
+	if len(rest) < 4+8+1+2 {
+		return errShortPacket
+	}
+	logStruct.ConnectionID = binary.LittleEndian.Uint32(rest[0:4])
+	logStruct.CapabilityFlags = uint32(binary.LittleEndian.Uint16(rest[13:15]))
+	rest = rest[15:]
+
+	// Older servers stop after the lower capability flags
+	if len(rest) >= 1+2+2+1+10 {
+		logStruct.CharacterSet = rest[0]
+		logStruct.StatusFlags = binary.LittleEndian.Uint16(rest[1:3])
+		logStruct.CapabilityFlags |= uint32(binary.LittleEndian.Uint16(rest[3:5])) << 16
+		authDataLength := int(rest[5])
+		rest = rest[16:]
+
+		// auth-plugin-data-part-2 is at least 13 bytes, the plugin name
+		// follows it
+		if logStruct.CapabilityFlags&CLIENT_SECURE_CONNECTION != 0 {
+			part2 := authDataLength - 8
+			if part2 < 13 {
+				part2 = 13
+			}
+			if part2 > len(rest) {
+				part2 = len(rest)
+			}
+			rest = rest[part2:]
+		}
+		if logStruct.CapabilityFlags&CLIENT_PLUGIN_AUTH != 0 {
+			if end := bytes.IndexByte(rest, 0); end >= 0 {
+				rest = rest[0:end]
+			}
+			logStruct.AuthPluginName = string(rest)
+		}
+	}
+
+	logStruct.SupportsTLS = logStruct.CapabilityFlags&CLIENT_SSL != 0
+	return nil
+}
+
+// GetMySQLBanner reads and parses the server's initial handshake packet
+func GetMySQLBanner(logStruct *MySQLLog, connection net.Conn) error {
+	payload, _, err := readPacket(connection)
+	if err != nil {
+		return err
+	}
+	return parseHandshake(logStruct, payload)
+}
+
+// SendSSLRequest asks the server to switch to TLS. The TLS handshake must
+// follow immediately.
+func SendSSLRequest(logStruct *MySQLLog, connection net.Conn) error {
+	packet := make([]byte, 4+32)
+	packet[0] = 32
+	packet[3] = 1
+	flags := uint32(CLIENT_PROTOCOL_41 | CLIENT_SSL | CLIENT_SECURE_CONNECTION)
+	binary.LittleEndian.PutUint32(packet[4:8], flags)
+	binary.LittleEndian.PutUint32(packet[8:12], maxPacketLength)
+	packet[12] = logStruct.CharacterSet
+	if packet[12] == 0 {
+		// utf8_general_ci
+		packet[12] = 33
+	}
+	_, err := connection.Write(packet)
+	return err
+}
@@ -0,0 +1,259 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package ntp encodes an NTP client request and decodes the server's reply,
+// RFC 5905, and reads the system variables with a mode 6 control query,
+// RFC 1305 appendix B
+package ntp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Association modes
+const (
+	ModeClient  = 3
+	ModeServer  = 4
+	ModeControl = 6
+)
+
+// KissRate is the kiss code of a server telling the client to slow down
+const KissRate = "RATE"
+
+const packetLen = 48
+
+// Seconds from the NTP era 0 epoch, 1900, to the Unix epoch
+const unixOffset = 2208988800
+
+var errShort = errors.New("ntp: short packet")
+
+// A Response is an NTP server's reply to a client request
+type Response struct {
+	LeapIndicator  uint8     `json:"leap_indicator"`
+	Version        uint8     `json:"version"`
+	Mode           uint8     `json:"mode"`
+	Stratum        uint8     `json:"stratum"`
+	Poll           int8      `json:"poll"`
+	Precision      int8      `json:"precision"`
+	RootDelay      float64   `json:"root_delay"`
+	RootDispersion float64   `json:"root_dispersion"`
+	ReferenceID    string    `json:"reference_id"`
+	ReferenceTime  time.Time `json:"reference_time"`
+	ReceiveTime    time.Time `json:"receive_time"`
+	TransmitTime   time.Time `json:"transmit_time"`
+
+	// A stratum 0 reply is a Kiss-o'-Death packet whose reference ID is a
+	// kiss code, e.g. RATE when the server rate limits the client
+	KissCode    string `json:"kiss_code,omitempty"`
+	RateLimited bool   `json:"rate_limited,omitempty"`
+}
+
+// ClientRequest returns a version 4 client mode request with the transmit
+// timestamp set to now, which the server echoes as its origin timestamp
+func ClientRequest(now time.Time) []byte {
+	b := make([]byte, packetLen)
+	b[0] = 4<<3 | ModeClient
+	putTimestamp(b[40:], now)
+	return b
+}
+
+// ParseResponse decodes the reply to request
+func ParseResponse(request, b []byte) (*Response, error) {
+	if len(b) < packetLen {
+		return nil, errShort
+	}
+	res := &Response{
+		LeapIndicator:  b[0] >> 6,
+		Version:        (b[0] >> 3) & 0x7,
+		Mode:           b[0] & 0x7,
+		Stratum:        b[1],
+		Poll:           int8(b[2]),
+		Precision:      int8(b[3]),
+		RootDelay:      shortFormat(b[4:]),
+		RootDispersion: shortFormat(b[8:]),
+		ReferenceTime:  timestamp(b[16:]),
+		ReceiveTime:    timestamp(b[32:]),
+		TransmitTime:   timestamp(b[40:]),
+	}
+	if res.Mode != ModeServer {
+		return res, fmt.Errorf("ntp: reply in mode %d", res.Mode)
+	}
+	if len(request) >= packetLen && string(b[24:32]) != string(request[40:48]) {
+		return res, errors.New("ntp: origin timestamp does not match the request")
+	}
+	res.ReferenceID = referenceID(res.Stratum, b[12:16])
+	if res.Stratum == 0 {
+		res.KissCode = res.ReferenceID
+		res.RateLimited = res.KissCode == KissRate
+	}
+	return res, nil
+}
+
+// referenceID returns the ASCII source name of a primary server, e.g. GPS,
+// the kiss code of a stratum 0 reply, or the IPv4 address of the upstream
+// server otherwise
+func referenceID(stratum uint8, id []byte) string {
+	if stratum <= 1 {
+		n := 0
+		for n < len(id) && id[n] != 0 {
+			n++
+		}
+		return string(id[:n])
+	}
+	return net.IP(id).String()
+}
+
+// shortFormat decodes a 16.16 fixed point number of seconds
+func shortFormat(b []byte) float64 {
+	return float64(binary.BigEndian.Uint32(b)) / 65536
+}
+
+// timestamp decodes a 64-bit NTP timestamp, zero meaning unset
+func timestamp(b []byte) time.Time {
+	secs := binary.BigEndian.Uint32(b)
+	frac := binary.BigEndian.Uint32(b[4:])
+	if secs == 0 && frac == 0 {
+		return time.Time{}
+	}
+	nanos := (int64(frac) * int64(time.Second)) >> 32
+	return time.Unix(int64(secs)-unixOffset, nanos).UTC()
+}
+
+func putTimestamp(b []byte, t time.Time) {
+	secs := uint32(t.Unix() + unixOffset)
+	frac := uint32((int64(t.Nanosecond()) << 32) / int64(time.Second))
+	binary.BigEndian.PutUint32(b, secs)
+	binary.BigEndian.PutUint32(b[4:], frac)
+}
+
+// Control message header bits and opcodes
+const (
+	controlHeaderLen = 12
+	controlResponse  = 0x80
+	controlError     = 0x40
+	controlMore      = 0x20
+	opReadVariables  = 2
+	// Control messages carry at most this much data, RFC 1305 appendix B
+	maxControlData = 468
+	// The most fragments accepted in one reply
+	maxControlFragments = 32
+)
+
+// ReadVarRequest returns a READVAR control request for the system
+// variables, as sent by ntpq -c rv
+func ReadVarRequest(sequence uint16) []byte {
+	b := make([]byte, controlHeaderLen)
+	b[0] = 2<<3 | ModeControl
+	b[1] = opReadVariables
+	binary.BigEndian.PutUint16(b[2:], sequence)
+	return b
+}
+
+// A ControlResponse is the reply to a READVAR request. The system status
+// word is split into its fields and the variables, e.g. version="ntpd
+// 4.2.8p15", stratum=2, are kept as sent.
+type ControlResponse struct {
+	LeapIndicator uint8  `json:"leap_indicator"`
+	ClockSource   uint8  `json:"clock_source"`
+	EventCount    uint8  `json:"event_count"`
+	EventCode     uint8  `json:"event_code"`
+	Variables     string `json:"variables,omitempty"`
+}
+
+// A ControlError is returned when the server answers a control request
+// with the error bit set
+type ControlError struct {
+	Code uint8
+}
+
+func (e *ControlError) Error() string {
+	return fmt.Sprintf("ntp: control request failed with error code %d", e.Code)
+}
+
+// A ControlReader reassembles the reply to a control request from its
+// fragments, which may arrive in any order
+type ControlReader struct {
+	Sequence uint16
+
+	status    uint16
+	fragments map[int][]byte
+	end       int
+	sawLast   bool
+}
+
+// Add files a datagram of the reply. It returns true once the whole reply
+// has been received. Datagrams that do not answer the request are ignored.
+func (r *ControlReader) Add(b []byte) (bool, error) {
+	if len(b) < controlHeaderLen || b[0]&0x7 != ModeControl || b[1]&controlResponse == 0 ||
+		b[1]&0x1f != opReadVariables || binary.BigEndian.Uint16(b[2:]) != r.Sequence {
+		return false, nil
+	}
+	status := binary.BigEndian.Uint16(b[4:])
+	if b[1]&controlError != 0 {
+		return true, &ControlError{Code: uint8(status >> 8)}
+	}
+	offset := int(binary.BigEndian.Uint16(b[8:]))
+	count := int(binary.BigEndian.Uint16(b[10:]))
+	if count > maxControlData || controlHeaderLen+count > len(b) {
+		return true, errShort
+	}
+	if r.fragments == nil {
+		r.fragments = make(map[int][]byte)
+	}
+	if len(r.fragments) >= maxControlFragments {
+		return true, errors.New("ntp: too many control fragments")
+	}
+	r.status = status
+	r.fragments[offset] = append([]byte(nil), b[controlHeaderLen:controlHeaderLen+count]...)
+	if b[1]&controlMore == 0 {
+		r.sawLast = true
+		r.end = offset + count
+	}
+	return r.complete(), nil
+}
+
+// complete reports whether the fragments cover the reply without gaps
+func (r *ControlReader) complete() bool {
+	if !r.sawLast {
+		return false
+	}
+	for off := 0; off < r.end; {
+		frag, ok := r.fragments[off]
+		if !ok || len(frag) == 0 {
+			return false
+		}
+		off += len(frag)
+	}
+	return true
+}
+
+// Response returns the reassembled reply
+func (r *ControlReader) Response() *ControlResponse {
+	var data []byte
+	for off := 0; off < r.end; off += len(r.fragments[off]) {
+		data = append(data, r.fragments[off]...)
+	}
+	return &ControlResponse{
+		LeapIndicator: uint8(r.status >> 14),
+		ClockSource:   uint8(r.status>>8) & 0x3f,
+		EventCount:    uint8(r.status>>4) & 0xf,
+		EventCode:     uint8(r.status) & 0xf,
+		Variables:     strings.TrimRight(string(data), "\x00\r\n"),
+	}
+}
@@ -0,0 +1,54 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package postgres
+
+type PostgresLog struct {
+	SSLResponse  []byte `json:"ssl_response,omitempty"`
+	SupportsSSL  bool   `json:"supports_ssl"`
+	OtherMessage []byte `json:"other_message,omitempty"`
+
+	Startup *StartupLog `json:"startup,omitempty"`
+}
+
+// A StartupLog records the server's reply to a StartupMessage for a user
+// that should not exist. Most servers answer with an authentication request
+// or a FATAL ErrorResponse; a trust entry for any user yields AuthenticationOk
+// followed by the run-time parameters, server_version among them.
+type StartupLog struct {
+	ProtocolVersion      string            `json:"protocol_version"`
+	RejectedVersions     []string          `json:"rejected_versions,omitempty"`
+	AuthenticationType   uint32            `json:"authentication_type,omitempty"`
+	AuthenticationMethod string            `json:"authentication_method,omitempty"`
+	SASLMechanisms       []string          `json:"sasl_mechanisms,omitempty"`
+	NegotiatedMinor      uint32            `json:"negotiated_minor_version,omitempty"`
+	UnrecognizedOptions  []string          `json:"unrecognized_options,omitempty"`
+	Parameters           map[string]string `json:"parameters,omitempty"`
+	ServerVersion        string            `json:"server_version,omitempty"`
+	Error                *ErrorFields      `json:"error,omitempty"`
+}
+
+// ErrorFields are the fields of an ErrorResponse, protocol section 53.8.
+// File, Line and Routine point into the server source and so vary between
+// releases.
+type ErrorFields struct {
+	Severity string `json:"severity,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Hint     string `json:"hint,omitempty"`
+	File     string `json:"file,omitempty"`
+	Line     string `json:"line,omitempty"`
+	Routine  string `json:"routine,omitempty"`
+}
@@ -0,0 +1,63 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package postgres
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// sslRequestCode is the protocol version sent in an SSLRequest message
+const sslRequestCode = 80877103
+
+// Bytes read after an unexpected reply, e.g. an ErrorResponse
+const maxOtherMessageLength = 1024
+
+func makeSSLRequest() []byte {
+	req := make([]byte, 8)
+	binary.BigEndian.PutUint32(req[0:4], 8)
+	binary.BigEndian.PutUint32(req[4:8], sslRequestCode)
+	return req
+}
+
+// SetupSSL sends an SSLRequest and reports whether the server answered 'S'.
+// An 'N' is not an error. Any other reply is returned as an error after the
+// rest of the message has been recorded.
+func SetupSSL(logStruct *PostgresLog, connection net.Conn) (bool, error) {
+	if _, err := connection.Write(makeSSLRequest()); err != nil {
+		return false, err
+	}
+
+	resp := make([]byte, 1)
+	if _, err := io.ReadFull(connection, resp); err != nil {
+		return false, err
+	}
+	logStruct.SSLResponse = resp
+
+	switch resp[0] {
+	case 'S':
+		logStruct.SupportsSSL = true
+		return true, nil
+	case 'N':
+		return false, nil
+	}
+
+	other := make([]byte, maxOtherMessageLength)
+	n, _ := connection.Read(other)
+	logStruct.OtherMessage = other[0:n]
+	return false, fmt.Errorf("Unexpected response %q to SSLRequest", resp[0])
+}
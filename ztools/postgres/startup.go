@@ -0,0 +1,260 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package postgres
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Protocol versions of a StartupMessage, major in the high 16 bits
+const (
+	ProtocolVersion32 = 3<<16 | 2
+	ProtocolVersion3  = 3 << 16
+	ProtocolVersion2  = 2 << 16
+)
+
+// StartupVersions are tried in order, each after the server refused the
+// one before. Servers predating NegotiateProtocolVersion refuse 3.2 with an
+// ErrorResponse instead of downgrading.
+var StartupVersions = []uint32{ProtocolVersion32, ProtocolVersion3, ProtocolVersion2}
+
+// The user and database sent, which should not exist on the server
+const startupUser = "zgrab"
+
+// Messages read at most after AuthenticationOk, and the longest accepted
+const (
+	maxStartupMessages = 64
+	maxMessageLength   = 16 * 1024
+)
+
+// The SQLSTATE of "unsupported frontend protocol"
+const featureNotSupported = "0A000"
+
+var authenticationMethods = map[uint32]string{
+	0:  "ok",
+	2:  "kerberos_v5",
+	3:  "cleartext_password",
+	5:  "md5_password",
+	6:  "scm_credential",
+	7:  "gss",
+	9:  "sspi",
+	10: "sasl",
+}
+
+var errNotPostgres = errors.New("Not a Postgres response")
+
+// A VersionError is returned when the server refuses the protocol version
+// of the StartupMessage, so it may be retried with a lower one
+type VersionError struct {
+	Version string
+	Message string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("Postgres protocol version %s refused: %s", e.Version, e.Message)
+}
+
+func versionString(version uint32) string {
+	return fmt.Sprintf("%d.%d", version>>16, version&0xffff)
+}
+
+func makeStartupMessage(version uint32) []byte {
+	var params []byte
+	if version >= ProtocolVersion3 {
+		params = []byte("user\x00" + startupUser + "\x00database\x00" + startupUser + "\x00\x00")
+	} else {
+		// The 2.0 message has fixed 64 byte database and user fields
+		// followed by unused options, tty and the 64 byte unused field
+		params = make([]byte, 64+32+64+64+64)
+		copy(params, startupUser)
+		copy(params[64:], startupUser)
+	}
+	msg := make([]byte, 8, 8+len(params))
+	binary.BigEndian.PutUint32(msg[0:4], uint32(8+len(params)))
+	binary.BigEndian.PutUint32(msg[4:8], version)
+	return append(msg, params...)
+}
+
+// readMessage reads a 3.0 message, a type byte then a length including
+// itself
+func readMessage(conn net.Conn) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length < 4 || length > maxMessageLength {
+		return 0, nil, errNotPostgres
+	}
+	body := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+// cstrings splits a run of NUL terminated strings, stopping at an empty one
+func cstrings(b []byte) []string {
+	var s []string
+	for len(b) > 0 {
+		end := bytes.IndexByte(b, 0)
+		if end <= 0 {
+			break
+		}
+		s = append(s, string(b[:end]))
+		b = b[end+1:]
+	}
+	return s
+}
+
+func parseErrorFields(body []byte) *ErrorFields {
+	fields := new(ErrorFields)
+	for len(body) > 1 && body[0] != 0 {
+		end := bytes.IndexByte(body[1:], 0)
+		if end < 0 {
+			break
+		}
+		value := string(body[1 : 1+end])
+		switch body[0] {
+		case 'V':
+			fields.Severity = value
+		case 'S':
+			if fields.Severity == "" {
+				fields.Severity = value
+			}
+		case 'C':
+			fields.Code = value
+		case 'M':
+			fields.Message = value
+		case 'D':
+			fields.Detail = value
+		case 'H':
+			fields.Hint = value
+		case 'F':
+			fields.File = value
+		case 'L':
+			fields.Line = value
+		case 'R':
+			fields.Routine = value
+		}
+		body = body[2+end:]
+	}
+	return fields
+}
+
+func (logStruct *StartupLog) authentication(authType uint32, rest []byte) {
+	logStruct.AuthenticationType = authType
+	logStruct.AuthenticationMethod = authenticationMethods[authType]
+	if logStruct.AuthenticationMethod == "" {
+		logStruct.AuthenticationMethod = fmt.Sprintf("unknown_%d", authType)
+	}
+	if authType == 10 {
+		logStruct.SASLMechanisms = cstrings(rest)
+	}
+}
+
+// Startup sends a StartupMessage with the given protocol version and records
+// the reply. The exchange ends at the first authentication request other
+// than AuthenticationOk, an ErrorResponse or ReadyForQuery; the client never
+// authenticates. A *VersionError means the version was refused.
+func Startup(logStruct *StartupLog, conn net.Conn, version uint32) error {
+	logStruct.ProtocolVersion = versionString(version)
+	if _, err := conn.Write(makeStartupMessage(version)); err != nil {
+		return err
+	}
+	if version < ProtocolVersion3 {
+		return startupV2(logStruct, conn)
+	}
+
+	for i := 0; i < maxStartupMessages; i++ {
+		msgType, body, err := readMessage(conn)
+		if err != nil {
+			return err
+		}
+		switch msgType {
+		case 'R':
+			if len(body) < 4 {
+				return errNotPostgres
+			}
+			logStruct.authentication(binary.BigEndian.Uint32(body), body[4:])
+			if logStruct.AuthenticationType != 0 {
+				return nil
+			}
+		case 'E':
+			logStruct.Error = parseErrorFields(body)
+			if logStruct.Error.Code == featureNotSupported {
+				logStruct.RejectedVersions = append(logStruct.RejectedVersions, logStruct.ProtocolVersion)
+				return &VersionError{Version: logStruct.ProtocolVersion, Message: logStruct.Error.Message}
+			}
+			return nil
+		case 'v':
+			// NegotiateProtocolVersion: newest minor version supported
+			// and the options the server did not recognize
+			if len(body) < 8 {
+				return errNotPostgres
+			}
+			logStruct.NegotiatedMinor = binary.BigEndian.Uint32(body)
+			logStruct.UnrecognizedOptions = cstrings(body[8:])
+		case 'S':
+			kv := cstrings(body)
+			if len(kv) != 2 {
+				continue
+			}
+			if logStruct.Parameters == nil {
+				logStruct.Parameters = make(map[string]string)
+			}
+			logStruct.Parameters[kv[0]] = kv[1]
+			if kv[0] == "server_version" {
+				logStruct.ServerVersion = kv[1]
+			}
+		case 'Z':
+			return nil
+		case 'K', 'N':
+		default:
+			return errNotPostgres
+		}
+	}
+	return nil
+}
+
+// startupV2 reads the reply to a 2.0 StartupMessage, whose messages carry no
+// length: an ErrorResponse is a single string
+func startupV2(logStruct *StartupLog, conn net.Conn) error {
+	header := make([]byte, 1)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	switch header[0] {
+	case 'R':
+		authType := make([]byte, 4)
+		if _, err := io.ReadFull(conn, authType); err != nil {
+			return err
+		}
+		logStruct.authentication(binary.BigEndian.Uint32(authType), nil)
+		return nil
+	case 'E':
+		b := make([]byte, maxMessageLength)
+		n, _ := conn.Read(b)
+		message := bytes.TrimRight(b[:n], "\x00\n")
+		logStruct.Error = &ErrorFields{Message: string(message)}
+		return nil
+	}
+	return errNotPostgres
+}
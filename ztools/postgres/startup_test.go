@@ -0,0 +1,136 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package postgres
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func message(msgType byte, body []byte) []byte {
+	m := []byte{msgType, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(m[1:], uint32(4+len(body)))
+	return append(m, body...)
+}
+
+// serve reads a StartupMessage and answers with replies, returning the
+// message
+func serve(conn net.Conn, replies ...[]byte) <-chan []byte {
+	sent := make(chan []byte, 1)
+	go func() {
+		header := make([]byte, 4)
+		io.ReadFull(conn, header)
+		rest := make([]byte, binary.BigEndian.Uint32(header)-4)
+		io.ReadFull(conn, rest)
+		for _, r := range replies {
+			conn.Write(r)
+		}
+		sent <- append(header, rest...)
+	}()
+	return sent
+}
+
+func TestStartupSASL(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	sent := serve(server, message('R', []byte("\x00\x00\x00\x0aSCRAM-SHA-256-PLUS\x00SCRAM-SHA-256\x00\x00")))
+
+	log := new(StartupLog)
+	if err := Startup(log, client, ProtocolVersion3); err != nil {
+		t.Fatalf("Startup: %s", err.Error())
+	}
+	startup := <-sent
+	if binary.BigEndian.Uint32(startup[4:]) != ProtocolVersion3 || !bytes.Contains(startup, []byte("user\x00zgrab\x00")) {
+		t.Errorf("Wrong StartupMessage: %q", startup)
+	}
+	if log.AuthenticationMethod != "sasl" || len(log.SASLMechanisms) != 2 || log.SASLMechanisms[1] != "SCRAM-SHA-256" {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
+
+func TestStartupErrorResponse(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	fields := "SFATAL\x00VFATAL\x00C28000\x00Mno pg_hba.conf entry for host \"192.0.2.1\", user \"zgrab\", database \"zgrab\", no encryption\x00Fauth.c\x00L543\x00RClientAuthentication\x00\x00"
+	serve(server, message('E', []byte(fields)))
+
+	log := new(StartupLog)
+	if err := Startup(log, client, ProtocolVersion3); err != nil {
+		t.Fatalf("Startup: %s", err.Error())
+	}
+	if e := log.Error; e == nil || e.Severity != "FATAL" || e.Code != "28000" || e.Line != "543" || e.Routine != "ClientAuthentication" {
+		t.Errorf("Wrong error fields: %+v", log.Error)
+	}
+}
+
+func TestStartupTrust(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	serve(server,
+		message('v', []byte("\x00\x00\x00\x00\x00\x00\x00\x00")),
+		message('R', []byte{0, 0, 0, 0}),
+		message('S', []byte("server_version\x0016.2\x00")),
+		message('S', []byte("server_encoding\x00UTF8\x00")),
+		message('K', []byte{0, 0, 0, 1, 0, 0, 0, 2}),
+		message('Z', []byte("I")))
+
+	log := new(StartupLog)
+	if err := Startup(log, client, ProtocolVersion32); err != nil {
+		t.Fatalf("Startup: %s", err.Error())
+	}
+	if log.ProtocolVersion != "3.2" || log.AuthenticationMethod != "ok" || log.ServerVersion != "16.2" || len(log.Parameters) != 2 {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
+
+func TestStartupVersionRefused(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	serve(server, message('E', []byte("SFATAL\x00C0A000\x00Munsupported frontend protocol 3.2: server supports 3.0 to 3.0\x00\x00")))
+
+	log := new(StartupLog)
+	err := Startup(log, client, ProtocolVersion32)
+	if verr, ok := err.(*VersionError); !ok || verr.Version != "3.2" {
+		t.Fatalf("Expected a VersionError, got %v", err)
+	}
+	if len(log.RejectedVersions) != 1 || log.RejectedVersions[0] != "3.2" {
+		t.Errorf("Wrong rejected versions: %v", log.RejectedVersions)
+	}
+}
+
+func TestStartupVersion2(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	sent := serve(server, []byte{'R', 0, 0, 0, 5})
+
+	log := new(StartupLog)
+	if err := Startup(log, client, ProtocolVersion2); err != nil {
+		t.Fatalf("Startup: %s", err.Error())
+	}
+	if startup := <-sent; len(startup) != 296 || string(startup[8:13]) != "zgrab" {
+		t.Errorf("Wrong 2.0 StartupMessage: %q", startup)
+	}
+	if log.AuthenticationMethod != "md5_password" {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package processing
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Transforms that may follow a CSV field path after a "|"
+const (
+	CSVFirstLine = "first_line"
+	CSVPort      = "port"
+)
+
+// A CSVField selects one column of a CSVWriter's output
+type CSVField struct {
+	Path      []string
+	Transform string
+}
+
+// ParseCSVField parses a dotted path into the JSON form of a result, e.g.
+// "data.tls.server_hello.version.name", optionally followed by "|first_line"
+// to keep the first line of a string or "|port" to keep the port of a
+// host:port. Array elements are selected by index.
+func ParseCSVField(spec string) (CSVField, error) {
+	var f CSVField
+	path := spec
+	if i := strings.Index(spec, "|"); i >= 0 {
+		path, f.Transform = spec[:i], spec[i+1:]
+		switch f.Transform {
+		case CSVFirstLine, CSVPort:
+		default:
+			return f, &CSVFieldError{Field: spec, Reason: "unknown transform " + f.Transform}
+		}
+	}
+	f.Path = strings.Split(path, ".")
+	for _, part := range f.Path {
+		if part == "" {
+			return f, &CSVFieldError{Field: spec, Reason: "empty path element"}
+		}
+	}
+	return f, nil
+}
+
+// A CSVFieldError reports a field that could not be parsed
+type CSVFieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *CSVFieldError) Error() string {
+	return "invalid CSV field " + strconv.Quote(e.Field) + ": " + e.Reason
+}
+
+// A CSVWriter projects results onto a fixed set of columns, one row per
+// JSON value the marshaler produces, after a header row naming the fields.
+// Fields missing from a result are left empty.
+type CSVWriter struct {
+	*bufferedOutput
+	m      Marshaler
+	fields []CSVField
+}
+
+// NewCSVWriter writes the header row and returns a writer of rows to w,
+// which is not closed by Close. The header cells are the field specs.
+func NewCSVWriter(w io.Writer, m Marshaler, specs []string, opts OutputOptions) (*CSVWriter, error) {
+	cw := &CSVWriter{m: m}
+	for _, spec := range specs {
+		f, err := ParseCSVField(spec)
+		if err != nil {
+			return nil, err
+		}
+		cw.fields = append(cw.fields, f)
+	}
+	header, err := encodeCSVRecord(specs)
+	if err != nil {
+		return nil, err
+	}
+	cw.bufferedOutput = newBufferedOutput(w, opts)
+	return cw, cw.write(header)
+}
+
+func (cw *CSVWriter) Write(result interface{}) error {
+	enc, err := cw.m.Marshal(result)
+	if err != nil {
+		return err
+	}
+	var rows [][]byte
+	dec := json.NewDecoder(bytes.NewReader(enc))
+	dec.UseNumber()
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		record := make([]string, len(cw.fields))
+		for i, f := range cw.fields {
+			record[i] = f.value(v)
+		}
+		row, err := encodeCSVRecord(record)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	return cw.write(rows...)
+}
+
+// encodeCSVRecord quotes a record as one CSV line
+func encodeCSVRecord(record []string) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write(record)
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// value renders the field of the decoded JSON v as a cell
+func (f CSVField) value(v interface{}) string {
+	for _, part := range f.Path {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[part]
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return ""
+			}
+			v = node[i]
+		default:
+			return ""
+		}
+	}
+	var cell string
+	switch leaf := v.(type) {
+	case nil:
+		return ""
+	case string:
+		cell = leaf
+	case json.Number:
+		cell = leaf.String()
+	case bool:
+		cell = strconv.FormatBool(leaf)
+	default:
+		b, _ := json.Marshal(leaf)
+		cell = string(b)
+	}
+	switch f.Transform {
+	case CSVFirstLine:
+		if i := strings.IndexAny(cell, "\r\n"); i >= 0 {
+			cell = cell[:i]
+		}
+	case CSVPort:
+		_, port, err := net.SplitHostPort(cell)
+		if err != nil {
+			return ""
+		}
+		cell = port
+	}
+	return cell
+}
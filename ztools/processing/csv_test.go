@@ -0,0 +1,59 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package processing
+
+import (
+	"bytes"
+	"testing"
+)
+
+type rawMarshaler struct{}
+
+func (rawMarshaler) Marshal(v interface{}) ([]byte, error) {
+	return []byte(v.(string)), nil
+}
+
+func TestCSVWriterProjectsFields(t *testing.T) {
+	var out bytes.Buffer
+	fields := []string{"ip", "data.port", "data.ok", "data.banner|first_line", "data.addr|port", "data.list.1", "data.obj", "data.missing.deep"}
+	w, err := NewCSVWriter(&out, rawMarshaler{}, fields, OutputOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(`{"ip":"192.0.2.1","data":{"port":25,"ok":true,"banner":"220 \"mx\", ready\r\nmore","addr":"[2001:db8::1]:443","list":["a","b"],"obj":{"k":1}}}`)
+	w.Write(`{"ip":"192.0.2.2"}` + "\n" + `{"ip":"192.0.2.3","data":{"ok":false,"addr":"nonsense"}}`)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "ip,data.port,data.ok,data.banner|first_line,data.addr|port,data.list.1,data.obj,data.missing.deep\n" +
+		`192.0.2.1,25,true,"220 ""mx"", ready",443,b,"{""k"":1}",` + "\n" +
+		"192.0.2.2,,,,,,,\n" +
+		"192.0.2.3,,false,,,,,\n"
+	if out.String() != expected {
+		t.Errorf("Wrong CSV output:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestParseCSVFieldErrors(t *testing.T) {
+	for _, spec := range []string{"", "data..banner", "data.banner|upper"} {
+		if _, err := ParseCSVField(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+	if _, err := NewCSVWriter(new(bytes.Buffer), rawMarshaler{}, []string{"ip", "a|b"}, OutputOptions{}); err == nil {
+		t.Errorf("Writer created with an invalid field")
+	}
+}
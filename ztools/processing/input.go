@@ -39,28 +39,19 @@ type Worker interface {
 
 type Handler func(interface{}) interface{}
 
-func Process(in Decoder, out io.Writer, w Worker, m Marshaler, workers uint) {
+func Process(in Decoder, out ResultWriter, w Worker, workers uint) {
+	ProcessUntil(in, out, w, workers, nil)
+}
+
+// ProcessUntil is Process, except that it stops reading input once stop is
+// closed. Targets already handed to a worker are still grabbed and written.
+func ProcessUntil(in Decoder, out ResultWriter, w Worker, workers uint, stop <-chan struct{}) {
 	processQueue := make(chan interface{}, workers*4)
-	outputQueue := make(chan []byte, workers*4)
 
-	// Create wait groups
+	// Create wait group
 	var workerDone sync.WaitGroup
-	var outputDone sync.WaitGroup
 	workerDone.Add(int(workers))
-	outputDone.Add(1)
 
-	// Start the output encoder
-	go func() {
-		for result := range outputQueue {
-			if _, err := out.Write(result); err != nil {
-				panic(err.Error())
-			}
-			if _, err := out.Write([]byte("\n")); err != nil {
-				panic(err.Error())
-			}
-		}
-		outputDone.Done()
-	}()
 	// Start all the workers
 	for i := uint(0); i < workers; i++ {
 		handler := w.MakeHandler(i)
@@ -68,30 +59,36 @@ func Process(in Decoder, out io.Writer, w Worker, m Marshaler, workers uint) {
 		go func(handler Handler) {
 			for obj := range processQueue {
 				for run := uint(0); run < runCount; run++ {
-					result := handler(obj)
-					enc, err := m.Marshal(result)
-					if err != nil {
+					if err := out.Write(handler(obj)); err != nil {
 						panic(err.Error())
 					}
-					outputQueue <- enc
 				}
 			}
 			workerDone.Done()
 		}(handler)
 	}
 	// Read the input, send to workers
+read:
 	for {
+		select {
+		case <-stop:
+			break read
+		default:
+		}
 		obj, err := in.DecodeNext()
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			zlog.Error(err)
+			continue
+		}
+		select {
+		case processQueue <- obj:
+		case <-stop:
+			break read
 		}
-		processQueue <- obj
 	}
 	close(processQueue)
 	workerDone.Wait()
-	close(outputQueue)
-	outputDone.Wait()
 	w.Done()
 }
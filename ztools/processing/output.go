@@ -0,0 +1,221 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package processing
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var errWriterClosed = errors.New("output writer closed")
+
+// A ResultWriter serializes results handed to it by any number of workers
+type ResultWriter interface {
+	Write(result interface{}) error
+	Close() error
+}
+
+// Defaults for OutputOptions left at zero
+const (
+	DefaultOutputBufferSize    = 64 * 1024
+	DefaultOutputFlushInterval = time.Second
+)
+
+// OutputOptions tunes the buffering of an NDJSONWriter or CSVWriter
+type OutputOptions struct {
+	// Bytes buffered before writing to the underlying writer
+	BufferSize int
+
+	// Buffered output is written at least this often, negative to only
+	// write when the buffer fills or on Close
+	FlushInterval time.Duration
+
+	// Compress the output with gzip
+	Gzip bool
+}
+
+// bufferedOutput is the buffer, optional gzip stream and periodic flush
+// shared by the writers. Each write is copied into the buffer whole, so
+// concurrent writers never interleave.
+type bufferedOutput struct {
+	mu  sync.Mutex
+	buf *bufio.Writer
+	gz  *gzip.Writer
+	err error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newBufferedOutput(w io.Writer, opts OutputOptions) *bufferedOutput {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultOutputBufferSize
+	}
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = DefaultOutputFlushInterval
+	}
+	bo := new(bufferedOutput)
+	if opts.Gzip {
+		bo.gz = gzip.NewWriter(w)
+		w = bo.gz
+	}
+	bo.buf = bufio.NewWriterSize(w, opts.BufferSize)
+	if opts.FlushInterval > 0 {
+		bo.stop = make(chan struct{})
+		bo.done = make(chan struct{})
+		go bo.flushEvery(opts.FlushInterval)
+	}
+	return bo
+}
+
+// write copies the chunks into the buffer back to back
+func (bo *bufferedOutput) write(chunks ...[]byte) error {
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	for _, chunk := range chunks {
+		if bo.err != nil {
+			break
+		}
+		_, bo.err = bo.buf.Write(chunk)
+	}
+	return bo.err
+}
+
+// Flush writes out everything buffered so far, ending the current gzip
+// block so a reader sees complete lines
+func (bo *bufferedOutput) Flush() error {
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	return bo.flush()
+}
+
+func (bo *bufferedOutput) flush() error {
+	if bo.err != nil {
+		return bo.err
+	}
+	bo.err = bo.buf.Flush()
+	if bo.err == nil && bo.gz != nil {
+		bo.err = bo.gz.Flush()
+	}
+	return bo.err
+}
+
+func (bo *bufferedOutput) flushEvery(interval time.Duration) {
+	defer close(bo.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			bo.Flush()
+		case <-bo.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic flush, writes out the buffer and finishes the
+// gzip stream. Writes after Close fail.
+func (bo *bufferedOutput) Close() error {
+	if bo.stop != nil {
+		close(bo.stop)
+		<-bo.done
+	}
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	err := bo.flush()
+	if err == nil && bo.gz != nil {
+		err = bo.gz.Close()
+	}
+	if bo.err == nil {
+		bo.err = errWriterClosed
+	}
+	return err
+}
+
+// An NDJSONWriter writes each result as one or more complete lines, so
+// concurrent writers never interleave within a line. Results are marshaled
+// by the calling goroutine and only the copy into the buffer is serialized.
+type NDJSONWriter struct {
+	*bufferedOutput
+	m Marshaler
+}
+
+// NewNDJSONWriter writes the results marshaled by m to w, which is not
+// closed by Close
+func NewNDJSONWriter(w io.Writer, m Marshaler, opts OutputOptions) *NDJSONWriter {
+	return &NDJSONWriter{bufferedOutput: newBufferedOutput(w, opts), m: m}
+}
+
+func (nw *NDJSONWriter) Write(result interface{}) error {
+	line, err := nw.m.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return nw.write(line, newline)
+}
+
+var newline = []byte("\n")
+
+// SplitWriter sends the results Failed reports on to Errors and the rest to
+// Out, so the main output only holds successes
+type SplitWriter struct {
+	Out    ResultWriter
+	Errors ResultWriter
+	Failed func(result interface{}) bool
+}
+
+func (s *SplitWriter) Write(result interface{}) error {
+	if s.Failed(result) {
+		return s.Errors.Write(result)
+	}
+	return s.Out.Write(result)
+}
+
+// Close closes both writers, returning the first error
+func (s *SplitWriter) Close() error {
+	err := s.Out.Close()
+	if errErrors := s.Errors.Close(); err == nil {
+		err = errErrors
+	}
+	return err
+}
+
+// MultiWriter writes every result to each of its writers
+type MultiWriter []ResultWriter
+
+func (mw MultiWriter) Write(result interface{}) error {
+	for _, w := range mw {
+		if err := w.Write(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every writer, returning the first error
+func (mw MultiWriter) Close() error {
+	var err error
+	for _, w := range mw {
+		if errClose := w.Close(); err == nil {
+			err = errClose
+		}
+	}
+	return err
+}
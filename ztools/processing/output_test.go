@@ -0,0 +1,189 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package processing
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type jsonMarshaler struct{}
+
+func (jsonMarshaler) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+type testResult struct {
+	Worker int    `json:"worker"`
+	Index  int    `json:"index"`
+	Body   string `json:"body"`
+}
+
+// lockedBuffer lets the test read what the periodic flush has written
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.b.Write(p)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.b.String()
+}
+
+func TestNDJSONWriterConcurrentLines(t *testing.T) {
+	var out bytes.Buffer
+	w := NewNDJSONWriter(&out, jsonMarshaler{}, OutputOptions{BufferSize: 100, FlushInterval: -1})
+	body := strings.Repeat("x", 300)
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if err := w.Write(&testResult{worker, i, body}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	next := make(map[int]int)
+	scanner := bufio.NewScanner(&out)
+	scanner.Buffer(nil, 1024*1024)
+	lines := 0
+	for scanner.Scan() {
+		var r testResult
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.Body != body {
+			t.Fatalf("Corrupt line %d: %q", lines, scanner.Text())
+		}
+		if r.Index != next[r.Worker] {
+			t.Fatalf("Worker %d result %d out of order", r.Worker, r.Index)
+		}
+		next[r.Worker]++
+		lines++
+	}
+	if lines != 8*200 {
+		t.Errorf("Wrote %d lines, expected %d", lines, 8*200)
+	}
+	if err := w.Write(&testResult{}); err == nil {
+		t.Errorf("Write after Close succeeded")
+	}
+}
+
+func TestNDJSONWriterPeriodicFlush(t *testing.T) {
+	out := new(lockedBuffer)
+	w := NewNDJSONWriter(out, jsonMarshaler{}, OutputOptions{FlushInterval: 10 * time.Millisecond})
+	defer w.Close()
+	w.Write(&testResult{Index: 1})
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if out.String() != "" {
+			break
+		}
+	}
+	if got := out.String(); got != `{"worker":0,"index":1,"body":""}`+"\n" {
+		t.Errorf("Wrong flushed output %q", got)
+	}
+}
+
+func TestNDJSONWriterGzip(t *testing.T) {
+	var out bytes.Buffer
+	w := NewNDJSONWriter(&out, jsonMarshaler{}, OutputOptions{Gzip: true})
+	for i := 0; i < 3; i++ {
+		w.Write(&testResult{Index: i})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain bytes.Buffer
+	if _, err := plain.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(plain.String(), "\n"); lines != 3 {
+		t.Errorf("Decompressed %d lines, expected 3: %q", lines, plain.String())
+	}
+}
+
+func TestSplitWriter(t *testing.T) {
+	var out, errs bytes.Buffer
+	w := &SplitWriter{
+		Out:    NewNDJSONWriter(&out, jsonMarshaler{}, OutputOptions{}),
+		Errors: NewNDJSONWriter(&errs, jsonMarshaler{}, OutputOptions{}),
+		Failed: func(v interface{}) bool { return v.(*testResult).Body == "failed" },
+	}
+	w.Write(&testResult{Body: "ok"})
+	w.Write(&testResult{Body: "failed"})
+	w.Write(&testResult{Body: "ok"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out.String(), `"ok"`) != 2 || strings.Contains(out.String(), "failed") {
+		t.Errorf("Wrong success output %q", out.String())
+	}
+	if errs.String() != `{"worker":0,"index":0,"body":"failed"}`+"\n" {
+		t.Errorf("Wrong error output %q", errs.String())
+	}
+}
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
+
+// BenchmarkNDJSONWriter writes grab-sized results from parallel workers;
+// ns/op is the cost of one result
+func BenchmarkNDJSONWriter(b *testing.B) {
+	for _, gz := range []bool{false, true} {
+		b.Run(fmt.Sprintf("gzip=%t", gz), func(b *testing.B) {
+			w := NewNDJSONWriter(discard{}, jsonMarshaler{}, OutputOptions{Gzip: gz})
+			body := strings.Repeat("220 mail.example.com ESMTP ready\r\n", 30)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					if err := w.Write(&testResult{Index: i, Body: body}); err != nil {
+						b.Error(err)
+						return
+					}
+					i++
+				}
+			})
+			if err := w.Close(); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
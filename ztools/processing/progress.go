@@ -0,0 +1,73 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package processing
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ReportProgress writes a line about w's progress to out every interval
+// until the returned function is called, which writes a final line. total
+// is the number of grabs expected, zero if unknown, and is used to estimate
+// the time remaining.
+func ReportProgress(out io.Writer, w Worker, total uint, interval time.Duration) func() {
+	start := time.Now()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last, lastTime := uint(0), start
+		for {
+			select {
+			case now := <-ticker.C:
+				n := w.Total()
+				rate := float64(n-last) / now.Sub(lastTime).Seconds()
+				fmt.Fprintln(out, progressLine(w, total, rate))
+				last, lastTime = n, now
+			case <-stop:
+				rate := float64(w.Total()) / time.Since(start).Seconds()
+				fmt.Fprintf(out, "%s in %s\n", progressLine(w, 0, rate), time.Since(start).Round(time.Second))
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// progressLine summarizes the grabs done so far at the given rate per
+// second
+func progressLine(w Worker, total uint, rate float64) string {
+	n := w.Total()
+	success := 0.0
+	if n > 0 {
+		success = 100 * float64(w.Success()) / float64(n)
+	}
+	if total == 0 {
+		return fmt.Sprintf("%d done, %.1f%% success, %.1f/s", n, success, rate)
+	}
+	eta := "unknown"
+	if rate > 0 && total >= n {
+		eta = time.Duration(float64(total-n) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+	return fmt.Sprintf("%d/%d done (%.1f%%), %.1f%% success, %.1f/s, ETA %s",
+		n, total, 100*float64(n)/float64(total), success, rate, eta)
+}
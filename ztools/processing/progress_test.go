@@ -0,0 +1,92 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package processing
+
+import (
+	"bytes"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingWorker struct {
+	success, failure uint64
+}
+
+func (w *countingWorker) MakeHandler(uint) Handler {
+	return func(v interface{}) interface{} {
+		if v.(int)%4 == 0 {
+			atomic.AddUint64(&w.failure, 1)
+		} else {
+			atomic.AddUint64(&w.success, 1)
+		}
+		return v
+	}
+}
+
+func (w *countingWorker) Success() uint  { return uint(atomic.LoadUint64(&w.success)) }
+func (w *countingWorker) Failure() uint  { return uint(atomic.LoadUint64(&w.failure)) }
+func (w *countingWorker) Total() uint    { return w.Success() + w.Failure() }
+func (w *countingWorker) Done()          {}
+func (w *countingWorker) RunCount() uint { return 1 }
+
+type counter struct{ n int }
+
+func (c *counter) DecodeNext() (interface{}, error) {
+	c.n++
+	return c.n, nil
+}
+
+type nullWriter struct{ n uint64 }
+
+func (w *nullWriter) Write(interface{}) error {
+	atomic.AddUint64(&w.n, 1)
+	return nil
+}
+func (w *nullWriter) Close() error { return nil }
+
+func TestProgressLine(t *testing.T) {
+	w := &countingWorker{success: 75, failure: 25}
+	if got := progressLine(w, 1000, 50); got != "100/1000 done (10.0%), 75.0% success, 50.0/s, ETA 18s" {
+		t.Errorf("Wrong line %q", got)
+	}
+	if got := progressLine(w, 0, 50); got != "100 done, 75.0% success, 50.0/s" {
+		t.Errorf("Wrong line without a total %q", got)
+	}
+}
+
+func TestProcessUntilStopsReading(t *testing.T) {
+	w := new(countingWorker)
+	out := new(nullWriter)
+	stop := make(chan struct{})
+	var log bytes.Buffer
+	done := ReportProgress(&log, w, 0, 5*time.Millisecond)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(stop)
+	}()
+	// The decoder never runs out, so only stop ends the scan
+	ProcessUntil(new(counter), out, w, 8, stop)
+	done()
+
+	if w.Total() == 0 || uint64(w.Total()) != atomic.LoadUint64(&out.n) {
+		t.Errorf("Handled %d targets, wrote %d", w.Total(), out.n)
+	}
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) < 2 || !strings.Contains(lines[len(lines)-1], "% success") {
+		t.Errorf("Wrong progress output %q", log.String())
+	}
+}
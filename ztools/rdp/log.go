@@ -0,0 +1,31 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package rdp
+
+// An RDPLog records the X.224 connection negotiation. NLASupported is set
+// when the server selected CredSSP, which authenticates the user before a
+// session exists, or refused us for not requiring it. Since SSL is offered
+// too, a server selecting CredSSP may still accept SSL alone.
+type RDPLog struct {
+	RequestedProtocols []string `json:"requested_protocols"`
+	// Set when the server sent no negotiation response, meaning it only
+	// speaks standard RDP security
+	StandardSecurity bool     `json:"standard_security,omitempty"`
+	SelectedProtocol string   `json:"selected_protocol,omitempty"`
+	Flags            []string `json:"flags,omitempty"`
+	FailureCode      uint32   `json:"failure_code,omitempty"`
+	Failure          string   `json:"failure,omitempty"`
+	NLASupported     bool     `json:"nla_supported"`
+}
@@ -0,0 +1,176 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package rdp sends an RDP X.224 Connection Request offering TLS and
+// CredSSP, MS-RDPBCGR section 2.2.1.1, and decodes the server's choice
+package rdp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Security protocols, MS-RDPBCGR section 2.2.1.1.1
+const (
+	ProtocolRDP      = 0x00000000
+	ProtocolSSL      = 0x00000001
+	ProtocolHybrid   = 0x00000002
+	ProtocolRDSTLS   = 0x00000004
+	ProtocolHybridEx = 0x00000008
+)
+
+var protocolNames = []struct {
+	id   uint32
+	name string
+}{
+	{ProtocolSSL, "ssl"},
+	{ProtocolHybrid, "hybrid"},
+	{ProtocolRDSTLS, "rdstls"},
+	{ProtocolHybridEx, "hybrid_ex"},
+}
+
+func protocolName(id uint32) string {
+	if id == ProtocolRDP {
+		return "rdp"
+	}
+	for _, p := range protocolNames {
+		if p.id == id {
+			return p.name
+		}
+	}
+	return fmt.Sprintf("unknown_0x%08x", id)
+}
+
+// Negotiation response flags, MS-RDPBCGR section 2.2.1.2.1
+var flagNames = []struct {
+	flag byte
+	name string
+}{
+	{0x01, "extended_client_data_supported"},
+	{0x02, "dynvc_gfx_protocol_supported"},
+	{0x08, "restricted_admin_mode_supported"},
+	{0x10, "redirected_authentication_mode_supported"},
+}
+
+// Negotiation failure codes, MS-RDPBCGR section 2.2.1.2.2
+const (
+	FailureSSLRequired             = 1
+	FailureSSLNotAllowed           = 2
+	FailureSSLCertNotOnServer      = 3
+	FailureInconsistentFlags       = 4
+	FailureHybridRequired          = 5
+	FailureSSLWithUserAuthRequired = 6
+)
+
+var failureNames = map[uint32]string{
+	FailureSSLRequired:             "SSL_REQUIRED_BY_SERVER",
+	FailureSSLNotAllowed:           "SSL_NOT_ALLOWED_BY_SERVER",
+	FailureSSLCertNotOnServer:      "SSL_CERT_NOT_ON_SERVER",
+	FailureInconsistentFlags:       "INCONSISTENT_FLAGS",
+	FailureHybridRequired:          "HYBRID_REQUIRED_BY_SERVER",
+	FailureSSLWithUserAuthRequired: "SSL_WITH_USER_AUTH_REQUIRED_BY_SERVER",
+}
+
+// FailureName returns the name of a negotiation failure code
+func FailureName(code uint32) string {
+	if name, ok := failureNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN_FAILURE_%d", code)
+}
+
+// Negotiation structure types
+const (
+	typeNegRequest  = 0x01
+	typeNegResponse = 0x02
+	typeNegFailure  = 0x03
+)
+
+const (
+	tpktHeaderLen = 4
+	// X.224 Connection Confirm, the high nibble of the TPDU code
+	x224ConnectionConfirm = 0xd0
+	negStructureLen       = 8
+	// Connection confirms longer than this are treated as malformed
+	maxConfirmLength = 512
+)
+
+var errNotRDP = errors.New("Server did not answer with an X.224 Connection Confirm")
+
+// connectionRequest returns a TPKT framed X.224 Connection Request carrying
+// an RDP Negotiation Request for protocols
+func connectionRequest(protocols uint32) []byte {
+	neg := []byte{typeNegRequest, 0, negStructureLen, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(neg[4:], protocols)
+	// length indicator, CR TPDU, destination and source references, class
+	x224 := append([]byte{byte(6 + len(neg)), 0xe0, 0, 0, 0, 0, 0}, neg...)
+	tpkt := []byte{3, 0, 0, 0}
+	binary.BigEndian.PutUint16(tpkt[2:], uint16(tpktHeaderLen+len(x224)))
+	return append(tpkt, x224...)
+}
+
+// Negotiate offers TLS and CredSSP and records the server's choice. It
+// returns true when the server selected a protocol that starts with a TLS
+// handshake, for which the caller should now perform one.
+func Negotiate(logStruct *RDPLog, connection net.Conn) (bool, error) {
+	requested := uint32(ProtocolSSL | ProtocolHybrid)
+	logStruct.RequestedProtocols = []string{protocolName(ProtocolSSL), protocolName(ProtocolHybrid)}
+	if _, err := connection.Write(connectionRequest(requested)); err != nil {
+		return false, err
+	}
+
+	header := make([]byte, tpktHeaderLen)
+	if _, err := io.ReadFull(connection, header); err != nil {
+		return false, err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if header[0] != 3 || length < tpktHeaderLen+7 || length > maxConfirmLength {
+		return false, errNotRDP
+	}
+	x224 := make([]byte, length-tpktHeaderLen)
+	if _, err := io.ReadFull(connection, x224); err != nil {
+		return false, err
+	}
+	if x224[1]&0xf0 != x224ConnectionConfirm {
+		return false, errNotRDP
+	}
+
+	neg := x224[7:]
+	if len(neg) < negStructureLen {
+		logStruct.StandardSecurity = true
+		logStruct.SelectedProtocol = protocolName(ProtocolRDP)
+		return false, nil
+	}
+	value := binary.LittleEndian.Uint32(neg[4:])
+	switch neg[0] {
+	case typeNegResponse:
+		logStruct.SelectedProtocol = protocolName(value)
+		for _, f := range flagNames {
+			if neg[1]&f.flag != 0 {
+				logStruct.Flags = append(logStruct.Flags, f.name)
+			}
+		}
+		logStruct.NLASupported = value&(ProtocolHybrid|ProtocolHybridEx) != 0
+		return value&(ProtocolSSL|ProtocolHybrid|ProtocolHybridEx) != 0, nil
+	case typeNegFailure:
+		logStruct.FailureCode = value
+		logStruct.Failure = FailureName(value)
+		logStruct.NLASupported = value == FailureHybridRequired
+		return false, nil
+	}
+	return false, fmt.Errorf("Unknown RDP negotiation structure type %d", neg[0])
+}
@@ -0,0 +1,106 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package rdp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// confirm returns a TPKT framed X.224 Connection Confirm carrying neg
+func confirm(neg []byte) []byte {
+	x224 := append([]byte{byte(6 + len(neg)), 0xd0, 0, 0, 0x12, 0x34, 0}, neg...)
+	return append([]byte{3, 0, 0, byte(4 + len(x224))}, x224...)
+}
+
+// serve reads the connection request, answers with reply and returns what
+// the client sent
+func serve(conn net.Conn, reply []byte) <-chan []byte {
+	sent := make(chan []byte, 1)
+	go func() {
+		defer conn.Close()
+		request := make([]byte, 19)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			sent <- nil
+			return
+		}
+		sent <- request
+		conn.Write(reply)
+		io.Copy(ioutil.Discard, conn)
+	}()
+	return sent
+}
+
+func negotiate(t *testing.T, reply []byte) (*RDPLog, bool, error) {
+	client, server := net.Pipe()
+	defer client.Close()
+	sent := serve(server, reply)
+	log := new(RDPLog)
+	ok, err := Negotiate(log, client)
+	want := []byte{3, 0, 0, 19, 14, 0xe0, 0, 0, 0, 0, 0, 1, 0, 8, 0, 3, 0, 0, 0}
+	if request := <-sent; !bytes.Equal(request, want) {
+		t.Errorf("Wrong connection request: %x", request)
+	}
+	return log, ok, err
+}
+
+func TestNegotiateHybrid(t *testing.T) {
+	log, ok, err := negotiate(t, confirm([]byte{2, 0x09, 8, 0, 2, 0, 0, 0}))
+	if err != nil {
+		t.Fatalf("Negotiate: %s", err.Error())
+	}
+	if !ok || log.SelectedProtocol != "hybrid" || !log.NLASupported {
+		t.Errorf("Wrong result %t: %+v", ok, log)
+	}
+	if len(log.Flags) != 2 || log.Flags[1] != "restricted_admin_mode_supported" {
+		t.Errorf("Wrong flags: %v", log.Flags)
+	}
+}
+
+func TestNegotiateSSL(t *testing.T) {
+	log, ok, err := negotiate(t, confirm([]byte{2, 0, 8, 0, 1, 0, 0, 0}))
+	if err != nil || !ok || log.SelectedProtocol != "ssl" || log.NLASupported {
+		t.Errorf("Wrong result %t, %v: %+v", ok, err, log)
+	}
+}
+
+func TestNegotiateFailure(t *testing.T) {
+	log, ok, err := negotiate(t, confirm([]byte{3, 0, 8, 0, 5, 0, 0, 0}))
+	if err != nil || ok {
+		t.Fatalf("Wrong result %t, %v", ok, err)
+	}
+	if log.FailureCode != 5 || log.Failure != "HYBRID_REQUIRED_BY_SERVER" || !log.NLASupported {
+		t.Errorf("Wrong failure: %+v", log)
+	}
+	if FailureName(1) != "SSL_REQUIRED_BY_SERVER" {
+		t.Errorf("Wrong name for failure 1: %s", FailureName(1))
+	}
+}
+
+func TestNegotiateStandardSecurity(t *testing.T) {
+	log, ok, err := negotiate(t, confirm(nil))
+	if err != nil || ok || !log.StandardSecurity || log.SelectedProtocol != "rdp" {
+		t.Errorf("Wrong result %t, %v: %+v", ok, err, log)
+	}
+}
+
+func TestNegotiateNotRDP(t *testing.T) {
+	if _, _, err := negotiate(t, []byte("HTTP/1.1 400 Bad Request\r\n\r\n")); err != errNotRDP {
+		t.Errorf("Expected errNotRDP, got %v", err)
+	}
+}
@@ -55,7 +55,11 @@ func ReadVLC(c net.Conn) (vlc *VLC, npdu *NPDU, apdu *APDU, leftovers []byte, er
 	if err != nil {
 		return
 	}
-	b = b[0:n]
+	return ParseVLC(b[0:n])
+}
+
+// ParseVLC decodes the headers of a datagram read by ReadVLC
+func ParseVLC(b []byte) (vlc *VLC, npdu *NPDU, apdu *APDU, leftovers []byte, err error, isBACNet bool) {
 	leftovers = b
 	vlc = new(VLC)
 	if leftovers, err = vlc.Unmarshal(leftovers); err != nil {
@@ -13,6 +13,15 @@ type Log struct {
 	ModelName                   string `json:"model_name,omitempty"`
 	Description                 string `json:"description,omitempty"`
 	Location                    string `json:"location,omitempty"`
+
+	// The datagrams received, one per property read
+	Responses []*PropertyResponse `json:"responses,omitempty"`
+}
+
+// A PropertyResponse is the reply to a ReadProperty request as received
+type PropertyResponse struct {
+	Property PropertyID `json:"property_id"`
+	Raw      []byte     `json:"raw"`
 }
 
 func (log *Log) sendReadProperty(c net.Conn, oid ObjectID, pid PropertyID) ([]byte, error, bool) {
@@ -24,9 +33,15 @@ func (log *Log) sendReadProperty(c net.Conn, oid ObjectID, pid PropertyID) ([]by
 	if err := SendVLC(c, b); err != nil {
 		return nil, err, false
 	}
+	raw := make([]byte, MAX_BACNET_FRAME_LEN)
+	n, err := c.Read(raw)
+	if err != nil {
+		return nil, err, false
+	}
+	log.Responses = append(log.Responses, &PropertyResponse{Property: pid, Raw: raw[0:n]})
 	var body []byte
 	var isBACNet bool
-	_, _, _, body, err, isBACNet = ReadVLC(c)
+	_, _, _, body, err, isBACNet = ParseVLC(raw[0:n])
 	if err != nil {
 		return nil, err, isBACNet
 	}
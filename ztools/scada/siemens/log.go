@@ -16,4 +16,15 @@ type S7Log struct {
 	ModuleId           string `json:"module_id,omitempty"`
 	Hardware           string `json:"hardware,omitempty"`
 	Firmware           string `json:"firmware,omitempty"`
+
+	// How the last COTP connection request was answered, see COTPStatus*,
+	// which tells a PLC refusing the TSAP from a server speaking something
+	// other than TPKT
+	COTPStatus string `json:"cotp_status,omitempty"`
+
+	// Responses as received
+	RawCOTP        []byte `json:"raw_cotp,omitempty"`
+	RawSetup       []byte `json:"raw_setup,omitempty"`
+	RawModuleID    []byte `json:"raw_module_id,omitempty"`
+	RawComponentID []byte `json:"raw_component_id,omitempty"`
 }
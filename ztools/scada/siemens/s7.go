@@ -3,6 +3,7 @@ package siemens
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"net"
 	"time"
 )
@@ -16,7 +17,8 @@ func GetS7Banner(logStruct *S7Log, connection net.Conn) (err error) {
 	}
 
 	connResponseBytes, err = sendRequestReadResponse(connection, connPacketBytes)
-	if connResponseBytes == nil || len(connResponseBytes) == 0 || err != nil {
+	logStruct.setCOTPResponse(connResponseBytes, err)
+	if connResponseBytes == nil || len(connResponseBytes) == 0 || err != nil || logStruct.COTPStatus == COTPStatusRefused {
 		address := connection.RemoteAddr().String()
 		connection.Close()
 		connection, err = makeNewConnection(address)
@@ -29,10 +31,14 @@ func GetS7Banner(logStruct *S7Log, connection net.Conn) (err error) {
 			return err
 		}
 		connResponseBytes, err = sendRequestReadResponse(connection, connPacketBytes)
+		logStruct.setCOTPResponse(connResponseBytes, err)
 		if err != nil {
 			return err
 		}
 	}
+	if logStruct.COTPStatus == COTPStatusRefused {
+		return errCOTPRefused
+	}
 
 	_, err = unmarshalCOTPConnectionResponse(connResponseBytes)
 	if err != nil {
@@ -44,7 +50,7 @@ func GetS7Banner(logStruct *S7Log, connection net.Conn) (err error) {
 	if err != nil {
 		return err
 	}
-	_, err = sendRequestReadResponse(connection, requestPacketBytes)
+	logStruct.RawSetup, err = sendRequestReadResponse(connection, requestPacketBytes)
 	if err != nil {
 		return err
 	}
@@ -52,14 +58,14 @@ func GetS7Banner(logStruct *S7Log, connection net.Conn) (err error) {
 	logStruct.IsS7 = true
 
 	// Make Module Identification request
-	moduleIdentificationResponse, err := readRequest(connection, S7_SZL_MODULE_IDENTIFICATION)
+	moduleIdentificationResponse, err := readRequest(connection, S7_SZL_MODULE_IDENTIFICATION, &logStruct.RawModuleID)
 	if err != nil {
 		return nil // mask errors after detecting IsS7
 	}
 	parseModuleIdentificatioNRequest(logStruct, &moduleIdentificationResponse)
 
 	// Make Component Identification request
-	componentIdentificationResponse, err := readRequest(connection, S7_SZL_COMPONENT_IDENTIFICATION)
+	componentIdentificationResponse, err := readRequest(connection, S7_SZL_COMPONENT_IDENTIFICATION, &logStruct.RawComponentID)
 	if err != nil {
 		return nil // mask errors after detecting IsS7
 	}
@@ -289,12 +295,14 @@ func parseModuleIdentificatioNRequest(logStruct *S7Log, s7Packet *S7Packet) erro
 	return nil
 }
 
-func readRequest(connection net.Conn, slzId uint16) (packet S7Packet, err error) {
+// readRequest reads the SZL with id slzId, keeping the response in raw
+func readRequest(connection net.Conn, slzId uint16, raw *[]byte) (packet S7Packet, err error) {
 	readRequestBytes, err := makeReadRequestBytes(slzId)
 	if err != nil {
 		return packet, err
 	}
 	readResponse, err := sendRequestReadResponse(connection, readRequestBytes)
+	*raw = readResponse
 	if err != nil {
 		return packet, err
 	}
@@ -305,3 +313,46 @@ func readRequest(connection net.Conn, slzId uint16) (packet S7Packet, err error)
 
 	return packet, nil
 }
+
+// Answers to a COTP connection request
+const (
+	COTPStatusConnected   = "connected"
+	COTPStatusRefused     = "refused"
+	COTPStatusNotTPKT     = "not_tpkt"
+	COTPStatusInvalidCOTP = "invalid_cotp"
+	COTPStatusNoResponse  = "no_response"
+)
+
+// COTP TPDU codes, the high nibble of the second header byte
+const (
+	cotpConnectionConfirm = 0xd0
+	cotpDisconnectRequest = 0x80
+	cotpTPDUError         = 0x70
+	tpktVersion           = 3
+)
+
+var errCOTPRefused = errors.New("COTP connection request refused")
+
+// setCOTPResponse records the response to a COTP connection request and
+// classifies it
+func (logStruct *S7Log) setCOTPResponse(b []byte, err error) {
+	logStruct.RawCOTP = b
+	switch {
+	case len(b) == 0:
+		logStruct.COTPStatus = COTPStatusNoResponse
+	case len(b) < tpktLength || b[0] != tpktVersion || b[1] != 0:
+		// e.g. an HTTP server answering with a 400
+		logStruct.COTPStatus = COTPStatusNotTPKT
+	case len(b) < tpktLength+2:
+		logStruct.COTPStatus = COTPStatusInvalidCOTP
+	default:
+		switch b[tpktLength+1] & 0xf0 {
+		case cotpConnectionConfirm:
+			logStruct.COTPStatus = COTPStatusConnected
+		case cotpDisconnectRequest, cotpTPDUError:
+			logStruct.COTPStatus = COTPStatusRefused
+		default:
+			logStruct.COTPStatus = COTPStatusInvalidCOTP
+		}
+	}
+}
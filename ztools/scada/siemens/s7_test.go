@@ -0,0 +1,44 @@
+package siemens
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCOTPStatus(t *testing.T) {
+	tests := []struct {
+		response []byte
+		status   string
+	}{
+		{nil, COTPStatusNoResponse},
+		{[]byte{0x03, 0x00, 0x00, 0x16, 0x11, 0xd0, 0x00, 0x01, 0x00, 0x04, 0x00}, COTPStatusConnected},
+		{[]byte{0x03, 0x00, 0x00, 0x0b, 0x06, 0x80, 0x00, 0x04, 0x00, 0x01, 0x00}, COTPStatusRefused},
+		{[]byte{0x03, 0x00, 0x00, 0x0b, 0x06, 0xf0, 0x80}, COTPStatusInvalidCOTP},
+		{[]byte("HTTP/1.1 400 Bad Request\r\n\r\n"), COTPStatusNotTPKT},
+	}
+	for _, test := range tests {
+		log := new(S7Log)
+		log.setCOTPResponse(test.response, nil)
+		if log.COTPStatus != test.status {
+			t.Errorf("%q: expected %s, got %s", test.response, test.status, log.COTPStatus)
+		}
+	}
+}
+
+func TestGetS7BannerNotTPKT(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		server.Read(make([]byte, 64))
+		server.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+	}()
+
+	log := new(S7Log)
+	if err := GetS7Banner(log, client); err == nil || log.IsS7 {
+		t.Errorf("HTTP server taken for a PLC: %v, %+v", err, log)
+	}
+	if log.COTPStatus != COTPStatusNotTPKT || string(log.RawCOTP[:4]) != "HTTP" {
+		t.Errorf("Wrong COTP status %s, response %q", log.COTPStatus, log.RawCOTP)
+	}
+}
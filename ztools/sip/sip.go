@@ -0,0 +1,169 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package sip builds SIP OPTIONS requests and parses the responses,
+// RFC 3261 section 11
+package sip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// T1 is the round-trip time estimate the retransmission schedule of a
+// non-INVITE request over UDP starts from, RFC 3261 section 17.1.2.2
+const T1 = 500 * time.Millisecond
+
+// BranchPrefix is the magic cookie starting every RFC 3261 branch parameter
+const BranchPrefix = "z9hG4bK"
+
+// An Options describes the OPTIONS request to send. Local is the address
+// the request is sent from, placed in Via, From and Contact.
+type Options struct {
+	Transport string
+	Local     net.Addr
+	Target    string
+	Branch    string
+	Tag       string
+	CallID    string
+}
+
+// Request returns the OPTIONS request
+func (o *Options) Request() []byte {
+	local := o.Local.String()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "OPTIONS sip:%s SIP/2.0\r\n", o.Target)
+	fmt.Fprintf(&b, "Via: SIP/2.0/%s %s;branch=%s;rport\r\n", o.Transport, local, o.Branch)
+	b.WriteString("Max-Forwards: 70\r\n")
+	fmt.Fprintf(&b, "From: <sip:zgrab@%s>;tag=%s\r\n", local, o.Tag)
+	fmt.Fprintf(&b, "To: <sip:%s>\r\n", o.Target)
+	fmt.Fprintf(&b, "Call-ID: %s\r\n", o.CallID)
+	b.WriteString("CSeq: 1 OPTIONS\r\n")
+	fmt.Fprintf(&b, "Contact: <sip:zgrab@%s>\r\n", local)
+	b.WriteString("Accept: application/sdp\r\n")
+	b.WriteString("Content-Length: 0\r\n\r\n")
+	return b.Bytes()
+}
+
+// Compact forms, RFC 3261 section 7.3.3, of the headers read
+var compactHeaders = map[string]string{
+	"v": "via",
+	"k": "supported",
+}
+
+// A Response is a parsed SIP response. Server and UserAgent are kept
+// verbatim since they identify the PBX.
+type Response struct {
+	StatusCode   int      `json:"status_code"`
+	ReasonPhrase string   `json:"reason_phrase"`
+	Server       string   `json:"server,omitempty"`
+	UserAgent    string   `json:"user_agent,omitempty"`
+	Allow        []string `json:"allow,omitempty"`
+	Supported    []string `json:"supported,omitempty"`
+	Branch       string   `json:"-"`
+}
+
+var (
+	errNotSIP        = errors.New("sip: not a SIP response")
+	errNoHeaderEnd   = errors.New("sip: response headers are not terminated")
+	errBadStatusCode = errors.New("sip: invalid status code")
+)
+
+// ParseResponse parses the status line and headers of a response. Headers
+// may be folded and repeated, list headers being joined.
+func ParseResponse(b []byte) (*Response, error) {
+	end := bytes.Index(b, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, errNoHeaderEnd
+	}
+	lines := strings.Split(string(b[:end]), "\r\n")
+	status := strings.SplitN(lines[0], " ", 3)
+	if len(status) < 2 || status[0] != "SIP/2.0" {
+		return nil, errNotSIP
+	}
+	code, err := strconv.Atoi(status[1])
+	if err != nil || code < 100 || code > 699 {
+		return nil, errBadStatusCode
+	}
+	res := &Response{StatusCode: code}
+	if len(status) == 3 {
+		res.ReasonPhrase = status[2]
+	}
+
+	var headers []string
+	for _, line := range lines[1:] {
+		if len(headers) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			headers[len(headers)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+		headers = append(headers, line)
+	}
+	for _, header := range headers {
+		colon := strings.IndexByte(header, ':')
+		if colon < 0 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(header[:colon]))
+		if long, ok := compactHeaders[name]; ok {
+			name = long
+		}
+		value := strings.TrimSpace(header[colon+1:])
+		switch name {
+		case "server":
+			res.Server = value
+		case "user-agent":
+			res.UserAgent = value
+		case "allow":
+			res.Allow = append(res.Allow, splitList(value)...)
+		case "supported":
+			res.Supported = append(res.Supported, splitList(value)...)
+		case "via":
+			// Only the topmost Via, ours, carries the branch to match
+			if res.Branch == "" {
+				res.Branch = viaBranch(value)
+			}
+		}
+	}
+	return res, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// viaBranch returns the branch parameter of the first value of a Via header
+func viaBranch(via string) string {
+	if comma := strings.IndexByte(via, ','); comma >= 0 {
+		via = via[:comma]
+	}
+	params := strings.Split(via, ";")
+	for _, param := range params[1:] {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 && strings.ToLower(kv[0]) == "branch" {
+			return kv[1]
+		}
+	}
+	return ""
+}
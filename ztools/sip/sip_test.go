@@ -0,0 +1,81 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package sip
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestOptionsRequest(t *testing.T) {
+	o := &Options{
+		Transport: "UDP",
+		Local:     &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5060},
+		Target:    "pbx.example.com",
+		Branch:    BranchPrefix + "abc",
+		Tag:       "t1",
+		CallID:    "c1",
+	}
+	req := string(o.Request())
+	for _, want := range []string{
+		"OPTIONS sip:pbx.example.com SIP/2.0\r\n",
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bKabc;rport\r\n",
+		"From: <sip:zgrab@192.0.2.1:5060>;tag=t1\r\n",
+		"To: <sip:pbx.example.com>\r\n",
+		"Call-ID: c1\r\n",
+		"Max-Forwards: 70\r\n",
+	} {
+		if !strings.Contains(req, want) {
+			t.Errorf("Request lacks %q:\n%s", want, req)
+		}
+	}
+	if !strings.HasSuffix(req, "Content-Length: 0\r\n\r\n") {
+		t.Errorf("Request not terminated:\n%s", req)
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	raw := "SIP/2.0 200 OK\r\n" +
+		"v: SIP/2.0/UDP 192.0.2.1:5060;rport=5060;BRANCH=z9hG4bKabc, SIP/2.0/UDP 192.0.2.9;branch=z9hG4bKother\r\n" +
+		"Via: SIP/2.0/UDP 192.0.2.8;branch=z9hG4bKlater\r\n" +
+		"User-Agent: FPBX-16.0.40(18.20.0)\r\n" +
+		"Allow: INVITE, ACK, CANCEL,\r\n OPTIONS, BYE\r\n" +
+		"Allow: NOTIFY\r\n" +
+		"k: replaces, timer\r\n" +
+		"Content-Length: 0\r\n\r\n"
+	res, err := ParseResponse([]byte(raw))
+	if err != nil {
+		t.Fatalf("ParseResponse: %s", err.Error())
+	}
+	if res.StatusCode != 200 || res.ReasonPhrase != "OK" || res.Branch != "z9hG4bKabc" {
+		t.Errorf("Wrong status or branch: %+v", res)
+	}
+	if res.UserAgent != "FPBX-16.0.40(18.20.0)" || res.Server != "" {
+		t.Errorf("Wrong User-Agent %q", res.UserAgent)
+	}
+	if strings.Join(res.Allow, " ") != "INVITE ACK CANCEL OPTIONS BYE NOTIFY" {
+		t.Errorf("Wrong Allow: %v", res.Allow)
+	}
+	if len(res.Supported) != 2 || res.Supported[1] != "timer" {
+		t.Errorf("Wrong Supported: %v", res.Supported)
+	}
+
+	for _, bad := range []string{"SIP/2.0 200 OK\r\n", "HTTP/1.1 200 OK\r\n\r\n", "SIP/2.0 2000 OK\r\n\r\n"} {
+		if _, err := ParseResponse([]byte(bad)); err == nil {
+			t.Errorf("Accepted %q", bad)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package smb
+
+import "time"
+
+// Protocols a server can answer the negotiation with
+const (
+	ProtocolSMB1 = "smb1"
+	ProtocolSMB2 = "smb2"
+)
+
+// An SMBLog records the reply to a multi-protocol Negotiate. A server that
+// answers in SMB1 although SMB2 dialects were offered is SMB1Only.
+type SMBLog struct {
+	Protocol        string    `json:"protocol"`
+	SMB1Only        bool      `json:"smb1_only"`
+	Dialect         string    `json:"dialect,omitempty"`
+	DialectRevision uint16    `json:"dialect_revision,omitempty"`
+	SecurityMode    uint16    `json:"security_mode"`
+	SigningEnabled  bool      `json:"signing_enabled"`
+	SigningRequired bool      `json:"signing_required"`
+	ServerGUID      string    `json:"server_guid,omitempty"`
+	Capabilities    uint32    `json:"capabilities"`
+	CapabilityNames []string  `json:"capability_names,omitempty"`
+	SystemTime      time.Time `json:"system_time"`
+	// Only sent by SMB2 servers, and zero on most of them
+	ServerStartTime time.Time `json:"server_start_time"`
+	// Minutes from UTC, only sent by SMB1 servers
+	TimeZone int16 `json:"time_zone,omitempty"`
+}
@@ -0,0 +1,266 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package smb sends an SMB1 Negotiate Protocol request that also lists SMB2
+// dialects, so that SMB2 servers answer with an SMB2 NEGOTIATE response
+// ([MS-SMB2] section 3.3.5.3.1) and SMB1-only servers with an SMB1 one
+package smb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Dialects offered in the SMB1 request, in order
+var dialects = []string{"NT LM 0.12", "SMB 2.002", "SMB 2.???"}
+
+var dialectRevisions = map[uint16]string{
+	0x0202: "2.0.2",
+	0x0210: "2.1",
+	0x0300: "3.0",
+	0x0302: "3.0.2",
+	0x0311: "3.1.1",
+	// The server supports SMB 2.1 or later and expects an SMB2 NEGOTIATE
+	0x02ff: "2.???",
+}
+
+type capability struct {
+	flag uint32
+	name string
+}
+
+var smb1Capabilities = []capability{
+	{0x00000001, "raw_mode"},
+	{0x00000002, "mpx_mode"},
+	{0x00000004, "unicode"},
+	{0x00000008, "large_files"},
+	{0x00000010, "nt_smbs"},
+	{0x00000020, "rpc_remote_apis"},
+	{0x00000040, "status32"},
+	{0x00000080, "level_ii_oplocks"},
+	{0x00000100, "lock_and_read"},
+	{0x00000200, "nt_find"},
+	{0x00001000, "dfs"},
+	{0x00002000, "infolevel_passthru"},
+	{0x00004000, "large_readx"},
+	{0x00008000, "large_writex"},
+	{0x00010000, "lwio"},
+	{0x00800000, "unix"},
+	{0x02000000, "compressed_data"},
+	{0x20000000, "dynamic_reauth"},
+	{0x80000000, "extended_security"},
+}
+
+var smb2Capabilities = []capability{
+	{0x00000001, "dfs"},
+	{0x00000002, "leasing"},
+	{0x00000004, "large_mtu"},
+	{0x00000008, "multi_channel"},
+	{0x00000010, "persistent_handles"},
+	{0x00000020, "directory_leasing"},
+	{0x00000040, "encryption"},
+}
+
+const (
+	netBIOSHeaderLen = 4
+	smb1HeaderLen    = 32
+	smb2HeaderLen    = 64
+	// Fixed part of the SMB2 NEGOTIATE response body
+	smb2NegotiateLen = 64
+	// Negotiate responses longer than this are treated as malformed
+	maxResponseLength = 0x10000
+
+	smb1CommandNegotiate = 0x72
+	smb1ExtendedSecurity = 0x80000000
+	smb1NoDialect        = 0xffff
+	smb1SigningEnabled   = 0x04
+	smb1SigningRequired  = 0x08
+	smb2SigningEnabled   = 0x01
+	smb2SigningRequired  = 0x02
+	smb2CommandNegotiate = 0x0000
+	filetimeUnixDiff     = 116444736000000000
+	filetimeTicksPerSec  = 10000000
+	smb1NTLM012WordCount = 17
+)
+
+var (
+	smb1Magic = []byte{0xff, 'S', 'M', 'B'}
+	smb2Magic = []byte{0xfe, 'S', 'M', 'B'}
+
+	errNotSMB       = errors.New("Server did not answer with an SMB message")
+	errShortSMB     = errors.New("SMB negotiate response is truncated")
+	errNoDialect    = errors.New("Server accepted none of the offered dialects")
+	errNotNegotiate = errors.New("SMB response is not a negotiate response")
+)
+
+// negotiateRequest returns the NetBIOS framed SMB1 Negotiate Protocol request
+func negotiateRequest() []byte {
+	header := make([]byte, smb1HeaderLen)
+	copy(header, smb1Magic)
+	header[4] = smb1CommandNegotiate
+	// case insensitive, canonicalized paths
+	header[9] = 0x18
+	// unicode, NT status codes, extended security, long names
+	binary.LittleEndian.PutUint16(header[10:], 0xc801)
+	binary.LittleEndian.PutUint16(header[24:], 0xffff)
+	binary.LittleEndian.PutUint16(header[26:], 0xfeff)
+
+	var names []byte
+	for _, d := range dialects {
+		names = append(names, 0x02)
+		names = append(names, d...)
+		names = append(names, 0)
+	}
+	body := []byte{0, byte(len(names)), byte(len(names) >> 8)}
+	body = append(body, names...)
+
+	message := append(header, body...)
+	frame := []byte{0, byte(len(message) >> 16), byte(len(message) >> 8), byte(len(message))}
+	return append(frame, message...)
+}
+
+// Negotiate sends the multi-protocol Negotiate request and records the
+// server's reply, whichever version of SMB it is in
+func Negotiate(logStruct *SMBLog, connection net.Conn) error {
+	if _, err := connection.Write(negotiateRequest()); err != nil {
+		return err
+	}
+	header := make([]byte, netBIOSHeaderLen)
+	if _, err := io.ReadFull(connection, header); err != nil {
+		return err
+	}
+	length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+	if header[0] != 0 || length < len(smb1Magic) || length > maxResponseLength {
+		return errNotSMB
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(connection, message); err != nil {
+		return err
+	}
+	switch string(message[:4]) {
+	case string(smb1Magic):
+		return parseSMB1(logStruct, message)
+	case string(smb2Magic):
+		return parseSMB2(logStruct, message)
+	}
+	return errNotSMB
+}
+
+// parseSMB1 reads an SMB_COM_NEGOTIATE response, [MS-SMB] section 2.2.4.5.2
+func parseSMB1(logStruct *SMBLog, message []byte) error {
+	logStruct.Protocol = ProtocolSMB1
+	logStruct.SMB1Only = true
+	if len(message) < smb1HeaderLen+3 {
+		return errShortSMB
+	}
+	if message[4] != smb1CommandNegotiate {
+		return errNotNegotiate
+	}
+	if status := binary.LittleEndian.Uint32(message[5:]); status != 0 {
+		return fmt.Errorf("SMB negotiate failed with status 0x%08x", status)
+	}
+	wordCount := int(message[smb1HeaderLen])
+	words := message[smb1HeaderLen+1:]
+	if len(words) < 2*wordCount+2 {
+		return errShortSMB
+	}
+	index := binary.LittleEndian.Uint16(words)
+	if index == smb1NoDialect {
+		return errNoDialect
+	}
+	if int(index) < len(dialects) {
+		logStruct.Dialect = dialects[index]
+	}
+	if wordCount != smb1NTLM012WordCount {
+		return nil
+	}
+
+	logStruct.SecurityMode = uint16(words[2])
+	logStruct.SigningEnabled = words[2]&smb1SigningEnabled != 0
+	logStruct.SigningRequired = words[2]&smb1SigningRequired != 0
+	setCapabilities(logStruct, binary.LittleEndian.Uint32(words[19:]), smb1Capabilities)
+	logStruct.SystemTime = filetime(words[23:])
+	logStruct.TimeZone = int16(binary.LittleEndian.Uint16(words[31:]))
+
+	data := words[2*wordCount+2:]
+	if logStruct.Capabilities&smb1ExtendedSecurity != 0 && len(data) >= 16 {
+		logStruct.ServerGUID = formatGUID(data[:16])
+	}
+	return nil
+}
+
+// parseSMB2 reads an SMB2 NEGOTIATE response, [MS-SMB2] section 2.2.4
+func parseSMB2(logStruct *SMBLog, message []byte) error {
+	logStruct.Protocol = ProtocolSMB2
+	if len(message) < smb2HeaderLen {
+		return errShortSMB
+	}
+	if binary.LittleEndian.Uint16(message[12:]) != smb2CommandNegotiate {
+		return errNotNegotiate
+	}
+	if status := binary.LittleEndian.Uint32(message[8:]); status != 0 {
+		return fmt.Errorf("SMB2 negotiate failed with status 0x%08x", status)
+	}
+	body := message[smb2HeaderLen:]
+	if len(body) < smb2NegotiateLen {
+		return errShortSMB
+	}
+
+	mode := binary.LittleEndian.Uint16(body[2:])
+	logStruct.SecurityMode = mode
+	logStruct.SigningEnabled = mode&smb2SigningEnabled != 0
+	logStruct.SigningRequired = mode&smb2SigningRequired != 0
+	logStruct.DialectRevision = binary.LittleEndian.Uint16(body[4:])
+	if name, ok := dialectRevisions[logStruct.DialectRevision]; ok {
+		logStruct.Dialect = name
+	} else {
+		logStruct.Dialect = fmt.Sprintf("unknown_0x%04x", logStruct.DialectRevision)
+	}
+	logStruct.ServerGUID = formatGUID(body[8:24])
+	setCapabilities(logStruct, binary.LittleEndian.Uint32(body[24:]), smb2Capabilities)
+	logStruct.SystemTime = filetime(body[40:])
+	logStruct.ServerStartTime = filetime(body[48:])
+	return nil
+}
+
+func setCapabilities(logStruct *SMBLog, flags uint32, known []capability) {
+	logStruct.Capabilities = flags
+	for _, c := range known {
+		if flags&c.flag != 0 {
+			logStruct.CapabilityNames = append(logStruct.CapabilityNames, c.name)
+		}
+	}
+}
+
+// filetime decodes a little-endian FILETIME, the count of 100ns intervals
+// since 1601. Zero, which servers send for unknown times, stays zero.
+func filetime(b []byte) time.Time {
+	ticks := binary.LittleEndian.Uint64(b)
+	if ticks == 0 {
+		return time.Time{}
+	}
+	unix := int64(ticks) - filetimeUnixDiff
+	return time.Unix(unix/filetimeTicksPerSec, unix%filetimeTicksPerSec*100).UTC()
+}
+
+// formatGUID renders a GUID in its usual form, where the first three fields
+// are stored little-endian
+func formatGUID(b []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x", binary.LittleEndian.Uint32(b),
+		binary.LittleEndian.Uint16(b[4:]), binary.LittleEndian.Uint16(b[6:]), b[8:10], b[10:16])
+}
@@ -0,0 +1,144 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package smb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// 2016-01-02 03:04:05 UTC
+const testFiletime = 130961774450000000
+
+// frame wraps an SMB message in a NetBIOS session header
+func frame(message []byte) []byte {
+	return append([]byte{0, byte(len(message) >> 16), byte(len(message) >> 8), byte(len(message))}, message...)
+}
+
+// serve answers the negotiate request with reply and returns what the
+// client sent
+func serve(conn net.Conn, reply []byte) <-chan []byte {
+	sent := make(chan []byte, 1)
+	go func() {
+		defer conn.Close()
+		request := make([]byte, len(negotiateRequest()))
+		if _, err := io.ReadFull(conn, request); err != nil {
+			sent <- nil
+			return
+		}
+		sent <- request
+		conn.Write(reply)
+		io.Copy(ioutil.Discard, conn)
+	}()
+	return sent
+}
+
+func negotiate(t *testing.T, reply []byte) (*SMBLog, error) {
+	client, server := net.Pipe()
+	defer client.Close()
+	sent := serve(server, reply)
+	log := new(SMBLog)
+	err := Negotiate(log, client)
+	request := <-sent
+	if !bytes.Equal(request[4:8], smb1Magic) || !bytes.Contains(request, []byte("\x02SMB 2.???\x00")) {
+		t.Errorf("Wrong negotiate request: %x", request)
+	}
+	return log, err
+}
+
+var testGUID = []byte{0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+
+func TestNegotiateSMB2(t *testing.T) {
+	header := make([]byte, smb2HeaderLen)
+	copy(header, smb2Magic)
+	binary.LittleEndian.PutUint16(header[4:], smb2HeaderLen)
+	body := make([]byte, smb2NegotiateLen+1)
+	binary.LittleEndian.PutUint16(body, 65)
+	binary.LittleEndian.PutUint16(body[2:], smb2SigningEnabled)
+	binary.LittleEndian.PutUint16(body[4:], 0x02ff)
+	copy(body[8:], testGUID)
+	binary.LittleEndian.PutUint32(body[24:], 0x07)
+	binary.LittleEndian.PutUint64(body[40:], testFiletime)
+
+	log, err := negotiate(t, frame(append(header, body...)))
+	if err != nil {
+		t.Fatalf("Negotiate: %s", err.Error())
+	}
+	if log.Protocol != ProtocolSMB2 || log.SMB1Only || log.Dialect != "2.???" || log.DialectRevision != 0x02ff {
+		t.Errorf("Wrong dialect: %+v", log)
+	}
+	if !log.SigningEnabled || log.SigningRequired {
+		t.Errorf("Wrong signing flags: %+v", log)
+	}
+	if log.ServerGUID != "00112233-4455-6677-8899-aabbccddeeff" {
+		t.Errorf("Wrong server GUID %s", log.ServerGUID)
+	}
+	if len(log.CapabilityNames) != 3 || log.CapabilityNames[2] != "large_mtu" {
+		t.Errorf("Wrong capabilities: %v", log.CapabilityNames)
+	}
+	if !log.SystemTime.Equal(time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)) || !log.ServerStartTime.IsZero() {
+		t.Errorf("Wrong times: %s, %s", log.SystemTime, log.ServerStartTime)
+	}
+}
+
+func smb1Response(words []byte, data []byte) []byte {
+	header := make([]byte, smb1HeaderLen)
+	copy(header, smb1Magic)
+	header[4] = smb1CommandNegotiate
+	message := append(header, byte(len(words)/2))
+	message = append(message, words...)
+	message = append(message, byte(len(data)), byte(len(data)>>8))
+	return frame(append(message, data...))
+}
+
+func TestNegotiateSMB1(t *testing.T) {
+	words := make([]byte, 2*smb1NTLM012WordCount)
+	words[2] = 0x03 | smb1SigningEnabled | smb1SigningRequired
+	binary.LittleEndian.PutUint32(words[19:], smb1ExtendedSecurity|0x04)
+	binary.LittleEndian.PutUint64(words[23:], testFiletime)
+	binary.LittleEndian.PutUint16(words[31:], uint16(0xffc4))
+
+	log, err := negotiate(t, smb1Response(words, testGUID))
+	if err != nil {
+		t.Fatalf("Negotiate: %s", err.Error())
+	}
+	if log.Protocol != ProtocolSMB1 || !log.SMB1Only || log.Dialect != "NT LM 0.12" {
+		t.Errorf("Wrong dialect: %+v", log)
+	}
+	if !log.SigningEnabled || !log.SigningRequired || log.TimeZone != -60 {
+		t.Errorf("Wrong security mode or time zone: %+v", log)
+	}
+	if log.ServerGUID != "00112233-4455-6677-8899-aabbccddeeff" || len(log.CapabilityNames) != 2 {
+		t.Errorf("Wrong extended security fields: %+v", log)
+	}
+}
+
+func TestNegotiateSMB1NoDialect(t *testing.T) {
+	log, err := negotiate(t, smb1Response([]byte{0xff, 0xff}, nil))
+	if err != errNoDialect || !log.SMB1Only {
+		t.Errorf("Expected errNoDialect, got %v: %+v", err, log)
+	}
+}
+
+func TestNegotiateNotSMB(t *testing.T) {
+	if _, err := negotiate(t, frame([]byte("HTTP/1.1 400"))); err != errNotSMB {
+		t.Errorf("Expected errNotSMB, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package snmp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/ber"
+)
+
+// The BER tags SNMP uses, RFC 3416 section 3
+const (
+	tagInteger        = 0x02
+	tagOctetString    = 0x04
+	tagNull           = 0x05
+	tagOID            = 0x06
+	tagSequence       = 0x30
+	tagIPAddress      = 0x40
+	tagCounter32      = 0x41
+	tagGauge32        = 0x42
+	tagTimeTicks      = 0x43
+	tagCounter64      = 0x46
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+	tagGetRequest     = 0xa0
+	tagGetResponse    = 0xa2
+)
+
+var (
+	errTruncated   = errors.New("snmp: truncated BER element")
+	errLongLength  = errors.New("snmp: BER length too long")
+	errInvalidOID  = errors.New("snmp: invalid object identifier")
+	errLongInteger = errors.New("snmp: BER integer too long")
+)
+
+func encodeTLV(tag byte, content ...[]byte) []byte {
+	var body []byte
+	for _, c := range content {
+		body = append(body, c...)
+	}
+	b := append([]byte{tag}, ber.EncodeLength(len(body))...)
+	return append(b, body...)
+}
+
+// encodeInteger returns the shortest two's complement encoding of n
+func encodeInteger(n int64) []byte {
+	b := []byte{byte(n)}
+	for n >= 0x80 || n < -0x80 {
+		n >>= 8
+		b = append([]byte{byte(n)}, b...)
+	}
+	return encodeTLV(tagInteger, b)
+}
+
+// encodeOID encodes a dotted object identifier, the first two arcs sharing
+// a byte and each arc in base 128 with the high bit marking continuation
+func encodeOID(oid string) ([]byte, error) {
+	parts := strings.Split(oid, ".")
+	if len(parts) < 2 {
+		return nil, errInvalidOID
+	}
+	arcs := make([]uint64, len(parts))
+	for i, p := range parts {
+		arc, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, errInvalidOID
+		}
+		arcs[i] = arc
+	}
+	if arcs[0] > 2 || (arcs[0] < 2 && arcs[1] >= 40) {
+		return nil, errInvalidOID
+	}
+	var body []byte
+	for _, arc := range append([]uint64{40*arcs[0] + arcs[1]}, arcs[2:]...) {
+		chunk := []byte{byte(arc & 0x7f)}
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			chunk = append([]byte{0x80 | byte(arc&0x7f)}, chunk...)
+		}
+		body = append(body, chunk...)
+	}
+	return encodeTLV(tagOID, body), nil
+}
+
+// readTLV splits the first element off b
+func readTLV(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errTruncated
+	}
+	tag, length, b := b[0], int(b[1]), b[2:]
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 3 {
+			return 0, nil, nil, errLongLength
+		}
+		if len(b) < n {
+			return 0, nil, nil, errTruncated
+		}
+		length = 0
+		for _, c := range b[:n] {
+			length = length<<8 | int(c)
+		}
+		b = b[n:]
+	}
+	if len(b) < length {
+		return 0, nil, nil, errTruncated
+	}
+	return tag, b[:length], b[length:], nil
+}
+
+// decodeInteger decodes a two's complement integer of up to 8 bytes
+func decodeInteger(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, errLongInteger
+	}
+	n := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+// decodeUnsigned decodes the unsigned application types, whose encoding
+// may carry a leading zero byte
+func decodeUnsigned(b []byte) (uint64, error) {
+	if len(b) == 0 || len(b) > 9 || (len(b) == 9 && b[0] != 0) {
+		return 0, errLongInteger
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+// decodeOID returns the dotted form of an encoded object identifier
+func decodeOID(b []byte) (string, error) {
+	if len(b) == 0 || b[len(b)-1]&0x80 != 0 {
+		return "", errInvalidOID
+	}
+	var arcs []string
+	var arc uint64
+	for _, c := range b {
+		if arc > 1<<56 {
+			return "", errInvalidOID
+		}
+		arc = arc<<7 | uint64(c&0x7f)
+		if c&0x80 != 0 {
+			continue
+		}
+		if arcs == nil {
+			first := arc / 40
+			if first > 2 {
+				first = 2
+			}
+			arcs = append(arcs, strconv.FormatUint(first, 10), strconv.FormatUint(arc-40*first, 10))
+		} else {
+			arcs = append(arcs, strconv.FormatUint(arc, 10))
+		}
+		arc = 0
+	}
+	return strings.Join(arcs, "."), nil
+}
@@ -0,0 +1,92 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package snmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadTLVLongLength(t *testing.T) {
+	content := bytes.Repeat([]byte{'a'}, 300)
+	b := append(encodeTLV(tagOctetString, content), 0x05, 0x00)
+	tag, got, rest, err := readTLV(b)
+	if err != nil {
+		t.Fatalf("readTLV: %s", err.Error())
+	}
+	if tag != tagOctetString || !bytes.Equal(got, content) || !bytes.Equal(rest, []byte{0x05, 0x00}) {
+		t.Errorf("Wrong element: tag %x, %d bytes, rest %x", tag, len(got), rest)
+	}
+	if _, _, _, err := readTLV(b[:100]); err != errTruncated {
+		t.Errorf("Expected errTruncated, got %v", err)
+	}
+	if _, _, _, err := readTLV([]byte{0x04, 0x85, 1, 2, 3, 4, 5}); err != errLongLength {
+		t.Errorf("Expected errLongLength, got %v", err)
+	}
+}
+
+func TestEncodeInteger(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want []byte
+	}{
+		{0, []byte{0x02, 0x01, 0x00}},
+		{127, []byte{0x02, 0x01, 0x7f}},
+		{128, []byte{0x02, 0x02, 0x00, 0x80}},
+		{-128, []byte{0x02, 0x01, 0x80}},
+		{-129, []byte{0x02, 0x02, 0xff, 0x7f}},
+		{0x12345678, []byte{0x02, 0x04, 0x12, 0x34, 0x56, 0x78}},
+	}
+	for _, test := range tests {
+		got := encodeInteger(test.n)
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("encodeInteger(%d) = %x, want %x", test.n, got, test.want)
+			continue
+		}
+		if n, err := decodeInteger(got[2:]); err != nil || n != test.n {
+			t.Errorf("decodeInteger(%x) = %d, %v", got[2:], n, err)
+		}
+	}
+}
+
+func TestOIDEncoding(t *testing.T) {
+	tests := []struct {
+		oid  string
+		want []byte
+	}{
+		{"1.3.6.1.2.1.1.1.0", []byte{0x06, 0x08, 0x2b, 6, 1, 2, 1, 1, 1, 0}},
+		// 311 and 4294967295 need multi-byte arcs
+		{"1.3.6.1.4.1.311", []byte{0x06, 0x07, 0x2b, 6, 1, 4, 1, 0x82, 0x37}},
+		{"2.999.4294967295", []byte{0x06, 0x07, 0x88, 0x37, 0x8f, 0xff, 0xff, 0xff, 0x7f}},
+	}
+	for _, test := range tests {
+		got, err := encodeOID(test.oid)
+		if err != nil || !bytes.Equal(got, test.want) {
+			t.Errorf("encodeOID(%s) = %x, %v, want %x", test.oid, got, err, test.want)
+			continue
+		}
+		if oid, err := decodeOID(got[2:]); err != nil || oid != test.oid {
+			t.Errorf("decodeOID(%x) = %s, %v", got[2:], oid, err)
+		}
+	}
+	for _, oid := range []string{"1", "3.1", "1.40", "1.3.x"} {
+		if _, err := encodeOID(oid); err != errInvalidOID {
+			t.Errorf("encodeOID(%s) accepted", oid)
+		}
+	}
+	if _, err := decodeOID([]byte{0x2b, 0x82}); err != errInvalidOID {
+		t.Errorf("Truncated OID accepted")
+	}
+}
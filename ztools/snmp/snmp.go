@@ -0,0 +1,234 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package snmp builds SNMPv1 and SNMPv2c GetRequests for the system group
+// and decodes the GetResponses, RFC 1157 and RFC 3416
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// Message versions, one less than the protocol version
+const (
+	Version1  = 0
+	Version2c = 1
+)
+
+// Object identifiers of the values requested
+const (
+	OIDSysDescr    = "1.3.6.1.2.1.1.1.0"
+	OIDSysObjectID = "1.3.6.1.2.1.1.2.0"
+	OIDSysName     = "1.3.6.1.2.1.1.5.0"
+)
+
+var systemOIDs = []string{OIDSysDescr, OIDSysObjectID, OIDSysName}
+
+var errorStatusNames = []string{
+	"noError",
+	"tooBig",
+	"noSuchName",
+	"badValue",
+	"readOnly",
+	"genErr",
+	"noAccess",
+	"wrongType",
+	"wrongLength",
+	"wrongEncoding",
+	"wrongValue",
+	"noCreation",
+	"inconsistentValue",
+	"resourceUnavailable",
+	"commitFailed",
+	"undoFailed",
+	"authorizationError",
+	"notWritable",
+	"inconsistentName",
+}
+
+// ErrorStatusName returns the name of a PDU error-status
+func ErrorStatusName(status int) string {
+	if status >= 0 && status < len(errorStatusNames) {
+		return errorStatusNames[status]
+	}
+	return fmt.Sprintf("unknown(%d)", status)
+}
+
+// A Binding is one variable in a response, its value rendered as text:
+// octet strings as is, object identifiers dotted and numbers in decimal.
+// Type is empty for v2c exceptions such as noSuchObject, named in Value.
+type Binding struct {
+	OID   string `json:"oid"`
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value"`
+}
+
+// A Response is a decoded GetResponse
+type Response struct {
+	Version     int        `json:"version"`
+	Community   string     `json:"community"`
+	RequestID   int32      `json:"request_id"`
+	ErrorStatus int        `json:"error_status"`
+	ErrorIndex  int        `json:"error_index"`
+	Bindings    []*Binding `json:"bindings,omitempty"`
+}
+
+// Value returns the value bound to oid, if the response has one
+func (r *Response) Value(oid string) (string, bool) {
+	for _, b := range r.Bindings {
+		if b.OID == oid && b.Type != "" {
+			return b.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetRequest returns a GetRequest for sysDescr.0, sysObjectID.0 and
+// sysName.0
+func GetRequest(version int, community string, requestID int32) []byte {
+	var bindings []byte
+	for _, oid := range systemOIDs {
+		name, _ := encodeOID(oid)
+		bindings = append(bindings, encodeTLV(tagSequence, name, []byte{tagNull, 0})...)
+	}
+	pdu := encodeTLV(tagGetRequest,
+		encodeInteger(int64(requestID)),
+		encodeInteger(0),
+		encodeInteger(0),
+		encodeTLV(tagSequence, bindings))
+	return encodeTLV(tagSequence,
+		encodeInteger(int64(version)),
+		encodeTLV(tagOctetString, []byte(community)),
+		pdu)
+}
+
+var errNotResponse = errors.New("snmp: not a GetResponse")
+
+// ParseResponse decodes a GetResponse message
+func ParseResponse(b []byte) (*Response, error) {
+	tag, message, _, err := readTLV(b)
+	if err != nil {
+		return nil, err
+	}
+	if tag != tagSequence {
+		return nil, errNotResponse
+	}
+	var fields [2][]byte
+	for i := range fields {
+		if tag, fields[i], message, err = readTLV(message); err != nil {
+			return nil, err
+		}
+	}
+	version, err := decodeInteger(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	res := &Response{Version: int(version), Community: string(fields[1])}
+
+	tag, pdu, _, err := readTLV(message)
+	if err != nil {
+		return nil, err
+	}
+	if tag != tagGetResponse {
+		return nil, errNotResponse
+	}
+	var ints [3]int64
+	for i := range ints {
+		var content []byte
+		if tag, content, pdu, err = readTLV(pdu); err != nil {
+			return nil, err
+		}
+		if tag != tagInteger {
+			return nil, errNotResponse
+		}
+		if ints[i], err = decodeInteger(content); err != nil {
+			return nil, err
+		}
+	}
+	res.RequestID, res.ErrorStatus, res.ErrorIndex = int32(ints[0]), int(ints[1]), int(ints[2])
+
+	_, list, _, err := readTLV(pdu)
+	if err != nil {
+		return nil, err
+	}
+	for len(list) > 0 {
+		var binding []byte
+		if _, binding, list, err = readTLV(list); err != nil {
+			return nil, err
+		}
+		bind, err := parseBinding(binding)
+		if err != nil {
+			return nil, err
+		}
+		res.Bindings = append(res.Bindings, bind)
+	}
+	return res, nil
+}
+
+func parseBinding(b []byte) (*Binding, error) {
+	_, name, b, err := readTLV(b)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := decodeOID(name)
+	if err != nil {
+		return nil, err
+	}
+	tag, value, _, err := readTLV(b)
+	if err != nil {
+		return nil, err
+	}
+	bind := &Binding{OID: oid}
+	switch tag {
+	case tagOctetString:
+		bind.Type, bind.Value = "octet_string", string(value)
+	case tagOID:
+		bind.Type = "oid"
+		bind.Value, err = decodeOID(value)
+	case tagInteger:
+		var n int64
+		n, err = decodeInteger(value)
+		bind.Type, bind.Value = "integer", strconv.FormatInt(n, 10)
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		var n uint64
+		n, err = decodeUnsigned(value)
+		bind.Type, bind.Value = unsignedTypes[tag], strconv.FormatUint(n, 10)
+	case tagIPAddress:
+		if len(value) != 4 {
+			return nil, errTruncated
+		}
+		bind.Type, bind.Value = "ip_address", net.IP(value).String()
+	case tagNull:
+		bind.Type = "null"
+	case tagNoSuchObject:
+		bind.Value = "noSuchObject"
+	case tagNoSuchInstance:
+		bind.Value = "noSuchInstance"
+	case tagEndOfMibView:
+		bind.Value = "endOfMibView"
+	default:
+		bind.Type, bind.Value = fmt.Sprintf("0x%02x", tag), fmt.Sprintf("%x", value)
+	}
+	return bind, err
+}
+
+var unsignedTypes = map[byte]string{
+	tagCounter32: "counter32",
+	tagGauge32:   "gauge32",
+	tagTimeTicks: "timeticks",
+	tagCounter64: "counter64",
+}
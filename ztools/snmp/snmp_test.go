@@ -0,0 +1,68 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package snmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGetRequest(t *testing.T) {
+	b := GetRequest(Version2c, "public", 0x1234)
+	want := []byte{0x30, 0x43, 0x02, 0x01, 0x01, 0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c', 0xa0, 0x36, 0x02, 0x02, 0x12, 0x34}
+	if !bytes.HasPrefix(b, want) || len(b) != 0x45 {
+		t.Errorf("Wrong GetRequest: %x", b)
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	descr, _ := encodeOID(OIDSysDescr)
+	objectID, _ := encodeOID(OIDSysObjectID)
+	name, _ := encodeOID(OIDSysName)
+	enterprise, _ := encodeOID("1.3.6.1.4.1.9.1.1")
+	bindings := encodeTLV(tagSequence,
+		encodeTLV(tagSequence, descr, encodeTLV(tagOctetString, []byte("Cisco IOS"))),
+		encodeTLV(tagSequence, objectID, enterprise),
+		encodeTLV(tagSequence, name, []byte{tagNoSuchInstance, 0}))
+	b := encodeTLV(tagSequence, encodeInteger(Version2c), encodeTLV(tagOctetString, []byte("private")),
+		encodeTLV(tagGetResponse, encodeInteger(7), encodeInteger(0), encodeInteger(0), bindings))
+
+	res, err := ParseResponse(b)
+	if err != nil {
+		t.Fatalf("ParseResponse: %s", err.Error())
+	}
+	if res.Version != Version2c || res.Community != "private" || res.RequestID != 7 || len(res.Bindings) != 3 {
+		t.Fatalf("Wrong response: %+v", res)
+	}
+	if v, ok := res.Value(OIDSysDescr); !ok || v != "Cisco IOS" {
+		t.Errorf("Wrong sysDescr %q", v)
+	}
+	if v, ok := res.Value(OIDSysObjectID); !ok || v != "1.3.6.1.4.1.9.1.1" {
+		t.Errorf("Wrong sysObjectID %q", v)
+	}
+	if _, ok := res.Value(OIDSysName); ok || res.Bindings[2].Value != "noSuchInstance" {
+		t.Errorf("Exception returned as a value: %+v", res.Bindings[2])
+	}
+
+	if _, err := ParseResponse(GetRequest(Version1, "public", 1)); err != errNotResponse {
+		t.Errorf("Expected errNotResponse, got %v", err)
+	}
+}
+
+func TestErrorStatusName(t *testing.T) {
+	if ErrorStatusName(2) != "noSuchName" || ErrorStatusName(16) != "authorizationError" || ErrorStatusName(99) != "unknown(99)" {
+		t.Errorf("Wrong error status names")
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package sslv2
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
+)
+
+// A CipherKind is a 3-byte SSLv2 cipher spec
+type CipherKind uint32
+
+// SSLv2 cipher kinds, see draft-hickman-netscape-ssl-00
+const (
+	SSL_CK_RC4_128_WITH_MD5              CipherKind = 0x010080
+	SSL_CK_RC4_128_EXPORT40_WITH_MD5     CipherKind = 0x020080
+	SSL_CK_RC2_128_CBC_WITH_MD5          CipherKind = 0x030080
+	SSL_CK_RC2_128_CBC_EXPORT40_WITH_MD5 CipherKind = 0x040080
+	SSL_CK_IDEA_128_CBC_WITH_MD5         CipherKind = 0x050080
+	SSL_CK_DES_64_CBC_WITH_MD5           CipherKind = 0x060040
+	SSL_CK_DES_192_EDE3_CBC_WITH_MD5     CipherKind = 0x0700C0
+)
+
+var cipherKindNames = map[CipherKind]string{
+	SSL_CK_RC4_128_WITH_MD5:              "SSL_CK_RC4_128_WITH_MD5",
+	SSL_CK_RC4_128_EXPORT40_WITH_MD5:     "SSL_CK_RC4_128_EXPORT40_WITH_MD5",
+	SSL_CK_RC2_128_CBC_WITH_MD5:          "SSL_CK_RC2_128_CBC_WITH_MD5",
+	SSL_CK_RC2_128_CBC_EXPORT40_WITH_MD5: "SSL_CK_RC2_128_CBC_EXPORT40_WITH_MD5",
+	SSL_CK_IDEA_128_CBC_WITH_MD5:         "SSL_CK_IDEA_128_CBC_WITH_MD5",
+	SSL_CK_DES_64_CBC_WITH_MD5:           "SSL_CK_DES_64_CBC_WITH_MD5",
+	SSL_CK_DES_192_EDE3_CBC_WITH_MD5:     "SSL_CK_DES_192_EDE3_CBC_WITH_MD5",
+}
+
+func (k CipherKind) String() string {
+	if name, ok := cipherKindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (k CipherKind) MarshalJSON() ([]byte, error) {
+	aux := struct {
+		Hex   string `json:"hex"`
+		Name  string `json:"name"`
+		Value int    `json:"value"`
+	}{
+		Hex:   fmt.Sprintf("0x%06X", uint32(k)),
+		Name:  k.String(),
+		Value: int(k),
+	}
+	return json.Marshal(&aux)
+}
+
+// An SSLv2Log records the SERVER-HELLO sent in reply to an SSLv2
+// CLIENT-HELLO. Supported is false, with Error set, when the reply was not
+// an SSLv2 SERVER-HELLO.
+type SSLv2Log struct {
+	Supported       bool                    `json:"supported"`
+	Version         uint16                  `json:"version,omitempty"`
+	SessionIDHit    bool                    `json:"session_id_hit,omitempty"`
+	CertificateType uint8                   `json:"certificate_type,omitempty"`
+	Certificate     *ztls.SimpleCertificate `json:"certificate,omitempty"`
+	CipherSpecs     []CipherKind            `json:"cipher_specs,omitempty"`
+	ConnectionID    []byte                  `json:"connection_id,omitempty"`
+	Error           string                  `json:"error,omitempty"`
+	ErrorClass      string                  `json:"error_class,omitempty"`
+}
@@ -0,0 +1,175 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package sslv2
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/x509"
+	"gopkg.in/eniac/zgrab.v0/ztools/zerrors"
+	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
+)
+
+const (
+	msgClientHello = 1
+	msgServerHello = 4
+
+	version2 = 0x0002
+
+	certificateTypeX509 = 1
+
+	// Records are at most 32767 bytes with a 2-byte header
+	maxRecordLength = 0x7fff
+)
+
+// errNotSSLv2 is recorded when the reply cannot be an SSLv2 SERVER-HELLO
+var errNotSSLv2 = errors.New("not sslv2")
+
+// ClientCipherSpecs are the cipher kinds offered in the CLIENT-HELLO
+var ClientCipherSpecs = []CipherKind{
+	SSL_CK_RC4_128_WITH_MD5,
+	SSL_CK_RC4_128_EXPORT40_WITH_MD5,
+	SSL_CK_RC2_128_CBC_WITH_MD5,
+	SSL_CK_RC2_128_CBC_EXPORT40_WITH_MD5,
+	SSL_CK_IDEA_128_CBC_WITH_MD5,
+	SSL_CK_DES_64_CBC_WITH_MD5,
+	SSL_CK_DES_192_EDE3_CBC_WITH_MD5,
+}
+
+// Probe sends an SSLv2 CLIENT-HELLO on connection and records the server's
+// SERVER-HELLO in logStruct. The session is never completed. A reply that
+// is not an SSLv2 SERVER-HELLO, including the connection being closed, is
+// recorded as unsupported; only other read and write errors are returned.
+func Probe(logStruct *SSLv2Log, connection net.Conn) error {
+	hello, err := marshalClientHello(ClientCipherSpecs)
+	if err != nil {
+		return err
+	}
+	if _, err := connection.Write(hello); err != nil {
+		return err
+	}
+	body, err := readRecord(connection)
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == errNotSSLv2 {
+		logStruct.Error = errNotSSLv2.Error()
+		logStruct.ErrorClass = zerrors.Classify(err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := parseServerHello(logStruct, body); err != nil {
+		logStruct.Error = errNotSSLv2.Error() + ": " + err.Error()
+		logStruct.ErrorClass = zerrors.Classify(err)
+	}
+	return nil
+}
+
+// marshalClientHello returns a CLIENT-HELLO record offering specs, with no
+// session ID and a random 16-byte challenge
+func marshalClientHello(specs []CipherKind) ([]byte, error) {
+	challenge := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, challenge); err != nil {
+		return nil, err
+	}
+	bodyLen := 9 + 3*len(specs) + len(challenge)
+	b := make([]byte, 2, 2+bodyLen)
+	b[0] = byte(bodyLen>>8) | 0x80
+	b[1] = byte(bodyLen)
+	b = append(b, msgClientHello, version2>>8, version2&0xff)
+	b = append(b, byte(3*len(specs)>>8), byte(3*len(specs)))
+	b = append(b, 0, 0)
+	b = append(b, byte(len(challenge)>>8), byte(len(challenge)))
+	for _, spec := range specs {
+		b = append(b, byte(spec>>16), byte(spec>>8), byte(spec))
+	}
+	return append(b, challenge...), nil
+}
+
+// readRecord reads one SSLv2 record and returns its body without padding.
+// A TLS record, which begins with a content type below 0x80 and a 3.x
+// version, is rejected as errNotSSLv2 after its header.
+func readRecord(connection net.Conn) ([]byte, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(connection, header[:2]); err != nil {
+		return nil, err
+	}
+	var length, padding int
+	if header[0]&0x80 != 0 {
+		length = int(header[0]&0x7f)<<8 | int(header[1])
+	} else {
+		// 3-byte header, used when the body is padded
+		if header[0]&0x40 != 0 || header[1] == 3 {
+			return nil, errNotSSLv2
+		}
+		if _, err := io.ReadFull(connection, header[2:]); err != nil {
+			return nil, err
+		}
+		length = int(header[0]&0x3f)<<8 | int(header[1])
+		padding = int(header[2])
+	}
+	if length == 0 || length > maxRecordLength || padding > length {
+		return nil, errNotSSLv2
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(connection, body); err != nil {
+		return nil, err
+	}
+	return body[:length-padding], nil
+}
+
+// parseServerHello fills logStruct from a SERVER-HELLO message body
+func parseServerHello(logStruct *SSLv2Log, b []byte) error {
+	if len(b) < 11 {
+		return errors.New("short SERVER-HELLO")
+	}
+	if b[0] != msgServerHello {
+		return errors.New("unexpected message type")
+	}
+	certLen := int(b[5])<<8 | int(b[6])
+	specsLen := int(b[7])<<8 | int(b[8])
+	idLen := int(b[9])<<8 | int(b[10])
+	rest := b[11:]
+	if specsLen%3 != 0 || certLen+specsLen+idLen != len(rest) {
+		return errors.New("bad SERVER-HELLO lengths")
+	}
+
+	logStruct.Supported = true
+	logStruct.SessionIDHit = b[1] != 0
+	logStruct.CertificateType = b[2]
+	logStruct.Version = uint16(b[3])<<8 | uint16(b[4])
+
+	if certLen > 0 {
+		raw := rest[:certLen]
+		cert := &ztls.SimpleCertificate{Raw: raw}
+		if logStruct.CertificateType == certificateTypeX509 {
+			if parsed, err := x509.ParseCertificate(raw); err != nil {
+				cert.ParseError = err.Error()
+			} else {
+				cert.Parsed = parsed
+			}
+		}
+		logStruct.Certificate = cert
+	}
+	specs := rest[certLen : certLen+specsLen]
+	for i := 0; i < len(specs); i += 3 {
+		spec := CipherKind(specs[i])<<16 | CipherKind(specs[i+1])<<8 | CipherKind(specs[i+2])
+		logStruct.CipherSpecs = append(logStruct.CipherSpecs, spec)
+	}
+	logStruct.ConnectionID = rest[certLen+specsLen:]
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package sslv2
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	stdx509 "crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveReply reads the CLIENT-HELLO, then writes reply and closes
+func serveReply(t *testing.T, reply []byte) net.Conn {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		header := make([]byte, 2)
+		if _, err := server.Read(header); err != nil {
+			return
+		}
+		body := make([]byte, int(header[0]&0x7f)<<8|int(header[1]))
+		for n := 0; n < len(body); {
+			m, err := server.Read(body[n:])
+			if err != nil {
+				return
+			}
+			n += m
+		}
+		if body[0] != msgClientHello {
+			t.Errorf("Wrong message type %d", body[0])
+		}
+		server.Write(reply)
+	}()
+	client.SetDeadline(time.Now().Add(3 * time.Second))
+	return client
+}
+
+func selfSignedCertificate(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &stdx509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sslv2.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := stdx509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func serverHello(cert []byte, specs []CipherKind, connectionID []byte) []byte {
+	body := []byte{msgServerHello, 0, certificateTypeX509, 0, 2,
+		byte(len(cert) >> 8), byte(len(cert)),
+		0, byte(3 * len(specs)),
+		0, byte(len(connectionID))}
+	body = append(body, cert...)
+	for _, spec := range specs {
+		body = append(body, byte(spec>>16), byte(spec>>8), byte(spec))
+	}
+	body = append(body, connectionID...)
+	return append([]byte{byte(len(body)>>8) | 0x80, byte(len(body))}, body...)
+}
+
+func TestProbeParsesServerHello(t *testing.T) {
+	cert := selfSignedCertificate(t)
+	specs := []CipherKind{SSL_CK_RC4_128_EXPORT40_WITH_MD5, SSL_CK_DES_192_EDE3_CBC_WITH_MD5}
+	connectionID := bytes.Repeat([]byte{0xab}, 16)
+
+	log := new(SSLv2Log)
+	if err := Probe(log, serveReply(t, serverHello(cert, specs, connectionID))); err != nil {
+		t.Fatalf("Probe: %s", err)
+	}
+	if !log.Supported || log.Version != 2 || log.Error != "" {
+		t.Fatalf("SERVER-HELLO not recorded: %+v", *log)
+	}
+	if len(log.CipherSpecs) != 2 || log.CipherSpecs[0] != specs[0] || log.CipherSpecs[1] != specs[1] {
+		t.Errorf("Wrong cipher specs: %v", log.CipherSpecs)
+	}
+	if !bytes.Equal(log.ConnectionID, connectionID) {
+		t.Errorf("Wrong connection ID: %x", log.ConnectionID)
+	}
+	if log.Certificate == nil || log.Certificate.Parsed == nil || log.Certificate.Parsed.Subject.CommonName != "sslv2.example.com" {
+		t.Errorf("Certificate not parsed: %+v", log.Certificate)
+	}
+}
+
+func TestProbeNotSSLv2(t *testing.T) {
+	replies := map[string][]byte{
+		"tls alert":   {0x15, 0x03, 0x01, 0x00, 0x02, 0x02, 0x28},
+		"http":        []byte("HTTP/1.1 400 Bad Request\r\n\r\n"),
+		"closed":      nil,
+		"bad lengths": {0x80, 0x0b, msgServerHello, 0, 1, 0, 2, 0xff, 0xff, 0, 3, 0, 0},
+	}
+	for name, reply := range replies {
+		log := new(SSLv2Log)
+		if err := Probe(log, serveReply(t, reply)); err != nil {
+			t.Errorf("%s: Probe returned an error: %s", name, err)
+			continue
+		}
+		if log.Supported || log.Error == "" || log.ErrorClass == "" {
+			t.Errorf("%s: not recorded as unsupported: %+v", name, *log)
+		}
+	}
+}
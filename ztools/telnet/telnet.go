@@ -15,12 +15,10 @@
 package telnet
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"math"
 	"net"
 )
 
@@ -31,9 +29,14 @@ const (
 	DO                 = byte(253)
 	WONT               = byte(252)
 	WILL               = byte(251)
+	SB                 = byte(250) // Start of subnegotiation
 	GO_AHEAD           = byte(249) // Special go ahead command
+	SE                 = byte(240) // End of subnegotiation
 	IAC_CMD_LENGTH     = 3         // IAC commands take 3 bytes (inclusive)
 	READ_BUFFER_LENGTH = 8192
+
+	// Reads without any banner text before giving up on the negotiation
+	MAX_NEGOTIATION_ROUNDS = 16
 )
 
 type TelnetOption uint16
@@ -71,132 +74,142 @@ func (opt *TelnetOption) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func GetTelnetBanner(logStruct *TelnetLog, conn net.Conn, maxReadSize int) (err error) {
-	if err = NegotiateOptions(logStruct, conn); err != nil {
-		return err
-	}
-
-	var bannerSlice []byte
-	//grab banner
+// GetTelnetBanner reads the server's banner, up to maxReadSize bytes, with
+// IAC sequences stripped. Every DO and WILL is refused once with WONT or
+// DONT, and all negotiation the server attempts is recorded in logStruct.
+// Reading stops at the first short read carrying banner text, or once the
+// server has spent MAX_NEGOTIATION_ROUNDS reads on nothing but negotiation.
+func GetTelnetBanner(logStruct *TelnetLog, conn net.Conn, maxReadSize int) error {
+	n := &negotiator{log: logStruct, refused: make(map[[2]byte]bool)}
 	buffer := make([]byte, READ_BUFFER_LENGTH)
+	var banner []byte
+	rounds := 0
+	for len(banner) < maxReadSize {
+		numBytes, err := conn.Read(buffer)
+		data, reply := n.parse(buffer[0:numBytes])
+		if room := maxReadSize - len(banner); len(data) > room {
+			data = data[0:room]
+		}
+		banner = append(banner, data...)
+		logStruct.Banner = string(banner)
 
-	numBytes := len(buffer)
-	rounds := int(math.Ceil(float64(maxReadSize) / READ_BUFFER_LENGTH))
-	count := 0
-	for numBytes != 0 && count < rounds && numBytes == READ_BUFFER_LENGTH {
-
-		numBytes, err = conn.Read(buffer)
 		// ignore timeout errors if there is already banner content
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			if len(logStruct.Banner) == 0 {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			if len(banner) == 0 {
 				return err
-			} else {
-				break
 			}
+			return nil
 		}
-
-		if err != nil && err != io.EOF {
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
 			return err
 		}
 
-		if containsIAC(buffer) {
+		if len(reply) > 0 {
+			if _, err := conn.Write(reply); err != nil {
+				return err
+			}
+		}
+		if len(data) > 0 {
+			if numBytes < len(buffer) {
+				return nil
+			}
 			continue
 		}
-
-		if count == rounds-1 {
-			bannerSlice = append(bannerSlice, buffer[0:maxReadSize%READ_BUFFER_LENGTH]...)
-		} else {
-			bannerSlice = append(bannerSlice, buffer[0:numBytes]...)
+		if rounds++; rounds >= MAX_NEGOTIATION_ROUNDS {
+			return fmt.Errorf("telnet server still negotiating after %d reads", rounds)
 		}
-		count += 1
 	}
-
-	logStruct.Banner += string(bannerSlice)
-
 	return nil
 }
 
-func NegotiateOptions(logStruct *TelnetLog, conn net.Conn) error {
-	var readBuffer, retBuffer []byte
-	var option, optionType, returnOptionType byte
-	var iacIndex, firstUnreadIndex, numBytes, numDataBytes int
-	var err error
-
-	for finishedNegotiating := false; finishedNegotiating == false; {
-		readBuffer = make([]byte, READ_BUFFER_LENGTH)
-		retBuffer = nil
-		numBytes, err = conn.Read(readBuffer)
-		numDataBytes = numBytes
-
-		if err != nil {
-			return err
-		}
-
-		if numBytes == len(readBuffer) {
-			return errors.New("Not enough buffer space for telnet options")
-		}
-
-		// Negotiate options
+// Parser states between calls to negotiator.parse
+const (
+	stateData = iota
+	stateIAC
+	stateOption
+	stateSubnegotiation
+	stateSubnegotiationIAC
+)
 
-		for iacIndex = bytes.IndexByte(readBuffer, IAC); iacIndex != -1; iacIndex = bytes.IndexByte(readBuffer, IAC) {
-			firstUnreadIndex = 0
-			optionType = readBuffer[iacIndex+1]
-			option = readBuffer[iacIndex+2]
+// A negotiator strips IAC sequences from the byte stream, keeping its state
+// across reads so sequences split between two reads are still recognized
+type negotiator struct {
+	log     *TelnetLog
+	state   int
+	command byte
+	refused map[[2]byte]bool
+}
 
-			// ignore go ahead
-			if optionType == GO_AHEAD {
-				readBuffer = readBuffer[0:iacIndex]
-				numBytes = iacIndex
-				firstUnreadIndex = 0
-				break
+// parse returns the banner bytes in b and the replies to the negotiation
+func (n *negotiator) parse(b []byte) (data, reply []byte) {
+	for _, c := range b {
+		switch n.state {
+		case stateData:
+			if c == IAC {
+				n.state = stateIAC
+			} else {
+				data = append(data, c)
 			}
-
-			// record all offered options
-			opt := TelnetOption(option)
-			if optionType == WILL {
-				logStruct.Will = append(logStruct.Will, opt)
-			} else if optionType == DO {
-				logStruct.Do = append(logStruct.Do, opt)
-			} else if optionType == WONT {
-				logStruct.Wont = append(logStruct.Wont, opt)
-			} else if optionType == DONT {
-				logStruct.Dont = append(logStruct.Dont, opt)
+		case stateIAC:
+			switch c {
+			case IAC:
+				// escaped 0xff data byte
+				data = append(data, IAC)
+				n.state = stateData
+			case WILL, WONT, DO, DONT:
+				n.command = c
+				n.state = stateOption
+			case SB:
+				n.state = stateSubnegotiation
+			default:
+				// GO_AHEAD, NOP and the other two byte commands
+				n.state = stateData
 			}
-
-			// reject all offered options
-			if optionType == WILL || optionType == WONT {
-				returnOptionType = DONT
-			} else if optionType == DO || optionType == DONT {
-				returnOptionType = WONT
+		case stateOption:
+			reply = append(reply, n.option(n.command, c)...)
+			n.state = stateData
+		case stateSubnegotiation:
+			if c == IAC {
+				n.state = stateSubnegotiationIAC
+			}
+		case stateSubnegotiationIAC:
+			if c == SE {
+				n.state = stateData
 			} else {
-				return errors.New("Unsupported telnet IAC option type" + fmt.Sprintf("%d", optionType))
+				n.state = stateSubnegotiation
 			}
-
-			retBuffer = append(retBuffer, IAC)
-			retBuffer = append(retBuffer, returnOptionType)
-			retBuffer = append(retBuffer, option)
-
-			firstUnreadIndex = iacIndex + IAC_CMD_LENGTH
-			numDataBytes -= firstUnreadIndex
-			readBuffer = readBuffer[firstUnreadIndex:]
-		}
-
-		if _, err = conn.Write(retBuffer); err != nil {
-			return err
 		}
-
-		numIACBytes := numBytes - numDataBytes
-		finishedNegotiating = numBytes != numIACBytes
 	}
-
-	// no more IAC commands, just read the resulting data
-	if numDataBytes >= 0 {
-		logStruct.Banner = string(readBuffer[0:numDataBytes])
-	}
-
-	return nil
+	return data, reply
 }
 
-func containsIAC(buffer []byte) bool {
-	return bytes.IndexByte(buffer, IAC) != -1
+// option records a negotiation command and returns the reply to it. Only
+// requests are answered, and each only once, so a server repeating itself
+// can't drag us into a negotiation loop.
+func (n *negotiator) option(command, option byte) []byte {
+	opt := TelnetOption(option)
+	var reply byte
+	switch command {
+	case WILL:
+		n.log.Will = append(n.log.Will, opt)
+		reply = DONT
+	case DO:
+		n.log.Do = append(n.log.Do, opt)
+		reply = WONT
+	case WONT:
+		n.log.Wont = append(n.log.Wont, opt)
+		return nil
+	case DONT:
+		n.log.Dont = append(n.log.Dont, opt)
+		return nil
+	}
+	key := [2]byte{command, option}
+	if n.refused[key] {
+		return nil
+	}
+	n.refused[key] = true
+	return []byte{IAC, reply, option}
 }
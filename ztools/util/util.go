@@ -15,6 +15,10 @@ import (
 	"strings"
 )
 
+// ErrBufferFull is returned when a response does not fit the buffer or the
+// maximum length it may grow to
+var ErrBufferFull = errors.New("Not enough buffer space")
+
 func ReadUntilRegex(connection net.Conn, res []byte, expr *regexp.Regexp) (int, error) {
 
 	buf := res[0:]
@@ -29,13 +33,51 @@ func ReadUntilRegex(connection net.Conn, res []byte, expr *regexp.Regexp) (int,
 			finished = true
 		}
 		if length == len(res) {
-			return length, errors.New("Not enough buffer space")
+			return length, ErrBufferFull
 		}
 		buf = res[length:]
 	}
 	return length, nil
 }
 
+// ReadUntilRegexGrowing reads from connection until the accumulated data
+// matches expr. Unlike ReadUntilRegex, res is only used as the initial
+// buffer: it is grown as needed, up to maxLength bytes, and the accumulated
+// data is returned. Growing reslices into spare capacity of res before
+// allocating, so a pooled buffer of greater capacity is reused.
+func ReadUntilRegexGrowing(connection net.Conn, res []byte, expr *regexp.Regexp, maxLength int) ([]byte, error) {
+	length := 0
+	for {
+		if length == len(res) {
+			if length >= maxLength {
+				return res[0:length], ErrBufferFull
+			}
+			size := 2 * len(res)
+			if size == 0 {
+				size = 512
+			}
+			if size > maxLength {
+				size = maxLength
+			}
+			if cap(res) >= size {
+				res = res[0:size]
+			} else {
+				grown := make([]byte, size)
+				copy(grown, res[0:length])
+				res = grown
+			}
+		}
+		n, err := connection.Read(res[length:])
+		length += n
+		if err != nil {
+			return res[0:length], err
+		}
+		if expr.Match(res[0:length]) {
+			return res[0:length], nil
+		}
+	}
+}
+
 // Checks for a strict TLD match
 func TLDMatches(host1 string, host2 string) bool {
 	splitStr1 := strings.Split(stripPortNumber(host1), ".")
@@ -0,0 +1,41 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package vnc
+
+// A SecurityType is an RFB security type or VeNCrypt subtype
+type SecurityType struct {
+	ID   uint32 `json:"id"`
+	Name string `json:"name"`
+}
+
+// A VNCLog records the RFB handshake up to the point where the client would
+// authenticate. ProtocolVersion is the version string as sent, which some
+// vendors extend with a product name.
+type VNCLog struct {
+	ProtocolVersion string          `json:"protocol_version"`
+	ServerMajor     int             `json:"server_major"`
+	ServerMinor     int             `json:"server_minor"`
+	ClientVersion   string          `json:"client_version,omitempty"`
+	SecurityTypes   []*SecurityType `json:"security_types,omitempty"`
+	// Set when the server refused the connection instead of listing
+	// security types
+	ConnectionFailed bool   `json:"connection_failed,omitempty"`
+	FailureReason    string `json:"failure_reason,omitempty"`
+
+	// The VeNCrypt negotiation, with --vnc-tls
+	VeNCryptVersion  string          `json:"vencrypt_version,omitempty"`
+	VeNCryptSubtypes []*SecurityType `json:"vencrypt_subtypes,omitempty"`
+	TLSSecurityType  *SecurityType   `json:"tls_security_type,omitempty"`
+}
@@ -0,0 +1,259 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package vnc reads the RFB version and security types a VNC server offers,
+// RFC 6143, and optionally negotiates TLS through VeNCrypt or the TLS
+// security type so the certificate can be captured.
+package vnc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+)
+
+// Security types and VeNCrypt subtypes
+const (
+	SecurityInvalid   = 0
+	SecurityNone      = 1
+	SecurityVNCAuth   = 2
+	SecurityTLS       = 18
+	SecurityVeNCrypt  = 19
+	VeNCryptTLSNone   = 257
+	VeNCryptTLSVNC    = 258
+	VeNCryptTLSPlain  = 259
+	VeNCryptX509None  = 260
+	VeNCryptX509VNC   = 261
+	VeNCryptX509Plain = 262
+	VeNCryptTLSSASL   = 263
+	VeNCryptX509SASL  = 264
+)
+
+const (
+	// "RFB 003.008\n"
+	versionLength = 12
+	// Failure reasons longer than this are truncated
+	maxReasonLength = 4096
+)
+
+var securityTypeNames = map[uint32]string{
+	SecurityInvalid:   "invalid",
+	SecurityNone:      "none",
+	SecurityVNCAuth:   "vnc_auth",
+	5:                 "ra2",
+	6:                 "ra2ne",
+	16:                "tight",
+	17:                "ultra",
+	SecurityTLS:       "tls",
+	SecurityVeNCrypt:  "vencrypt",
+	20:                "sasl",
+	21:                "md5",
+	22:                "xvp",
+	30:                "apple_remote_desktop",
+	113:               "ms_logon_ii",
+	VeNCryptTLSNone:   "tls_none",
+	VeNCryptTLSVNC:    "tls_vnc",
+	VeNCryptTLSPlain:  "tls_plain",
+	VeNCryptX509None:  "x509_none",
+	VeNCryptX509VNC:   "x509_vnc",
+	VeNCryptX509Plain: "x509_plain",
+	VeNCryptTLSSASL:   "tls_sasl",
+	VeNCryptX509SASL:  "x509_sasl",
+}
+
+func securityType(id uint32) *SecurityType {
+	name, ok := securityTypeNames[id]
+	if !ok {
+		name = "unknown_" + strconv.Itoa(int(id))
+	}
+	return &SecurityType{ID: id, Name: name}
+}
+
+var versionRegex = regexp.MustCompile(`^RFB (\d{3})\.(\d{3})`)
+
+var errNotRFB = errors.New("Server did not send an RFB protocol version")
+
+// GetVNCBanner reads the server's protocol version, answers with the
+// highest version both sides speak, at most 3.8, and reads the offered
+// security types or the reason the connection failed
+func GetVNCBanner(logStruct *VNCLog, connection net.Conn) error {
+	version := make([]byte, versionLength)
+	n, err := io.ReadFull(connection, version)
+	logStruct.ProtocolVersion = string(version[:n])
+	if err != nil {
+		return err
+	}
+	m := versionRegex.FindStringSubmatch(logStruct.ProtocolVersion)
+	if m == nil {
+		return errNotRFB
+	}
+	logStruct.ServerMajor, _ = strconv.Atoi(m[1])
+	logStruct.ServerMinor, _ = strconv.Atoi(m[2])
+
+	// 3.3 clients get the security type picked by the server, and versions
+	// between 3.3 and 3.7 must be treated as 3.3
+	minor := 3
+	switch {
+	case logStruct.ServerMajor > 3 || logStruct.ServerMinor >= 8:
+		minor = 8
+	case logStruct.ServerMinor == 7:
+		minor = 7
+	}
+	logStruct.ClientVersion = fmt.Sprintf("RFB 003.%03d\n", minor)
+	if _, err := connection.Write([]byte(logStruct.ClientVersion)); err != nil {
+		return err
+	}
+
+	if minor == 3 {
+		var b [4]byte
+		if _, err := io.ReadFull(connection, b[:]); err != nil {
+			return err
+		}
+		id := binary.BigEndian.Uint32(b[:])
+		if id == SecurityInvalid {
+			return readFailureReason(logStruct, connection)
+		}
+		logStruct.SecurityTypes = []*SecurityType{securityType(id)}
+		return nil
+	}
+
+	var count [1]byte
+	if _, err := io.ReadFull(connection, count[:]); err != nil {
+		return err
+	}
+	if count[0] == 0 {
+		return readFailureReason(logStruct, connection)
+	}
+	types := make([]byte, count[0])
+	if _, err := io.ReadFull(connection, types); err != nil {
+		return err
+	}
+	for _, id := range types {
+		logStruct.SecurityTypes = append(logStruct.SecurityTypes, securityType(uint32(id)))
+	}
+	return nil
+}
+
+// readFailureReason reads the length-prefixed reason a server sends when
+// it refuses the connection, e.g. after too many authentication failures.
+// The refusal is a result rather than an error.
+func readFailureReason(logStruct *VNCLog, connection net.Conn) error {
+	logStruct.ConnectionFailed = true
+	var b [4]byte
+	if _, err := io.ReadFull(connection, b[:]); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(b[:])
+	if length > maxReasonLength {
+		length = maxReasonLength
+	}
+	reason := make([]byte, length)
+	n, err := io.ReadFull(connection, reason)
+	logStruct.FailureReason = string(reason[:n])
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return err
+}
+
+// offers reports whether the server listed security type id
+func (logStruct *VNCLog) offers(id uint32) bool {
+	for _, t := range logStruct.SecurityTypes {
+		if t.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupTLS selects VeNCrypt, preferring a subtype with an X.509
+// certificate, or failing that the TLS security type. It returns true when
+// the server is ready for the TLS handshake, and false without error when
+// neither is offered or no TLS subtype was acceptable. Only RFB 3.7 and
+// later let the client choose.
+func SetupTLS(logStruct *VNCLog, connection net.Conn) (bool, error) {
+	if logStruct.ClientVersion == "RFB 003.003\n" {
+		return false, nil
+	}
+	if !logStruct.offers(SecurityVeNCrypt) {
+		if !logStruct.offers(SecurityTLS) {
+			return false, nil
+		}
+		if _, err := connection.Write([]byte{SecurityTLS}); err != nil {
+			return false, err
+		}
+		logStruct.TLSSecurityType = securityType(SecurityTLS)
+		return true, nil
+	}
+
+	if _, err := connection.Write([]byte{SecurityVeNCrypt}); err != nil {
+		return false, err
+	}
+	var version [2]byte
+	if _, err := io.ReadFull(connection, version[:]); err != nil {
+		return false, err
+	}
+	logStruct.VeNCryptVersion = fmt.Sprintf("%d.%d", version[0], version[1])
+	if version[0] != 0 || version[1] < 2 {
+		return false, fmt.Errorf("Unsupported VeNCrypt version %s", logStruct.VeNCryptVersion)
+	}
+	if _, err := connection.Write([]byte{0, 2}); err != nil {
+		return false, err
+	}
+	var b [4]byte
+	if _, err := io.ReadFull(connection, b[:1]); err != nil {
+		return false, err
+	}
+	if b[0] != 0 {
+		return false, errors.New("Server refused VeNCrypt version 0.2")
+	}
+	if _, err := io.ReadFull(connection, b[:1]); err != nil {
+		return false, err
+	}
+	subtypes := make([]byte, 4*int(b[0]))
+	if _, err := io.ReadFull(connection, subtypes); err != nil {
+		return false, err
+	}
+	var chosen *SecurityType
+	for i := 0; i < len(subtypes); i += 4 {
+		t := securityType(binary.BigEndian.Uint32(subtypes[i:]))
+		logStruct.VeNCryptSubtypes = append(logStruct.VeNCryptSubtypes, t)
+		if t.ID >= VeNCryptTLSNone && t.ID <= VeNCryptX509SASL && (chosen == nil || isX509(t.ID) && !isX509(chosen.ID)) {
+			chosen = t
+		}
+	}
+	if chosen == nil {
+		return false, nil
+	}
+	binary.BigEndian.PutUint32(b[:], chosen.ID)
+	if _, err := connection.Write(b[:]); err != nil {
+		return false, err
+	}
+	if _, err := io.ReadFull(connection, b[:1]); err != nil {
+		return false, err
+	}
+	if b[0] != 1 {
+		return false, fmt.Errorf("Server refused VeNCrypt subtype %s", chosen.Name)
+	}
+	logStruct.TLSSecurityType = chosen
+	return true, nil
+}
+
+func isX509(id uint32) bool {
+	return id == VeNCryptX509None || id == VeNCryptX509VNC || id == VeNCryptX509Plain || id == VeNCryptX509SASL
+}
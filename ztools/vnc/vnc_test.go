@@ -0,0 +1,102 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package vnc
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// script plays a server: it writes each []byte entry and reads as many
+// bytes as each int entry, recording what the client sent
+func script(conn net.Conn, steps ...interface{}) *bytes.Buffer {
+	sent := new(bytes.Buffer)
+	go func() {
+		defer conn.Close()
+		for _, step := range steps {
+			switch s := step.(type) {
+			case []byte:
+				if _, err := conn.Write(s); err != nil {
+					return
+				}
+			case int:
+				if _, err := io.CopyN(sent, conn, int64(s)); err != nil {
+					return
+				}
+			}
+		}
+		io.Copy(ioutil.Discard, conn)
+	}()
+	return sent
+}
+
+func TestGetVNCBannerSecurityTypes(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	script(server, []byte("RFB 003.889\n"), 12, []byte{3, 1, 2, 19})
+
+	log := new(VNCLog)
+	if err := GetVNCBanner(log, client); err != nil {
+		t.Fatalf("GetVNCBanner: %s", err.Error())
+	}
+	if log.ProtocolVersion != "RFB 003.889\n" || log.ServerMinor != 889 || log.ClientVersion != "RFB 003.008\n" {
+		t.Errorf("Wrong versions: %+v", log)
+	}
+	if len(log.SecurityTypes) != 3 || log.SecurityTypes[0].Name != "none" || log.SecurityTypes[2].Name != "vencrypt" {
+		t.Errorf("Wrong security types: %+v", log.SecurityTypes)
+	}
+}
+
+func TestGetVNCBannerFailureReason(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	reason := "Too many security failures"
+	script(server, []byte("RFB 003.003\n"), 12, append([]byte{0, 0, 0, 0, 0, 0, 0, byte(len(reason))}, reason...))
+
+	log := new(VNCLog)
+	if err := GetVNCBanner(log, client); err != nil {
+		t.Fatalf("GetVNCBanner: %s", err.Error())
+	}
+	if log.ClientVersion != "RFB 003.003\n" || !log.ConnectionFailed || log.FailureReason != reason {
+		t.Errorf("Wrong log: %+v", log)
+	}
+}
+
+func TestSetupTLSPrefersX509(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	sent := script(server,
+		[]byte("RFB 003.008\n"), 12, []byte{2, 2, 19},
+		1, []byte{0, 2}, 2, []byte{0, 3, 0, 0, 1, 1, 0, 0, 1, 4, 0, 0, 1, 6},
+		4, []byte{1})
+
+	log := new(VNCLog)
+	if err := GetVNCBanner(log, client); err != nil {
+		t.Fatalf("GetVNCBanner: %s", err.Error())
+	}
+	ok, err := SetupTLS(log, client)
+	if err != nil || !ok {
+		t.Fatalf("SetupTLS: %v, %v", ok, err)
+	}
+	if log.VeNCryptVersion != "0.2" || len(log.VeNCryptSubtypes) != 3 || log.TLSSecurityType.Name != "x509_none" {
+		t.Errorf("Wrong VeNCrypt negotiation: %+v", log)
+	}
+	if want := "RFB 003.008\n\x13\x00\x02\x00\x00\x01\x04"; sent.String() != want {
+		t.Errorf("Client sent %q, expected %q", sent.String(), want)
+	}
+}
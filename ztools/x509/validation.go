@@ -4,7 +4,20 @@
 
 package x509
 
-import "time"
+import (
+	"bytes"
+	"time"
+)
+
+// Classes of validation failure recorded in Validation.ErrorType
+const (
+	ValidationErrorExpired          = "expired"
+	ValidationErrorHostnameMismatch = "hostname_mismatch"
+	ValidationErrorUnknownAuthority = "unknown_authority"
+	ValidationErrorSelfSigned       = "self_signed"
+	ValidationErrorInvalidChain     = "invalid_chain"
+	ValidationErrorOther            = "other"
+)
 
 // Validation stores different validation levels for a given certificate
 type Validation struct {
@@ -12,6 +25,14 @@ type Validation struct {
 	BrowserError   string `json:"browser_error,omitempty"`
 	MatchesDomain  bool   `json:"matches_domain,omitempty"`
 	Domain         string `json:"-"`
+
+	// Set when no domain was given, so the hostname was not checked
+	HostnameSkipped bool   `json:"hostname_skipped,omitempty"`
+	ErrorType       string `json:"error_type,omitempty"`
+
+	// The root that anchored the first verified chain
+	AnchorSubjectDN         string                 `json:"anchor_subject_dn,omitempty"`
+	AnchorFingerprintSHA256 CertificateFingerprint `json:"anchor_fingerprint_sha256,omitempty"`
 }
 
 // ValidateWithStupidDetail fills out a Validation struct given a leaf
@@ -28,23 +49,65 @@ func (c *Certificate) ValidateWithStupidDetail(opts VerifyOptions) (chains [][]*
 
 	out := new(Validation)
 	out.Domain = opts.DNSName
+	out.HostnameSkipped = len(opts.DNSName) == 0
 
-	if chains, err = c.Verify(opts); err != nil {
-		switch err := err.(type) {
-		case HostnameError:
-			out.BrowserTrusted = true
-			out.MatchesDomain = false
-		default:
-			out.BrowserTrusted = false
-			out.BrowserError = err.Error()
+	chains, err = c.Verify(opts)
+	if hostErr, ok := err.(HostnameError); ok {
+		// Verify stops at the hostname, so build the chains without it to
+		// learn whether they would be trusted
+		chainOpts := opts
+		chainOpts.DNSName = ""
+		if chains, err = c.Verify(chainOpts); err == nil {
+			err = hostErr
 		}
-	} else {
+	}
+
+	switch err.(type) {
+	case nil:
 		out.BrowserTrusted = true
-		if len(opts.DNSName) > 0 {
-			out.MatchesDomain = true
-		}
+		out.MatchesDomain = len(opts.DNSName) > 0
+	case HostnameError:
+		out.BrowserTrusted = true
+		out.MatchesDomain = false
+		out.ErrorType = ValidationErrorHostnameMismatch
+	default:
+		out.BrowserTrusted = false
+		out.BrowserError = err.Error()
+		out.ErrorType = c.classifyValidationError(err)
+	}
+	if len(chains) > 0 && len(chains[0]) > 0 {
+		anchor := chains[0][len(chains[0])-1]
+		out.AnchorSubjectDN = anchor.Subject.String()
+		out.AnchorFingerprintSHA256 = anchor.FingerprintSHA256
 	}
 	validation = out
 
 	return
 }
+
+// classifyValidationError maps an error from Verify onto one of the
+// ValidationError classes
+func (c *Certificate) classifyValidationError(err error) string {
+	switch e := err.(type) {
+	case CertificateInvalidError:
+		if e.Reason == Expired {
+			return ValidationErrorExpired
+		}
+		return ValidationErrorInvalidChain
+	case UnknownAuthorityError:
+		if c.isSelfSigned() {
+			return ValidationErrorSelfSigned
+		}
+		return ValidationErrorUnknownAuthority
+	}
+	return ValidationErrorOther
+}
+
+// isSelfSigned reports whether c is its own issuer and carries a valid
+// signature under its own key
+func (c *Certificate) isSelfSigned() bool {
+	if !bytes.Equal(c.RawSubject, c.RawIssuer) {
+		return false
+	}
+	return c.CheckSignature(c.SignatureAlgorithm, c.RawTBSCertificate, c.Signature) == nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+	"testing"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/x509/pkix"
+)
+
+func selfSignedForValidation(t *testing.T) *Certificate {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ECDSA key: %s", err)
+	}
+	template := &Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "www.example.com"},
+		NotBefore:             time.Unix(1000, 0),
+		NotAfter:              time.Unix(100000, 0),
+		DNSNames:              []string{"www.example.com"},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              KeyUsageCertSign | KeyUsageDigitalSignature,
+		ExtKeyUsage:           []ExtKeyUsage{ExtKeyUsageServerAuth},
+	}
+	der, err := CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+	cert, err := ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %s", err)
+	}
+	return cert
+}
+
+func TestValidationSelfSignedSkipsHostname(t *testing.T) {
+	cert := selfSignedForValidation(t)
+	_, v, err := cert.ValidateWithStupidDetail(VerifyOptions{
+		Roots:       NewCertPool(),
+		CurrentTime: time.Unix(2000, 0),
+	})
+	if err == nil || v.BrowserTrusted {
+		t.Fatalf("Self-signed certificate was trusted")
+	}
+	if v.ErrorType != ValidationErrorSelfSigned {
+		t.Errorf("Wrong error type - expected: %s, got: %s", ValidationErrorSelfSigned, v.ErrorType)
+	}
+	if !v.HostnameSkipped {
+		t.Errorf("Hostname not marked as skipped without a domain")
+	}
+}
+
+func TestValidationHostnameMismatchRecordsAnchor(t *testing.T) {
+	cert := selfSignedForValidation(t)
+	roots := NewCertPool()
+	roots.AddCert(cert)
+	_, v, err := cert.ValidateWithStupidDetail(VerifyOptions{
+		Roots:       roots,
+		CurrentTime: time.Unix(2000, 0),
+		DNSName:     "mail.example.com",
+	})
+	if _, ok := err.(HostnameError); !ok {
+		t.Fatalf("Expected a HostnameError, got: %v", err)
+	}
+	if !v.BrowserTrusted || v.MatchesDomain || v.HostnameSkipped {
+		t.Errorf("Wrong validation: %+v", *v)
+	}
+	if v.ErrorType != ValidationErrorHostnameMismatch {
+		t.Errorf("Wrong error type - expected: %s, got: %s", ValidationErrorHostnameMismatch, v.ErrorType)
+	}
+	if !bytes.Equal(v.AnchorFingerprintSHA256, cert.FingerprintSHA256) {
+		t.Errorf("Wrong anchor - expected: %x, got: %x", cert.FingerprintSHA256, v.AnchorFingerprintSHA256)
+	}
+}
+
+func TestValidationExpired(t *testing.T) {
+	cert := selfSignedForValidation(t)
+	roots := NewCertPool()
+	roots.AddCert(cert)
+	_, v, _ := cert.ValidateWithStupidDetail(VerifyOptions{
+		Roots:       roots,
+		CurrentTime: time.Unix(200000, 0),
+		DNSName:     "www.example.com",
+	})
+	if v.BrowserTrusted || v.ErrorType != ValidationErrorExpired {
+		t.Errorf("Wrong validation for expired certificate: %+v", *v)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package xmpp
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/util"
+)
+
+// The features element may be split across many reads, so keep reading
+// until it closes or the server ends the stream
+var featuresEndRegex = regexp.MustCompile(`</stream:features>|<stream:features\s*/>|</stream:stream>`)
+var starttlsFeatureRegex = regexp.MustCompile(`<starttls\s+xmlns=['"]urn:ietf:params:xml:ns:xmpp-tls['"]`)
+var starttlsRespRegex = regexp.MustCompile(`<proceed[^>]*>|<failure[^>]*>|</stream:stream>`)
+var proceedRegex = regexp.MustCompile(`<proceed[^>]*>`)
+
+// attributeEscaper escapes the domain for the single-quoted to attribute
+var attributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "'", "&apos;", `"`, "&quot;")
+
+// Stream features are read into a growing buffer capped at this size
+const xmppMaxResponseSize = 64 * 1024
+
+// GetXMPPFeatures opens a client stream to domain and records the raw stream
+// features sent by the server
+func GetXMPPFeatures(logStruct *XMPPLog, connection net.Conn, domain string) error {
+	header := fmt.Sprintf("<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", attributeEscaper.Replace(domain))
+	if _, err := connection.Write([]byte(header)); err != nil {
+		return err
+	}
+
+	features, err := util.ReadUntilRegexGrowing(connection, make([]byte, 1024), featuresEndRegex, xmppMaxResponseSize)
+	logStruct.StreamFeatures = string(features)
+	if err != nil {
+		return err
+	}
+
+	logStruct.StartTLS = starttlsFeatureRegex.Match(features)
+	return nil
+}
+
+// SetupStartTLS sends the starttls element and reports whether the server
+// answered with proceed
+func SetupStartTLS(logStruct *XMPPLog, connection net.Conn) (bool, error) {
+	if _, err := connection.Write([]byte("<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")); err != nil {
+		return false, err
+	}
+
+	resp, err := util.ReadUntilRegexGrowing(connection, make([]byte, 256), starttlsRespRegex, xmppMaxResponseSize)
+	logStruct.StartTLSResp = string(resp)
+	if err != nil {
+		return false, err
+	}
+
+	return proceedRegex.Match(resp), nil
+}
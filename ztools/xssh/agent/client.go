@@ -41,9 +41,18 @@ type Agent interface {
 	// Add adds a private key to the agent.
 	Add(key AddedKey) error
 
+	// AddWithConstraints adds a private key to the agent with the
+	// lifetime and confirm constraints of [PROTOCOL.agent] section 3.7,
+	// overriding any set in key. A zero lifetimeSecs means no lifetime.
+	AddWithConstraints(key AddedKey, lifetimeSecs uint32, confirmBeforeUse bool) error
+
 	// Remove removes all identities with the given public key.
 	Remove(key xssh.PublicKey) error
 
+	// RemoveByFingerprint removes all identities whose public key has the
+	// given fingerprint, in either the SHA256 or the legacy MD5 format.
+	RemoveByFingerprint(fingerprint string) error
+
 	// RemoveAll removes all identities.
 	RemoveAll() error
 
@@ -296,6 +305,32 @@ func (c *client) Remove(key xssh.PublicKey) error {
 	return c.simpleCall(req)
 }
 
+func (c *client) RemoveByFingerprint(fingerprint string) error {
+	keys, err := c.List()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, k := range keys {
+		if matchesFingerprint(k, fingerprint) {
+			if err := c.Remove(k); err != nil {
+				return err
+			}
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("agent: key not found")
+	}
+	return nil
+}
+
+// matchesFingerprint reports whether fingerprint is the SHA256 or legacy
+// MD5 fingerprint of key.
+func matchesFingerprint(key xssh.PublicKey, fingerprint string) bool {
+	return fingerprint == xssh.FingerprintSHA256(key) || fingerprint == xssh.FingerprintLegacyMD5(key)
+}
+
 func (c *client) Lock(passphrase []byte) error {
 	req := xssh.Marshal(&agentLockMsg{
 		Passphrase: passphrase,
@@ -543,25 +578,39 @@ type ed25519CertMsg struct {
 // Add adds a private key to the agent. If a certificate is given,
 // that certificate is added instead as public key.
 func (c *client) Add(key AddedKey) error {
+	constraints := marshalConstraints(key.LifetimeSecs, key.ConfirmBeforeUse)
+
+	if cert := key.Certificate; cert == nil {
+		return c.insertKey(key.PrivateKey, key.Comment, constraints)
+	} else {
+		return c.insertCert(key.PrivateKey, cert, key.Comment, constraints)
+	}
+}
+
+func (c *client) AddWithConstraints(key AddedKey, lifetimeSecs uint32, confirmBeforeUse bool) error {
+	key.LifetimeSecs = lifetimeSecs
+	key.ConfirmBeforeUse = confirmBeforeUse
+	return c.Add(key)
+}
+
+// marshalConstraints encodes the constraints sent after the key in an
+// SSH_AGENTC_ADD_ID_CONSTRAINED request.
+func marshalConstraints(lifetimeSecs uint32, confirmBeforeUse bool) []byte {
 	var constraints []byte
 
-	if secs := key.LifetimeSecs; secs != 0 {
+	if lifetimeSecs != 0 {
 		constraints = append(constraints, agentConstrainLifetime)
 
 		var secsBytes [4]byte
-		binary.BigEndian.PutUint32(secsBytes[:], secs)
+		binary.BigEndian.PutUint32(secsBytes[:], lifetimeSecs)
 		constraints = append(constraints, secsBytes[:]...)
 	}
 
-	if key.ConfirmBeforeUse {
+	if confirmBeforeUse {
 		constraints = append(constraints, agentConstrainConfirm)
 	}
 
-	if cert := key.Certificate; cert == nil {
-		return c.insertKey(key.PrivateKey, key.Comment, constraints)
-	} else {
-		return c.insertCert(key.PrivateKey, cert, key.Comment, constraints)
-	}
+	return constraints
 }
 
 func (c *client) insertCert(s interface{}, cert *xssh.Certificate, comment string, constraints []byte) error {
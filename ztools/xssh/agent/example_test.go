@@ -5,6 +5,8 @@
 package agent_test
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"log"
 	"net"
 	"os"
@@ -38,3 +40,26 @@ func ExampleClientAgent() {
 	// .. use sshc
 	sshc.Close()
 }
+
+func ExampleNewClient_lifetime() {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		log.Fatalf("net.Dial: %v", err)
+	}
+	agentClient := agent.NewClient(conn)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	// The agent forgets the key after 60 seconds. Pass true instead of
+	// false to have the agent ask the user before each use as well.
+	err = agentClient.AddWithConstraints(agent.AddedKey{
+		PrivateKey: key,
+		Comment:    "temporary key",
+	}, 60, false)
+	if err != nil {
+		log.Fatalf("AddWithConstraints: %v", err)
+	}
+}
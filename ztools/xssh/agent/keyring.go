@@ -20,6 +20,7 @@ type privKey struct {
 	signer  xssh.Signer
 	comment string
 	expire  *time.Time
+	confirm bool
 }
 
 type keyring struct {
@@ -32,6 +33,10 @@ type keyring struct {
 
 var errLocked = errors.New("agent: locked")
 
+// errConfirm is returned when signing with a key that was added with the
+// confirm constraint: the keyring has no way of asking the user.
+var errConfirm = errors.New("agent: key requires confirmation")
+
 // NewKeyring returns an Agent that holds keys in memory.  It is safe
 // for concurrent use by multiple goroutines.
 func NewKeyring() Agent {
@@ -82,6 +87,23 @@ func (r *keyring) Remove(key xssh.PublicKey) error {
 	return r.removeLocked(key.Marshal())
 }
 
+// RemoveByFingerprint removes all identities whose public key has the
+// given SHA256 or legacy MD5 fingerprint.
+func (r *keyring) RemoveByFingerprint(fingerprint string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.locked {
+		return errLocked
+	}
+
+	for _, k := range r.keys {
+		if pub := k.signer.PublicKey(); matchesFingerprint(pub, fingerprint) {
+			return r.removeLocked(pub.Marshal())
+		}
+	}
+	return errors.New("agent: key not found")
+}
+
 // Lock locks the agent. Sign and Remove will fail, and List will return an empty list.
 func (r *keyring) Lock(passphrase []byte) error {
 	r.mu.Lock()
@@ -144,8 +166,8 @@ func (r *keyring) List() ([]*Key, error) {
 }
 
 // Insert adds a private key to the keyring. If a certificate
-// is given, that certificate is added as public key. Note that
-// any constraints given are ignored.
+// is given, that certificate is added as public key. A key added
+// with ConfirmBeforeUse cannot be used by Sign.
 func (r *keyring) Add(key AddedKey) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -168,6 +190,7 @@ func (r *keyring) Add(key AddedKey) error {
 	p := privKey{
 		signer:  signer,
 		comment: key.Comment,
+		confirm: key.ConfirmBeforeUse,
 	}
 
 	if key.LifetimeSecs > 0 {
@@ -180,6 +203,14 @@ func (r *keyring) Add(key AddedKey) error {
 	return nil
 }
 
+// AddWithConstraints adds a private key to the keyring with the given
+// lifetime and confirm constraints.
+func (r *keyring) AddWithConstraints(key AddedKey, lifetimeSecs uint32, confirmBeforeUse bool) error {
+	key.LifetimeSecs = lifetimeSecs
+	key.ConfirmBeforeUse = confirmBeforeUse
+	return r.Add(key)
+}
+
 // Sign returns a signature for the data.
 func (r *keyring) Sign(key xssh.PublicKey, data []byte) (*xssh.Signature, error) {
 	r.mu.Lock()
@@ -192,6 +223,9 @@ func (r *keyring) Sign(key xssh.PublicKey, data []byte) (*xssh.Signature, error)
 	wanted := key.Marshal()
 	for _, k := range r.keys {
 		if bytes.Equal(k.signer.PublicKey().Marshal(), wanted) {
+			if k.confirm {
+				return nil, errConfirm
+			}
 			return k.signer.Sign(rand.Reader, data)
 		}
 	}
@@ -5,10 +5,12 @@
 package agent
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/rand"
 	"fmt"
 	"testing"
+	"time"
 
 	"gopkg.in/eniac/zgrab.v0/ztools/xssh"
 )
@@ -205,3 +207,95 @@ func TestCertTypes(t *testing.T) {
 		}
 	}
 }
+
+func TestServerConstraints(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+	client := NewClient(c1)
+	keyring := NewKeyring()
+
+	go ServeAgent(keyring, c2)
+
+	for _, keyType := range []string{"rsa", "dsa", "ecdsa"} {
+		if err := client.AddWithConstraints(AddedKey{PrivateKey: testPrivateKeys[keyType], Comment: keyType}, 1, false); err != nil {
+			t.Fatalf("AddWithConstraints(%s): %v", keyType, err)
+		}
+	}
+	if err := client.AddWithConstraints(AddedKey{PrivateKey: testPrivateKeys["user"]}, 0, true); err != nil {
+		t.Fatalf("AddWithConstraints(user): %v", err)
+	}
+
+	if keys, err := client.List(); err != nil {
+		t.Fatalf("List: %v", err)
+	} else if len(keys) != 4 {
+		t.Fatalf("Want 4 keys, got %d", len(keys))
+	}
+	if _, err := client.Sign(testPublicKeys["user"], []byte("data")); err == nil {
+		t.Errorf("Sign with a confirm-constrained key succeeded")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	keys, err := client.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(keys) != 1 || !bytes.Equal(keys[0].Blob, testPublicKeys["user"].Marshal()) {
+		t.Errorf("Want only the key without lifetime, got %v", keys)
+	}
+}
+
+func TestSetConstraints(t *testing.T) {
+	var key AddedKey
+	if err := setConstraints(&key, marshalConstraints(60, true)); err != nil {
+		t.Fatalf("setConstraints: %v", err)
+	}
+	if key.LifetimeSecs != 60 || !key.ConfirmBeforeUse {
+		t.Errorf("Got lifetime %d, confirm %t; want 60, true", key.LifetimeSecs, key.ConfirmBeforeUse)
+	}
+
+	for _, constraints := range [][]byte{{agentConstrainLifetime, 0, 0}, {255}} {
+		if err := setConstraints(&AddedKey{}, constraints); err == nil {
+			t.Errorf("setConstraints(%x) accepted invalid constraints", constraints)
+		}
+	}
+}
+
+func TestRemoveByFingerprint(t *testing.T) {
+	c1, c2, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %v", err)
+	}
+	defer c1.Close()
+	defer c2.Close()
+	client := NewClient(c1)
+
+	go ServeAgent(NewKeyring(), c2)
+
+	for _, keyType := range []string{"rsa", "ecdsa", "user"} {
+		if err := client.Add(AddedKey{PrivateKey: testPrivateKeys[keyType]}); err != nil {
+			t.Fatalf("Add(%s): %v", keyType, err)
+		}
+	}
+
+	if err := client.RemoveByFingerprint(xssh.FingerprintSHA256(testPublicKeys["rsa"])); err != nil {
+		t.Errorf("RemoveByFingerprint(SHA256): %v", err)
+	}
+	if err := client.RemoveByFingerprint(xssh.FingerprintLegacyMD5(testPublicKeys["ecdsa"])); err != nil {
+		t.Errorf("RemoveByFingerprint(MD5): %v", err)
+	}
+	if err := client.RemoveByFingerprint(xssh.FingerprintSHA256(testPublicKeys["rsa"])); err == nil {
+		t.Errorf("RemoveByFingerprint of a removed key succeeded")
+	}
+
+	keys, err := client.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(keys) != 1 || !bytes.Equal(keys[0].Blob, testPublicKeys["user"].Marshal()) {
+		t.Errorf("Want only the user key, got %v", keys)
+	}
+}
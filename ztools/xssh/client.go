@@ -8,7 +8,6 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"strings"
 	"sync"
 	"time"
 )
@@ -69,13 +68,18 @@ func NewClient(c Conn, chans <-chan NewChannel, reqs <-chan *Request) *Client {
 func NewClientConn(c net.Conn, addr string, config *ClientConfig) (Conn, <-chan NewChannel, <-chan *Request, error) {
 	fullConf := *config
 	fullConf.SetDefaults()
+	fullConf.handshakeLog = fullConf.ConnLog
+	if fullConf.handshakeLog == nil {
+		fullConf.handshakeLog = new(HandshakeLog)
+	}
 	conn := &connection{
-		sshConn: sshConn{conn: c},
+		sshConn:      sshConn{conn: c},
+		handshakeLog: fullConf.handshakeLog,
 	}
 
 	if err := conn.clientHandshake(addr, &fullConf); err != nil {
 		c.Close()
-		return nil, nil, nil, fmt.Errorf("ssh: handshake failed: %v", err)
+		return nil, nil, nil, &HandshakeError{Err: err, Log: fullConf.handshakeLog}
 	}
 	conn.mux = newMux(conn.transport)
 	return conn, conn.mux.incomingChannels, conn.mux.incomingRequests, nil
@@ -95,53 +99,10 @@ func (c *connection) clientHandshake(dialAddress string, config *ClientConfig) e
 		return err
 	}
 
-	if config.ConnLog != nil {
-		config.ConnLog.ServerID = new(EndpointId)
-		config.ConnLog.ServerID.Raw = string(c.serverVersion)
-
-		serverSplitId := strings.SplitN(string(c.serverVersion), " ", 2)
-		if len(serverSplitId) == 2 {
-			config.ConnLog.ServerID.Comment = serverSplitId[1]
-		}
-
-		serverSplitGroup := strings.SplitN(serverSplitId[0], "-", 3)
-		if serverSplitGroup[0] == "SSH" {
-			// If ID doesn't start with "SSH", don't attempt to parse.
-			if len(serverSplitGroup) > 1 {
-				config.ConnLog.ServerID.ProtoVersion = serverSplitGroup[1]
-			}
-
-			if len(serverSplitGroup) == 3 {
-				config.ConnLog.ServerID.SoftwareVersion = serverSplitGroup[2]
-			}
-		}
-	}
-	if pkgConfig.Verbose {
-		if config.ConnLog != nil {
-			//config.ConnLog.ClientIDString = string(c.clientVersion)
-		}
-
-		if config.ConnLog != nil {
-			config.ConnLog.ClientID = new(EndpointId)
-			config.ConnLog.ClientID.Raw = string(c.clientVersion)
-
-			clientSplitId := strings.SplitN(string(c.clientVersion), " ", 2)
-			if len(clientSplitId) == 2 {
-				config.ConnLog.ClientID.Comment = clientSplitId[1]
-			}
-
-			clientSplitGroup := strings.SplitN(clientSplitId[0], "-", 3)
-			if clientSplitGroup[0] == "SSH" {
-				// If ID doesn't start with "SSH", don't attempt to parse.
-				if len(clientSplitGroup) > 1 {
-					config.ConnLog.ClientID.ProtoVersion = clientSplitGroup[1]
-				}
-
-				if len(clientSplitGroup) == 3 {
-					config.ConnLog.ClientID.SoftwareVersion = clientSplitGroup[2]
-				}
-			}
-		}
+	if log := config.log(); log != nil {
+		log.ServerID = parseEndpointId(c.serverVersion)
+		log.ClientID = parseEndpointId(c.clientVersion)
+		log.Weaknesses = classifyWeaknesses(log)
 	}
 
 	c.transport = newClientTransport(
@@ -269,4 +230,9 @@ type ClientConfig struct {
 	// If true, send the "none" Authentication Request to collect the advertised
 	// userauth method names, but do not attempt to authenticate.
 	DontAuthenticate bool
+
+	// If true, send a single "none" Authentication Request for User, even
+	// when scanning, and record the server's answer in ConnLog.UserAuthProbe.
+	// No other method is ever tried.
+	ProbeNoneAuth bool
 }
@@ -13,7 +13,7 @@ import (
 
 // clientAuthenticate authenticates with the remote server. See RFC 4252.
 func (c *connection) clientAuthenticate(config *ClientConfig) error {
-	if c.transport.config.ConnLog != nil && !pkgConfig.CollectUserAuth {
+	if c.transport.config.ConnLog != nil && !pkgConfig.CollectUserAuth && !config.ProbeNoneAuth {
 		// Use ConnLog existence to indicate that this is a run and not testing
 		return nil
 	}
@@ -40,13 +40,23 @@ func (c *connection) clientAuthenticate(config *ClientConfig) error {
 		if err != nil {
 			return err
 		}
+		if config.ProbeNoneAuth {
+			if log := c.transport.config.log(); log != nil {
+				log.UserAuthProbe = &UserAuthProbe{
+					User:     config.User,
+					Accepted: ok,
+					Methods:  methods,
+				}
+			}
+			return nil
+		}
 		if ok {
 			// success
 			return nil
 		}
 
-		if c.transport.config.ConnLog != nil {
-			c.transport.config.ConnLog.UserAuth = methods
+		if log := c.transport.config.log(); log != nil {
+			log.UserAuth = methods
 		}
 		if config.DontAuthenticate {
 			return nil
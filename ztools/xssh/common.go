@@ -225,6 +225,18 @@ type Config struct {
 
 	// A pointer to the handshake log IOT allow incremental building
 	ConnLog *HandshakeLog
+
+	// handshakeLog is what a client records its handshake in: ConnLog, or a
+	// log of its own when ConnLog is nil
+	handshakeLog *HandshakeLog
+}
+
+// log returns the log the handshake is recorded in, nil for none
+func (c *Config) log() *HandshakeLog {
+	if c.handshakeLog != nil {
+		return c.handshakeLog
+	}
+	return c.ConnLog
 }
 
 // SetDefaults sets sensible values for unset fields in config. This is
@@ -69,6 +69,10 @@ type Conn interface {
 	// error causing the shutdown.
 	Wait() error
 
+	// HandshakeLog returns what was recorded of the client handshake, or
+	// nil for server connections.
+	HandshakeLog() *HandshakeLog
+
 	// TODO(hanwen): consider exposing:
 	//   RequestKeyChange
 	//   Disconnect
@@ -91,6 +95,12 @@ type connection struct {
 
 	// The connection protocol.
 	*mux
+
+	handshakeLog *HandshakeLog
+}
+
+func (c *connection) HandshakeLog() *HandshakeLog {
+	return c.handshakeLog
 }
 
 func (c *connection) Close() error {
@@ -343,18 +343,18 @@ func (t *handshakeTransport) enterKeyExchangeLocked(otherInitPacket []byte) erro
 		return err
 	}
 
-	if pkgConfig.Verbose {
-		if t.config.ConnLog != nil {
-			t.config.ConnLog.ClientKex = myInit
-		}
+	if log := t.config.log(); log != nil {
+		log.ClientKex = myInit
 	}
 
 	otherInit := &kexInitMsg{}
 	if err := Unmarshal(otherInitPacket, otherInit); err != nil {
 		return err
 	}
-	if t.config.ConnLog != nil {
-		t.config.ConnLog.ServerKex = otherInit
+	if log := t.config.log(); log != nil {
+		log.ServerKex = otherInit
+		log.HASSHServer = hasshServer(otherInit)
+		log.Weaknesses = classifyWeaknesses(log)
 	}
 
 	magics := handshakeMagics{
@@ -378,8 +378,8 @@ func (t *handshakeTransport) enterKeyExchangeLocked(otherInitPacket []byte) erro
 	if err != nil {
 		return err
 	}
-	if t.config.ConnLog != nil {
-		t.config.ConnLog.AlgorithmSelection = algs
+	if log := t.config.log(); log != nil {
+		log.AlgorithmSelection = algs
 	}
 
 	// We don't send FirstKexFollows, but we handle receiving it.
@@ -407,8 +407,8 @@ func (t *handshakeTransport) enterKeyExchangeLocked(otherInitPacket []byte) erro
 
 	kex = kex.GetNew(algs.kex)
 
-	if t.config.ConnLog != nil {
-		t.config.ConnLog.DHKeyExchange = kex
+	if log := t.config.log(); log != nil {
+		log.DHKeyExchange = kex
 	}
 
 	var result *kexResult
@@ -418,8 +418,8 @@ func (t *handshakeTransport) enterKeyExchangeLocked(otherInitPacket []byte) erro
 		result, err = t.client(kex, algs, &magics)
 	}
 	if pkgConfig.Verbose {
-		if t.config.ConnLog != nil {
-			t.config.ConnLog.Crypto = result
+		if log := t.config.log(); log != nil {
+			log.Crypto = result
 		}
 	}
 	if err != nil {
@@ -0,0 +1,45 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package xssh
+
+import (
+	"strings"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/fingerprint"
+)
+
+// HASSHServer returns the HASSH-server fingerprint string of a server's
+// KEXINIT, as logged in server_key_exchange: the key exchange algorithms and
+// the server to client ciphers, MACs and compression methods, each list
+// comma-separated and the lists separated by semicolons. See
+// https://github.com/salesforce/hassh.
+func HASSHServer(kex *JsonKexInitMsg) string {
+	return strings.Join([]string{
+		strings.Join(kex.KexAlgos, ","),
+		strings.Join(kex.CiphersServerClient, ","),
+		strings.Join(kex.MACsServerClient, ","),
+		strings.Join(kex.CompressionServerClient, ","),
+	}, ";")
+}
+
+// hasshServer fingerprints a KEXINIT received from the server
+func hasshServer(kex *kexInitMsg) *fingerprint.Fingerprint {
+	return fingerprint.New(HASSHServer(&JsonKexInitMsg{
+		KexAlgos:                kex.KexAlgos,
+		CiphersServerClient:     kex.CiphersServerClient,
+		MACsServerClient:        kex.MACsServerClient,
+		CompressionServerClient: kex.CompressionServerClient,
+	}))
+}
@@ -0,0 +1,54 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package xssh
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHASSHServer(t *testing.T) {
+	kex := &kexInitMsg{
+		KexAlgos:                []string{"curve25519-sha256", kexAlgoDH14SHA1},
+		ServerHostKeyAlgos:      []string{KeyAlgoRSA},
+		CiphersClientServer:     []string{"aes128-ctr"},
+		CiphersServerClient:     []string{"aes128-ctr", "aes256-gcm@openssh.com"},
+		MACsClientServer:        []string{"hmac-sha1"},
+		MACsServerClient:        []string{"hmac-sha2-256", "hmac-sha1"},
+		CompressionClientServer: []string{"none"},
+		CompressionServerClient: []string{"none", "zlib@openssh.com"},
+	}
+	fp := hasshServer(kex)
+	expected := "curve25519-sha256,diffie-hellman-group14-sha1;aes128-ctr,aes256-gcm@openssh.com;hmac-sha2-256,hmac-sha1;none,zlib@openssh.com"
+	if fp.Raw != expected {
+		t.Errorf("HASSH-server = %s, expected %s", fp.Raw, expected)
+	}
+	if fp.MD5 != "faba91051cca24bd503c0bab0f525585" {
+		t.Errorf("HASSH-server digest = %s", fp.MD5)
+	}
+
+	// A logged server_key_exchange fingerprints the same
+	logged, err := json.Marshal(kex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded JsonKexInitMsg
+	if err := json.Unmarshal(logged, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if raw := HASSHServer(&decoded); raw != expected {
+		t.Errorf("HASSH-server of the logged KEXINIT = %s, expected %s", raw, expected)
+	}
+}
@@ -0,0 +1,50 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package xssh
+
+import (
+	"net"
+	"sync"
+)
+
+// An ObservedHostKey is a host key seen by a RecordingHostKeyCallback,
+// with its fingerprints in the formats printed by ssh-keygen -l.
+type ObservedHostKey struct {
+	Host              string `json:"host"`
+	Type              string `json:"type"`
+	Blob              []byte `json:"blob"`
+	FingerprintSHA256 string `json:"fingerprint_sha256"`
+	FingerprintMD5    string `json:"fingerprint_md5"`
+}
+
+// RecordingHostKeyCallback returns a ClientConfig.HostKeyCallback that
+// accepts every host key and appends it to keys. mu guards keys, so the
+// callback may be shared by concurrent handshakes; hold mu when reading
+// keys while handshakes may still be running.
+func RecordingHostKeyCallback(mu *sync.Mutex, keys *[]ObservedHostKey) func(hostname string, remote net.Addr, key PublicKey) error {
+	return func(hostname string, remote net.Addr, key PublicKey) error {
+		observed := ObservedHostKey{
+			Host:              hostname,
+			Type:              key.Type(),
+			Blob:              key.Marshal(),
+			FingerprintSHA256: FingerprintSHA256(key),
+			FingerprintMD5:    FingerprintLegacyMD5(key),
+		}
+		mu.Lock()
+		*keys = append(*keys, observed)
+		mu.Unlock()
+		return nil
+	}
+}
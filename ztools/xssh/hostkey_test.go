@@ -0,0 +1,66 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package xssh
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestRecordingHostKeyCallback(t *testing.T) {
+	ed25519Key, err := NewPublicKey(ed25519.PublicKey(bytes.Repeat([]byte{1}, ed25519.PublicKeySize)))
+	if err != nil {
+		t.Fatalf("NewPublicKey: %v", err)
+	}
+	hostKeys := []PublicKey{testPublicKeys["ecdsa"], ed25519Key}
+
+	var mu sync.Mutex
+	var keys []ObservedHostKey
+	callback := RecordingHostKeyCallback(&mu, &keys)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := hostKeys[i%len(hostKeys)]
+			if err := callback(fmt.Sprintf("host%d:22", i), nil, key); err != nil {
+				t.Errorf("callback: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(keys) != 10 {
+		t.Fatalf("got %d observed keys, want 10", len(keys))
+	}
+	for _, observed := range keys {
+		var i int
+		if _, err := fmt.Sscanf(observed.Host, "host%d:22", &i); err != nil {
+			t.Fatalf("unexpected host %q", observed.Host)
+		}
+		key := hostKeys[i%len(hostKeys)]
+		if observed.Type != key.Type() || !bytes.Equal(observed.Blob, key.Marshal()) {
+			t.Errorf("%s: got %s key %x, want %s key %x", observed.Host, observed.Type, observed.Blob, key.Type(), key.Marshal())
+		}
+		if observed.FingerprintSHA256 != FingerprintSHA256(key) || observed.FingerprintMD5 != FingerprintLegacyMD5(key) {
+			t.Errorf("%s: wrong fingerprints %q, %q", observed.Host, observed.FingerprintSHA256, observed.FingerprintMD5)
+		}
+	}
+}
@@ -14,17 +14,37 @@
 
 package xssh
 
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/fingerprint"
+)
+
 // HandshakeLog contains detailed information about each step of the
-// SSH handshake, and can be encoded to JSON.
+// SSH handshake, and can be encoded to JSON. Steps are recorded as they
+// complete, so the log of a failed handshake holds everything up to the
+// failure.
 type HandshakeLog struct {
-	ServerID           *EndpointId  `json:"server_id,omitempty"`
-	ClientID           *EndpointId  `json:"client_id,omitempty"`
-	ServerKex          *kexInitMsg  `json:"server_key_exchange,omitempty"`
-	ClientKex          *kexInitMsg  `json:"client_key_exchange,omitempty"`
-	AlgorithmSelection *algorithms  `json:"algorithm_selection,omitempty"`
-	DHKeyExchange      kexAlgorithm `json:"dh_key_exchange,omitempty"`
-	UserAuth           []string     `json:"userauth,omitempty"`
-	Crypto             *kexResult   `json:"crypto,omitempty"`
+	ServerID           *EndpointId              `json:"server_id,omitempty"`
+	ClientID           *EndpointId              `json:"client_id,omitempty"`
+	ServerKex          *kexInitMsg              `json:"server_key_exchange,omitempty"`
+	HASSHServer        *fingerprint.Fingerprint `json:"hassh_server,omitempty"`
+	ClientKex          *kexInitMsg              `json:"client_key_exchange,omitempty"`
+	AlgorithmSelection *algorithms              `json:"algorithm_selection,omitempty"`
+	DHKeyExchange      kexAlgorithm             `json:"dh_key_exchange,omitempty"`
+	UserAuth           []string                 `json:"userauth,omitempty"`
+	Crypto             *kexResult               `json:"crypto,omitempty"`
+	Weaknesses         *Weaknesses              `json:"weaknesses,omitempty"`
+	UserAuthProbe      *UserAuthProbe           `json:"userauth_probe,omitempty"`
+}
+
+// UserAuthProbe records the server's answer to a "none" userauth request:
+// either access was granted outright, or the methods that can continue
+type UserAuthProbe struct {
+	User     string   `json:"user"`
+	Accepted bool     `json:"accepted"`
+	Methods  []string `json:"methods,omitempty"`
 }
 
 type EndpointId struct {
@@ -33,3 +53,35 @@ type EndpointId struct {
 	SoftwareVersion string `json:"software,omitempty"`
 	Comment         string `json:"comment,omitempty"`
 }
+
+// parseEndpointId splits an identification string such as
+// "SSH-2.0-OpenSSH_7.4 Debian-10" into its parts. Strings not starting with
+// "SSH" are kept raw only.
+func parseEndpointId(raw []byte) *EndpointId {
+	id := &EndpointId{Raw: string(raw)}
+	splitId := strings.SplitN(id.Raw, " ", 2)
+	if len(splitId) == 2 {
+		id.Comment = splitId[1]
+	}
+	splitGroup := strings.SplitN(splitId[0], "-", 3)
+	if splitGroup[0] == "SSH" {
+		if len(splitGroup) > 1 {
+			id.ProtoVersion = splitGroup[1]
+		}
+		if len(splitGroup) == 3 {
+			id.SoftwareVersion = splitGroup[2]
+		}
+	}
+	return id
+}
+
+// A HandshakeError is returned by NewClientConn when the handshake fails,
+// with the log of the handshake up to the failure
+type HandshakeError struct {
+	Err error
+	Log *HandshakeLog
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("ssh: handshake failed: %v", e.Err)
+}
@@ -0,0 +1,87 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package xssh
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestHandshakeLogKeptOnFailure(t *testing.T) {
+	client, server, err := netPipe()
+	if err != nil {
+		t.Fatalf("netPipe: %s", err.Error())
+	}
+	defer client.Close()
+
+	// The server sends its KEXINIT and hangs up before the key exchange
+	go func() {
+		defer server.Close()
+		payload := Marshal(&kexInitMsg{
+			KexAlgos:                []string{kexAlgoDH1SHA1},
+			ServerHostKeyAlgos:      []string{KeyAlgoRSA},
+			CiphersClientServer:     []string{"aes128-ctr"},
+			CiphersServerClient:     []string{"aes128-ctr"},
+			MACsClientServer:        []string{"hmac-sha1"},
+			MACsServerClient:        []string{"hmac-sha1"},
+			CompressionClientServer: []string{"none"},
+			CompressionServerClient: []string{"none"},
+		})
+		padding := 8 - (5+len(payload))%8
+		if padding < 4 {
+			padding += 8
+		}
+		packet := make([]byte, 5, 5+len(payload)+padding)
+		binary.BigEndian.PutUint32(packet, uint32(1+len(payload)+padding))
+		packet[4] = byte(padding)
+		packet = append(append(packet, payload...), make([]byte, padding)...)
+		server.Write([]byte("SSH-2.0-Fake_1.0 test\r\n"))
+		server.Write(packet)
+
+		// Drain what the client sends so closing doesn't reset the
+		// connection before our KEXINIT has been read
+		buf := make([]byte, 4096)
+		server.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _, _, err = NewClientConn(client, "", &ClientConfig{ClientVersion: "SSH-2.0-Scanner"})
+	handshakeErr, ok := err.(*HandshakeError)
+	if !ok {
+		t.Fatalf("Expected a *HandshakeError, got: %v", err)
+	}
+	log := handshakeErr.Log
+	if log.ServerID == nil || log.ServerID.SoftwareVersion != "Fake_1.0" || log.ServerID.Comment != "test" {
+		t.Errorf("Wrong server identification: %+v", log.ServerID)
+	}
+	if log.ClientID == nil || log.ClientID.Raw != "SSH-2.0-Scanner" || log.ClientID.ProtoVersion != "2.0" {
+		t.Errorf("Wrong client identification: %+v", log.ClientID)
+	}
+	if log.ClientKex == nil || log.ServerKex == nil || log.ServerKex.KexAlgos[0] != kexAlgoDH1SHA1 {
+		t.Errorf("KEXINIT messages not recorded: client %+v, server %+v", log.ClientKex, log.ServerKex)
+	}
+	if log.Weaknesses == nil || !log.Weaknesses.WeakKex {
+		t.Errorf("Weak key exchange not flagged: %+v", log.Weaknesses)
+	}
+	if log.HASSHServer == nil || log.HASSHServer.Raw != kexAlgoDH1SHA1+";aes128-ctr;hmac-sha1;none" {
+		t.Errorf("Wrong HASSH-server fingerprint: %+v", log.HASSHServer)
+	}
+}
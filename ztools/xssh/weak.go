@@ -0,0 +1,106 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package xssh
+
+import (
+	"strings"
+)
+
+// Kinds of algorithm in a KEXINIT, as they appear in WeakAlgorithm.Kind
+const (
+	AlgorithmKindKex     = "kex"
+	AlgorithmKindHostKey = "host_key"
+	AlgorithmKindCipher  = "cipher"
+	AlgorithmKindMAC     = "mac"
+)
+
+// A WeakAlgorithm is a known-weak algorithm offered by the server
+type WeakAlgorithm struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// weakAlgorithms lists the offerings flagged by classifyWeaknesses. Add
+// entries here, along with a case in weak_test.go.
+var weakAlgorithms = []WeakAlgorithm{
+	{AlgorithmKindKex, kexAlgoDH1SHA1, "1024-bit Oakley Group 2"},
+	{AlgorithmKindHostKey, KeyAlgoDSA, "1024-bit DSA"},
+	{AlgorithmKindHostKey, CertAlgoDSAv01, "1024-bit DSA"},
+	{AlgorithmKindCipher, "arcfour", "RC4"},
+	{AlgorithmKindCipher, "arcfour128", "RC4"},
+	{AlgorithmKindCipher, "arcfour256", "RC4"},
+	{AlgorithmKindMAC, "hmac-md5", "MD5"},
+	{AlgorithmKindMAC, "hmac-md5-96", "MD5"},
+	{AlgorithmKindMAC, "hmac-md5-etm@openssh.com", "MD5"},
+	{AlgorithmKindMAC, "hmac-md5-96-etm@openssh.com", "MD5"},
+}
+
+// Weaknesses classifies what the server offered in its identification
+// string and KEXINIT
+type Weaknesses struct {
+	WeakAlgorithms []WeakAlgorithm `json:"weak_algorithms,omitempty"`
+	WeakKex        bool            `json:"weak_kex"`
+	WeakHostKey    bool            `json:"weak_host_key"`
+	WeakCipher     bool            `json:"weak_cipher"`
+	WeakMAC        bool            `json:"weak_mac"`
+
+	// A protocol version of 1.99 or 1.x announces SSH-1 support
+	SSHv1 bool `json:"ssh_v1"`
+}
+
+// classifyWeaknesses flags the known-weak offerings recorded in log so far
+func classifyWeaknesses(log *HandshakeLog) *Weaknesses {
+	w := new(Weaknesses)
+	if log.ServerID != nil {
+		w.SSHv1 = strings.HasPrefix(log.ServerID.ProtoVersion, "1.")
+	}
+	if kex := log.ServerKex; kex != nil {
+		offered := map[string][][]string{
+			AlgorithmKindKex:     {kex.KexAlgos},
+			AlgorithmKindHostKey: {kex.ServerHostKeyAlgos},
+			AlgorithmKindCipher:  {kex.CiphersClientServer, kex.CiphersServerClient},
+			AlgorithmKindMAC:     {kex.MACsClientServer, kex.MACsServerClient},
+		}
+		for _, weak := range weakAlgorithms {
+			if !offeredIn(weak.Name, offered[weak.Kind]) {
+				continue
+			}
+			w.WeakAlgorithms = append(w.WeakAlgorithms, weak)
+			switch weak.Kind {
+			case AlgorithmKindKex:
+				w.WeakKex = true
+			case AlgorithmKindHostKey:
+				w.WeakHostKey = true
+			case AlgorithmKindCipher:
+				w.WeakCipher = true
+			case AlgorithmKindMAC:
+				w.WeakMAC = true
+			}
+		}
+	}
+	return w
+}
+
+func offeredIn(name string, lists [][]string) bool {
+	for _, list := range lists {
+		for _, offered := range list {
+			if offered == name {
+				return true
+			}
+		}
+	}
+	return false
+}
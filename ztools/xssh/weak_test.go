@@ -0,0 +1,57 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package xssh
+
+import (
+	"testing"
+)
+
+func TestClassifyWeaknesses(t *testing.T) {
+	log := &HandshakeLog{
+		ServerID: &EndpointId{ProtoVersion: "1.99", SoftwareVersion: "OpenSSH_3.9p1"},
+		ServerKex: &kexInitMsg{
+			KexAlgos:            []string{kexAlgoDH14SHA1, kexAlgoDH1SHA1},
+			ServerHostKeyAlgos:  []string{KeyAlgoRSA, KeyAlgoDSA},
+			CiphersClientServer: []string{"aes128-ctr", "arcfour"},
+			CiphersServerClient: []string{"aes128-ctr", "arcfour256"},
+			MACsClientServer:    []string{"hmac-sha1"},
+			MACsServerClient:    []string{"hmac-sha1"},
+		},
+	}
+	w := classifyWeaknesses(log)
+	if !w.SSHv1 || !w.WeakKex || !w.WeakHostKey || !w.WeakCipher || w.WeakMAC {
+		t.Errorf("Wrong classification: %+v", *w)
+	}
+	want := []string{kexAlgoDH1SHA1, KeyAlgoDSA, "arcfour", "arcfour256"}
+	if len(w.WeakAlgorithms) != len(want) {
+		t.Fatalf("Wrong weak algorithms - expected: %v, got: %+v", want, w.WeakAlgorithms)
+	}
+	for i, name := range want {
+		if w.WeakAlgorithms[i].Name != name {
+			t.Errorf("Wrong weak algorithm %d - expected: %s, got: %s", i, name, w.WeakAlgorithms[i].Name)
+		}
+	}
+
+	// A MAC offered in only one direction is still offered
+	log.ServerID.ProtoVersion = "2.0"
+	log.ServerKex = &kexInitMsg{MACsServerClient: []string{"hmac-md5-96"}}
+	w = classifyWeaknesses(log)
+	if w.SSHv1 || w.WeakKex || w.WeakCipher || !w.WeakMAC || len(w.WeakAlgorithms) != 1 {
+		t.Errorf("Wrong classification: %+v", *w)
+	}
+	if w.WeakAlgorithms[0].Kind != AlgorithmKindMAC {
+		t.Errorf("Wrong kind - expected: %s, got: %s", AlgorithmKindMAC, w.WeakAlgorithms[0].Kind)
+	}
+}
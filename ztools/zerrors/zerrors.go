@@ -0,0 +1,124 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package zerrors maps the errors a grab fails with onto a small set of
+// classes that, unlike the error messages, do not vary with the operating
+// system or Go version.
+package zerrors
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/util"
+	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
+)
+
+// Error classes returned by Classify. A fatal TLS alert is classified as
+// TLSAlertPrefix followed by its description, e.g. "tls_alert:40".
+const (
+	ConnectionRefused  = "connection_refused"
+	ConnectionTimeout  = "connection_timeout"
+	ConnectionReset    = "connection_reset"
+	NetworkUnreachable = "network_unreachable"
+	HostUnreachable    = "host_unreachable"
+	DNSError           = "dns_error"
+	TLSAlertPrefix     = "tls_alert:"
+	IOEOF              = "io_eof"
+	ProtocolError      = "protocol_error"
+	TooManyBytes       = "too_many_bytes"
+	Unknown            = "unknown"
+)
+
+// A Classified error knows its own class, which Classify returns as is
+type Classified interface {
+	error
+	ErrorClass() string
+}
+
+type classifiedError struct {
+	class string
+	text  string
+}
+
+func (e *classifiedError) Error() string {
+	return e.text
+}
+
+func (e *classifiedError) ErrorClass() string {
+	return e.class
+}
+
+// New returns an error with the given text that Classify maps onto class
+func New(class, text string) error {
+	return &classifiedError{class: class, text: text}
+}
+
+// TLSAlert returns the class of a fatal alert with the given description
+func TLSAlert(description uint8) string {
+	return TLSAlertPrefix + strconv.Itoa(int(description))
+}
+
+// Classify returns the class of err, or "" for a nil error. Socket errors
+// the table does not cover are Unknown; errors that did not come from the
+// network at all, e.g. a malformed reply, are ProtocolError.
+func Classify(err error) string {
+	if err == nil {
+		return ""
+	}
+	var classified Classified
+	if errors.As(err, &classified) {
+		return classified.ErrorClass()
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		if description, ok := ztls.RemoteAlert(opErr); ok {
+			return TLSAlert(description)
+		}
+	}
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, util.ErrBufferFull):
+		return TooManyBytes
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return IOEOF
+	case errors.As(err, &dnsErr):
+		return DNSError
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EPIPE):
+		return ConnectionReset
+	case errors.Is(err, syscall.ENETUNREACH):
+		return NetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return HostUnreachable
+	case errors.Is(err, syscall.ETIMEDOUT):
+		return ConnectionTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ConnectionTimeout
+		}
+		return Unknown
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return Unknown
+	}
+	return ProtocolError
+}
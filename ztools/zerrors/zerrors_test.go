@@ -0,0 +1,89 @@
+/*
+ * ZGrab Copyright 2015 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zerrors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/util"
+	"gopkg.in/eniac/zgrab.v0/ztools/ztls"
+)
+
+// timeoutError is a net.Error like the one a missed deadline returns
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func opError(op string, errno syscall.Errno) error {
+	return &net.OpError{Op: op, Net: "tcp", Err: &os.SyscallError{Syscall: op, Err: errno}}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"refused", opError("dial", syscall.ECONNREFUSED), ConnectionRefused},
+		{"reset", opError("read", syscall.ECONNRESET), ConnectionReset},
+		{"broken pipe", opError("write", syscall.EPIPE), ConnectionReset},
+		{"network unreachable", opError("dial", syscall.ENETUNREACH), NetworkUnreachable},
+		{"host unreachable", opError("dial", syscall.EHOSTUNREACH), HostUnreachable},
+		{"connect timed out", opError("dial", syscall.ETIMEDOUT), ConnectionTimeout},
+		{"deadline", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, ConnectionTimeout},
+		{"dns", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "example.invalid"}}, DNSError},
+		{"dns timeout", &net.DNSError{Err: "timeout", Name: "example.com", IsTimeout: true}, DNSError},
+		{"eof", io.EOF, IOEOF},
+		{"unexpected eof", io.ErrUnexpectedEOF, IOEOF},
+		{"wrapped eof", fmt.Errorf("reading banner: %w", io.EOF), IOEOF},
+		{"buffer full", util.ErrBufferFull, TooManyBytes},
+		{"classified", New(TooManyBytes, "read limit exceeded"), TooManyBytes},
+		{"other errno", opError("read", syscall.EINVAL), Unknown},
+		{"protocol", errors.New("Invalid Redis bulk string length: x"), ProtocolError},
+	}
+	for _, test := range tests {
+		if got := Classify(test.err); got != test.want {
+			t.Errorf("%s: Classify(%v) = %q, expected %q", test.name, test.err, got, test.want)
+		}
+	}
+}
+
+func TestClassifyTLSAlert(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		server.Read(make([]byte, 1024))
+		// A fatal handshake_failure alert
+		server.Write([]byte{0x15, 0x03, 0x01, 0x00, 0x02, 0x02, 40})
+	}()
+
+	c := ztls.Client(client, &ztls.Config{InsecureSkipVerify: true})
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	err := c.Handshake()
+	if got := Classify(err); got != "tls_alert:40" {
+		t.Errorf("Classify(%v) = %q, expected tls_alert:40", err, got)
+	}
+}
@@ -37,6 +37,8 @@ const (
 	alertInternalError          alert = 80
 	alertUserCanceled           alert = 90
 	alertNoRenegotiation        alert = 100
+	alertMissingExtension       alert = 109
+	alertUnsupportedExtension   alert = 110
 )
 
 var alertText = map[alert]string{
@@ -62,6 +64,8 @@ var alertText = map[alert]string{
 	alertInternalError:          "internal error",
 	alertUserCanceled:           "user canceled",
 	alertNoRenegotiation:        "no renegotiation",
+	alertMissingExtension:       "missing extension",
+	alertUnsupportedExtension:   "unsupported extension",
 }
 
 func (e alert) String() string {
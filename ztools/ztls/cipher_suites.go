@@ -613,6 +613,9 @@ const (
 	TLS_DHE_RSA_WITH_CAMELLIA_256_CBC_SHA256      = 0x00C4
 	TLS_DH_ANON_WITH_CAMELLIA_256_CBC_SHA256      = 0x00C5
 	TLS_RENEGO_PROTECTION_REQUEST                 = 0x00FF
+	TLS_AES_128_GCM_SHA256                        = 0x1301
+	TLS_AES_256_GCM_SHA384                        = 0x1302
+	TLS_CHACHA20_POLY1305_SHA256                  = 0x1303
 	TLS_FALLBACK_SCSV                             = 0x5600
 	TLS_ECDH_ECDSA_WITH_NULL_SHA                  = 0xC001
 	TLS_ECDH_ECDSA_WITH_RC4_128_SHA               = 0xC002
@@ -818,6 +821,15 @@ var RSACiphers = []uint16{
 	TLS_RSA_WITH_AES_128_GCM_SHA256,
 }
 
+var RC4Ciphers []uint16 = []uint16{
+	TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+	TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+	TLS_DHE_DSS_WITH_RC4_128_SHA,
+	TLS_RSA_WITH_RC4_128_SHA,
+	TLS_RSA_WITH_RC4_128_MD5,
+	TLS_RSA_EXPORT_WITH_RC4_40_MD5,
+}
+
 var DHECiphers []uint16 = []uint16{
 	TLS_DHE_DSS_WITH_DES_CBC_SHA,
 	TLS_DHE_DSS_WITH_3DES_EDE_CBC_SHA,
@@ -1074,3 +1086,21 @@ var SChannelSuites []uint16 = []uint16{
 	TLS_RSA_WITH_AES_128_GCM_SHA256,
 	TLS_RSA_WITH_RC4_128_SHA,
 }
+
+// CipherSuitePresets maps the names accepted on the command line to the
+// cipher suite lists offered in the ClientHello
+var CipherSuitePresets = map[string][]uint16{
+	"rsa":           RSACiphers,
+	"rc4":           RC4Ciphers,
+	"dhe":           DHECiphers,
+	"ecdhe":         ECDHECiphers,
+	"export":        ExportCiphers,
+	"rsa-export":    RSA512ExportCiphers,
+	"dhe-export":    DHEExportCiphers,
+	"chrome":        ChromeCiphers,
+	"chrome-nodhe":  ChromeNoDHECiphers,
+	"firefox":       FirefoxCiphers,
+	"firefox-nodhe": FirefoxNoDHECiphers,
+	"safari":        SafariCiphers,
+	"safari-nodhe":  SafariNoDHECiphers,
+}
@@ -24,6 +24,7 @@ const (
 	VersionTLS10 = 0x0301
 	VersionTLS11 = 0x0302
 	VersionTLS12 = 0x0303
+	VersionTLS13 = 0x0304
 )
 
 const (
@@ -54,6 +55,7 @@ const (
 	typeServerHello         uint8 = 2
 	typeHelloVerifyRequest  uint8 = 3
 	typeNewSessionTicket    uint8 = 4
+	typeEncryptedExts13     uint8 = 8 // TLS 1.3 EncryptedExtensions
 	typeCertificate         uint8 = 11
 	typeServerKeyExchange   uint8 = 12
 	typeCertificateRequest  uint8 = 13
@@ -62,8 +64,10 @@ const (
 	typeClientKeyExchange   uint8 = 16
 	typeFinished            uint8 = 20
 	typeCertificateStatus   uint8 = 22
+	typeKeyUpdate           uint8 = 24
 	typeNextProtocol        uint8 = 67  // Not IANA assigned
 	typeEncryptedExtensions uint8 = 203 // Not IANA assigned
+	typeMessageHash         uint8 = 254 // Transcript placeholder, RFC 8446 section 4.4.1
 )
 
 // TLS compression types.
@@ -73,18 +77,23 @@ const (
 
 // TLS extension numbers
 const (
-	extensionServerName           uint16 = 0
-	extensionStatusRequest        uint16 = 5
-	extensionSupportedCurves      uint16 = 10
-	extensionSupportedPoints      uint16 = 11
-	extensionSignatureAlgorithms  uint16 = 13
-	extensionALPN                 uint16 = 16
-	extensionExtendedMasterSecret uint16 = 23
-	extensionSessionTicket        uint16 = 35
-	extensionNextProtoNeg         uint16 = 13172 // not IANA assigned
-	extensionRenegotiationInfo    uint16 = 0xff01
-	extensionExtendedRandom       uint16 = 0x0028 // not IANA assigned
-	extensionSCT                  uint16 = 18
+	extensionServerName             uint16 = 0
+	extensionStatusRequest          uint16 = 5
+	extensionSupportedCurves        uint16 = 10
+	extensionSupportedPoints        uint16 = 11
+	extensionSignatureAlgorithms    uint16 = 13
+	extensionALPN                   uint16 = 16
+	extensionExtendedMasterSecret   uint16 = 23
+	extensionSessionTicket          uint16 = 35
+	extensionSupportedVersions      uint16 = 43
+	extensionCookie                 uint16 = 44
+	extensionPSKModes               uint16 = 45
+	extensionCertificateAuthorities uint16 = 47
+	extensionKeyShare               uint16 = 51
+	extensionNextProtoNeg           uint16 = 13172 // not IANA assigned
+	extensionRenegotiationInfo      uint16 = 0xff01
+	extensionExtendedRandom         uint16 = 0x0028 // not IANA assigned
+	extensionSCT                    uint16 = 18
 )
 
 // TLS signaling cipher suite values
@@ -136,6 +145,10 @@ const (
 	hashSHA256 uint8 = 4
 	hashSHA384 uint8 = 5
 	hashSHA512 uint8 = 6
+
+	// hashIntrinsic marks schemes that name their digest in the signature
+	// byte, such as RSASSA-PSS (See RFC 8446, section 4.2.3)
+	hashIntrinsic uint8 = 8
 )
 
 // Signature algorithms for TLS 1.2 (See RFC 5246, section A.4.1)
@@ -168,6 +181,17 @@ var defaultSKXSignatureAlgorithms = []signatureAndHash{
 	{signatureECDSA, hashSHA1},
 }
 
+// tls13SignatureAndHashes are the RSASSA-PSS and ECDSA schemes offered ahead
+// of the TLS 1.2 algorithms when TLS 1.3 is enabled. TLS 1.3 servers cannot
+// sign with PKCS #1 v1.5.
+var tls13SignatureAndHashes = []signatureAndHash{
+	{hashSHA256, hashIntrinsic},
+	{signatureECDSA, hashSHA384},
+	{hashSHA384, hashIntrinsic},
+	{signatureECDSA, hashSHA512},
+	{hashSHA512, hashIntrinsic},
+}
+
 // supportedClientCertSignatureAlgorithms contains the signature and hash
 // algorithms that the code advertises as supported in a TLS 1.2
 // CertificateRequest.
@@ -181,6 +205,7 @@ type ConnectionState struct {
 	Version                    uint16                // TLS version used by the connection (e.g. VersionTLS12)
 	HandshakeComplete          bool                  // TLS handshake is complete
 	DidResume                  bool                  // connection resumes a previous TLS connection
+	ResumedWithTicket          bool                  // the resumed session was offered as a session ticket rather than a session ID
 	CipherSuite                uint16                // cipher suite in use (TLS_RSA_WITH_RC4_128_SHA, ...)
 	NegotiatedProtocol         string                // negotiated next protocol (from Config.NextProtos)
 	NegotiatedProtocolIsMutual bool                  // negotiated protocol was advertised by server
@@ -205,6 +230,7 @@ const (
 // sessions.
 type ClientSessionState struct {
 	sessionTicket        []uint8             // Encrypted ticket used for session resumption with server
+	sessionID            []uint8             // Session ID to resume with when the server issued no ticket
 	lifetimeHint         uint32              // Hint from server about how long the session ticket should be stored
 	vers                 uint16              // SSL/TLS version negotiated for the session
 	cipherSuite          uint16              // Ciphersuite negotiated for the session
@@ -317,8 +343,9 @@ type Config struct {
 	MinVersion uint16
 
 	// MaxVersion contains the maximum SSL/TLS version that is acceptable.
-	// If zero, then the maximum version supported by this package is used,
-	// which is currently TLS 1.2.
+	// If zero, then TLS 1.2 is used. TLS 1.3 has to be asked for: its
+	// key_share offers only NIST curves, and no ChaCha20 suite is offered,
+	// so many servers answer with a HelloRetryRequest or fail the handshake.
 	MaxVersion uint16
 
 	// CurvePreferences contains the elliptic curves that will be used in
@@ -359,6 +386,30 @@ type Config struct {
 
 	// Send an invalid DH key exchange value
 	InvalidDHKeyExchange string
+
+	// Do not offer the status_request (OCSP stapling) extension
+	NoOCSPStapling bool
+
+	// CT logs to check the signatures of the server's SCTs against
+	CTLogs *CTLogList
+
+	// Keep the raw bytes of the first flight in each direction
+	CaptureHandshakeBytes bool
+
+	// Abandon a full handshake with a close_notify once the server's
+	// Certificate, and any CertificateStatus, has been recorded, returning
+	// ErrCertificatesOnly. No key exchange is computed or sent.
+	CertificatesOnly bool
+
+	// Return from a full handshake right after sending the client Finished
+	// when the negotiated suite is ECDHE with an AEAD cipher, so that data
+	// written before the first Read goes out ahead of the server's Finished
+	// (RFC 7918). Not used together with a ClientSessionCache.
+	FalseStart bool
+
+	// Shape the ClientHello like the profile's client. Ignored when
+	// ExternalClientHello is set.
+	ClientHelloProfile *ClientHelloProfile
 }
 
 func (c *Config) serverInit() {
@@ -488,10 +539,14 @@ func (c *Config) signatureAndHashesForClient() []signatureAndHash {
 			return c.SignatureAndHashes
 		}
 	*/
+	algorithms := defaultSKXSignatureAlgorithms
 	if c.ClientDSAEnabled {
-		return supportedSKXSignatureAlgorithms
+		algorithms = supportedSKXSignatureAlgorithms
+	}
+	if c.maxVersion() >= VersionTLS13 {
+		algorithms = append(append([]signatureAndHash{}, tls13SignatureAndHashes...), algorithms...)
 	}
-	return defaultSKXSignatureAlgorithms
+	return algorithms
 }
 
 // BuildNameToCertificate parses c.Certificates and builds c.NameToCertificate
@@ -35,6 +35,7 @@ type Conn struct {
 	config               *Config    // configuration passed to constructor
 	handshakeComplete    bool
 	didResume            bool // whether this connection was a session resumption
+	resumedWithTicket    bool // whether the resumed session was offered as a ticket
 	extendedMasterSecret bool // whether this session used an extended master secret
 	cipherSuite          uint16
 	ocspResponse         []byte // stapled OCSP response
@@ -69,6 +70,20 @@ type Conn struct {
 
 	// Raw client hello
 	clientHelloRaw []byte
+
+	// Client Finished verify_data, sent again when renegotiating
+	clientVerifyData []byte
+	renegotiating    bool
+
+	// Set after a False Start until the server's Finished has been read
+	falseStart *clientHandshakeState
+
+	// GREASE values of a ClientHelloProfile hello, kept for a second hello
+	helloGREASE *helloGREASE
+
+	// The first fatal alert received, and the last one sent
+	receivedAlert *Alert
+	sentAlert     *alert
 }
 
 func (c *Conn) ClientHelloRaw() []byte {
@@ -138,6 +153,10 @@ type halfConn struct {
 	nextCipher interface{} // next encryption state
 	nextMac    macFunction // next MAC algorithm
 
+	// TLS 1.3 traffic keys, kept for KeyUpdate
+	trafficSuite  *cipherSuiteTLS13
+	trafficSecret []byte
+
 	// used to save allocating a new buffer for each MAC.
 	inDigestBuf, outDigestBuf []byte
 }
@@ -275,6 +294,9 @@ type cbcMode interface {
 // success boolean, the number of bytes to skip from the start of the record in
 // order to get the application payload, and an optional alert value.
 func (hc *halfConn) decrypt(b *block) (ok bool, prefixLen int, alertValue alert) {
+	if c, ok := hc.cipher.(*tls13Aead); ok {
+		return hc.decryptTLS13(c, b)
+	}
 	recordHeaderLen := hc.recordHeaderLen()
 
 	// pull out payload
@@ -400,6 +422,9 @@ func padToBlockSize(payload []byte, blockSize int) (prefix, finalBlock []byte) {
 
 // encrypt encrypts and macs the data in b.
 func (hc *halfConn) encrypt(b *block, explicitIVLen int) (bool, alert) {
+	if c, ok := hc.cipher.(*tls13Aead); ok {
+		return hc.encryptTLS13(c, b)
+	}
 	recordHeaderLen := hc.recordHeaderLen()
 
 	// mac
@@ -570,7 +595,7 @@ func (c *Conn) readRecord(want recordType) error {
 		c.sendAlert(alertInternalError)
 		return c.in.setErrorLocked(errors.New("tls: unknown record type requested"))
 	case recordTypeHandshake, recordTypeChangeCipherSpec:
-		if c.handshakeComplete {
+		if c.handshakeComplete && !c.renegotiating && c.falseStart == nil {
 			c.sendAlert(alertInternalError)
 			return c.in.setErrorLocked(errors.New("tls: handshake or ChangeCipherSpec requested after handshake complete"))
 		}
@@ -613,9 +638,14 @@ Again:
 
 	vers := uint16(b.data[1])<<8 | uint16(b.data[2])
 	n := int(b.data[3])<<8 | int(b.data[4])
-	if c.haveVers && vers != c.vers {
+	// TLS 1.3 records keep the TLS 1.2 version number
+	expectVers := c.vers
+	if expectVers > VersionTLS12 {
+		expectVers = VersionTLS12
+	}
+	if c.haveVers && vers != expectVers {
 		c.sendAlert(alertProtocolVersion)
-		return c.in.setErrorLocked(fmt.Errorf("tls: received record with version %x when expecting version %x", vers, c.vers))
+		return c.in.setErrorLocked(fmt.Errorf("tls: received record with version %x when expecting version %x", vers, expectVers))
 	}
 	if n > maxCiphertext {
 		c.sendAlert(alertRecordOverflow)
@@ -652,6 +682,8 @@ Again:
 		c.in.setErrorLocked(c.sendAlert(err))
 	}
 	b.off = off
+	// A TLS 1.3 record's real type was inside the ciphertext
+	typ = recordType(b.data[0])
 	data := b.data[b.off:]
 	if len(data) > maxPlaintext {
 		err := c.sendAlert(alertRecordOverflow)
@@ -674,16 +706,28 @@ Again:
 		}
 		switch data[0] {
 		case alertLevelWarning:
+			if c.renegotiating && alert(data[1]) == alertNoRenegotiation {
+				c.in.freeBlock(b)
+				return alertNoRenegotiation
+			}
 			// drop on the floor
 			c.in.freeBlock(b)
 			goto Again
 		case alertLevelError:
+			if c.receivedAlert == nil {
+				c.receivedAlert = newAlert(data[0], alert(data[1]))
+			}
 			c.in.setErrorLocked(&net.OpError{Op: "remote error", Err: alert(data[1])})
 		default:
 			c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
 		}
 
 	case recordTypeChangeCipherSpec:
+		if c.vers >= VersionTLS13 && !c.handshakeComplete && len(data) == 1 && data[0] == 1 {
+			// Middlebox compatibility, RFC 8446 appendix D.4
+			c.in.freeBlock(b)
+			goto Again
+		}
 		if typ != want || len(data) != 1 || data[0] != 1 {
 			c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
 			break
@@ -703,6 +747,12 @@ Again:
 
 	case recordTypeHandshake:
 		// TODO(rsc): Should at least pick off connection close.
+		if typ != want && c.vers >= VersionTLS13 && c.handshakeComplete {
+			if err := c.handlePostHandshakeTLS13(data); err != nil {
+				c.in.setErrorLocked(err)
+			}
+			break
+		}
 		if typ != want {
 			return c.in.setErrorLocked(c.sendAlert(alertNoRenegotiation))
 		}
@@ -711,7 +761,7 @@ Again:
 		if want != recordTypeHeartbeat {
 			return c.sendAlert(alertUnexpectedMessage)
 		}
-		c.heartbleedLog.Vulnerable = true
+		c.heartbleedLog.HeartbeatResponded = true
 		c.input = b
 		b = nil
 	}
@@ -732,6 +782,7 @@ func (c *Conn) sendAlertLocked(err alert) error {
 		c.tmp[0] = alertLevelError
 	}
 	c.tmp[1] = byte(err)
+	c.sentAlert = &err
 	c.writeRecord(recordTypeAlert, c.tmp[0:2])
 	// closeNotify is a special case in that it isn't an error:
 	if err != alertCloseNotify {
@@ -792,6 +843,8 @@ func (c *Conn) writeRecord(typ recordType, data []byte) (n int, err error) {
 			// Some TLS servers fail if the record version is
 			// greater than TLS 1.0 for the initial ClientHello.
 			vers = VersionTLS10
+		} else if vers > VersionTLS12 {
+			vers = VersionTLS12
 		}
 		b.data[1] = byte(vers >> 8)
 		b.data[2] = byte(vers)
@@ -818,7 +871,7 @@ func (c *Conn) writeRecord(typ recordType, data []byte) (n int, err error) {
 	}
 	c.out.freeBlock(b)
 
-	if typ == recordTypeChangeCipherSpec {
+	if typ == recordTypeChangeCipherSpec && c.vers < VersionTLS13 {
 		err = c.out.changeCipherSpec()
 		if err != nil {
 			// Cannot call sendAlert directly,
@@ -869,9 +922,22 @@ func (c *Conn) readHandshake() (interface{}, error) {
 		m = new(serverHelloMsg)
 	case typeNewSessionTicket:
 		m = new(newSessionTicketMsg)
+	case typeEncryptedExts13:
+		if c.vers < VersionTLS13 {
+			return nil, c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
+		}
+		m = new(encryptedExtensionsMsg)
 	case typeCertificate:
-		m = new(certificateMsg)
+		if c.vers >= VersionTLS13 {
+			m = new(certificateMsgTLS13)
+		} else {
+			m = new(certificateMsg)
+		}
 	case typeCertificateRequest:
+		if c.vers >= VersionTLS13 {
+			m = new(certificateRequestMsgTLS13)
+			break
+		}
 		m = &certificateRequestMsg{
 			hasSignatureAndHash: c.vers >= VersionTLS12,
 		}
@@ -959,6 +1025,10 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 		return
 	}
 
+	if err = c.finishFalseStart(); err != nil {
+		return
+	}
+
 	c.in.Lock()
 	defer c.in.Unlock()
 
@@ -1040,7 +1110,35 @@ func (c *Conn) Handshake() error {
 	}
 
 	if c.isClient {
+		var recorder *flightRecorder
+		if c.config != nil && c.config.CaptureHandshakeBytes {
+			recorder = &flightRecorder{Conn: c.conn}
+			c.conn = recorder
+		}
 		c.handshakeErr = c.clientHandshake()
+		if recorder != nil {
+			c.conn = recorder.Conn
+			if c.handshakeLog != nil {
+				c.handshakeLog.RawClientFlight = recorder.client
+				c.handshakeLog.RawServerFlight = recorder.server
+				c.handshakeLog.RawFlightTruncated = recorder.truncated
+			}
+		}
+		if c.handshakeLog != nil {
+			c.handshakeLog.SCTs = collectSCTs(c.handshakeLog, c.config.CTLogs)
+		}
+		if c.handshakeErr == nil {
+			// A protocol picked without the server's agreement is not
+			// recorded as negotiated
+			if !c.clientProtocolFallback {
+				c.handshakeLog.NegotiatedProtocol = c.clientProtocol
+			}
+		} else if c.handshakeErr == ErrCertificatesOnly && c.handshakeLog != nil {
+			c.handshakeLog.Abandoned = true
+		} else if c.handshakeLog != nil {
+			c.handshakeLog.ErrorCategory = c.classifyHandshakeError(c.handshakeErr)
+			c.handshakeLog.Alert = c.receivedAlert
+		}
 	} else {
 		c.handshakeErr = c.serverHandshake()
 	}
@@ -1058,6 +1156,7 @@ func (c *Conn) ConnectionState() ConnectionState {
 		state.Version = c.vers
 		state.NegotiatedProtocol = c.clientProtocol
 		state.DidResume = c.didResume
+		state.ResumedWithTicket = c.resumedWithTicket
 		state.NegotiatedProtocolIsMutual = !c.clientProtocolFallback
 		state.CipherSuite = c.cipherSuite
 		state.PeerCertificates = c.peerCertificates
@@ -18,6 +18,7 @@ import (
 	"net"
 	"strconv"
 
+	"gopkg.in/eniac/zgrab.v0/ztools/fingerprint"
 	"gopkg.in/eniac/zgrab.v0/ztools/x509"
 )
 
@@ -38,6 +39,7 @@ func (c *Conn) clientHandshake() error {
 	}
 
 	var hello *clientHelloMsg
+	var key *ephemeralKey
 
 	var session *ClientSessionState
 	var sessionCache ClientSessionCache
@@ -72,7 +74,7 @@ func (c *Conn) clientHandshake() error {
 			vers:                 c.config.maxVersion(),
 			compressionMethods:   []uint8{compressionNone},
 			random:               make([]byte, 32),
-			ocspStapling:         true,
+			ocspStapling:         !c.config.NoOCSPStapling,
 			serverName:           c.config.ServerName,
 			supportedCurves:      c.config.curvePreferences(),
 			supportedPoints:      []uint8{pointFormatUncompressed},
@@ -171,7 +173,11 @@ func (c *Conn) clientHandshake() error {
 			}
 		}
 
-		if session != nil {
+		if session != nil && session.sessionTicket == nil {
+			// The server issued no ticket, so offer the session ID it
+			// assigned; it echoes the ID back only when resuming
+			hello.sessionId = session.sessionID
+		} else if session != nil {
 			hello.sessionTicket = session.sessionTicket
 			// A random session ID is used to detect when the
 			// server accepted the ticket and is resuming a session
@@ -182,6 +188,23 @@ func (c *Conn) clientHandshake() error {
 				return errors.New("tls: short read from Rand: " + err.Error())
 			}
 		}
+
+		profile := c.config.ClientHelloProfile
+		if profile != nil {
+			if err := c.applyClientHelloProfile(hello, profile); err != nil {
+				return err
+			}
+		}
+		if hello.vers >= VersionTLS13 {
+			var err error
+			if key, err = c.offerTLS13(hello); err != nil {
+				c.sendAlert(alertInternalError)
+				return err
+			}
+		}
+		if profile != nil {
+			c.shapeClientHello(hello, false)
+		}
 	}
 
 	c.handshakeLog = new(ServerHandshake)
@@ -189,6 +212,12 @@ func (c *Conn) clientHandshake() error {
 
 	c.writeRecord(recordTypeHandshake, hello.marshal())
 	c.handshakeLog.ClientHello = hello.MakeLog()
+	if profile := c.config.ClientHelloProfile; profile != nil && c.config.ExternalClientHello == nil {
+		c.handshakeLog.ClientHello.Profile = profile.Name
+		if ja3, err := JA3(hello.marshal()); err == nil {
+			c.handshakeLog.ClientHello.JA3 = fingerprint.New(ja3)
+		}
+	}
 
 	msg, err := c.readHandshake()
 	if err != nil {
@@ -200,6 +229,11 @@ func (c *Conn) clientHandshake() error {
 		return unexpectedMessageError(serverHello, msg)
 	}
 	c.handshakeLog.ServerHello = serverHello.MakeLog()
+	c.handshakeLog.JA3S = fingerprint.New(JA3S(c.handshakeLog.ServerHello))
+
+	if len(hello.supportedVersions) > 0 && serverHello.supportedVersion != 0 {
+		return c.clientHandshakeTLS13(hello, serverHello, key)
+	}
 
 	if serverHello.heartbeatEnabled {
 		c.heartbeat = true
@@ -214,9 +248,9 @@ func (c *Conn) clientHandshake() error {
 	c.vers = vers
 	c.haveVers = true
 
-	suite := mutualCipherSuite(c.config.cipherSuites(), serverHello.cipherSuite)
+	suite := mutualCipherSuite(hello.cipherSuites, serverHello.cipherSuite)
 	cipherImplemented := cipherIDInCipherList(serverHello.cipherSuite, implementedCipherSuites)
-	cipherShared := cipherIDInCipherIDList(serverHello.cipherSuite, c.config.cipherSuites())
+	cipherShared := cipherIDInCipherIDList(serverHello.cipherSuite, hello.cipherSuites)
 	if suite == nil {
 		//c.sendAlert(alertHandshakeFailure)
 		if !cipherShared {
@@ -270,32 +304,105 @@ func (c *Conn) clientHandshake() error {
 		if err := hs.sendFinished(); err != nil {
 			return err
 		}
-		if err := hs.readSessionTicket(); err != nil {
-			return err
-		}
-		if err := hs.readFinished(); err != nil {
-			return err
+		if c.config.FalseStart && sessionCache == nil && hs.falseStartAllowed() {
+			// The rest of the server's flight is read by the first Read
+			c.falseStart = hs
+			c.handshakeLog.FalseStart = true
+		} else {
+			if err := hs.readSessionTicket(); err != nil {
+				return err
+			}
+			if err := hs.readFinished(); err != nil {
+				return err
+			}
 		}
 	}
 
-	if hs.session == nil {
-		c.handshakeLog.SessionTicket = nil
-	} else {
-		c.handshakeLog.SessionTicket = hs.session.MakeLog()
-	}
+	hs.logSessionTicket()
 
 	c.handshakeLog.KeyMaterial = hs.MakeLog()
 
 	if sessionCache != nil && hs.session != nil && session != hs.session {
 		sessionCache.Put(cacheKey, hs.session)
+	} else if sessionCache != nil && hs.session == nil && !isResume && len(serverHello.sessionId) > 0 {
+		sessionCache.Put(cacheKey, &ClientSessionState{
+			sessionID:            serverHello.sessionId,
+			vers:                 c.vers,
+			cipherSuite:          suite.id,
+			masterSecret:         hs.masterSecret,
+			serverCertificates:   c.peerCertificates,
+			extendedMasterSecret: c.extendedMasterSecret,
+		})
 	}
 
 	c.didResume = isResume
+	c.resumedWithTicket = isResume && session.sessionTicket != nil
 	c.handshakeComplete = true
 	c.cipherSuite = suite.id
 	return nil
 }
 
+// processServerCertificates parses and validates the server's chain and
+// records it in the handshake log. Validation failures are fatal only when
+// verifying.
+func (c *Conn) processServerCertificates(certMsg *certificateMsg) error {
+	certs, parseErrs := parseCertificates(certMsg.certificates)
+	invalidCert := false
+	var invalidCertErr error
+	for _, parseErr := range parseErrs {
+		if parseErr != nil {
+			invalidCert = true
+			invalidCertErr = parseErr
+			break
+		}
+	}
+
+	c.handshakeLog.ServerCertificates = certMsg.MakeLog()
+	if invalidCert {
+		c.handshakeLog.ServerCertificates.addParsed(certs, nil)
+		c.handshakeLog.ServerCertificates.addParseErrors(parseErrs)
+	}
+
+	if !invalidCert {
+		opts := x509.VerifyOptions{
+			Roots:         c.config.RootCAs,
+			CurrentTime:   c.config.time(),
+			DNSName:       c.config.ServerName,
+			Intermediates: x509.NewCertPool(),
+		}
+
+		// Always check validity of the certificates
+		for _, cert := range certs {
+			/*
+				if i == 0 {
+					continue
+				}
+			*/
+			opts.Intermediates.AddCert(cert)
+		}
+		var validation *x509.Validation
+		var err error
+		c.verifiedChains, validation, err = certs[0].ValidateWithStupidDetail(opts)
+		c.handshakeLog.ServerCertificates.addParsed(certs, validation)
+
+		// If actually verifying and invalid, reject
+		if !c.config.InsecureSkipVerify {
+			if err != nil {
+				c.sendAlert(alertBadCertificate)
+				return err
+			}
+		}
+	}
+
+	if invalidCert {
+		c.sendAlert(alertBadCertificate)
+		return errors.New("tls: failed to parse certificate from server: " + invalidCertErr.Error())
+	}
+
+	c.peerCertificates = certs
+	return nil
+}
+
 func (hs *clientHandshakeState) doFullHandshake() error {
 	c := hs.c
 
@@ -317,58 +424,10 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		}
 		hs.finishedHash.Write(certMsg.marshal())
 
-		certs := make([]*x509.Certificate, len(certMsg.certificates))
-		invalidCert := false
-		var invalidCertErr error
-		for i, asn1Data := range certMsg.certificates {
-			cert, err := x509.ParseCertificate(asn1Data)
-			if err != nil {
-				invalidCert = true
-				invalidCertErr = err
-				break
-			}
-			certs[i] = cert
-		}
-
-		c.handshakeLog.ServerCertificates = certMsg.MakeLog()
-
-		if !invalidCert {
-			opts := x509.VerifyOptions{
-				Roots:         c.config.RootCAs,
-				CurrentTime:   c.config.time(),
-				DNSName:       c.config.ServerName,
-				Intermediates: x509.NewCertPool(),
-			}
-
-			// Always check validity of the certificates
-			for _, cert := range certs {
-				/*
-					if i == 0 {
-						continue
-					}
-				*/
-				opts.Intermediates.AddCert(cert)
-			}
-			var validation *x509.Validation
-			c.verifiedChains, validation, err = certs[0].ValidateWithStupidDetail(opts)
-			c.handshakeLog.ServerCertificates.addParsed(certs, validation)
-
-			// If actually verifying and invalid, reject
-			if !c.config.InsecureSkipVerify {
-				if err != nil {
-					c.sendAlert(alertBadCertificate)
-					return err
-				}
-			}
-		}
-
-		if invalidCert {
-			c.sendAlert(alertBadCertificate)
-			return errors.New("tls: failed to parse certificate from server: " + invalidCertErr.Error())
+		if err := c.processServerCertificates(certMsg); err != nil {
+			return err
 		}
 
-		c.peerCertificates = certs
-
 		if hs.serverHello.ocspStapling {
 			msg, err = c.readHandshake()
 			if err != nil {
@@ -383,10 +442,11 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 
 			if cs.statusType == statusTypeOCSP {
 				c.ocspResponse = cs.response
+				c.handshakeLog.OCSPStaple = parseOCSPStaple(cs.response, c.config.time())
 			}
 		}
 
-		serverCert = certs[0]
+		serverCert = c.peerCertificates[0]
 
 		var supportedCertKeyType bool
 		switch serverCert.PublicKey.(type) {
@@ -406,6 +466,11 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 			return fmt.Errorf("tls: server's certificate contains an unsupported type of public key: %T", serverCert.PublicKey)
 		}
 
+		if c.config.CertificatesOnly {
+			c.sendAlert(alertCloseNotify)
+			return ErrCertificatesOnly
+		}
+
 		msg, err = c.readHandshake()
 		if err != nil {
 			return err
@@ -456,6 +521,7 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		// arrangement to the contrary.
 
 		hs.finishedHash.Write(certReq.marshal())
+		c.handshakeLog.CertificateRequest = certReq.MakeLog()
 
 		var rsaAvail, ecdsaAvail bool
 		for _, certType := range certReq.certificateTypes {
@@ -530,6 +596,7 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		certMsg := new(certificateMsg)
 		if chainToSend != nil {
 			certMsg.certificates = chainToSend.Certificate
+			c.handshakeLog.CertificateRequest.ClientCertificateSent = true
 		}
 		hs.finishedHash.Write(certMsg.marshal())
 		c.writeRecord(recordTypeHandshake, certMsg.marshal())
@@ -556,7 +623,7 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 
 		// Determine the hash to sign.
 		var signatureType uint8
-		switch c.config.Certificates[0].PrivateKey.(type) {
+		switch chainToSend.PrivateKey.(type) {
 		case *ecdsa.PrivateKey:
 			signatureType = signatureECDSA
 		case *rsa.PrivateKey:
@@ -576,7 +643,7 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 			return err
 		}
 
-		switch key := c.config.Certificates[0].PrivateKey.(type) {
+		switch key := chainToSend.PrivateKey.(type) {
 		case *ecdsa.PrivateKey:
 			var r, s *big.Int
 			r, s, err = ecdsa.Sign(c.config.rand(), key, digest)
@@ -705,6 +772,48 @@ func (hs *clientHandshakeState) processServerHello() (bool, error) {
 	return false, nil
 }
 
+// falseStartAllowed reports whether application data may be sent before
+// the server's Finished, RFC 7918: the key exchange must be forward secret
+// and the cipher an AEAD
+func (hs *clientHandshakeState) falseStartAllowed() bool {
+	if hs.suite == nil || hs.suite.aead == nil {
+		return false
+	}
+	return hs.suite.flags&(suiteECDHE|suiteAnon|suiteExport|suitePSK) == suiteECDHE
+}
+
+// finishFalseStart reads the session ticket and Finished held back by a
+// False Start, if any. An error, typically the server's alert, marks the
+// False Start as aborted.
+func (c *Conn) finishFalseStart() error {
+	c.handshakeMutex.Lock()
+	defer c.handshakeMutex.Unlock()
+	hs := c.falseStart
+	if hs == nil {
+		return nil
+	}
+	err := hs.readSessionTicket()
+	if err == nil {
+		err = hs.readFinished()
+	}
+	c.falseStart = nil
+	if err != nil {
+		c.handshakeLog.FalseStartAborted = true
+		c.handshakeLog.Alert = c.receivedAlert
+		return err
+	}
+	hs.logSessionTicket()
+	return nil
+}
+
+func (hs *clientHandshakeState) logSessionTicket() {
+	if hs.session == nil {
+		hs.c.handshakeLog.SessionTicket = nil
+	} else {
+		hs.c.handshakeLog.SessionTicket = hs.session.MakeLog()
+	}
+}
+
 func (hs *clientHandshakeState) readFinished() error {
 	c := hs.c
 
@@ -731,6 +840,11 @@ func (hs *clientHandshakeState) readFinished() error {
 		return errors.New("tls: server's Finished message was incorrect")
 	}
 	hs.finishedHash.Write(serverFinished.marshal())
+	// The server carries on with the handshake once it accepts the client
+	// certificate, and alerts otherwise
+	if cr := c.handshakeLog.CertificateRequest; cr != nil && cr.ClientCertificateSent {
+		cr.ClientCertificateAccepted = true
+	}
 	return nil
 }
 
@@ -783,6 +897,7 @@ func (hs *clientHandshakeState) sendFinished() error {
 	hs.finishedHash.Write(finished.marshal())
 
 	c.handshakeLog.ClientFinished = finished.MakeLog()
+	c.clientVerifyData = finished.verifyData
 
 	c.writeRecord(recordTypeHandshake, finished.marshal())
 	return nil
@@ -26,6 +26,7 @@ type clientHelloMsg struct {
 	sessionTicket         []uint8
 	signatureAndHashes    []signatureAndHash
 	secureRenegotiation   bool
+	renegotiationInfo     []byte // client_verify_data when renegotiating
 	heartbeatEnabled      bool
 	heartbeatMode         uint8
 	extendedRandomEnabled bool
@@ -33,6 +34,9 @@ type clientHelloMsg struct {
 	extendedMasterSecret  bool
 	sctEnabled            bool
 	alpnProtocols         []string
+	supportedVersions     []uint16
+	keyShares             []keyShare
+	cookie                []byte
 	unknownExtensions     [][]byte
 }
 
@@ -64,6 +68,9 @@ func (m *clientHelloMsg) equal(i interface{}) bool {
 		bytes.Equal(m.extendedRandom, m1.extendedRandom) &&
 		m.extendedMasterSecret == m1.extendedMasterSecret &&
 		eqStrings(m.alpnProtocols, m1.alpnProtocols) &&
+		eqUint16s(m.supportedVersions, m1.supportedVersions) &&
+		reflect.DeepEqual(m.keyShares, m1.keyShares) &&
+		bytes.Equal(m.cookie, m1.cookie) &&
 		reflect.DeepEqual(m.unknownExtensions, m1.unknownExtensions)
 }
 
@@ -103,7 +110,7 @@ func (m *clientHelloMsg) marshal() []byte {
 		numExtensions++
 	}
 	if m.secureRenegotiation {
-		extensionsLength += 1
+		extensionsLength += 1 + len(m.renegotiationInfo)
 		numExtensions++
 	}
 	if len(m.alpnProtocols) > 0 {
@@ -131,6 +138,21 @@ func (m *clientHelloMsg) marshal() []byte {
 	if m.sctEnabled {
 		numExtensions++
 	}
+	if len(m.supportedVersions) > 0 {
+		extensionsLength += 1 + 2*len(m.supportedVersions)
+		numExtensions++
+	}
+	if len(m.keyShares) > 0 {
+		extensionsLength += 2
+		for _, ks := range m.keyShares {
+			extensionsLength += 4 + len(ks.data)
+		}
+		numExtensions++
+	}
+	if len(m.cookie) > 0 {
+		extensionsLength += 2 + len(m.cookie)
+		numExtensions++
+	}
 	if len(m.unknownExtensions) > 0 {
 		// we do not update numExtensions because the extension code and length
 		// are already contained at the beginning of every 'ext' below
@@ -286,8 +308,10 @@ func (m *clientHelloMsg) marshal() []byte {
 		z[0] = byte(extensionRenegotiationInfo >> 8)
 		z[1] = byte(extensionRenegotiationInfo & 0xff)
 		z[2] = 0
-		z[3] = 1
-		z = z[5:]
+		z[3] = byte(1 + len(m.renegotiationInfo))
+		z[4] = byte(len(m.renegotiationInfo))
+		copy(z[5:], m.renegotiationInfo)
+		z = z[5+len(m.renegotiationInfo):]
 	}
 	if len(m.alpnProtocols) > 0 {
 		z[0] = byte(extensionALPN >> 8)
@@ -345,6 +369,57 @@ func (m *clientHelloMsg) marshal() []byte {
 		// zero uint16 for the zero-length extension_data
 		z = z[4:]
 	}
+	if len(m.supportedVersions) > 0 {
+		// https://tools.ietf.org/html/rfc8446#section-4.2.1
+		z[0] = byte(extensionSupportedVersions >> 8)
+		z[1] = byte(extensionSupportedVersions)
+		l := 1 + 2*len(m.supportedVersions)
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		z[4] = byte(l - 1)
+		z = z[5:]
+		for _, vers := range m.supportedVersions {
+			z[0] = byte(vers >> 8)
+			z[1] = byte(vers)
+			z = z[2:]
+		}
+	}
+	if len(m.keyShares) > 0 {
+		// https://tools.ietf.org/html/rfc8446#section-4.2.8
+		z[0] = byte(extensionKeyShare >> 8)
+		z[1] = byte(extensionKeyShare)
+		lengths := z[2:]
+		z = z[6:]
+
+		sharesLength := 0
+		for _, ks := range m.keyShares {
+			z[0] = byte(ks.group >> 8)
+			z[1] = byte(ks.group)
+			z[2] = byte(len(ks.data) >> 8)
+			z[3] = byte(len(ks.data))
+			copy(z[4:], ks.data)
+			z = z[4+len(ks.data):]
+			sharesLength += 4 + len(ks.data)
+		}
+
+		lengths[2] = byte(sharesLength >> 8)
+		lengths[3] = byte(sharesLength)
+		sharesLength += 2
+		lengths[0] = byte(sharesLength >> 8)
+		lengths[1] = byte(sharesLength)
+	}
+	if len(m.cookie) > 0 {
+		// https://tools.ietf.org/html/rfc8446#section-4.2.2
+		z[0] = byte(extensionCookie >> 8)
+		z[1] = byte(extensionCookie)
+		l := 2 + len(m.cookie)
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		z[4] = byte(len(m.cookie) >> 8)
+		z[5] = byte(len(m.cookie))
+		copy(z[6:], m.cookie)
+		z = z[6+len(m.cookie):]
+	}
 	if len(m.unknownExtensions) > 0 {
 		for _, ext := range m.unknownExtensions {
 			copy(z, ext)
@@ -604,7 +679,12 @@ type serverHelloMsg struct {
 	extendedRandom        []byte
 	extendedMasterSecret  bool
 	alpnProtocol          string
+	supportedVersion      uint16
+	serverShare           keyShare
+	selectedGroup         CurveID // HelloRetryRequest only
+	cookie                []byte
 	unknownExtensions     [][]byte
+	extensions            []uint16 // types in the order received
 }
 
 func (m *serverHelloMsg) equal(i interface{}) bool {
@@ -626,6 +706,10 @@ func (m *serverHelloMsg) equal(i interface{}) bool {
 		m.secureRenegotiation == m1.secureRenegotiation &&
 		m.extendedMasterSecret == m1.extendedMasterSecret &&
 		m.alpnProtocol == m1.alpnProtocol &&
+		m.supportedVersion == m1.supportedVersion &&
+		reflect.DeepEqual(m.serverShare, m1.serverShare) &&
+		m.selectedGroup == m1.selectedGroup &&
+		bytes.Equal(m.cookie, m1.cookie) &&
 		reflect.DeepEqual(m.unknownExtensions, m1.unknownExtensions)
 }
 
@@ -846,7 +930,12 @@ func (m *serverHelloMsg) unmarshal(data []byte) bool {
 	m.extendedRandomEnabled = false
 	m.extendedMasterSecret = false
 	m.alpnProtocol = ""
+	m.supportedVersion = 0
+	m.serverShare = keyShare{}
+	m.selectedGroup = 0
+	m.cookie = nil
 	m.unknownExtensions = [][]byte(nil)
+	m.extensions = nil
 
 	if len(data) == 0 {
 		// ServerHello is optionally followed by extension data
@@ -873,6 +962,7 @@ func (m *serverHelloMsg) unmarshal(data []byte) bool {
 		if len(data) < length {
 			return false
 		}
+		m.extensions = append(m.extensions, extension)
 
 		switch extension {
 		case extensionNextProtoNeg:
@@ -898,7 +988,9 @@ func (m *serverHelloMsg) unmarshal(data []byte) bool {
 			}
 			m.ticketSupported = true
 		case extensionRenegotiationInfo:
-			if length != 1 || data[0] != 0 {
+			// Empty on an initial handshake, the client and server
+			// verify_data when renegotiating
+			if length < 1 || int(data[0]) != length-1 {
 				return false
 			}
 			m.secureRenegotiation = true
@@ -969,6 +1061,36 @@ func (m *serverHelloMsg) unmarshal(data []byte) bool {
 				m.scts = append(m.scts, d[:sctLen])
 				d = d[sctLen:]
 			}
+		case extensionSupportedVersions:
+			if length != 2 {
+				return false
+			}
+			m.supportedVersion = uint16(data[0])<<8 | uint16(data[1])
+		case extensionKeyShare:
+			d := data[:length]
+			if len(d) == 2 {
+				// A HelloRetryRequest names only the group
+				m.selectedGroup = CurveID(d[0])<<8 | CurveID(d[1])
+				break
+			}
+			if len(d) < 4 {
+				return false
+			}
+			l := int(d[2])<<8 | int(d[3])
+			if l == 0 || l != len(d)-4 {
+				return false
+			}
+			m.serverShare = keyShare{group: CurveID(d[0])<<8 | CurveID(d[1]), data: d[4:]}
+		case extensionCookie:
+			d := data[:length]
+			if len(d) < 3 {
+				return false
+			}
+			l := int(d[0])<<8 | int(d[1])
+			if l != len(d)-2 {
+				return false
+			}
+			m.cookie = d[2:]
 		default:
 			fullExt := append(fullData[:4], data[:length]...)
 			m.unknownExtensions = append(m.unknownExtensions, fullExt)
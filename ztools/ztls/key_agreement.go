@@ -14,8 +14,10 @@ import (
 	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/asn1"
 	"errors"
+	"hash"
 	"io"
 	"math/big"
 
@@ -219,7 +221,11 @@ func md5SHA1Hash(slices [][]byte) []byte {
 
 // sha256Hash implements TLS 1.2's hash function.
 func sha256Hash(slices [][]byte) []byte {
-	h := sha256.New()
+	return hashSlices(sha256.New(), slices)
+}
+
+// hashSlices calculates the digest of the given byte slices with h.
+func hashSlices(h hash.Hash, slices [][]byte) []byte {
 	for _, slice := range slices {
 		h.Write(slice)
 	}
@@ -236,6 +242,10 @@ func hashForServerKeyExchange(sigType, hashFunc uint8, version uint16, slices ..
 			return sha256Hash(slices), crypto.SHA256, nil
 		case hashSHA1:
 			return sha1Hash(slices), crypto.SHA1, nil
+		case hashSHA384:
+			return hashSlices(sha512.New384(), slices), crypto.SHA384, nil
+		case hashSHA512:
+			return hashSlices(sha512.New(), slices), crypto.SHA512, nil
 		default:
 			return nil, crypto.Hash(0), errors.New("tls: unknown hash function used by peer")
 		}
@@ -378,6 +388,7 @@ func (ka *signedKeyAgreement) verifyParameters(config *Config, clientHello *clie
 	}
 
 	var tls12HashId uint8
+	pss := false
 	if ka.version >= VersionTLS12 {
 		// handle SignatureAndHashAlgorithm
 		var sigAndHash []uint8
@@ -385,14 +396,24 @@ func (ka *signedKeyAgreement) verifyParameters(config *Config, clientHello *clie
 		tls12HashId = sigAndHash[0]
 		ka.sh.hash = tls12HashId
 		ka.sh.signature = sigAndHash[1]
-		if sigAndHash[1] != ka.sigType {
+		offered := signatureAndHash{ka.sigType, tls12HashId}
+		if tls12HashId == hashIntrinsic && ka.sigType == signatureRSA {
+			// RSASSA-PSS names its digest in the second byte
+			pss = true
+			offered = signatureAndHash{sigAndHash[1], hashIntrinsic}
+			tls12HashId = sigAndHash[1]
+		} else if sigAndHash[1] != ka.sigType {
 			return errServerKeyExchange
 		}
 		if len(sig) < 2 {
 			return errServerKeyExchange
 		}
 
-		if !isSupportedSignatureAndHash(signatureAndHash{ka.sigType, tls12HashId}, config.signatureAndHashesForClient()) {
+		supported := clientHello.signatureAndHashes
+		if len(supported) == 0 {
+			supported = config.signatureAndHashesForClient()
+		}
+		if !isSupportedSignatureAndHash(offered, supported) {
 			return errors.New("tls: unsupported hash function for ServerKeyExchange")
 		}
 	}
@@ -430,7 +451,11 @@ func (ka *signedKeyAgreement) verifyParameters(config *Config, clientHello *clie
 		if !ok {
 			return errors.New("ECDHE RSA requires a RSA server public key")
 		}
-		if err := rsa.VerifyPKCS1v15(pubKey, hashFunc, digest, sig); err != nil {
+		if pss {
+			if err := rsa.VerifyPSS(pubKey, hashFunc, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+				return err
+			}
+		} else if err := rsa.VerifyPKCS1v15(pubKey, hashFunc, digest, sig); err != nil {
 			return err
 		}
 	case signatureDSA:
@@ -0,0 +1,119 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Categories of handshake failure, recorded in ServerHandshake.ErrorCategory
+const (
+	HandshakeErrorAlert     = "alert"
+	HandshakeErrorReset     = "reset"
+	HandshakeErrorTimeout   = "timeout"
+	HandshakeErrorEOF       = "eof"
+	HandshakeErrorMalformed = "malformed"
+	HandshakeErrorOther     = "other"
+)
+
+var alertNames = map[alert]string{
+	alertCloseNotify:            "close_notify",
+	alertUnexpectedMessage:      "unexpected_message",
+	alertBadRecordMAC:           "bad_record_mac",
+	alertDecryptionFailed:       "decryption_failed",
+	alertRecordOverflow:         "record_overflow",
+	alertDecompressionFailure:   "decompression_failure",
+	alertHandshakeFailure:       "handshake_failure",
+	alertBadCertificate:         "bad_certificate",
+	alertUnsupportedCertificate: "unsupported_certificate",
+	alertCertificateRevoked:     "certificate_revoked",
+	alertCertificateExpired:     "certificate_expired",
+	alertCertificateUnknown:     "certificate_unknown",
+	alertIllegalParameter:       "illegal_parameter",
+	alertUnknownCA:              "unknown_ca",
+	alertAccessDenied:           "access_denied",
+	alertDecodeError:            "decode_error",
+	alertDecryptError:           "decrypt_error",
+	alertProtocolVersion:        "protocol_version",
+	alertInsufficientSecurity:   "insufficient_security",
+	alertInternalError:          "internal_error",
+	alertUserCanceled:           "user_canceled",
+	alertNoRenegotiation:        "no_renegotiation",
+}
+
+// An Alert is a TLS alert received from the server. Name is the RFC 5246
+// name of the description, or "unknown".
+type Alert struct {
+	Level       uint8  `json:"level"`
+	Description uint8  `json:"description"`
+	Name        string `json:"name"`
+}
+
+func newAlert(level uint8, description alert) *Alert {
+	name, ok := alertNames[description]
+	if !ok {
+		name = "unknown"
+	}
+	return &Alert{
+		Level:       level,
+		Description: uint8(description),
+		Name:        name,
+	}
+}
+
+// RemoteAlert returns the description of the fatal alert the server sent if
+// err is the error the connection returned because of it
+func RemoteAlert(err error) (uint8, bool) {
+	if e, ok := err.(*net.OpError); ok && e.Op == "remote error" {
+		if a, ok := e.Err.(alert); ok {
+			return uint8(a), true
+		}
+	}
+	return 0, false
+}
+
+// Alerts we send when the server's messages cannot be parsed
+var malformedAlerts = map[alert]bool{
+	alertUnexpectedMessage:    true,
+	alertBadRecordMAC:         true,
+	alertRecordOverflow:       true,
+	alertDecompressionFailure: true,
+	alertIllegalParameter:     true,
+	alertDecodeError:          true,
+}
+
+// classifyHandshakeError returns the category of a failed handshake. A
+// fatal alert from the server takes precedence over the error it caused.
+func (c *Conn) classifyHandshakeError(err error) string {
+	if c.receivedAlert != nil {
+		return HandshakeErrorAlert
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return HandshakeErrorEOF
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return HandshakeErrorTimeout
+	}
+	if isConnReset(err) {
+		return HandshakeErrorReset
+	}
+	if c.sentAlert != nil && malformedAlerts[*c.sentAlert] {
+		return HandshakeErrorMalformed
+	}
+	return HandshakeErrorOther
+}
+
+func isConnReset(err error) bool {
+	if e, ok := err.(*net.OpError); ok {
+		err = e.Err
+	}
+	if e, ok := err.(*os.SyscallError); ok {
+		err = e.Err
+	}
+	return err == syscall.ECONNRESET
+}
@@ -0,0 +1,110 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// cannedConn replays a fixed server response, then fails reads with err
+type cannedConn struct {
+	net.Conn
+	response *bytes.Reader
+	err      error
+}
+
+func (c *cannedConn) Read(b []byte) (int, error) {
+	if c.response.Len() == 0 {
+		return 0, c.err
+	}
+	return c.response.Read(b)
+}
+
+func (c *cannedConn) Write(b []byte) (int, error)        { return len(b), nil }
+func (c *cannedConn) Close() error                       { return nil }
+func (c *cannedConn) SetDeadline(t time.Time) error      { return nil }
+func (c *cannedConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *cannedConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func TestHandshakeErrorCategory(t *testing.T) {
+	reset := &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	tests := []struct {
+		name     string
+		response []byte
+		err      error
+		category string
+		alert    *Alert
+	}{
+		{"handshake_failure", []byte{0x15, 0x03, 0x01, 0x00, 0x02, 0x02, 40}, reset, HandshakeErrorAlert, &Alert{2, 40, "handshake_failure"}},
+		{"protocol_version", []byte{0x15, 0x03, 0x00, 0x00, 0x02, 0x02, 70}, reset, HandshakeErrorAlert, &Alert{2, 70, "protocol_version"}},
+		{"unknown alert", []byte{0x15, 0x03, 0x01, 0x00, 0x02, 0x02, 200}, reset, HandshakeErrorAlert, &Alert{2, 200, "unknown"}},
+		{"reset", nil, reset, HandshakeErrorReset, nil},
+		{"timeout", nil, timeoutError{}, HandshakeErrorTimeout, nil},
+		{"eof", []byte{0x16, 0x03, 0x01}, io.EOF, HandshakeErrorEOF, nil},
+		{"malformed", []byte("HTTP/1.1 400 Bad Request\r\n\r\n"), reset, HandshakeErrorMalformed, nil},
+	}
+	for _, test := range tests {
+		conn := &cannedConn{response: bytes.NewReader(test.response), err: test.err}
+		client := Client(conn, &Config{InsecureSkipVerify: true})
+		if err := client.Handshake(); err == nil {
+			t.Errorf("%s: handshake succeeded", test.name)
+			continue
+		}
+		hl := client.GetHandshakeLog()
+		if hl.ErrorCategory != test.category {
+			t.Errorf("%s: wrong category - expected %s, got %s", test.name, test.category, hl.ErrorCategory)
+		}
+		if test.alert == nil && hl.Alert != nil {
+			t.Errorf("%s: unexpected alert %+v", test.name, *hl.Alert)
+		} else if test.alert != nil && (hl.Alert == nil || *hl.Alert != *test.alert) {
+			t.Errorf("%s: wrong alert - expected %+v, got %+v", test.name, *test.alert, hl.Alert)
+		}
+	}
+}
+
+// Falling back to our own protocol when the server's NPN list shares none
+// with ours is not a handshake error
+func TestNPNFallbackIsNotAnError(t *testing.T) {
+	c, s := net.Pipe()
+	go func() {
+		server := Server(s, &Config{
+			Certificates: testConfig.Certificates,
+			NextProtos:   []string{"bar"},
+			MaxVersion:   VersionTLS12,
+		})
+		server.Handshake()
+		s.Close()
+	}()
+	// The server only answers NPN when the client offers no ALPN
+	hello := &clientHelloMsg{
+		vers:               VersionTLS12,
+		random:             make([]byte, 32),
+		cipherSuites:       []uint16{TLS_RSA_WITH_AES_128_CBC_SHA},
+		compressionMethods: []uint8{compressionNone},
+		nextProtoNeg:       true,
+	}
+	client := Client(c, &Config{
+		InsecureSkipVerify:  true,
+		NextProtos:          []string{"foo"},
+		ExternalClientHello: hello.marshal(),
+	})
+	defer client.Close()
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("Handshake: %s", err)
+	}
+	hl := client.GetHandshakeLog()
+	if hl.ErrorCategory != "" || hl.Alert != nil || hl.NegotiatedProtocol != "" {
+		t.Errorf("error_category %q, alert %+v, negotiated_protocol %q", hl.ErrorCategory, hl.Alert, hl.NegotiatedProtocol)
+	}
+	if state := client.ConnectionState(); state.NegotiatedProtocolIsMutual {
+		t.Errorf("Fallback protocol %q reported as mutual", state.NegotiatedProtocol)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"net"
+)
+
+// Raw handshake bytes are capped at this size in each direction
+const maxCapturedFlight = 64 * 1024
+
+// A flightRecorder keeps the bytes of the first flight in each direction:
+// everything written before the first read, then everything read before
+// the next write
+type flightRecorder struct {
+	net.Conn
+	client, server []byte
+	truncated      bool
+	reading, done  bool
+}
+
+func (f *flightRecorder) capture(buf, b []byte) []byte {
+	if room := maxCapturedFlight - len(buf); len(b) > room {
+		b = b[:room]
+		f.truncated = true
+	}
+	return append(buf, b...)
+}
+
+func (f *flightRecorder) Write(b []byte) (int, error) {
+	if f.reading {
+		f.done = true
+	} else {
+		f.client = f.capture(f.client, b)
+	}
+	return f.Conn.Write(b)
+}
+
+func (f *flightRecorder) Read(b []byte) (int, error) {
+	n, err := f.Conn.Read(b)
+	if !f.done {
+		f.reading = true
+		f.server = f.capture(f.server, b[:n])
+	}
+	return n, err
+}
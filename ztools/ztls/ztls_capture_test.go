@@ -0,0 +1,54 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func capturedHandshake(t *testing.T, capture bool) *ServerHandshake {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	go Server(s, testConfig).Handshake()
+
+	client := Client(c, &Config{InsecureSkipVerify: true, MaxVersion: VersionTLS12, CaptureHandshakeBytes: capture})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("Handshake: %s", err)
+	}
+	return client.GetHandshakeLog()
+}
+
+func TestHandshakeCapturesFirstFlights(t *testing.T) {
+	hl := capturedHandshake(t, true)
+	client, server := hl.RawClientFlight, hl.RawServerFlight
+	if len(client) < 6 || recordType(client[0]) != recordTypeHandshake || client[5] != typeClientHello {
+		t.Errorf("Client flight is not a ClientHello record: %x", client)
+	} else if recordLen := int(client[3])<<8 | int(client[4]); len(client) != 5+recordLen {
+		t.Errorf("Client flight has %d bytes, expected only the ClientHello record (%d)", len(client), 5+recordLen)
+	}
+	if len(server) < 6 || recordType(server[0]) != recordTypeHandshake || server[5] != typeServerHello {
+		t.Errorf("Server flight does not start with a ServerHello: %x", server)
+	}
+	if hl.RawFlightTruncated {
+		t.Errorf("Small flights marked truncated")
+	}
+
+	if hl = capturedHandshake(t, false); hl.RawClientFlight != nil || hl.RawServerFlight != nil {
+		t.Errorf("Handshake bytes captured without CaptureHandshakeBytes")
+	}
+}
+
+func TestFlightRecorderCapsCapture(t *testing.T) {
+	f := new(flightRecorder)
+	buf := f.capture(nil, make([]byte, maxCapturedFlight-1))
+	buf = f.capture(buf, []byte{1, 2, 3})
+	if len(buf) != maxCapturedFlight || !f.truncated {
+		t.Errorf("Capture not capped: %d bytes, truncated %t", len(buf), f.truncated)
+	}
+}
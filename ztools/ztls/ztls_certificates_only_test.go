@@ -0,0 +1,56 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCertificatesOnlyAbandonsHandshake(t *testing.T) {
+	// The client stops reading mid-flight, which a net.Pipe cannot buffer
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	serverErr := make(chan error, 1)
+	go func() {
+		s, err := l.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer s.Close()
+		serverErr <- Server(s, testConfig).Handshake()
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	client := Client(c, &Config{InsecureSkipVerify: true, MaxVersion: VersionTLS12, CertificatesOnly: true})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := client.Handshake(); err != ErrCertificatesOnly {
+		t.Fatalf("Expected ErrCertificatesOnly, got %v", err)
+	}
+	hl := client.GetHandshakeLog()
+	if hl.ServerCertificates == nil || !bytes.Equal(hl.ServerCertificates.Certificate.Raw, testRSACertificate) {
+		t.Errorf("Server certificate not recorded")
+	}
+	if !hl.Abandoned || hl.ErrorCategory != "" {
+		t.Errorf("Handshake not marked abandoned: abandoned %t, error category %q", hl.Abandoned, hl.ErrorCategory)
+	}
+	if hl.ClientKeyExchange != nil || hl.ClientFinished != nil {
+		t.Errorf("Key exchange sent on an abandoned handshake")
+	}
+	c.Close()
+	if err := <-serverErr; err == nil {
+		t.Errorf("Server completed an abandoned handshake")
+	}
+}
@@ -0,0 +1,545 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/fingerprint"
+	"gopkg.in/eniac/zgrab.v0/ztools/x509"
+)
+
+// DTLS protocol versions as they appear on the wire
+const (
+	VersionDTLS10 = 0xfeff
+	VersionDTLS12 = 0xfefd
+)
+
+// Retransmission defaults, from RFC 6347 section 4.2.4.1
+const (
+	DefaultDTLSRetransmitTimeout = time.Second
+	DefaultDTLSMaxRetransmits    = 3
+)
+
+const (
+	dtlsHandshakeHeaderLen = 12
+	dtlsMaxDatagramSize    = 65535
+)
+
+// ErrDTLSTimeout is returned when the server does not answer a flight
+// within the allowed retransmissions
+var ErrDTLSTimeout = errors.New("dtls: no response after retransmitting")
+
+// A DTLSLog records the datagram-specific parts of a DTLS handshake. The
+// handshake messages themselves are in the ServerHandshake log.
+type DTLSLog struct {
+	HelloVerifyRequest bool `json:"hello_verify_request"`
+	CookieLength       int  `json:"cookie_length,omitempty"`
+	Retransmits        int  `json:"retransmits"`
+}
+
+// dtlsMessage reassembles a handshake message from its fragments
+type dtlsMessage struct {
+	typ      uint8
+	body     []byte
+	have     []bool
+	received int
+}
+
+func (m *dtlsMessage) add(offset int, fragment []byte) {
+	for i, b := range fragment {
+		if !m.have[offset+i] {
+			m.have[offset+i] = true
+			m.body[offset+i] = b
+			m.received++
+		}
+	}
+}
+
+func (m *dtlsMessage) complete() bool {
+	return m.received == len(m.body)
+}
+
+// marshal returns the message with a TLS handshake header, the form the
+// handshake message types unmarshal
+func (m *dtlsMessage) marshal() []byte {
+	x := make([]byte, 4+len(m.body))
+	x[0] = m.typ
+	x[1] = uint8(len(m.body) >> 16)
+	x[2] = uint8(len(m.body) >> 8)
+	x[3] = uint8(len(m.body))
+	copy(x[4:], m.body)
+	return x
+}
+
+// A DTLSConn runs the client side of a DTLS 1.0 or 1.2 handshake over a
+// datagram connection. The handshake stops once the server's first flight
+// has been read: it records the same ServerHandshake log as a TLS
+// handshake up to ServerHelloDone, but negotiates no keys, so no
+// application data can be exchanged.
+type DTLSConn struct {
+	conn   net.Conn
+	config *Config
+
+	// Time to wait for the server's answer to a flight before sending the
+	// flight again, doubled on every retransmission, and how many times a
+	// flight is sent again before giving up
+	RetransmitTimeout time.Duration
+	MaxRetransmits    int
+
+	deadline     time.Time
+	handshakeLog *ServerHandshake
+	dtlsLog      *DTLSLog
+	handshakeErr error
+	handshaked   bool
+
+	vers          uint16
+	flight        []byte
+	recordSeq     uint64
+	messageSeq    uint16
+	readSeq       int
+	messages      map[uint16]*dtlsMessage
+	receivedAlert *Alert
+}
+
+// DTLSClient returns a DTLS client using conn, which must be a connected
+// datagram socket
+func DTLSClient(conn net.Conn, config *Config) *DTLSConn {
+	return &DTLSConn{
+		conn:              conn,
+		config:            config,
+		RetransmitTimeout: DefaultDTLSRetransmitTimeout,
+		MaxRetransmits:    DefaultDTLSMaxRetransmits,
+	}
+}
+
+// SetDeadline bounds the whole handshake, retransmissions included
+func (c *DTLSConn) SetDeadline(t time.Time) {
+	c.deadline = t
+}
+
+func (c *DTLSConn) GetHandshakeLog() *ServerHandshake {
+	return c.handshakeLog
+}
+
+func (c *DTLSConn) GetDTLSLog() *DTLSLog {
+	return c.dtlsLog
+}
+
+// dtlsToTLSVersion returns the TLS version a DTLS version is derived from,
+// which selects the message formats and key agreement rules
+func dtlsToTLSVersion(vers uint16) (uint16, bool) {
+	switch vers {
+	case VersionDTLS10:
+		return VersionTLS11, true
+	case VersionDTLS12:
+		return VersionTLS12, true
+	}
+	return 0, false
+}
+
+func (c *DTLSConn) makeClientHello() (*clientHelloMsg, error) {
+	if c.config == nil {
+		c.config = defaultConfig()
+	}
+	vers := uint16(VersionDTLS12)
+	if c.config.maxVersion() < VersionTLS12 {
+		vers = VersionDTLS10
+	}
+	hello := &clientHelloMsg{
+		vers:                vers,
+		compressionMethods:  []uint8{compressionNone},
+		random:              make([]byte, 32),
+		serverName:          c.config.ServerName,
+		supportedCurves:     c.config.curvePreferences(),
+		supportedPoints:     []uint8{pointFormatUncompressed},
+		secureRenegotiation: true,
+		alpnProtocols:       c.config.NextProtos,
+	}
+	if vers == VersionDTLS12 {
+		hello.signatureAndHashes = c.config.signatureAndHashesForClient()
+	}
+	for _, id := range c.config.cipherSuites() {
+		for _, suite := range implementedCipherSuites {
+			if suite.id != id || suite.flags&suiteNoDTLS != 0 {
+				continue
+			}
+			if vers == VersionDTLS10 && suite.flags&suiteTLS12 != 0 {
+				continue
+			}
+			hello.cipherSuites = append(hello.cipherSuites, id)
+			break
+		}
+	}
+	if _, err := io.ReadFull(c.config.rand(), hello.random); err != nil {
+		return nil, errors.New("dtls: short read from Rand: " + err.Error())
+	}
+	return hello, nil
+}
+
+// clientHelloWithCookie returns a ClientHello encoded for DTLS, which adds
+// the cookie after the session ID
+func clientHelloWithCookie(hello *clientHelloMsg, cookie []byte) []byte {
+	raw := hello.marshal()
+	pos := 4 + 2 + 32 + 1 + len(hello.sessionId)
+	x := make([]byte, 0, len(raw)+1+len(cookie))
+	x = append(x, raw[:pos]...)
+	x = append(x, uint8(len(cookie)))
+	x = append(x, cookie...)
+	x = append(x, raw[pos:]...)
+	length := len(x) - 4
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	return x
+}
+
+// record frames fragment as an epoch 0 record
+func (c *DTLSConn) record(typ recordType, fragment []byte) []byte {
+	x := make([]byte, dtlsRecordHeaderLen+len(fragment))
+	x[0] = uint8(typ)
+	vers := c.vers
+	if vers == 0 {
+		vers = VersionDTLS10
+	}
+	x[1] = uint8(vers >> 8)
+	x[2] = uint8(vers)
+	for i := 0; i < 6; i++ {
+		x[5+i] = uint8(c.recordSeq >> uint(40-8*i))
+	}
+	c.recordSeq++
+	x[11] = uint8(len(fragment) >> 8)
+	x[12] = uint8(len(fragment))
+	copy(x[dtlsRecordHeaderLen:], fragment)
+	return x
+}
+
+// handshakeRecord converts a message with a TLS handshake header into an
+// unfragmented DTLS handshake message and frames it as a record
+func (c *DTLSConn) handshakeRecord(msg []byte) []byte {
+	x := make([]byte, dtlsHandshakeHeaderLen+len(msg)-4)
+	copy(x[0:4], msg[0:4])
+	x[4] = uint8(c.messageSeq >> 8)
+	x[5] = uint8(c.messageSeq)
+	copy(x[9:12], msg[1:4])
+	copy(x[dtlsHandshakeHeaderLen:], msg[4:])
+	c.messageSeq++
+	return c.record(recordTypeHandshake, x)
+}
+
+// sendFlight sends the flight and keeps it for retransmission
+func (c *DTLSConn) sendFlight(msg []byte) error {
+	c.flight = msg
+	_, err := c.conn.Write(c.handshakeRecord(msg))
+	return err
+}
+
+// retransmit sends the last flight again. Retransmitted records get new
+// record sequence numbers but keep their message sequence numbers.
+func (c *DTLSConn) retransmit() error {
+	c.messageSeq--
+	c.dtlsLog.Retransmits++
+	_, err := c.conn.Write(c.handshakeRecord(c.flight))
+	return err
+}
+
+// handleDatagram files the handshake fragments in a datagram. It returns
+// an error if the server sent a fatal alert.
+func (c *DTLSConn) handleDatagram(b []byte) error {
+	for len(b) >= dtlsRecordHeaderLen {
+		typ := recordType(b[0])
+		epoch := uint16(b[3])<<8 | uint16(b[4])
+		n := int(b[11])<<8 | int(b[12])
+		if len(b) < dtlsRecordHeaderLen+n {
+			return nil
+		}
+		fragment := b[dtlsRecordHeaderLen : dtlsRecordHeaderLen+n]
+		b = b[dtlsRecordHeaderLen+n:]
+		if epoch != 0 {
+			continue
+		}
+		switch typ {
+		case recordTypeAlert:
+			if len(fragment) < 2 {
+				continue
+			}
+			c.receivedAlert = newAlert(fragment[0], alert(fragment[1]))
+			if fragment[0] == alertLevelError {
+				c.handshakeLog.Alert = c.receivedAlert
+				return &net.OpError{Op: "remote error", Err: alert(fragment[1])}
+			}
+		case recordTypeHandshake:
+			c.handleFragments(fragment)
+		}
+	}
+	return nil
+}
+
+func (c *DTLSConn) handleFragments(b []byte) {
+	for len(b) >= dtlsHandshakeHeaderLen {
+		typ := b[0]
+		length := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+		seq := uint16(b[4])<<8 | uint16(b[5])
+		offset := int(b[6])<<16 | int(b[7])<<8 | int(b[8])
+		n := int(b[9])<<16 | int(b[10])<<8 | int(b[11])
+		if len(b) < dtlsHandshakeHeaderLen+n || offset+n > length || length > maxHandshake {
+			return
+		}
+		fragment := b[dtlsHandshakeHeaderLen : dtlsHandshakeHeaderLen+n]
+		b = b[dtlsHandshakeHeaderLen+n:]
+		if int(seq) < c.readSeq {
+			continue
+		}
+		m, ok := c.messages[seq]
+		if !ok {
+			m = &dtlsMessage{typ: typ, body: make([]byte, length), have: make([]bool, length)}
+			c.messages[seq] = m
+		}
+		if m.typ != typ || len(m.body) != length {
+			continue
+		}
+		m.add(offset, fragment)
+	}
+}
+
+// nextMessage returns the next complete handshake message, or nil. The
+// server numbers its messages from 0 or 1 depending on whether it sent a
+// HelloVerifyRequest, so the first message must open a flight.
+func (c *DTLSConn) nextMessage() *dtlsMessage {
+	if c.readSeq < 0 {
+		for seq, m := range c.messages {
+			if m.complete() && (m.typ == typeServerHello || m.typ == typeHelloVerifyRequest) {
+				c.readSeq = int(seq)
+				break
+			}
+		}
+		if c.readSeq < 0 {
+			return nil
+		}
+	}
+	m, ok := c.messages[uint16(c.readSeq)]
+	if !ok || !m.complete() {
+		return nil
+	}
+	delete(c.messages, uint16(c.readSeq))
+	c.readSeq++
+	return m
+}
+
+// readMessage returns the next handshake message, retransmitting the last
+// flight whenever the timer runs out
+func (c *DTLSConn) readMessage() (*dtlsMessage, error) {
+	timeout := c.RetransmitTimeout
+	buf := make([]byte, dtlsMaxDatagramSize)
+	for {
+		if m := c.nextMessage(); m != nil {
+			return m, nil
+		}
+		timer := time.Now().Add(timeout)
+		if !c.deadline.IsZero() && c.deadline.Before(timer) {
+			timer = c.deadline
+		}
+		c.conn.SetReadDeadline(timer)
+		n, err := c.conn.Read(buf)
+		if err == nil {
+			if err = c.handleDatagram(buf[:n]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return nil, err
+		}
+		if !c.deadline.IsZero() && !time.Now().Before(c.deadline) {
+			return nil, err
+		}
+		if c.dtlsLog.Retransmits >= c.MaxRetransmits {
+			return nil, ErrDTLSTimeout
+		}
+		if err := c.retransmit(); err != nil {
+			return nil, err
+		}
+		timeout *= 2
+	}
+}
+
+// Handshake sends a ClientHello, answers a HelloVerifyRequest with the
+// cookie, and reads the server's flight up to ServerHelloDone
+func (c *DTLSConn) Handshake() error {
+	if c.handshaked {
+		return c.handshakeErr
+	}
+	c.handshaked = true
+	c.handshakeErr = c.clientHandshake()
+	if c.handshakeErr != nil && c.handshakeLog != nil && c.handshakeLog.ErrorCategory == "" {
+		c.handshakeLog.ErrorCategory = c.classifyHandshakeError(c.handshakeErr)
+	}
+	return c.handshakeErr
+}
+
+func (c *DTLSConn) classifyHandshakeError(err error) string {
+	if c.receivedAlert != nil && c.receivedAlert.Level == alertLevelError {
+		return HandshakeErrorAlert
+	}
+	if err == ErrDTLSTimeout {
+		return HandshakeErrorTimeout
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return HandshakeErrorTimeout
+	}
+	if isConnReset(err) {
+		return HandshakeErrorReset
+	}
+	return HandshakeErrorOther
+}
+
+func (c *DTLSConn) clientHandshake() error {
+	hello, err := c.makeClientHello()
+	if err != nil {
+		return err
+	}
+	c.handshakeLog = new(ServerHandshake)
+	c.dtlsLog = new(DTLSLog)
+	c.messages = make(map[uint16]*dtlsMessage)
+	c.readSeq = -1
+
+	if err := c.sendFlight(clientHelloWithCookie(hello, nil)); err != nil {
+		return err
+	}
+	c.handshakeLog.ClientHello = hello.MakeLog()
+
+	m, err := c.readMessage()
+	if err != nil {
+		return err
+	}
+	if m.typ == typeHelloVerifyRequest {
+		if len(m.body) < 3 || int(m.body[2]) != len(m.body)-3 {
+			return errors.New("dtls: malformed HelloVerifyRequest")
+		}
+		cookie := m.body[3:]
+		c.dtlsLog.HelloVerifyRequest = true
+		c.dtlsLog.CookieLength = len(cookie)
+		// The server keeps no state until it sees the cookie, so it may
+		// number its next flight either way
+		c.messages = make(map[uint16]*dtlsMessage)
+		c.readSeq = -1
+		if err := c.sendFlight(clientHelloWithCookie(hello, cookie)); err != nil {
+			return err
+		}
+		if m, err = c.readMessage(); err != nil {
+			return err
+		}
+	}
+
+	serverHello := new(serverHelloMsg)
+	if m.typ != typeServerHello || !serverHello.unmarshal(m.marshal()) {
+		c.sendAlert(alertUnexpectedMessage)
+		return fmt.Errorf("dtls: expected ServerHello, got message type %d", m.typ)
+	}
+	c.handshakeLog.ServerHello = serverHello.MakeLog()
+	c.handshakeLog.JA3S = fingerprint.New(JA3S(c.handshakeLog.ServerHello))
+	c.vers = serverHello.vers
+	vers, ok := dtlsToTLSVersion(serverHello.vers)
+	if !ok || vers < c.config.minVersion() || serverHello.vers < hello.vers {
+		c.sendAlert(alertProtocolVersion)
+		return fmt.Errorf("dtls: server selected unsupported protocol version %x", serverHello.vers)
+	}
+	suite := mutualCipherSuite(hello.cipherSuites, serverHello.cipherSuite)
+	if suite == nil {
+		c.sendAlert(alertHandshakeFailure)
+		return ErrNoMutualCipher
+	}
+
+	var serverCert *x509.Certificate
+	var keyAgreement keyAgreement
+	for {
+		if m, err = c.readMessage(); err != nil {
+			return err
+		}
+		switch m.typ {
+		case typeCertificate:
+			certMsg := new(certificateMsg)
+			if !certMsg.unmarshal(m.marshal()) || len(certMsg.certificates) == 0 {
+				c.sendAlert(alertDecodeError)
+				return errors.New("dtls: malformed Certificate")
+			}
+			if serverCert, err = c.logCertificates(certMsg); err != nil {
+				c.sendAlert(alertBadCertificate)
+				return err
+			}
+		case typeCertificateStatus:
+		case typeServerKeyExchange:
+			skx := new(serverKeyExchangeMsg)
+			if !skx.unmarshal(m.marshal()) {
+				c.sendAlert(alertDecodeError)
+				return errors.New("dtls: malformed ServerKeyExchange")
+			}
+			keyAgreement = suite.ka(vers)
+			err = keyAgreement.processServerKeyExchange(c.config, hello, serverHello, serverCert, skx)
+			c.handshakeLog.ServerKeyExchange = skx.MakeLog(keyAgreement)
+			if err != nil {
+				c.sendAlert(alertUnexpectedMessage)
+				return err
+			}
+		case typeCertificateRequest:
+			certReq := &certificateRequestMsg{hasSignatureAndHash: vers >= VersionTLS12}
+			if !certReq.unmarshal(m.marshal()) {
+				c.sendAlert(alertDecodeError)
+				return errors.New("dtls: malformed CertificateRequest")
+			}
+			c.handshakeLog.CertificateRequest = certReq.MakeLog()
+		case typeServerHelloDone:
+			// Nothing more is learned without deriving keys, so end the
+			// session rather than leave the server waiting
+			c.sendAlert(alertUserCanceled)
+			return nil
+		default:
+			c.sendAlert(alertUnexpectedMessage)
+			return fmt.Errorf("dtls: unexpected handshake message type %d", m.typ)
+		}
+	}
+}
+
+// logCertificates records the server's certificates and their validation,
+// as the TLS handshake does, and returns the leaf
+func (c *DTLSConn) logCertificates(certMsg *certificateMsg) (*x509.Certificate, error) {
+	certs, parseErrs := parseCertificates(certMsg.certificates)
+	c.handshakeLog.ServerCertificates = certMsg.MakeLog()
+	for _, parseErr := range parseErrs {
+		if parseErr != nil {
+			c.handshakeLog.ServerCertificates.addParsed(certs, nil)
+			c.handshakeLog.ServerCertificates.addParseErrors(parseErrs)
+			return nil, errors.New("dtls: failed to parse certificate from server: " + parseErr.Error())
+		}
+	}
+	opts := x509.VerifyOptions{
+		Roots:         c.config.RootCAs,
+		CurrentTime:   c.config.time(),
+		DNSName:       c.config.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, validation, err := certs[0].ValidateWithStupidDetail(opts)
+	c.handshakeLog.ServerCertificates.addParsed(certs, validation)
+	if err != nil && !c.config.InsecureSkipVerify {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+// sendAlert sends a warning for user_canceled and a fatal alert otherwise
+func (c *DTLSConn) sendAlert(err alert) {
+	level := uint8(alertLevelError)
+	if err == alertUserCanceled {
+		level = alertLevelWarning
+	}
+	c.conn.Write(c.record(recordTypeAlert, []byte{level, uint8(err)}))
+}
@@ -0,0 +1,160 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDTLSServer answers ClientHellos on a UDP socket. It demands a cookie,
+// ignores the first ClientHello carrying it to force a retransmission and
+// then sends its flight with the Certificate split across two datagrams.
+type fakeDTLSServer struct {
+	conn      *net.UDPConn
+	cookie    []byte
+	hellos    [][]byte
+	recordSeq uint64
+}
+
+func (s *fakeDTLSServer) record(typ recordType, fragment []byte) []byte {
+	srv := &DTLSConn{vers: VersionDTLS12, recordSeq: s.recordSeq}
+	s.recordSeq++
+	return srv.record(typ, fragment)
+}
+
+// fragment encodes part of a handshake message with a TLS header
+func (s *fakeDTLSServer) fragment(msg []byte, seq uint16, offset, n int) []byte {
+	body := msg[4:]
+	x := make([]byte, dtlsHandshakeHeaderLen+n)
+	copy(x[0:4], msg[0:4])
+	x[4], x[5] = uint8(seq>>8), uint8(seq)
+	x[6], x[7], x[8] = uint8(offset>>16), uint8(offset>>8), uint8(offset)
+	x[9], x[10], x[11] = uint8(n>>16), uint8(n>>8), uint8(n)
+	copy(x[dtlsHandshakeHeaderLen:], body[offset:offset+n])
+	return s.record(recordTypeHandshake, x)
+}
+
+func (s *fakeDTLSServer) whole(msg []byte, seq uint16) []byte {
+	return s.fragment(msg, seq, 0, len(msg)-4)
+}
+
+func (s *fakeDTLSServer) serve() {
+	buf := make([]byte, dtlsMaxDatagramSize)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		b := buf[:n]
+		if len(b) < dtlsRecordHeaderLen+dtlsHandshakeHeaderLen || recordType(b[0]) != recordTypeHandshake {
+			continue
+		}
+		body := b[dtlsRecordHeaderLen+dtlsHandshakeHeaderLen:]
+		s.hellos = append(s.hellos, append([]byte(nil), body...))
+		sidLen := int(body[34])
+		cookie := body[35+sidLen+1 : 35+sidLen+1+int(body[35+sidLen])]
+		if !bytes.Equal(cookie, s.cookie) {
+			hvr := []byte{typeHelloVerifyRequest, 0, 0, byte(3 + len(s.cookie)), 0xfe, 0xff, byte(len(s.cookie))}
+			hvr = append(hvr, s.cookie...)
+			s.conn.WriteToUDP(s.whole(hvr, 0), addr)
+			continue
+		}
+		if len(s.hellos) == 2 {
+			continue
+		}
+		hello := &serverHelloMsg{
+			vers:        VersionDTLS12,
+			random:      make([]byte, 32),
+			cipherSuite: TLS_RSA_WITH_AES_128_CBC_SHA,
+		}
+		cert := (&certificateMsg{certificates: [][]byte{testRSACertificate}}).marshal()
+		half := (len(cert) - 4) / 2
+		first := append(s.whole(hello.marshal(), 1), s.fragment(cert, 2, 0, half)...)
+		s.conn.WriteToUDP(first, addr)
+		second := append(s.fragment(cert, 2, half, len(cert)-4-half), s.whole([]byte{typeServerHelloDone, 0, 0, 0}, 3)...)
+		s.conn.WriteToUDP(second, addr)
+	}
+}
+
+func dialFakeDTLSServer(t *testing.T) (*fakeDTLSServer, net.Conn) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeDTLSServer{conn: l, cookie: []byte("0123456789abcdef")}
+	c, err := net.Dial("udp", l.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, c
+}
+
+func TestDTLSHandshakeWithCookieAndLoss(t *testing.T) {
+	s, conn := dialFakeDTLSServer(t)
+	defer s.conn.Close()
+	defer conn.Close()
+	go s.serve()
+
+	c := DTLSClient(conn, &Config{InsecureSkipVerify: true})
+	c.RetransmitTimeout = 50 * time.Millisecond
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := c.Handshake(); err != nil {
+		t.Fatalf("Handshake: %s", err.Error())
+	}
+	dl := c.GetDTLSLog()
+	if !dl.HelloVerifyRequest || dl.CookieLength != len(s.cookie) || dl.Retransmits != 1 {
+		t.Errorf("Wrong DTLS log: %+v", dl)
+	}
+	hl := c.GetHandshakeLog()
+	if hl.ServerHello == nil || hl.ServerHello.Version.String() != "DTLSv1.2" {
+		t.Errorf("Wrong server hello: %+v", hl.ServerHello)
+	}
+	if hl.JA3S == nil || !strings.HasPrefix(hl.JA3S.Raw, "65277,") {
+		t.Errorf("Wrong JA3S: %+v", hl.JA3S)
+	}
+	if hl.ServerCertificates == nil || !bytes.Equal(hl.ServerCertificates.Certificate.Raw, testRSACertificate) {
+		t.Errorf("Reassembled certificate not recorded")
+	}
+	if len(s.hellos) != 3 || !bytes.Equal(s.hellos[1], s.hellos[2]) {
+		t.Errorf("Retransmitted ClientHello differs from the original")
+	}
+}
+
+func TestDTLSHandshakeGivesUp(t *testing.T) {
+	s, conn := dialFakeDTLSServer(t)
+	defer s.conn.Close()
+	defer conn.Close()
+
+	c := DTLSClient(conn, &Config{InsecureSkipVerify: true})
+	c.RetransmitTimeout = 10 * time.Millisecond
+	c.MaxRetransmits = 2
+	if err := c.Handshake(); err != ErrDTLSTimeout {
+		t.Fatalf("Expected ErrDTLSTimeout, got %v", err)
+	}
+	if c.GetDTLSLog().Retransmits != 2 || c.GetHandshakeLog().ErrorCategory != HandshakeErrorTimeout {
+		t.Errorf("Wrong logs: %+v, %q", c.GetDTLSLog(), c.GetHandshakeLog().ErrorCategory)
+	}
+}
+
+func TestClientHelloWithCookie(t *testing.T) {
+	hello := &clientHelloMsg{
+		vers:               VersionDTLS12,
+		random:             make([]byte, 32),
+		sessionId:          []byte{1, 2},
+		cipherSuites:       []uint16{TLS_RSA_WITH_AES_128_CBC_SHA},
+		compressionMethods: []uint8{compressionNone},
+	}
+	raw := clientHelloWithCookie(hello, []byte{0xaa, 0xbb, 0xcc})
+	if length := int(raw[1])<<16 | int(raw[2])<<8 | int(raw[3]); length != len(raw)-4 {
+		t.Errorf("Wrong length %d for %d byte body", length, len(raw)-4)
+	}
+	if !bytes.Equal(raw[38:44], []byte{2, 1, 2, 3, 0xaa, 0xbb}) {
+		t.Errorf("Cookie not after session ID: %x", raw[38:44])
+	}
+}
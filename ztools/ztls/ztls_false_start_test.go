@@ -0,0 +1,119 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// abortingServerConn replaces the server's ChangeCipherSpec, and everything
+// after it, with a fatal handshake_failure alert
+type abortingServerConn struct {
+	net.Conn
+}
+
+func (a *abortingServerConn) Write(b []byte) (int, error) {
+	if len(b) > 0 && recordType(b[0]) == recordTypeChangeCipherSpec {
+		a.Conn.Write([]byte{byte(recordTypeAlert), 3, 3, 0, 2, byte(alertLevelError), byte(alertHandshakeFailure)})
+		a.Conn.Close()
+		return 0, io.ErrClosedPipe
+	}
+	return a.Conn.Write(b)
+}
+
+// falseStartPair connects a False Start client to a server running serve on
+// the accepted connection. Loopback TCP buffers the client's early data.
+func falseStartPair(t *testing.T, suite uint16, serve func(net.Conn)) *Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer l.Close()
+		s, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer s.Close()
+		serve(s)
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := Client(c, &Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         VersionTLS12,
+		CipherSuites:       []uint16{suite},
+		FalseStart:         true,
+	})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client
+}
+
+func TestFalseStart(t *testing.T) {
+	client := falseStartPair(t, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, func(s net.Conn) {
+		server := Server(s, testConfig)
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(server, buf); err == nil && string(buf) == "ping" {
+			server.Write([]byte("pong"))
+		}
+	})
+	defer client.Close()
+
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("Handshake: %s", err)
+	}
+	hl := client.GetHandshakeLog()
+	if !hl.FalseStart || hl.ServerFinished != nil {
+		t.Fatalf("Handshake not false started: false_start %t, server finished %v", hl.FalseStart, hl.ServerFinished)
+	}
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("Read %q: %v", buf, err)
+	}
+	if hl.ServerFinished == nil || hl.FalseStartAborted {
+		t.Errorf("Server Finished not read after False Start: aborted %t", hl.FalseStartAborted)
+	}
+}
+
+func TestFalseStartAborted(t *testing.T) {
+	client := falseStartPair(t, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, func(s net.Conn) {
+		Server(&abortingServerConn{Conn: s}, testConfig).Handshake()
+	})
+	defer client.Close()
+
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("Handshake: %s", err)
+	}
+	client.Write([]byte("ping"))
+	if _, err := client.Read(make([]byte, 4)); err == nil {
+		t.Fatalf("Read succeeded after the server aborted")
+	}
+	hl := client.GetHandshakeLog()
+	if !hl.FalseStart || !hl.FalseStartAborted || hl.Alert == nil || hl.Alert.Description != uint8(alertHandshakeFailure) {
+		t.Errorf("Abort not recorded: false_start %t, aborted %t, alert %+v", hl.FalseStart, hl.FalseStartAborted, hl.Alert)
+	}
+}
+
+func TestFalseStartNeedsAEAD(t *testing.T) {
+	client := falseStartPair(t, TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, func(s net.Conn) {
+		Server(s, testConfig).Handshake()
+	})
+	defer client.Close()
+
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("Handshake: %s", err)
+	}
+	if hl := client.GetHandshakeLog(); hl.FalseStart || hl.ServerFinished == nil {
+		t.Errorf("False Start used with a CBC suite")
+	}
+}
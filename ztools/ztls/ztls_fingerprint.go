@@ -0,0 +1,123 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errMalformedClientHello = errors.New("tls: malformed ClientHello")
+
+// JA3 returns the JA3 fingerprint string of a marshalled ClientHello
+// handshake message: the legacy version, cipher suites, extensions, groups
+// and point formats in decimal, with GREASE values left out. See
+// https://github.com/salesforce/ja3.
+func JA3(clientHello []byte) (string, error) {
+	if len(clientHello) < 4+2+32+1 || clientHello[0] != typeClientHello {
+		return "", errMalformedClientHello
+	}
+	data := clientHello[4:]
+	vers := uint16(data[0])<<8 | uint16(data[1])
+	data = data[2+32:]
+
+	sessionIDLen := int(data[0])
+	if len(data) < 1+sessionIDLen+2 {
+		return "", errMalformedClientHello
+	}
+	data = data[1+sessionIDLen:]
+	suitesLen := int(data[0])<<8 | int(data[1])
+	if suitesLen%2 != 0 || len(data) < 2+suitesLen+1 {
+		return "", errMalformedClientHello
+	}
+	suites := readUint16s(data[2 : 2+suitesLen])
+	data = data[2+suitesLen:]
+	compressionLen := int(data[0])
+	if len(data) < 1+compressionLen {
+		return "", errMalformedClientHello
+	}
+	data = data[1+compressionLen:]
+
+	var extensions, curves []uint16
+	var points []uint8
+	if len(data) >= 2 {
+		extensionsLen := int(data[0])<<8 | int(data[1])
+		data = data[2:]
+		if len(data) != extensionsLen {
+			return "", errMalformedClientHello
+		}
+	}
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return "", errMalformedClientHello
+		}
+		extension := uint16(data[0])<<8 | uint16(data[1])
+		length := int(data[2])<<8 | int(data[3])
+		if len(data) < 4+length {
+			return "", errMalformedClientHello
+		}
+		d := data[4 : 4+length]
+		data = data[4+length:]
+		extensions = append(extensions, extension)
+
+		switch extension {
+		case extensionSupportedCurves:
+			if len(d) < 2 || int(d[0])<<8|int(d[1]) != len(d)-2 || len(d)%2 != 0 {
+				return "", errMalformedClientHello
+			}
+			curves = readUint16s(d[2:])
+		case extensionSupportedPoints:
+			if len(d) < 1 || int(d[0]) != len(d)-1 {
+				return "", errMalformedClientHello
+			}
+			points = d[1:]
+		}
+	}
+
+	pointValues := make([]uint16, len(points))
+	for i, point := range points {
+		pointValues[i] = uint16(point)
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(vers)),
+		joinFingerprintValues(suites),
+		joinFingerprintValues(extensions),
+		joinFingerprintValues(curves),
+		joinFingerprintValues(pointValues),
+	}, ","), nil
+}
+
+// JA3S returns the JA3S fingerprint string of a logged ServerHello: the
+// legacy version, the cipher suite and the extensions in the order the server
+// sent them, in decimal. ServerHello logs recorded without their extensions
+// yield a fingerprint with the extensions left empty.
+func JA3S(serverHello *ServerHello) string {
+	return strings.Join([]string{
+		strconv.Itoa(int(serverHello.Version)),
+		strconv.Itoa(int(serverHello.CipherSuite)),
+		joinFingerprintValues(serverHello.Extensions),
+	}, ",")
+}
+
+func readUint16s(data []byte) []uint16 {
+	values := make([]uint16, 0, len(data)/2)
+	for ; len(data) >= 2; data = data[2:] {
+		values = append(values, uint16(data[0])<<8|uint16(data[1]))
+	}
+	return values
+}
+
+// joinFingerprintValues writes values in decimal separated by dashes,
+// skipping GREASE values
+func joinFingerprintValues(values []uint16) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			parts = append(parts, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(parts, "-")
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	stdtls "crypto/tls"
+	"testing"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/fingerprint"
+)
+
+func TestJA3S(t *testing.T) {
+	m := &serverHelloMsg{
+		vers:                VersionTLS12,
+		random:              make([]byte, 32),
+		cipherSuite:         TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		secureRenegotiation: true,
+		ticketSupported:     true,
+		alpnProtocol:        "h2",
+	}
+	var parsed serverHelloMsg
+	if !parsed.unmarshal(m.marshal()) {
+		t.Fatal("Failed to unmarshal ServerHello")
+	}
+	fp := fingerprint.New(JA3S(parsed.MakeLog()))
+	if expected := "771,49199,35-65281-16"; fp.Raw != expected {
+		t.Errorf("JA3S = %s, expected %s", fp.Raw, expected)
+	}
+	if expected := "2b57f9dbfe8cbbe32fb9886c41c7bb20"; fp.MD5 != expected {
+		t.Errorf("JA3S digest = %s, expected %s", fp.MD5, expected)
+	}
+
+	// A log without its extensions still fingerprints
+	if ja3s := JA3S(&ServerHello{Version: VersionTLS10, CipherSuite: TLS_RSA_WITH_AES_128_CBC_SHA}); ja3s != "769,47," {
+		t.Errorf("JA3S without extensions = %s", ja3s)
+	}
+}
+
+// The handshake log carries the JA3S of the ServerHello the handshake
+// continued with, after any HelloRetryRequest
+func TestJA3SHandshakeLog(t *testing.T) {
+	client := stdTLSPair(t, &Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         VersionTLS13,
+	}, &stdtls.Config{
+		Certificates:     []stdtls.Certificate{testECDSAStdCertificate(t)},
+		MinVersion:       stdtls.VersionTLS13,
+		CurvePreferences: []stdtls.CurveID{stdtls.CurveP384},
+	})
+	defer client.Close()
+
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("Handshake: %s", err)
+	}
+	hl := client.GetHandshakeLog()
+	if hl.HelloRetryRequest == nil || hl.JA3S == nil {
+		t.Fatalf("hello_retry_request = %+v, ja3s = %+v", hl.HelloRetryRequest, hl.JA3S)
+	}
+	if expected := JA3S(hl.ServerHello); hl.JA3S.Raw != expected || len(hl.ServerHello.Extensions) == 0 {
+		t.Errorf("ja3s = %s, expected %s", hl.JA3S.Raw, expected)
+	}
+}
@@ -6,28 +6,51 @@ package ztls
 
 import (
 	"bytes"
+	"encoding/asn1"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
 
+	"gopkg.in/eniac/zgrab.v0/ztools/fingerprint"
 	"gopkg.in/eniac/zgrab.v0/ztools/keys"
 	"gopkg.in/eniac/zgrab.v0/ztools/x509"
+	"gopkg.in/eniac/zgrab.v0/ztools/x509/pkix"
 	"gopkg.in/eniac/zgrab.v0/ztools/zct"
 )
 
 var ErrUnimplementedCipher error = errors.New("unimplemented cipher suite")
 var ErrNoMutualCipher error = errors.New("no mutual cipher suite")
 
+// ErrCertificatesOnly is returned by the handshake of a client configured
+// with CertificatesOnly once the server's certificates have been recorded
+var ErrCertificatesOnly error = errors.New("tls: handshake abandoned after the server certificates")
+
 type TLSVersion uint16
 
 type CipherSuite uint16
 
 type ClientHello struct {
-	Random         []byte `json:"random"`
-	ExtendedRandom []byte `json:"extended_random,omitempty"`
-	SessionID      []byte `json:"session_id,omitempty"`
+	Random            []byte                   `json:"random,omitempty"`
+	ExtendedRandom    []byte                   `json:"extended_random,omitempty"`
+	SessionID         []byte                   `json:"session_id,omitempty"`
+	ServerName        string                   `json:"server_name,omitempty"`
+	CipherSuites      []CipherSuite            `json:"cipher_suites,omitempty"`
+	ALPNProtocols     []string                 `json:"alpn_protocols,omitempty"`
+	Heartbeat         bool                     `json:"heartbeat"`
+	HeartbeatMode     string                   `json:"heartbeat_mode,omitempty"`
+	SupportedVersions []TLSVersion             `json:"supported_versions,omitempty"`
+	KeyShares         []KeyShare               `json:"key_shares,omitempty"`
+	Profile           string                   `json:"profile,omitempty"`
+	JA3               *fingerprint.Fingerprint `json:"ja3,omitempty"`
+}
+
+// A KeyShare is a TLS 1.3 key_share entry: a group and the ECDHE public value
+// offered or selected for it
+type KeyShare struct {
+	Group keys.TLSCurveID `json:"group"`
+	Key   []byte          `json:"key,omitempty"`
 }
 
 type ParsedAndRawSCT struct {
@@ -48,12 +71,38 @@ type ServerHello struct {
 	ExtendedRandom              []byte            `json:"extended_random,omitempty"`
 	ExtendedMasterSecret        bool              `json:"extended_master_secret"`
 	SignedCertificateTimestamps []ParsedAndRawSCT `json:"scts,omitempty"`
+	ALPNProtocol                string            `json:"alpn_protocol,omitempty"`
+	NextProtoNeg                bool              `json:"next_protocol_negotiation"`
+	NextProtocols               []string          `json:"next_protocols,omitempty"`
+	HeartbeatMode               string            `json:"heartbeat_mode,omitempty"`
+	SupportedVersion            *TLSVersion       `json:"supported_version,omitempty"`
+	KeyShare                    *KeyShare         `json:"key_share,omitempty"`
+	Extensions                  []uint16          `json:"extensions,omitempty"`
+}
+
+// A HelloRetryRequest records a TLS 1.3 server asking for a second
+// ClientHello, with the group it wants a key_share for and any cookie to echo
+type HelloRetryRequest struct {
+	CipherSuite   CipherSuite      `json:"cipher_suite"`
+	SelectedGroup *keys.TLSCurveID `json:"selected_group,omitempty"`
+	Cookie        []byte           `json:"cookie,omitempty"`
+}
+
+// EncryptedExts records the extensions a TLS 1.3 server sent encrypted after
+// its ServerHello. Extensions lists every extension type seen.
+type EncryptedExts struct {
+	ALPNProtocol    string            `json:"alpn_protocol,omitempty"`
+	ServerNameAck   bool              `json:"server_name_ack,omitempty"`
+	SupportedGroups []keys.TLSCurveID `json:"supported_groups,omitempty"`
+	Extensions      []uint16          `json:"extensions,omitempty"`
 }
 
-// SimpleCertificate holds a *x509.Certificate and a []byte for the certificate
+// SimpleCertificate holds a *x509.Certificate and a []byte for the certificate.
+// ParseError is set instead of Parsed when the certificate is malformed.
 type SimpleCertificate struct {
-	Raw    []byte            `json:"raw,omitempty"`
-	Parsed *x509.Certificate `json:"parsed,omitempty"`
+	Raw        []byte            `json:"raw,omitempty"`
+	Parsed     *x509.Certificate `json:"parsed,omitempty"`
+	ParseError string            `json:"parse_error,omitempty"`
 }
 
 // Certificates represents a TLS certificates message in a format friendly to the golang JSON library.
@@ -115,15 +164,30 @@ type KeyMaterial struct {
 // ServerHandshake stores all of the messages sent by the server during a standard TLS Handshake.
 // It implements zgrab.EventData interface
 type ServerHandshake struct {
-	ClientHello        *ClientHello       `json:"client_hello,omitempty"`
-	ServerHello        *ServerHello       `json:"server_hello,omitempty"`
-	ServerCertificates *Certificates      `json:"server_certificates,omitempty"`
-	ServerKeyExchange  *ServerKeyExchange `json:"server_key_exchange,omitempty"`
-	ClientKeyExchange  *ClientKeyExchange `json:"client_key_exchange,omitempty"`
-	ClientFinished     *Finished          `json:"client_finished,omitempty"`
-	SessionTicket      *SessionTicket     `json:"session_ticket,omitempty"`
-	ServerFinished     *Finished          `json:"server_finished,omitempty"`
-	KeyMaterial        *KeyMaterial       `json:"key_material,omitempty"`
+	ClientHello        *ClientHello             `json:"client_hello,omitempty"`
+	HelloRetryRequest  *HelloRetryRequest       `json:"hello_retry_request,omitempty"`
+	ServerHello        *ServerHello             `json:"server_hello,omitempty"`
+	EncryptedExts      *EncryptedExts           `json:"encrypted_extensions,omitempty"`
+	ServerCertificates *Certificates            `json:"server_certificates,omitempty"`
+	OCSPStaple         *OCSPStaple              `json:"ocsp_staple,omitempty"`
+	SCTs               []*SCT                   `json:"signed_certificate_timestamps,omitempty"`
+	RawClientFlight    []byte                   `json:"raw_client_flight,omitempty"`
+	RawServerFlight    []byte                   `json:"raw_server_flight,omitempty"`
+	RawFlightTruncated bool                     `json:"raw_flight_truncated,omitempty"`
+	ServerKeyExchange  *ServerKeyExchange       `json:"server_key_exchange,omitempty"`
+	CertificateRequest *CertificateRequest      `json:"certificate_request,omitempty"`
+	ClientKeyExchange  *ClientKeyExchange       `json:"client_key_exchange,omitempty"`
+	ClientFinished     *Finished                `json:"client_finished,omitempty"`
+	SessionTicket      *SessionTicket           `json:"session_ticket,omitempty"`
+	ServerFinished     *Finished                `json:"server_finished,omitempty"`
+	KeyMaterial        *KeyMaterial             `json:"key_material,omitempty"`
+	NegotiatedProtocol string                   `json:"negotiated_protocol,omitempty"`
+	ErrorCategory      string                   `json:"error_category,omitempty"`
+	Alert              *Alert                   `json:"alert,omitempty"`
+	Abandoned          bool                     `json:"abandoned,omitempty"`
+	FalseStart         bool                     `json:"false_start,omitempty"`
+	FalseStartAborted  bool                     `json:"false_start_aborted,omitempty"`
+	JA3S               *fingerprint.Fingerprint `json:"ja3s,omitempty"`
 }
 
 // MarshalJSON implements the json.Marshler interface
@@ -209,6 +273,17 @@ func (c *Conn) OutSeq() []byte {
 	return c.out.seq[:]
 }
 
+// heartbeatModeName returns the RFC 6520 name of a HeartbeatMode
+func heartbeatModeName(mode uint8) string {
+	switch mode {
+	case heartbeatModePeerAllowed:
+		return "peer_allowed_to_send"
+	case heartbeatModePeerNotAllowed:
+		return "peer_not_allowed_to_send"
+	}
+	return "unknown"
+}
+
 func (m *clientHelloMsg) MakeLog() *ClientHello {
 	ch := new(ClientHello)
 	ch.Random = make([]byte, len(m.random))
@@ -219,9 +294,31 @@ func (m *clientHelloMsg) MakeLog() *ClientHello {
 		ch.ExtendedRandom = make([]byte, len(m.extendedRandom))
 		copy(ch.ExtendedRandom, m.extendedRandom)
 	}
+	ch.ServerName = m.serverName
+	ch.CipherSuites = make([]CipherSuite, len(m.cipherSuites))
+	for idx, suite := range m.cipherSuites {
+		ch.CipherSuites[idx] = CipherSuite(suite)
+	}
+	ch.ALPNProtocols = m.alpnProtocols
+	ch.Heartbeat = m.heartbeatEnabled
+	if m.heartbeatEnabled {
+		ch.HeartbeatMode = heartbeatModeName(m.heartbeatMode)
+	}
+	for _, vers := range m.supportedVersions {
+		ch.SupportedVersions = append(ch.SupportedVersions, TLSVersion(vers))
+	}
+	for _, share := range m.keyShares {
+		ch.KeyShares = append(ch.KeyShares, share.MakeLog())
+	}
 	return ch
 }
 
+func (s keyShare) MakeLog() KeyShare {
+	key := make([]byte, len(s.data))
+	copy(key, s.data)
+	return KeyShare{Group: keys.TLSCurveID(s.group), Key: key}
+}
+
 func (m *serverHelloMsg) MakeLog() *ServerHello {
 	sh := new(ServerHello)
 	sh.Version = TLSVersion(m.vers)
@@ -252,9 +349,97 @@ func (m *serverHelloMsg) MakeLog() *ServerHello {
 		}
 	}
 	sh.ExtendedMasterSecret = m.extendedMasterSecret
+	sh.ALPNProtocol = m.alpnProtocol
+	sh.NextProtoNeg = m.nextProtoNeg
+	sh.NextProtocols = m.nextProtos
+	if m.heartbeatEnabled {
+		sh.HeartbeatMode = heartbeatModeName(m.heartbeatMode)
+	}
+	if m.supportedVersion != 0 {
+		vers := TLSVersion(m.supportedVersion)
+		sh.SupportedVersion = &vers
+	}
+	if m.serverShare.group != 0 {
+		share := m.serverShare.MakeLog()
+		sh.KeyShare = &share
+	}
+	if len(m.extensions) > 0 {
+		sh.Extensions = make([]uint16, len(m.extensions))
+		copy(sh.Extensions, m.extensions)
+	}
 	return sh
 }
 
+// MakeHelloRetryRequestLog records a ServerHello carrying the
+// HelloRetryRequest random
+func (m *serverHelloMsg) MakeHelloRetryRequestLog() *HelloRetryRequest {
+	hrr := &HelloRetryRequest{CipherSuite: CipherSuite(m.cipherSuite)}
+	if m.selectedGroup != 0 {
+		group := keys.TLSCurveID(m.selectedGroup)
+		hrr.SelectedGroup = &group
+	}
+	if len(m.cookie) > 0 {
+		hrr.Cookie = make([]byte, len(m.cookie))
+		copy(hrr.Cookie, m.cookie)
+	}
+	return hrr
+}
+
+func (m *encryptedExtensionsMsg) MakeLog() *EncryptedExts {
+	ee := &EncryptedExts{
+		ALPNProtocol:  m.alpnProtocol,
+		ServerNameAck: m.serverNameAck,
+		Extensions:    m.extensions,
+	}
+	for _, group := range m.supportedGroups {
+		ee.SupportedGroups = append(ee.SupportedGroups, keys.TLSCurveID(group))
+	}
+	return ee
+}
+
+// A CertificateRequest records the server asking for a client certificate:
+// the certificate types and CA distinguished names it accepts, whether a
+// configured certificate matched and was sent, and whether the handshake
+// then completed. An empty CA list means the server accepts any CA.
+type CertificateRequest struct {
+	CertificateTypes          []string `json:"certificate_types,omitempty"`
+	CertificateAuthorities    []string `json:"certificate_authorities,omitempty"`
+	ClientCertificateSent     bool     `json:"client_certificate_sent"`
+	ClientCertificateAccepted bool     `json:"client_certificate_accepted"`
+}
+
+var certificateTypeNames = map[byte]string{
+	certTypeRSASign:        "rsa_sign",
+	certTypeDSSSign:        "dss_sign",
+	certTypeRSAFixedDH:     "rsa_fixed_dh",
+	certTypeDSSFixedDH:     "dss_fixed_dh",
+	certTypeECDSASign:      "ecdsa_sign",
+	certTypeRSAFixedECDH:   "rsa_fixed_ecdh",
+	certTypeECDSAFixedECDH: "ecdsa_fixed_ecdh",
+}
+
+func (m *certificateRequestMsg) MakeLog() *CertificateRequest {
+	cr := new(CertificateRequest)
+	for _, t := range m.certificateTypes {
+		name, ok := certificateTypeNames[t]
+		if !ok {
+			name = fmt.Sprintf("unknown(%d)", t)
+		}
+		cr.CertificateTypes = append(cr.CertificateTypes, name)
+	}
+	for _, raw := range m.certificateAuthorities {
+		var rdns pkix.RDNSequence
+		if rest, err := asn1.Unmarshal(raw, &rdns); err != nil || len(rest) > 0 {
+			cr.CertificateAuthorities = append(cr.CertificateAuthorities, hex.EncodeToString(raw))
+			continue
+		}
+		var name pkix.Name
+		name.FillFromRDNSequence(&rdns)
+		cr.CertificateAuthorities = append(cr.CertificateAuthorities, name.String())
+	}
+	return cr
+}
+
 func (m *certificateMsg) MakeLog() *Certificates {
 	sc := new(Certificates)
 	if len(m.certificates) >= 1 {
@@ -288,6 +473,37 @@ func (c *Certificates) addParsed(certs []*x509.Certificate, validation *x509.Val
 	c.Validation = validation
 }
 
+// addParseErrors records the error for each certificate that failed to
+// parse. It assumes the chain slice has already been allocated.
+func (c *Certificates) addParseErrors(errs []error) {
+	for idx, err := range errs {
+		if err == nil {
+			continue
+		}
+		if idx == 0 {
+			c.Certificate.ParseError = err.Error()
+		} else {
+			c.Chain[idx-1].ParseError = err.Error()
+		}
+	}
+}
+
+// parseCertificates parses every certificate in a Certificate message, so
+// that one malformed certificate does not hide the rest of the chain. certs[i]
+// is nil whenever errs[i] is set.
+func parseCertificates(raw [][]byte) (certs []*x509.Certificate, errs []error) {
+	certs = make([]*x509.Certificate, len(raw))
+	errs = make([]error, len(raw))
+	for i, asn1Data := range raw {
+		if cert, err := x509.ParseCertificate(asn1Data); err != nil {
+			errs[i] = err
+		} else {
+			certs[i] = cert
+		}
+	}
+	return certs, errs
+}
+
 func (m *serverKeyExchangeMsg) MakeLog(ka keyAgreement) *ServerKeyExchange {
 	skx := new(ServerKeyExchange)
 	skx.Raw = make([]byte, len(m.key))
@@ -48,3 +48,41 @@ func TestCipherSuiteEncodeDecode(t *testing.T) {
 		t.Errorf("decoded wrong name, got %s, expected %s", decodedName, expectedName)
 	}
 }
+
+func TestMalformedChainCertificateKeepsRest(t *testing.T) {
+	malformed := []byte{0x30, 0x03, 0x02, 0x01, 0x00}
+	msg := &certificateMsg{certificates: [][]byte{testRSACertificate, malformed, testRSACertificate}}
+	certs, errs := parseCertificates(msg.certificates)
+
+	log := msg.MakeLog()
+	log.addParsed(certs, nil)
+	log.addParseErrors(errs)
+
+	if log.Certificate.Parsed == nil || log.Certificate.ParseError != "" {
+		t.Errorf("Leaf certificate not parsed: %q", log.Certificate.ParseError)
+	}
+	if len(log.Chain) != 2 {
+		t.Fatalf("Wrong chain length - expected: 2, got: %d", len(log.Chain))
+	}
+	if log.Chain[0].ParseError == "" || log.Chain[0].Parsed != nil {
+		t.Errorf("Malformed certificate did not record a parse error")
+	}
+	if !reflect.DeepEqual(log.Chain[0].Raw, malformed) {
+		t.Errorf("Malformed certificate bytes not preserved")
+	}
+	if log.Chain[1].Parsed == nil {
+		t.Errorf("Certificate after the malformed one was not parsed")
+	}
+}
+
+func TestServerHelloLogsNPN(t *testing.T) {
+	m := &serverHelloMsg{
+		vers:         VersionTLS12,
+		nextProtoNeg: true,
+		nextProtos:   []string{"spdy/3", "http/1.1"},
+	}
+	sh := m.MakeLog()
+	if !sh.NextProtoNeg || !reflect.DeepEqual(sh.NextProtocols, m.nextProtos) || sh.ALPNProtocol != "" {
+		t.Errorf("NPN not logged: %+v", *sh)
+	}
+}
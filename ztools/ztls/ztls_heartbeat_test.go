@@ -0,0 +1,100 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestHeartbeatResponseEchoingPayloadLeaksNothing(t *testing.T) {
+	// A patched server echoes exactly the payload it was sent, plus padding
+	payload := []byte("0123456789abcdef")
+	data := []byte{heartbeatTypeResponse, 0x00, byte(len(payload))}
+	data = append(data, payload...)
+	data = append(data, make([]byte, heartbeatPaddingLength)...)
+
+	sample := make([]byte, 1024)
+	leaked, n := parseHeartbeatResponse(data, len(payload), sample)
+	if leaked != 0 || n != 0 {
+		t.Errorf("Expected no leak, got %d bytes leaked and %d sampled", leaked, n)
+	}
+}
+
+func TestHeartbeatResponseOverreadIsSampled(t *testing.T) {
+	memory := bytes.Repeat([]byte{0x41}, 2048)
+	data := []byte{heartbeatTypeResponse, byte(len(memory) >> 8), byte(len(memory))}
+	data = append(data, memory...)
+	data = append(data, make([]byte, heartbeatPaddingLength)...)
+
+	sample := make([]byte, 1024)
+	leaked, n := parseHeartbeatResponse(data, 0, sample)
+	if leaked != len(memory) {
+		t.Errorf("Wrong leaked byte count - expected: %d, got: %d", len(memory), leaked)
+	}
+	if n != len(sample) || !bytes.Equal(sample, memory[0:len(sample)]) {
+		t.Errorf("Sample not capped at %d bytes, got %d", len(sample), n)
+	}
+}
+
+func TestHeartbleedMessageMarshal(t *testing.T) {
+	hb := heartbleedMessage{}
+	raw := hb.marshal(heartbleedClaimedLength, nil, 0)
+	if !bytes.Equal(raw, []byte{heartbeatTypeRequest, 0x40, 0x00}) {
+		t.Errorf("Wrong overread probe encoding: %x", raw)
+	}
+	raw = hb.marshal(2, []byte{0xaa, 0xbb}, heartbeatPaddingLength)
+	if len(raw) != 3+2+heartbeatPaddingLength || raw[2] != 2 || raw[3] != 0xaa {
+		t.Errorf("Wrong heartbeat encoding: %x", raw)
+	}
+}
+
+func TestHandshakeLogRecordsHeartbeatExtension(t *testing.T) {
+	hello := &clientHelloMsg{heartbeatEnabled: true, heartbeatMode: heartbeatModePeerAllowed}
+	if ch := hello.MakeLog(); !ch.Heartbeat || ch.HeartbeatMode != "peer_allowed_to_send" {
+		t.Errorf("Offered heartbeat extension not logged: %+v", *ch)
+	}
+	if ch := new(clientHelloMsg).MakeLog(); ch.Heartbeat || ch.HeartbeatMode != "" {
+		t.Errorf("Heartbeat extension logged but not offered: %+v", *ch)
+	}
+
+	// A server hello carrying the extension parses into the log
+	serverHello := &serverHelloMsg{vers: VersionTLS12, heartbeatEnabled: true, heartbeatMode: heartbeatModePeerNotAllowed}
+	parsed := new(serverHelloMsg)
+	if !parsed.unmarshal(serverHello.marshal()) {
+		t.Fatalf("Could not unmarshal server hello")
+	}
+	if sh := parsed.MakeLog(); !sh.HeartbeatSupported || sh.HeartbeatMode != "peer_not_allowed_to_send" {
+		t.Errorf("Echoed heartbeat extension not logged: %+v", *sh)
+	}
+}
+
+func TestHeartbleedProbesRejectInvalidPayloadLength(t *testing.T) {
+	c := new(Conn)
+	for _, length := range []int{0, -1, MaxHeartbeatPayloadLength + 1} {
+		if n, err := c.CheckHeartbleedProbes(1, length, 0, nil); err == nil || n != 0 {
+			t.Errorf("Payload length %d accepted", length)
+		}
+	}
+	hb := heartbleedMessage{}
+	if raw := hb.marshal(MaxHeartbeatPayloadLength, nil, 0); !bytes.Equal(raw, []byte{heartbeatTypeRequest, 0xff, 0xff}) {
+		t.Errorf("Wrong overread probe encoding: %x", raw)
+	}
+}
+
+func TestHeartbleedProbeWriteErrorIsLogged(t *testing.T) {
+	client, server := net.Pipe()
+	server.Close()
+	c := &Conn{conn: client, isClient: true, handshakeComplete: true, heartbeat: true, heartbleedLog: new(Heartbleed)}
+	n, err := c.CheckHeartbleedProbes(3, heartbleedClaimedLength, 0, make([]byte, 16))
+	if n != 0 || err != HeartbleedError {
+		t.Fatalf("Wrong result: %d probes, error %v", n, err)
+	}
+	probes := c.heartbleedLog.Probes
+	if len(probes) != 1 || probes[0].Error == "" || probes[0].Err() == nil {
+		t.Errorf("Failed probe not logged: %+v", probes)
+	}
+}
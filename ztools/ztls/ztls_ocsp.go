@@ -0,0 +1,179 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/x509/pkix"
+)
+
+// Parse outcomes of a stapled OCSP response, see OCSPStaple.ParseStatus
+const (
+	OCSPParseOK           = "ok"
+	OCSPParseExpired      = "expired"
+	OCSPParseUnsuccessful = "unsuccessful"
+	OCSPParseMalformed    = "malformed"
+)
+
+var ocspResponseStatusNames = map[asn1.Enumerated]string{
+	0: "successful",
+	1: "malformed_request",
+	2: "internal_error",
+	3: "try_later",
+	5: "sig_required",
+	6: "unauthorized",
+}
+
+var idPKIXOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+// An OCSPStaple is the OCSP response a server sent in its CertificateStatus
+// message. Only the first SingleResponse is recorded, and the signature is
+// not checked. A staple that cannot be parsed is kept raw with a
+// ParseStatus of "malformed" rather than failing the handshake.
+type OCSPStaple struct {
+	Raw              []byte     `json:"raw"`
+	ParseStatus      string     `json:"parse_status"`
+	ParseError       string     `json:"parse_error,omitempty"`
+	ResponseStatus   string     `json:"response_status,omitempty"`
+	CertStatus       string     `json:"cert_status,omitempty"`
+	ProducedAt       *time.Time `json:"produced_at,omitempty"`
+	ThisUpdate       *time.Time `json:"this_update,omitempty"`
+	NextUpdate       *time.Time `json:"next_update,omitempty"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	ResponderName    string     `json:"responder_name,omitempty"`
+	ResponderKeyHash []byte     `json:"responder_key_hash,omitempty"`
+}
+
+// RFC 6960, section 4.2.1
+type ocspResponseASN1 struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type basicOCSPResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version            int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID     asn1.RawValue
+	ProducedAt         time.Time `asn1:"generalized"`
+	Responses          []ocspSingleResponse
+	ResponseExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspSingleResponse struct {
+	CertID           ocspCertID
+	Good             asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked          ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown          asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// parseOCSPStaple parses a stapled response, judging expiry against now
+func parseOCSPStaple(raw []byte, now time.Time) *OCSPStaple {
+	out := &OCSPStaple{Raw: raw}
+	if err := out.parse(now); err != nil {
+		out.ParseStatus = OCSPParseMalformed
+		out.ParseError = err.Error()
+	}
+	return out
+}
+
+func (s *OCSPStaple) parse(now time.Time) error {
+	var resp ocspResponseASN1
+	if rest, err := asn1.Unmarshal(s.Raw, &resp); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return errors.New("trailing data after OCSP response")
+	}
+	if name, ok := ocspResponseStatusNames[resp.Status]; ok {
+		s.ResponseStatus = name
+	} else {
+		return errors.New("unknown OCSP response status")
+	}
+	if resp.Status != 0 {
+		s.ParseStatus = OCSPParseUnsuccessful
+		return nil
+	}
+	if !resp.Response.ResponseType.Equal(idPKIXOCSPBasic) {
+		return errors.New("OCSP response is not a basic response")
+	}
+
+	var basic basicOCSPResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return err
+	}
+	data := basic.TBSResponseData
+	switch data.RawResponderID.Tag {
+	case 1:
+		var rdn pkix.RDNSequence
+		if _, err := asn1.Unmarshal(data.RawResponderID.Bytes, &rdn); err != nil {
+			return err
+		}
+		var name pkix.Name
+		name.FillFromRDNSequence(&rdn)
+		s.ResponderName = name.String()
+	case 2:
+		if _, err := asn1.Unmarshal(data.RawResponderID.Bytes, &s.ResponderKeyHash); err != nil {
+			return err
+		}
+	default:
+		return errors.New("invalid OCSP responder ID")
+	}
+	if len(data.Responses) == 0 {
+		return errors.New("OCSP response contains no responses")
+	}
+
+	single := data.Responses[0]
+	switch {
+	case bool(single.Good):
+		s.CertStatus = "good"
+	case bool(single.Unknown):
+		s.CertStatus = "unknown"
+	default:
+		s.CertStatus = "revoked"
+		revokedAt := single.Revoked.RevocationTime
+		s.RevokedAt = &revokedAt
+	}
+	producedAt, thisUpdate := data.ProducedAt, single.ThisUpdate
+	s.ProducedAt = &producedAt
+	s.ThisUpdate = &thisUpdate
+	s.ParseStatus = OCSPParseOK
+	if !single.NextUpdate.IsZero() {
+		nextUpdate := single.NextUpdate
+		s.NextUpdate = &nextUpdate
+		if now.After(nextUpdate) {
+			s.ParseStatus = OCSPParseExpired
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/x509/pkix"
+)
+
+// The parse structures use asn1.Flag for the CertStatus choice, which does
+// not marshal as NULL, so tests build responses with raw values instead
+type testSingleResponse struct {
+	CertID     ocspCertID
+	CertStatus asn1.RawValue
+	ThisUpdate time.Time `asn1:"generalized"`
+	NextUpdate time.Time `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type testResponseData struct {
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []testSingleResponse
+}
+
+type testBasicResponse struct {
+	TBSResponseData    testResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+}
+
+func marshalTestOCSPResponse(t *testing.T, keyHash []byte, thisUpdate, nextUpdate time.Time) []byte {
+	hash, err := asn1.Marshal(keyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	basic, err := asn1.Marshal(testBasicResponse{
+		TBSResponseData: testResponseData{
+			ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: hash},
+			ProducedAt:  thisUpdate,
+			Responses: []testSingleResponse{{
+				CertID: ocspCertID{
+					HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}},
+					NameHash:      make([]byte, 20),
+					IssuerKeyHash: keyHash,
+					SerialNumber:  big.NewInt(1),
+				},
+				CertStatus: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0},
+				ThisUpdate: thisUpdate,
+				NextUpdate: nextUpdate,
+			}},
+		},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}},
+		Signature:          asn1.BitString{Bytes: []byte{0}, BitLength: 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := asn1.Marshal(ocspResponseASN1{
+		Response: ocspResponseBytes{ResponseType: idPKIXOCSPBasic, Response: basic},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestParseOCSPStaple(t *testing.T) {
+	keyHash := bytes.Repeat([]byte{0x42}, 20)
+	thisUpdate := time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+	nextUpdate := thisUpdate.Add(7 * 24 * time.Hour)
+	raw := marshalTestOCSPResponse(t, keyHash, thisUpdate, nextUpdate)
+
+	s := parseOCSPStaple(raw, thisUpdate.Add(time.Hour))
+	if s.ParseStatus != OCSPParseOK || s.ResponseStatus != "successful" || s.CertStatus != "good" {
+		t.Fatalf("Staple not parsed: %+v", *s)
+	}
+	if !s.ThisUpdate.Equal(thisUpdate) || !s.NextUpdate.Equal(nextUpdate) || !bytes.Equal(s.ResponderKeyHash, keyHash) {
+		t.Errorf("Wrong parsed fields: %+v", *s)
+	}
+
+	if s := parseOCSPStaple(raw, nextUpdate.Add(time.Hour)); s.ParseStatus != OCSPParseExpired {
+		t.Errorf("Expired staple not flagged: %s", s.ParseStatus)
+	}
+	if s := parseOCSPStaple([]byte{0x30, 0x03, 0x0a, 0x01, 0x03}, thisUpdate); s.ParseStatus != OCSPParseUnsuccessful || s.ResponseStatus != "try_later" {
+		t.Errorf("Unsuccessful response not recorded: %+v", *s)
+	}
+	if s := parseOCSPStaple([]byte("garbage"), thisUpdate); s.ParseStatus != OCSPParseMalformed || s.ParseError == "" {
+		t.Errorf("Malformed staple not recorded: %+v", *s)
+	}
+}
+
+func stapledHandshake(t *testing.T, staple []byte, noStapling bool) *ServerHandshake {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	serverConfig := &Config{
+		Time:         testConfig.Time,
+		Rand:         zeroSource{},
+		Certificates: []Certificate{{Certificate: [][]byte{testRSACertificate}, PrivateKey: testRSAPrivateKey, OCSPStaple: staple}},
+		MaxVersion:   VersionTLS12,
+	}
+	go Server(s, serverConfig).Handshake()
+
+	client := Client(c, &Config{InsecureSkipVerify: true, MaxVersion: VersionTLS12, NoOCSPStapling: noStapling})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("Handshake: %s", err)
+	}
+	return client.GetHandshakeLog()
+}
+
+func TestHandshakeRecordsMalformedStaple(t *testing.T) {
+	hl := stapledHandshake(t, []byte("not an OCSP response"), false)
+	if !hl.ServerHello.OcspStapling || hl.OCSPStaple == nil || hl.OCSPStaple.ParseStatus != OCSPParseMalformed {
+		t.Errorf("Malformed staple not recorded: %+v", hl.OCSPStaple)
+	}
+
+	hl = stapledHandshake(t, []byte("not an OCSP response"), true)
+	if hl.ServerHello.OcspStapling || hl.OCSPStaple != nil {
+		t.Errorf("Staple sent without status_request")
+	}
+}
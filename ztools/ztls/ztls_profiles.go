@@ -0,0 +1,426 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"errors"
+	"io"
+)
+
+// GREASE marks where a ClientHelloProfile puts a GREASE value (RFC 8701).
+// Every connection replaces it with a random value of the 0x?A?A form.
+const GREASE = 0x0a0a
+
+// A ClientHelloProfile shapes the ClientHello like a particular client's.
+// Nil fields keep what the Config would send otherwise, and lists may hold
+// GREASE entries. Extensions gives the extensions to send in wire order;
+// those missing from it are not sent.
+//
+// Profiles follow their browser's preferences but leave out the groups, cipher
+// suites and extensions ztls cannot complete a handshake with (X25519,
+// RFC 7905 ChaCha20-Poly1305, certificate compression), so the resulting JA3
+// is close to, not the same as, the browser's.
+type ClientHelloProfile struct {
+	Name              string
+	CipherSuites      []uint16
+	Extensions        []uint16
+	Curves            []CurveID
+	PointFormats      []uint8
+	SignatureSchemes  []uint16
+	ALPN              []string
+	SupportedVersions []uint16
+}
+
+var chromeProfile = &ClientHelloProfile{
+	Name: "chrome",
+	CipherSuites: []uint16{
+		GREASE,
+		TLS_AES_128_GCM_SHA256,
+		TLS_AES_256_GCM_SHA384,
+		TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		TLS_RSA_WITH_AES_128_GCM_SHA256,
+		TLS_RSA_WITH_AES_256_GCM_SHA384,
+		TLS_RSA_WITH_AES_128_CBC_SHA,
+		TLS_RSA_WITH_AES_256_CBC_SHA,
+	},
+	Extensions: []uint16{
+		GREASE,
+		extensionServerName,
+		extensionExtendedMasterSecret,
+		extensionRenegotiationInfo,
+		extensionSupportedCurves,
+		extensionSupportedPoints,
+		extensionSessionTicket,
+		extensionALPN,
+		extensionStatusRequest,
+		extensionSignatureAlgorithms,
+		extensionSCT,
+		extensionKeyShare,
+		extensionPSKModes,
+		extensionSupportedVersions,
+		GREASE,
+	},
+	Curves:       []CurveID{GREASE, CurveP256, CurveP384},
+	PointFormats: []uint8{pointFormatUncompressed},
+	SignatureSchemes: []uint16{
+		0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601,
+	},
+	ALPN:              []string{"h2", "http/1.1"},
+	SupportedVersions: []uint16{GREASE, VersionTLS13, VersionTLS12},
+}
+
+var firefoxProfile = &ClientHelloProfile{
+	Name: "firefox",
+	CipherSuites: []uint16{
+		TLS_AES_128_GCM_SHA256,
+		TLS_AES_256_GCM_SHA384,
+		TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+		TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		TLS_RSA_WITH_AES_128_GCM_SHA256,
+		TLS_RSA_WITH_AES_256_GCM_SHA384,
+		TLS_RSA_WITH_AES_128_CBC_SHA,
+		TLS_RSA_WITH_AES_256_CBC_SHA,
+	},
+	Extensions: []uint16{
+		extensionServerName,
+		extensionExtendedMasterSecret,
+		extensionRenegotiationInfo,
+		extensionSupportedCurves,
+		extensionSupportedPoints,
+		extensionSessionTicket,
+		extensionALPN,
+		extensionStatusRequest,
+		extensionKeyShare,
+		extensionSupportedVersions,
+		extensionSignatureAlgorithms,
+		extensionPSKModes,
+	},
+	Curves:       []CurveID{CurveP256, CurveP384, CurveP521},
+	PointFormats: []uint8{pointFormatUncompressed},
+	SignatureSchemes: []uint16{
+		0x0403, 0x0503, 0x0603, 0x0804, 0x0805, 0x0806, 0x0401, 0x0501, 0x0601, 0x0203, 0x0201,
+	},
+	ALPN:              []string{"h2", "http/1.1"},
+	SupportedVersions: []uint16{VersionTLS13, VersionTLS12},
+}
+
+var safariProfile = &ClientHelloProfile{
+	Name: "safari",
+	CipherSuites: []uint16{
+		GREASE,
+		TLS_AES_128_GCM_SHA256,
+		TLS_AES_256_GCM_SHA384,
+		TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+		TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		TLS_RSA_WITH_AES_256_GCM_SHA384,
+		TLS_RSA_WITH_AES_128_GCM_SHA256,
+		TLS_RSA_WITH_AES_256_CBC_SHA,
+		TLS_RSA_WITH_AES_128_CBC_SHA,
+		TLS_ECDHE_ECDSA_WITH_3DES_EDE_CBC_SHA,
+		TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+		TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	},
+	Extensions: []uint16{
+		GREASE,
+		extensionServerName,
+		extensionExtendedMasterSecret,
+		extensionRenegotiationInfo,
+		extensionSupportedCurves,
+		extensionSupportedPoints,
+		extensionALPN,
+		extensionStatusRequest,
+		extensionSignatureAlgorithms,
+		extensionSCT,
+		extensionKeyShare,
+		extensionPSKModes,
+		extensionSupportedVersions,
+		GREASE,
+	},
+	Curves:       []CurveID{GREASE, CurveP256, CurveP384, CurveP521},
+	PointFormats: []uint8{pointFormatUncompressed},
+	SignatureSchemes: []uint16{
+		0x0403, 0x0804, 0x0401, 0x0503, 0x0203, 0x0805, 0x0501, 0x0806, 0x0601, 0x0201,
+	},
+	ALPN:              []string{"h2", "http/1.1"},
+	SupportedVersions: []uint16{GREASE, VersionTLS13, VersionTLS12, VersionTLS11, VersionTLS10},
+}
+
+// The default profile sends the ClientHello the Config describes; choosing
+// it only records the profile name and JA3 of the hello
+var defaultProfile = &ClientHelloProfile{Name: "default"}
+
+var clientHelloProfiles = map[string]*ClientHelloProfile{
+	chromeProfile.Name:  chromeProfile,
+	firefoxProfile.Name: firefoxProfile,
+	safariProfile.Name:  safariProfile,
+	defaultProfile.Name: defaultProfile,
+}
+
+// ClientHelloProfileByName returns the named built-in profile: "chrome",
+// "firefox", "safari" or "default".
+func ClientHelloProfileByName(name string) (*ClientHelloProfile, bool) {
+	p, ok := clientHelloProfiles[name]
+	return p, ok
+}
+
+// isGREASE reports whether v is one of the RFC 8701 reserved values
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// helloGREASE holds the GREASE values chosen for one connection, separate for
+// each list as browsers do
+type helloGREASE struct {
+	cipher, group, version uint16
+	extensions             [2]uint16
+}
+
+func newHelloGREASE(rand io.Reader) (*helloGREASE, error) {
+	var b [5]byte
+	if _, err := io.ReadFull(rand, b[:]); err != nil {
+		return nil, errors.New("tls: short read from Rand: " + err.Error())
+	}
+	value := func(b byte) uint16 {
+		v := uint16(b&0xf0 | 0x0a)
+		return v<<8 | v
+	}
+	g := &helloGREASE{
+		cipher:     value(b[0]),
+		group:      value(b[1]),
+		version:    value(b[2]),
+		extensions: [2]uint16{value(b[3]), value(b[4])},
+	}
+	if g.extensions[0] == g.extensions[1] {
+		g.extensions[1] ^= 0x1010
+	}
+	return g, nil
+}
+
+func greaseUint16s(list []uint16, value uint16) []uint16 {
+	out := make([]uint16, len(list))
+	for i, v := range list {
+		if v == GREASE {
+			v = value
+		}
+		out[i] = v
+	}
+	return out
+}
+
+// applyClientHelloProfile sets the lists and extensions of hello from the
+// profile. Versions outside the Config's MinVersion and MaxVersion, when set,
+// are not offered and neither are TLS 1.3 suites without TLS 1.3. The
+// Config can still keep extensions out: NoOCSPStapling drops status_request
+// and SessionTicketsDisabled the session ticket extension.
+func (c *Conn) applyClientHelloProfile(hello *clientHelloMsg, p *ClientHelloProfile) error {
+	g, err := newHelloGREASE(c.config.rand())
+	if err != nil {
+		return err
+	}
+	c.helloGREASE = g
+
+	offers13 := hello.vers >= VersionTLS13
+	if p.SupportedVersions != nil {
+		max := c.config.MaxVersion
+		if max == 0 {
+			max = VersionTLS13
+		}
+		hello.supportedVersions = nil
+		hello.vers = 0
+		for _, vers := range p.SupportedVersions {
+			if vers == GREASE {
+				hello.supportedVersions = append(hello.supportedVersions, g.version)
+				continue
+			}
+			if vers < c.config.minVersion() || vers > max {
+				continue
+			}
+			hello.supportedVersions = append(hello.supportedVersions, vers)
+			if vers > hello.vers {
+				hello.vers = vers
+			}
+		}
+		if hello.vers == 0 {
+			return errors.New("tls: the ClientHello profile offers no version within the configured range")
+		}
+		offers13 = hello.vers >= VersionTLS13
+		if !offers13 {
+			hello.supportedVersions = nil
+		}
+	}
+	if p.CipherSuites != nil {
+		hello.cipherSuites = nil
+		for _, id := range greaseUint16s(p.CipherSuites, g.cipher) {
+			if !offers13 && cipherSuiteTLS13ByID(id) != nil {
+				continue
+			}
+			hello.cipherSuites = append(hello.cipherSuites, id)
+		}
+	}
+	if p.Curves != nil {
+		hello.supportedCurves = make([]CurveID, len(p.Curves))
+		for i, curve := range p.Curves {
+			if curve == GREASE {
+				curve = CurveID(g.group)
+			}
+			hello.supportedCurves[i] = curve
+		}
+	}
+	if p.PointFormats != nil {
+		hello.supportedPoints = p.PointFormats
+	}
+	if p.SignatureSchemes != nil {
+		hello.signatureAndHashes = make([]signatureAndHash, len(p.SignatureSchemes))
+		for i, scheme := range p.SignatureSchemes {
+			hello.signatureAndHashes[i] = signatureAndHash{hash: uint8(scheme >> 8), signature: uint8(scheme)}
+		}
+	}
+	if p.ALPN != nil && len(c.config.NextProtos) == 0 {
+		hello.alpnProtocols = p.ALPN
+	}
+
+	if p.Extensions == nil {
+		return nil
+	}
+	has := func(extension uint16) bool {
+		for _, e := range p.Extensions {
+			if e == extension {
+				return true
+			}
+		}
+		return false
+	}
+	if !has(extensionServerName) {
+		hello.serverName = ""
+	}
+	hello.ocspStapling = has(extensionStatusRequest) && !c.config.NoOCSPStapling
+	if !has(extensionSupportedCurves) {
+		hello.supportedCurves = nil
+	}
+	if !has(extensionSupportedPoints) {
+		hello.supportedPoints = nil
+	}
+	if !has(extensionSignatureAlgorithms) {
+		hello.signatureAndHashes = nil
+	}
+	if !has(extensionALPN) {
+		hello.alpnProtocols = nil
+	}
+	hello.nextProtoNeg = hello.nextProtoNeg && has(extensionNextProtoNeg)
+	hello.sctEnabled = has(extensionSCT)
+	hello.extendedMasterSecret = has(extensionExtendedMasterSecret)
+	hello.secureRenegotiation = has(extensionRenegotiationInfo)
+	hello.heartbeatEnabled = hello.heartbeatEnabled && has(extensionHeartbeat)
+	hello.extendedRandomEnabled = hello.extendedRandomEnabled && has(extensionExtendedRandom)
+	if !has(extensionSessionTicket) || c.config.SessionTicketsDisabled {
+		hello.ticketSupported = false
+		hello.sessionTicket = nil
+	} else {
+		hello.ticketSupported = true
+	}
+	if !has(extensionSupportedVersions) || !has(extensionKeyShare) {
+		hello.supportedVersions = nil
+		if hello.vers > VersionTLS12 {
+			hello.vers = VersionTLS12
+		}
+	}
+	return nil
+}
+
+// shapeClientHello marshals hello in the profile's extension order. The
+// first ClientHello also gets a GREASE key share; the one answering a
+// HelloRetryRequest must carry only the share the server asked for.
+func (c *Conn) shapeClientHello(hello *clientHelloMsg, retry bool) {
+	p := c.config.ClientHelloProfile
+	g := c.helloGREASE
+	if !retry && len(hello.keyShares) > 0 {
+		for _, curve := range p.Curves {
+			if curve == GREASE {
+				hello.keyShares = append([]keyShare{{CurveID(g.group), []byte{0}}}, hello.keyShares...)
+				break
+			}
+		}
+	}
+	hello.raw = nil
+	raw := hello.marshal()
+	if p.Extensions != nil {
+		hello.raw = reorderExtensions(raw, p.Extensions, g, len(hello.supportedVersions) > 0)
+	}
+}
+
+// reorderExtensions rewrites a marshalled ClientHello with its extensions in
+// the given order, filling in GREASE extensions and, with TLS 1.3,
+// psk_key_exchange_modes. Extensions missing from the order go last.
+func reorderExtensions(raw []byte, order []uint16, g *helloGREASE, tls13 bool) []byte {
+	offset := 4 + 2 + 32
+	offset += 1 + int(raw[offset])
+	offset += 2 + (int(raw[offset])<<8 | int(raw[offset+1]))
+	offset += 1 + int(raw[offset])
+
+	blocks := make(map[uint16][]byte)
+	var ids []uint16
+	if offset < len(raw) {
+		for exts := raw[offset+2:]; len(exts) >= 4; {
+			id := uint16(exts[0])<<8 | uint16(exts[1])
+			n := 4 + (int(exts[2])<<8 | int(exts[3]))
+			blocks[id] = exts[:n]
+			ids = append(ids, id)
+			exts = exts[n:]
+		}
+	}
+
+	out := make([]byte, offset+2, len(raw)+64)
+	copy(out, raw[:offset])
+	greaseCount := 0
+	for _, id := range order {
+		switch {
+		case id == GREASE:
+			value := g.extensions[greaseCount%2]
+			if greaseCount == 0 {
+				out = append(out, byte(value>>8), byte(value), 0, 0)
+			} else {
+				out = append(out, byte(value>>8), byte(value), 0, 1, 0)
+			}
+			greaseCount++
+		case id == extensionPSKModes:
+			if tls13 {
+				// psk_dhe_ke, https://tools.ietf.org/html/rfc8446#section-4.2.9
+				out = append(out, byte(id>>8), byte(id), 0, 2, 1, 1)
+			}
+		default:
+			out = append(out, blocks[id]...)
+			delete(blocks, id)
+		}
+	}
+	for _, id := range ids {
+		out = append(out, blocks[id]...)
+	}
+
+	extensionsLength := len(out) - offset - 2
+	out[offset] = byte(extensionsLength >> 8)
+	out[offset+1] = byte(extensionsLength)
+	length := len(out) - 4
+	out[1] = byte(length >> 16)
+	out[2] = byte(length >> 8)
+	out[3] = byte(length)
+	return out
+}
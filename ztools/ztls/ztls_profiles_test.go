@@ -0,0 +1,182 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"bytes"
+	stdtls "crypto/tls"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// oneSource is a deterministic Rand that, unlike zeroSource, yields valid
+// ECDHE private keys
+type oneSource struct{}
+
+func (oneSource) Read(b []byte) (n int, err error) {
+	for i := range b {
+		b[i] = 1
+	}
+	return len(b), nil
+}
+
+// profileClientHello returns the ClientHello record a client with the
+// profile writes first
+func profileClientHello(t *testing.T, profile *ClientHelloProfile) []byte {
+	return configClientHello(t, &Config{
+		ServerName:         "example.com",
+		Rand:               oneSource{},
+		ClientHelloProfile: profile,
+	})
+}
+
+// configClientHello returns the ClientHello record a client with config
+// writes first
+func configClientHello(t *testing.T, config *Config) []byte {
+	c, s := net.Pipe()
+	defer c.Close()
+	record := make(chan []byte, 1)
+	go func() {
+		defer s.Close()
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(s, header); err != nil {
+			record <- nil
+			return
+		}
+		body := make([]byte, int(header[3])<<8|int(header[4]))
+		io.ReadFull(s, body)
+		record <- append(header, body...)
+	}()
+	client := Client(c, config)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.Handshake()
+	return <-record
+}
+
+func TestClientHelloProfilesGolden(t *testing.T) {
+	for _, name := range []string{"chrome", "firefox", "safari", "default"} {
+		profile, ok := ClientHelloProfileByName(name)
+		if !ok {
+			t.Fatalf("No %s profile", name)
+		}
+		record := profileClientHello(t, profile)
+		if len(record) < 5 {
+			t.Fatalf("%s: no ClientHello written", name)
+		}
+		golden := filepath.Join("testdata", "ClientHello-Profile-"+name)
+		dump := hex.Dump(record)
+		if *update {
+			if err := ioutil.WriteFile(golden, []byte(dump), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dump != string(expected) {
+			t.Errorf("%s: ClientHello differs from %s:\n%s", name, golden, dump)
+		}
+	}
+}
+
+func TestJA3(t *testing.T) {
+	record := profileClientHello(t, chromeProfile)
+	ja3, err := JA3(record[5:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "771,4865-4866-49195-49199-49196-49200-49171-49172-156-157-47-53,0-23-65281-10-11-35-16-5-13-18-51-45-43,23-24,0"
+	if ja3 != expected {
+		t.Errorf("JA3 = %s, expected %s", ja3, expected)
+	}
+	if _, err := JA3(record[5:40]); err == nil {
+		t.Errorf("JA3 of a truncated ClientHello succeeded")
+	}
+}
+
+func TestClientHelloProfileKeepsExtensionsOut(t *testing.T) {
+	record := configClientHello(t, &Config{
+		ServerName:             "example.com",
+		Rand:                   oneSource{},
+		ClientHelloProfile:     chromeProfile,
+		NoOCSPStapling:         true,
+		SessionTicketsDisabled: true,
+	})
+	ja3, err := JA3(record[5:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "771,4865-4866-49195-49199-49196-49200-49171-49172-156-157-47-53,0-23-65281-10-11-16-13-18-51-45-43,23-24,0"
+	if ja3 != expected {
+		t.Errorf("JA3 = %s, expected %s", ja3, expected)
+	}
+}
+
+func TestGREASEValues(t *testing.T) {
+	g, err := newHelloGREASE(bytes.NewReader([]byte{0x00, 0x35, 0xff, 0x4a, 0x4b}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []uint16{g.cipher, g.group, g.version, g.extensions[0], g.extensions[1]}
+	expected := []uint16{0x0a0a, 0x3a3a, 0xfafa, 0x4a4a, 0x5a5a}
+	for i, v := range values {
+		if v != expected[i] || !isGREASE(v) {
+			t.Errorf("GREASE value %d = %#04x, expected %#04x", i, v, expected[i])
+		}
+	}
+	if isGREASE(0x0a1a) || isGREASE(0x1301) {
+		t.Errorf("Non-GREASE value detected as GREASE")
+	}
+}
+
+// Every profile completes handshakes with TLS 1.3 and TLS 1.2 servers,
+// including after a HelloRetryRequest
+func TestClientHelloProfileHandshakes(t *testing.T) {
+	servers := map[string]*stdtls.Config{
+		"TLSv13": {
+			Certificates: []stdtls.Certificate{testECDSAStdCertificate(t)},
+			MinVersion:   stdtls.VersionTLS13,
+		},
+		"TLSv13-HRR": {
+			Certificates:     []stdtls.Certificate{testECDSAStdCertificate(t)},
+			MinVersion:       stdtls.VersionTLS13,
+			CurvePreferences: []stdtls.CurveID{stdtls.CurveP384},
+		},
+		"TLSv12": {
+			Certificates: []stdtls.Certificate{testRSAStdCertificate()},
+			MaxVersion:   stdtls.VersionTLS12,
+		},
+	}
+	for _, name := range []string{"chrome", "firefox", "safari"} {
+		profile, _ := ClientHelloProfileByName(name)
+		for serverName, serverConfig := range servers {
+			client := stdTLSPair(t, &Config{
+				InsecureSkipVerify: true,
+				ClientHelloProfile: profile,
+				NextProtos:         []string{"http/1.1"},
+			}, serverConfig)
+			if err := client.Handshake(); err != nil {
+				t.Fatalf("%s against %s: Handshake: %s", name, serverName, err)
+			}
+			hl := client.GetHandshakeLog()
+			if hl.ClientHello.Profile != name || hl.ClientHello.JA3 == nil || !strings.HasPrefix(hl.ClientHello.JA3.Raw, "771,") {
+				t.Errorf("%s against %s: profile %q, ja3 %+v", name, serverName, hl.ClientHello.Profile, hl.ClientHello.JA3)
+			}
+			if strings.HasSuffix(serverName, "HRR") && hl.HelloRetryRequest == nil {
+				t.Errorf("%s against %s: no HelloRetryRequest", name, serverName)
+			}
+			pingTLS(t, client)
+			client.Close()
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+// Outcomes of a client-initiated renegotiation, as recorded in
+// Renegotiation.Result
+const (
+	RenegotiationSecure     = "secure"
+	RenegotiationInsecure   = "insecure"
+	RenegotiationRefused    = "no_renegotiation"
+	RenegotiationFatalAlert = "fatal_alert"
+	RenegotiationNoResponse = "no_response"
+	RenegotiationError      = "error"
+)
+
+// Renegotiation records the server's answer to a renegotiation ClientHello
+// sent after the initial handshake
+type Renegotiation struct {
+	// Whether the initial ServerHello carried the RFC 5746 extension
+	SecureRenegotiation bool   `json:"secure_renegotiation"`
+	Result              string `json:"result"`
+	Alert               string `json:"alert,omitempty"`
+	Error               string `json:"error,omitempty"`
+
+	// ErrorClass is not set here: callers classify Err with zerrors, which
+	// imports this package
+	ErrorClass string `json:"error_class,omitempty"`
+
+	err error
+}
+
+// Err returns the error recorded in Error, or nil
+func (r *Renegotiation) Err() error {
+	return r.err
+}
+
+// CheckRenegotiation sends a new ClientHello over the established
+// connection and classifies the server's reply. The renegotiation is never
+// completed, so the connection must not be used afterwards. Reads honor the
+// connection's deadlines.
+func (c *Conn) CheckRenegotiation() (*Renegotiation, error) {
+	if err := c.Handshake(); err != nil {
+		return nil, err
+	}
+	if !c.isClient {
+		return nil, errors.New("tls: only clients can initiate renegotiation")
+	}
+	if c.vers >= VersionTLS13 {
+		return nil, errors.New("tls: TLS 1.3 has no renegotiation")
+	}
+	c.in.Lock()
+	defer c.in.Unlock()
+
+	r := new(Renegotiation)
+	if c.handshakeLog != nil && c.handshakeLog.ServerHello != nil {
+		r.SecureRenegotiation = c.handshakeLog.ServerHello.SecureRenegotiation
+	}
+	hello := &clientHelloMsg{
+		vers:                c.vers,
+		random:              make([]byte, 32),
+		cipherSuites:        []uint16{c.cipherSuite},
+		compressionMethods:  []uint8{compressionNone},
+		supportedCurves:     c.config.curvePreferences(),
+		supportedPoints:     []uint8{pointFormatUncompressed},
+		secureRenegotiation: r.SecureRenegotiation,
+	}
+	if r.SecureRenegotiation {
+		hello.renegotiationInfo = c.clientVerifyData
+	}
+	if c.vers >= VersionTLS12 {
+		hello.signatureAndHashes = c.config.signatureAndHashesForClient()
+	}
+	if _, err := io.ReadFull(c.config.rand(), hello.random); err != nil {
+		return nil, err
+	}
+
+	c.out.Lock()
+	_, err := c.writeRecord(recordTypeHandshake, hello.marshal())
+	c.out.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	c.renegotiating = true
+	defer func() { c.renegotiating = false }()
+	msg, err := c.readHandshake()
+	if err != nil {
+		r.Error = err.Error()
+		r.err = err
+		switch e := err.(type) {
+		case alert:
+			r.Result = RenegotiationRefused
+			r.Alert = e.String()
+		case *net.OpError:
+			if a, ok := e.Err.(alert); ok && e.Op == "remote error" {
+				r.Result = RenegotiationFatalAlert
+				r.Alert = a.String()
+			} else if e.Timeout() {
+				r.Result = RenegotiationNoResponse
+			} else {
+				r.Result = RenegotiationError
+			}
+		default:
+			if ne, ok := err.(net.Error); (ok && ne.Timeout()) || err == io.EOF || err == io.ErrUnexpectedEOF {
+				r.Result = RenegotiationNoResponse
+			} else {
+				r.Result = RenegotiationError
+			}
+		}
+		return r, nil
+	}
+
+	serverHello, ok := msg.(*serverHelloMsg)
+	if !ok {
+		r.Result = RenegotiationError
+		r.err = unexpectedMessageError(serverHello, msg)
+		r.Error = r.err.Error()
+		return r, nil
+	}
+	if serverHello.secureRenegotiation {
+		r.Result = RenegotiationSecure
+	} else {
+		r.Result = RenegotiationInsecure
+	}
+	return r, nil
+}
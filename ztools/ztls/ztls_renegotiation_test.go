@@ -0,0 +1,43 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCheckRenegotiationRefusedByServer(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	go func() {
+		server := Server(s, testConfig)
+		if err := server.Handshake(); err != nil {
+			return
+		}
+		// The server only expects application data and answers the
+		// renegotiation ClientHello with a no_renegotiation warning
+		server.Read(make([]byte, 1))
+	}()
+
+	client := Client(c, &Config{InsecureSkipVerify: true, MaxVersion: VersionTLS12})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("Handshake: %s", err)
+	}
+	r, err := client.CheckRenegotiation()
+	if err != nil {
+		t.Fatalf("CheckRenegotiation: %s", err)
+	}
+	if !r.SecureRenegotiation {
+		t.Errorf("RFC 5746 extension in the initial handshake not recorded")
+	}
+	if r.Result != RenegotiationRefused || r.Alert != alertNoRenegotiation.String() {
+		t.Errorf("Wrong result - expected: %s, got: %s (%s)", RenegotiationRefused, r.Result, r.Error)
+	}
+}
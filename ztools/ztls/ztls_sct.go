@@ -0,0 +1,238 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"crypto/sha256"
+	stdx509 "crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/x509"
+	"gopkg.in/eniac/zgrab.v0/ztools/x509/pkix"
+	"gopkg.in/eniac/zgrab.v0/ztools/zct"
+)
+
+// How an SCT was delivered, see SCT.Source
+const (
+	SCTSourceTLSExtension = "tls_extension"
+	SCTSourceCertificate  = "certificate"
+)
+
+// Outcomes of checking an SCT signature, see SCT.SignatureStatus. SCTs are
+// unverified when no log list was given, and unknown_log when the list does
+// not have the SCT's log.
+const (
+	SCTSignatureValid      = "valid"
+	SCTSignatureInvalid    = "invalid"
+	SCTSignatureUnverified = "unverified"
+	SCTSignatureUnknownLog = "unknown_log"
+	SCTSignatureError      = "error"
+)
+
+var oidExtensionSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// An SCT is a signed certificate timestamp for the server's leaf
+// certificate, from either the TLS extension or the certificate itself
+type SCT struct {
+	Source          string `json:"source"`
+	Version         uint8  `json:"version"`
+	LogID           []byte `json:"log_id"`
+	LogDescription  string `json:"log_description,omitempty"`
+	Timestamp       uint64 `json:"timestamp"`
+	SignatureStatus string `json:"signature_status"`
+	Error           string `json:"error,omitempty"`
+
+	// ErrorClass is not set here: callers classify Err with zerrors, which
+	// imports this package
+	ErrorClass string `json:"error_class,omitempty"`
+
+	err error
+}
+
+// Err returns the error recorded in Error, or nil
+func (s *SCT) Err() error {
+	return s.err
+}
+
+// A CTLog is a Certificate Transparency log SCTs are checked against
+type CTLog struct {
+	Description string `json:"description"`
+	Key         []byte `json:"key"`
+	URL         string `json:"url,omitempty"`
+
+	verifier *ct.SignatureVerifier
+}
+
+// A CTLogList holds CT logs by log ID, the SHA-256 hash of their key
+type CTLogList struct {
+	logs map[[sha256.Size]byte]*CTLog
+}
+
+// ParseCTLogList parses a log list in the format of the Chrome log_list.json
+// ({"logs": [{"description": ..., "key": <base64 DER>, "url": ...}]})
+func ParseCTLogList(b []byte) (*CTLogList, error) {
+	var aux struct {
+		Logs []*CTLog `json:"logs"`
+	}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return nil, err
+	}
+	list := &CTLogList{logs: make(map[[sha256.Size]byte]*CTLog, len(aux.Logs))}
+	for _, log := range aux.Logs {
+		key, err := stdx509.ParsePKIXPublicKey(log.Key)
+		if err != nil {
+			return nil, errors.New("invalid key for CT log " + log.Description + ": " + err.Error())
+		}
+		if log.verifier, err = ct.NewSignatureVerifier(key); err != nil {
+			return nil, errors.New("invalid key for CT log " + log.Description + ": " + err.Error())
+		}
+		list.logs[sha256.Sum256(log.Key)] = log
+	}
+	return list, nil
+}
+
+// collectSCTs gathers the SCTs for the leaf certificate in the handshake
+// log and checks their signatures against logs. Embedded SCTs are signed
+// over the precertificate, which needs the issuer from the chain.
+func collectSCTs(hl *ServerHandshake, logs *CTLogList) []*SCT {
+	var leaf, issuer *x509.Certificate
+	if certs := hl.ServerCertificates; certs != nil {
+		leaf = certs.Certificate.Parsed
+		if len(certs.Chain) > 0 {
+			issuer = certs.Chain[0].Parsed
+		}
+	}
+	if leaf == nil {
+		return nil
+	}
+
+	var out []*SCT
+	if hl.ServerHello != nil {
+		for _, sct := range hl.ServerHello.SignedCertificateTimestamps {
+			if sct.Parsed == nil {
+				continue
+			}
+			entry, err := x509Entry(leaf)
+			out = append(out, checkSCT(SCTSourceTLSExtension, sct.Parsed, entry, err, logs))
+		}
+	}
+	for _, sct := range leaf.SignedCertificateTimestampList {
+		entry, err := precertEntry(leaf, issuer)
+		out = append(out, checkSCT(SCTSourceCertificate, sct, entry, err, logs))
+	}
+	return out
+}
+
+func checkSCT(source string, sct *ct.SignedCertificateTimestamp, entry *ct.LogEntry, entryErr error, logs *CTLogList) *SCT {
+	out := &SCT{
+		Source:    source,
+		Version:   uint8(sct.SCTVersion),
+		LogID:     append([]byte(nil), sct.LogID[:]...),
+		Timestamp: sct.Timestamp,
+	}
+	if logs == nil {
+		out.SignatureStatus = SCTSignatureUnverified
+		return out
+	}
+	log := logs.logs[sct.LogID]
+	if log == nil {
+		out.SignatureStatus = SCTSignatureUnknownLog
+		return out
+	}
+	out.LogDescription = log.Description
+	if entryErr != nil {
+		out.SignatureStatus = SCTSignatureError
+		out.Error = entryErr.Error()
+		out.err = entryErr
+		return out
+	}
+	entry.Leaf.TimestampedEntry.Extensions = sct.Extensions
+	if err := log.verifier.VerifySCTSignature(*sct, *entry); err != nil {
+		out.SignatureStatus = SCTSignatureInvalid
+		out.Error = err.Error()
+		out.err = err
+		return out
+	}
+	out.SignatureStatus = SCTSignatureValid
+	return out
+}
+
+func x509Entry(leaf *x509.Certificate) (*ct.LogEntry, error) {
+	entry := &ct.LogEntry{}
+	entry.Leaf.LeafType = ct.TimestampedEntryLeafType
+	entry.Leaf.TimestampedEntry.EntryType = ct.X509LogEntryType
+	entry.Leaf.TimestampedEntry.X509Entry = leaf.Raw
+	return entry, nil
+}
+
+func precertEntry(leaf, issuer *x509.Certificate) (*ct.LogEntry, error) {
+	if issuer == nil {
+		return nil, errors.New("issuer certificate not sent")
+	}
+	tbs, err := removeSCTExtension(leaf.RawTBSCertificate)
+	if err != nil {
+		return nil, err
+	}
+	entry := &ct.LogEntry{}
+	entry.Leaf.LeafType = ct.TimestampedEntryLeafType
+	entry.Leaf.TimestampedEntry.EntryType = ct.PrecertLogEntryType
+	entry.Leaf.TimestampedEntry.PrecertEntry.IssuerKeyHash = sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	entry.Leaf.TimestampedEntry.PrecertEntry.TBSCertificate = tbs
+	return entry, nil
+}
+
+// removeSCTExtension re-encodes a TBSCertificate without its SCT list
+// extension, giving the TBSCertificate the log signed (RFC 6962, 3.2)
+func removeSCTExtension(tbs []byte) ([]byte, error) {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(tbs, &seq); err != nil {
+		return nil, err
+	}
+	var fields []byte
+	for rest := seq.Bytes; len(rest) > 0; {
+		var field asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			return nil, err
+		}
+		if field.Class == asn1.ClassContextSpecific && field.Tag == 3 {
+			exts, err := filterExtensions(field.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			if field.FullBytes, err = asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: exts}); err != nil {
+				return nil, err
+			}
+		}
+		fields = append(fields, field.FullBytes...)
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: fields})
+}
+
+// filterExtensions returns the Extensions SEQUENCE in b without the SCT list
+func filterExtensions(b []byte) ([]byte, error) {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(b, &seq); err != nil {
+		return nil, err
+	}
+	var kept []byte
+	for rest := seq.Bytes; len(rest) > 0; {
+		var ext pkix.Extension
+		var raw asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &raw); err != nil {
+			return nil, err
+		}
+		if _, err := asn1.Unmarshal(raw.FullBytes, &ext); err != nil {
+			return nil, err
+		}
+		if !ext.Id.Equal(oidExtensionSCTList) {
+			kept = append(kept, raw.FullBytes...)
+		}
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: kept})
+}
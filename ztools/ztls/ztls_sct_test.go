@@ -0,0 +1,118 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	stdx509 "crypto/x509"
+	stdpkix "crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/x509"
+	"gopkg.in/eniac/zgrab.v0/ztools/zct"
+)
+
+func TestCollectSCTsFromTLSExtension(t *testing.T) {
+	leaf, err := x509.ParseCertificate(testRSACertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := stdx509.MarshalPKIXPublicKey(&logKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listJSON, _ := json.Marshal(map[string]interface{}{
+		"logs": []map[string]interface{}{{"description": "Test Log", "key": der}},
+	})
+	logs, err := ParseCTLogList(listJSON)
+	if err != nil {
+		t.Fatalf("ParseCTLogList: %s", err)
+	}
+
+	sct := &ct.SignedCertificateTimestamp{SCTVersion: ct.V1, LogID: sha256.Sum256(der), Timestamp: 1433116800000}
+	entry, _ := x509Entry(leaf)
+	input, err := ct.SerializeSCTSignatureInput(*sct, *entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(input)
+	r, s, err := ecdsa.Sign(rand.Reader, logKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	sct.Signature = ct.DigitallySigned{HashAlgorithm: ct.SHA256, SignatureAlgorithm: ct.ECDSA, Signature: sig}
+
+	tampered := *sct
+	tampered.Timestamp++
+	hl := &ServerHandshake{
+		ServerHello:        &ServerHello{SignedCertificateTimestamps: []ParsedAndRawSCT{{Parsed: sct}, {Parsed: &tampered}}},
+		ServerCertificates: &Certificates{Certificate: SimpleCertificate{Parsed: leaf}},
+	}
+	scts := collectSCTs(hl, logs)
+	if len(scts) != 2 {
+		t.Fatalf("Expected 2 SCTs, got %d", len(scts))
+	}
+	if scts[0].Source != SCTSourceTLSExtension || scts[0].SignatureStatus != SCTSignatureValid || scts[0].LogDescription != "Test Log" {
+		t.Errorf("Valid SCT not verified: %+v", *scts[0])
+	}
+	if scts[1].SignatureStatus != SCTSignatureInvalid {
+		t.Errorf("Tampered SCT verified: %+v", *scts[1])
+	}
+	if scts := collectSCTs(hl, nil); scts[0].SignatureStatus != SCTSignatureUnverified {
+		t.Errorf("SCT checked without a log list: %+v", *scts[0])
+	}
+	if scts := collectSCTs(hl, new(CTLogList)); scts[0].SignatureStatus != SCTSignatureUnknownLog {
+		t.Errorf("SCT checked against a list without its log: %+v", *scts[0])
+	}
+}
+
+func TestRemoveSCTExtension(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := stdpkix.Extension{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Value: []byte{0x05, 0x00}}
+	sctList := stdpkix.Extension{Id: asn1.ObjectIdentifier(oidExtensionSCTList), Value: []byte{0x04, 0x02, 0x00, 0x00}}
+	makeTBS := func(exts ...stdpkix.Extension) []byte {
+		template := &stdx509.Certificate{
+			SerialNumber:    big.NewInt(1),
+			Subject:         stdpkix.Name{CommonName: "ct.example.com"},
+			NotBefore:       time.Unix(1433116800, 0),
+			NotAfter:        time.Unix(1433116800, 0).Add(time.Hour),
+			SubjectKeyId:    []byte{1, 2, 3, 4},
+			ExtraExtensions: exts,
+		}
+		der, err := stdx509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := stdx509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.RawTBSCertificate
+	}
+
+	precert, err := removeSCTExtension(makeTBS(other, sctList))
+	if err != nil {
+		t.Fatalf("removeSCTExtension: %s", err)
+	}
+	if !bytes.Equal(precert, makeTBS(other)) {
+		t.Errorf("SCT extension not removed cleanly")
+	}
+}
@@ -0,0 +1,986 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/fingerprint"
+	"gopkg.in/eniac/zgrab.v0/ztools/x509"
+)
+
+// A keyShare is a KeyShareEntry of RFC 8446 section 4.2.8: a group and the
+// public value for it
+type keyShare struct {
+	group CurveID
+	data  []byte
+}
+
+// helloRetryRequestRandom is the ServerHello.random that marks a
+// HelloRetryRequest, RFC 8446 section 4.1.3
+var helloRetryRequestRandom = []byte{
+	0xCF, 0x21, 0xAD, 0x74, 0xE5, 0x9A, 0x61, 0x11,
+	0xBE, 0x1D, 0x8C, 0x02, 0x1E, 0x65, 0xB8, 0x91,
+	0xC2, 0xA2, 0x11, 0x16, 0x7A, 0xBB, 0x8C, 0x5E,
+	0x07, 0x9E, 0x09, 0xE2, 0xC8, 0xA8, 0x33, 0x9C,
+}
+
+// Labels of the TLS 1.3 key schedule, RFC 8446 section 7.1
+const (
+	clientHandshakeTrafficLabel   = "c hs traffic"
+	serverHandshakeTrafficLabel   = "s hs traffic"
+	clientApplicationTrafficLabel = "c ap traffic"
+	serverApplicationTrafficLabel = "s ap traffic"
+	trafficUpdateLabel            = "traffic upd"
+	derivedLabel                  = "derived"
+)
+
+// serverSignatureContext prefixes the transcript hash signed in a server
+// CertificateVerify, RFC 8446 section 4.4.3
+const serverSignatureContext = "TLS 1.3, server CertificateVerify\x00"
+
+// A cipherSuiteTLS13 is a TLS 1.3 AEAD and hash pair. Key exchange and
+// authentication are negotiated separately.
+type cipherSuiteTLS13 struct {
+	id     uint16
+	keyLen int
+	aead   func(key []byte) cipher.AEAD
+	hash   crypto.Hash
+}
+
+// cipherSuitesTLS13 are offered ahead of the TLS 1.2 suites when the
+// maximum version allows TLS 1.3. ChaCha20-Poly1305 is missing because the
+// implementation here predates RFC 7539.
+var cipherSuitesTLS13 = []*cipherSuiteTLS13{
+	{TLS_AES_128_GCM_SHA256, 16, aeadAESGCMTLS13, crypto.SHA256},
+	{TLS_AES_256_GCM_SHA384, 32, aeadAESGCMTLS13, crypto.SHA384},
+}
+
+func aeadAESGCMTLS13(key []byte) cipher.AEAD {
+	aes, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(aes)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+func cipherSuiteTLS13ByID(id uint16) *cipherSuiteTLS13 {
+	for _, suite := range cipherSuitesTLS13 {
+		if suite.id == id {
+			return suite
+		}
+	}
+	return nil
+}
+
+// A tls13Aead protects TLS 1.3 records. The nonce is the static IV XORed
+// with the sequence number and the record header is the additional data.
+type tls13Aead struct {
+	cipher.AEAD
+	iv    []byte
+	nonce []byte
+}
+
+func (a *tls13Aead) nonceFor(seq []byte) []byte {
+	copy(a.nonce, a.iv)
+	for i, b := range seq {
+		a.nonce[len(a.nonce)-8+i] ^= b
+	}
+	return a.nonce
+}
+
+// hkdfExtract is HKDF-Extract of RFC 5869. A nil secret stands for a string
+// of zeros as long as the hash.
+func hkdfExtract(h crypto.Hash, secret, salt []byte) []byte {
+	if secret == nil {
+		secret = make([]byte, h.Size())
+	}
+	mac := hmac.New(h.New, salt)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// hkdfExpand is HKDF-Expand of RFC 5869
+func hkdfExpand(h crypto.Hash, prk, info []byte, length int) []byte {
+	out := make([]byte, 0, length+h.Size())
+	var t []byte
+	for i := byte(1); len(out) < length; i++ {
+		mac := hmac.New(h.New, prk)
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:length]
+}
+
+// expandLabel is HKDF-Expand-Label, RFC 8446 section 7.1
+func (s *cipherSuiteTLS13) expandLabel(secret []byte, label string, context []byte, length int) []byte {
+	label = "tls13 " + label
+	info := make([]byte, 0, 4+len(label)+len(context))
+	info = append(info, byte(length>>8), byte(length), byte(len(label)))
+	info = append(info, label...)
+	info = append(info, byte(len(context)))
+	info = append(info, context...)
+	return hkdfExpand(s.hash, secret, info, length)
+}
+
+// deriveSecret is Derive-Secret, RFC 8446 section 7.1. A nil transcript is
+// the hash of the empty string.
+func (s *cipherSuiteTLS13) deriveSecret(secret []byte, label string, transcript hash.Hash) []byte {
+	if transcript == nil {
+		transcript = s.hash.New()
+	}
+	return s.expandLabel(secret, label, transcript.Sum(nil), s.hash.Size())
+}
+
+// trafficKey returns the record protection keyed by a traffic secret
+func (s *cipherSuiteTLS13) trafficKey(secret []byte) *tls13Aead {
+	key := s.expandLabel(secret, "key", nil, s.keyLen)
+	iv := s.expandLabel(secret, "iv", nil, 12)
+	return &tls13Aead{AEAD: s.aead(key), iv: iv, nonce: make([]byte, len(iv))}
+}
+
+// finishedMAC computes the verify_data of a Finished sent by the side owning
+// the handshake traffic secret, RFC 8446 section 4.4.4
+func (s *cipherSuiteTLS13) finishedMAC(secret []byte, transcript hash.Hash) []byte {
+	finishedKey := s.expandLabel(secret, "finished", nil, s.hash.Size())
+	mac := hmac.New(s.hash.New, finishedKey)
+	mac.Write(transcript.Sum(nil))
+	return mac.Sum(nil)
+}
+
+// setTrafficSecret switches the half connection to TLS 1.3 record
+// protection keyed by secret. Unlike earlier versions no ChangeCipherSpec
+// is involved, so the sequence number resets here.
+func (hc *halfConn) setTrafficSecret(suite *cipherSuiteTLS13, secret []byte) {
+	hc.version = VersionTLS13
+	hc.trafficSuite = suite
+	hc.trafficSecret = secret
+	hc.cipher = suite.trafficKey(secret)
+	hc.mac = nil
+	hc.resetSeq()
+}
+
+// decryptTLS13 opens a protected record and replaces the outer
+// application_data type in the header with the inner content type.
+// Compatibility ChangeCipherSpecs are never protected and alerts from
+// servers that lost their keys are let through in the clear.
+func (hc *halfConn) decryptTLS13(c *tls13Aead, b *block) (bool, int, alert) {
+	recordHeaderLen := hc.recordHeaderLen()
+	switch recordType(b.data[0]) {
+	case recordTypeChangeCipherSpec, recordTypeAlert:
+		return true, recordHeaderLen, 0
+	case recordTypeApplicationData:
+	default:
+		return false, 0, alertUnexpectedMessage
+	}
+
+	payload := b.data[recordHeaderLen:]
+	plaintext, err := c.Open(payload[:0], c.nonceFor(hc.seq[:]), payload, b.data[:recordHeaderLen])
+	if err != nil {
+		return false, 0, alertBadRecordMAC
+	}
+	n := len(plaintext)
+	for n > 0 && plaintext[n-1] == 0 {
+		n--
+	}
+	if n == 0 {
+		return false, 0, alertUnexpectedMessage
+	}
+	b.data[0] = plaintext[n-1]
+	b.resize(recordHeaderLen + n - 1)
+	hc.incSeq(false)
+	return true, recordHeaderLen, 0
+}
+
+// encryptTLS13 protects the record in b, moving its type inside the
+// ciphertext
+func (hc *halfConn) encryptTLS13(c *tls13Aead, b *block) (bool, alert) {
+	recordHeaderLen := hc.recordHeaderLen()
+	n := len(b.data)
+	b.resize(n + 1 + c.Overhead())
+	b.data[n] = b.data[0]
+	b.data[0] = byte(recordTypeApplicationData)
+	length := len(b.data) - recordHeaderLen
+	b.data[3] = byte(length >> 8)
+	b.data[4] = byte(length)
+	payload := b.data[recordHeaderLen : n+1]
+	c.Seal(payload[:0], c.nonceFor(hc.seq[:]), payload, b.data[:recordHeaderLen])
+	hc.incSeq(true)
+	return true, 0
+}
+
+// An ephemeralKey is the client's private half of a key_share
+type ephemeralKey struct {
+	curve elliptic.Curve
+	priv  []byte
+	share keyShare
+}
+
+func generateEphemeralKey(rand io.Reader, group CurveID) (*ephemeralKey, error) {
+	curve, ok := curveForCurveID(group)
+	if !ok {
+		return nil, errors.New("tls: no key_share support for the group")
+	}
+	priv, x, y, err := elliptic.GenerateKey(curve, rand)
+	if err != nil {
+		return nil, err
+	}
+	return &ephemeralKey{curve, priv, keyShare{group, elliptic.Marshal(curve, x, y)}}, nil
+}
+
+// sharedSecret returns the x-coordinate of the ECDH result padded to the
+// field size, RFC 8446 section 7.4.2, or nil for an invalid peer share
+func (k *ephemeralKey) sharedSecret(peer []byte) []byte {
+	x, y := elliptic.Unmarshal(k.curve, peer)
+	if x == nil {
+		return nil
+	}
+	x, _ = k.curve.ScalarMult(x, y, k.priv)
+	out := make([]byte, (k.curve.Params().BitSize+7)/8)
+	xBytes := x.Bytes()
+	copy(out[len(out)-len(xBytes):], xBytes)
+	return out
+}
+
+// supportedVersions lists the versions offered in supported_versions,
+// highest first. SSL 3.0 cannot be negotiated this way.
+func (c *Config) supportedVersions() []uint16 {
+	max := c.maxVersion()
+	if max > VersionTLS13 {
+		max = VersionTLS13
+	}
+	var versions []uint16
+	for vers := max; vers >= c.minVersion() && vers >= VersionTLS10; vers-- {
+		versions = append(versions, vers)
+	}
+	return versions
+}
+
+// offerTLS13 adds what a TLS 1.3 server needs to hello: supported_versions,
+// the TLS 1.3 suites, a key_share for the first usable curve and a legacy
+// session ID for middlebox compatibility. Versions and suites already set,
+// as by a ClientHelloProfile, are kept.
+func (c *Conn) offerTLS13(hello *clientHelloMsg) (*ephemeralKey, error) {
+	// The legacy version stays at TLS 1.2, RFC 8446 section 4.1.2
+	hello.vers = VersionTLS12
+	if hello.supportedVersions == nil {
+		hello.supportedVersions = c.config.supportedVersions()
+	}
+
+	offered := false
+	for _, suite := range cipherSuitesTLS13 {
+		offered = offered || cipherIDInCipherIDList(suite.id, hello.cipherSuites)
+	}
+	if !offered {
+		var suites []uint16
+		for _, suite := range cipherSuitesTLS13 {
+			suites = append(suites, suite.id)
+		}
+		hello.cipherSuites = append(suites, hello.cipherSuites...)
+	}
+
+	var key *ephemeralKey
+	for _, curve := range hello.supportedCurves {
+		if _, ok := curveForCurveID(curve); !ok {
+			continue
+		}
+		var err error
+		if key, err = generateEphemeralKey(c.config.rand(), curve); err != nil {
+			return nil, err
+		}
+		hello.keyShares = []keyShare{key.share}
+		break
+	}
+	if key == nil {
+		return nil, errors.New("tls: no supported curve for a TLS 1.3 key_share")
+	}
+
+	if len(hello.sessionId) == 0 {
+		hello.sessionId = make([]byte, 32)
+		if _, err := io.ReadFull(c.config.rand(), hello.sessionId); err != nil {
+			return nil, errors.New("tls: short read from Rand: " + err.Error())
+		}
+	}
+	return key, nil
+}
+
+type clientHandshakeStateTLS13 struct {
+	c               *Conn
+	hello           *clientHelloMsg
+	serverHello     *serverHelloMsg
+	key             *ephemeralKey
+	suite           *cipherSuiteTLS13
+	transcript      hash.Hash
+	clientSecret    []byte // handshake traffic secrets
+	serverSecret    []byte
+	masterSecret    []byte
+	clientAppSecret []byte
+	certRequested   bool
+	certContext     []byte
+}
+
+// clientHandshakeTLS13 completes a handshake whose ServerHello, possibly a
+// HelloRetryRequest, selected TLS 1.3
+func (c *Conn) clientHandshakeTLS13(hello *clientHelloMsg, serverHello *serverHelloMsg, key *ephemeralKey) error {
+	hs := &clientHandshakeStateTLS13{
+		c:           c,
+		hello:       hello,
+		serverHello: serverHello,
+		key:         key,
+	}
+	if err := hs.checkServerHello(); err != nil {
+		return err
+	}
+	hs.transcript.Write(hs.hello.marshal())
+	if bytes.Equal(hs.serverHello.random, helloRetryRequestRandom) {
+		if err := hs.processHelloRetryRequest(); err != nil {
+			return err
+		}
+	}
+	hs.transcript.Write(hs.serverHello.marshal())
+
+	if err := hs.establishHandshakeKeys(); err != nil {
+		return err
+	}
+	if err := hs.readServerParameters(); err != nil {
+		return err
+	}
+	if err := hs.readServerCertificate(); err != nil {
+		return err
+	}
+	if err := hs.readServerFinished(); err != nil {
+		return err
+	}
+	if err := hs.sendClientFinished(); err != nil {
+		return err
+	}
+
+	c.handshakeComplete = true
+	c.cipherSuite = hs.suite.id
+	return nil
+}
+
+func (hs *clientHandshakeStateTLS13) checkServerHello() error {
+	c := hs.c
+	if hs.serverHello.supportedVersion != VersionTLS13 || hs.serverHello.vers != VersionTLS12 {
+		c.sendAlert(alertIllegalParameter)
+		return fmt.Errorf("tls: server selected unsupported protocol version %x", hs.serverHello.supportedVersion)
+	}
+	offered := false
+	for _, vers := range hs.hello.supportedVersions {
+		offered = offered || vers == VersionTLS13
+	}
+	if !offered {
+		c.sendAlert(alertProtocolVersion)
+		return fmt.Errorf("tls: server selected unsupported protocol version %x", hs.serverHello.supportedVersion)
+	}
+	c.vers = VersionTLS13
+	c.haveVers = true
+
+	if hs.serverHello.compressionMethod != compressionNone {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server selected unsupported compression format")
+	}
+	if !bytes.Equal(hs.serverHello.sessionId, hs.hello.sessionId) {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server did not echo the legacy session ID")
+	}
+
+	suite := cipherSuiteTLS13ByID(hs.serverHello.cipherSuite)
+	if suite == nil || !cipherIDInCipherIDList(suite.id, hs.hello.cipherSuites) {
+		if !cipherIDInCipherIDList(hs.serverHello.cipherSuite, hs.hello.cipherSuites) {
+			c.cipherError = ErrNoMutualCipher
+		} else {
+			c.cipherError = ErrUnimplementedCipher
+		}
+		c.sendAlert(alertHandshakeFailure)
+		return c.cipherError
+	}
+	if hs.suite != nil && hs.suite != suite {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server changed cipher suite after a HelloRetryRequest")
+	}
+	hs.suite = suite
+	if hs.transcript == nil {
+		hs.transcript = suite.hash.New()
+	}
+	return nil
+}
+
+// processHelloRetryRequest answers a HelloRetryRequest with a second
+// ClientHello carrying a key_share for the group the server asked for and
+// its cookie, then reads the real ServerHello
+func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
+	c := hs.c
+	hrr := hs.serverHello
+	c.handshakeLog.HelloRetryRequest = hrr.MakeHelloRetryRequestLog()
+	c.handshakeLog.ServerHello = nil
+	c.handshakeLog.JA3S = nil
+
+	// The first ClientHello is replaced by its hash, RFC 8446 section 4.4.1
+	chHash := hs.transcript.Sum(nil)
+	hs.transcript.Reset()
+	hs.transcript.Write([]byte{typeMessageHash, 0, 0, uint8(len(chHash))})
+	hs.transcript.Write(chHash)
+	hs.transcript.Write(hrr.marshal())
+
+	if hrr.selectedGroup == 0 && len(hrr.cookie) == 0 {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server sent an unnecessary HelloRetryRequest")
+	}
+	hello := hs.hello
+	if group := hrr.selectedGroup; group != 0 {
+		offered := false
+		for _, curve := range hello.supportedCurves {
+			offered = offered || curve == group
+		}
+		if !offered || group == hs.key.share.group {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: server selected an unsupported group in a HelloRetryRequest")
+		}
+		key, err := generateEphemeralKey(c.config.rand(), group)
+		if err != nil {
+			c.sendAlert(alertHandshakeFailure)
+			return err
+		}
+		hs.key = key
+		hello.keyShares = []keyShare{key.share}
+	}
+	hello.cookie = hrr.cookie
+	hello.raw = nil
+	if c.config.ClientHelloProfile != nil {
+		c.shapeClientHello(hello, true)
+	}
+	hs.transcript.Write(hello.marshal())
+	c.writeRecord(recordTypeHandshake, hello.marshal())
+
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	serverHello, ok := msg.(*serverHelloMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(serverHello, msg)
+	}
+	if bytes.Equal(serverHello.random, helloRetryRequestRandom) {
+		c.sendAlert(alertUnexpectedMessage)
+		return errors.New("tls: server sent two HelloRetryRequest messages")
+	}
+	hs.serverHello = serverHello
+	c.handshakeLog.ServerHello = serverHello.MakeLog()
+	c.handshakeLog.JA3S = fingerprint.New(JA3S(c.handshakeLog.ServerHello))
+	return hs.checkServerHello()
+}
+
+func (hs *clientHandshakeStateTLS13) establishHandshakeKeys() error {
+	c := hs.c
+	share := hs.serverHello.serverShare
+	if share.group != hs.key.share.group {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server selected a group without a key_share")
+	}
+	shared := hs.key.sharedSecret(share.data)
+	if shared == nil {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: invalid server key share")
+	}
+
+	earlySecret := hkdfExtract(hs.suite.hash, nil, nil)
+	handshakeSecret := hkdfExtract(hs.suite.hash, shared, hs.suite.deriveSecret(earlySecret, derivedLabel, nil))
+	hs.clientSecret = hs.suite.deriveSecret(handshakeSecret, clientHandshakeTrafficLabel, hs.transcript)
+	hs.serverSecret = hs.suite.deriveSecret(handshakeSecret, serverHandshakeTrafficLabel, hs.transcript)
+	hs.masterSecret = hkdfExtract(hs.suite.hash, nil, hs.suite.deriveSecret(handshakeSecret, derivedLabel, nil))
+	c.in.setTrafficSecret(hs.suite, hs.serverSecret)
+	return nil
+}
+
+func (hs *clientHandshakeStateTLS13) readServerParameters() error {
+	c := hs.c
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	ee, ok := msg.(*encryptedExtensionsMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(ee, msg)
+	}
+	hs.transcript.Write(ee.marshal())
+	c.handshakeLog.EncryptedExts = ee.MakeLog()
+
+	if ee.alpnProtocol != "" {
+		if len(hs.hello.alpnProtocols) == 0 {
+			c.sendAlert(alertUnsupportedExtension)
+			return errors.New("tls: server advertised unrequested ALPN extension")
+		}
+		c.clientProtocol = ee.alpnProtocol
+		c.clientProtocolFallback = false
+	}
+	return nil
+}
+
+func (hs *clientHandshakeStateTLS13) readServerCertificate() error {
+	c := hs.c
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	if certReq, ok := msg.(*certificateRequestMsgTLS13); ok {
+		hs.transcript.Write(certReq.marshal())
+		hs.certRequested = true
+		hs.certContext = certReq.context
+		c.handshakeLog.CertificateRequest = certReq.MakeLog()
+		if msg, err = c.readHandshake(); err != nil {
+			return err
+		}
+	}
+
+	certMsg, ok := msg.(*certificateMsgTLS13)
+	if !ok || len(certMsg.certificates) == 0 {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(certMsg, msg)
+	}
+	hs.transcript.Write(certMsg.marshal())
+	if err := c.processServerCertificates(&certificateMsg{certificates: certMsg.certificates}); err != nil {
+		return err
+	}
+	if certMsg.ocspResponse != nil {
+		c.ocspResponse = certMsg.ocspResponse
+		c.handshakeLog.OCSPStaple = parseOCSPStaple(certMsg.ocspResponse, c.config.time())
+	}
+
+	serverCert := c.peerCertificates[0]
+	switch serverCert.PublicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, *x509.AugmentedECDSA:
+	default:
+		c.sendAlert(alertUnsupportedCertificate)
+		return fmt.Errorf("tls: server's certificate contains an unsupported type of public key: %T", serverCert.PublicKey)
+	}
+	if c.config.CertificatesOnly {
+		c.sendAlert(alertCloseNotify)
+		return ErrCertificatesOnly
+	}
+
+	if msg, err = c.readHandshake(); err != nil {
+		return err
+	}
+	certVerify, ok := msg.(*certificateVerifyMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(certVerify, msg)
+	}
+	signed := make([]byte, 64, 64+len(serverSignatureContext)+hs.suite.hash.Size())
+	for i := range signed {
+		signed[i] = 0x20
+	}
+	signed = append(signed, serverSignatureContext...)
+	signed = hs.transcript.Sum(signed)
+	if err := verifySignatureTLS13(serverCert.PublicKey, certVerify.signatureAndHash, signed, certVerify.signature); err != nil {
+		c.sendAlert(alertDecryptError)
+		return err
+	}
+	hs.transcript.Write(certVerify.marshal())
+	return nil
+}
+
+func (hs *clientHandshakeStateTLS13) readServerFinished() error {
+	c := hs.c
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	finished, ok := msg.(*finishedMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(finished, msg)
+	}
+	c.handshakeLog.ServerFinished = finished.MakeLog()
+	expected := hs.suite.finishedMAC(hs.serverSecret, hs.transcript)
+	if !hmac.Equal(expected, finished.verifyData) {
+		c.sendAlert(alertDecryptError)
+		return errors.New("tls: server's Finished message was incorrect")
+	}
+	hs.transcript.Write(finished.marshal())
+
+	hs.clientAppSecret = hs.suite.deriveSecret(hs.masterSecret, clientApplicationTrafficLabel, hs.transcript)
+	serverAppSecret := hs.suite.deriveSecret(hs.masterSecret, serverApplicationTrafficLabel, hs.transcript)
+	c.in.setTrafficSecret(hs.suite, serverAppSecret)
+	return nil
+}
+
+// sendClientFinished sends a compatibility ChangeCipherSpec, an empty
+// Certificate if one was requested, and Finished
+func (hs *clientHandshakeStateTLS13) sendClientFinished() error {
+	c := hs.c
+	if _, err := c.writeRecord(recordTypeChangeCipherSpec, []byte{1}); err != nil {
+		return err
+	}
+	c.out.setTrafficSecret(hs.suite, hs.clientSecret)
+
+	if hs.certRequested {
+		certMsg := &certificateMsgTLS13{context: hs.certContext}
+		hs.transcript.Write(certMsg.marshal())
+		if _, err := c.writeRecord(recordTypeHandshake, certMsg.marshal()); err != nil {
+			return err
+		}
+	}
+
+	finished := &finishedMsg{verifyData: hs.suite.finishedMAC(hs.clientSecret, hs.transcript)}
+	hs.transcript.Write(finished.marshal())
+	c.handshakeLog.ClientFinished = finished.MakeLog()
+	if _, err := c.writeRecord(recordTypeHandshake, finished.marshal()); err != nil {
+		return err
+	}
+	c.out.setTrafficSecret(hs.suite, hs.clientAppSecret)
+	return nil
+}
+
+// tls13SignatureHash maps the digest byte of a signature scheme onto a hash
+func tls13SignatureHash(id uint8) (crypto.Hash, bool) {
+	switch id {
+	case hashSHA256:
+		return crypto.SHA256, true
+	case hashSHA384:
+		return crypto.SHA384, true
+	case hashSHA512:
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+// verifySignatureTLS13 checks a CertificateVerify signature. TLS 1.3 allows
+// only RSASSA-PSS and ECDSA.
+func verifySignatureTLS13(pub interface{}, scheme signatureAndHash, signed, sig []byte) error {
+	var digestID uint8
+	switch {
+	case scheme.hash == hashIntrinsic:
+		digestID = scheme.signature
+	case scheme.signature == signatureECDSA:
+		digestID = scheme.hash
+	default:
+		return fmt.Errorf("tls: unsupported CertificateVerify signature scheme %#02x%02x", scheme.hash, scheme.signature)
+	}
+	hashFunc, ok := tls13SignatureHash(digestID)
+	if !ok {
+		return fmt.Errorf("tls: unsupported CertificateVerify signature scheme %#02x%02x", scheme.hash, scheme.signature)
+	}
+	h := hashFunc.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	if scheme.hash == hashIntrinsic {
+		pubKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("tls: RSASSA-PSS CertificateVerify requires an RSA server public key")
+		}
+		return rsa.VerifyPSS(pubKey, hashFunc, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	}
+	var pubKey *ecdsa.PublicKey
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		pubKey = key
+	case *x509.AugmentedECDSA:
+		pubKey = key.Pub
+	default:
+		return errors.New("tls: ECDSA CertificateVerify requires an ECDSA server public key")
+	}
+	ecdsaSig := new(ecdsaSignature)
+	if _, err := asn1.Unmarshal(sig, ecdsaSig); err != nil {
+		return err
+	}
+	if ecdsaSig.R.Sign() <= 0 || ecdsaSig.S.Sign() <= 0 {
+		return errors.New("ECDSA signature contained zero or negative values")
+	}
+	if !ecdsa.Verify(pubKey, digest, ecdsaSig.R, ecdsaSig.S) {
+		return errors.New("ECDSA verification failure")
+	}
+	return nil
+}
+
+// handlePostHandshakeTLS13 processes handshake records arriving after a
+// TLS 1.3 handshake. Session tickets are dropped since resumption is never
+// attempted; a KeyUpdate rekeys the read side and, if asked, the write side.
+// c.in.Mutex <= L.
+func (c *Conn) handlePostHandshakeTLS13(data []byte) error {
+	c.hand.Write(data)
+	for c.hand.Len() >= 4 {
+		header := c.hand.Bytes()
+		n := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		if n > maxHandshake {
+			return c.sendAlert(alertInternalError)
+		}
+		if c.hand.Len() < 4+n {
+			return nil
+		}
+		msg := c.hand.Next(4 + n)
+		switch msg[0] {
+		case typeNewSessionTicket:
+		case typeKeyUpdate:
+			if n != 1 || msg[4] > 1 {
+				return c.sendAlert(alertDecodeError)
+			}
+			suite := c.in.trafficSuite
+			c.in.setTrafficSecret(suite, suite.expandLabel(c.in.trafficSecret, trafficUpdateLabel, nil, suite.hash.Size()))
+			if msg[4] == 1 {
+				c.out.Lock()
+				_, err := c.writeRecord(recordTypeHandshake, []byte{typeKeyUpdate, 0, 0, 1, 0})
+				suite = c.out.trafficSuite
+				c.out.setTrafficSecret(suite, suite.expandLabel(c.out.trafficSecret, trafficUpdateLabel, nil, suite.hash.Size()))
+				c.out.Unlock()
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			return c.sendAlert(alertUnexpectedMessage)
+		}
+	}
+	return nil
+}
+
+// encryptedExtensionsMsg is the first message of a TLS 1.3 server's
+// encrypted flight. It is only ever received, so marshal returns the raw
+// message.
+type encryptedExtensionsMsg struct {
+	raw             []byte
+	alpnProtocol    string
+	serverNameAck   bool
+	supportedGroups []CurveID
+	extensions      []uint16
+}
+
+func (m *encryptedExtensionsMsg) marshal() []byte {
+	return m.raw
+}
+
+func (m *encryptedExtensionsMsg) unmarshal(data []byte) bool {
+	m.raw = data
+	if len(data) < 6 {
+		return false
+	}
+	extensionsLength := int(data[4])<<8 | int(data[5])
+	data = data[6:]
+	if len(data) != extensionsLength {
+		return false
+	}
+	for len(data) != 0 {
+		if len(data) < 4 {
+			return false
+		}
+		extension := uint16(data[0])<<8 | uint16(data[1])
+		length := int(data[2])<<8 | int(data[3])
+		data = data[4:]
+		if len(data) < length {
+			return false
+		}
+		d := data[:length]
+		data = data[length:]
+		m.extensions = append(m.extensions, extension)
+
+		switch extension {
+		case extensionServerName:
+			m.serverNameAck = true
+		case extensionALPN:
+			if len(d) < 3 || int(d[0])<<8|int(d[1]) != len(d)-2 || int(d[2]) != len(d)-3 || len(d) == 3 {
+				return false
+			}
+			m.alpnProtocol = string(d[3:])
+		case extensionSupportedCurves:
+			if len(d) < 2 || int(d[0])<<8|int(d[1]) != len(d)-2 || len(d)%2 != 0 {
+				return false
+			}
+			for d = d[2:]; len(d) > 0; d = d[2:] {
+				m.supportedGroups = append(m.supportedGroups, CurveID(d[0])<<8|CurveID(d[1]))
+			}
+		}
+	}
+	return true
+}
+
+// certificateMsgTLS13 is the TLS 1.3 Certificate: a request context and
+// per-certificate extensions, of which the leaf's OCSP staple is kept
+type certificateMsgTLS13 struct {
+	raw          []byte
+	context      []byte
+	certificates [][]byte
+	ocspResponse []byte
+}
+
+// marshal encodes the message without certificate extensions
+func (m *certificateMsgTLS13) marshal() []byte {
+	if m.raw != nil {
+		return m.raw
+	}
+	listLength := 0
+	for _, cert := range m.certificates {
+		listLength += 3 + len(cert) + 2
+	}
+	length := 1 + len(m.context) + 3 + listLength
+	x := make([]byte, 4, 4+length)
+	x[0] = typeCertificate
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	x = append(x, uint8(len(m.context)))
+	x = append(x, m.context...)
+	x = append(x, uint8(listLength>>16), uint8(listLength>>8), uint8(listLength))
+	for _, cert := range m.certificates {
+		x = append(x, uint8(len(cert)>>16), uint8(len(cert)>>8), uint8(len(cert)))
+		x = append(x, cert...)
+		x = append(x, 0, 0)
+	}
+	m.raw = x
+	return x
+}
+
+func (m *certificateMsgTLS13) unmarshal(data []byte) bool {
+	m.raw = data
+	if len(data) < 5 {
+		return false
+	}
+	contextLen := int(data[4])
+	data = data[5:]
+	if len(data) < contextLen+3 {
+		return false
+	}
+	m.context = data[:contextLen]
+	data = data[contextLen:]
+	listLen := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+	data = data[3:]
+	if len(data) != listLen {
+		return false
+	}
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return false
+		}
+		certLen := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+		data = data[3:]
+		if certLen == 0 || len(data) < certLen+2 {
+			return false
+		}
+		m.certificates = append(m.certificates, data[:certLen])
+		data = data[certLen:]
+		extsLen := int(data[0])<<8 | int(data[1])
+		data = data[2:]
+		if len(data) < extsLen {
+			return false
+		}
+		exts := data[:extsLen]
+		data = data[extsLen:]
+		if len(m.certificates) > 1 {
+			continue
+		}
+		for len(exts) > 0 {
+			if len(exts) < 4 {
+				return false
+			}
+			extension := uint16(exts[0])<<8 | uint16(exts[1])
+			length := int(exts[2])<<8 | int(exts[3])
+			exts = exts[4:]
+			if len(exts) < length {
+				return false
+			}
+			d := exts[:length]
+			exts = exts[length:]
+			if extension == extensionStatusRequest && len(d) >= 4 && d[0] == statusTypeOCSP {
+				if int(d[1])<<16|int(d[2])<<8|int(d[3]) != len(d)-4 {
+					return false
+				}
+				m.ocspResponse = d[4:]
+			}
+		}
+	}
+	return true
+}
+
+// certificateRequestMsgTLS13 is the TLS 1.3 CertificateRequest. Only the
+// context and the certificate_authorities extension are kept.
+type certificateRequestMsgTLS13 struct {
+	raw                    []byte
+	context                []byte
+	certificateAuthorities [][]byte
+}
+
+func (m *certificateRequestMsgTLS13) marshal() []byte {
+	return m.raw
+}
+
+func (m *certificateRequestMsgTLS13) unmarshal(data []byte) bool {
+	m.raw = data
+	if len(data) < 5 {
+		return false
+	}
+	contextLen := int(data[4])
+	data = data[5:]
+	if len(data) < contextLen+2 {
+		return false
+	}
+	m.context = data[:contextLen]
+	data = data[contextLen:]
+	extensionsLength := int(data[0])<<8 | int(data[1])
+	data = data[2:]
+	if len(data) != extensionsLength {
+		return false
+	}
+	for len(data) != 0 {
+		if len(data) < 4 {
+			return false
+		}
+		extension := uint16(data[0])<<8 | uint16(data[1])
+		length := int(data[2])<<8 | int(data[3])
+		data = data[4:]
+		if len(data) < length {
+			return false
+		}
+		d := data[:length]
+		data = data[length:]
+		if extension != extensionCertificateAuthorities {
+			continue
+		}
+		if len(d) < 2 || int(d[0])<<8|int(d[1]) != len(d)-2 {
+			return false
+		}
+		for d = d[2:]; len(d) > 0; {
+			if len(d) < 2 {
+				return false
+			}
+			caLen := int(d[0])<<8 | int(d[1])
+			if len(d) < 2+caLen {
+				return false
+			}
+			m.certificateAuthorities = append(m.certificateAuthorities, d[2:2+caLen])
+			d = d[2+caLen:]
+		}
+	}
+	return true
+}
+
+// MakeLog records the request like a TLS 1.2 one, without certificate types
+func (m *certificateRequestMsgTLS13) MakeLog() *CertificateRequest {
+	return (&certificateRequestMsg{certificateAuthorities: m.certificateAuthorities}).MakeLog()
+}
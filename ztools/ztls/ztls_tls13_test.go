@@ -0,0 +1,182 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ztls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	stdtls "crypto/tls"
+	stdx509 "crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"gopkg.in/eniac/zgrab.v0/ztools/keys"
+)
+
+// stdTLSPair connects a client using config to a crypto/tls server that
+// echoes four bytes
+func stdTLSPair(t *testing.T, config *Config, serverConfig *stdtls.Config) *Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer l.Close()
+		s, err := l.Accept()
+		if err != nil {
+			return
+		}
+		server := stdtls.Server(s, serverConfig)
+		defer server.Close()
+		server.SetDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(server, buf); err == nil {
+			server.Write(buf)
+		}
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := Client(c, config)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client
+}
+
+func testRSAStdCertificate() stdtls.Certificate {
+	return stdtls.Certificate{
+		Certificate: [][]byte{testRSACertificate},
+		PrivateKey:  testRSAPrivateKey,
+	}
+}
+
+func testECDSAStdCertificate(t *testing.T) stdtls.Certificate {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &stdx509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"example.com"},
+	}
+	der, err := stdx509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stdtls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+}
+
+func pingTLS(t *testing.T, client *Conn) {
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Read %q: %v", buf, err)
+	}
+}
+
+func TestTLS13Handshake(t *testing.T) {
+	for name, cert := range map[string]stdtls.Certificate{
+		"RSA-PSS": testRSAStdCertificate(),
+		"ECDSA":   testECDSAStdCertificate(t),
+	} {
+		client := stdTLSPair(t, &Config{
+			InsecureSkipVerify: true,
+			MaxVersion:         VersionTLS13,
+			NextProtos:         []string{"h2"},
+		}, &stdtls.Config{
+			Certificates: []stdtls.Certificate{cert},
+			MinVersion:   stdtls.VersionTLS13,
+			NextProtos:   []string{"h2"},
+		})
+		if err := client.Handshake(); err != nil {
+			t.Fatalf("%s: Handshake: %s", name, err)
+		}
+		hl := client.GetHandshakeLog()
+		if sv := hl.ServerHello.SupportedVersion; sv == nil || *sv != VersionTLS13 {
+			t.Errorf("%s: server_hello.supported_version = %v", name, sv)
+		}
+		if hl.ServerHello.KeyShare == nil || hl.ServerHello.KeyShare.Group != keys.TLSCurveID(CurveP256) {
+			t.Errorf("%s: server_hello.key_share = %v", name, hl.ServerHello.KeyShare)
+		}
+		if len(hl.ClientHello.SupportedVersions) == 0 || hl.ClientHello.SupportedVersions[0] != VersionTLS13 {
+			t.Errorf("%s: client_hello.supported_versions = %v", name, hl.ClientHello.SupportedVersions)
+		}
+		if hl.EncryptedExts == nil || hl.EncryptedExts.ALPNProtocol != "h2" {
+			t.Errorf("%s: encrypted_extensions = %+v", name, hl.EncryptedExts)
+		}
+		if hl.ServerCertificates == nil || hl.ServerFinished == nil || hl.ClientFinished == nil {
+			t.Errorf("%s: certificates or Finished messages missing from the log", name)
+		}
+		if hl.HelloRetryRequest != nil {
+			t.Errorf("%s: unexpected HelloRetryRequest", name)
+		}
+		if state := client.ConnectionState(); state.Version != VersionTLS13 || state.CipherSuite != TLS_AES_128_GCM_SHA256 {
+			t.Errorf("%s: negotiated version %x, suite %x", name, state.Version, state.CipherSuite)
+		}
+		pingTLS(t, client)
+		client.Close()
+	}
+}
+
+func TestTLS13HelloRetryRequest(t *testing.T) {
+	client := stdTLSPair(t, &Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         VersionTLS13,
+	}, &stdtls.Config{
+		Certificates:     []stdtls.Certificate{testECDSAStdCertificate(t)},
+		MinVersion:       stdtls.VersionTLS13,
+		CurvePreferences: []stdtls.CurveID{stdtls.CurveP384},
+	})
+	defer client.Close()
+
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("Handshake: %s", err)
+	}
+	hl := client.GetHandshakeLog()
+	hrr := hl.HelloRetryRequest
+	if hrr == nil || hrr.SelectedGroup == nil || *hrr.SelectedGroup != keys.TLSCurveID(CurveP384) {
+		t.Fatalf("hello_retry_request = %+v", hrr)
+	}
+	if hl.ServerHello == nil || hl.ServerHello.KeyShare == nil || hl.ServerHello.KeyShare.Group != keys.TLSCurveID(CurveP384) {
+		t.Errorf("server_hello after the retry = %+v", hl.ServerHello)
+	}
+	pingTLS(t, client)
+}
+
+// An RSA server at TLS 1.2 picks RSASSA-PSS for its ServerKeyExchange once
+// the client offers the TLS 1.3 signature schemes
+func TestTLS13FallbackToTLS12(t *testing.T) {
+	client := stdTLSPair(t, &Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         VersionTLS13,
+	}, &stdtls.Config{
+		Certificates: []stdtls.Certificate{testRSAStdCertificate()},
+		MaxVersion:   stdtls.VersionTLS12,
+		CipherSuites: []uint16{stdtls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	})
+	defer client.Close()
+
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("Handshake: %s", err)
+	}
+	hl := client.GetHandshakeLog()
+	if hl.ServerHello.SupportedVersion != nil || client.ConnectionState().Version != VersionTLS12 {
+		t.Errorf("negotiated version %x, supported_version %v", client.ConnectionState().Version, hl.ServerHello.SupportedVersion)
+	}
+	if hl.ServerKeyExchange == nil || hl.ServerKeyExchange.Signature == nil || !hl.ServerKeyExchange.Signature.Valid {
+		t.Errorf("server_key_exchange signature = %+v", hl.ServerKeyExchange)
+	}
+	pingTLS(t, client)
+}